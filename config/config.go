@@ -0,0 +1,776 @@
+// Package config holds tg's user-configurable settings, and the types used to
+// parse them from flags and config files.
+//
+// Settings are kept in a YAML file in tg's state directory (see FileName),
+// which 'tg config set' writes and users may also edit by hand. Older
+// versions of tg kept them in JSON (see legacyFileName), which is still read
+// until the config is next saved.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/redact"
+	"github.com/msteffen/toggl-watcher/schedule"
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// FileName is the name of the config file in tg's state directory
+const FileName = "config.yaml"
+
+// legacyFileName is the name of the JSON config file that older versions of
+// tg wrote. It's read if there's no FileName, and replaced by FileName when
+// the config is saved
+const legacyFileName = "config.json"
+
+// Config holds tg's settings that are read from the config file
+type Config struct {
+	// IdleTimeout is how long tg waits after the most recent write before
+	// stopping the open time entry
+	IdleTimeout Duration `json:"idle_timeout"`
+
+	// InputIdleTimeout, if set, is how long the user may go without keyboard
+	// or mouse input before the open time entry is stopped (at their last
+	// input), and writes are ignored until there's input again, so that
+	// editors' autosaves don't keep an entry open while the user is away. It
+	// requires X11 with xprintidle, or a desktop that implements
+	// org.freedesktop.ScreenSaver over D-Bus
+	InputIdleTimeout Duration `json:"input_idle_timeout"`
+
+	// StopGrace is added to the time of the most recent write to get the time
+	// at which an idle time entry is stopped, to account for work (e.g.
+	// reading or thinking) after the last write. If "off", the entry stops at
+	// the last write
+	StopGrace Duration `json:"stop_grace"`
+
+	// BridgeGap is the longest break (counted from where an idle entry would
+	// stop; see StopGrace and InputIdleTimeout) after which work in the same
+	// project continues the open time entry, rather than starting a new one.
+	// Until it passes, an idle entry is kept open; after it, the entry is
+	// stopped where the work ended. If "off", idle entries are stopped at once
+	BridgeGap Duration `json:"bridge_gap"`
+
+	// MinSwitchDuration is how long a time entry must have been open before
+	// work on a different project stops it and starts a new one. Until then,
+	// the open entry is moved to the new project instead. If "off", every
+	// switch starts a new entry
+	MinSwitchDuration Duration `json:"min_switch_duration"`
+
+	// MinActivity is the activity in a project that's required before a time
+	// entry is started for it, e.g. "3/5m" (three ticks within five minutes)
+	// or "2m" (two minutes of sustained activity), so that a stray write
+	// doesn't start a short-lived entry. The entry is backdated to the start
+	// of that activity. Root watches may override it. If unset, the first
+	// write starts an entry
+	MinActivity string `json:"min_activity,omitempty"`
+
+	// DebounceWindow is the period over which writes are consolidated into a
+	// single tick (all writes to a project within the window are one tick)
+	DebounceWindow Duration `json:"debounce_window"`
+
+	// IgnorePatterns are shell patterns (see filepath.Match). Writes to files
+	// whose path (relative to the watched directory) or any path component
+	// matches one of these patterns are ignored
+	IgnorePatterns []string `json:"ignore_patterns,omitempty"`
+
+	// WatchEvents are the classes of filesystem event that count as writes
+	// (see status.EventClasses), unless a root watch sets its own. If unset,
+	// every class but "attrib" does
+	WatchEvents []string `json:"watch_events,omitempty"`
+
+	// Workspace is the name or ID of the Toggl (or Clockify, if that's the
+	// backend) workspace in which projects are created. If unset, the user's
+	// default workspace is used
+	Workspace string `json:"workspace,omitempty"`
+
+	// WindowTitles attribute time to a project while the focused window's
+	// title matches a pattern, even if no files are written (e.g. while
+	// reading code). Writes take precedence over window titles
+	WindowTitles []WindowTitle `json:"window_titles,omitempty"`
+
+	// WindowPollInterval is how often the focused window's title is read, if
+	// any WindowTitles are configured
+	WindowPollInterval Duration `json:"window_poll_interval"`
+
+	// Notifications are the events for which the daemon shows a desktop
+	// notification: NotifyStart, NotifyStop, and NotifySwitch
+	Notifications []string `json:"notifications,omitempty"`
+
+	// RequireApproval, if true, keeps new time entries in tg until they're
+	// approved with 'tg approve', rather than creating them in Toggl at once
+	RequireApproval bool `json:"require_approval,omitempty"`
+
+	// Redactions rewrite paths (and any other matching text) in time entry
+	// descriptions, logs, and exported traces, after any redactions set on
+	// the root watch that the text came from
+	Redactions []redact.Spec `json:"redactions,omitempty"`
+
+	// WatchBackend is the API through which the daemon observes writes:
+	// "inotify" (the default on Linux), "fanotify", which watches whole
+	// filesystems and requires CAP_SYS_ADMIN, or "poll" (the default, and only
+	// option, elsewhere), which scans for modified files. It's applied when the
+	// daemon starts
+	WatchBackend string `json:"watch_backend,omitempty"`
+
+	// DryRun, if true, makes the daemon log the requests that it would send to
+	// Toggl to create or change projects and time entries, instead of sending
+	// them (see 'tg resume --dry-run'). It's applied when the daemon starts
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// DescriptionTemplate is a text/template for the descriptions of time
+	// entries started by writes under root watches that don't set their own
+	// (e.g. "coding on {{.GitBranch}} in {{.Project}}"; see
+	// status.DescriptionData for its fields). If unset, entries have no
+	// description
+	DescriptionTemplate string `json:"description_template,omitempty"`
+
+	// GitBranches, if true, tags time entries started by writes in git
+	// repositories with the checked-out branch, and starts a new entry when
+	// work moves to another branch (even in the same project), so that time
+	// can be split by branch
+	GitBranches bool `json:"git_branches,omitempty"`
+
+	// MergeGap and RoundEntries consolidate time entries awaiting approval
+	// (see RequireApproval) before they're created in Toggl: adjacent entries
+	// in the same project that are less than MergeGap apart are merged, and
+	// start and stop times are rounded to the nearest multiple of
+	// RoundEntries. Both are off by default
+	MergeGap     Duration `json:"merge_gap"`
+	RoundEntries Duration `json:"round_entries"`
+
+	// Backend is where the daemon records time entries: BackendToggl (the
+	// default), BackendClockify, BackendHarvest, or BackendLocal, which
+	// records them only in tg's journal, so that tg can be used without a
+	// time tracking account. It's applied when the daemon starts
+	Backend string `json:"backend,omitempty"`
+
+	// HarvestAccountID is the ID of the Harvest account in which time entries
+	// are recorded, if the backend is BackendHarvest
+	HarvestAccountID string `json:"harvest_account_id,omitempty"`
+
+	// APITimeout is how long a request to Toggl may take before it's
+	// abandoned, so that an unresponsive server can't stall tracking.
+	// Abandoned updates are queued and retried, as when Toggl is unreachable.
+	// If "off", requests are never abandoned
+	APITimeout Duration `json:"api_timeout"`
+
+	// Pomodoro, if set, is the focus period of pomodoro mode: once work has
+	// continued this long without a break of at least PomodoroBreak, the
+	// daemon notifies the user to take one (and again every PomodoroBreak
+	// until they do). Time entries started during a focus period are tagged
+	// with PomodoroTag, if it's set. If "off", pomodoro mode is disabled
+	Pomodoro      Duration `json:"pomodoro"`
+	PomodoroBreak Duration `json:"pomodoro_break"`
+	PomodoroTag   string   `json:"pomodoro_tag,omitempty"`
+
+	// WorkHours, if set, are the user's working hours (e.g. "Mon-Fri
+	// 09:00-18:00"; see schedule.ParsePeriod), and AfterHours is what the
+	// daemon does with writes outside them (e.g. from builds that run at
+	// night): AfterHoursIgnore (the default), AfterHoursTag, which tracks them
+	// in entries tagged with AfterHoursTag, or AfterHoursNotify, which ignores
+	// them but asks the user whether to track them
+	WorkHours     []string `json:"work_hours,omitempty"`
+	AfterHours    string   `json:"after_hours,omitempty"`
+	AfterHoursTag string   `json:"after_hours_tag,omitempty"`
+
+	// Profiles are named Toggl accounts other than the default one (e.g. for
+	// consultants who track time in each client's account). Each profile's
+	// API token is stored with 'tg login --profile <name>'. Commands run with
+	// --profile use its account, and root watches assigned to a profile
+	// report to it. Profiles are managed with 'tg profile'
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+// Profile is a Toggl account other than the default one (see Config.Profiles)
+type Profile struct {
+	// Workspace is the name or ID of the workspace in the account in which
+	// projects are created. If unset, the account's default workspace is used
+	Workspace string `json:"workspace,omitempty"`
+	// Tags are added to every time entry created in the account
+	Tags []string `json:"tags,omitempty"`
+}
+
+// profileName matches valid profile names, which are also used in the names
+// of credentials files and environment variables (see
+// credentials.ProfileScope)
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateProfileName returns an error if 'name' can't be the name of a
+// profile
+func ValidateProfileName(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (must be lower-case letters, "+
+			"digits, '-', and '_')", name)
+	}
+	return nil
+}
+
+// Profile returns the profile named 'name'
+func (c *Config) Profile(name string) (Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile named %q (add it with 'tg "+
+			"profile add %s')", name, name)
+	}
+	return p, nil
+}
+
+// The time tracking backends that may be set in Config.Backend
+const (
+	// BackendToggl sends time entries to Toggl
+	BackendToggl = "toggl"
+	// BackendClockify sends time entries to Clockify
+	BackendClockify = "clockify"
+	// BackendHarvest sends time entries to Harvest (see HarvestAccountID)
+	BackendHarvest = "harvest"
+	// BackendLocal only records time entries locally
+	BackendLocal = "local"
+)
+
+// The events that may be listed in Config.Notifications
+const (
+	// NotifyStart is when tracking starts, with no time entry open before
+	NotifyStart = "start"
+	// NotifyStop is when the open time entry is stopped because the user went
+	// idle
+	NotifyStop = "stop"
+	// NotifySwitch is when work moves from one project to another
+	NotifySwitch = "switch"
+)
+
+// The values that may be set in Config.AfterHours
+const (
+	// AfterHoursIgnore ignores writes outside working hours
+	AfterHoursIgnore = "ignore"
+	// AfterHoursTag tracks writes outside working hours in time entries
+	// tagged with Config.AfterHoursTag
+	AfterHoursTag = "tag"
+	// AfterHoursNotify ignores writes outside working hours, but notifies the
+	// user, who may choose to track them with 'tg resume-tracking
+	// --after-hours'
+	AfterHoursNotify = "notify"
+)
+
+// DefaultAfterHoursTag is the tag of time entries for work outside working
+// hours, if Config.AfterHoursTag is unset
+const DefaultAfterHoursTag = "after hours"
+
+// WindowTitle attributes time in windows whose title matches Pattern (a
+// regular expression) to Project
+type WindowTitle struct {
+	Pattern string `json:"pattern"`
+	Project string `json:"project"`
+}
+
+// Default returns the Config used when no config file exists
+func Default() Config {
+	return Config{
+		IdleTimeout:        Duration(24 * time.Minute),
+		DebounceWindow:     Duration(3 * time.Second),
+		WindowPollInterval: Duration(30 * time.Second),
+		APITimeout:         Duration(30 * time.Second),
+		PomodoroBreak:      Duration(5 * time.Minute),
+	}
+}
+
+// CandidateFileName is the name of the candidate config file in tg's state
+// directory. While it exists, the daemon evaluates it in shadow mode alongside
+// the active config, recording where their decisions differ (see 'tg canary'),
+// until it's promoted or discarded
+const CandidateFileName = "config.candidate.yaml"
+
+// legacyCandidateFileName is the name of the candidate config file that older
+// versions of tg wrote (see legacyFileName)
+const legacyCandidateFileName = "config.candidate.json"
+
+// Read reads the config file in 'tgStateDir'. Settings that are missing from
+// the file (or all settings, if there is no file) have their default values
+func Read(tgStateDir string) (Config, error) {
+	c, _, err := readFile(tgStateDir, FileName, legacyFileName)
+	return c, err
+}
+
+// ReadCandidate reads the candidate config file in 'tgStateDir'. 'ok' is false
+// if there is no candidate config
+func ReadCandidate(tgStateDir string) (c Config, ok bool, err error) {
+	return readFile(tgStateDir, CandidateFileName, legacyCandidateFileName)
+}
+
+// readFile reads the config file 'name' in 'tgStateDir' (or, if it doesn't
+// exist, the JSON file 'legacyName'), as described by Read. 'ok' is false if
+// neither file exists
+func readFile(tgStateDir, name, legacyName string) (c Config, ok bool, err error) {
+	c = Default()
+	configPath := path.Join(tgStateDir, name)
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		configPath = path.Join(tgStateDir, legacyName)
+		data, err = ioutil.ReadFile(configPath)
+	}
+	if os.IsNotExist(err) {
+		return c, false, nil
+	} else if err != nil {
+		return c, false, fmt.Errorf("could not read config file: %v", err)
+	}
+	// JSON is YAML, so legacy files are parsed the same way
+	if err := unmarshalYAML(data, &c); err != nil {
+		return c, false, fmt.Errorf("could not parse config file at %q: %v", configPath, err)
+	}
+	return c, true, nil
+}
+
+// Save writes 'c' to the config file in 'tgStateDir'
+func (c Config) Save(tgStateDir string) error {
+	return c.writeFile(tgStateDir, FileName, legacyFileName)
+}
+
+// SaveCandidate writes 'c' to the candidate config file in 'tgStateDir'
+func (c Config) SaveCandidate(tgStateDir string) error {
+	return c.writeFile(tgStateDir, CandidateFileName, legacyCandidateFileName)
+}
+
+// writeFile writes 'c' to the config file 'name' in 'tgStateDir', and removes
+// the JSON file 'legacyName' that it replaces, if any
+func (c Config) writeFile(tgStateDir, name, legacyName string) error {
+	data, err := marshalYAML(c)
+	if err != nil {
+		return err
+	}
+	if err := persist.WriteFile(path.Join(tgStateDir, name), data, 0644); err != nil {
+		return fmt.Errorf("could not write config file: %v", err)
+	}
+	return removeFile(path.Join(tgStateDir, legacyName))
+}
+
+// removeFile removes the config file at 'configPath', if it exists
+func removeFile(configPath string) error {
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove %q: %v", configPath, err)
+	}
+	return nil
+}
+
+// PromoteCandidate replaces the config file in 'tgStateDir' with the candidate
+// config file, ending shadow evaluation
+func PromoteCandidate(tgStateDir string) error {
+	c, ok, err := ReadCandidate(tgStateDir)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("there is no candidate config to promote")
+	}
+	if err := c.Save(tgStateDir); err != nil {
+		return err
+	}
+	return DiscardCandidate(tgStateDir)
+}
+
+// DiscardCandidate removes the candidate config file in 'tgStateDir', if any
+func DiscardCandidate(tgStateDir string) error {
+	if err := removeFile(path.Join(tgStateDir, CandidateFileName)); err != nil {
+		return err
+	}
+	return removeFile(path.Join(tgStateDir, legacyCandidateFileName))
+}
+
+// Keys returns the names of all settings accepted by Get and Set, in order
+func Keys() []string {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setting describes how to read and write a single setting in Config
+type setting struct {
+	get func(c *Config) string
+	set func(c *Config, value string) error
+}
+
+// settings maps each setting's name (the same as its name in the config file)
+// to its accessors
+var settings = map[string]setting{
+	"idle_timeout": {
+		get: func(c *Config) string { return c.IdleTimeout.String() },
+		set: func(c *Config, value string) error { return c.IdleTimeout.Set(value) },
+	},
+	"input_idle_timeout": {
+		get: func(c *Config) string { return c.InputIdleTimeout.String() },
+		set: func(c *Config, value string) error { return c.InputIdleTimeout.Set(value) },
+	},
+	"round_entries": {
+		get: func(c *Config) string { return c.RoundEntries.String() },
+		set: func(c *Config, value string) error { return c.RoundEntries.Set(value) },
+	},
+	"stop_grace": {
+		get: func(c *Config) string { return c.StopGrace.String() },
+		set: func(c *Config, value string) error { return c.StopGrace.Set(value) },
+	},
+	"bridge_gap": {
+		get: func(c *Config) string { return c.BridgeGap.String() },
+		set: func(c *Config, value string) error { return c.BridgeGap.Set(value) },
+	},
+	"merge_gap": {
+		get: func(c *Config) string { return c.MergeGap.String() },
+		set: func(c *Config, value string) error { return c.MergeGap.Set(value) },
+	},
+	"api_timeout": {
+		get: func(c *Config) string { return c.APITimeout.String() },
+		set: func(c *Config, value string) error { return c.APITimeout.Set(value) },
+	},
+	"min_activity": {
+		get: func(c *Config) string {
+			t, _ := status.ParseActivityThreshold(c.MinActivity)
+			return t.String()
+		},
+		set: func(c *Config, value string) error {
+			t, err := status.ParseActivityThreshold(value)
+			if err != nil {
+				return err
+			}
+			c.MinActivity = ""
+			if !t.IsZero() {
+				c.MinActivity = t.String()
+			}
+			return nil
+		},
+	},
+	"min_switch_duration": {
+		get: func(c *Config) string { return c.MinSwitchDuration.String() },
+		set: func(c *Config, value string) error { return c.MinSwitchDuration.Set(value) },
+	},
+	"backend": {
+		get: func(c *Config) string {
+			if c.Backend == "" {
+				return BackendToggl
+			}
+			return c.Backend
+		},
+		set: func(c *Config, value string) error {
+			switch value {
+			case BackendToggl:
+				c.Backend = "" // the default
+			case BackendClockify, BackendHarvest, BackendLocal:
+				c.Backend = value
+			default:
+				return fmt.Errorf("invalid value %q for backend (expected %s, %s, "+
+					"%s, or %s)", value, BackendToggl, BackendClockify, BackendHarvest,
+					BackendLocal)
+			}
+			return nil
+		},
+	},
+	"debounce_window": {
+		get: func(c *Config) string { return c.DebounceWindow.String() },
+		set: func(c *Config, value string) error { return c.DebounceWindow.Set(value) },
+	},
+	"git_branches": {
+		get: func(c *Config) string { return strconv.FormatBool(c.GitBranches) },
+		set: func(c *Config, value string) error {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for git_branches (expected true "+
+					"or false)", value)
+			}
+			c.GitBranches = enabled
+			return nil
+		},
+	},
+	"harvest_account_id": {
+		get: func(c *Config) string { return c.HarvestAccountID },
+		set: func(c *Config, value string) error {
+			c.HarvestAccountID = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	"pomodoro": {
+		get: func(c *Config) string { return c.Pomodoro.String() },
+		set: func(c *Config, value string) error { return c.Pomodoro.Set(value) },
+	},
+	"pomodoro_break": {
+		get: func(c *Config) string { return c.PomodoroBreak.String() },
+		set: func(c *Config, value string) error {
+			var d Duration
+			if err := d.Set(value); err != nil {
+				return err
+			} else if d <= 0 {
+				return fmt.Errorf("pomodoro_break must be positive")
+			}
+			c.PomodoroBreak = d
+			return nil
+		},
+	},
+	"pomodoro_tag": {
+		get: func(c *Config) string { return c.PomodoroTag },
+		set: func(c *Config, value string) error {
+			c.PomodoroTag = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	"work_hours": {
+		get: func(c *Config) string { return strings.Join(c.WorkHours, ",") },
+		set: func(c *Config, value string) error {
+			c.WorkHours = nil
+			for _, spec := range strings.Split(value, ",") {
+				if spec = strings.Join(strings.Fields(spec), " "); spec == "" {
+					continue
+				}
+				if _, err := schedule.ParsePeriod(spec); err != nil {
+					return err
+				}
+				c.WorkHours = append(c.WorkHours, spec)
+			}
+			return nil
+		},
+	},
+	"after_hours": {
+		get: func(c *Config) string {
+			if c.AfterHours == "" {
+				return AfterHoursIgnore
+			}
+			return c.AfterHours
+		},
+		set: func(c *Config, value string) error {
+			switch value {
+			case AfterHoursIgnore:
+				c.AfterHours = "" // the default
+			case AfterHoursTag, AfterHoursNotify:
+				c.AfterHours = value
+			default:
+				return fmt.Errorf("invalid value %q for after_hours (expected %s, "+
+					"%s, or %s)", value, AfterHoursIgnore, AfterHoursTag, AfterHoursNotify)
+			}
+			return nil
+		},
+	},
+	"after_hours_tag": {
+		get: func(c *Config) string {
+			if c.AfterHoursTag == "" {
+				return DefaultAfterHoursTag
+			}
+			return c.AfterHoursTag
+		},
+		set: func(c *Config, value string) error {
+			c.AfterHoursTag = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	"ignore_patterns": {
+		get: func(c *Config) string { return strings.Join(c.IgnorePatterns, ",") },
+		set: func(c *Config, value string) error {
+			c.IgnorePatterns = nil
+			for _, pattern := range strings.Split(value, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern == "" {
+					continue
+				}
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+				}
+				c.IgnorePatterns = append(c.IgnorePatterns, pattern)
+			}
+			return nil
+		},
+	},
+	"watch_events": {
+		get: func(c *Config) string { return strings.Join(c.WatchEvents, ",") },
+		set: func(c *Config, value string) error {
+			c.WatchEvents = nil
+			for _, class := range strings.Split(value, ",") {
+				if class = strings.TrimSpace(class); class != "" {
+					c.WatchEvents = append(c.WatchEvents, class)
+				}
+			}
+			_, err := status.ParseEventClasses(c.WatchEvents)
+			return err
+		},
+	},
+	"window_titles": {
+		get: func(c *Config) string {
+			titles := make([]string, len(c.WindowTitles))
+			for i, w := range c.WindowTitles {
+				titles[i] = w.Pattern + "=" + w.Project
+			}
+			return strings.Join(titles, ",")
+		},
+		set: func(c *Config, value string) error {
+			c.WindowTitles = nil
+			for _, title := range strings.Split(value, ",") {
+				if title = strings.TrimSpace(title); title == "" {
+					continue
+				}
+				// Patterns may contain '=', but project names rarely do
+				i := strings.LastIndex(title, "=")
+				if i <= 0 || i == len(title)-1 {
+					return fmt.Errorf("invalid window title %q (expected "+
+						"<pattern>=<project>)", title)
+				}
+				pattern, project := title[:i], title[i+1:]
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+				}
+				c.WindowTitles = append(c.WindowTitles, WindowTitle{
+					Pattern: pattern,
+					Project: project,
+				})
+			}
+			return nil
+		},
+	},
+	"window_poll_interval": {
+		get: func(c *Config) string { return c.WindowPollInterval.String() },
+		set: func(c *Config, value string) error { return c.WindowPollInterval.Set(value) },
+	},
+	"notifications": {
+		get: func(c *Config) string { return strings.Join(c.Notifications, ",") },
+		set: func(c *Config, value string) error {
+			c.Notifications = nil
+			for _, event := range strings.Split(value, ",") {
+				switch event = strings.TrimSpace(event); event {
+				case "":
+					continue
+				case NotifyStart, NotifyStop, NotifySwitch:
+					c.Notifications = append(c.Notifications, event)
+				default:
+					return fmt.Errorf("invalid notification %q (expected %s, %s, or %s)",
+						event, NotifyStart, NotifyStop, NotifySwitch)
+				}
+			}
+			return nil
+		},
+	},
+	"require_approval": {
+		get: func(c *Config) string { return strconv.FormatBool(c.RequireApproval) },
+		set: func(c *Config, value string) error {
+			required, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for require_approval (expected "+
+					"true or false)", value)
+			}
+			c.RequireApproval = required
+			return nil
+		},
+	},
+	"redactions": {
+		get: func(c *Config) string {
+			redactions := make([]string, len(c.Redactions))
+			for i, r := range c.Redactions {
+				redactions[i] = r.Pattern + "=" + r.Replacement
+			}
+			return strings.Join(redactions, ",")
+		},
+		set: func(c *Config, value string) error {
+			c.Redactions = nil
+			for _, redaction := range strings.Split(value, ",") {
+				if redaction = strings.TrimSpace(redaction); redaction == "" {
+					continue
+				}
+				// As with window titles, patterns may contain '='. The
+				// replacement may be empty, to remove matching text
+				i := strings.LastIndex(redaction, "=")
+				if i <= 0 {
+					return fmt.Errorf("invalid redaction %q (expected "+
+						"<pattern>=<replacement>)", redaction)
+				}
+				spec := redact.Spec{Pattern: redaction[:i], Replacement: redaction[i+1:]}
+				if _, err := redact.NewRule(spec.Pattern, spec.Replacement); err != nil {
+					return err
+				}
+				c.Redactions = append(c.Redactions, spec)
+			}
+			return nil
+		},
+	},
+	"description_template": {
+		get: func(c *Config) string { return c.DescriptionTemplate },
+		set: func(c *Config, value string) error {
+			if _, err := status.ParseTemplate("description_template", value); err != nil {
+				return err
+			}
+			c.DescriptionTemplate = value
+			return nil
+		},
+	},
+	"dry_run": {
+		get: func(c *Config) string { return strconv.FormatBool(c.DryRun) },
+		set: func(c *Config, value string) error {
+			dryRun, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for dry_run (expected true or "+
+					"false)", value)
+			}
+			c.DryRun = dryRun
+			return nil
+		},
+	},
+	"watch_backend": {
+		get: func(c *Config) string {
+			if c.WatchBackend == "" {
+				return string(status.DefaultBackend)
+			}
+			return c.WatchBackend
+		},
+		set: func(c *Config, value string) error {
+			backend, err := status.ParseBackend(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for watch_backend: %v", err)
+			}
+			c.WatchBackend = string(backend)
+			if backend == status.DefaultBackend {
+				c.WatchBackend = "" // the default
+			}
+			return nil
+		},
+	},
+	"workspace": {
+		get: func(c *Config) string { return c.Workspace },
+		set: func(c *Config, value string) error {
+			c.Workspace = value
+			return nil
+		},
+	},
+}
+
+// Consolidation returns the consolidation of time entries awaiting approval
+// configured by 'c'
+func (c *Config) Consolidation() status.Consolidation {
+	return status.Consolidation{
+		MergeGap: time.Duration(c.MergeGap),
+		Round:    time.Duration(c.RoundEntries),
+	}
+}
+
+// Get returns the value of the setting 'key' in 'c', formatted as it would be
+// passed to Set
+func (c *Config) Get(key string) (string, error) {
+	s, ok := settings[key]
+	if !ok {
+		return "", fmt.Errorf("unknown setting %q (must be one of %s)", key,
+			strings.Join(Keys(), ", "))
+	}
+	return s.get(c), nil
+}
+
+// Set parses 'value' and assigns it to the setting 'key' in 'c'. List
+// settings (ignore_patterns, watch_events, window_titles, redactions) are
+// comma-separated,
+// each window title is <pattern>=<project>, and each redaction is
+// <pattern>=<replacement>
+func (c *Config) Set(key, value string) error {
+	s, ok := settings[key]
+	if !ok {
+		return fmt.Errorf("unknown setting %q (must be one of %s)", key,
+			strings.Join(Keys(), ", "))
+	}
+	return s.set(c, value)
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/redact"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A missing config file yields the defaults
+	c, err := Read(dir)
+	if err != nil || !reflect.DeepEqual(c, Default()) {
+		t.Fatalf("expected default config, but got %+v (%v)", c, err)
+	}
+
+	for key, value := range map[string]string{
+		"idle_timeout":         "15m",
+		"input_idle_timeout":   "10m",
+		"stop_grace":           "2m",
+		"bridge_gap":           "15m",
+		"min_switch_duration":  "1m",
+		"min_activity":         "3/5m",
+		"debounce_window":      "10s",
+		"ignore_patterns":      ".git, *.swp",
+		"watch_events":         "modify, attrib",
+		"workspace":            "work",
+		"window_titles":        "— tg — Visual Studio Code$=tg, (?i)pachyderm=pach",
+		"window_poll_interval": "1m",
+		"notifications":        "start, switch",
+		"require_approval":     "true",
+		"watch_backend":        "poll",
+		"dry_run":              "true",
+		"description_template": "{{.Project}} on {{.GitBranch}}",
+		"git_branches":         "true",
+		"merge_gap":            "5m",
+		"backend":              "local",
+		"round_entries":        "15m",
+		"harvest_account_id":   "12345",
+		"api_timeout":          "10s",
+		"pomodoro":             "25m",
+		"pomodoro_break":       "10m",
+		"pomodoro_tag":         "pomodoro",
+		"work_hours":           "Mon-Fri  09:00-18:00, Sat 10-12",
+		"after_hours":          "tag",
+		"after_hours_tag":      "overtime",
+		"redactions":           "/clients/[^/]+=/clients/<client>, secret-\\w+=",
+	} {
+		if err := c.Set(key, value); err != nil {
+			t.Fatalf("could not set %s: %v", key, err)
+		}
+	}
+	c.Profiles = map[string]Profile{"client-a": {Workspace: "acme", Tags: []string{"acme"}}}
+	if err := c.Save(dir); err != nil {
+		t.Fatalf("could not save config: %v", err)
+	}
+	got, err := Read(dir)
+	if err != nil {
+		t.Fatalf("could not read config: %v", err)
+	}
+	expected := Config{
+		IdleTimeout:       Duration(15 * time.Minute),
+		InputIdleTimeout:  Duration(10 * time.Minute),
+		StopGrace:         Duration(2 * time.Minute),
+		BridgeGap:         Duration(15 * time.Minute),
+		MinSwitchDuration: Duration(time.Minute),
+		MinActivity:       "3/5m",
+		DebounceWindow:    Duration(10 * time.Second),
+		IgnorePatterns:    []string{".git", "*.swp"},
+		WatchEvents:       []string{"modify", "attrib"},
+		Workspace:         "work",
+		WindowTitles: []WindowTitle{
+			{Pattern: "— tg — Visual Studio Code$", Project: "tg"},
+			{Pattern: "(?i)pachyderm", Project: "pach"},
+		},
+		WindowPollInterval:  Duration(time.Minute),
+		Notifications:       []string{NotifyStart, NotifySwitch},
+		RequireApproval:     true,
+		WatchBackend:        "poll",
+		DryRun:              true,
+		DescriptionTemplate: "{{.Project}} on {{.GitBranch}}",
+		GitBranches:         true,
+		MergeGap:            Duration(5 * time.Minute),
+		Backend:             BackendLocal,
+		RoundEntries:        Duration(15 * time.Minute),
+		HarvestAccountID:    "12345",
+		APITimeout:          Duration(10 * time.Second),
+		Pomodoro:            Duration(25 * time.Minute),
+		PomodoroBreak:       Duration(10 * time.Minute),
+		PomodoroTag:         "pomodoro",
+		WorkHours:           []string{"Mon-Fri 09:00-18:00", "Sat 10-12"},
+		AfterHours:          AfterHoursTag,
+		AfterHoursTag:       "overtime",
+		Redactions: []redact.Spec{
+			{Pattern: "/clients/[^/]+", Replacement: "/clients/<client>"},
+			{Pattern: `secret-\w+`, Replacement: ""},
+		},
+		Profiles: map[string]Profile{"client-a": {Workspace: "acme", Tags: []string{"acme"}}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, got)
+	}
+	if v, err := got.Get("ignore_patterns"); err != nil || v != ".git,*.swp" {
+		t.Fatalf("unexpected ignore_patterns %q (%v)", v, err)
+	}
+	if err := got.Set("window_titles", "no project"); err == nil {
+		t.Fatalf("expected error setting a window title without a project")
+	}
+	if err := got.Set("notifications", "start,exit"); err == nil {
+		t.Fatalf("expected error setting an unknown notification")
+	}
+	if err := got.Set("redactions", "([a-z]=x"); err == nil {
+		t.Fatalf("expected error setting an invalid redaction pattern")
+	}
+	if err := got.Set("work_hours", "Mon-Fri 9-25"); err == nil {
+		t.Fatalf("expected error setting invalid working hours")
+	}
+	if err := got.Set("pomodoro_break", "off"); err == nil {
+		t.Fatalf("expected error turning off pomodoro_break")
+	}
+	if err := got.Set("description_template", "{{.Branch}}"); err == nil {
+		t.Fatalf("expected error setting a template with an unknown field")
+	}
+	if err := got.Set("backend", "freckle"); err == nil {
+		t.Fatalf("expected error setting an unknown backend")
+	}
+	if err := got.Set("watch_backend", "kqueue"); err == nil {
+		t.Fatalf("expected error setting an unknown watch backend")
+	}
+	if err := got.Set("min_activity", "3/"); err == nil {
+		t.Fatalf("expected error setting an invalid activity threshold")
+	}
+	if _, err := got.Profile("client-b"); err == nil {
+		t.Fatalf("expected error getting an unknown profile")
+	}
+	if err := ValidateProfileName("Client A"); err == nil {
+		t.Fatalf("expected error validating an invalid profile name")
+	}
+	if err := got.Set("nonexistent", "x"); err == nil {
+		t.Fatalf("expected error setting unknown key")
+	}
+}
+
+func TestPromoteCandidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, ok, err := ReadCandidate(dir); err != nil || ok {
+		t.Fatalf("expected no candidate config, but got (%t, %v)", ok, err)
+	}
+	candidate := Default()
+	candidate.IgnorePatterns = []string{"*.log"}
+	if err := candidate.SaveCandidate(dir); err != nil {
+		t.Fatalf("could not save candidate config: %v", err)
+	}
+	// The active config is unaffected until the candidate is promoted
+	if c, err := Read(dir); err != nil || len(c.IgnorePatterns) != 0 {
+		t.Fatalf("expected default config, but got %+v (%v)", c, err)
+	}
+	if err := PromoteCandidate(dir); err != nil {
+		t.Fatalf("could not promote candidate config: %v", err)
+	}
+	if c, err := Read(dir); err != nil || !reflect.DeepEqual(c, candidate) {
+		t.Fatalf("expected %+v, but got %+v (%v)", candidate, c, err)
+	}
+	if _, ok, _ := ReadCandidate(dir); ok {
+		t.Fatalf("expected candidate config to be removed after promotion")
+	}
+}
+
+func TestYAMLConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A JSON config file written by an older version of tg is still read
+	legacy := `{"idle_timeout": "20m", "ignore_patterns": [".git"]}`
+	if err := ioutil.WriteFile(path.Join(dir, legacyFileName), []byte(legacy), 0644); err != nil {
+		t.Fatalf("could not write legacy config: %v", err)
+	}
+	c, err := Read(dir)
+	if err != nil || c.IdleTimeout != Duration(20*time.Minute) || len(c.IgnorePatterns) != 1 {
+		t.Fatalf("expected the legacy config to be read, but got %+v (%v)", c, err)
+	}
+
+	// Saving it replaces the JSON file with a YAML file
+	if err := c.Save(dir); err != nil {
+		t.Fatalf("could not save config: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, legacyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the legacy config to be removed, but got %v", err)
+	}
+
+	// The YAML file may be edited by hand, e.g. with unquoted values
+	yamlConfig := "# edited by hand\nidle_timeout: 45m\nstop_grace: off\n" +
+		"ignore_patterns:\n  - .git\n  - \"*.swp\"\n"
+	if err := ioutil.WriteFile(path.Join(dir, FileName), []byte(yamlConfig), 0644); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+	c, err = Read(dir)
+	if err != nil || c.IdleTimeout != Duration(45*time.Minute) || c.StopGrace != 0 ||
+		!reflect.DeepEqual(c.IgnorePatterns, []string{".git", "*.swp"}) {
+		t.Fatalf("expected the YAML config to be read, but got %+v (%v)", c, err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, FileName), []byte("idle_timeout: [\n"), 0644); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+	if _, err := Read(dir); err == nil {
+		t.Fatalf("expected an error reading an invalid config")
+	}
+}
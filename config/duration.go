@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that's parsed from human-friendly strings, in
+// both flags and config files. In addition to everything accepted by
+// time.ParseDuration, it accepts days ("2d") and weeks ("1w"), and "off" (or
+// "0"), meaning that whatever the duration controls is disabled
+type Duration time.Duration
+
+// units maps each unit suffix accepted by ParseDuration to its length. Longer
+// suffixes that share a prefix with shorter ones (e.g. "ms" and "m") are
+// matched first (see ParseDuration)
+var units = []struct {
+	suffix string
+	length time.Duration
+}{
+	{"ns", time.Nanosecond},
+	{"us", time.Microsecond},
+	{"µs", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+}
+
+// ParseDuration parses a Duration such as "90m", "1h30m", "2d", or "off"
+func ParseDuration(s string) (Duration, error) {
+	orig := s
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "off", "none", "never", "0":
+		return 0, nil
+	case "":
+		return 0, fmt.Errorf("empty duration (use \"off\" to disable)")
+	}
+	var total time.Duration
+	for s != "" {
+		// Read number
+		i := 0
+		for i < len(s) && (s[i] == '.' || ('0' <= s[i] && s[i] <= '9')) {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q: expected a number at %q", orig, s)
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %v", orig, err)
+		}
+		s = s[i:]
+
+		// Read unit
+		var unit time.Duration
+		for _, u := range units {
+			if strings.HasPrefix(s, u.suffix) && (len(u.suffix) > 1 ||
+				len(s) == 1 || s[1] < 'a' || s[1] > 'z') {
+				unit = u.length
+				s = s[len(u.suffix):]
+				break
+			}
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("invalid duration %q: missing or unknown unit "+
+				"(expected one of w, d, h, m, s, ms)", orig)
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return Duration(total), nil
+}
+
+// Enabled returns false if 'd' is "off"
+func (d Duration) Enabled() bool {
+	return d > 0
+}
+
+// String returns 'd' in a form accepted by ParseDuration
+func (d Duration) String() string {
+	if d <= 0 {
+		return "off"
+	}
+	day := Duration(24 * time.Hour)
+	if d < day || d%Duration(time.Hour) != 0 {
+		// Drop trailing zero units (e.g. "2h0m0s" -> "2h")
+		s := time.Duration(d).String()
+		if strings.HasSuffix(s, "m0s") {
+			s = strings.TrimSuffix(s, "0s")
+		}
+		if strings.HasSuffix(s, "h0m") {
+			s = strings.TrimSuffix(s, "0m")
+		}
+		return s
+	}
+	result := fmt.Sprintf("%dd", d/day)
+	if rem := d % day; rem > 0 {
+		result += fmt.Sprintf("%dh", rem/Duration(time.Hour))
+	}
+	return result
+}
+
+// Set implements the pflag.Value interface, so Durations can be used as flags
+func (d *Duration) Set(s string) error {
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Type implements the pflag.Value interface
+func (d *Duration) Type() string {
+	return "duration"
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"90m\" or \"off\", "+
+			"but got %s", data)
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1w2d", 9 * 24 * time.Hour},
+		{"1.5h", 90 * time.Minute},
+		{"500ms", 500 * time.Millisecond},
+		{"3s", 3 * time.Second},
+		{" 24M ", 24 * time.Minute},
+		{"off", 0},
+		{"0", 0},
+	} {
+		got, err := ParseDuration(c.s)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): unexpected error: %v", c.s, err)
+		} else if time.Duration(got) != c.want {
+			t.Errorf("ParseDuration(%q): expected %v, but got %v", c.s, c.want, got)
+		}
+	}
+	for _, s := range []string{"", "5", "m", "5x", "-5m", "1h-"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q): expected error", s)
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	for _, s := range []string{"off", "90m", "1h30m", "2d", "2d3h", "3s"} {
+		d, err := ParseDuration(s)
+		if err != nil {
+			t.Fatalf("ParseDuration(%q): %v", s, err)
+		}
+		if again, err := ParseDuration(d.String()); err != nil || again != d {
+			t.Errorf("%q does not round-trip: String() = %q", s, d.String())
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var v struct {
+		Idle Duration `json:"idle"`
+	}
+	if err := json.Unmarshal([]byte(`{"idle": "2d"}`), &v); err != nil {
+		t.Fatalf("could not unmarshal duration: %v", err)
+	}
+	if time.Duration(v.Idle) != 48*time.Hour {
+		t.Fatalf("expected 48h but got %v", v.Idle)
+	}
+	if err := json.Unmarshal([]byte(`{"idle": 5}`), &v); err == nil {
+		t.Fatalf("expected error unmarshalling non-string duration")
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file is YAML, but Config (and the types in it, e.g. Duration and
+// redact.Spec) only define how they're serialized as JSON. Rather than
+// duplicating that for YAML, values are converted between the two: YAML is
+// parsed into generic values that are then decoded as JSON, and JSON is
+// re-encoded as YAML
+
+// unmarshalYAML parses the YAML document 'data' into 'v', via its JSON
+// encoding
+func unmarshalYAML(data []byte, v interface{}) error {
+	// yaml.v3 follows YAML 1.2, in which e.g. "off" is a string rather than a
+	// boolean (as Duration requires), and mappings with string keys are
+	// decoded as map[string]interface{}, which encoding/json accepts
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	if generic == nil {
+		return nil // empty file
+	}
+	jsonData, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, v)
+}
+
+// marshalYAML serializes 'v' as a YAML document, via its JSON encoding, with
+// fields in the order in which they're encoded as JSON
+func marshalYAML(v interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(jsonData, &node); err != nil {
+		return nil, err
+	}
+	clearStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clearStyle resets the style of 'n' and its children, which were parsed
+// from JSON (and so are in flow style, with quoted strings), so that they're
+// written in block style, with strings quoted only where necessary
+func clearStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		clearStyle(c)
+	}
+}
@@ -0,0 +1,116 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Access is the level of access that a control socket client has
+type Access uint8
+
+// The definition of each Access level. Levels are ordered, so that a client
+// with access 'a' may call any method requiring access <= 'a'
+const (
+	// NoAccess clients may not call any methods
+	NoAccess Access = iota
+	// ReadAccess clients may query the daemon's state, but not change it
+	ReadAccess
+	// WriteAccess clients may call any method
+	WriteAccess
+)
+
+func (a Access) String() string {
+	switch a {
+	case NoAccess:
+		return "none"
+	case ReadAccess:
+		return "read"
+	case WriteAccess:
+		return "write"
+	}
+	return fmt.Sprintf("Access(%d)", uint8(a))
+}
+
+// methodAccess maps each control method to the access level required to call
+// it. Methods not listed here require WriteAccess
+var methodAccess = map[string]Access{}
+
+// requiredAccess returns the access level required to call 'method'
+func requiredAccess(method string) Access {
+	if a, ok := methodAccess[method]; ok {
+		return a
+	}
+	return WriteAccess
+}
+
+// Cred is the credentials of the process on the other end of a control
+// socket connection (see PeerCredentials)
+type Cred struct {
+	UID, GID uint32
+}
+
+// ACL determines which peers may call which control methods, based on the
+// peer's credentials (see PeerCredentials)
+type ACL struct {
+	// OwnerUID is the UID of the user running the daemon. Processes running as
+	// this user (or root) have WriteAccess
+	OwnerUID int
+
+	// ReadGID, if non-negative, is a group whose members have ReadAccess (e.g.
+	// so that a status bar running as a separate service user can query the
+	// daemon's status, but not stop timers)
+	ReadGID int
+}
+
+// DefaultACL returns an ACL that grants access only to the current user
+func DefaultACL() ACL {
+	return ACL{OwnerUID: os.Getuid(), ReadGID: -1}
+}
+
+// LookupGroup returns the GID of the group 'name' (e.g. for ACL.ReadGID),
+// which may also be a numeric GID
+func LookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, fmt.Errorf("could not look up group %q: %v", name, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return -1, fmt.Errorf("group %q has non-numeric ID %q", name, g.Gid)
+	}
+	return gid, nil
+}
+
+// AccessFor returns the access level granted to a peer with credentials
+// 'cred'
+func (a ACL) AccessFor(cred *Cred) Access {
+	switch {
+	case cred == nil:
+		return NoAccess
+	case cred.UID == uint32(a.OwnerUID), cred.UID == 0:
+		return WriteAccess
+	case a.ReadGID >= 0 && int(cred.GID) == a.ReadGID:
+		return ReadAccess
+	}
+	return NoAccess
+}
+
+// Authorize returns an error if a peer with credentials 'cred' may not call
+// 'method'
+func (a ACL) Authorize(cred *Cred, method string) error {
+	have, need := a.AccessFor(cred), requiredAccess(method)
+	if have < need {
+		var uid uint32
+		if cred != nil {
+			uid = cred.UID
+		}
+		return fmt.Errorf("permission denied: %q requires %s access, but uid %d "+
+			"has %s access", method, need, uid, have)
+	}
+	return nil
+}
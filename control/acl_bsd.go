@@ -0,0 +1,56 @@
+//go:build darwin || freebsd
+
+package control
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants from sys/un.h, which x/sys/unix doesn't define
+const (
+	solLocal      = 0
+	localPeercred = 0x1
+)
+
+// xucred is struct xucred from sys/ucred.h. FreeBSD appends the peer's pid,
+// which is ignored
+type xucred struct {
+	Version uint32
+	UID     uint32
+	Ngroups int16
+	Groups  [16]uint32
+	_       uintptr
+}
+
+// PeerCredentials returns the credentials of the process on the other end of
+// 'conn', read with LOCAL_PEERCRED. The peer's primary group is the first of
+// its groups
+func PeerCredentials(conn *net.UnixConn) (*Cred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		xu    xucred
+		errno syscall.Errno
+	)
+	if err := raw.Control(func(fd uintptr) {
+		n := uint32(unsafe.Sizeof(xu))
+		_, _, errno = unix.Syscall6(unix.SYS_GETSOCKOPT, fd, solLocal, localPeercred,
+			uintptr(unsafe.Pointer(&xu)), uintptr(unsafe.Pointer(&n)), 0)
+	}); err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, fmt.Errorf("could not read peer credentials: %v", errno)
+	}
+	if xu.Ngroups < 1 {
+		return nil, fmt.Errorf("could not read peer credentials: peer has no groups")
+	}
+	return &Cred{UID: xu.UID, GID: xu.Groups[0]}, nil
+}
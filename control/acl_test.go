@@ -0,0 +1,37 @@
+package control
+
+import "testing"
+
+func TestACL(t *testing.T) {
+	methodAccess["test-read"] = ReadAccess
+	defer delete(methodAccess, "test-read")
+	acl := ACL{OwnerUID: 1000, ReadGID: 50}
+
+	owner := &Cred{UID: 1000, GID: 1000}
+	reader := &Cred{UID: 1001, GID: 50}
+	stranger := &Cred{UID: 1002, GID: 1002}
+	for _, c := range []struct {
+		cred   *Cred
+		method string
+		ok     bool
+	}{
+		{owner, "test-read", true},
+		{owner, "test-write", true},
+		{reader, "test-read", true},
+		{reader, "test-write", false},
+		{stranger, "test-read", false},
+		{nil, "test-read", false},
+	} {
+		err := acl.Authorize(c.cred, c.method)
+		if (err == nil) != c.ok {
+			t.Errorf("Authorize(%+v, %q): expected ok=%v, but got %v",
+				c.cred, c.method, c.ok, err)
+		}
+	}
+
+	// Without ReadGID, group members have no access
+	acl.ReadGID = -1
+	if err := acl.Authorize(reader, "test-read"); err == nil {
+		t.Errorf("expected group member to be denied when ReadGID is unset")
+	}
+}
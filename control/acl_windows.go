@@ -0,0 +1,53 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// PeerCredentials returns the credentials of the process on the other end of
+// 'conn'. Windows doesn't report the peer of a unix domain socket, but the
+// socket's DACL (see PrepareSocket) only lets the daemon's own user connect,
+// so every peer has the daemon's credentials
+func PeerCredentials(conn *net.UnixConn) (*Cred, error) {
+	return &Cred{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}, nil
+}
+
+// PrepareDir does nothing, as access to the control socket is controlled by
+// its own DACL (see PrepareSocket)
+func (a ACL) PrepareDir(dir string) error {
+	return nil
+}
+
+// PrepareSocket replaces the DACL of the socket file at 'path' with one that
+// grants access only to the current user, the same way that the DACL of a
+// named pipe would restrict who may open it. Windows has no groups in the
+// unix sense, so an ACL with a ReadGID is rejected
+func (a ACL) PrepareSocket(path string) error {
+	if a.ReadGID >= 0 {
+		return fmt.Errorf("read-only group access to %q is not supported on Windows", path)
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("could not look up the current user: %v", err)
+	}
+	// Protected (i.e. not inherited), with a single entry granting full
+	// control to the current user
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;GA;;;" + user.User.Sid.String() + ")")
+	if err != nil {
+		return fmt.Errorf("could not build security descriptor for %q: %v", path, err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return fmt.Errorf("could not build security descriptor for %q: %v", path, err)
+	}
+	if err := windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		nil, nil, dacl, nil); err != nil {
+		return fmt.Errorf("could not set permissions of %q: %v", path, err)
+	}
+	return nil
+}
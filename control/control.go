@@ -0,0 +1,211 @@
+// Package control implements the protocol that the tg CLI uses to talk to a
+// running tg daemon over a unix domain socket in tg's state directory.
+//
+// Each connection carries a single request and response, both JSON-encoded:
+// the client sends a Request naming a method, and the daemon replies with a
+// Response containing either the method's result or an error.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SocketName is the name of the daemon's control socket in tg's state
+// directory
+const SocketName = "control.sock"
+
+// callTimeout bounds how long Call waits for the daemon to respond
+const callTimeout = 30 * time.Second
+
+// ErrNotRunning is returned by Call if no daemon is listening on the control
+// socket
+var ErrNotRunning = errors.New("the tg daemon is not running")
+
+// Request is sent by a client to the daemon
+type Request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is sent by the daemon to a client in reply to a Request
+type Response struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Handler implements a single control method. 'params' is the raw Params
+// field of the request, and the returned value is serialized as the Result
+// field of the response
+type Handler func(params json.RawMessage) (interface{}, error)
+
+// Server listens on the control socket and dispatches requests to Handlers
+type Server struct {
+	acl      ACL
+	listener *net.UnixListener
+
+	// peerCredentials returns the credentials of a client. It's
+	// PeerCredentials, except in tests, which fake other users' clients
+	peerCredentials func(conn *net.UnixConn) (*Cred, error)
+
+	// handlersMu guards 'handlers'
+	handlersMu sync.Mutex
+	handlers   map[string]Handler
+}
+
+// SocketPath returns the path of the control socket in 'tgStateDir'
+func SocketPath(tgStateDir string) string {
+	return path.Join(tgStateDir, SocketName)
+}
+
+// Listen creates the control socket in 'tgStateDir'. Connections are
+// authorized using 'acl', and the permissions of 'tgStateDir' and the socket
+// are set so that only the peers it permits can connect (see PrepareDir). If
+// a socket file already exists but nothing is listening on it (e.g. because a
+// previous daemon crashed) it's replaced
+func Listen(tgStateDir string, acl ACL) (*Server, error) {
+	if err := acl.PrepareDir(tgStateDir); err != nil {
+		return nil, err
+	}
+	sockPath := SocketPath(tgStateDir)
+	if _, err := os.Stat(sockPath); err == nil {
+		if conn, err := net.Dial("unix", sockPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another tg daemon is listening on %q", sockPath)
+		}
+		if err := os.Remove(sockPath); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %q: %v", sockPath, err)
+		}
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %q: %v", sockPath, err)
+	}
+	if err := acl.PrepareSocket(sockPath); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &Server{
+		acl:             acl,
+		listener:        l,
+		peerCredentials: PeerCredentials,
+		handlers:        make(map[string]Handler),
+	}, nil
+}
+
+// Handle registers 'h' as the implementation of 'method'
+func (s *Server) Handle(method string, h Handler) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.handlers[method] = h
+}
+
+// Serve accepts connections until Close() is called
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			if ne, ok := err.(*net.OpError); ok && !ne.Temporary() {
+				return nil // listener closed
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the server and removes the socket file
+func (s *Server) Close() error {
+	return s.listener.Close() // also unlinks the socket file
+}
+
+// serveConn reads a single request from 'conn', and writes its response
+func (s *Server) serveConn(conn *net.UnixConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+	var resp Response
+	result, err := s.dispatch(conn)
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(&resp)
+}
+
+// dispatch reads a request from 'conn', checks that the peer may call the
+// requested method, and calls it
+func (s *Server) dispatch(conn *net.UnixConn) (interface{}, error) {
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return nil, fmt.Errorf("could not parse request: %v", err)
+	}
+	cred, err := s.peerCredentials(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.acl.Authorize(cred, req.Method); err != nil {
+		return nil, err
+	}
+	s.handlersMu.Lock()
+	h, ok := s.handlers[req.Method]
+	s.handlersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q", req.Method)
+	}
+	return h(req.Params)
+}
+
+// Call sends a request for 'method' with 'params' to the daemon listening in
+// 'tgStateDir', and deserializes the result into 'result' (if non-nil). If no
+// daemon is running, Call returns ErrNotRunning
+func Call(tgStateDir, method string, params, result interface{}) error {
+	conn, err := net.DialTimeout("unix", SocketPath(tgStateDir), callTimeout)
+	if err != nil {
+		if isNotRunning(err) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("could not connect to tg daemon: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	req := Request{Method: method}
+	if params != nil {
+		if req.Params, err = json.Marshal(params); err != nil {
+			return fmt.Errorf("could not serialize %q params: %v", method, err)
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		return fmt.Errorf("could not send %q request: %v", method, err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("could not read %q response: %v", method, err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// isNotRunning returns true if 'err' (returned by Dial) indicates that nothing
+// is listening on the control socket
+func isNotRunning(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno == syscall.ENOENT || errno == syscall.ECONNREFUSED
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Call(dir, MethodStatus, nil, nil); err != ErrNotRunning {
+		t.Fatalf("expected ErrNotRunning before Listen, but got %v", err)
+	}
+
+	s, err := Listen(dir, DefaultACL())
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer s.Close()
+	s.Handle(MethodTick, func(params json.RawMessage) (interface{}, error) {
+		var p TickParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Project == "" {
+			return nil, fmt.Errorf("no project")
+		}
+		return StatusResult{Project: p.Project}, nil
+	})
+	go s.Serve()
+
+	var result StatusResult
+	if err := Call(dir, MethodTick, TickParams{Project: "p"}, &result); err != nil {
+		t.Fatalf("could not call %q: %v", MethodTick, err)
+	}
+	if result.Project != "p" {
+		t.Fatalf("expected project \"p\", but got %q", result.Project)
+	}
+	if err := Call(dir, MethodTick, TickParams{}, nil); err == nil || err.Error() != "no project" {
+		t.Fatalf("expected error \"no project\", but got %v", err)
+	}
+	if err := Call(dir, "nonexistent", nil, nil); err == nil {
+		t.Fatalf("expected error calling unknown method")
+	}
+
+	// A second server can't listen on the same socket
+	if _, err := Listen(dir, DefaultACL()); err == nil {
+		t.Fatalf("expected error listening on socket that's in use")
+	}
+}
+
+func TestReadGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the control socket has no read group on Windows")
+	}
+	dir, err := ioutil.TempDir("", "control-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The current user's own group stands in for the read group, as it can
+	// always be assigned to the state dir and socket
+	acl := DefaultACL()
+	acl.ReadGID = os.Getgid()
+	s, err := Listen(dir, acl)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer s.Close()
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("could not stat state dir: %v", err)
+	}
+	if info.Mode().Perm() != 0710 {
+		t.Fatalf("expected state dir to have mode 0710, but got %o", info.Mode().Perm())
+	}
+
+	// Pretend every client is another user in the read group
+	s.peerCredentials = func(conn *net.UnixConn) (*Cred, error) {
+		return &Cred{UID: uint32(os.Getuid() + 1), GID: uint32(acl.ReadGID)}, nil
+	}
+	for _, m := range []string{MethodStatus, MethodStop} {
+		s.Handle(m, func(params json.RawMessage) (interface{}, error) {
+			return StatusResult{Project: "p"}, nil
+		})
+	}
+	go s.Serve()
+
+	var result StatusResult
+	if err := Call(dir, MethodStatus, nil, &result); err != nil {
+		t.Fatalf("expected group member to be able to call %q, but got: %v", MethodStatus, err)
+	}
+	if result.Project != "p" {
+		t.Fatalf("expected project \"p\", but got %q", result.Project)
+	}
+	err = Call(dir, MethodStop, nil, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "permission denied") {
+		t.Fatalf("expected group member to be denied %q, but got %v", MethodStop, err)
+	}
+}
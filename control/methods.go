@@ -0,0 +1,168 @@
+package control
+
+import (
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// Control methods served by the tg daemon
+const (
+	MethodWatch = "watch"
+	// MethodWatchBatch adds many watches at once, all or nothing
+	MethodWatchBatch = "watch_batch"
+	MethodUnwatch    = "unwatch"
+	MethodTick       = "tick"
+	MethodStop       = "stop"
+	MethodDetour     = "detour"
+	// MethodPause pauses (or redirects) tracking while a command runs (see
+	// 'tg run')
+	MethodPause  = "pause"
+	MethodStatus = "status"
+	// MethodSuspend suspends tracking until it's resumed, or for a while (see
+	// 'tg pause')
+	MethodSuspend = "suspend"
+	// MethodShellActivity reports a command run in a shell (see 'tg
+	// shell-hook')
+	MethodShellActivity = "shell_activity"
+	// MethodDaemonInfo identifies the running daemon (see 'tg daemon status')
+	MethodDaemonInfo = "daemon_info"
+	// MethodShutdown shuts the daemon down, as SIGTERM does
+	MethodShutdown = "shutdown"
+	// MethodReload reloads the daemon's watches and config, as SIGHUP does
+	MethodReload = "reload"
+	// MethodWatchHealth describes how each root watch is being watched (see
+	// 'tg list'). Its result is a []status.RootHealth
+	MethodWatchHealth = "watch_health"
+	// MethodRepair re-walks every root watch, and watches any directories that
+	// were missed (see 'tg repair'). Its result is a status.RepairResult
+	MethodRepair = "repair"
+)
+
+func init() {
+	methodAccess[MethodStatus] = ReadAccess
+	methodAccess[MethodDaemonInfo] = ReadAccess
+	methodAccess[MethodWatchHealth] = ReadAccess
+}
+
+// WatchParams are the parameters of MethodWatch
+type WatchParams struct {
+	Dir     string `json:"dir"`
+	Project string `json:"project"`
+}
+
+// WatchBatchParams are the parameters of MethodWatchBatch
+type WatchBatchParams struct {
+	Watches []status.RootWatch `json:"watches"`
+}
+
+// UnwatchParams are the parameters of MethodUnwatch
+type UnwatchParams struct {
+	Dir string `json:"dir"`
+}
+
+// TickParams are the parameters of MethodTick
+type TickParams struct {
+	Project string `json:"project"`
+	// Profile, if set, is the profile in which the activity is recorded (see
+	// 'tg tick --profile'). Only the project's root watches in that profile
+	// supply the activity's options
+	Profile string `json:"profile,omitempty"`
+}
+
+// DetourParams are the parameters of MethodDetour
+type DetourParams struct {
+	// Project, if set, is the project to which work during the detour is
+	// attributed
+	Project string `json:"project,omitempty"`
+	// End ends the current detour, rather than starting one
+	End bool `json:"end,omitempty"`
+}
+
+// PauseParams are the parameters of MethodPause
+type PauseParams struct {
+	// PID is the process for which tracking is paused. The pause ends when End
+	// is sent for PID, or when the process exits
+	PID int `json:"pid"`
+	// Project, if set, is the project to which all writes are attributed while
+	// the pause is active. Otherwise, writes are ignored
+	Project string `json:"project,omitempty"`
+	// End ends the pause for PID, rather than starting one
+	End bool `json:"end,omitempty"`
+}
+
+// SuspendParams are the parameters of MethodSuspend
+type SuspendParams struct {
+	// Until, if set, is when tracking resumes by itself. Otherwise, it's
+	// suspended until End is sent
+	Until time.Time `json:"until,omitempty"`
+	// End resumes tracking, rather than suspending it
+	End bool `json:"end,omitempty"`
+	// AfterHours, with End, also tracks writes outside working hours (see
+	// config.WorkHours) until working hours next begin
+	AfterHours bool `json:"after_hours,omitempty"`
+}
+
+// ShellActivityParams are the parameters of MethodShellActivity
+type ShellActivityParams struct {
+	// Dir is the absolute working directory in which the command ran
+	Dir string `json:"dir"`
+}
+
+// StopResult is the result of MethodStop
+type StopResult struct {
+	// TimeEntryID is the ID of the time entry that was stopped, or 0 if no time
+	// entry was open
+	TimeEntryID int64 `json:"time_entry_id"`
+}
+
+// DaemonInfo is the result of MethodDaemonInfo
+type DaemonInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// Args are the daemon's command-line arguments, after the program name
+	Args []string `json:"args,omitempty"`
+	// Systemd is true if the daemon was started by systemd (see 'tg
+	// install-service')
+	Systemd bool `json:"systemd,omitempty"`
+}
+
+// StatusResult is the result of MethodStatus
+type StatusResult struct {
+	// Project is the project with which the most recent tick was associated
+	Project string `json:"project"`
+	// LatestTick is the time of the most recent tick
+	LatestTick time.Time `json:"latest_tick"`
+	// TimeEntryID is the ID of the open Toggl time entry, or 0 if none
+	TimeEntryID int64 `json:"time_entry_id"`
+	// LocalOnly is true if the daemon only records time entries locally
+	// (see config.BackendLocal)
+	LocalOnly bool `json:"local_only,omitempty"`
+	// Tracker is the time tracker other than Toggl in which the daemon records
+	// time entries, if any (see config.BackendClockify), and TrackerEntryID is
+	// the ID of the open entry there (if TimeEntryID is status.TrackerEntryID)
+	Tracker        string `json:"tracker,omitempty"`
+	TrackerEntryID string `json:"tracker_entry_id,omitempty"`
+	// Detour is true if the user is on a detour (see 'tg detour')
+	Detour bool `json:"detour,omitempty"`
+	// DetourProject is the project to which detour work is attributed, if any
+	DetourProject string `json:"detour_project,omitempty"`
+	// Paused is true if tracking is paused by 'tg run'
+	Paused bool `json:"paused,omitempty"`
+	// PausedProject is the project to which writes are attributed while
+	// tracking is paused, if any
+	PausedProject string `json:"paused_project,omitempty"`
+	// Suspended is true if tracking is suspended by 'tg pause', until
+	// SuspendedUntil if it's set
+	Suspended      bool      `json:"suspended,omitempty"`
+	SuspendedUntil time.Time `json:"suspended_until"`
+	// Pending is the number of Toggl updates queued because Toggl couldn't be
+	// reached
+	Pending int `json:"pending,omitempty"`
+	// Watches maps each watched directory to its project
+	Watches map[string]string `json:"watches"`
+	// Broken maps each watched directory that was moved away (or is missing)
+	// to its project. They aren't watched until they're watched again or
+	// removed
+	Broken map[string]string `json:"broken,omitempty"`
+}
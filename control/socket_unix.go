@@ -0,0 +1,44 @@
+//go:build !windows
+
+package control
+
+import (
+	"fmt"
+	"os"
+)
+
+// PrepareDir sets the permissions of 'dir', which contains the control socket,
+// so that the peers permitted by 'a' can reach the socket: it's private to
+// the owner unless 'a' has a ReadGID, whose members may also traverse it (but
+// not list its contents)
+func (a ACL) PrepareDir(dir string) error {
+	mode := os.FileMode(0700)
+	if a.ReadGID >= 0 {
+		if err := os.Chown(dir, -1, a.ReadGID); err != nil {
+			return fmt.Errorf("could not set group of %q to %d: %v", dir, a.ReadGID, err)
+		}
+		mode = 0710
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return fmt.Errorf("could not set permissions of %q: %v", dir, err)
+	}
+	return nil
+}
+
+// PrepareSocket sets the permissions of the socket file at 'path' so that
+// only the peers permitted by 'a' can connect to it. The ACL is still
+// enforced per-method by Authorize, since group members who can connect only
+// get read access
+func (a ACL) PrepareSocket(path string) error {
+	mode := os.FileMode(0600)
+	if a.ReadGID >= 0 {
+		if err := os.Chown(path, -1, a.ReadGID); err != nil {
+			return fmt.Errorf("could not set group of %q to %d: %v", path, a.ReadGID, err)
+		}
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("could not set permissions of %q: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package credentials finds the Toggl API token that tg uses to authenticate
+// to Toggl. The token is read, in order of precedence, from:
+//  1. The TOGGL_API_TOKEN environment variable
+//  2. The file 'credentials' in tg's state directory
+//  3. The system keyring (via libsecret's 'secret-tool'), if available
+//
+// Users with limited-scope tokens may also configure separate read and write
+// tokens (see Scope), which take precedence over the general token for
+// requests in their scope.
+//
+// Tokens for time trackers other than Toggl (e.g. Clockify; see
+// TrackerToken) and for the Toggl accounts of profiles (see ProfileToken) are
+// stored alongside it, each under its own scope
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+const (
+	// TokenEnvVar is the environment variable from which the API token is read
+	TokenEnvVar = "TOGGL_API_TOKEN"
+
+	// credentialsFile is the name of the file in tg's state directory where the
+	// API token may be stored
+	credentialsFile = "credentials"
+
+	// keyringService and keyringAccount are the attributes under which the API
+	// token is stored in the system keyring
+	keyringService = "toggl-watcher"
+	keyringAccount = "api-token"
+)
+
+// Scope identifies the operations that a token is used for
+type Scope string
+
+const (
+	// AnyScope is the scope of the general API token, used for all requests
+	// that don't have a token of their own
+	AnyScope Scope = ""
+	// ReadScope tokens are used for requests that only read from Toggl
+	ReadScope Scope = "read"
+	// WriteScope tokens are used for requests that modify Toggl (e.g. creating
+	// or stopping time entries)
+	WriteScope Scope = "write"
+)
+
+// ParseScope parses a Scope from the value of a flag
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(strings.ToLower(s)); scope {
+	case AnyScope, ReadScope, WriteScope:
+		return scope, nil
+	}
+	return AnyScope, fmt.Errorf("invalid token scope %q (must be \"read\" or \"write\")", s)
+}
+
+// envVar returns the environment variable from which tokens with 'scope' are
+// read (e.g. TOGGL_API_READ_TOKEN)
+func (s Scope) envVar() string {
+	if s == AnyScope {
+		return TokenEnvVar
+	}
+	return "TOGGL_API_" + strings.ToUpper(string(s)) + "_TOKEN"
+}
+
+// suffix returns the suffix of the credentials file and keyring account in
+// which tokens with 'scope' are stored (e.g. "credentials.read")
+func (s Scope) suffix(sep string) string {
+	if s == AnyScope {
+		return ""
+	}
+	return sep + string(s)
+}
+
+// ErrNoToken is returned by Token if no API token is configured anywhere
+var ErrNoToken = errors.New("no Toggl API token found; set " + TokenEnvVar +
+	" or run 'tg login'")
+
+// Token returns the Toggl API token, searching the environment, the
+// credentials file in 'tgStateDir', and the system keyring (in that order)
+func Token(tgStateDir string) (string, error) {
+	token, err := lookup(tgStateDir, AnyScope)
+	if err != nil || token != "" {
+		return token, err
+	}
+	return "", ErrNoToken
+}
+
+// Tokens returns the tokens that tg should use for read and write requests.
+// Each is the token configured for that scope if there is one, and otherwise
+// the general token. If only a write token is configured, it's also used for
+// reads (a token that can modify Toggl can read it too). If only a read token
+// is configured, 'write' is empty
+func Tokens(tgStateDir string) (read, write string, err error) {
+	general, err := lookup(tgStateDir, AnyScope)
+	if err != nil {
+		return "", "", err
+	}
+	if read, err = lookup(tgStateDir, ReadScope); err != nil {
+		return "", "", err
+	}
+	if write, err = lookup(tgStateDir, WriteScope); err != nil {
+		return "", "", err
+	}
+	if write == "" {
+		write = general
+	}
+	if read == "" {
+		read = write
+	}
+	if read == "" {
+		return "", "", ErrNoToken
+	}
+	return read, write, nil
+}
+
+// lookup returns the token for 'scope' from the environment, the credentials
+// file in 'tgStateDir', or the system keyring (in that order), or "" if there
+// is none
+func lookup(tgStateDir string, scope Scope) (string, error) {
+	if token, ok := os.LookupEnv(scope.envVar()); ok && strings.TrimSpace(token) != "" {
+		return strings.TrimSpace(token), nil
+	}
+	token, err := readFile(tgStateDir, scope)
+	if err != nil || token != "" {
+		return token, err
+	}
+	if token, err := readKeyring(scope); err == nil && token != "" {
+		return token, nil
+	}
+	return "", nil
+}
+
+// TrackerScope returns the scope under which the API token for the time
+// tracker 'tracker' (e.g. "clockify") is stored by Save
+func TrackerScope(tracker string) Scope {
+	return Scope(strings.ToLower(tracker))
+}
+
+// TrackerToken returns the API token for the time tracker 'tracker' other than
+// Toggl (e.g. "clockify"), from the environment variable <TRACKER>_API_TOKEN,
+// the token stored for TrackerScope(tracker) in 'tgStateDir', or the system
+// keyring (in that order)
+func TrackerToken(tgStateDir, tracker string) (string, error) {
+	envVar := strings.ToUpper(tracker) + "_API_TOKEN"
+	if token, ok := os.LookupEnv(envVar); ok && strings.TrimSpace(token) != "" {
+		return strings.TrimSpace(token), nil
+	}
+	token, err := readFile(tgStateDir, TrackerScope(tracker))
+	if err != nil || token != "" {
+		return token, err
+	}
+	if token, err := readKeyring(TrackerScope(tracker)); err == nil && token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no %s API token found; set %s or run 'tg login "+
+		"--tracker %s'", tracker, envVar, tracker)
+}
+
+// ProfileScope returns the scope under which the Toggl API token of the
+// profile 'name' is stored by Save
+func ProfileScope(name string) Scope {
+	return Scope("profile-" + strings.ToLower(name))
+}
+
+// ProfileEnvVar returns the environment variable from which the Toggl API
+// token of the profile 'name' is read (e.g. TOGGL_API_TOKEN_CLIENT_A)
+func ProfileEnvVar(name string) string {
+	return TokenEnvVar + "_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// ProfileToken returns the Toggl API token of the profile 'name', from the
+// environment variable ProfileEnvVar(name), the token stored for
+// ProfileScope(name) in 'tgStateDir', or the system keyring (in that order)
+func ProfileToken(tgStateDir, name string) (string, error) {
+	if token, ok := os.LookupEnv(ProfileEnvVar(name)); ok && strings.TrimSpace(token) != "" {
+		return strings.TrimSpace(token), nil
+	}
+	token, err := readFile(tgStateDir, ProfileScope(name))
+	if err != nil || token != "" {
+		return token, err
+	}
+	if token, err := readKeyring(ProfileScope(name)); err == nil && token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no Toggl API token found for profile %q; set %s or "+
+		"run 'tg login --profile %s'", name, ProfileEnvVar(name), name)
+}
+
+// readFile reads the API token for 'scope' from its credentials file in
+// 'tgStateDir'. If the file doesn't exist, it returns "" and no error
+func readFile(tgStateDir string, scope Scope) (string, error) {
+	credsPath := path.Join(tgStateDir, credentialsFile+scope.suffix("."))
+	data, err := ioutil.ReadFile(credsPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("could not read credentials file %q: %v", credsPath, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readKeyring reads the API token for 'scope' from the system keyring
+func readKeyring(scope Scope) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup",
+		"service", keyringService, "account", keyringAccount+scope.suffix("-")).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Save stores 'token' for future invocations of tg, as the token for 'scope'.
+// If 'useKeyring' is true, the token is stored in the system keyring;
+// otherwise it's written to a credentials file in 'tgStateDir' (readable only
+// by the current user)
+func Save(tgStateDir string, scope Scope, token string, useKeyring bool) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return fmt.Errorf("cannot save empty API token")
+	}
+	if useKeyring {
+		cmd := exec.Command("secret-tool", "store", "--label=toggl-watcher API token",
+			"service", keyringService, "account", keyringAccount+scope.suffix("-"))
+		cmd.Stdin = strings.NewReader(token)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("could not store API token in keyring: %v (%s)",
+				err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	if err := persist.MakeStateDir(tgStateDir); err != nil {
+		return err
+	}
+	credsPath := path.Join(tgStateDir, credentialsFile+scope.suffix("."))
+	if err := persist.WriteFile(credsPath, []byte(token+"\n"), 0600); err != nil {
+		return fmt.Errorf("could not write credentials file %q: %v", credsPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTokenPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// File is used if the env var is unset
+	os.Unsetenv(TokenEnvVar)
+	if err := Save(dir, AnyScope, " file-token\n", false); err != nil {
+		t.Fatalf("could not save token: %v", err)
+	}
+	if token, err := Token(dir); err != nil || token != "file-token" {
+		t.Fatalf("expected \"file-token\", but got %q (%v)", token, err)
+	}
+
+	// Env var takes precedence over the file
+	os.Setenv(TokenEnvVar, "env-token")
+	defer os.Unsetenv(TokenEnvVar)
+	if token, err := Token(dir); err != nil || token != "env-token" {
+		t.Fatalf("expected \"env-token\", but got %q (%v)", token, err)
+	}
+}
+
+func TestSaveEmptyToken(t *testing.T) {
+	if err := Save(os.TempDir(), AnyScope, "  ", false); err == nil {
+		t.Fatalf("expected error saving empty token")
+	}
+}
+
+func TestScopedTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv(TokenEnvVar)
+
+	// With only a read token, there's no token for writes
+	if err := Save(dir, ReadScope, "read-token", false); err != nil {
+		t.Fatalf("could not save token: %v", err)
+	}
+	if read, write, err := Tokens(dir); err != nil || read != "read-token" || write != "" {
+		t.Fatalf("expected (\"read-token\", \"\"), but got (%q, %q) (%v)", read, write, err)
+	}
+
+	// The general token is used for writes if there's no write token
+	if err := Save(dir, AnyScope, "general-token", false); err != nil {
+		t.Fatalf("could not save token: %v", err)
+	}
+	if read, write, err := Tokens(dir); err != nil || read != "read-token" || write != "general-token" {
+		t.Fatalf("expected (\"read-token\", \"general-token\"), but got (%q, %q) (%v)",
+			read, write, err)
+	}
+
+	// Scoped env vars take precedence over files
+	os.Setenv(WriteScope.envVar(), "env-write-token")
+	defer os.Unsetenv(WriteScope.envVar())
+	if read, write, err := Tokens(dir); err != nil || read != "read-token" || write != "env-write-token" {
+		t.Fatalf("expected (\"read-token\", \"env-write-token\"), but got (%q, %q) (%v)",
+			read, write, err)
+	}
+}
+
+func TestTrackerToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv("CLOCKIFY_API_TOKEN")
+	if _, err := TrackerToken(dir, "clockify"); err == nil {
+		t.Fatalf("expected an error with no clockify token")
+	}
+
+	// Tracker tokens are kept apart from the Toggl token
+	if err := Save(dir, TrackerScope("clockify"), "clockify-key", false); err != nil {
+		t.Fatalf("could not save token: %v", err)
+	}
+	if token, err := TrackerToken(dir, "clockify"); err != nil || token != "clockify-key" {
+		t.Fatalf("expected \"clockify-key\", but got %q (%v)", token, err)
+	}
+	os.Unsetenv(TokenEnvVar)
+	if _, err := Token(dir); err != ErrNoToken {
+		t.Fatalf("expected no Toggl token, but got %v", err)
+	}
+	os.Setenv("CLOCKIFY_API_TOKEN", "env-key")
+	defer os.Unsetenv("CLOCKIFY_API_TOKEN")
+	if token, err := TrackerToken(dir, "clockify"); err != nil || token != "env-key" {
+		t.Fatalf("expected \"env-key\", but got %q (%v)", token, err)
+	}
+}
+
+func TestProfileToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv("TOGGL_API_TOKEN_CLIENT_A")
+	if _, err := ProfileToken(dir, "client-a"); err == nil {
+		t.Fatalf("expected an error with no token for the profile")
+	}
+
+	// Profile tokens are kept apart from the default Toggl token
+	if err := Save(dir, ProfileScope("client-a"), "client-a-token", false); err != nil {
+		t.Fatalf("could not save token: %v", err)
+	}
+	if token, err := ProfileToken(dir, "client-a"); err != nil || token != "client-a-token" {
+		t.Fatalf("expected \"client-a-token\", but got %q (%v)", token, err)
+	}
+	os.Unsetenv(TokenEnvVar)
+	if _, err := Token(dir); err != ErrNoToken {
+		t.Fatalf("expected no default Toggl token, but got %v", err)
+	}
+	os.Setenv("TOGGL_API_TOKEN_CLIENT_A", "env-token")
+	defer os.Unsetenv("TOGGL_API_TOKEN_CLIENT_A")
+	if token, err := ProfileToken(dir, "client-a"); err != nil || token != "env-token" {
+		t.Fatalf("expected \"env-token\", but got %q (%v)", token, err)
+	}
+}
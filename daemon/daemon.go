@@ -0,0 +1,1249 @@
+// Package daemon implements the long-running tg process (started by 'tg
+// resume'), which watches project directories for writes and turns them into
+// Toggl time entries
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/git"
+	"github.com/msteffen/toggl-watcher/logging"
+	"github.com/msteffen/toggl-watcher/metrics"
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/redact"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/tracing"
+	"github.com/msteffen/toggl-watcher/tracker"
+	"github.com/msteffen/toggl-watcher/window"
+)
+
+// Options configures optional daemon behavior
+type Options struct {
+	// HealthcheckURL, if set, is pinged with every heartbeat (see
+	// status.Heartbeat)
+	HealthcheckURL string
+
+	// HeartbeatInterval is how often the daemon pings HealthcheckURL (see
+	// status.Heartbeat). If "off", it's never pinged. The daemon records that
+	// it's alive regardless (see status.KeepAlive)
+	HeartbeatInterval config.Duration
+
+	// LiveUpdateInterval, if enabled, is how often the open time entry's
+	// description is updated with the time of the latest tick. Ticks between
+	// updates are batched into a single API call
+	LiveUpdateInterval config.Duration
+
+	// DailyNoteDir, if set, is a directory of daily markdown notes (e.g. an
+	// Obsidian vault's daily notes folder). Each stopped time entry is appended
+	// to the note for the day on which it started
+	DailyNoteDir string
+
+	// TraceEndpoint, if set, is the OTLP/HTTP endpoint of an OpenTelemetry
+	// collector (e.g. "http://localhost:4318") to which the daemon exports
+	// traces of each write's path to Toggl
+	TraceEndpoint string
+
+	// MetricsAddr, if set, is the address (e.g. "localhost:9100") at which the
+	// daemon serves its internal metrics (see the metrics package) via expvar
+	MetricsAddr string
+
+	// StatusPort, if set, is the port on localhost at which the daemon serves
+	// its state over HTTP, for status bars and the like (see serveStatus)
+	StatusPort int
+
+	// ControlReadGroup, if set, is the name (or GID) of a group whose members
+	// may call the daemon's read-only control methods, such as status (see
+	// control.ACL). Otherwise only the current user may use the control socket
+	ControlReadGroup string
+
+	// DryRun, if true, puts the daemon in dry-run mode, in which requests that
+	// would modify Toggl are logged instead of sent, and tracking state is kept
+	// apart from the real state (see DryRunDir). It's also set by the config's
+	// dry_run setting
+	DryRun bool
+
+	// Tracker, if set, is the time tracker other than Toggl (e.g. Clockify) in
+	// which the daemon records time entries, per the config's backend setting
+	// (see status.SetTracker). The daemon's Toggl client may then be nil
+	Tracker tracker.TimeTracker
+
+	// Profiles maps the name of each profile in the config (see
+	// config.Profiles) to a client for its Toggl account, to which time
+	// entries for root watches assigned to the profile are sent
+	Profiles map[string]*togglclient.Client
+
+	// LogLevel and LogFormat control the daemon's log, which is written to
+	// stderr and to a file in the state directory (see the logging package)
+	LogLevel  logging.Level
+	LogFormat logging.Format
+}
+
+// DryRunDir is the directory in tgStateDir where a daemon in dry-run mode keeps
+// its tracking state (the open time entry, queued Toggl updates, and recorded
+// activity), so that it can't affect the real state. It's cleared whenever a
+// dry run starts
+const DryRunDir = "dry-run"
+
+const (
+	// idleCheckInterval is how often the daemon checks whether the open time
+	// entry should be stopped because the user has gone idle
+	idleCheckInterval = time.Minute
+
+	// inputIdleCheckInterval is how often the daemon reads the user's input
+	// idle time, if input_idle_timeout is set (see inputIdleStops)
+	inputIdleCheckInterval = 15 * time.Second
+
+	// outboxRetryInterval is how often the daemon checks for queued Toggl
+	// updates that are due to be retried (see status.RetryOutbox)
+	outboxRetryInterval = 15 * time.Second
+
+	// projectRefreshInterval is how often the daemon re-fetches the projects in
+	// the Toggl workspace, to notice projects that were renamed or deleted
+	projectRefreshInterval = 30 * time.Minute
+
+	// writePrecedence is how long after the latest write the focused window's
+	// title is prevented from switching the open time entry to another project
+	writePrecedence = 5 * time.Minute
+)
+
+// daemonLog logs the daemon's progress and any errors it recovers from
+var daemonLog = logging.With("component", "daemon")
+
+// DefaultOptions returns the Options used by the daemon unless configured
+// otherwise
+func DefaultOptions() Options {
+	return Options{
+		HeartbeatInterval: config.Duration(status.DefaultHeartbeatInterval),
+	}
+}
+
+// Daemon connects a status.Watch (which observes writes in project
+// directories) to a status.Status (which turns those writes into Toggl time
+// entries)
+type Daemon struct {
+	// The directory where tg is storing its state
+	tgStateDir string
+	// trackDir is where tracking state is kept: tgStateDir, or its DryRunDir
+	// in dry-run mode
+	trackDir string
+
+	opts Options
+
+	watch *status.Watch
+
+	// mu guards 'status', 'pauses', and the assignment of 'watch' (in Run)
+	mu     sync.Mutex
+	status *status.Status
+
+	// pauses are the 'tg run' invocations that are pausing tracking, and
+	// suspension is set by 'tg pause'. Both are guarded by 'mu'
+	pauses     pauses
+	suspension suspension
+
+	// shell tracks commands run in unwatched directories (see
+	// onShellActivity). Guarded by 'mu'
+	shell shellActivity
+
+	// span, if set, is the span of the tick being processed, in which Toggl API
+	// requests are traced. Guarded by 'mu'
+	span *tracing.Span
+
+	// windowRules and windowInterval configure how the focused window's title
+	// is attributed to projects (see windowTitles), and lastWrite is the time
+	// of the latest write. All are guarded by 'mu'
+	windowRules    []window.Rule
+	windowInterval time.Duration
+	lastWrite      time.Time
+
+	// inputIdleTimeout is the configured input_idle_timeout, and inputIdle is
+	// set while the user has gone that long without input (see
+	// checkInputIdle). Both are guarded by 'mu'
+	inputIdleTimeout time.Duration
+	inputIdle        bool
+
+	// notifications are the kinds of status.Change for which a desktop
+	// notification is shown (see onChange). Guarded by 'mu'
+	notifications map[status.ChangeKind]bool
+
+	// redactions are the global redaction rules in tg's config, which are
+	// applied to descriptions and traced roots after each root's own (see
+	// redactionsFor). Guarded by 'mu'
+	redactions []redact.Rule
+
+	// descriptionTemplate is the description_template setting in tg's config,
+	// used for roots without their own template, and gitBranches is the
+	// git_branches setting. Both are guarded by 'mu'
+	descriptionTemplate string
+	gitBranches         bool
+
+	// minActivity is the min_activity setting in tg's config, used for roots
+	// without their own threshold. Guarded by 'mu'
+	minActivity status.ActivityThreshold
+
+	// pomodoro tracks work streaks for pomodoro mode (see onPomodoroTick).
+	// Guarded by 'mu'
+	pomodoro pomodoro
+
+	// afterHours decides what happens to writes outside working hours.
+	// Guarded by 'mu'
+	afterHours afterHours
+
+	// profileTags are the tags of each profile in tg's config, which are added
+	// to time entries sent to its account. Guarded by 'mu'
+	profileTags map[string][]string
+
+	// started is when Run was called
+	started time.Time
+
+	// reload receives a value when the daemon should reload its watches and
+	// config, as SIGHUP does (see MethodReload)
+	reload chan struct{}
+
+	// stop is closed when the daemon should exit
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// cancel cancels the context of the requests that the daemon sends to
+	// Toggl (see status.SetContext), once Run returns
+	cancel context.CancelFunc
+}
+
+// New restores the watches and tick state persisted in 'tgStateDir' and
+// returns a Daemon that reports work to Toggl using 'client', which may be nil
+// if the config's backend is config.BackendLocal. Watching doesn't begin until
+// Run() is called
+func New(tgStateDir string, client *togglclient.Client, opts Options) (*Daemon, error) {
+	if err := persist.MakeStateDir(tgStateDir); err != nil {
+		return nil, err
+	}
+	var info control.DaemonInfo
+	if err := control.Call(tgStateDir, control.MethodDaemonInfo, nil, &info); err == nil {
+		return nil, fmt.Errorf("the tg daemon is already running (PID %d)", info.PID)
+	}
+	if err := logging.Start(tgStateDir, opts.LogLevel, opts.LogFormat); err != nil {
+		return nil, err
+	}
+	if err := status.MigrateState(tgStateDir); err != nil {
+		return nil, err
+	}
+	localOnly := false
+	if c, err := config.Read(tgStateDir); err == nil {
+		opts.DryRun = opts.DryRun || c.DryRun
+		localOnly = c.Backend == config.BackendLocal
+	}
+	if client == nil && !localOnly && opts.Tracker == nil {
+		return nil, fmt.Errorf("a toggl client is required unless the backend " +
+			"is \"" + config.BackendLocal + "\" or another tracker is set")
+	}
+	if opts.DryRun && opts.Tracker != nil {
+		return nil, fmt.Errorf("dry runs are only supported with the %q backend, "+
+			"not %q", config.BackendToggl, opts.Tracker.Name())
+	}
+	trackDir := tgStateDir
+	if opts.DryRun {
+		trackDir = filepath.Join(tgStateDir, DryRunDir)
+		if err := os.RemoveAll(trackDir); err != nil {
+			return nil, fmt.Errorf("could not clear dry-run state: %v", err)
+		}
+		if err := persist.MakeStateDir(trackDir); err != nil {
+			return nil, err
+		}
+		if client != nil {
+			client.SetDryRun(true)
+		}
+		for _, c := range opts.Profiles {
+			c.SetDryRun(true)
+		}
+		daemonLog.Infof("dry run: requests that would modify Toggl are logged "+
+			"instead of sent, and tracking state is kept in %s", trackDir)
+	}
+	s, err := status.Read(trackDir)
+	if _, ok := err.(*persist.CorruptError); ok {
+		daemonLog.Warnf("%v; starting with no tick state", err)
+		s = status.New(trackDir)
+	} else if os.IsNotExist(err) {
+		s = status.New(trackDir) // no work has been tracked yet
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read tick state: %v", err)
+	}
+	s.SetClient(client)
+	for name, c := range opts.Profiles {
+		s.SetProfileClient(name, c)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.SetContext(ctx)
+	if localOnly {
+		s.SetLocalOnly(true)
+		daemonLog.Infof("backend is %q: time entries are only recorded locally",
+			config.BackendLocal)
+	} else if opts.Tracker != nil {
+		s.SetTracker(opts.Tracker)
+		daemonLog.Infof("backend is %q: time entries are recorded there instead "+
+			"of in Toggl", opts.Tracker.Name())
+	}
+	d := &Daemon{
+		tgStateDir: tgStateDir,
+		trackDir:   trackDir,
+		opts:       opts,
+		status:     s,
+		reload:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		cancel:     cancel,
+	}
+	if opts.TraceEndpoint != "" {
+		tracing.Start(opts.TraceEndpoint)
+		if client != nil {
+			client.SetRequestHook(d.traceRequest)
+		}
+		for _, c := range opts.Profiles {
+			c.SetRequestHook(d.traceRequest)
+		}
+	}
+	s.SetChangeCallback(d.onChange)
+	if opts.DailyNoteDir != "" {
+		s.SetStopCallback(func(e status.StoppedEntry) {
+			if err := appendDailyNote(opts.DailyNoteDir, e); err != nil {
+				daemonLog.Errorf("%v", err)
+			}
+		})
+	}
+	return d, nil
+}
+
+// Run starts watching all persisted watch directories and blocks until Stop()
+// is called
+func (d *Daemon) Run() error {
+	defer d.cancel() // abandon requests still in flight in other goroutines
+	d.started = time.Now()
+	if gap, err := status.RecordStartup(d.tgStateDir, time.Now()); err != nil {
+		daemonLog.Errorf("could not record startup: %v", err)
+	} else if gap != nil {
+		daemonLog.Infof("daemon was not running from %s", gap)
+	}
+	go status.KeepAlive(d.tgStateDir, d.stop)
+	if d.opts.HealthcheckURL != "" && d.opts.HeartbeatInterval.Enabled() {
+		go status.Heartbeat(time.Duration(d.opts.HeartbeatInterval),
+			d.opts.HealthcheckURL, d.stop)
+	}
+	if d.opts.LiveUpdateInterval.Enabled() {
+		go d.liveUpdates(time.Duration(d.opts.LiveUpdateInterval))
+	}
+	d.mu.Lock()
+	// Without Toggl, there are no Toggl projects or updates to sync
+	noToggl := d.status.LocalOnly() || d.status.Tracker() != nil
+	if !noToggl {
+		if err := d.status.RefreshProjects(); err != nil {
+			// Projects are fetched again on the first tick
+			daemonLog.Warnf("could not fetch toggl projects: %v", err)
+		}
+	}
+	d.mu.Unlock()
+	go d.idleStops(idleCheckInterval)
+	if !noToggl {
+		go d.outboxRetries(outboxRetryInterval)
+		go d.projectRefreshes(projectRefreshInterval)
+	}
+	go d.windowTitles()
+	go d.inputIdleStops(inputIdleCheckInterval)
+	if d.opts.StatusPort != 0 {
+		go func() {
+			if err := d.serveStatus(d.opts.StatusPort); err != nil {
+				daemonLog.Errorf("could not serve status: %v", err)
+			}
+		}()
+	}
+	if d.opts.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(d.opts.MetricsAddr); err != nil {
+				daemonLog.Errorf("could not serve metrics: %v", err)
+			}
+		}()
+	}
+
+	w, err := d.startWatch()
+	if err != nil {
+		return fmt.Errorf("could not start watching directories: %v", err)
+	}
+	d.mu.Lock()
+	d.watch = w
+	d.mu.Unlock()
+	d.watch.SetCallback(d.onWrite)
+	d.watch.SetLostRootCallback(d.onLostRoot)
+	metrics.WatchDescriptors.Set(func() float64 {
+		return float64(w.WatchDescriptors())
+	})
+	metrics.OpenEntryAge.Set(d.openEntryAge)
+	d.applyConfig()
+	if err := writePID(d.tgStateDir); err != nil {
+		return fmt.Errorf("could not write PID file: %v", err)
+	}
+	defer removePID(d.tgStateDir)
+	daemonLog.Infof("watching directories listed in %s", d.tgStateDir)
+
+	// Serve requests from the tg CLI
+	acl := control.DefaultACL()
+	if d.opts.ControlReadGroup != "" {
+		gid, err := control.LookupGroup(d.opts.ControlReadGroup)
+		if err != nil {
+			return err
+		}
+		acl.ReadGID = gid
+	}
+	server, err := control.Listen(d.tgStateDir, acl)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	d.registerHandlers(server)
+	go func() {
+		if err := server.Serve(); err != nil {
+			daemonLog.Errorf("control socket failed: %v", err)
+		}
+	}()
+	if err := sdNotify("READY=1"); err != nil {
+		daemonLog.Warnf("%v", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go d.watchdog(interval)
+	}
+
+	// SIGHUP (or MethodReload, where there's no SIGHUP) indicates that the
+	// watch state or config file has changed (see Reload). SIGINT and SIGTERM
+	// shut the daemon down
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	for {
+		select {
+		case <-hup:
+			d.requestReload()
+		case <-d.reload:
+			sdNotify("RELOADING=1")
+			if err := d.watch.Reload(); err != nil {
+				daemonLog.Errorf("could not reload watches: %v", err)
+			}
+			d.applyConfig()
+			sdNotify("READY=1")
+		case sig := <-quit:
+			// A second signal kills the daemon, in case shutting down hangs
+			signal.Stop(quit)
+			daemonLog.Infof("received %s; shutting down", sig)
+			d.Stop()
+			sdNotify("STOPPING=1")
+			return d.shutdown()
+		case <-d.stop:
+			sdNotify("STOPPING=1")
+			return d.shutdown()
+		}
+	}
+}
+
+// shutdown stops the open time entry (as if the user had just gone idle),
+// persists the daemon's state, and closes its Watch, so that no time entry is
+// left running and another daemon may start right away
+func (d *Daemon) shutdown() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []string
+	if err := d.status.StopWorking(time.Now()); err != nil {
+		errs = append(errs, fmt.Sprintf("could not stop the open time entry: %v", err))
+	}
+	if err := d.watch.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	daemonLog.Infof("shut down")
+	return nil
+}
+
+// VerifyWatches checks that the directories the daemon watches match those on
+// disk (see status.Watch.Verify)
+func (d *Daemon) VerifyWatches() error {
+	d.mu.Lock()
+	w := d.watch
+	d.mu.Unlock()
+	if w == nil {
+		return fmt.Errorf("the daemon isn't watching any directories yet")
+	}
+	return w.Verify()
+}
+
+// requestReload asks Run to reload the daemon's watches and config. Requests
+// made while one is pending are merged with it
+func (d *Daemon) requestReload() {
+	select {
+	case d.reload <- struct{}{}:
+	default:
+	}
+}
+
+// Stop causes Run() to shut down (see shutdown) and return
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// startWatch starts watching the root watches in d.tgStateDir, with the
+// watch backend set in the config. If that backend can't be used (e.g. the
+// daemon lacks CAP_SYS_ADMIN for fanotify), it falls back to the default
+func (d *Daemon) startWatch() (*status.Watch, error) {
+	c, err := config.Read(d.tgStateDir)
+	if err != nil {
+		daemonLog.Errorf("%v", err) // use the default backend
+	}
+	backend, err := status.ParseBackend(c.WatchBackend)
+	if err != nil {
+		daemonLog.Errorf("%v", err)
+		backend = status.DefaultBackend
+	}
+	w, err := status.StartWithBackend(d.tgStateDir, backend)
+	if err != nil && backend != status.DefaultBackend {
+		daemonLog.Errorf("could not start the %s watch backend (%v); falling "+
+			"back to %s", backend, err, status.DefaultBackend)
+		return status.Start(d.tgStateDir)
+	}
+	return w, err
+}
+
+// applyConfig reads the config file in d.tgStateDir and applies its settings
+// to d.watch and d.status. If there is a candidate config, it's evaluated in
+// shadow mode. If the config file can't be read, the current
+// settings are left in place
+func (d *Daemon) applyConfig() {
+	c, err := config.Read(d.tgStateDir)
+	if err != nil {
+		daemonLog.Errorf("%v", err)
+		return
+	}
+	d.watch.SetBucketSize(time.Duration(c.DebounceWindow))
+	d.watch.SetIgnorePatterns(c.IgnorePatterns)
+	if events, err := status.ParseEventClasses(c.WatchEvents); err != nil {
+		daemonLog.Errorf("%v", err) // keep the current events
+	} else {
+		d.watch.SetDefaultEvents(events)
+	}
+	if candidate, ok, err := config.ReadCandidate(d.tgStateDir); err != nil {
+		daemonLog.Errorf("%v", err)
+	} else if ok {
+		d.watch.SetCanary(candidate.IgnorePatterns, d.recordCanary)
+	} else {
+		d.watch.SetCanary(nil, nil)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status.SetIdleTimeout(time.Duration(c.IdleTimeout))
+	d.status.SetStopGrace(time.Duration(c.StopGrace))
+	d.status.SetBridgeGap(time.Duration(c.BridgeGap))
+	d.status.SetMinSwitchDuration(time.Duration(c.MinSwitchDuration))
+	d.status.SetRequireApproval(c.RequireApproval)
+	d.windowRules = nil
+	for _, w := range c.WindowTitles {
+		rule, err := window.NewRule(w.Pattern, w.Project)
+		if err != nil {
+			daemonLog.Errorf("%v", err)
+			continue
+		}
+		d.windowRules = append(d.windowRules, rule)
+	}
+	d.windowInterval = time.Duration(c.WindowPollInterval)
+	d.inputIdleTimeout = time.Duration(c.InputIdleTimeout)
+	d.notifications = make(map[status.ChangeKind]bool)
+	for _, event := range c.Notifications {
+		d.notifications[notificationKinds[event]] = true
+	}
+	if d.inputIdleTimeout == 0 {
+		d.inputIdle = false
+	}
+	d.redactions = nil
+	for _, r := range c.Redactions {
+		rule, err := redact.NewRule(r.Pattern, r.Replacement)
+		if err != nil {
+			daemonLog.Errorf("%v", err)
+			continue
+		}
+		d.redactions = append(d.redactions, rule)
+	}
+	d.descriptionTemplate = c.DescriptionTemplate
+	d.gitBranches = c.GitBranches
+	d.pomodoro.configure(c)
+	d.profileTags = make(map[string][]string)
+	for name, p := range c.Profiles {
+		d.profileTags[name] = p.Tags
+	}
+	if err := d.afterHours.configure(c); err != nil {
+		daemonLog.Errorf("%v", err) // track writes at all times
+	}
+	if d.minActivity, err = status.ParseActivityThreshold(c.MinActivity); err != nil {
+		daemonLog.Errorf("%v", err) // start entries at the first write
+	}
+	rules := d.redactions
+	logging.SetRedact(func(s string) string { return redact.Apply(rules, s) })
+}
+
+// redactionsFor returns the redaction rules for text derived from a root with
+// the options 'opts': the root's own rules, followed by the global ones. 'mu'
+// must be held
+func (d *Daemon) redactionsFor(opts status.RootOptions) []redact.Rule {
+	rules, err := redact.Compile(opts.Redactions)
+	if err != nil {
+		// Root options are validated when they're set, so this is unexpected
+		daemonLog.Errorf("%v", err)
+	}
+	return append(rules, d.redactions...)
+}
+
+// recordCanary records a write on which the active and candidate configs
+// disagree
+func (d *Daemon) recordCanary(diff status.CanaryDiff) {
+	if err := status.AppendCanary(d.tgStateDir, diff); err != nil {
+		daemonLog.Errorf("%v", err)
+	}
+}
+
+// registerHandlers registers the daemon's implementation of each control
+// method with 'server'
+func (d *Daemon) registerHandlers(server *control.Server) {
+	server.Handle(control.MethodWatch, func(params json.RawMessage) (interface{}, error) {
+		var p control.WatchParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return nil, d.watch.AddWatch(p.Dir, p.Project)
+	})
+	server.Handle(control.MethodWatchBatch, func(params json.RawMessage) (interface{}, error) {
+		var p control.WatchBatchParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return nil, d.watch.AddWatches(p.Watches)
+	})
+	server.Handle(control.MethodUnwatch, func(params json.RawMessage) (interface{}, error) {
+		var p control.UnwatchParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		project, err := d.watch.RemoveWatch(p.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return nil, StopOrphanedEntry(d.status, project, d.watch.Roots())
+	})
+	server.Handle(control.MethodTick, func(params json.RawMessage) (interface{}, error) {
+		var p control.TickParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := d.status.TickWith(p.Project, d.projectEntryOptions(p.Project, p.Profile)); err != nil {
+			return nil, err
+		}
+		return d.statusResult(), nil
+	})
+	server.Handle(control.MethodStop, func(json.RawMessage) (interface{}, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return StopNow(d.status)
+	})
+	server.Handle(control.MethodDetour, func(params json.RawMessage) (interface{}, error) {
+		var p control.DetourParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		SetDetour(d.status, p)
+		return d.statusResult(), d.status.Save()
+	})
+	server.Handle(control.MethodPause, func(params json.RawMessage) (interface{}, error) {
+		var p control.PauseParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if p.PID <= 0 {
+			return nil, fmt.Errorf("invalid pid %d", p.PID)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if p.End {
+			d.pauses.end(p.PID)
+		} else {
+			d.pauses.start(p.PID, p.Project)
+		}
+		return d.statusResult(), nil
+	})
+	server.Handle(control.MethodDaemonInfo, func(json.RawMessage) (interface{}, error) {
+		return control.DaemonInfo{
+			PID:     os.Getpid(),
+			Started: d.started,
+			Args:    os.Args[1:],
+			Systemd: os.Getenv("NOTIFY_SOCKET") != "",
+		}, nil
+	})
+	server.Handle(control.MethodShutdown, func(json.RawMessage) (interface{}, error) {
+		daemonLog.Infof("shutdown requested; shutting down")
+		d.Stop() // Run shuts the daemon down
+		return nil, nil
+	})
+	server.Handle(control.MethodReload, func(json.RawMessage) (interface{}, error) {
+		d.requestReload()
+		return nil, nil
+	})
+	server.Handle(control.MethodSuspend, func(params json.RawMessage) (interface{}, error) {
+		var p control.SuspendParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if p.End {
+			d.suspension.end()
+			daemonLog.Infof("tracking resumed")
+			if p.AfterHours {
+				d.afterHours.allow(time.Now())
+				daemonLog.Infof("tracking writes outside working hours until %s",
+					d.afterHours.allowedUntil.Format(time.RFC3339))
+			}
+			return d.statusResult(), nil
+		}
+		d.suspension.start(p.Until)
+		if p.Until.IsZero() {
+			daemonLog.Infof("tracking suspended")
+		} else {
+			daemonLog.Infof("tracking suspended until %s", p.Until.Format(time.RFC3339))
+		}
+		// Work is no longer being tracked, so the open time entry ends now
+		if _, err := StopNow(d.status); err != nil {
+			return nil, err
+		}
+		return d.statusResult(), nil
+	})
+	server.Handle(control.MethodShellActivity, func(params json.RawMessage) (interface{}, error) {
+		var p control.ShellActivityParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(p.Dir) {
+			return nil, fmt.Errorf("expected an absolute directory, but got %q", p.Dir)
+		}
+		d.onShellActivity(filepath.Clean(p.Dir), time.Now())
+		return nil, nil
+	})
+	server.Handle(control.MethodStatus, func(json.RawMessage) (interface{}, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.statusResult(), nil
+	})
+	server.Handle(control.MethodWatchHealth, func(json.RawMessage) (interface{}, error) {
+		return d.watch.Health(), nil
+	})
+	server.Handle(control.MethodRepair, func(json.RawMessage) (interface{}, error) {
+		return d.watch.Repair()
+	})
+}
+
+// SetDetour starts or ends a detour in 's', per 'p'
+func SetDetour(s *status.Status, p control.DetourParams) {
+	if p.End {
+		s.EndDetour()
+	} else {
+		s.StartDetour(p.Project)
+	}
+}
+
+// StopNow stops the open time entry in 's' (if any) and persists 's'
+func StopNow(s *status.Status) (control.StopResult, error) {
+	result := control.StopResult{TimeEntryID: s.TimeEntryID()}
+	if result.TimeEntryID == 0 {
+		return result, nil
+	}
+	if err := s.Stop(time.Now()); err != nil {
+		return result, err
+	}
+	return result, s.Save()
+}
+
+// StopOrphanedEntry stops the open time entry in 's' if it belongs to
+// 'project' and 'project' is no longer associated with any directory in
+// 'roots' (e.g. because its only directory was just unwatched)
+func StopOrphanedEntry(s *status.Status, project string, roots map[string]string) error {
+	if s.TimeEntryID() == 0 || s.Project() != project {
+		return nil
+	}
+	for _, p := range roots {
+		if p == project {
+			return nil // project is still watched elsewhere
+		}
+	}
+	if err := s.Stop(s.LatestTick()); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+// statusResult returns the daemon's current state. d.mu must be held by the
+// caller
+func (d *Daemon) statusResult() control.StatusResult {
+	detour, detourProject := d.status.Detour()
+	paused, pausedProject := d.pauses.active()
+	suspended := d.suspension.activeAt(time.Now())
+	pending, err := status.PendingOps(d.trackDir)
+	if err != nil {
+		daemonLog.Errorf("%v", err)
+	}
+	return control.StatusResult{
+		Project:        d.status.Project(),
+		LatestTick:     d.status.LatestTick(),
+		TimeEntryID:    d.status.TimeEntryID(),
+		LocalOnly:      d.status.LocalOnly(),
+		Tracker:        trackerName(d.status.Tracker()),
+		TrackerEntryID: d.status.TrackerEntryID(),
+		Detour:         detour,
+		DetourProject:  detourProject,
+		Paused:         paused,
+		PausedProject:  pausedProject,
+		Suspended:      suspended,
+		SuspendedUntil: d.suspension.until,
+		Pending:        pending,
+		Watches:        d.watch.Roots(),
+		Broken:         d.watch.Broken(),
+	}
+}
+
+// trackerName returns the name of 't', or "" if it's nil (i.e. time entries
+// are recorded in Toggl or locally)
+func trackerName(t tracker.TimeTracker) string {
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// liveUpdates pushes the latest tick to the open time entry every 'interval'
+// until the daemon stops
+func (d *Daemon) liveUpdates(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			err := d.status.UpdateLastActive()
+			d.mu.Unlock()
+			if err != nil {
+				daemonLog.Errorf("%v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// openEntryAge returns the age of the open time entry in seconds, or 0 if
+// there is none (see metrics.OpenEntryAge)
+func (d *Daemon) openEntryAge() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.TimeEntryID() == 0 || d.status.EntryStart().IsZero() {
+		return 0
+	}
+	return time.Since(d.status.EntryStart()).Seconds()
+}
+
+// idleStops stops the open time entry once the user has been idle for too
+// long, checking every 'interval' until the daemon stops. Without this, an
+// entry would only be stopped by the next tick, which may never come
+func (d *Daemon) idleStops(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.mu.Lock()
+			_, err := d.status.StopIfIdle(now)
+			d.mu.Unlock()
+			if err != nil {
+				daemonLog.Errorf("could not stop idle time entry: %v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// outboxRetries replays Toggl updates that were queued while Toggl was
+// unreachable, checking every 'interval' until the daemon stops
+func (d *Daemon) outboxRetries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.mu.Lock()
+			err := d.status.RetryOutbox(now)
+			d.mu.Unlock()
+			if err != nil {
+				daemonLog.Errorf("%v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// projectRefreshes re-fetches the projects in the Toggl workspace every
+// 'interval' until the daemon stops
+func (d *Daemon) projectRefreshes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			err := d.status.RefreshProjects()
+			d.mu.Unlock()
+			if err != nil {
+				daemonLog.Errorf("could not refresh toggl projects: %v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// onWrite is called by d.watch with the writes observed in each project
+func (d *Daemon) onWrite(e status.WriteEvent) {
+	var span *tracing.Span // spans the path from the first write to Toggl
+	if tracing.Enabled() {
+		span = tracing.New("write", e.Start)
+		debounce := span.Child("debounce", e.Start)
+		debounce.SetAttr("events", strconv.Itoa(e.Count))
+		debounce.End(time.Now())
+		defer func() { span.End(time.Now()) }()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	opts := d.watch.Options(e.Root)
+	redactions := d.redactionsFor(opts)
+	span.SetAttr("root", redact.Apply(redactions, e.Root))
+	attribution := span.Child("attribution", time.Now())
+	if d.suspension.activeAt(time.Now()) {
+		attribution.SetAttr("suspended", "true")
+		attribution.End(time.Now())
+		return // tracking is suspended by 'tg pause'
+	}
+	if d.inputIdle {
+		attribution.SetAttr("input_idle", "true")
+		attribution.End(time.Now())
+		return // e.g. an editor autosaving while the user is away
+	}
+	paused, project := d.pauses.active()
+	if paused && project == "" {
+		attribution.SetAttr("paused", "true")
+		attribution.End(time.Now())
+		return // tracking is paused by 'tg run'
+	} else if paused {
+		e.Project = project
+	}
+	afterHours := d.afterHours.outside(e.End)
+	if afterHours && d.afterHours.mode != config.AfterHoursTag {
+		attribution.SetAttr("after_hours", "true")
+		attribution.End(time.Now())
+		d.onAfterHours(e.Project, e.End)
+		return // e.g. a build running at night
+	}
+	attribution.SetAttr("project", e.Project)
+	attribution.End(time.Now())
+	d.lastWrite = e.End
+	// Record the bucket locally first, so that it's kept even if Toggl is
+	// unreachable
+	b := status.Bucket{Project: e.Project, Root: e.Root, Start: e.Start, End: e.End,
+		Files: e.Files, TopFiles: e.TopFiles}
+	if err := status.AppendActivity(d.trackDir, b); err != nil {
+		daemonLog.Errorf("%v", err)
+	}
+	d.onPomodoroTick(e.End)
+	d.span = span.Child("tick", time.Now())
+	branch := writeBranch(e)
+	desc, err := opts.Description(d.descriptionTemplate, status.DescriptionData{
+		Project:   e.Project,
+		Dir:       e.Root,
+		GitBranch: branch,
+		Files:     e.Files,
+		TopFiles:  strings.Join(e.TopFiles, ", "),
+	})
+	if err != nil {
+		daemonLog.Errorf("%v", err) // start the entry without one
+	}
+	entryOpts := status.EntryOptions{
+		Description: redact.Apply(redactions, desc),
+		Tags:        opts.Tags,
+		Billable:    opts.Billable,
+		MinActivity: opts.Threshold(d.minActivity),
+		Workspace:   opts.Workspace,
+		Client:      opts.Client,
+		Profile:     opts.Profile,
+	}
+	if d.gitBranches && branch != "" {
+		entryOpts.Branch = branch
+		entryOpts.Tags = append(append([]string(nil), opts.Tags...),
+			redact.Apply(redactions, branch))
+	}
+	if tags := d.profileTags[opts.Profile]; len(tags) > 0 {
+		entryOpts.Tags = append(append([]string(nil), entryOpts.Tags...), tags...)
+	}
+	if afterHours {
+		entryOpts.Tags = append(append([]string(nil), entryOpts.Tags...), d.afterHours.tag)
+	}
+	if d.pomodoro.tag != "" && d.pomodoro.focused(e.End) {
+		entryOpts.Tags = append(append([]string(nil), entryOpts.Tags...), d.pomodoro.tag)
+	}
+	err = d.status.TickWith(e.Project, entryOpts)
+	d.span.SetError(err)
+	d.span.End(time.Now())
+	d.span = nil
+	if err != nil {
+		metrics.Errors.Add("tick", 1)
+		daemonLog.Errorf("could not record tick for %q: %v", e.Project, err)
+	}
+}
+
+// writeBranch returns the git branch checked out where the writes in 'e'
+// happened (the repository containing the latest written path, which may be
+// nested under the root), or "" if they weren't in a git repository
+func writeBranch(e status.WriteEvent) string {
+	dir := e.Root
+	if e.Path != "" {
+		dir = filepath.Dir(e.Path)
+	}
+	branch, err := git.Branch(dir)
+	if err != nil {
+		daemonLog.Warnf("could not read git branch of %q: %v", dir, err)
+	}
+	return branch
+}
+
+// onLostRoot is called by d.watch when a root watch's directory is deleted or
+// moved away. If that leaves the open time entry's project without any watched
+// directory, the entry is stopped
+func (d *Daemon) onLostRoot(r status.LostRoot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := StopOrphanedEntry(d.status, r.Project, d.watch.Roots()); err != nil {
+		daemonLog.Errorf("could not stop the time entry for %q: %v", r.Project, err)
+	}
+}
+
+// windowTitles polls the focused window's title while any window titles are
+// configured, and ticks the project matching the title while the user is
+// active in the window, until the daemon stops
+func (d *Daemon) windowTitles() {
+	poller := window.NewPoller()
+	var lastErr string // only log each distinct error once
+	for {
+		d.mu.Lock()
+		rules, interval := d.windowRules, d.windowInterval
+		d.mu.Unlock()
+		if len(rules) > 0 && interval > 0 {
+			now := time.Now()
+			title, active, err := poller.Poll(interval)
+			if err != nil {
+				if err.Error() != lastErr {
+					daemonLog.Warnf("could not read the focused window: %v", err)
+				}
+				lastErr = err.Error()
+			} else {
+				lastErr = ""
+				if project := window.Match(rules, title); active && project != "" {
+					d.onWindow(project, now.Add(-interval), now)
+				}
+			}
+		} else {
+			interval = time.Minute // check for new config periodically
+		}
+		select {
+		case <-time.After(interval):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// onWindow records activity in 'project' between 'start' and 'end', observed
+// in the focused window's title. Unlike writes, window titles don't switch the
+// open time entry to another project within writePrecedence of a write
+func (d *Daemon) onWindow(project string, start, end time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.suspension.activeAt(end) {
+		return // tracking is suspended by 'tg pause'
+	}
+	paused, pausedProject := d.pauses.active()
+	if paused && pausedProject == "" {
+		return // tracking is paused by 'tg run'
+	} else if paused {
+		project = pausedProject
+	}
+	if d.afterHours.outside(end) && d.afterHours.mode != config.AfterHoursTag {
+		return // window titles alone don't ask whether to track after hours
+	}
+	if project != d.status.Project() && end.Sub(d.lastWrite) < writePrecedence {
+		return
+	}
+	b := status.Bucket{Project: project, Source: status.SourceWindow, Start: start,
+		End: end}
+	if err := status.AppendActivity(d.trackDir, b); err != nil {
+		daemonLog.Errorf("%v", err)
+	}
+	d.onPomodoroTick(end)
+	if err := d.status.TickWith(project, d.projectEntryOptions(project, "")); err != nil {
+		metrics.Errors.Add("tick", 1)
+		daemonLog.Errorf("could not record tick for %q: %v", project, err)
+	}
+}
+
+// projectEntryOptions returns the options with which to record activity in
+// 'project' that wasn't observed under a particular root (window titles and
+// 'tg tick'): those of a root watch of the project, so that the activity is in
+// the same workspace, client and profile as writes there (see
+// pickEntryOptions). If 'profile' is set (e.g. by 'tg tick --profile'), only
+// roots in that profile are considered. d.mu must be held
+func (d *Daemon) projectEntryOptions(project, profile string) status.EntryOptions {
+	var roots []status.RootWatch
+	for dir, p := range d.watch.Roots() {
+		if p == project {
+			roots = append(roots, status.RootWatch{Dir: dir, Project: p,
+				RootOptions: d.watch.Options(dir)})
+		}
+	}
+	return pickEntryOptions(d.status, project, profile, roots, d.rootEntryOptions)
+}
+
+// pickEntryOptions returns the options of the root watch of 'project' in
+// 'roots' with which to record activity in 'project', computed by
+// 'entryOptions'. If several roots are watched for the project, one whose
+// options match the open time entry in 's' is preferred, and otherwise the
+// first (by path). If 'profile' is set, only roots in that profile are
+// considered, and if there are none, the activity is still recorded in it
+func pickEntryOptions(s *status.Status, project, profile string, roots []status.RootWatch,
+	entryOptions func(project, dir string, opts status.RootOptions) status.EntryOptions) status.EntryOptions {
+	var dirs []string
+	byDir := make(map[string]status.RootOptions)
+	for _, rw := range roots {
+		if rw.Project == project && (profile == "" || rw.Profile == profile) {
+			dirs = append(dirs, rw.Dir)
+			byDir[rw.Dir] = rw.RootOptions
+		}
+	}
+	sort.Strings(dirs)
+	if len(dirs) == 0 && profile != "" {
+		result := entryOptions(project, "", status.RootOptions{Profile: profile})
+		result.Description = "" // there's no root to describe
+		return result
+	}
+	var result status.EntryOptions
+	for i, dir := range dirs {
+		opts := entryOptions(project, dir, byDir[dir])
+		if s.SameProject(project, opts) {
+			return opts
+		} else if i == 0 {
+			result = opts
+		}
+	}
+	return result
+}
+
+// rootEntryOptions returns the options of time entries in 'project' started
+// by activity attributed to the root 'dir', whose options are 'opts'
+func (d *Daemon) rootEntryOptions(project, dir string, opts status.RootOptions) status.EntryOptions {
+	return entryOptions(project, dir, opts, d.descriptionTemplate,
+		d.redactionsFor(opts), d.profileTags[opts.Profile])
+}
+
+// entryOptions returns the options of time entries in 'project' started by
+// activity attributed to the root 'dir', whose options are 'opts'. Their
+// description is rendered from 'template' (unless the root has its own) and
+// redacted with 'redactions', and 'profileTags' (the tags of the root's
+// profile) are added to the root's tags
+func entryOptions(project, dir string, opts status.RootOptions, template string,
+	redactions []redact.Rule, profileTags []string) status.EntryOptions {
+	desc, err := opts.Description(template, status.DescriptionData{
+		Project: project,
+		Dir:     dir,
+	})
+	if err != nil {
+		daemonLog.Errorf("%v", err) // start the entry without one
+	}
+	result := status.EntryOptions{
+		Description: redact.Apply(redactions, desc),
+		Tags:        opts.Tags,
+		Billable:    opts.Billable,
+		Workspace:   opts.Workspace,
+		Client:      opts.Client,
+		Profile:     opts.Profile,
+	}
+	if len(profileTags) > 0 {
+		result.Tags = append(append([]string(nil), result.Tags...), profileTags...)
+	}
+	return result
+}
+
+// ProjectEntryOptions returns the options with which 'tg tick' records
+// activity in 'project' in 's' when the daemon isn't running, as the daemon
+// would (see projectEntryOptions): those of one of 'roots' (in 'profile', if
+// it's set), with tg's config 'c'
+func ProjectEntryOptions(s *status.Status, c config.Config, roots []status.RootWatch,
+	project, profile string) status.EntryOptions {
+	var global []redact.Rule
+	for _, r := range c.Redactions {
+		rule, err := redact.NewRule(r.Pattern, r.Replacement)
+		if err != nil {
+			daemonLog.Errorf("%v", err)
+			continue
+		}
+		global = append(global, rule)
+	}
+	return pickEntryOptions(s, project, profile, roots,
+		func(project, dir string, opts status.RootOptions) status.EntryOptions {
+			rules, err := redact.Compile(opts.Redactions)
+			if err != nil {
+				daemonLog.Errorf("%v", err)
+			}
+			return entryOptions(project, dir, opts, c.DescriptionTemplate,
+				append(rules, global...), c.Profiles[opts.Profile].Tags)
+		})
+}
+
+// traceRequest records a span for a Toggl API request (see
+// togglclient.SetRequestHook) within d.span, if it's set. It's only called
+// while d.status is in use, so d.mu is held
+func (d *Daemon) traceRequest(method, path string, start time.Time, err error) {
+	s := d.span.Child("toggl "+method, start)
+	s.SetAttr("http.path", path)
+	s.SetError(err)
+	s.End(time.Now())
+}
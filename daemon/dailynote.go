@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// dailyNoteLayout is the time layout of daily note file names. It matches the
+// default used by Obsidian's daily notes plugin
+const dailyNoteLayout = "2006-01-02"
+
+// appendDailyNote appends a line describing 'e' to the daily note in 'dir' for
+// the day on which 'e' started, creating the note if necessary
+func appendDailyNote(dir string, e status.StoppedEntry) error {
+	start, stop := e.Start.Local(), e.Stop.Local()
+	notePath := filepath.Join(dir, start.Format(dailyNoteLayout)+".md")
+	f, err := os.OpenFile(notePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open daily note at %q: %v", notePath, err)
+	}
+	line := fmt.Sprintf("- %s-%s **%s**", start.Format("15:04"), stop.Format("15:04"),
+		e.Project)
+	if e.Description != "" {
+		line += " " + e.Description
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write to daily note at %q: %v", notePath, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+func TestAppendDailyNote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daily-notes-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2019, 3, 4, 9, 30, 0, 0, time.Local)
+	entries := []status.StoppedEntry{
+		{Start: start, Stop: start.Add(time.Hour), Project: "a", Description: "review"},
+		{Start: start.Add(2 * time.Hour), Stop: start.Add(3 * time.Hour), Project: "b"},
+	}
+	for _, e := range entries {
+		if err := appendDailyNote(dir, e); err != nil {
+			t.Fatalf("could not append to daily note: %v", err)
+		}
+	}
+	note, err := ioutil.ReadFile(filepath.Join(dir, "2019-03-04.md"))
+	if err != nil {
+		t.Fatalf("could not read daily note: %v", err)
+	}
+	expected := "- 09:30-10:30 **a** review\n- 11:30-12:30 **b**\n"
+	if string(note) != expected {
+		t.Fatalf("expected daily note:\n%s\nbut got:\n%s", expected, note)
+	}
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// healthzTimeout is how long /healthz waits for the daemon's lock before
+// reporting that the daemon is wedged
+const healthzTimeout = 5 * time.Second
+
+// httpStatus is the response to /status
+type httpStatus struct {
+	// Tracking is true if a time entry is open
+	Tracking bool   `json:"tracking"`
+	Project  string `json:"project,omitempty"`
+	// Started is when the open time entry began, and ElapsedSeconds is the
+	// time since then
+	Started        *time.Time `json:"started,omitempty"`
+	ElapsedSeconds int64      `json:"elapsed_seconds"`
+	Detour         bool       `json:"detour,omitempty"`
+	Paused         bool       `json:"paused,omitempty"`
+	Suspended      bool       `json:"suspended,omitempty"`
+}
+
+// httpWatches is the response to /watches
+type httpWatches struct {
+	Watches map[string]string `json:"watches"`
+	Broken  map[string]string `json:"broken,omitempty"`
+}
+
+// serveStatus serves the daemon's state over HTTP on localhost:'port' until
+// the daemon exits
+func (d *Daemon) serveStatus(port int) error {
+	addr := "localhost:" + strconv.Itoa(port)
+	daemonLog.Infof("serving status at http://%s/status", addr)
+	return http.ListenAndServe(addr, d.statusHandler(port))
+}
+
+// localOnly wraps 'h' so that it only serves requests addressed to localhost
+// at 'port'. Checking the Host header stops web pages from reading the
+// daemon's state via DNS rebinding (pointing a name they control at
+// 127.0.0.1). Requests with an Origin header are refused too: they're made
+// by browsers on behalf of web pages (or extensions), which the server isn't
+// meant for, so it sends no CORS headers
+func localOnly(port int, h http.Handler) http.Handler {
+	p := strconv.Itoa(port)
+	allowed := map[string]bool{
+		"localhost:" + p: true,
+		"127.0.0.1:" + p: true,
+		"[::1]:" + p:     true,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[strings.ToLower(r.Host)] {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// readOnly wraps 'h' so that it only serves GET and HEAD requests
+func readOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeJSON writes 'v' to 'w' as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		daemonLog.Warnf("could not write HTTP response: %v", err)
+	}
+}
+
+// statusHandler returns the handler of the daemon's HTTP server on
+// localhost:'port' (see localOnly):
+//   - /status reports the open time entry. With ?format=text, it's a single
+//     line (e.g. "tg 1:05"), or an empty line if nothing is tracked, which
+//     status bars can show as is
+//   - /watches reports the watched directories and their projects
+//   - /healthz responds "ok" unless the daemon is wedged
+func (d *Daemon) statusHandler(port int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		d.mu.Lock()
+		s := httpStatus{
+			Tracking:  d.status.TimeEntryID() != 0,
+			Suspended: d.suspension.activeAt(now),
+		}
+		s.Detour, _ = d.status.Detour()
+		s.Paused, _ = d.pauses.active()
+		if s.Tracking {
+			s.Project = d.status.Project()
+			if started := d.status.EntryStart(); !started.IsZero() {
+				s.Started = &started
+				s.ElapsedSeconds = int64(now.Sub(started) / time.Second)
+			}
+		}
+		d.mu.Unlock()
+		if r.URL.Query().Get("format") != "text" {
+			writeJSON(w, s)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !s.Tracking {
+			fmt.Fprintln(w)
+			return
+		}
+		elapsed := time.Duration(s.ElapsedSeconds) * time.Second
+		fmt.Fprintf(w, "%s %d:%02d\n", s.Project, int(elapsed.Hours()),
+			int(elapsed.Minutes())%60)
+	}))
+	mux.HandleFunc("/watches", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		watches := httpWatches{Watches: d.watch.Roots(), Broken: d.watch.Broken()}
+		d.mu.Unlock()
+		writeJSON(w, watches)
+	}))
+	mux.HandleFunc("/healthz", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		locked := make(chan struct{})
+		go func() {
+			d.mu.Lock()
+			d.mu.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			fmt.Fprintln(w, "ok")
+		case <-time.After(healthzTimeout):
+			http.Error(w, "the daemon is not responding", http.StatusServiceUnavailable)
+		}
+	}))
+	return localOnly(port, mux)
+}
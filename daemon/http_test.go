@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestStatusHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	toggl := toggltest.NewServer()
+	defer toggl.Close()
+	d := &Daemon{tgStateDir: dir, trackDir: dir, status: status.New(dir),
+		watch: &status.Watch{}}
+	d.status.SetClient(toggl.Client())
+	server := httptest.NewUnstartedServer(nil)
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	server.Config.Handler = d.statusHandler(port)
+	server.Start()
+	defer server.Close()
+
+	// request sends a GET request for 'path' to the server, with the Host
+	// header 'host' (if set) and an Origin header (if 'origin' is set)
+	request := func(path, host, origin string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("could not create request for %s: %v", path, err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("could not read %s: %v", path, err)
+		}
+		return resp.StatusCode, string(body)
+	}
+	get := func(path string) (int, string) {
+		return request(path, "", "")
+	}
+
+	if code, body := get("/status?format=text"); code != http.StatusOK || body != "\n" {
+		t.Fatalf("expected an empty line while not tracking, but got %d %q", code, body)
+	}
+	if err := d.status.Tick("tg"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, body := get("/status")
+	var s httpStatus
+	if err := json.Unmarshal([]byte(body), &s); err != nil {
+		t.Fatalf("could not parse /status response %q: %v", body, err)
+	}
+	if !s.Tracking || s.Project != "tg" || s.Started == nil {
+		t.Fatalf("expected to be tracking \"tg\", but got %s", body)
+	}
+	if _, body := get("/status?format=text"); body != "tg 0:00\n" {
+		t.Fatalf("unexpected text status %q", body)
+	}
+	if code, body := get("/watches"); code != http.StatusOK || !strings.Contains(body, `"watches":{}`) {
+		t.Fatalf("unexpected /watches response %d %q", code, body)
+	}
+	if code, body := get("/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("unexpected /healthz response %d %q", code, body)
+	}
+
+	// Requests for other hosts (e.g. via DNS rebinding) and from web pages are
+	// refused, but any name for localhost is fine
+	if code, _ := request("/watches", fmt.Sprintf("evil.example:%d", port), ""); code != http.StatusForbidden {
+		t.Fatalf("expected a request for another host to be refused, but got %d", code)
+	}
+	if code, _ := request("/watches", "", "http://evil.example"); code != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin request to be refused, but got %d", code)
+	}
+	for _, host := range []string{"localhost", "[::1]"} {
+		if code, _ := request("/healthz", fmt.Sprintf("%s:%d", host, port), ""); code != http.StatusOK {
+			t.Fatalf("expected a request for %s to be served, but got %d", host, code)
+		}
+	}
+
+	// The server is read-only
+	resp, err := http.Post(server.URL+"/status", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected POST to be rejected, but got %d", resp.StatusCode)
+	}
+}
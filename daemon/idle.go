@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/msteffen/toggl-watcher/idle"
+)
+
+// inputIdle reads the user's input idle time. It's a variable so that tests
+// can fake it
+var inputIdle = idle.Duration
+
+// inputIdleStops reads the user's input idle time every 'interval' while
+// input_idle_timeout is set, until the daemon stops (see checkInputIdle)
+func (d *Daemon) inputIdleStops(interval time.Duration) {
+	var lastErr string // only log each distinct error once
+	for {
+		d.mu.Lock()
+		timeout := d.inputIdleTimeout
+		d.mu.Unlock()
+		if timeout > 0 {
+			now := time.Now()
+			idleFor, err := inputIdle()
+			if err != nil {
+				if err.Error() != lastErr {
+					daemonLog.Warnf("could not read the input idle time; "+
+						"input_idle_timeout has no effect: %v", err)
+				}
+				lastErr = err.Error()
+			} else {
+				lastErr = ""
+				d.checkInputIdle(idleFor, now)
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// checkInputIdle stops the open time entry if the user has had no input for
+// 'idleFor' (as of 'now') and that's past the input idle timeout. Writes are
+// ignored until the user's input resumes
+func (d *Daemon) checkInputIdle(idleFor time.Duration, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inputIdleTimeout == 0 || idleFor < d.inputIdleTimeout {
+		if d.inputIdle {
+			daemonLog.Infof("input resumed; tracking writes again")
+		}
+		d.inputIdle = false
+		return
+	}
+	if !d.inputIdle {
+		daemonLog.Infof("no input for %s; ignoring writes until there is", idleFor)
+	}
+	d.inputIdle = true
+	if _, err := d.status.StopInputIdle(now, now.Add(-idleFor)); err != nil {
+		daemonLog.Errorf("could not stop idle time entry: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestCheckInputIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	server := toggltest.NewServer()
+	defer server.Close()
+	d := &Daemon{tgStateDir: dir, trackDir: dir, status: status.New(dir),
+		inputIdleTimeout: 10 * time.Minute}
+	d.status.SetClient(server.Client())
+	if err := d.status.Tick("tg"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Recent input keeps the entry open
+	now := time.Now()
+	d.checkInputIdle(time.Minute, now)
+	if d.inputIdle || d.status.TimeEntryID() == 0 {
+		t.Fatalf("expected the entry to stay open after recent input")
+	}
+
+	// ...but not once the user has been away for longer than the timeout
+	d.checkInputIdle(11*time.Minute, now.Add(11*time.Minute))
+	if !d.inputIdle || d.status.TimeEntryID() != 0 {
+		t.Fatalf("expected the entry to be stopped after the input idle timeout")
+	}
+	// The entry is stopped at the last input, or its start if that's later
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Stop == nil || entries[0].Stop.After(now) {
+		t.Fatalf("expected one time entry stopped by %s, but got %+v", now, entries)
+	}
+
+	// Autosaves don't start a new entry while the user is away
+	d.watch = &status.Watch{} // no root options
+	d.onWrite(status.WriteEvent{Project: "tg", Start: now, End: now})
+	if d.status.TimeEntryID() != 0 {
+		t.Fatalf("expected writes to be ignored while the user is away")
+	}
+	d.checkInputIdle(0, now.Add(12*time.Minute))
+	if d.inputIdle {
+		t.Fatalf("expected input to resume")
+	}
+}
@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/notifier"
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// notify shows a desktop notification. It's a variable so that tests can fake
+// it
+var notify = notifier.Send
+
+// notificationKinds maps each event in config.Notifications to the kind of
+// status.Change that it is
+var notificationKinds = map[string]status.ChangeKind{
+	config.NotifyStart:  status.Started,
+	config.NotifyStop:   status.IdleStopped,
+	config.NotifySwitch: status.Switched,
+}
+
+// onChange is called by d.status when tracking starts, switches projects, or
+// stops after an idle period, and shows a notification if the user enabled
+// them for 'c'. d.status is only used while d.mu is held, so it's held here
+func (d *Daemon) onChange(c status.Change) {
+	if !d.notifications[c.Kind] {
+		return
+	}
+	var title, body string
+	switch c.Kind {
+	case status.Started:
+		title = "Tracking " + c.Project
+		body = fmt.Sprintf("tg started a time entry for %s", c.Project)
+	case status.Switched:
+		to, from := c.Project, c.From
+		if c.Branch != "" {
+			to, from = to+" ("+c.Branch+")", from+" ("+c.FromBranch+")"
+		}
+		title = "Switched to " + to
+		body = fmt.Sprintf("tg switched from %s to %s", from, to)
+	case status.IdleStopped:
+		title = "Stopped tracking " + c.Project
+		body = fmt.Sprintf("tg stopped the time entry for %s, as you've been idle",
+			c.Project)
+	}
+	// Don't block the daemon on the notification server
+	go func() {
+		if err := notify(title, body); err != nil {
+			daemonLog.Warnf("could not show notification: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestChangeNotifications(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	server := toggltest.NewServer()
+	defer server.Close()
+	titles := make(chan string, 10)
+	defer func(f func(string, string) error) { notify = f }(notify)
+	notify = func(title, body string) error {
+		titles <- title
+		return nil
+	}
+	d := &Daemon{tgStateDir: dir, trackDir: dir, status: status.New(dir),
+		notifications: map[status.ChangeKind]bool{status.Switched: true}}
+	d.status.SetClient(server.Client())
+	d.status.SetChangeCallback(d.onChange)
+
+	// Starting to track "a" isn't enabled, but switching to "b" is
+	for _, project := range []string{"a", "a", "b"} {
+		if err := d.status.Tick(project); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	select {
+	case title := <-titles:
+		if title != "Switched to b" {
+			t.Fatalf("unexpected notification %q", title)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a notification about switching to \"b\"")
+	}
+	select {
+	case title := <-titles:
+		t.Fatalf("unexpected notification %q", title)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
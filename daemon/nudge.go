@@ -0,0 +1,123 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// shellActivityWindow is how far back commands count towards sustained
+	// shell activity, which is at least minShellCommands commands spanning at
+	// least minShellSpan
+	shellActivityWindow = 15 * time.Minute
+	minShellCommands    = 5
+	minShellSpan        = 5 * time.Minute
+
+	// nudgeInterval is the least time between nudges about the same directory
+	nudgeInterval = time.Hour
+)
+
+// shellActivity tracks the commands run in shells (see 'tg shell-hook') in
+// directories that no watch covers
+type shellActivity struct {
+	// commands maps each directory to the times of its recent commands
+	commands map[string][]time.Time
+	// nudged maps each directory to the time of its latest nudge
+	nudged map[string]time.Time
+}
+
+// record records a command run in 'dir' at 'now', and returns true if the
+// user should be nudged to track time in 'dir', because commands have been run
+// there for a while (see shellActivityWindow) and it hasn't been nudged
+// recently
+func (a *shellActivity) record(dir string, now time.Time) bool {
+	if a.commands == nil {
+		a.commands = make(map[string][]time.Time)
+		a.nudged = make(map[string]time.Time)
+	}
+	// Drop commands that are too old to count, in every directory
+	for d, times := range a.commands {
+		kept := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < shellActivityWindow {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(a.commands, d)
+		} else {
+			a.commands[d] = kept
+		}
+	}
+	times := append(a.commands[dir], now)
+	a.commands[dir] = times
+	if len(times) < minShellCommands || now.Sub(times[0]) < minShellSpan ||
+		now.Sub(a.nudged[dir]) < nudgeInterval {
+		return false
+	}
+	a.nudged[dir] = now
+	delete(a.commands, dir)
+	return true
+}
+
+// reset forgets the commands run in every directory (e.g. because a timer is
+// running, so the user's time is being tracked)
+func (a *shellActivity) reset() {
+	a.commands = nil
+}
+
+// projectDir returns the directory to suggest watching for shell activity in
+// 'dir': the root of the git repository containing it, if there is one, or
+// 'dir' itself
+func projectDir(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if d == filepath.Dir(d) {
+			return dir
+		}
+	}
+}
+
+// onShellActivity is called with the working directory of each command run
+// in a shell with tg's hook installed. If commands keep being run in a
+// directory that no watch covers while no timer is running, the user is
+// nudged to start tracking it, as otherwise that time is lost
+func (d *Daemon) onShellActivity(dir string, now time.Time) {
+	dir, ok := d.recordShellActivity(dir, now)
+	if !ok {
+		return
+	}
+	body := fmt.Sprintf("You've been working in %s for a while, but no timer is "+
+		"running. Run 'tg watch <project> %s' to track it, or 'tg tick <project>' "+
+		"to start a timer now", dir, dir)
+	daemonLog.Infof("nudging about shell activity in %q", dir)
+	if err := notify("Untracked work in "+filepath.Base(dir), body); err != nil {
+		daemonLog.Warnf("could not send nudge notification: %v", err)
+	}
+}
+
+// recordShellActivity records a command run in 'dir' (see onShellActivity),
+// and returns the directory to nudge the user about, if they should be
+func (d *Daemon) recordShellActivity(dir string, now time.Time) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.suspension.activeAt(now) {
+		return "", false // the user isn't doing billable work
+	}
+	for root := range d.watch.Roots() {
+		if dir == root || strings.HasPrefix(dir, strings.TrimSuffix(root, "/")+"/") {
+			return "", false // writes in 'dir' are already tracked
+		}
+	}
+	if d.status.TimeEntryID() != 0 {
+		d.shell.reset()
+		return "", false
+	}
+	dir = projectDir(dir)
+	return dir, d.shell.record(dir, now)
+}
@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShellActivity(t *testing.T) {
+	var a shellActivity
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// A burst of commands isn't sustained activity...
+	for i := 0; i < minShellCommands; i++ {
+		if a.record("/src/x", at(time.Duration(i)*time.Second)) {
+			t.Fatalf("expected no nudge after a burst of %d commands", i+1)
+		}
+	}
+	// ...but commands spanning minShellSpan are
+	if !a.record("/src/x", at(minShellSpan)) {
+		t.Fatalf("expected a nudge after %s of commands", minShellSpan)
+	}
+	// The same directory isn't nudged about again for a while
+	for i := 0; i < 2*minShellCommands; i++ {
+		if a.record("/src/x", at(minShellSpan+time.Duration(i)*time.Minute)) {
+			t.Fatalf("expected no repeated nudge within %s", nudgeInterval)
+		}
+	}
+
+	// Commands older than shellActivityWindow don't count
+	for i := 0; i < minShellCommands; i++ {
+		if a.record("/src/y", at(time.Duration(i)*shellActivityWindow)) {
+			t.Fatalf("expected no nudge for commands spread over hours")
+		}
+	}
+}
+
+func TestProjectDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo, sub := filepath.Join(dir, "repo"), filepath.Join(dir, "repo", "a", "b")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := projectDir(sub); got != repo {
+		t.Fatalf("expected %q, but got %q", repo, got)
+	}
+	if got := projectDir(dir); got != dir {
+		t.Fatalf("expected %q, but got %q", dir, got)
+	}
+}
@@ -0,0 +1,78 @@
+package daemon
+
+import "time"
+
+// suspension is set by 'tg pause'. While it's active, writes and window titles
+// aren't tracked (though directories stay watched)
+type suspension struct {
+	active bool
+	// until, if set, is when the suspension ends by itself
+	until time.Time
+}
+
+// start suspends tracking until 'until' or, if it's zero, until end is called
+func (s *suspension) start(until time.Time) {
+	*s = suspension{active: true, until: until}
+}
+
+// end resumes tracking
+func (s *suspension) end() {
+	*s = suspension{}
+}
+
+// activeAt returns true if tracking is suspended at 'now', ending the
+// suspension if it has run out
+func (s *suspension) activeAt(now time.Time) bool {
+	if s.active && !s.until.IsZero() && !now.Before(s.until) {
+		s.end()
+	}
+	return s.active
+}
+
+// pause is a single 'tg run' invocation that has paused tracking
+type pause struct {
+	pid     int
+	project string
+}
+
+// pauses are the active pauses, in the order in which they started
+type pauses []pause
+
+// alive returns true if the process 'pid' still exists. It's a variable so
+// that tests can fake it
+var alive = ProcessExists
+
+// start adds a pause for 'pid', replacing any existing pause for it
+func (ps *pauses) start(pid int, project string) {
+	ps.end(pid)
+	*ps = append(*ps, pause{pid: pid, project: project})
+}
+
+// end removes the pause for 'pid', if any
+func (ps *pauses) end(pid int) {
+	kept := (*ps)[:0]
+	for _, p := range *ps {
+		if p.pid != pid {
+			kept = append(kept, p)
+		}
+	}
+	*ps = kept
+}
+
+// active returns true if tracking is paused, along with the project to which
+// writes should be attributed ("" if they should be ignored). The most recent
+// pause takes precedence. Pauses whose process has exited (e.g. because 'tg
+// run' was killed before it could end its pause) are dropped
+func (ps *pauses) active() (paused bool, project string) {
+	kept := (*ps)[:0]
+	for _, p := range *ps {
+		if alive(p.pid) {
+			kept = append(kept, p)
+		}
+	}
+	*ps = kept
+	if len(kept) == 0 {
+		return false, ""
+	}
+	return true, kept[len(kept)-1].project
+}
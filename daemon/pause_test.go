@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauses(t *testing.T) {
+	running := map[int]bool{1: true, 2: true}
+	defer func(f func(int) bool) { alive = f }(alive)
+	alive = func(pid int) bool { return running[pid] }
+
+	var ps pauses
+	if paused, _ := ps.active(); paused {
+		t.Fatalf("expected tracking not to be paused initially")
+	}
+	ps.start(1, "")
+	ps.start(2, "data-gen")
+	if paused, project := ps.active(); !paused || project != "data-gen" {
+		t.Fatalf("expected writes to go to \"data-gen\", but got (%t, %q)", paused, project)
+	}
+
+	// The most recent pause's process exits without ending its pause
+	running[2] = false
+	if paused, project := ps.active(); !paused || project != "" {
+		t.Fatalf("expected writes to be ignored, but got (%t, %q)", paused, project)
+	}
+	if len(ps) != 1 {
+		t.Fatalf("expected pause for exited process to be dropped, but have %v", ps)
+	}
+
+	ps.end(1)
+	if paused, _ := ps.active(); paused {
+		t.Fatalf("expected tracking to resume after all pauses ended")
+	}
+}
+
+func TestSuspension(t *testing.T) {
+	now := time.Now()
+	var s suspension
+	if s.activeAt(now) {
+		t.Fatalf("expected tracking not to be suspended initially")
+	}
+	s.start(time.Time{})
+	if !s.activeAt(now.Add(24 * time.Hour)) {
+		t.Fatalf("expected open-ended suspension to stay active")
+	}
+	s.end()
+	if s.activeAt(now) {
+		t.Fatalf("expected tracking to resume after suspension ended")
+	}
+
+	s.start(now.Add(time.Minute))
+	if !s.activeAt(now) {
+		t.Fatalf("expected tracking to be suspended before its end")
+	}
+	if s.activeAt(now.Add(time.Minute)) {
+		t.Fatalf("expected suspension to end by itself")
+	}
+	if s.active || !s.until.IsZero() {
+		t.Fatalf("expected expired suspension to be cleared, but have %+v", s)
+	}
+}
@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// pidFile is the file in tgStateDir where the running daemon's PID is written
+const pidFile = "daemon.pid"
+
+// PIDPath returns the path of the file in 'tgStateDir' where the running
+// daemon's PID is written
+func PIDPath(tgStateDir string) string {
+	return path.Join(tgStateDir, pidFile)
+}
+
+// writePID records the current process's PID in tgStateDir
+func writePID(tgStateDir string) error {
+	pidPath := path.Join(tgStateDir, pidFile)
+	return ioutil.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePID removes the PID file from tgStateDir
+func removePID(tgStateDir string) {
+	os.Remove(path.Join(tgStateDir, pidFile))
+}
+
+// PID returns the PID of the running tg daemon, or an error if no daemon is
+// running
+func PID(tgStateDir string) (int, error) {
+	data, err := ioutil.ReadFile(path.Join(tgStateDir, pidFile))
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("the tg daemon is not running")
+	} else if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse daemon PID file: %v", err)
+	}
+	if !ProcessExists(pid) {
+		return 0, fmt.Errorf("the tg daemon is not running (stale PID %d)", pid)
+	}
+	return pid, nil
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+)
+
+// pomodoro tracks the user's streak of continuous work, per the daemon's
+// ticks, for pomodoro mode (see config.Pomodoro)
+type pomodoro struct {
+	// focus, rest, and tag are the pomodoro, pomodoro_break, and pomodoro_tag
+	// settings. Pomodoro mode is off if 'focus' is 0
+	focus, rest time.Duration
+	tag         string
+
+	// start is when the current streak began, last is the time of its latest
+	// tick, and nudged is when the user was last told to take a break during
+	// it (or the zero time)
+	start, last, nudged time.Time
+}
+
+// configure applies the pomodoro settings in 'c'
+func (p *pomodoro) configure(c config.Config) {
+	p.focus, p.rest = time.Duration(c.Pomodoro), time.Duration(c.PomodoroBreak)
+	p.tag = c.PomodoroTag
+	if p.focus <= 0 {
+		p.start, p.last, p.nudged = time.Time{}, time.Time{}, time.Time{}
+	}
+}
+
+// record records a tick at 'now', and returns true if the user should be told
+// to take a break: the streak has lasted the focus period, and they haven't
+// been told within the last break period
+func (p *pomodoro) record(now time.Time) bool {
+	if p.focus <= 0 {
+		return false
+	}
+	if p.last.IsZero() || now.Sub(p.last) >= p.rest {
+		// The user took a break (or this is the first tick), so a new streak
+		// begins
+		p.start, p.nudged = now, time.Time{}
+	}
+	p.last = now
+	if now.Sub(p.start) < p.focus || (!p.nudged.IsZero() && now.Sub(p.nudged) < p.rest) {
+		return false
+	}
+	p.nudged = now
+	return true
+}
+
+// focused returns true if pomodoro mode is on and 'now' is within the focus
+// period of the current streak, i.e. the user isn't overdue for a break
+func (p *pomodoro) focused(now time.Time) bool {
+	return p.focus > 0 && !p.last.IsZero() && now.Sub(p.start) < p.focus
+}
+
+// onPomodoroTick records a tick at 'now' for pomodoro mode, and tells the user
+// to take a break if it's time. d.mu must be held
+func (d *Daemon) onPomodoroTick(now time.Time) {
+	if !d.pomodoro.record(now) {
+		return
+	}
+	worked := config.Duration(now.Sub(d.pomodoro.start).Round(time.Minute))
+	body := fmt.Sprintf("You've been working for %s without a break. Step away "+
+		"for %s; tg starts a new pomodoro once you're back", worked,
+		config.Duration(d.pomodoro.rest))
+	daemonLog.Infof("pomodoro: suggesting a break after %s of work", worked)
+	// Don't block the daemon on the notification server
+	go func() {
+		if err := notify("Time for a break", body); err != nil {
+			daemonLog.Warnf("could not show notification: %v", err)
+		}
+	}()
+}
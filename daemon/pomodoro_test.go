@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+)
+
+func TestPomodoro(t *testing.T) {
+	var p pomodoro
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// Pomodoro mode is off by default
+	if p.record(at(time.Hour)) || p.focused(at(time.Hour)) {
+		t.Fatalf("expected pomodoro mode to be off")
+	}
+
+	c := config.Default()
+	c.Pomodoro = config.Duration(25 * time.Minute)
+	p.configure(c)
+	for m := time.Duration(0); m < 25*time.Minute; m += time.Minute {
+		if p.record(at(m)) {
+			t.Fatalf("expected no break within the focus period (at %s)", m)
+		}
+		if !p.focused(at(m)) {
+			t.Fatalf("expected to be focused at %s", m)
+		}
+	}
+	// Once the focus period is over, the user is told to take a break, and
+	// told again every break period until they do
+	if !p.record(at(25 * time.Minute)) {
+		t.Fatalf("expected a break after the focus period")
+	}
+	if p.focused(at(25 * time.Minute)) {
+		t.Fatalf("expected not to be focused when a break is due")
+	}
+	if p.record(at(27 * time.Minute)) {
+		t.Fatalf("expected no repeated nudge within the break period")
+	}
+	if !p.record(at(30 * time.Minute)) {
+		t.Fatalf("expected another nudge after the break period")
+	}
+
+	// A break of at least pomodoro_break starts a new streak
+	if p.record(at(36*time.Minute)) || !p.focused(at(36*time.Minute)) {
+		t.Fatalf("expected a new pomodoro after a break")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package daemon
+
+import "syscall"
+
+// ProcessExists returns true if the process 'pid' exists
+func ProcessExists(pid int) bool {
+	// Signal 0 checks that the process exists without actually signalling it
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Reload signals the daemon 'pid' to reload its watches and config
+func Reload(tgStateDir string, pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"github.com/msteffen/toggl-watcher/control"
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code that GetExitCodeProcess reports for a process
+// that hasn't exited
+const stillActive = 259
+
+// ProcessExists returns true if the process 'pid' exists
+func ProcessExists(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
+
+// Reload asks the daemon 'pid' to reload its watches and config. Windows has
+// no SIGHUP, so the request is sent over the control socket
+func Reload(tgStateDir string, pid int) error {
+	return control.Call(tgStateDir, control.MethodReload, nil, nil)
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends 'state' (e.g. "READY=1") to systemd's notification socket
+// (see sd_notify(3)). It does nothing unless the daemon was started by a
+// systemd unit with Type=notify (see 'tg install-service')
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // a socket in the abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to systemd: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("could not notify systemd: %v", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often the daemon must send WATCHDOG=1 to
+// systemd (half of the unit's WatchdogSec, as sd_watchdog_enabled(3)
+// recommends), or 0 if systemd isn't supervising it
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // the watchdog is meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// watchdog sends WATCHDOG=1 to systemd every 'interval' until the daemon
+// stops, as long as the daemon's and its Watch's locks can be acquired. If
+// either stays held (e.g. because event processing is wedged), the pings stop
+// and systemd restarts the daemon
+func (d *Daemon) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			d.watch.Roots()
+			d.mu.Unlock()
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				daemonLog.Warnf("%v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
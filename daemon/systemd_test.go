@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSDNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer conn.Close()
+
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	os.Setenv("NOTIFY_SOCKET", addr.Name)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Fatalf("expected \"READY=1\", but got %q (%v)", buf[:n], err)
+	}
+
+	// Without NOTIFY_SOCKET, the daemon wasn't started by systemd
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("expected no error outside of systemd, but got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	if i := watchdogInterval(); i != 0 {
+		t.Fatalf("expected no watchdog, but got %s", i)
+	}
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	if i := watchdogInterval(); i != 30*time.Second {
+		t.Fatalf("expected a 30s watchdog interval, but got %s", i)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if i := watchdogInterval(); i != 0 {
+		t.Fatalf("expected no watchdog for another process, but got %s", i)
+	}
+}
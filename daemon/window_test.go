@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+func TestWindowPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := status.Start(dir)
+	if err != nil {
+		t.Fatalf("could not start watch: %v", err)
+	}
+	defer w.Close()
+	d := &Daemon{tgStateDir: dir, trackDir: dir, status: status.New(dir), watch: w}
+	if err := d.status.Tick("tg"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// A recent write in "tg" keeps the window title from switching projects
+	now := time.Now()
+	d.lastWrite = now.Add(-time.Minute)
+	d.onWindow("docs", now.Add(-30*time.Second), now)
+	if p := d.status.Project(); p != "tg" {
+		t.Fatalf("expected project to stay \"tg\", but got %q", p)
+	}
+
+	// ...but not once writes have stopped for a while
+	d.lastWrite = now.Add(-writePrecedence - time.Minute)
+	d.onWindow("docs", now.Add(-30*time.Second), now)
+	if p := d.status.Project(); p != "docs" {
+		t.Fatalf("expected project to switch to \"docs\", but got %q", p)
+	}
+	buckets, err := status.ReadActivity(dir, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Source != status.SourceWindow {
+		t.Fatalf("expected one window bucket, but got %+v", buckets)
+	}
+}
+
+func TestWindowRootOptions(t *testing.T) {
+	for _, opts := range []status.RootOptions{
+		{Workspace: "acme"},
+		{Profile: "client-a"},
+	} {
+		dir, err := ioutil.TempDir("", "daemon-test-")
+		if err != nil {
+			t.Fatalf("could not create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		root := filepath.Join(dir, "src")
+		if err := os.Mkdir(root, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		w, err := status.Start(dir)
+		if err != nil {
+			t.Fatalf("could not start watch: %v", err)
+		}
+		defer w.Close()
+		if err := w.AddWatches([]status.RootWatch{
+			{Dir: root, Project: "tg", RootOptions: opts},
+		}); err != nil {
+			t.Fatalf("could not add watch: %v", err)
+		}
+		d := &Daemon{tgStateDir: dir, trackDir: dir, status: status.New(dir), watch: w}
+		d.status.SetLocalOnly(true)
+		entryOpts := status.EntryOptions{Workspace: opts.Workspace, Profile: opts.Profile}
+		if err := d.status.TickWith("tg", entryOpts); err != nil {
+			t.Fatalf("%v", err)
+		}
+		start := d.status.EntryStart()
+
+		// Window titles (and 'tg tick') in the root's project continue the
+		// entry started by writes under it, in the same workspace and profile
+		now := time.Now()
+		d.onWindow("tg", now.Add(-30*time.Second), now)
+		if !d.status.SameProject("tg", entryOpts) || !d.status.EntryStart().Equal(start) {
+			t.Fatalf("expected the window title to continue the entry with %+v", opts)
+		}
+		journal, err := status.ReadJournal(dir, now.Add(-time.Hour), now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(journal) != 0 {
+			t.Fatalf("expected no stopped entries, but got %+v", journal)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/schedule"
+)
+
+// afterHours decides what happens to writes outside the user's working hours
+// (see config.WorkHours)
+type afterHours struct {
+	// hours, mode, and tag are the work_hours, after_hours, and
+	// after_hours_tag settings. If 'hours' is empty, every time is within
+	// working hours
+	hours schedule.Schedule
+	mode  string
+	tag   string
+
+	// allowedUntil, if set, is when working hours next begin. Until then,
+	// writes outside working hours are tracked, because the user chose to
+	// (see 'tg resume-tracking --after-hours')
+	allowedUntil time.Time
+
+	// nudged maps each project to when the user was last notified that writes
+	// in it are being ignored (see AfterHoursNotify)
+	nudged map[string]time.Time
+}
+
+// configure applies the working hours settings in 'c'
+func (a *afterHours) configure(c config.Config) error {
+	hours, err := schedule.Parse(c.WorkHours)
+	if err != nil {
+		a.hours = nil // track writes at all times
+		return err
+	}
+	a.hours, a.mode, a.tag = hours, c.AfterHours, c.AfterHoursTag
+	if a.tag == "" {
+		a.tag = config.DefaultAfterHoursTag
+	}
+	return nil
+}
+
+// outside returns true if 'now' is outside working hours, and the user hasn't
+// chosen to track writes until working hours begin again
+func (a *afterHours) outside(now time.Time) bool {
+	if a.hours.Contains(now) {
+		return false
+	}
+	if !a.allowedUntil.IsZero() && now.Before(a.allowedUntil) {
+		return false
+	}
+	a.allowedUntil = time.Time{}
+	return true
+}
+
+// allow tracks writes outside working hours until they next begin
+func (a *afterHours) allow(now time.Time) {
+	a.allowedUntil = a.hours.NextStart(now)
+}
+
+// nudge returns true if the user should be notified that writes in 'project'
+// at 'now' are being ignored, because they haven't been recently (see
+// nudgeInterval)
+func (a *afterHours) nudge(project string, now time.Time) bool {
+	if a.mode != config.AfterHoursNotify || now.Sub(a.nudged[project]) < nudgeInterval {
+		return false
+	}
+	if a.nudged == nil {
+		a.nudged = make(map[string]time.Time)
+	}
+	a.nudged[project] = now
+	return true
+}
+
+// onAfterHours is called with writes in 'project' outside working hours that
+// are being ignored, and notifies the user about them if after_hours is
+// "notify". d.mu must be held
+func (d *Daemon) onAfterHours(project string, now time.Time) {
+	if !d.afterHours.nudge(project, now) {
+		return
+	}
+	body := fmt.Sprintf("tg is ignoring writes in %s, as it's outside your "+
+		"working hours. Run 'tg resume-tracking --after-hours' to track them "+
+		"until your working hours begin", project)
+	daemonLog.Infof("notifying about writes in %q outside working hours", project)
+	// Don't block the daemon on the notification server
+	go func() {
+		if err := notify("Working after hours?", body); err != nil {
+			daemonLog.Warnf("could not show notification: %v", err)
+		}
+	}()
+}
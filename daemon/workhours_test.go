@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+)
+
+func TestAfterHours(t *testing.T) {
+	var a afterHours
+	c := config.Default()
+	c.WorkHours = []string{"Mon-Fri 09:00-18:00"}
+	c.AfterHours = config.AfterHoursNotify
+	if err := a.configure(c); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// 2019-03-04 is a Monday
+	at := func(day, hour int) time.Time {
+		return time.Date(2019, 3, day, hour, 0, 0, 0, time.Local)
+	}
+	if a.outside(at(4, 10)) || !a.outside(at(4, 20)) || !a.outside(at(9, 10)) {
+		t.Fatalf("unexpected working hours")
+	}
+
+	// The user is notified about each project at most once per nudgeInterval
+	if !a.nudge("a", at(4, 20)) || a.nudge("a", at(4, 20).Add(time.Minute)) ||
+		!a.nudge("b", at(4, 20)) || !a.nudge("a", at(4, 21)) {
+		t.Fatalf("unexpected nudges")
+	}
+
+	// Once the user chooses to track after hours, writes are tracked until
+	// working hours begin again
+	a.allow(at(4, 20))
+	if a.outside(at(4, 23)) || a.outside(at(5, 8)) {
+		t.Fatalf("expected writes to be tracked after the user allowed them")
+	}
+	if !a.outside(at(5, 20)) {
+		t.Fatalf("expected the next evening to be outside working hours again")
+	}
+}
@@ -0,0 +1,164 @@
+// Package export writes the blocks of work in tg's activity log (see
+// status.Blocks) in formats that other tools can import: CSV and JSON for
+// spreadsheets and scripts, and iCalendar, so that tracked time can be
+// reviewed in a calendar app
+package export
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// Format is a format in which blocks may be exported
+type Format string
+
+const (
+	// CSV writes one row per block, with a header row
+	CSV Format = "csv"
+	// JSON writes an array with one object per block
+	JSON Format = "json"
+	// ICal writes an iCalendar (.ics) file with one event per block
+	ICal Format = "ics"
+)
+
+// ParseFormat parses a Format from the value of a flag
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSON, ICal:
+		return f, nil
+	case "ical":
+		return ICal, nil
+	}
+	return "", fmt.Errorf("invalid export format %q (expected %s, %s, or %s)",
+		s, CSV, JSON, ICal)
+}
+
+// Write writes 'blocks' to 'w' in 'format'
+func Write(w io.Writer, format Format, blocks []status.Block) error {
+	switch format {
+	case CSV:
+		return WriteCSV(w, blocks)
+	case JSON:
+		return WriteJSON(w, blocks)
+	case ICal:
+		return WriteICal(w, blocks, time.Now())
+	}
+	return fmt.Errorf("invalid export format %q", format)
+}
+
+// csvTime is the format of times in CSV exports, which spreadsheets parse as
+// dates (in local time)
+const csvTime = "2006-01-02 15:04:05"
+
+// WriteCSV writes 'blocks' to 'w' as CSV
+func WriteCSV(w io.Writer, blocks []status.Block) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "project", "start", "end", "minutes", "files"})
+	for _, b := range blocks {
+		cw.Write([]string{
+			b.Start.Local().Format("2006-01-02"),
+			b.Project,
+			b.Start.Local().Format(csvTime),
+			b.End.Local().Format(csvTime),
+			strconv.FormatFloat(b.End.Sub(b.Start).Minutes(), 'f', 1, 64),
+			strconv.Itoa(b.Files),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonBlock is the JSON form of a block
+type jsonBlock struct {
+	Project string    `json:"project"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds int64     `json:"seconds"`
+	Files   int       `json:"files"`
+	// TopFiles are the files written most often in the block
+	TopFiles []string `json:"top_files,omitempty"`
+}
+
+// WriteJSON writes 'blocks' to 'w' as a JSON array
+func WriteJSON(w io.Writer, blocks []status.Block) error {
+	result := make([]jsonBlock, 0, len(blocks))
+	for _, b := range blocks {
+		result = append(result, jsonBlock{
+			Project:  b.Project,
+			Start:    b.Start,
+			End:      b.End,
+			Seconds:  int64(b.End.Sub(b.Start) / time.Second),
+			Files:    b.Files,
+			TopFiles: b.TopFiles,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// icalTime is the format of UTC times in iCalendar files
+const icalTime = "20060102T150405Z"
+
+// WriteICal writes 'blocks' to 'w' as an iCalendar file, with one event per
+// block. 'now' is the events' timestamp. Each event's UID is derived from its
+// block, so that importing an overlapping export again updates the events
+// rather than duplicating them
+func WriteICal(w io.Writer, blocks []status.Block, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//toggl-watcher//tg export//EN",
+		"CALSCALE:GREGORIAN",
+	}
+	for _, b := range blocks {
+		uid := sha1.Sum([]byte(b.Project + "\x00" + b.Start.UTC().Format(icalTime)))
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%x@toggl-watcher", uid[:8]),
+			"DTSTAMP:"+now.UTC().Format(icalTime),
+			"DTSTART:"+b.Start.UTC().Format(icalTime),
+			"DTEND:"+b.End.UTC().Format(icalTime),
+			"SUMMARY:"+icalText(b.Project),
+			"DESCRIPTION:"+icalText(fmt.Sprintf("%d files written (tracked by tg)", b.Files)),
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		if _, err := io.WriteString(w, fold(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icalText escapes 's' for use as an iCalendar TEXT value
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// fold splits 'line' into lines of at most 75 octets, as iCalendar requires,
+// without splitting UTF-8 characters. Continuation lines begin with a space
+func fold(line string) string {
+	var b strings.Builder
+	n := 0 // octets in the current line
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+var (
+	start  = time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	blocks = []status.Block{
+		{Project: "tg", Start: start, End: start.Add(90 * time.Minute), Files: 3},
+		{Project: "a, b; c", Start: start.Add(2 * time.Hour),
+			End: start.Add(150 * time.Minute), Files: 1},
+	}
+)
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, CSV, blocks); err != nil {
+		t.Fatalf("could not write CSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("could not parse CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[1][1] != "tg" || rows[1][4] != "90.0" ||
+		rows[2][1] != "a, b; c" || rows[2][5] != "1" {
+		t.Fatalf("unexpected rows: %q", rows)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, blocks); err != nil {
+		t.Fatalf("could not write JSON: %v", err)
+	}
+	var got []jsonBlock
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("could not parse JSON: %v", err)
+	}
+	if len(got) != 2 || got[0].Seconds != 5400 || !got[1].Start.Equal(blocks[1].Start) {
+		t.Fatalf("unexpected blocks: %+v", got)
+	}
+}
+
+func TestICal(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteICal(&buf, blocks, start); err != nil {
+		t.Fatalf("could not write iCalendar: %v", err)
+	}
+	ics := buf.String()
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART:20200102T100000Z\r\nDTEND:20200102T113000Z\r\n",
+		"SUMMARY:a\\, b\\; c\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Fatalf("expected %q in:\n%s", line, ics)
+		}
+	}
+	if n := strings.Count(ics, "BEGIN:VEVENT"); n != 2 {
+		t.Fatalf("expected 2 events, but got %d", n)
+	}
+
+	// Long lines are folded
+	long := strings.Repeat("é", 100)
+	if folded := fold("SUMMARY:" + long); !strings.Contains(folded, "\r\n ") ||
+		strings.Replace(folded, "\r\n ", "", -1) != "SUMMARY:"+long {
+		t.Fatalf("unexpected folding: %q", folded)
+	}
+	for _, line := range strings.Split(fold("SUMMARY:"+long), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line of %d octets is too long: %q", len(line), line)
+		}
+	}
+}
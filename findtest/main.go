@@ -1,13 +1,33 @@
+// Command findtest prints the events that a watcher.Watcher sends for a
+// directory tree, e.g. to check them by hand (see test_findtest.sh)
 package main
 
 import (
 	"fmt"
 	"os"
+
+	"github.com/msteffen/toggl-watcher/watcher"
 )
 
 func main() {
-	Watch(os.Args[1], func(e WatchEvent) error {
-		_, err := fmt.Printf("%s\n", e)
-		return err
-	})
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s <dir>\n", os.Args[0])
+		os.Exit(2)
+	}
+	w, err := watcher.NewWatcher(watcher.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := w.Add(os.Args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	for e := range w.Events() {
+		fmt.Println(e)
+	}
+	if err := w.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,80 @@
+// Package git reads the state of git repositories directly from their .git
+// directories, so that tg doesn't depend on the git binary being installed
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shortHashLen is the length to which the commit hash of a detached HEAD is
+// abbreviated
+const shortHashLen = 7
+
+// FindGitDir returns the git directory of the repository containing 'dir':
+// the nearest .git directory in 'dir' or one of its ancestors or, if that's a
+// file (as in worktrees and submodules), the directory that it points to. It
+// returns "" if 'dir' isn't in a git repository
+func FindGitDir(dir string) (string, error) {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		info, err := os.Stat(gitPath)
+		if err == nil && info.IsDir() {
+			return gitPath, nil
+		} else if err == nil {
+			return readGitFile(gitPath)
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("could not stat %q: %v", gitPath, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// readGitFile returns the git directory named by the .git file at 'gitPath',
+// which contains "gitdir: <path>"
+func readGitFile(gitPath string) (string, error) {
+	data, err := ioutil.ReadFile(gitPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read %q: %v", gitPath, err)
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", fmt.Errorf("could not parse %q: expected \"gitdir: <path>\"", gitPath)
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(gitPath), gitDir)
+	}
+	return gitDir, nil
+}
+
+// Branch returns the branch that's checked out in the git repository
+// containing 'dir' (e.g. "feature/foo"), or the abbreviated commit hash if
+// HEAD is detached. It returns "" if 'dir' isn't in a git repository
+func Branch(dir string) (string, error) {
+	gitDir, err := FindGitDir(dir)
+	if err != nil || gitDir == "" {
+		return "", err
+	}
+	headPath := filepath.Join(gitDir, "HEAD")
+	data, err := ioutil.ReadFile(headPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read %q: %v", headPath, err)
+	}
+	head := strings.TrimSpace(string(data))
+	if strings.HasPrefix(head, "ref:") {
+		ref := strings.TrimSpace(strings.TrimPrefix(head, "ref:"))
+		return strings.TrimPrefix(ref, "refs/heads/"), nil
+	}
+	if len(head) > shortHashLen {
+		head = head[:shortHashLen]
+	}
+	return head, nil
+}
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("could not create dir for %q: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write %q: %v", path, err)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	writeFile(t, filepath.Join(repo, ".git", "HEAD"), "ref: refs/heads/feature/foo\n")
+	if err := os.MkdirAll(filepath.Join(repo, "src", "pkg"), 0755); err != nil {
+		t.Fatalf("could not create src dir: %v", err)
+	}
+
+	// The branch is found from any directory in the repo
+	for _, d := range []string{repo, filepath.Join(repo, "src", "pkg")} {
+		if branch, err := Branch(d); err != nil || branch != "feature/foo" {
+			t.Fatalf("expected branch feature/foo in %q, but got %q (%v)", d, branch, err)
+		}
+	}
+
+	// Detached HEADs are abbreviated
+	writeFile(t, filepath.Join(repo, ".git", "HEAD"),
+		"0123456789abcdef0123456789abcdef01234567\n")
+	if branch, err := Branch(repo); err != nil || branch != "0123456" {
+		t.Fatalf("expected abbreviated hash, but got %q (%v)", branch, err)
+	}
+
+	// Worktrees have a .git file pointing to their git dir
+	worktree := filepath.Join(dir, "worktree")
+	writeFile(t, filepath.Join(repo, ".git", "worktrees", "wt", "HEAD"),
+		"ref: refs/heads/fix\n")
+	writeFile(t, filepath.Join(worktree, ".git"), "gitdir: ../repo/.git/worktrees/wt\n")
+	if branch, err := Branch(worktree); err != nil || branch != "fix" {
+		t.Fatalf("expected branch fix in worktree, but got %q (%v)", branch, err)
+	}
+
+	// Directories outside any repo have no branch
+	if branch, err := Branch(dir); err != nil || branch != "" {
+		t.Fatalf("expected no branch outside a repo, but got %q (%v)", branch, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package idle reads how long the user has gone without keyboard or mouse
+// input, so that a time entry can be stopped while the user is away even if
+// their editor keeps autosaving files. On X11 it queries the XScreenSaver
+// extension (with xprintidle); elsewhere, e.g. on Wayland, it queries the
+// org.freedesktop.ScreenSaver D-Bus service (with dbus-send)
+package idle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned when the user's input idle time can't be read in
+// the current session
+var ErrUnsupported = errors.New("the input idle time can't be read in this " +
+	"session (supported: X11 with xprintidle, org.freedesktop.ScreenSaver over " +
+	"D-Bus with dbus-send)")
+
+// commandTimeout bounds each call to xprintidle or dbus-send
+const commandTimeout = 5 * time.Second
+
+// run runs the command 'name' with 'args' and returns its output
+func run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	timer := time.AfterFunc(commandTimeout, func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	})
+	defer timer.Stop()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not run %s: %v", name, err)
+	}
+	return out, nil
+}
+
+// installed returns true if the command 'name' is in $PATH
+func installed(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// Duration returns the time since the user's last keyboard or mouse input
+func Duration() (time.Duration, error) {
+	x11 := os.Getenv("DISPLAY") != "" && os.Getenv("WAYLAND_DISPLAY") == ""
+	if x11 && installed("xprintidle") {
+		out, err := run("xprintidle")
+		if err != nil {
+			return 0, err
+		}
+		return parseXprintidle(out)
+	}
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" && installed("dbus-send") {
+		out, err := run("dbus-send", "--session", "--print-reply=literal",
+			"--dest=org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver",
+			"org.freedesktop.ScreenSaver.GetSessionIdleTime")
+		if err != nil {
+			// Many desktops (e.g. GNOME) don't implement GetSessionIdleTime
+			return 0, ErrUnsupported
+		}
+		return parseDBusReply(out)
+	}
+	return 0, ErrUnsupported
+}
+
+// parseXprintidle parses the output of xprintidle: the idle time in
+// milliseconds
+func parseXprintidle(out []byte) (time.Duration, error) {
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse xprintidle output %q: %v", out, err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// parseDBusReply parses the reply to GetSessionIdleTime printed by
+// 'dbus-send --print-reply=literal', e.g. "   uint32 12345": the idle time in
+// milliseconds
+func parseDBusReply(out []byte) (time.Duration, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 || fields[0] != "uint32" {
+		return 0, fmt.Errorf("could not parse dbus-send output %q", out)
+	}
+	ms, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse dbus-send output %q: %v", out, err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,25 @@
+package idle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	d, err := parseXprintidle([]byte("61234\n"))
+	if err != nil || d != 61234*time.Millisecond {
+		t.Fatalf("expected 61.234s, but got %s (err: %v)", d, err)
+	}
+	if _, err := parseXprintidle([]byte("couldn't open display\n")); err == nil {
+		t.Fatalf("expected an error parsing invalid xprintidle output")
+	}
+	d, err = parseDBusReply([]byte("   uint32 5000\n"))
+	if err != nil || d != 5*time.Second {
+		t.Fatalf("expected 5s, but got %s (err: %v)", d, err)
+	}
+	for _, out := range []string{"", "   boolean true\n", "   uint32 -1\n"} {
+		if _, err := parseDBusReply([]byte(out)); err == nil {
+			t.Fatalf("expected an error parsing dbus-send output %q", out)
+		}
+	}
+}
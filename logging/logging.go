@@ -0,0 +1,388 @@
+// Package logging writes the tg daemon's log: leveled messages with optional
+// key/value fields, formatted as text or JSON, to stderr and (once Start is
+// called) to a log file in tg's state directory that's rotated as it grows
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message. Messages below the configured level
+// are discarded
+type Level int
+
+// The zero Level is Info
+const (
+	Debug Level = iota - 1
+	Info
+	Warn
+	Error
+)
+
+var levelNames = map[Level]string{
+	Debug: "debug",
+	Info:  "info",
+	Warn:  "warn",
+	Error: "error",
+}
+
+// ParseLevel parses a Level such as "debug" or "warn"
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		s = "warn"
+	}
+	for l, name := range levelNames {
+		if s == name {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q (expected one of debug, info, "+
+		"warn, error)", s)
+}
+
+// String returns 'l' in a form accepted by ParseLevel
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Set implements the pflag.Value interface, so Levels can be used as flags
+func (l *Level) Set(s string) error {
+	parsed, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// Type implements the pflag.Value interface
+func (l *Level) Type() string {
+	return "level"
+}
+
+// Format is the encoding of each log line
+type Format int
+
+// The zero Format is Text
+const (
+	// Text lines look like:
+	// 2006-01-02T15:04:05.000Z07:00 INFO  adding watch dir=/home/me/src
+	Text Format = iota
+	// JSON lines look like:
+	// {"dir":"/home/me/src","level":"info","msg":"adding watch","time":"..."}
+	JSON
+)
+
+// String returns 'f' in a form accepted by Set
+func (f Format) String() string {
+	if f == JSON {
+		return "json"
+	}
+	return "text"
+}
+
+// Set implements the pflag.Value interface, so Formats can be used as flags
+func (f *Format) Set(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text":
+		*f = Text
+	case "json":
+		*f = JSON
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", s)
+	}
+	return nil
+}
+
+// Type implements the pflag.Value interface
+func (f *Format) Type() string {
+	return "format"
+}
+
+const (
+	// FileName is the name of the log file in tg's state directory
+	FileName = "tg.log"
+
+	// maxFileSize is the size past which the log file is rotated, and
+	// keepFiles is how many rotated files (tg.log.1, tg.log.2, ...) are kept
+	maxFileSize = 10 << 20
+	keepFiles   = 3
+)
+
+// output is where every Logger writes. It's guarded by 'mu', which also
+// serializes writes, so that lines from different goroutines don't interleave
+var (
+	mu     sync.Mutex
+	output = struct {
+		level  Level
+		format Format
+		w      io.Writer
+		file   *rotatingFile
+		redact func(string) string
+	}{w: os.Stderr}
+)
+
+// Start configures logging for the daemon: messages at 'level' and above are
+// written in 'format' to stderr and to FileName in 'tgStateDir'. Until Start
+// is called, messages at Info and above are written as text to stderr
+func Start(tgStateDir string, level Level, format Format) error {
+	f, err := openRotatingFile(path.Join(tgStateDir, FileName), maxFileSize, keepFiles)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if output.file != nil {
+		output.file.Close()
+	}
+	output.level, output.format, output.file = level, format, f
+	output.w = io.MultiWriter(os.Stderr, f)
+	return nil
+}
+
+// SetOutput configures logging to write messages at 'level' and above to 'w'
+// (and not to any log file). It's mainly useful for tests
+func SetOutput(w io.Writer, level Level, format Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	if output.file != nil {
+		output.file.Close()
+		output.file = nil
+	}
+	output.level, output.format, output.w = level, format, w
+}
+
+// SetRedact makes every Logger pass messages and field values through 'f'
+// before they're written (e.g. to remove paths that could leak client names).
+// If 'f' is nil, messages are written as they are
+func SetRedact(f func(string) string) {
+	mu.Lock()
+	defer mu.Unlock()
+	output.redact = f
+}
+
+// Enabled returns true if messages at 'l' are being written (e.g. to skip
+// building an expensive debug message)
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= output.level
+}
+
+// Logger writes messages with a fixed set of fields
+type Logger struct {
+	fields []interface{} // alternating keys and values
+}
+
+// root is the Logger with no fields used by the package-level functions
+var root = &Logger{}
+
+// With returns a Logger that adds the alternating keys and values in 'kv' to
+// every message, e.g. With("component", "watch")
+func With(kv ...interface{}) *Logger {
+	return root.With(kv...)
+}
+
+// With returns a Logger that adds the alternating keys and values in 'kv' to
+// every message, in addition to the fields in 'l'
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	return &Logger{fields: append(fields, kv...)}
+}
+
+// Debugf logs a message at Debug level
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(Debug, format, args) }
+
+// Infof logs a message at Info level
+func (l *Logger) Infof(format string, args ...interface{}) { l.log(Info, format, args) }
+
+// Warnf logs a message at Warn level
+func (l *Logger) Warnf(format string, args ...interface{}) { l.log(Warn, format, args) }
+
+// Errorf logs a message at Error level
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(Error, format, args) }
+
+// Debugf logs a message at Debug level
+func Debugf(format string, args ...interface{}) { root.log(Debug, format, args) }
+
+// Infof logs a message at Info level
+func Infof(format string, args ...interface{}) { root.log(Info, format, args) }
+
+// Warnf logs a message at Warn level
+func Warnf(format string, args ...interface{}) { root.log(Warn, format, args) }
+
+// Errorf logs a message at Error level
+func Errorf(format string, args ...interface{}) { root.log(Error, format, args) }
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if level < output.level {
+		return
+	}
+	msg := redacted(strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	var line []byte
+	if output.format == JSON {
+		line = l.json(time.Now(), level, msg)
+	} else {
+		line = l.text(time.Now(), level, msg)
+	}
+	output.w.Write(line)
+}
+
+// redacted applies output.redact (if set) to 's'. 'mu' must be held
+func redacted(s string) string {
+	if output.redact == nil {
+		return s
+	}
+	return output.redact(s)
+}
+
+// text renders a message in the Text format
+func (l *Logger) text(now time.Time, level Level, msg string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", now.Format("2006-01-02T15:04:05.000Z07:00"),
+		strings.ToUpper(level.String()), msg)
+	for i := 0; i < len(l.fields); i += 2 {
+		fmt.Fprintf(&b, " %v=", l.fields[i])
+		var v interface{} = "<missing>"
+		if i+1 < len(l.fields) {
+			v = l.fields[i+1]
+		}
+		if s := redacted(fmt.Sprint(v)); strings.ContainsAny(s, " \t\n\"=") || s == "" {
+			fmt.Fprintf(&b, "%q", s)
+		} else {
+			b.WriteString(s)
+		}
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// json renders a message in the JSON format. Fields can't override "time",
+// "level", or "msg"
+func (l *Logger) json(now time.Time, level Level, msg string) []byte {
+	m := make(map[string]interface{}, len(l.fields)/2+3)
+	for i := 0; i < len(l.fields); i += 2 {
+		var v interface{} = "<missing>"
+		if i+1 < len(l.fields) {
+			v = l.fields[i+1]
+		}
+		switch tv := v.(type) {
+		case error:
+			v = redacted(tv.Error()) // most errors marshal as {}
+		case string:
+			v = redacted(tv)
+		}
+		m[fmt.Sprint(l.fields[i])] = v
+	}
+	m["time"] = now.Format(time.RFC3339Nano)
+	m["level"] = level.String()
+	m["msg"] = msg
+	line, err := json.Marshal(m)
+	if err != nil {
+		// e.g. a field that can't be marshalled; log it as a string instead
+		for k, v := range m {
+			m[k] = fmt.Sprint(v)
+		}
+		line, _ = json.Marshal(m)
+	}
+	return append(line, '\n')
+}
+
+// rotatingFile is an io.Writer that appends to a file until it would exceed
+// 'maxSize', and then renames it to <path>.1 (shifting older files up, and
+// removing any past 'keep') and starts a new one
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not stat log file: %v", err)
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotated returns the path of the 'i'th most recently rotated log file
+func (r *rotatingFile) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	os.Remove(r.rotated(r.keep))
+	for i := r.keep - 1; i > 0; i-- {
+		os.Rename(r.rotated(i), r.rotated(i+1)) // may not exist yet
+	}
+	renameErr := os.Rename(r.path, r.rotated(1))
+	// Even if the rename failed, reopen the file so that logging continues
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("could not rotate log file: %v", renameErr)
+	}
+	return nil
+}
+
+// Write implements io.Writer. 'p' is never split across files
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.f == nil {
+		return 0, fmt.Errorf("log file %q is closed", r.path)
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			if r.f == nil {
+				return 0, err
+			}
+			// Otherwise only the rename failed; keep appending to the old file
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (r *rotatingFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
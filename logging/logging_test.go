@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf, Warn, Text)
+	defer SetOutput(os.Stderr, Info, Text)
+
+	log := With("component", "test")
+	log.Infof("dropped")
+	log.Warnf("kept %d", 1)
+	Errorf("kept %d\n", 2)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, but got %d:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "WARN  kept 1 component=test") {
+		t.Fatalf("unexpected line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "ERROR kept 2") {
+		t.Fatalf("unexpected line %q", lines[1])
+	}
+	if Enabled(Info) || !Enabled(Warn) {
+		t.Fatalf("expected only Warn and above to be enabled")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf, Debug, JSON)
+	defer SetOutput(os.Stderr, Info, Text)
+
+	With("dir", "/a b").With("err", errors.New("boom")).Debugf("hello %s", "world")
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("could not parse %q: %v", buf.String(), err)
+	}
+	for k, v := range map[string]string{
+		"level": "debug", "msg": "hello world", "dir": "/a b", "err": "boom",
+	} {
+		if m[k] != v {
+			t.Fatalf("expected %s=%q, but got %v", k, v, m[k])
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf, Info, Text)
+	SetRedact(func(s string) string { return strings.Replace(s, "acme", "<client>", -1) })
+	defer SetOutput(os.Stderr, Info, Text)
+	defer SetRedact(nil)
+
+	With("dir", "/src/acme").Errorf("could not watch %q", "/src/acme/web")
+	if line := buf.String(); strings.Contains(line, "acme") ||
+		!strings.Contains(line, `"/src/<client>/web"`) || !strings.Contains(line, "dir=/src/<client>") {
+		t.Fatalf("expected \"acme\" to be redacted, but got %q", line)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{Debug, Info, Warn, Error} {
+		parsed, err := ParseLevel(strings.ToUpper(l.String()))
+		if err != nil || parsed != l {
+			t.Fatalf("expected %v, but got %v (err: %v)", l, parsed, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatalf("expected an error parsing an invalid level")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging-test-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, FileName)
+
+	r, err := openRotatingFile(logPath, 10, 2)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Each write fills a file, so every write after the first rotates
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	r.Close()
+	for name, expected := range map[string]string{
+		FileName:        "fourth\n",
+		FileName + ".1": "third\n",
+		FileName + ".2": "second\n",
+	} {
+		contents, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if string(contents) != expected {
+			t.Fatalf("expected %s to contain %q, but it contains %q", name, expected,
+				contents)
+		}
+	}
+	if _, err := os.Stat(logPath + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 rotated files to be kept (err: %v)", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package metrics publishes measurements of the tg daemon's internals via
+// expvar and in Prometheus's text format, so that users running a metrics
+// collector can graph its behavior over the long term (e.g. to spot
+// regressions after an upgrade, or inotify pressure)
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// QueueDepth is the number of items waiting in each of the daemon's
+	// internal queues: "events" (inotify events waiting to be processed) and
+	// "writes" (processed writes waiting to be consolidated into buckets)
+	QueueDepth = expvar.NewMap("queue_depth")
+
+	// Errors counts errors by kind (e.g. "toggl_api", "tick", "inotify")
+	Errors = expvar.NewMap("errors")
+
+	// DebounceLatency is the delay between the first write in each bucket and
+	// the bucket being reported to the daemon
+	DebounceLatency = NewLatency("debounce_latency")
+
+	// APILatency is the duration of each request to the Toggl API
+	APILatency = NewLatency("toggl_api_latency")
+
+	// RescannedDirs is the number of directory trees that are periodically
+	// scanned for writes, because they couldn't be watched (e.g. the inotify
+	// watch limit was reached) or their events were lost
+	RescannedDirs = expvar.NewInt("rescanned_dirs")
+
+	// EventsReceived is the number of filesystem events read from inotify or
+	// fanotify
+	EventsReceived = expvar.NewInt("events_received")
+
+	// EventsFiltered counts events that weren't reported as writes, by reason:
+	// "unwatched" (not under any root watch) or "ignored" (matched an ignore
+	// pattern or file)
+	EventsFiltered = expvar.NewMap("events_filtered")
+
+	// WatchDescriptors is the number of inotify watches in use, and
+	// OpenEntryAge is the age in seconds of the open time entry (or 0). Both
+	// are read from the daemon when they're exported (see Gauge.Set)
+	WatchDescriptors = NewGauge("watch_descriptors")
+	OpenEntryAge     = NewGauge("open_entry_age_seconds")
+)
+
+// Gauge is a value that's computed when it's exported. It satisfies
+// expvar.Var
+type Gauge struct {
+	mu sync.Mutex
+	f  func() float64
+}
+
+// NewGauge returns a new Gauge, published via expvar as 'name'. It's 0 until
+// Set is called
+func NewGauge(name string) *Gauge {
+	g := &Gauge{}
+	expvar.Publish(name, g)
+	return g
+}
+
+// Set sets the function that computes 'g'
+func (g *Gauge) Set(f func() float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.f = f
+}
+
+// Value returns the current value of 'g'
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	f := g.f
+	g.mu.Unlock()
+	if f == nil {
+		return 0
+	}
+	return f()
+}
+
+// String returns the current value of 'g' as JSON
+func (g *Gauge) String() string {
+	data, _ := json.Marshal(g.Value())
+	return string(data)
+}
+
+// Latency summarizes a series of durations. It satisfies expvar.Var
+type Latency struct {
+	mu    sync.Mutex
+	count int64
+	total time.Duration
+	max   time.Duration
+	last  time.Duration
+}
+
+// NewLatency returns a new Latency, published via expvar as 'name'
+func NewLatency(name string) *Latency {
+	l := &Latency{}
+	expvar.Publish(name, l)
+	return l
+}
+
+// Observe records 'd' in 'l'
+func (l *Latency) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	l.total += d
+	l.last = d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+// Since records the time elapsed since 'start' in 'l'
+func (l *Latency) Since(start time.Time) {
+	l.Observe(time.Since(start))
+}
+
+// String returns 'l' as a JSON object, with durations in milliseconds
+func (l *Latency) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var mean time.Duration
+	if l.count > 0 {
+		mean = l.total / time.Duration(l.count)
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"count":   l.count,
+		"mean_ms": ms(mean),
+		"max_ms":  ms(l.max),
+		"last_ms": ms(l.last),
+	})
+	return string(data)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Serve serves all published metrics at http://'addr'/debug/vars (as expvar
+// JSON) and http://'addr'/metrics (in Prometheus's text format) until it fails
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", servePrometheus)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	l := &Latency{}
+	l.Observe(10 * time.Millisecond)
+	l.Observe(30 * time.Millisecond)
+	var got map[string]float64
+	if err := json.Unmarshal([]byte(l.String()), &got); err != nil {
+		t.Fatalf("could not parse latency %q: %v", l.String(), err)
+	}
+	if got["count"] != 2 || got["mean_ms"] != 20 || got["max_ms"] != 30 || got["last_ms"] != 30 {
+		t.Fatalf("unexpected latency summary: %v", got)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	EventsFiltered.Add("unwatched", 3)
+	EventsFiltered.Add("ignored", 2)
+	OpenEntryAge.Set(func() float64 { return 90 })
+	defer OpenEntryAge.Set(nil)
+	APILatency.Observe(1500 * time.Millisecond)
+
+	var buf bytes.Buffer
+	writePrometheus(&buf)
+	out := buf.String()
+	for _, expected := range []string{
+		"# TYPE tg_events_received_total counter\ntg_events_received_total ",
+		"tg_events_filtered_total{reason=\"ignored\"} 2\n" +
+			"tg_events_filtered_total{reason=\"unwatched\"} 3\n",
+		"tg_open_entry_age_seconds 90\n",
+		"tg_toggl_api_latency_seconds_sum ",
+		"tg_toggl_api_latency_seconds_count 1\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected output to contain %q, but got:\n%s", expected, out)
+		}
+	}
+}
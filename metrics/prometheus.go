@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusPrefix is prepended to the name of each metric exported to
+// Prometheus
+const prometheusPrefix = "tg_"
+
+// exported describes how a published metric is exported to Prometheus
+type exported struct {
+	name, help, kind string
+	v                expvar.Var
+	// label is the label that distinguishes the entries of an *expvar.Map
+	label string
+}
+
+// prometheusMetrics are the metrics exported to Prometheus, in order
+var prometheusMetrics = []exported{
+	{name: "events_received_total", kind: "counter", v: EventsReceived,
+		help: "Filesystem events read from inotify or fanotify"},
+	{name: "events_filtered_total", kind: "counter", v: EventsFiltered, label: "reason",
+		help: "Filesystem events that weren't reported as writes, by reason"},
+	{name: "watch_descriptors", kind: "gauge", v: WatchDescriptors,
+		help: "inotify watches in use"},
+	{name: "queue_depth", kind: "gauge", v: QueueDepth, label: "queue",
+		help: "Items waiting in the daemon's internal queues"},
+	{name: "rescanned_dirs", kind: "gauge", v: RescannedDirs,
+		help: "Directory trees that are scanned for writes, as they can't be watched"},
+	{name: "errors_total", kind: "counter", v: Errors, label: "kind",
+		help: "Errors, by kind"},
+	{name: "debounce_latency_seconds", kind: "summary", v: DebounceLatency,
+		help: "Delay between the first write in each bucket and its report"},
+	{name: "toggl_api_latency_seconds", kind: "summary", v: APILatency,
+		help: "Duration of requests to the Toggl API"},
+	{name: "open_entry_age_seconds", kind: "gauge", v: OpenEntryAge,
+		help: "Age of the open time entry, or 0 if there is none"},
+}
+
+// servePrometheus writes all of prometheusMetrics to 'w'
+func servePrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w)
+}
+
+// writePrometheus writes all of prometheusMetrics to 'w' in Prometheus's text
+// exposition format
+func writePrometheus(w io.Writer) {
+	for _, m := range prometheusMetrics {
+		name := prometheusPrefix + m.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.kind)
+		switch v := m.v.(type) {
+		case *expvar.Int:
+			fmt.Fprintf(w, "%s %d\n", name, v.Value())
+		case *Gauge:
+			fmt.Fprintf(w, "%s %s\n", name, formatFloat(v.Value()))
+		case *expvar.Map:
+			var lines []string
+			v.Do(func(kv expvar.KeyValue) {
+				lines = append(lines, fmt.Sprintf("%s{%s=%s} %s\n", name, m.label,
+					strconv.Quote(kv.Key), kv.Value.String()))
+			})
+			sort.Strings(lines)
+			io.WriteString(w, strings.Join(lines, ""))
+		case *Latency:
+			v.mu.Lock()
+			count, total := v.count, v.total
+			v.mu.Unlock()
+			fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name,
+				formatFloat(total.Seconds()), name, count)
+		}
+	}
+}
+
+// formatFloat formats 'f' as a Prometheus sample value
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
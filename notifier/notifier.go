@@ -0,0 +1,72 @@
+// Package notifier shows desktop notifications, with notify-send (from
+// libnotify) if it's installed, or otherwise by calling the
+// org.freedesktop.Notifications D-Bus service directly (with gdbus)
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrUnsupported is returned when no way to show notifications is installed
+var ErrUnsupported = errors.New("notifications can't be shown (install " +
+	"notify-send or gdbus)")
+
+// appName is the application that notifications are shown for
+const appName = "tg"
+
+// commandTimeout bounds each call to notify-send or gdbus
+const commandTimeout = 5 * time.Second
+
+// run runs the command 'name' with 'args'
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	timer := time.AfterFunc(commandTimeout, func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	})
+	defer timer.Stop()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not run %s: %v (%s)", name, err, out)
+	}
+	return nil
+}
+
+// Send shows a desktop notification with 'title' and 'body'
+func Send(title, body string) error {
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return run("notify-send", "--app-name="+appName, title, body)
+	}
+	if _, err := exec.LookPath("gdbus"); err == nil {
+		return run("gdbus", dbusArgs(title, body)...)
+	}
+	return ErrUnsupported
+}
+
+// dbusArgs returns the gdbus arguments that call
+// org.freedesktop.Notifications.Notify with 'title' and 'body'
+func dbusArgs(title, body string) []string {
+	return []string{"call", "--session",
+		"--dest=org.freedesktop.Notifications",
+		"--object-path=/org/freedesktop/Notifications",
+		"--method=org.freedesktop.Notifications.Notify",
+		// app_name, replaces_id, app_icon, summary, body, actions, hints, and
+		// expire_timeout (-1 for the server's default), in GVariant text format
+		gvariantString(appName), "0", `""`, gvariantString(title),
+		gvariantString(body), "@as []", "@a{sv} {}", "-1"}
+}
+
+// gvariantString quotes 's' as a GVariant string literal
+func gvariantString(s string) string {
+	quoted := []rune{'"'}
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			quoted = append(quoted, '\\')
+		}
+		quoted = append(quoted, r)
+	}
+	return string(append(quoted, '"'))
+}
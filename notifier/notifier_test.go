@@ -0,0 +1,18 @@
+package notifier
+
+import (
+	"testing"
+)
+
+func TestDBusArgs(t *testing.T) {
+	args := dbusArgs(`tracking "tg"`, `C:\src`)
+	if title := args[8]; title != `"tracking \"tg\""` {
+		t.Fatalf("unexpected quoted title %s", title)
+	}
+	if body := args[9]; body != `"C:\\src"` {
+		t.Fatalf("unexpected quoted body %s", body)
+	}
+	if len(args) != 13 {
+		t.Fatalf("expected 13 arguments, but got %d: %q", len(args), args)
+	}
+}
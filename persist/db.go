@@ -0,0 +1,390 @@
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DBFile is the name of the database in a state directory that holds tg's
+// state: documents (JSON values that are replaced as a whole, see ReadDoc)
+// and logs (sequences of JSON records that are appended to, see
+// AppendRecord). Each call opens the database and closes it again, so that
+// the daemon and tg commands can share it
+const DBFile = "state.db"
+
+const (
+	// docsBucket is the bucket holding every document, keyed by name
+	docsBucket = "docs"
+
+	// lockTimeout is how long a call waits for another process that has the
+	// database open for writing (which locks it)
+	lockTimeout = 10 * time.Second
+)
+
+// DocPath returns the path by which the document or log 'name' in the
+// database in 'dir' is identified in errors (e.g. "/x/state.db:tick")
+func DocPath(dir, name string) string {
+	return filepath.Join(dir, DBFile) + ":" + name
+}
+
+// openDB opens the database in 'dir', creating it (readable only by the
+// current user) unless 'readOnly' is true. If it's read-only and doesn't
+// exist, an error for which os.IsNotExist is true is returned
+func openDB(dir string, readOnly bool) (*bolt.DB, error) {
+	dbPath := filepath.Join(dir, DBFile)
+	if readOnly {
+		if _, err := os.Stat(dbPath); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: lockTimeout,
+		ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %q: %v", dbPath, err)
+	}
+	return db, nil
+}
+
+// view calls 'f' in a read-only transaction on the database in 'dir'. If the
+// database doesn't exist, an error for which os.IsNotExist is true is returned
+func view(dir string, f func(tx *bolt.Tx) error) error {
+	db, err := openDB(dir, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(f)
+}
+
+// update calls 'f' in a read-write transaction on the database in 'dir',
+// which is committed (and synced to disk) only if 'f' returns nil
+func update(dir string, f func(tx *bolt.Tx) error) error {
+	db, err := openDB(dir, false)
+	if err != nil {
+		return err
+	}
+	if err := db.Update(f); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// notExist returns an error for the missing document 'name', for which
+// os.IsNotExist is true (as for a missing state file)
+func notExist(dir, name string) error {
+	return &os.PathError{Op: "read", Path: DocPath(dir, name), Err: os.ErrNotExist}
+}
+
+// getDoc parses the document 'name' in 'tx' into 'v'. It returns an error for
+// which os.IsNotExist is true if there's no such document, or a *parseError if
+// it can't be parsed
+func getDoc(tx *bolt.Tx, dir, name string, v interface{}) error {
+	var data []byte
+	if b := tx.Bucket([]byte(docsBucket)); b != nil {
+		data = b.Get([]byte(name))
+	}
+	if data == nil {
+		return notExist(dir, name)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &parseError{err}
+	}
+	return nil
+}
+
+// putDoc serializes 'v' as the document 'name' in 'tx'
+func putDoc(tx *bolt.Tx, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not serialize %q: %v", name, err)
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(docsBucket))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(name), data)
+}
+
+// ReadDoc parses the document 'name' in the database in 'dir' into 'v'. If
+// there's no such document, an error for which os.IsNotExist is true is
+// returned. If it can't be parsed, it's moved out of the database (to the file
+// 'name'.corrupt in 'dir', see CorruptFiles) and a *CorruptError is returned
+func ReadDoc(dir, name string, v interface{}) error {
+	err := view(dir, func(tx *bolt.Tx) error {
+		return getDoc(tx, dir, name, v)
+	})
+	if os.IsNotExist(err) {
+		return notExist(dir, name) // the database doesn't exist yet
+	} else if _, ok := err.(*parseError); !ok {
+		return err
+	}
+	if moveErr := moveAside(dir, name); moveErr != nil {
+		return fmt.Errorf("could not move corrupt document %q aside: %v (%v)",
+			DocPath(dir, name), moveErr, err)
+	}
+	return &CorruptError{Path: filepath.Join(dir, name), Err: err}
+}
+
+// moveAside moves the document 'name' in the database in 'dir' to the file
+// 'name'.corrupt in 'dir', so that it can be inspected
+func moveAside(dir, name string) error {
+	return update(dir, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(docsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(name))
+		if data == nil {
+			return nil // e.g. already moved aside by another process
+		}
+		corruptPath := filepath.Join(dir, name+corruptSuffix)
+		if err := ioutil.WriteFile(corruptPath, data, 0600); err != nil {
+			return err
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// WriteDoc serializes 'v' and stores it as the document 'name' in the database
+// in 'dir', replacing any previous version atomically
+func WriteDoc(dir, name string, v interface{}) error {
+	return update(dir, func(tx *bolt.Tx) error {
+		return putDoc(tx, name, v)
+	})
+}
+
+// UpdateDoc parses the document 'name' in the database in 'dir' into 'v' (if
+// it exists), calls 'f', and then stores 'v' as the new version of the
+// document, all in one transaction, so that concurrent updates by other
+// processes aren't lost. If 'f' returns an error, nothing is stored and the
+// error is returned. A document that can't be parsed is treated as ReadDoc
+// treats it
+func UpdateDoc(dir, name string, v interface{}, f func() error) error {
+	err := update(dir, func(tx *bolt.Tx) error {
+		if err := getDoc(tx, dir, name, v); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := f(); err != nil {
+			return err
+		}
+		return putDoc(tx, name, v)
+	})
+	if _, ok := err.(*parseError); ok {
+		return ReadDoc(dir, name, v) // moves it aside
+	}
+	return err
+}
+
+// CheckDoc returns an error if the document 'name' in the database in 'dir'
+// exists but can't be parsed. Unlike ReadDoc, it never moves the document
+// aside
+func CheckDoc(dir, name string) error {
+	var v interface{}
+	err := view(dir, func(tx *bolt.Tx) error {
+		return getDoc(tx, dir, name, &v)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteDoc removes the document 'name' from the database in 'dir'. Removing
+// a document that doesn't exist does nothing
+func DeleteDoc(dir, name string) error {
+	return update(dir, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(docsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// AppendRecord serializes 'v' and appends it to the log 'log' in the database
+// in 'dir'
+func AppendRecord(dir, log string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not serialize record for %q: %v", log, err)
+	}
+	return update(dir, func(tx *bolt.Tx) error {
+		return appendRecords(tx, log, [][]byte{data})
+	})
+}
+
+// appendRecords appends each of 'records' to the log 'log' in 'tx'. Records
+// are keyed by sequence number, so that they're read in the order in which
+// they were appended
+func appendRecords(tx *bolt.Tx, log string, records [][]byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(log))
+	if err != nil {
+		return err
+	}
+	for _, data := range records {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRecords calls 'f' with each record in the log 'log' in the database in
+// 'dir', oldest first. A missing log has no records. 'data' is only valid
+// until 'f' returns
+func ReadRecords(dir, log string, f func(data []byte) error) error {
+	err := view(dir, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(log))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			return f(data)
+		})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// UpdateRecords replaces the records in the log 'log' in the database in
+// 'dir' with the result of applying 'f' to them (oldest first), in one
+// transaction. If 'f' returns an error, the log is left unchanged
+func UpdateRecords(dir, log string, f func(records [][]byte) ([][]byte, error)) error {
+	return update(dir, func(tx *bolt.Tx) error {
+		var records [][]byte
+		if b := tx.Bucket([]byte(log)); b != nil {
+			if err := b.ForEach(func(_, data []byte) error {
+				records = append(records, append([]byte(nil), data...))
+				return nil
+			}); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket([]byte(log)); err != nil {
+				return err
+			}
+		}
+		records, err := f(records)
+		if err != nil {
+			return err
+		}
+		return appendRecords(tx, log, records)
+	})
+}
+
+// CheckRecords returns an error if any record in the log 'log' in the
+// database in 'dir' isn't a JSON value
+func CheckRecords(dir, log string) error {
+	n := 0
+	return ReadRecords(dir, log, func(data []byte) error {
+		n++
+		if !json.Valid(data) {
+			return fmt.Errorf("record %d of %q isn't valid JSON: %q", n,
+				DocPath(dir, log), data)
+		}
+		return nil
+	})
+}
+
+// CheckDB returns an error if the database in 'dir' (if it exists) can't be
+// opened or is inconsistent (e.g. after a disk error)
+func CheckDB(dir string) error {
+	err := view(dir, func(tx *bolt.Tx) error {
+		var errs []string
+		for err := range tx.Check() {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			sort.Strings(errs)
+			return fmt.Errorf("%q is inconsistent: %s", filepath.Join(dir, DBFile),
+				strings.Join(errs, "; "))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ImportFile moves the JSON state file 'name' in 'dir' (see ReadJSON) into
+// the database in 'dir', as the document 'name', and removes the file and its
+// backup. A missing or corrupt file (which ReadJSON moves aside) is skipped,
+// and so is a file whose document already exists (e.g. if an import was
+// interrupted before the file was removed)
+func ImportFile(dir, name string) error {
+	filePath := filepath.Join(dir, name)
+	var v json.RawMessage
+	err := ReadJSON(filePath, &v)
+	if _, ok := err.(*CorruptError); ok || os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := update(dir, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(docsBucket)); b != nil && b.Get([]byte(name)) != nil {
+			return nil
+		}
+		return putDoc(tx, name, v)
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(filePath + backupSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// ImportLog moves the file 'name' in 'dir', which has one JSON record per
+// line, into the database in 'dir', as the log 'name', and removes the file.
+// Lines that aren't valid JSON (e.g. a line that was partially written when
+// tg crashed) are dropped. If the log already exists (e.g. if an import was
+// interrupted before the file was removed), the file is only removed
+func ImportLog(dir, name string) error {
+	filePath := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var records [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); json.Valid(line) {
+			records = append(records, append([]byte(nil), line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read %q: %v", filePath, err)
+	}
+	if err := update(dir, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) != nil {
+			return nil
+		}
+		return appendRecords(tx, name, records)
+	}); err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
@@ -0,0 +1,162 @@
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDocs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var v map[string]string
+	if err := ReadDoc(dir, "state", &v); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, but got %v", err)
+	}
+	if err := WriteDoc(dir, "state", map[string]string{"k": "a"}); err != nil {
+		t.Fatalf("could not write document: %v", err)
+	}
+	if err := UpdateDoc(dir, "state", &v, func() error {
+		v["k"] += "b"
+		return nil
+	}); err != nil {
+		t.Fatalf("could not update document: %v", err)
+	}
+	// An update whose callback fails changes nothing
+	errStop := errors.New("stop")
+	if err := UpdateDoc(dir, "state", &v, func() error {
+		v["k"] = "c"
+		return errStop
+	}); err != errStop {
+		t.Fatalf("expected the callback's error, but got %v", err)
+	}
+	v = nil
+	if err := ReadDoc(dir, "state", &v); err != nil || v["k"] != "ab" {
+		t.Fatalf("expected to read \"ab\", but got %v (%v)", v, err)
+	}
+	checkPerm(t, filepath.Join(dir, DBFile))
+
+	// A document that can't be parsed is reported by CheckDoc, and moved aside
+	// (to a file) by ReadDoc
+	db, err := bolt.Open(filepath.Join(dir, DBFile), 0644, nil)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(docsBucket)).Put([]byte("state"), []byte(`{"k": "c`))
+	}); err != nil {
+		t.Fatalf("could not corrupt document: %v", err)
+	}
+	db.Close()
+	if err := CheckDoc(dir, "state"); err == nil {
+		t.Fatalf("expected CheckDoc to report the corrupt document")
+	}
+	if err := ReadDoc(dir, "state", &v); err == nil {
+		t.Fatalf("expected an error reading the corrupt document")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Fatalf("expected *CorruptError, but got %T: %v", err, err)
+	}
+	if err := ReadDoc(dir, "state", &v); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupt document to be gone, but got %v", err)
+	}
+	corrupt, err := CorruptFiles(dir)
+	if err != nil || len(corrupt) != 1 {
+		t.Fatalf("expected one corrupt file, but got %v (%v)", corrupt, err)
+	}
+	checkPerm(t, corrupt[0])
+}
+
+// checkPerm fails 't' unless the file at 'path' is only accessible by its
+// owner
+func checkPerm(t *testing.T, path string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		return // Windows has no permission bits
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat %q: %v", path, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected %q to have mode 0600, but got %o", path, info.Mode().Perm())
+	}
+}
+
+func TestRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	read := func() []int {
+		var result []int
+		if err := ReadRecords(dir, "log", func(data []byte) error {
+			var n int
+			if err := json.Unmarshal(data, &n); err != nil {
+				return err
+			}
+			result = append(result, n)
+			return nil
+		}); err != nil {
+			t.Fatalf("could not read records: %v", err)
+		}
+		return result
+	}
+	if records := read(); len(records) != 0 {
+		t.Fatalf("expected no records, but got %v", records)
+	}
+
+	// A log file is imported once, without its partially-written last line
+	if err := ioutil.WriteFile(filepath.Join(dir, "log"), []byte("1\n2\n{"), 0644); err != nil {
+		t.Fatalf("could not write log file: %v", err)
+	}
+	if err := ImportLog(dir, "log"); err != nil {
+		t.Fatalf("could not import log: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "log"), []byte("1\n2\n"), 0644); err != nil {
+		t.Fatalf("could not write log file: %v", err)
+	}
+	if err := ImportLog(dir, "log"); err != nil {
+		t.Fatalf("could not import log: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log")); !os.IsNotExist(err) {
+		t.Fatalf("expected the log file to be removed, but got %v", err)
+	}
+	for _, n := range []int{3, 4} {
+		if err := AppendRecord(dir, "log", n); err != nil {
+			t.Fatalf("could not append record: %v", err)
+		}
+	}
+	if records := read(); len(records) != 4 || records[0] != 1 || records[3] != 4 {
+		t.Fatalf("expected records 1-4, in order, but got %v", records)
+	}
+
+	// Updated records are kept in order, and appended to after the update
+	if err := UpdateRecords(dir, "log", func(records [][]byte) ([][]byte, error) {
+		return records[2:], nil
+	}); err != nil {
+		t.Fatalf("could not update records: %v", err)
+	}
+	if err := AppendRecord(dir, "log", 5); err != nil {
+		t.Fatalf("could not append record: %v", err)
+	}
+	if records := read(); len(records) != 3 || records[0] != 3 || records[2] != 5 {
+		t.Fatalf("expected records 3-5, in order, but got %v", records)
+	}
+	if err := CheckRecords(dir, "log"); err != nil {
+		t.Fatalf("expected valid records, but got %v", err)
+	}
+	if err := CheckDB(dir); err != nil {
+		t.Fatalf("expected a consistent database, but got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package persist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// versionFile is the name of the file in a state directory that records the
+// version of the state directory's schema (the number of the last Migration
+// applied to it)
+const versionFile = "schema-version"
+
+// Migration converts a state directory from the previous schema version to
+// 'Version'
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(dir string) error
+}
+
+// SchemaVersion returns the schema version of the state directory 'dir'. A
+// directory without a version file has version 0
+func SchemaVersion(dir string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, versionFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not read schema version: %v", err)
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse schema version %q: %v", data, err)
+	}
+	return version, nil
+}
+
+// Migrate applies each of 'migrations' (which must be sorted by version) that
+// is newer than the schema version of 'dir', recording the new version after
+// each one so that an interrupted migration resumes where it left off. It
+// fails if 'dir' was written by a newer version of tg than 'migrations' knows
+// about
+func Migrate(dir string, migrations []Migration) error {
+	version, err := SchemaVersion(dir)
+	if err != nil {
+		return err
+	}
+	if latest := len(migrations); latest > 0 && version > migrations[latest-1].Version {
+		return fmt.Errorf("state directory %q has schema version %d, but this "+
+			"version of tg only supports up to version %d", dir, version,
+			migrations[latest-1].Version)
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := m.Apply(dir); err != nil {
+			return fmt.Errorf("could not migrate state directory to version %d (%s): %v",
+				m.Version, m.Description, err)
+		}
+		data := []byte(strconv.Itoa(m.Version) + "\n")
+		if err := WriteFile(filepath.Join(dir, versionFile), data, 0644); err != nil {
+			return err
+		}
+		version = m.Version
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var applied []int
+	migrations := []Migration{
+		{Version: 1, Apply: func(string) error { applied = append(applied, 1); return nil }},
+		{Version: 2, Apply: func(string) error { applied = append(applied, 2); return nil }},
+	}
+	if err := Migrate(dir, migrations[:1]); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+	if err := Migrate(dir, migrations); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+	if len(applied) != 2 || applied[0] != 1 || applied[1] != 2 {
+		t.Fatalf("expected each migration to be applied once, in order, but got %v", applied)
+	}
+	if version, err := SchemaVersion(dir); err != nil || version != 2 {
+		t.Fatalf("expected schema version 2, but got %d (%v)", version, err)
+	}
+
+	// Older versions of tg refuse to use newer state directories
+	if err := Migrate(dir, migrations[:1]); err == nil {
+		t.Fatalf("expected error migrating newer state directory")
+	}
+}
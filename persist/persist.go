@@ -0,0 +1,176 @@
+// Package persist reads and writes tg's state so that a crash (of tg or of the
+// machine) never leaves it partially written. Most state is kept in a database
+// in the state directory (see DBFile), which is updated in transactions. Other
+// files (e.g. the config) are replaced atomically, and the previous version of
+// each file is kept as a backup, from which it's recovered if the current
+// version is unreadable
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// backupSuffix is appended to a state file's path to get the path of the
+	// previous version of the file
+	backupSuffix = ".bak"
+
+	// corruptSuffix is appended to a state file's path to get the path where a
+	// corrupt, unrecoverable state file is moved (so it can be inspected)
+	corruptSuffix = ".corrupt"
+)
+
+// CorruptError is returned by ReadJSON if a state file (and its backup, if
+// any) can't be parsed. The corrupt file is moved aside, so that subsequent
+// reads see no file at all
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("state file %q is corrupt (moved to %q): %v", e.Path,
+		e.Path+corruptSuffix, e.Err)
+}
+
+// MakeStateDir creates the state directory 'dir' (and any missing parents) if
+// it doesn't exist yet. It's private to the current user (mode 0700), as it
+// holds API tokens and a record of the user's work
+func MakeStateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not create state dir at %q: %v", dir, err)
+	}
+	return nil
+}
+
+// WriteFile atomically replaces the file at 'path' with 'data': the data is
+// written to a temporary file in the same directory, synced to disk, and then
+// renamed over 'path'. The previous contents of 'path' (if any) are kept at
+// 'path'.bak
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not create temp file for %q: %v", path, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename has succeeded
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write %q: %v", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not set permissions of %q: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync %q: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close %q: %v", tmp.Name(), err)
+	}
+
+	// Keep the previous version as a backup. If tg crashes between these two
+	// renames, 'path' won't exist, and ReadJSON will recover it from the backup
+	if err := os.Rename(path, path+backupSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not back up %q: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %q: %v", path, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory 'dir', so that renames within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open %q: %v", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not sync %q: %v", dir, err)
+	}
+	return nil
+}
+
+// WriteJSON serializes 'v' and atomically writes it to 'path' (see WriteFile)
+func WriteJSON(path string, v interface{}, perm os.FileMode) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not serialize %q: %v", path, err)
+	}
+	return WriteFile(path, append(data, '\n'), perm)
+}
+
+// ReadJSON parses the JSON state file at 'path' into 'v'. If the file is
+// missing or can't be parsed (e.g. because it was written by an older version
+// of tg that didn't write files atomically, and was interrupted), 'v' is read
+// from the file's backup instead. If neither exists, the error from reading
+// 'path' is returned (so os.IsNotExist works). If neither can be parsed, the
+// file is moved aside and a *CorruptError is returned
+func ReadJSON(path string, v interface{}) error {
+	err := readJSON(path, v)
+	if err == nil {
+		return nil
+	}
+	if backupErr := readJSON(path+backupSuffix, v); backupErr == nil {
+		fmt.Fprintf(os.Stderr, "could not read %q (%v); recovered it from %q\n",
+			path, err, path+backupSuffix)
+		return nil
+	}
+	if _, ok := err.(*parseError); !ok {
+		return err // e.g. the file doesn't exist
+	}
+	if renameErr := os.Rename(path, path+corruptSuffix); renameErr != nil {
+		return fmt.Errorf("could not move corrupt state file %q aside: %v (%v)",
+			path, renameErr, err)
+	}
+	return &CorruptError{Path: path, Err: err}
+}
+
+// parseError is returned by readJSON if a file was read but couldn't be parsed
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+// readJSON parses the JSON file at 'path' into 'v'
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &parseError{err}
+	}
+	return nil
+}
+
+// Check returns an error if the JSON state file at 'path' exists but can't be
+// parsed, along with whether its backup can be (in which case ReadJSON will
+// recover the file from it). Unlike ReadJSON, it never moves the file aside,
+// so it's safe to call while another process is using the file
+func Check(path string) (backupOK bool, err error) {
+	var v interface{}
+	err = readJSON(path, &v)
+	if os.IsNotExist(err) {
+		return false, nil // it hasn't been written yet (or is mid-replacement)
+	} else if _, ok := err.(*parseError); !ok {
+		return false, err // nil, or e.g. a permissions error
+	}
+	return readJSON(path+backupSuffix, &v) == nil, err
+}
+
+// CorruptFiles returns the paths of the state files in 'dir' that ReadJSON
+// moved aside because they were corrupt (see CorruptError)
+func CorruptFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*"+corruptSuffix))
+}
@@ -0,0 +1,106 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteAndRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	var v map[string]string
+	if err := ReadJSON(path, &v); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error, but got %v", err)
+	}
+	for _, value := range []string{"a", "b"} {
+		if err := WriteJSON(path, map[string]string{"k": value}, 0644); err != nil {
+			t.Fatalf("could not write state: %v", err)
+		}
+	}
+	if err := ReadJSON(path, &v); err != nil || v["k"] != "b" {
+		t.Fatalf("expected to read \"b\", but got %v (%v)", v, err)
+	}
+
+	// A partially-written file is recovered from the previous version
+	if err := ioutil.WriteFile(path, []byte(`{"k": "c`), 0644); err != nil {
+		t.Fatalf("could not corrupt state: %v", err)
+	}
+	v = nil
+	if err := ReadJSON(path, &v); err != nil || v["k"] != "a" {
+		t.Fatalf("expected to recover \"a\", but got %v (%v)", v, err)
+	}
+
+	// If the backup is also corrupt, the file is moved aside
+	if err := ioutil.WriteFile(path+backupSuffix, nil, 0644); err != nil {
+		t.Fatalf("could not corrupt backup: %v", err)
+	}
+	if err := ReadJSON(path, &v); err == nil {
+		t.Fatalf("expected error reading corrupt state")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Fatalf("expected *CorruptError, but got %T (%v)", err, err)
+	}
+	if _, err := os.Stat(path + corruptSuffix); err != nil {
+		t.Fatalf("expected corrupt file to be moved aside: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	if _, err := Check(path); err != nil {
+		t.Fatalf("expected a missing file to pass, but got %v", err)
+	}
+	for _, value := range []string{"a", "b"} {
+		if err := WriteJSON(path, map[string]string{"k": value}, 0644); err != nil {
+			t.Fatalf("could not write state: %v", err)
+		}
+	}
+	if _, err := Check(path); err != nil {
+		t.Fatalf("expected an intact file to pass, but got %v", err)
+	}
+
+	// A corrupt file is reported (with its backup), but left in place
+	if err := ioutil.WriteFile(path, []byte(`{"k": "c`), 0644); err != nil {
+		t.Fatalf("could not corrupt state: %v", err)
+	}
+	if backupOK, err := Check(path); err == nil || !backupOK {
+		t.Fatalf("expected a corrupt file with an intact backup, but got %t (%v)", backupOK, err)
+	}
+	if corrupt, err := CorruptFiles(dir); err != nil || len(corrupt) != 0 {
+		t.Fatalf("expected no files to be moved aside, but got %v (%v)", corrupt, err)
+	}
+}
+
+func TestMakeStateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "a", "state")
+	for i := 0; i < 2; i++ { // creating it again does nothing
+		if err := MakeStateDir(stateDir); err != nil {
+			t.Fatalf("could not create state dir: %v", err)
+		}
+	}
+	info, err := os.Stat(stateDir)
+	if err != nil {
+		t.Fatalf("could not stat state dir: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+		t.Fatalf("expected state dir to have mode 0700, but got %o", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// pluginSymbol is the name of the symbol that policy plugins must export. It
+// may be a value implementing Policy, or a func(Activity) Decision
+const pluginSymbol = "Policy"
+
+// Load opens the Go plugin at 'path' (built with 'go build
+// -buildmode=plugin'), registers the Policy that it exports under 'name', and
+// returns it.
+func Load(name, path string) (Policy, error) {
+	plug, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open policy plugin %q: %v", path, err)
+	}
+	sym, err := plug.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("policy plugin %q does not export %q: %v",
+			path, pluginSymbol, err)
+	}
+	var p Policy
+	// Lookup returns a pointer for exported variables, and the function itself
+	// for exported functions
+	switch v := sym.(type) {
+	case Policy:
+		p = v
+	case *Policy:
+		p = *v
+	case func(Activity) Decision:
+		p = PolicyFunc(v)
+	case *func(Activity) Decision:
+		p = PolicyFunc(*v)
+	default:
+		return nil, fmt.Errorf("%q in policy plugin %q has type %T, which does "+
+			"not implement policy.Policy", pluginSymbol, path, sym)
+	}
+	if err := Register(name, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
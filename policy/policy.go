@@ -0,0 +1,150 @@
+// Package policy decides, based on the activity observed in each event bucket,
+// when tg should start, stop, or switch Toggl time entries. The default policy
+// simply follows the busiest project, but users with bespoke needs may supply
+// their own Policy as a Go plugin (see Load)
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Action is the kind of change a Policy wants made to the current time entry
+type Action uint8
+
+// The definition of each of the Actions
+const (
+	// None leaves the current time entry (or lack thereof) alone
+	None Action = iota
+	// Start starts a new time entry in Decision.Project
+	Start
+	// Stop stops the current time entry
+	Stop
+	// Switch stops the current time entry and starts a new one in
+	// Decision.Project
+	Switch
+)
+
+func (a Action) String() string {
+	switch a {
+	case None:
+		return "none"
+	case Start:
+		return "start"
+	case Stop:
+		return "stop"
+	case Switch:
+		return "switch"
+	}
+	return fmt.Sprintf("Action(%d)", uint8(a))
+}
+
+// Activity summarizes the work observed during a single event bucket, and is
+// the input to every Policy
+type Activity struct {
+	// End is the time at which the bucket was closed
+	End time.Time
+
+	// Scores maps each project that saw activity during the bucket to a score
+	// (currently, the number of filesystem events attributed to it)
+	Scores map[string]int
+
+	// CurrentProject is the project of the open time entry, or "" if no time
+	// entry is open
+	CurrentProject string
+
+	// LastTick is the end of the last bucket that contained any activity
+	LastTick time.Time
+}
+
+// Busiest returns the project with the highest score in 'a' (ties are broken
+// by name, so that the result is deterministic), or "" if no project saw any
+// activity
+func (a Activity) Busiest() string {
+	projects := make([]string, 0, len(a.Scores))
+	for project := range a.Scores {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	var busiest string
+	for _, project := range projects {
+		if busiest == "" || a.Scores[project] > a.Scores[busiest] {
+			busiest = project
+		}
+	}
+	return busiest
+}
+
+// Decision is the output of a Policy
+type Decision struct {
+	Action Action
+	// Project is the project in which a time entry should be started (ignored
+	// unless Action is Start or Switch)
+	Project string
+}
+
+// Policy decides what should happen to the current time entry after each
+// event bucket
+type Policy interface {
+	Decide(a Activity) Decision
+}
+
+// PolicyFunc allows ordinary functions to be used as Policies
+type PolicyFunc func(a Activity) Decision
+
+// Decide implements the Policy interface
+func (f PolicyFunc) Decide(a Activity) Decision {
+	return f(a)
+}
+
+// Default is the policy that tg uses unless configured otherwise: start an
+// entry in the busiest project, and switch if some other project becomes the
+// busiest. Default never stops entries; that's handled by the idle timeout
+var Default Policy = PolicyFunc(func(a Activity) Decision {
+	busiest := a.Busiest()
+	switch {
+	case busiest == "":
+		return Decision{Action: None}
+	case a.CurrentProject == "":
+		return Decision{Action: Start, Project: busiest}
+	case a.CurrentProject != busiest && a.Scores[busiest] > a.Scores[a.CurrentProject]:
+		return Decision{Action: Switch, Project: busiest}
+	}
+	return Decision{Action: None}
+})
+
+var (
+	// registryMu guards 'registry'
+	registryMu sync.Mutex
+
+	// registry maps policy names to policies, so that they can be selected by
+	// name in tg's configuration
+	registry = map[string]Policy{
+		"default": Default,
+	}
+)
+
+// Register makes 'p' available under 'name'. Plugins may call this from an
+// init() function instead of (or in addition to) exporting a Policy symbol
+func Register(name string, p Policy) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("a policy named %q is already registered", name)
+	}
+	registry[name] = p
+	return nil
+}
+
+// Lookup returns the policy registered under 'name'
+func Lookup(name string) (Policy, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("no policy named %q is registered", name)
+	}
+	return p, nil
+}
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	for _, c := range []struct {
+		a    Activity
+		want Decision
+	}{
+		{
+			a:    Activity{},
+			want: Decision{Action: None},
+		},
+		{
+			a:    Activity{Scores: map[string]int{"a": 1}},
+			want: Decision{Action: Start, Project: "a"},
+		},
+		{
+			a:    Activity{Scores: map[string]int{"a": 3, "b": 1}, CurrentProject: "a"},
+			want: Decision{Action: None},
+		},
+		{
+			a:    Activity{Scores: map[string]int{"a": 1, "b": 3}, CurrentProject: "a"},
+			want: Decision{Action: Switch, Project: "b"},
+		},
+		{
+			// ties favor the current project
+			a:    Activity{Scores: map[string]int{"a": 2, "b": 2}, CurrentProject: "b"},
+			want: Decision{Action: None},
+		},
+	} {
+		if got := Default.Decide(c.a); got != c.want {
+			t.Errorf("Decide(%+v): expected %+v, but got %+v", c.a, c.want, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	stop := PolicyFunc(func(Activity) Decision { return Decision{Action: Stop} })
+	if err := Register("stop", stop); err != nil {
+		t.Fatalf("could not register policy: %v", err)
+	}
+	if err := Register("stop", stop); err == nil {
+		t.Fatalf("expected error re-registering policy \"stop\"")
+	}
+	p, err := Lookup("stop")
+	if err != nil {
+		t.Fatalf("could not look up policy: %v", err)
+	}
+	if d := p.Decide(Activity{}); d.Action != Stop {
+		t.Fatalf("expected registered policy to stop, but got %v", d.Action)
+	}
+}
@@ -0,0 +1,54 @@
+// Package redact rewrites text that may contain paths (e.g. time entry
+// descriptions rendered from a root's directory, or log messages) before it
+// leaves tg, so that directory names can't leak client names or secrets
+package redact
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Spec is a redaction rule as it's configured: text matching Pattern (a
+// regular expression) is replaced with Replacement, which may refer to
+// submatches (e.g. "$1")
+type Spec struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Rule is a compiled Spec
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewRule compiles 'pattern' (a regular expression) into a Rule
+func NewRule(pattern, replacement string) (Rule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+	}
+	return Rule{Pattern: re, Replacement: replacement}, nil
+}
+
+// Compile compiles each of 'specs' into a Rule
+func Compile(specs []Spec) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, s := range specs {
+		r, err := NewRule(s.Pattern, s.Replacement)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Apply applies each of 'rules' to 's' in order, so later rules see the
+// output of earlier ones
+func Apply(rules []Rule, s string) string {
+	for _, r := range rules {
+		s = r.Pattern.ReplaceAllString(s, r.Replacement)
+	}
+	return s
+}
@@ -0,0 +1,28 @@
+package redact
+
+import "testing"
+
+func TestApply(t *testing.T) {
+	rules, err := Compile([]Spec{
+		{Pattern: `/clients/[^/]+`, Replacement: "/clients/<client>"},
+		{Pattern: `token=\w+`, Replacement: "token=<redacted>"},
+		{Pattern: `^/home/(\w+)/`, Replacement: "~$1/"},
+	})
+	if err != nil {
+		t.Fatalf("could not compile rules: %v", err)
+	}
+	for in, expected := range map[string]string{
+		"/home/me/clients/acme/src": "~me/clients/<client>/src",
+		"fetch?token=abc123&x=1":    "fetch?token=<redacted>&x=1",
+		"tg: /srv/toggl-watcher":    "tg: /srv/toggl-watcher",
+		"":                          "",
+		"/clients/a/x:/clients/b/y": "/clients/<client>/x:/clients/<client>/y",
+	} {
+		if got := Apply(rules, in); got != expected {
+			t.Fatalf("expected %q to be redacted to %q, but got %q", in, expected, got)
+		}
+	}
+	if _, err := Compile([]Spec{{Pattern: "(", Replacement: "x"}}); err == nil {
+		t.Fatalf("expected error compiling an invalid pattern")
+	}
+}
@@ -0,0 +1,185 @@
+// Package schedule parses the user's working hours (e.g. "Mon-Fri
+// 09:00-18:00"), so that the daemon can treat writes outside them (e.g. from
+// builds that run at night) differently (see config.Config.WorkHours)
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// day is the length of a day, ignoring daylight saving time transitions
+const day = 24 * time.Hour
+
+// Period is a weekly period of working hours: from Start to End (both offsets
+// from midnight, in local time) on each day in Days. If End isn't after Start,
+// the period runs past midnight into the next day
+type Period struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End time.Duration
+}
+
+// ParsePeriod parses a Period from 'spec', which is "[<days>] <start>-<end>",
+// e.g. "Mon-Fri 09:00-18:00" or "Sat 10-14". <days> is a day ("Mon"), a range
+// of days ("Mon-Fri"), "weekdays", "weekends", or "daily" (the default)
+func ParsePeriod(spec string) (Period, error) {
+	var p Period
+	fields := strings.Fields(spec)
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		days, hours = "daily", fields[0]
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return p, fmt.Errorf("invalid working hours %q (expected e.g. \"Mon-Fri "+
+			"09:00-18:00\")", spec)
+	}
+	if err := parseDays(&p, strings.ToLower(days)); err != nil {
+		return p, fmt.Errorf("invalid working hours %q: %v", spec, err)
+	}
+	i := strings.Index(hours, "-")
+	if i < 0 {
+		return p, fmt.Errorf("invalid working hours %q: expected <start>-<end> "+
+			"(e.g. \"09:00-18:00\")", spec)
+	}
+	var err error
+	if p.Start, err = parseTimeOfDay(hours[:i]); err != nil {
+		return p, fmt.Errorf("invalid working hours %q: %v", spec, err)
+	}
+	if p.End, err = parseTimeOfDay(hours[i+1:]); err != nil {
+		return p, fmt.Errorf("invalid working hours %q: %v", spec, err)
+	}
+	if p.Start == p.End {
+		return p, fmt.Errorf("invalid working hours %q: the period is empty", spec)
+	}
+	return p, nil
+}
+
+// parseDays sets the days in 'p' from the lower-cased 'days' (see ParsePeriod)
+func parseDays(p *Period, days string) error {
+	switch days {
+	case "daily":
+		for d := range p.Days {
+			p.Days[d] = true
+		}
+		return nil
+	case "weekdays":
+		days = "mon-fri"
+	case "weekends":
+		days = "sat-sun"
+	}
+	from, to := days, days
+	if i := strings.Index(days, "-"); i >= 0 {
+		from, to = days[:i], days[i+1:]
+	}
+	first, err := parseDay(from)
+	if err != nil {
+		return err
+	}
+	last, err := parseDay(to)
+	if err != nil {
+		return err
+	}
+	// Ranges may wrap around the end of the week (e.g. "Fri-Mon")
+	for d := first; ; d = (d + 1) % 7 {
+		p.Days[d] = true
+		if d == last {
+			return nil
+		}
+	}
+}
+
+// parseDay parses the name of a day, which may be abbreviated to as few as
+// three letters (e.g. "mon" or "monday")
+func parseDay(name string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if len(name) >= 3 && strings.HasPrefix(strings.ToLower(d.String()), name) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", name)
+}
+
+// parseTimeOfDay parses "15:04", "15", or "24:00" as an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hh, mm := s, "0"
+	if i := strings.Index(s, ":"); i >= 0 {
+		hh, mm = s[:i], s[i+1:]
+	}
+	h, herr := strconv.Atoi(hh)
+	m, merr := strconv.Atoi(mm)
+	if herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h > 24 ||
+		(h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid time of day %q (expected e.g. \"18:00\")", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// length returns how long each occurrence of 'p' lasts
+func (p Period) length() time.Duration {
+	if p.End > p.Start {
+		return p.End - p.Start
+	}
+	return p.End + day - p.Start
+}
+
+// Schedule is the user's working hours: the union of its periods
+type Schedule []Period
+
+// Parse parses a Schedule from 'specs', each of which is parsed by ParsePeriod
+func Parse(specs []string) (Schedule, error) {
+	var s Schedule
+	for _, spec := range specs {
+		p, err := ParsePeriod(spec)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, p)
+	}
+	return s, nil
+}
+
+// midnight returns the start of the day containing 't', in local time
+func midnight(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// Contains returns true if 't' is within the working hours in 's'. An empty
+// Schedule contains every time
+func (s Schedule) Contains(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	today := midnight(t)
+	for _, p := range s {
+		// A period that began yesterday may run past midnight
+		for _, date := range []time.Time{today, today.AddDate(0, 0, -1)} {
+			start := date.Add(p.Start)
+			if p.Days[date.Weekday()] && !t.Before(start) && t.Before(start.Add(p.length())) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextStart returns the start of the first working period in 's' after 't',
+// or the zero time if 's' is empty
+func (s Schedule) NextStart(t time.Time) time.Time {
+	var next time.Time
+	today := midnight(t)
+	for i := 0; i <= 7; i++ {
+		date := today.AddDate(0, 0, i)
+		for _, p := range s {
+			start := date.Add(p.Start)
+			if p.Days[date.Weekday()] && start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,70 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	p, err := ParsePeriod("Mon-Fri 09:00-18:30")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := Period{Start: 9 * time.Hour, End: 18*time.Hour + 30*time.Minute}
+	for d := time.Monday; d <= time.Friday; d++ {
+		expected.Days[d] = true
+	}
+	if p != expected {
+		t.Fatalf("expected %+v, but got %+v", expected, p)
+	}
+	if p, err = ParsePeriod("fri-monday 22-2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !p.Days[time.Friday] || !p.Days[time.Sunday] || !p.Days[time.Monday] ||
+		p.Days[time.Tuesday] || p.Start != 22*time.Hour || p.End != 2*time.Hour {
+		t.Fatalf("unexpected period %+v", p)
+	}
+	for _, spec := range []string{"", "9-17 Mon", "Mo 9-17", "Mon 9", "Mon 9-25",
+		"Mon 9:60-17", "Mon 9-9", "Mon-Fri 9-17 extra"} {
+		if _, err := ParsePeriod(spec); err == nil {
+			t.Fatalf("expected an error parsing %q", spec)
+		}
+	}
+}
+
+func TestContainsAndNextStart(t *testing.T) {
+	s, err := Parse([]string{"weekdays 09:00-18:00", "Sat 22:00-02:00"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// 2019-03-04 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2019, 3, day, hour, min, 0, 0, time.Local)
+	}
+	for _, c := range []struct {
+		t        time.Time
+		contains bool
+	}{
+		{at(4, 9, 0), true},
+		{at(4, 8, 59), false},
+		{at(8, 17, 59), true},  // Friday
+		{at(8, 18, 0), false},  // Friday
+		{at(9, 23, 0), true},   // Saturday night...
+		{at(10, 1, 30), true},  // ...past midnight
+		{at(10, 2, 0), false},  // Sunday
+		{at(10, 12, 0), false}, // Sunday
+	} {
+		if got := s.Contains(c.t); got != c.contains {
+			t.Fatalf("expected Contains(%s) to be %t", c.t, c.contains)
+		}
+	}
+	if next := s.NextStart(at(8, 20, 0)); !next.Equal(at(9, 22, 0)) {
+		t.Fatalf("expected the next period to start on Saturday night, not %s", next)
+	}
+	if next := s.NextStart(at(10, 1, 0)); !next.Equal(at(11, 9, 0)) {
+		t.Fatalf("expected the next period to start on Monday, not %s", next)
+	}
+	if !Schedule(nil).Contains(at(10, 1, 0)) || !Schedule(nil).NextStart(at(4, 0, 0)).IsZero() {
+		t.Fatalf("expected an empty schedule to contain every time")
+	}
+}
@@ -0,0 +1,166 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// activityLog is the log in tgStateDir's database (see persist.DBFile) where
+// every consolidated bucket of writes is recorded. Unlike the journal, it
+// doesn't depend on Toggl, so 'tg report' works without a Toggl account or
+// while the API is unreachable
+const activityLog = "activity"
+
+// SourceWindow is the Source of buckets observed in the focused window's title
+// rather than in writes (see the window package)
+const SourceWindow = "window"
+
+// Bucket is the work observed on one project during one event bucket (see
+// Watch.SetBucketSize). Root is the root watch in which the writes occurred
+// (it's unset in buckets recorded by older versions of tg). Source is unset
+// for writes, and SourceWindow for window titles
+type Bucket struct {
+	Project string    `json:"project"`
+	Root    string    `json:"root,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Files   int       `json:"files"`
+	// TopFiles are the files written most often in the bucket (see
+	// WriteEvent.TopFiles), so that users can recall what they worked on
+	TopFiles []string `json:"top_files,omitempty"`
+}
+
+// MaxTopFiles is the number of files recorded per bucket of writes (see
+// WriteEvent.TopFiles) and per block of work (see Block.TopFiles)
+const MaxTopFiles = 5
+
+// topFiles returns up to 'n' of the files in 'counts', with the highest counts
+// first (ties are broken by name)
+func topFiles(counts map[string]int, n int) []string {
+	result := make([]string, 0, len(counts))
+	for f := range counts {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if ci, cj := counts[result[i]], counts[result[j]]; ci != cj {
+			return ci > cj
+		}
+		return result[i] < result[j]
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// AppendActivity records 'b' in the activity log in 'tgStateDir'
+func AppendActivity(tgStateDir string, b Bucket) error {
+	if err := persist.AppendRecord(tgStateDir, activityLog, b); err != nil {
+		return fmt.Errorf("could not record activity: %v", err)
+	}
+	return nil
+}
+
+// ReadActivity returns all buckets in the activity log in 'tgStateDir' that
+// started in [from, to), oldest first
+func ReadActivity(tgStateDir string, from, to time.Time) ([]Bucket, error) {
+	var result []Bucket
+	if err := persist.ReadRecords(tgStateDir, activityLog, func(data []byte) error {
+		var b Bucket
+		if err := json.Unmarshal(data, &b); err != nil {
+			return fmt.Errorf("could not parse activity %q: %v", data, err)
+		}
+		if !b.Start.Before(from) && b.Start.Before(to) {
+			result = append(result, b)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
+
+// DailyTotals returns the time spent on each project on each day (formatted as
+// "2006-01-02", in local time), given the buckets in 'buckets' (oldest first).
+// As with ticks, work on a bucket's project is assumed to continue until the
+// next bucket, unless the next bucket is more than 'idleTimeout' later, in
+// which case the bucket only counts for its own duration
+func DailyTotals(buckets []Bucket, idleTimeout time.Duration) map[string]map[string]time.Duration {
+	result := make(map[string]map[string]time.Duration)
+	for i, b := range buckets {
+		d := b.End.Sub(b.Start)
+		if i+1 < len(buckets) {
+			if gap := buckets[i+1].Start.Sub(b.Start); gap <= idleTimeout {
+				d = gap
+			}
+		}
+		day := b.Start.Local().Format("2006-01-02")
+		if result[day] == nil {
+			result[day] = make(map[string]time.Duration)
+		}
+		result[day][b.Project] += d
+	}
+	return result
+}
+
+// Block is a stretch of continuous work on one project, made up of
+// consecutive buckets (see Blocks)
+type Block struct {
+	Project string
+	Start   time.Time
+	End     time.Time
+	// Files is the total number of files written in the block's buckets
+	Files int
+	// TopFiles are the files that were among the top files of the most buckets
+	// in the block, up to MaxTopFiles
+	TopFiles []string
+}
+
+// Blocks returns the stretches of continuous work on each project in
+// 'buckets' (oldest first), oldest first. As in DailyTotals, work on a
+// bucket's project continues until the next bucket, unless that's more than
+// 'idleTimeout' later. Blocks with no duration are omitted
+func Blocks(buckets []Bucket, idleTimeout time.Duration) []Block {
+	var (
+		result []Block
+		counts []map[string]int // the top files of each block's buckets
+	)
+	for i, b := range buckets {
+		end := b.End
+		if i+1 < len(buckets) && buckets[i+1].Start.Sub(b.Start) <= idleTimeout {
+			end = buckets[i+1].Start
+		}
+		if n := len(result); n > 0 && result[n-1].Project == b.Project &&
+			!b.Start.After(result[n-1].End) {
+			result[n-1].End = end
+			result[n-1].Files += b.Files
+		} else {
+			result = append(result, Block{Project: b.Project, Start: b.Start, End: end,
+				Files: b.Files})
+			counts = append(counts, make(map[string]int))
+		}
+		for _, f := range b.TopFiles {
+			counts[len(counts)-1][f]++
+		}
+	}
+	for i := range result {
+		if len(counts[i]) > 0 {
+			result[i].TopFiles = topFiles(counts[i], MaxTopFiles)
+		}
+	}
+	nonEmpty := result[:0]
+	for _, b := range result {
+		if b.End.After(b.Start) {
+			nonEmpty = append(nonEmpty, b)
+		}
+	}
+	return nonEmpty
+}
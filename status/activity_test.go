@@ -0,0 +1,53 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivity(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+
+	start := time.Date(2019, 3, 4, 9, 0, 0, 0, time.Local)
+	buckets := []Bucket{
+		{Project: "a", Start: start, End: start.Add(2 * time.Second), Files: 1,
+			TopFiles: []string{"main.go"}},
+		{Project: "a", Start: start.Add(10 * time.Minute), End: start.Add(10 * time.Minute), Files: 2,
+			TopFiles: []string{"main.go", "doc.go"}},
+		{Project: "b", Start: start.Add(20 * time.Minute), End: start.Add(20 * time.Minute), Files: 1},
+		// More than the idle timeout after the previous bucket
+		{Project: "a", Start: start.Add(2 * time.Hour), End: start.Add(2*time.Hour + time.Second), Files: 1},
+	}
+	for _, b := range buckets {
+		if err := AppendActivity(d, b); err != nil {
+			t.Fatalf("could not append activity: %v", err)
+		}
+	}
+
+	read, err := ReadActivity(d, start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not read activity: %v", err)
+	}
+	if len(read) != len(buckets) || len(read[1].TopFiles) != 2 {
+		t.Fatalf("expected %d buckets, but got %+v", len(buckets), read)
+	}
+	totals := DailyTotals(read, 15*time.Minute)
+	day := totals["2019-03-04"]
+	if len(totals) != 1 || day["a"] != 20*time.Minute+time.Second || day["b"] != 0 {
+		t.Fatalf("unexpected daily totals: %v", totals)
+	}
+
+	// Buckets in a project within the idle timeout of each other form a block
+	blocks := Blocks(read, 15*time.Minute)
+	if len(blocks) != 2 || blocks[0].Project != "a" || blocks[0].Files != 3 ||
+		!blocks[0].End.Equal(start.Add(20*time.Minute)) || blocks[1].Project != "a" ||
+		!blocks[1].Start.Equal(start.Add(2*time.Hour)) {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+	// A block's top files are those that were top files in the most buckets
+	if files := blocks[0].TopFiles; len(files) != 2 || files[0] != "main.go" ||
+		files[1] != "doc.go" || blocks[1].TopFiles != nil {
+		t.Fatalf("unexpected top files: %v, %v", files, blocks[1].TopFiles)
+	}
+}
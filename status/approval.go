@@ -0,0 +1,233 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// pendingEntriesDoc is the document in tgStateDir's database where stopped
+// time entries wait for approval before they're created in Toggl (see
+// SetRequireApproval)
+const pendingEntriesDoc = "pending_entries"
+
+// LocalEntryID is the TimeEntryID of an open time entry that's tracked
+// locally rather than in Toggl, because it must be approved before it's
+// created in Toggl (see SetRequireApproval)
+const LocalEntryID = -1
+
+// PendingEntry is a stopped time entry awaiting approval. Its ID is local to
+// tg, and unrelated to the ID of the Toggl time entry created on approval
+type PendingEntry struct {
+	ID          int64     `json:"id"`
+	Project     string    `json:"project"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Billable    bool      `json:"billable,omitempty"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+
+	// Workspace, Client, and Profile are where the entry is created on
+	// approval (see EntryOptions)
+	Workspace string `json:"workspace,omitempty"`
+	Client    string `json:"client,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+
+	// MergedIDs are the IDs of the pending entries that Consolidate merged
+	// into this one (not persisted)
+	MergedIDs []int64 `json:"-"`
+}
+
+// Validate returns an error if 'e' can't be created in Toggl
+func (e *PendingEntry) Validate() error {
+	if e.Project == "" {
+		return fmt.Errorf("entry %d has no project", e.ID)
+	}
+	if !e.Stop.After(e.Start) {
+		return fmt.Errorf("entry %d stops (%s) before it starts (%s)", e.ID,
+			e.Stop.Format(time.RFC3339), e.Start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// pendingEntries is the contents of the pending entries document. IDs aren't
+// reused, so that an ID can't refer to different entries over time
+type pendingEntries struct {
+	NextID  int64          `json:"next_id"`
+	Entries []PendingEntry `json:"entries"`
+}
+
+// updatePendingEntries applies 'f' to the pending entries in 'tgStateDir' and,
+// unless 'f' returns an error, persists the result
+func updatePendingEntries(tgStateDir string, f func(p *pendingEntries) error) error {
+	var p pendingEntries
+	var fErr error // returned by 'f' (rather than by the database)
+	err := persist.UpdateDoc(tgStateDir, pendingEntriesDoc, &p, func() error {
+		if fErr = f(&p); fErr != nil {
+			return fErr
+		}
+		if p.Entries == nil {
+			p.Entries = []PendingEntry{} // write "[]" rather than "null"
+		}
+		return nil
+	})
+	if err != nil && err != fErr {
+		return fmt.Errorf("could not update pending entries: %v", err)
+	}
+	return err
+}
+
+// ReadPendingEntries returns the time entries in 'tgStateDir' that are
+// awaiting approval, in the order in which they were stopped
+func ReadPendingEntries(tgStateDir string) ([]PendingEntry, error) {
+	var p pendingEntries
+	err := persist.ReadDoc(tgStateDir, pendingEntriesDoc, &p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read pending entries: %v", err)
+	}
+	sort.Slice(p.Entries, func(i, j int) bool { return p.Entries[i].ID < p.Entries[j].ID })
+	return p.Entries, nil
+}
+
+// addPendingEntry assigns 'e' an ID and adds it to the pending entries in
+// 'tgStateDir'
+func addPendingEntry(tgStateDir string, e PendingEntry) error {
+	return updatePendingEntries(tgStateDir, func(p *pendingEntries) error {
+		p.NextID++
+		e.ID = p.NextID
+		p.Entries = append(p.Entries, e)
+		return nil
+	})
+}
+
+// UpdatePendingEntry applies 'f' to the pending entry with the ID 'id' in
+// 'tgStateDir', and persists the result if 'f' succeeds and the entry is still
+// valid
+func UpdatePendingEntry(tgStateDir string, id int64, f func(e *PendingEntry) error) error {
+	return updatePendingEntries(tgStateDir, func(p *pendingEntries) error {
+		for i := range p.Entries {
+			if e := &p.Entries[i]; e.ID == id {
+				if err := f(e); err != nil {
+					return err
+				}
+				e.ID = id // IDs can't be edited
+				return e.Validate()
+			}
+		}
+		return fmt.Errorf("no pending entry has the ID %d", id)
+	})
+}
+
+// removePendingEntries removes the pending entries with the IDs in 'ids' from
+// 'tgStateDir'
+func removePendingEntries(tgStateDir string, ids map[int64]bool) error {
+	return updatePendingEntries(tgStateDir, func(p *pendingEntries) error {
+		kept := p.Entries[:0]
+		for _, pe := range p.Entries {
+			if !ids[pe.ID] {
+				kept = append(kept, pe)
+			}
+		}
+		p.Entries = kept
+		return nil
+	})
+}
+
+// options returns the settings with which 'e' is created in Toggl
+func (e *PendingEntry) options() EntryOptions {
+	return EntryOptions{
+		Description: e.Description,
+		Tags:        e.Tags,
+		Billable:    e.Billable,
+		Workspace:   e.Workspace,
+		Client:      e.Client,
+		Profile:     e.Profile,
+	}
+}
+
+// ApprovePendingEntries consolidates the pending entries in 'tgStateDir' with
+// the IDs in 'ids' (or all of them, if 'ids' is empty) per 'c', creates the
+// result in Toggl, using 'client' (or, for entries in a profile, its client
+// in 'profiles'), and records it in the journal. Each entry (along with any
+// merged into it) is removed from the pending entries as soon as it's
+// created, so if an entry fails, the entries approved before it are returned
+// along with the error and may be safely retried
+func ApprovePendingEntries(ctx context.Context, tgStateDir string, client *togglclient.Client,
+	profiles map[string]*togglclient.Client, ids []int64, c Consolidation) ([]PendingEntry, error) {
+	entries, err := ReadPendingEntries(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		byID := make(map[int64]PendingEntry)
+		for _, e := range entries {
+			byID[e.ID] = e
+		}
+		entries = entries[:0]
+		for _, id := range ids {
+			e, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("no pending entry has the ID %d", id)
+			}
+			entries = append(entries, e)
+		}
+	}
+	for i := range entries {
+		if err := entries[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	dropped := make(map[int64]bool) // entries that rounded to nothing
+	for _, e := range entries {
+		dropped[e.ID] = true
+	}
+	entries = Consolidate(entries, c)
+	for _, e := range entries {
+		delete(dropped, e.ID)
+		for _, id := range e.MergedIDs {
+			delete(dropped, id)
+		}
+	}
+
+	s := newCreator(ctx, tgStateDir, client, profiles)
+	var approved []PendingEntry
+	for _, e := range entries {
+		created, err := s.createStopped(e.Project, e.Start, e.Stop, e.options())
+		if err != nil {
+			return approved, fmt.Errorf("could not approve entry %d: %v", e.ID, err)
+		}
+		removed := map[int64]bool{e.ID: true}
+		for _, id := range e.MergedIDs {
+			removed[id] = true
+		}
+		if err := removePendingEntries(tgStateDir, removed); err != nil {
+			// The entry was created, so it mustn't be approved again
+			return approved, fmt.Errorf("entry %d was created in Toggl as %d, but %v",
+				e.ID, created.ID, err)
+		}
+		approved = append(approved, e)
+		if err := AppendJournal(tgStateDir, StoppedEntry{
+			TimeEntryID: created.ID,
+			Start:       e.Start,
+			Stop:        e.Stop,
+			Project:     e.Project,
+			Description: e.Description,
+			Profile:     e.Profile,
+		}); err != nil {
+			statusLog.Errorf("%v", err) // the entry was still created
+		}
+	}
+	if len(dropped) > 0 {
+		statusLog.Infof("discarding %d pending entries shorter than the rounding "+
+			"interval (%s)", len(dropped), c.Round)
+		if err := removePendingEntries(tgStateDir, dropped); err != nil {
+			return approved, err
+		}
+	}
+	return approved, nil
+}
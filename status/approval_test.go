@@ -0,0 +1,134 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestApproval(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetRequireApproval(true)
+
+	// Entries are tracked locally, and kept for approval once they stop
+	if err := s.TickWith("a", EntryOptions{Description: "first", Tags: []string{"x"}}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s.TimeEntryID() != LocalEntryID {
+		t.Fatalf("expected a local entry, but have %d", s.TimeEntryID())
+	}
+	if err := s.Tick("b"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if err := s.Stop(time.Now()); err != nil {
+		t.Fatalf("could not stop: %v", err)
+	}
+	if entries := server.TimeEntries(); len(entries) != 0 {
+		t.Fatalf("expected no entries in Toggl before approval, but got %+v", entries)
+	}
+	pending, err := ReadPendingEntries(d)
+	if err != nil {
+		t.Fatalf("could not read pending entries: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Project != "a" || pending[0].Description != "first" ||
+		len(pending[0].Tags) != 1 || pending[1].Project != "b" {
+		t.Fatalf("unexpected pending entries %+v", pending)
+	}
+
+	// Entries may be edited, but not made invalid
+	start := pending[0].Start
+	err = UpdatePendingEntry(d, pending[0].ID, func(e *PendingEntry) error {
+		e.Project, e.Stop = "c", start.Add(time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not edit pending entry: %v", err)
+	}
+	err = UpdatePendingEntry(d, pending[1].ID, func(e *PendingEntry) error {
+		e.Stop = e.Start.Add(-time.Minute)
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected an error making an entry stop before it starts")
+	}
+
+	// Approved entries are created in Toggl and journaled
+	approved, err := ApprovePendingEntries(context.Background(), d, server.Client(), nil,
+		[]int64{pending[0].ID}, Consolidation{})
+	if err != nil || len(approved) != 1 {
+		t.Fatalf("could not approve entry: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Running() || entries[0].Description != "first" ||
+		!entries[0].Stop.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected the approved entry in Toggl, but got %+v", entries)
+	}
+	journal, err := ReadJournal(d, start.Add(-time.Hour), start.Add(time.Hour))
+	if err != nil || len(journal) != 1 || journal[0].Project != "c" {
+		t.Fatalf("expected the approved entry in the journal, but got %+v (%v)", journal, err)
+	}
+	if pending, _ = ReadPendingEntries(d); len(pending) != 1 || pending[0].Project != "b" {
+		t.Fatalf("expected only \"b\" to remain pending, but got %+v", pending)
+	}
+}
+
+func TestApprovalInProfile(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server, acme := toggltest.NewServer(), toggltest.NewServer()
+	defer server.Close()
+	defer acme.Close()
+	wid := acme.AddWorkspace("Consulting")
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetProfileClient("acme", acme.Client())
+	s.SetRequireApproval(true)
+
+	// The pending entry keeps the account, workspace, and customer in which
+	// it was tracked
+	opts := EntryOptions{Workspace: "Consulting", Client: "Acme", Profile: "acme"}
+	if err := s.TickWith("Website", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if err := s.Stop(time.Now()); err != nil {
+		t.Fatalf("could not stop: %v", err)
+	}
+	pending, err := ReadPendingEntries(d)
+	if err != nil {
+		t.Fatalf("could not read pending entries: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Workspace != "Consulting" ||
+		pending[0].Client != "Acme" || pending[0].Profile != "acme" {
+		t.Fatalf("unexpected pending entries %+v", pending)
+	}
+
+	// ...and is created there on approval
+	approved, err := ApprovePendingEntries(context.Background(), d, server.Client(),
+		map[string]*togglclient.Client{"acme": acme.Client()}, nil, Consolidation{})
+	if err != nil || len(approved) != 1 {
+		t.Fatalf("could not approve entry: %v", err)
+	}
+	if entries := server.TimeEntries(); len(entries) != 0 {
+		t.Fatalf("expected no entries in the default account, but got %+v", entries)
+	}
+	entries, customers := acme.TimeEntries(), acme.Customers()
+	if len(customers) != 1 || customers[0].Name != "Acme" {
+		t.Fatalf("expected client Acme in the profile's account, but got %+v", customers)
+	}
+	if len(entries) != 1 || entries[0].WorkspaceID != wid {
+		t.Fatalf("expected an entry in workspace %d of the profile's account, but got %+v",
+			wid, entries)
+	}
+	journal, err := ReadJournal(d, time.Now().Add(-time.Hour), time.Now())
+	if err != nil || len(journal) != 1 || journal[0].Profile != "acme" {
+		t.Fatalf("expected the approved entry in the journal, but got %+v (%v)", journal, err)
+	}
+}
@@ -0,0 +1,57 @@
+package status
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Backend is the API through which a Watch observes writes. Which backends
+// are available depends on the platform (see backends)
+type Backend string
+
+const (
+	// BackendInotify adds an inotify watch for every directory under each
+	// root watch. It needs no privileges, but walking and watching very large
+	// trees is slow, and may exhaust the inotify watch limit (see rescan).
+	// Linux only
+	BackendInotify Backend = "inotify"
+
+	// BackendFanotify marks the whole filesystem (or mount) containing each
+	// root watch with fanotify, and filters its writes by path, so that no
+	// directories need to be walked or watched. It requires CAP_SYS_ADMIN,
+	// and only observes modifications to files (not creations, deletions, or
+	// renames). Linux only
+	BackendFanotify Backend = "fanotify"
+
+	// BackendPoll scans every root watch for modified files periodically
+	// (see RootOptions.PollInterval), in the same way as subtrees that can't
+	// be watched (see rescan). It works on any platform and filesystem
+	// (including network filesystems, on which inotify events aren't
+	// generated), but only observes modifications to files, and scanning very
+	// large trees is slow. It may also be set for individual roots (see
+	// RootOptions.Backend)
+	BackendPoll Backend = "poll"
+)
+
+// defaultPollInterval is how often BackendPoll scans each root watch for
+// writes, unless the root sets its own interval
+const defaultPollInterval = 10 * time.Second
+
+// ParseBackend parses the name of a Backend that's available on this
+// platform. "" is DefaultBackend
+func ParseBackend(s string) (Backend, error) {
+	if s == "" {
+		return DefaultBackend, nil
+	}
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		if Backend(s) == b {
+			return b, nil
+		}
+		names[i] = fmt.Sprintf("%q", b)
+	}
+	return "", fmt.Errorf("unknown watch backend %q (expected %s on %s)", s,
+		strings.Join(names, " or "), runtime.GOOS)
+}
@@ -0,0 +1,58 @@
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultBackend is the Backend used by Start, and by the daemon unless the
+// config sets another
+const DefaultBackend = BackendInotify
+
+// backends are the Backends available on this platform
+var backends = []Backend{BackendInotify, BackendFanotify, BackendPoll}
+
+// addDirWatch adds an inotify watch for w.iw (see
+// watcher.Options.InotifyAddWatch), using w.inotifyAddWatch if it's set. w.mu
+// must be held by the caller
+func (w *Watch) addDirWatch(fd int, path string, mask uint32) (int, error) {
+	if w.inotifyAddWatch != nil {
+		return w.inotifyAddWatch(fd, path, mask)
+	}
+	return unix.InotifyAddWatch(fd, path, mask)
+}
+
+// InotifyLimits returns the system's limits on the number of inotify watches
+// and instances per user (see inotify(7)), which bound the directories that
+// can be watched with BackendInotify
+func InotifyLimits() (watches, instances int, err error) {
+	if watches, err = readProcInt("/proc/sys/fs/inotify/max_user_watches"); err != nil {
+		return 0, 0, err
+	}
+	if instances, err = readProcInt("/proc/sys/fs/inotify/max_user_instances"); err != nil {
+		return 0, 0, err
+	}
+	return watches, instances, nil
+}
+
+// readProcInt reads the integer in the /proc file at 'path'
+func readProcInt(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %q: %v", path, err)
+	}
+	return n, nil
+}
+
+// closeFanotify closes the fanotify file descriptor 'fd'
+func closeFanotify(fd int) {
+	unix.Close(fd)
+}
@@ -0,0 +1,40 @@
+//go:build !linux
+
+package status
+
+import (
+	"errors"
+	"runtime"
+)
+
+// DefaultBackend is the Backend used by Start, and by the daemon unless the
+// config sets another
+const DefaultBackend = BackendPoll
+
+// backends are the Backends available on this platform. inotify and fanotify
+// are Linux APIs, so other platforms only scan for writes. Their native APIs
+// (e.g. ReadDirectoryChangesW on Windows) aren't supported, as the handling of
+// events in Watch (see superviseEvents and restart) assumes inotify or
+// fanotify
+var backends = []Backend{BackendPoll}
+
+// errUnsupported is returned when starting a Watch with a Backend that isn't
+// available on this platform
+var errUnsupported = errors.New("only the poll watch backend is supported on " +
+	runtime.GOOS)
+
+// InotifyLimits returns an error, as inotify is only available on Linux
+func InotifyLimits() (watches, instances int, err error) {
+	return 0, 0, errUnsupported
+}
+
+// The functions below are only called by the Linux backends, which
+// ParseBackend rejects on this platform
+
+func fanotifyInit() (int, error)                                   { return -1, errUnsupported }
+func fanotifyMark(fd int, path string) error                       { return errUnsupported }
+func (w *Watch) readFanotify(fd int, eventChan chan<- write) error { return errUnsupported }
+func (w *Watch) addDirWatch(fd int, path string, mask uint32) (int, error) {
+	return -1, errUnsupported
+}
+func closeFanotify(fd int) {}
@@ -0,0 +1,107 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/watchtest"
+)
+
+func TestPoll(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	if err := os.MkdirAll(j(d, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not make dir: %v", err)
+	}
+	stateDir := d + "-state"
+	if err := os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatalf("could not create watch state dir %q: %v", stateDir, err)
+	}
+	defer os.RemoveAll(stateDir)
+	w, err := StartWithBackend(stateDir, BackendPoll)
+	if err != nil {
+		t.Fatalf("could not start watch: %v", err)
+	}
+	defer w.Close()
+	if err := w.AddWatch(d, "project"); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Writes at any depth are found by the next scan...
+	if err := ioutil.WriteFile(j(d, "a", "b", "c"), []byte("x"), 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	w.triggerRescan()
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	// ...and only reported once
+	w.triggerRescan()
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+}
+
+func TestPollRoot(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	remote := j(d, "remote")
+	if err := os.MkdirAll(j(remote, "deep"), 0755); err != nil {
+		t.Fatalf("could not make dir: %v", err)
+	}
+	for _, o := range []RootOptions{
+		{Backend: BackendInotify},
+		{PollInterval: "1s"},
+		{Backend: BackendPoll, PollInterval: "1ms"},
+	} {
+		rw := RootWatch{Dir: remote, Project: "remote", RootOptions: o}
+		if err := rw.Validate(); err == nil {
+			t.Fatalf("expected an error validating %+v", o)
+		}
+	}
+
+	w := StartForTest(t, d)
+	defer w.Close()
+	err := w.AddWatches([]RootWatch{
+		{Dir: d, Project: "local"},
+		{Dir: remote, Project: "remote",
+			RootOptions: RootOptions{Backend: BackendPoll, PollInterval: "1s"}},
+	})
+	if err != nil {
+		t.Fatalf("could not add watches: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(e WriteEvent) {
+		if e.Project == "remote" {
+			touches <- struct{}{}
+		}
+	})
+	w.mu.Lock()
+	for _, path := range w.watched() {
+		if isUnder(path, remote) {
+			t.Errorf("expected %q not to be watched, as it's polled", path)
+		}
+	}
+	w.mu.Unlock()
+
+	// Writes in the polled root are found by the scan every PollInterval
+	if err := ioutil.WriteFile(j(remote, "deep", "f"), []byte("x"), 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestParseBackend(t *testing.T) {
+	for _, b := range backends {
+		if parsed, err := ParseBackend(string(b)); err != nil || parsed != b {
+			t.Fatalf("expected %q to parse, but got %q (%v)", b, parsed, err)
+		}
+	}
+	if b, err := ParseBackend(""); err != nil || b != DefaultBackend {
+		t.Fatalf("expected \"\" to parse as %q, but got %q (%v)", DefaultBackend, b, err)
+	}
+	if _, err := ParseBackend("kqueue"); err == nil {
+		t.Fatalf("expected an error parsing an unknown backend")
+	}
+}
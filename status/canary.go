@@ -0,0 +1,65 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// canaryDoc is the document in tgStateDir's database (see persist.DBFile)
+// where writes on which the active and candidate configs disagree are
+// recorded
+const canaryDoc = "canary"
+
+// CanaryDiff is a write that the active config and a candidate config (see
+// Watch.SetCanary) would attribute differently
+type CanaryDiff struct {
+	Time    time.Time `json:"time"`
+	Root    string    `json:"root"`
+	Project string    `json:"project"`
+	Path    string    `json:"path"`
+	// ActiveIgnored and CandidateIgnored are true if the active and candidate
+	// configs (respectively) ignore the write
+	ActiveIgnored    bool `json:"active_ignored"`
+	CandidateIgnored bool `json:"candidate_ignored"`
+}
+
+// AppendCanary records 'd' in the canary diffs in 'tgStateDir'
+func AppendCanary(tgStateDir string, d CanaryDiff) error {
+	var diffs []CanaryDiff
+	if err := persist.UpdateDoc(tgStateDir, canaryDoc, &diffs, func() error {
+		diffs = append(diffs, d)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("could not record canary diff: %v", err)
+	}
+	return nil
+}
+
+// ReadCanary returns all canary diffs in 'tgStateDir' that occurred in
+// [from, to), oldest first
+func ReadCanary(tgStateDir string, from, to time.Time) ([]CanaryDiff, error) {
+	var diffs []CanaryDiff
+	err := persist.ReadDoc(tgStateDir, canaryDoc, &diffs)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read canary diffs: %v", err)
+	}
+	var result []CanaryDiff
+	for _, d := range diffs {
+		if !d.Time.Before(from) && d.Time.Before(to) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// ClearCanary removes the canary diffs in 'tgStateDir' (e.g. once a candidate
+// config has been promoted or discarded)
+func ClearCanary(tgStateDir string) error {
+	if err := persist.DeleteDoc(tgStateDir, canaryDoc); err != nil {
+		return fmt.Errorf("could not remove canary diffs: %v", err)
+	}
+	return nil
+}
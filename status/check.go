@@ -0,0 +1,80 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	p "path"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// StateFileProblem is a state file in tgStateDir, or a document or log in its
+// database (see persist.DBFile), that can't be read (see CheckStateFiles)
+type StateFileProblem struct {
+	Path string
+	Err  error
+	// Doc is true if Path identifies a document in the database (see
+	// persist.DocPath), which is moved aside the next time it's read, rather
+	// than a file
+	Doc bool
+	// MovedAside is true if the file or document was already found to be
+	// corrupt and moved aside (see persist.CorruptError), so its contents were
+	// lost
+	MovedAside bool
+}
+
+// CheckStateFiles checks that the database in 'tgStateDir' is consistent and
+// that every document and log in it, and every state file, can be parsed.
+// Unlike reads, it doesn't move aside the documents that can't, so it's safe
+// to call while the daemon is running. Files and documents moved aside by
+// earlier reads are also reported
+func CheckStateFiles(tgStateDir string) ([]StateFileProblem, error) {
+	var result []StateFileProblem
+	if err := persist.CheckDB(tgStateDir); err != nil {
+		// Its documents and logs can't be checked either
+		return []StateFileProblem{{Path: p.Join(tgStateDir, persist.DBFile), Err: err}}, nil
+	}
+	for _, name := range []string{tickDoc, watchDoc, outboxDoc, pendingEntriesDoc,
+		aliveDoc, canaryDoc} {
+		if err := persist.CheckDoc(tgStateDir, name); err != nil {
+			result = append(result, StateFileProblem{Path: persist.DocPath(tgStateDir, name),
+				Err: err, Doc: true})
+		}
+	}
+	// Records are only ever appended whole, so a bad one means the database
+	// itself was damaged
+	for _, name := range []string{journalLog, activityLog, gapsLog} {
+		if err := persist.CheckRecords(tgStateDir, name); err != nil {
+			result = append(result, StateFileProblem{Path: p.Join(tgStateDir, persist.DBFile),
+				Err: err})
+		}
+	}
+	corrupt, err := persist.CorruptFiles(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range corrupt {
+		result = append(result, StateFileProblem{Path: path,
+			Err: fmt.Errorf("corrupt state was moved aside"), MovedAside: true})
+	}
+	return result, nil
+}
+
+// WatchLockPath returns the path of the lock file in 'tgStateDir' that's held
+// by the process watching its root watches (i.e. the daemon)
+func WatchLockPath(tgStateDir string) string {
+	return p.Join(tgStateDir, lockFileName)
+}
+
+// WatchLocked returns true if a process holds the lock file in 'tgStateDir'
+// (see WatchLockPath), in which case no other process can start watching
+func WatchLocked(tgStateDir string) (bool, error) {
+	f, err := os.Open(WatchLockPath(tgStateDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not open watch lock file: %v", err)
+	}
+	defer f.Close() // releases the lock, if it was acquired
+	return locked(f)
+}
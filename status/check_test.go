@@ -0,0 +1,65 @@
+package status
+
+import (
+	"os"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/persist"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCheckStateFiles(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	if err := SaveRootWatches(d, []RootWatch{{Dir: d, Project: "a"}}); err != nil {
+		t.Fatalf("could not save watches: %v", err)
+	}
+	problems, err := CheckStateFiles(d)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected no problems, but got %+v (err: %v)", problems, err)
+	}
+
+	// Damage the watch document (in the database's "docs" bucket) and a
+	// journal record, as a disk error might
+	db, err := bolt.Open(j(d, persist.DBFile), 0644, nil)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("docs")).Put([]byte(watchDoc), []byte("{")); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(journalLog))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("x"), []byte(`{"start":`))
+	}); err != nil {
+		t.Fatalf("could not damage database: %v", err)
+	}
+	db.Close()
+
+	// Both are reported, and the document is left in place
+	problems, err = CheckStateFiles(d)
+	if err != nil {
+		t.Fatalf("could not check state files: %v", err)
+	}
+	if len(problems) != 2 || !problems[0].Doc || problems[1].Doc {
+		t.Fatalf("expected 2 problems, but got %+v", problems)
+	}
+	if _, err := os.Stat(j(d, watchDoc+".corrupt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the watch document to be left as it was, but got %v", err)
+	}
+
+	// Reading the document moves it aside, which is reported too
+	if _, err := ListRootWatches(d); err == nil {
+		t.Fatalf("expected an error reading the corrupt watch document")
+	}
+	problems, err = CheckStateFiles(d)
+	if err != nil {
+		t.Fatalf("could not check state files: %v", err)
+	}
+	if len(problems) != 2 || !problems[1].MovedAside {
+		t.Fatalf("expected the watch document to be moved aside, but got %+v", problems)
+	}
+}
@@ -0,0 +1,81 @@
+package status
+
+import (
+	"sort"
+	"time"
+)
+
+// Consolidation configures how time entries awaiting approval are tidied up
+// before they're created in Toggl (see Consolidate), so that rapid switching
+// doesn't leave Toggl cluttered with very short entries
+type Consolidation struct {
+	// MergeGap, if set, merges adjacent entries in the same project (with the
+	// same billable flag) that are separated by less than this
+	MergeGap time.Duration
+	// Round, if set, rounds the start and stop of each entry to the nearest
+	// multiple of this (e.g. 5m). Entries that round to nothing are dropped
+	Round time.Duration
+}
+
+// Consolidate merges and rounds 'entries' per 'c', and returns the result in
+// order of their start times. An entry that others were merged into keeps its
+// own ID, and lists theirs in MergedIDs, along with any entries that it
+// absorbed before. Entries with an empty project aren't merged
+func Consolidate(entries []PendingEntry, c Consolidation) []PendingEntry {
+	sorted := append([]PendingEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+	var result []PendingEntry
+	for _, e := range sorted {
+		if n := len(result); n > 0 && c.MergeGap > 0 && canMerge(result[n-1], e, c.MergeGap) {
+			result[n-1] = merge(result[n-1], e)
+			continue
+		}
+		result = append(result, e)
+	}
+	if c.Round <= 0 {
+		return result
+	}
+	rounded := result[:0]
+	for _, e := range result {
+		e.Start, e.Stop = e.Start.Round(c.Round), e.Stop.Round(c.Round)
+		if e.Stop.After(e.Start) {
+			rounded = append(rounded, e)
+		}
+	}
+	return rounded
+}
+
+// canMerge returns true if 'next', which starts no earlier than 'prev', may be
+// merged into it. Entries in different accounts, workspaces, or customers
+// never merge, even if their projects have the same name
+func canMerge(prev, next PendingEntry, gap time.Duration) bool {
+	return prev.Project != "" && prev.Project == next.Project &&
+		prev.Workspace == next.Workspace && prev.Client == next.Client &&
+		prev.Profile == next.Profile &&
+		prev.Billable == next.Billable && next.Start.Sub(prev.Stop) < gap
+}
+
+// merge returns the entry spanning 'prev' and 'next'. It has the description
+// of 'prev' (or of 'next', if 'prev' has none) and the tags of both
+func merge(prev, next PendingEntry) PendingEntry {
+	if next.Stop.After(prev.Stop) {
+		prev.Stop = next.Stop
+	}
+	if prev.Description == "" {
+		prev.Description = next.Description
+	}
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range append(append([]string(nil), prev.Tags...), next.Tags...) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	prev.Tags = tags
+	merged := append([]int64(nil), prev.MergedIDs...)
+	prev.MergedIDs = append(append(merged, next.ID), next.MergedIDs...)
+	return prev
+}
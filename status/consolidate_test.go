@@ -0,0 +1,43 @@
+package status
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConsolidate(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	entries := []PendingEntry{
+		{ID: 1, Project: "a", Start: at(0), Stop: at(2), Tags: []string{"x"}},
+		{ID: 3, Project: "a", Start: at(5), Stop: at(7), Tags: []string{"x", "y"}},
+		{ID: 2, Project: "a", Start: at(3), Stop: at(4), Description: "d"},
+		{ID: 4, Project: "b", Start: at(8), Stop: at(9)},
+		{ID: 5, Project: "b", Start: at(30), Stop: at(52)},
+	}
+
+	// Adjacent entries in the same project within the gap are merged, in
+	// order of their start times
+	got := Consolidate(entries, Consolidation{MergeGap: 2 * time.Minute})
+	expected := []PendingEntry{
+		{ID: 1, Project: "a", Start: at(0), Stop: at(7), Description: "d",
+			Tags: []string{"x", "y"}, MergedIDs: []int64{2, 3}},
+		{ID: 4, Project: "b", Start: at(8), Stop: at(9)},
+		{ID: 5, Project: "b", Start: at(30), Stop: at(52)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, got)
+	}
+
+	// Rounding drops entries that round to nothing
+	got = Consolidate(entries, Consolidation{MergeGap: 2 * time.Minute,
+		Round: 15 * time.Minute})
+	expected = []PendingEntry{
+		{ID: 5, Project: "b", Start: at(30), Stop: at(45)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, got)
+	}
+}
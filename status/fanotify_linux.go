@@ -0,0 +1,189 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/msteffen/toggl-watcher/metrics"
+	"github.com/msteffen/toggl-watcher/watcher"
+	"golang.org/x/sys/unix"
+)
+
+// Constants from linux/fanotify.h, which x/sys/unix doesn't define
+const (
+	fanCloexec      = 0x1
+	fanNonblock     = 0x2
+	fanClassNotif   = 0x0
+	fanMarkAdd      = 0x1
+	fanMarkMount    = 0x10
+	fanMarkFS       = 0x100
+	fanModify       = 0x2
+	fanQOverflow    = 0x4000
+	fanNoFd         = -1
+	fanMetadataVers = 3
+)
+
+// fanotifyEventMetadata is struct fanotify_event_metadata
+type fanotifyEventMetadata struct {
+	EventLen    uint32
+	Vers        uint8
+	Reserved    uint8
+	MetadataLen uint16
+	Mask        uint64
+	Fd          int32
+	Pid         int32
+}
+
+// fanotifyPollTimeout bounds how long readFanotify waits for events before
+// checking whether the Watch was closed
+const fanotifyPollTimeout = time.Second
+
+// fanotifyInit creates a fanotify fd that reports events with read-only fds
+// for the modified files
+func fanotifyInit() (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_FANOTIFY_INIT,
+		fanClassNotif|fanCloexec|fanNonblock,
+		uintptr(unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC), 0)
+	if errno == unix.EPERM {
+		return -1, errors.New("could not create fanotify fd: the fanotify " +
+			"backend requires CAP_SYS_ADMIN (e.g. run 'tg resume' as root)")
+	} else if errno != 0 {
+		return -1, fmt.Errorf("could not create fanotify fd: %v", errno)
+	}
+	return int(fd), nil
+}
+
+// fanotifyMark adds the filesystem containing 'path' to the fanotify fd 'fd',
+// or its mount if the kernel can't mark whole filesystems (before Linux 4.20)
+func fanotifyMark(fd int, path string) error {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		// 32-bit platforms pass the 64-bit mask in two registers
+		return errors.New("the fanotify backend is only supported on 64-bit platforms")
+	}
+	pathPtr, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	dirfd := unix.AT_FDCWD
+	mark := func(flags uintptr) syscall.Errno {
+		_, _, errno := unix.Syscall6(unix.SYS_FANOTIFY_MARK, uintptr(fd),
+			fanMarkAdd|flags, fanModify, uintptr(dirfd),
+			uintptr(unsafe.Pointer(pathPtr)), 0)
+		return errno
+	}
+	errno := mark(fanMarkFS)
+	if errno == unix.EINVAL {
+		errno = mark(fanMarkMount)
+	}
+	if errno != 0 {
+		return fmt.Errorf("could not add fanotify mark for %q: %v", path, errno)
+	}
+	return nil
+}
+
+// inSkippedDir returns true if 'path' is in a directory under 'root' that
+// the inotify backend wouldn't watch (see skipDir), so that the fanotify
+// backend ignores the same writes. w.mu must be held by the caller
+func (w *Watch) inSkippedDir(root, path string) bool {
+	for dir := fp.Dir(path); isUnder(dir, root) && dir != root; dir = fp.Dir(dir) {
+		if w.skipDir(root, dir) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readFanotify reads fanotify events from 'fd', and writes each one that's
+// under a root watch to 'eventChan'. Like readWatcher, it returns when reading
+// fails or the Watch is closed
+func (w *Watch) readFanotify(fd int, eventChan chan<- write) error {
+	buf := make([]byte, 4096*int(unsafe.Sizeof(fanotifyEventMetadata{})))
+	lastRead := time.Now()
+	for {
+		// Poll, rather than blocking in read, so that Close is noticed
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		_, err := unix.Poll(fds, int(fanotifyPollTimeout/time.Millisecond))
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+		if closed {
+			unix.Close(fd)
+			return errClosed
+		}
+		if err == unix.EINTR || (err == nil && fds[0].Revents&unix.POLLIN == 0) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not poll fanotify fd: %v", err)
+		}
+		n, err := unix.Read(fd, buf)
+		readAt := time.Now()
+		switch {
+		case err == unix.EAGAIN || err == unix.EINTR:
+			continue
+		case err != nil:
+			return fmt.Errorf("fanotify read error: %v", err)
+		case n == 0:
+			return errors.New("fanotify fd was closed")
+		}
+		overflowed := false
+		for idx := 0; idx < n; {
+			if n-idx < int(unsafe.Sizeof(fanotifyEventMetadata{})) {
+				return fmt.Errorf("short read of event at %d/%d", idx, n)
+			}
+			event := (*fanotifyEventMetadata)(unsafe.Pointer(&buf[idx]))
+			if event.Vers != fanMetadataVers {
+				return fmt.Errorf("unsupported fanotify metadata version %d", event.Vers)
+			} else if event.EventLen == 0 || idx+int(event.EventLen) > n {
+				return fmt.Errorf("malformed event at %d/%d", idx, n)
+			}
+			idx += int(event.EventLen)
+			if event.Mask&fanQOverflow > 0 {
+				overflowed = true // but close the fds of the remaining events
+			}
+			if event.Fd == fanNoFd {
+				continue
+			}
+			path, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(event.Fd)))
+			unix.Close(int(event.Fd))
+			if err != nil || overflowed {
+				continue // e.g. the file was deleted
+			}
+			metrics.EventsReceived.Add(1)
+			w.mu.Lock()
+			root := w.rootFor(path)
+			if root == "" || w.inSkippedDir(root, path) {
+				w.mu.Unlock()
+				metrics.EventsFiltered.Add("unwatched", 1)
+				continue // most writes on the filesystem aren't under any root
+			}
+			ignored, diff := w.classify(root, path, false)
+			if !w.eventMask(root).Has(watcher.Modify) {
+				ignored, diff = true, nil // not a write, per the root's events
+			}
+			canary := w.canary
+			w.mu.Unlock()
+			watchLog.Debugf("event: MODIFY %s", path)
+
+			now := time.Now()
+			w.queue.Submit(root, func() {
+				if isIgnoreFile(path) {
+					w.mu.Lock()
+					w.loadIgnoreRules(root) // the ignore file changed
+					w.mu.Unlock()
+				}
+				w.publish(write{root: root, path: path, time: now}, ignored, diff, canary,
+					eventChan)
+			})
+		}
+		if overflowed {
+			return overflowError{since: lastRead}
+		}
+		lastRead = readAt
+	}
+}
@@ -0,0 +1,62 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/watchtest"
+	"golang.org/x/sys/unix"
+)
+
+func TestFanotify(t *testing.T) {
+	t.Parallel()
+	if fd, err := fanotifyInit(); err != nil {
+		t.Skipf("fanotify is unavailable: %v", err)
+	} else {
+		unix.Close(fd)
+	}
+	d := GetTestDir(t)
+	for _, dir := range []string{j(d, "a", "b"), j(d, ".hidden")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", dir, err)
+		}
+	}
+	stateDir := d + "-state"
+	if err := os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatalf("could not create watch state dir %q: %v", stateDir, err)
+	}
+	defer os.RemoveAll(stateDir)
+	w, err := StartWithBackend(stateDir, BackendFanotify)
+	if err != nil {
+		t.Fatalf("could not start watch: %v", err)
+	}
+	defer w.Close()
+	if err := w.AddWatch(d, "project"); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	w.mu.Lock()
+	watched := len(w.watched())
+	w.mu.Unlock()
+	if watched != 0 {
+		t.Fatalf("expected no per-directory watches, but have %d", watched)
+	}
+
+	write := func(path string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("could not write %q: %v", path, err)
+		}
+	}
+	// Writes at any depth are seen, without walking the tree...
+	write(j(d, "a", "b", "c"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	// ...except in directories that wouldn't be watched, or outside any root
+	write(j(d, ".hidden", "c"))
+	write(stateDir + "/c")
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+}
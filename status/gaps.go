@@ -0,0 +1,102 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+const (
+	// aliveDoc is the document in tgStateDir's database (see persist.DBFile)
+	// where the daemon periodically records that it's still running
+	aliveDoc = "alive"
+
+	// gapsLog is the log in tgStateDir's database where periods in which the
+	// daemon wasn't running are recorded (one Gap per record)
+	gapsLog = "gaps"
+)
+
+var (
+	// minGap is the shortest period of downtime that's recorded as a Gap.
+	// Shorter gaps are indistinguishable from the delay between the daemon's
+	// periodic MarkAlive() calls
+	minGap = 5 * time.Minute
+)
+
+// Gap is a period of time during which the tg daemon wasn't running, and
+// therefore any work that happened wasn't tracked
+type Gap struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of 'g'
+func (g Gap) Duration() time.Duration {
+	return g.End.Sub(g.Start)
+}
+
+func (g Gap) String() string {
+	return fmt.Sprintf("%s - %s (%s)", g.Start.Format(time.RFC3339),
+		g.End.Format(time.RFC3339), g.Duration().Round(time.Minute))
+}
+
+// MarkAlive records that the tg daemon was running at time 't'
+func MarkAlive(tgStateDir string, t time.Time) error {
+	if err := persist.WriteDoc(tgStateDir, aliveDoc, t); err != nil {
+		return fmt.Errorf("could not record that the daemon is alive: %v", err)
+	}
+	return nil
+}
+
+// LastAlive returns the last time passed to MarkAlive, or the zero time if
+// MarkAlive has never been called
+func LastAlive(tgStateDir string) (time.Time, error) {
+	var t time.Time
+	err := persist.ReadDoc(tgStateDir, aliveDoc, &t)
+	if err != nil && !os.IsNotExist(err) {
+		return time.Time{}, fmt.Errorf("could not read when the daemon was last alive: %v", err)
+	}
+	return t, nil
+}
+
+// RecordStartup should be called when the tg daemon starts. It records the
+// period since the daemon was last alive as a Gap (if it's long enough) and
+// returns it (or nil if there was no gap)
+func RecordStartup(tgStateDir string, now time.Time) (*Gap, error) {
+	last, err := LastAlive(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	var gap *Gap
+	if !last.IsZero() && now.Sub(last) >= minGap {
+		gap = &Gap{Start: last, End: now}
+		if err := persist.AppendRecord(tgStateDir, gapsLog, gap); err != nil {
+			return nil, fmt.Errorf("could not record gap: %v", err)
+		}
+	}
+	return gap, MarkAlive(tgStateDir, now)
+}
+
+// ReadGaps returns all recorded Gaps that ended after 'since', oldest first.
+// Records that can't be parsed are skipped, so that one bad record doesn't
+// hide the others
+func ReadGaps(tgStateDir string, since time.Time) ([]Gap, error) {
+	var result []Gap
+	if err := persist.ReadRecords(tgStateDir, gapsLog, func(data []byte) error {
+		var g Gap
+		if err := json.Unmarshal(data, &g); err != nil {
+			statusLog.Warnf("skipping gap %q that can't be parsed: %v", data, err)
+			return nil
+		}
+		if g.End.After(since) {
+			result = append(result, g)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not read gaps: %v", err)
+	}
+	return result, nil
+}
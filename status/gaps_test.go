@@ -0,0 +1,95 @@
+package status
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordStartup(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	start := time.Date(2018, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	// First startup: no previous heartbeat, so no gap
+	if g, err := RecordStartup(d, start); err != nil || g != nil {
+		t.Fatalf("expected no gap on first startup, but got %v (%v)", g, err)
+	}
+
+	// Short restart: not recorded
+	if err := MarkAlive(d, start.Add(time.Hour)); err != nil {
+		t.Fatalf("could not mark alive: %v", err)
+	}
+	if g, err := RecordStartup(d, start.Add(time.Hour+time.Minute)); err != nil || g != nil {
+		t.Fatalf("expected no gap after short restart, but got %v (%v)", g, err)
+	}
+
+	// Long restart: recorded
+	down := start.Add(2 * time.Hour)
+	if err := MarkAlive(d, down); err != nil {
+		t.Fatalf("could not mark alive: %v", err)
+	}
+	g, err := RecordStartup(d, down.Add(3*time.Hour))
+	if err != nil || g == nil || g.Duration() != 3*time.Hour {
+		t.Fatalf("expected 3h gap, but got %v (%v)", g, err)
+	}
+
+	gaps, err := ReadGaps(d, start)
+	if err != nil {
+		t.Fatalf("could not read gaps: %v", err)
+	}
+	if len(gaps) != 1 || !gaps[0].Start.Equal(down) {
+		t.Fatalf("expected one gap starting at %v, but got %v", down, gaps)
+	}
+	if gaps, _ := ReadGaps(d, down.Add(4*time.Hour)); len(gaps) != 0 {
+		t.Fatalf("expected no gaps after %v, but got %v", down.Add(4*time.Hour), gaps)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	pings := make(chan struct{}, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings <- struct{}{}
+	}))
+	defer s.Close()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Heartbeat(10*time.Millisecond, s.URL, stop)
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for heartbeat ping")
+		}
+	}
+	close(stop)
+	<-done
+	// Pings don't record that the daemon is alive; KeepAlive does that
+	if last, err := LastAlive(d); err != nil || !last.IsZero() {
+		t.Fatalf("expected no record that the daemon is alive, but got %v (%v)", last, err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		keepAlive(d, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+	if last, err := LastAlive(d); err != nil || time.Since(last) > time.Minute {
+		t.Fatalf("expected recent heartbeat, but got %v (%v)", last, err)
+	}
+}
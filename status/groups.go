@@ -0,0 +1,64 @@
+package status
+
+import "fmt"
+
+// ListRootWatches returns every root watch in the watch state in 'tgStateDir',
+// along with its ID and options, sorted by directory
+func ListRootWatches(tgStateDir string) ([]RootWatch, error) {
+	s, err := readStore(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	return s.sorted(), nil
+}
+
+// RootWatchByID returns the root watch with the ID 'id' in the watch state in
+// 'tgStateDir'
+func RootWatchByID(tgStateDir string, id int) (RootWatch, error) {
+	s, err := readStore(tgStateDir)
+	if err != nil {
+		return RootWatch{}, err
+	}
+	for _, rw := range s.Watches {
+		if rw.ID == id {
+			return rw, nil
+		}
+	}
+	return RootWatch{}, fmt.Errorf("no watched directory has the ID %d", id)
+}
+
+// UpdateRootWatches applies 'f' to each root watch in the watch state in
+// 'tgStateDir', in order of directory, and persists the modified projects and
+// options (a root watch's directory and ID can't be changed). If 'f' returns
+// an error, nothing is persisted. A running daemon must be told to Reload()
+// its Watch for the changes to take effect
+func UpdateRootWatches(tgStateDir string, f func(rw *RootWatch) error) error {
+	return updateStore(tgStateDir, func(s *watchStore) error {
+		for _, rw := range s.sorted() {
+			dir, id := rw.Dir, rw.ID
+			if err := f(&rw); err != nil {
+				return err
+			}
+			rw.Dir, rw.ID = dir, id
+			s.put(rw)
+		}
+		return nil
+	})
+}
+
+// UpdateGroup applies 'f' to each root watch in the group 'group' (see
+// UpdateRootWatches). It fails if the group has no members
+func UpdateGroup(tgStateDir, group string, f func(rw *RootWatch)) error {
+	var members int
+	err := UpdateRootWatches(tgStateDir, func(rw *RootWatch) error {
+		if rw.Group == group {
+			members++
+			f(rw)
+		}
+		return nil
+	})
+	if err == nil && members == 0 {
+		return fmt.Errorf("no watched directories are in the group %q", group)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package status
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUpdateGroup(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	var watches []RootWatch
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Mkdir(j(d, name), 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", j(d, name), err)
+		}
+		watches = append(watches, RootWatch{Dir: j(d, name), Project: name})
+	}
+	watches[0].Group, watches[1].Group = "client", "client"
+	if err := SaveRootWatches(d, watches); err != nil {
+		t.Fatalf("could not save watches: %v", err)
+	}
+
+	err := UpdateGroup(d, "client", func(rw *RootWatch) {
+		rw.Project = "client-work"
+		rw.Disabled = true
+	})
+	if err != nil {
+		t.Fatalf("could not update group: %v", err)
+	}
+	listed, err := ListRootWatches(d)
+	if err != nil || len(listed) != 3 {
+		t.Fatalf("expected 3 watches, but got %+v (%v)", listed, err)
+	}
+	for _, rw := range listed[:2] {
+		if rw.Project != "client-work" || !rw.Disabled || rw.Group != "client" {
+			t.Fatalf("expected %q to be updated, but got %+v", rw.Dir, rw)
+		}
+	}
+	if c := listed[2]; c.Project != "c" || c.Disabled {
+		t.Fatalf("expected %q (not in the group) to be unchanged, but got %+v", c.Dir, c)
+	}
+
+	if err := UpdateGroup(d, "nonexistent", func(*RootWatch) {}); err == nil {
+		t.Fatalf("expected error updating empty group")
+	}
+}
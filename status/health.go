@@ -0,0 +1,64 @@
+package status
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// RootHealth describes how a root watch is being watched, to help diagnose
+// root watches whose writes aren't being tracked (see 'tg list')
+type RootHealth struct {
+	Dir     string `json:"dir"`
+	Project string `json:"project"`
+	// Backend is the API through which writes under Dir are observed, or ""
+	// if the root watch is broken (see Broken)
+	Backend Backend `json:"backend,omitempty"`
+	// Descriptors is the number of inotify watches on Dir and the directories
+	// under it
+	Descriptors int `json:"descriptors"`
+	// LastEvent is the time of the latest write observed under Dir since the
+	// Watch started, or the zero time if there has been none
+	LastEvent time.Time `json:"last_event"`
+	// Exists is false if Dir no longer exists (or isn't a directory)
+	Exists bool `json:"exists"`
+	// Broken is true if Dir was moved to an unknown path (or was missing when
+	// the Watch started), so it isn't being watched
+	Broken bool `json:"broken,omitempty"`
+}
+
+// Health returns the health of every root watch in 'w', including broken
+// ones, ordered by directory
+func (w *Watch) Health() []RootHealth {
+	w.mu.Lock()
+	descriptors := make(map[string]int)
+	for _, dir := range w.watched() {
+		descriptors[w.rootFor(dir)]++
+	}
+	result := make([]RootHealth, 0, len(w.rootWatches)+len(w.broken))
+	for dir, project := range w.rootWatches {
+		backend := w.backend
+		if w.polled(dir) {
+			backend = BackendPoll
+		}
+		result = append(result, RootHealth{
+			Dir:         dir,
+			Project:     project,
+			Backend:     backend,
+			Descriptors: descriptors[dir],
+			LastEvent:   w.lastEvent[dir],
+		})
+	}
+	for dir, project := range w.broken {
+		result = append(result, RootHealth{Dir: dir, Project: project, Broken: true})
+	}
+	w.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Dir < result[j].Dir })
+	for i := range result {
+		h := &result[i]
+		info, err := os.Stat(h.Dir)
+		h.Exists = err == nil && info.IsDir()
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package status
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often the tg daemon pings its healthcheck
+// URL (see Heartbeat), unless configured otherwise
+const DefaultHeartbeatInterval = time.Minute
+
+// aliveInterval is how often KeepAlive records that the daemon is alive. It
+// must be well under minGap, so that a running daemon is never taken to have
+// been down
+const aliveInterval = time.Minute
+
+// pingTimeout bounds each request to the external healthcheck URL, so that a
+// slow healthcheck service can't delay the next heartbeat
+const pingTimeout = 10 * time.Second
+
+// KeepAlive records that the daemon is alive (see MarkAlive) periodically
+// until 'stop' is closed, so that RecordStartup can tell how long the daemon
+// was down when it next starts. Unlike Heartbeat, it always runs
+func KeepAlive(tgStateDir string, stop <-chan struct{}) {
+	keepAlive(tgStateDir, aliveInterval, stop)
+}
+
+// keepAlive implements KeepAlive, recording that the daemon is alive every
+// 'interval'
+func keepAlive(tgStateDir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := MarkAlive(tgStateDir, time.Now()); err != nil {
+			statusLog.Errorf("could not record that the daemon is alive: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Heartbeat sends a GET request to 'pingURL' (e.g. a healthchecks.io check
+// URL) every 'interval' until 'stop' is closed, so that external monitoring
+// services can alert when the beats stop
+func Heartbeat(interval time.Duration, pingURL string, stop <-chan struct{}) {
+	client := &http.Client{Timeout: pingTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := ping(client, pingURL); err != nil {
+			statusLog.Warnf("heartbeat ping failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ping sends a GET request to 'url' and checks that it succeeded
+func ping(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
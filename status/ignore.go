@@ -0,0 +1,188 @@
+package status
+
+import (
+	"bufio"
+	"os"
+	p "path"
+	fp "path/filepath"
+	"sort"
+	"strings"
+)
+
+// ignoreFileNames are the files whose patterns (in .gitignore syntax) are
+// used to ignore writes in the directories that contain them. .tgignore files
+// can be used to ignore paths that should be tracked by git but not by tg
+var ignoreFileNames = []string{".gitignore", ".tgignore"}
+
+// isIgnoreFile returns true if 'path' is one of ignoreFileNames
+func isIgnoreFile(path string) bool {
+	base := p.Base(path)
+	for _, name := range ignoreFileNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is a single pattern from an ignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool // pattern started with '!', so it re-includes matching paths
+	dirOnly  bool // pattern ended with '/', so it only matches directories
+	anchored bool // pattern contained '/', so it matches relative to its file
+}
+
+// match returns true if 'rel' (a path relative to the rule's ignore file)
+// matches 'r'
+func (r *ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+	}
+	ok, _ := p.Match(r.pattern, p.Base(rel))
+	return ok
+}
+
+// matchSegments matches the path segments 'name' against the pattern
+// segments 'pattern', in which "**" matches any number of segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := p.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// parseIgnoreFile reads the rules in the ignore file at 'path'
+func parseIgnoreFile(path string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\") // e.g. "\#file" or "\!file"
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// ignoreFile is the parsed contents of a single ignore file
+type ignoreFile struct {
+	dir   string // directory containing the file, relative to the root watch
+	rules []ignoreRule
+}
+
+// ignoreRules are the rules from all of the ignore files under a root watch
+type ignoreRules []ignoreFile
+
+// loadIgnoreRules reads all ignore files under 'root'
+func loadIgnoreRules(root string) ignoreRules {
+	var result ignoreRules
+	fp.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // skip unreadable paths
+		}
+		if info.IsDir() {
+			if path != root && p.Base(path) == ".git" {
+				return fp.SkipDir
+			}
+			return nil
+		}
+		if !isIgnoreFile(path) {
+			return nil
+		}
+		rules, err := parseIgnoreFile(path)
+		if err != nil || len(rules) == 0 {
+			return nil
+		}
+		dir := strings.TrimPrefix(strings.TrimPrefix(p.Dir(path), root), "/")
+		result = append(result, ignoreFile{dir: dir, rules: rules})
+		return nil
+	})
+	// Rules in deeper files take precedence, so evaluate them last
+	sort.SliceStable(result, func(i, j int) bool {
+		return depth(result[i].dir) < depth(result[j].dir)
+	})
+	return result
+}
+
+// depth returns the number of path components in the relative path 'dir'
+func depth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// ignored returns true if 'rel' (a path relative to the root watch) is ignored
+// by 'rules', either directly or because one of its parent directories is
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	if len(rules) == 0 || rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		if rules.matches(prefix, i < len(parts) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if the last rule that matches 'rel' ignores it
+func (rules ignoreRules) matches(rel string, isDir bool) bool {
+	var result bool
+	for _, f := range rules {
+		fileRel := rel
+		if f.dir != "" {
+			if !strings.HasPrefix(rel, f.dir+"/") {
+				continue
+			}
+			fileRel = strings.TrimPrefix(rel, f.dir+"/")
+		}
+		for i := range f.rules {
+			if f.rules[i].match(fileRel, isDir) {
+				result = !f.rules[i].negate
+			}
+		}
+	}
+	return result
+}
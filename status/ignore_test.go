@@ -0,0 +1,51 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	if err := os.MkdirAll(j(d, "sub"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "sub"), err)
+	}
+	files := map[string]string{
+		".gitignore":     "# comment\nnode_modules/\n*.o\n!keep.o\n/build\ndocs/**/*.html\n",
+		"sub/.tgignore":  "*.log\n",
+		"sub/.gitignore": "!main.o\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(j(d, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %q: %v", name, err)
+		}
+	}
+
+	rules := loadIgnoreRules(d)
+	for _, c := range []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"node_modules", true, true},
+		{"node_modules", false, false}, // dir-only pattern
+		{"a/node_modules/x.js", false, true},
+		{"a.o", false, true},
+		{"keep.o", false, false},
+		{"sub/main.o", false, false}, // re-included by a deeper file
+		{"sub/other.o", false, true},
+		{"build/out", false, true},
+		{"sub/build", false, false}, // anchored to the root
+		{"docs/a/b/c.html", false, true},
+		{"docs/c.html", false, true},
+		{"sub/x.log", false, true},
+		{"x.log", false, false}, // .tgignore only applies under sub/
+		{"main.go", false, false},
+	} {
+		if got := rules.ignored(c.rel, c.isDir); got != c.ignored {
+			t.Errorf("ignored(%q, %t): expected %t, but got %t", c.rel, c.isDir, c.ignored, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// journalLog is the log in tgStateDir's database (see persist.DBFile) where
+// every stopped time entry is recorded, so that tg's record of tracked time can
+// be checked against Toggl's (see 'tg verify' and Reconcile)
+const journalLog = "journal"
+
+// AppendJournal records 'e' in the journal in 'tgStateDir'
+func AppendJournal(tgStateDir string, e StoppedEntry) error {
+	if err := persist.AppendRecord(tgStateDir, journalLog, e); err != nil {
+		return fmt.Errorf("could not record time entry in the journal: %v", err)
+	}
+	return nil
+}
+
+// ReadJournal returns all time entries in the journal in 'tgStateDir' that
+// started in [from, to), oldest first
+func ReadJournal(tgStateDir string, from, to time.Time) ([]StoppedEntry, error) {
+	entries, err := readJournalFile(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	var result []StoppedEntry
+	for _, e := range entries {
+		if !e.Start.Before(from) && e.Start.Before(to) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// ProjectTotals returns the total duration of 'entries' in each project
+func ProjectTotals(entries []StoppedEntry) map[string]time.Duration {
+	result := make(map[string]time.Duration)
+	for _, e := range entries {
+		result[e.Project] += e.Stop.Sub(e.Start)
+	}
+	return result
+}
+
+// readJournalFile returns all entries in the journal in 'tgStateDir', in the
+// order in which they were appended
+func readJournalFile(tgStateDir string) ([]StoppedEntry, error) {
+	var result []StoppedEntry
+	if err := persist.ReadRecords(tgStateDir, journalLog, func(data []byte) error {
+		var e StoppedEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("could not parse journal entry %q: %v", data, err)
+		}
+		result = append(result, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateJournal replaces the journal in 'tgStateDir' with the result of
+// applying 'f' to its entries, in one transaction, so that entries appended
+// concurrently (e.g. by the daemon) aren't lost
+func updateJournal(tgStateDir string, f func([]StoppedEntry) []StoppedEntry) error {
+	err := persist.UpdateRecords(tgStateDir, journalLog, func(records [][]byte) ([][]byte, error) {
+		journal := make([]StoppedEntry, len(records))
+		for i, data := range records {
+			if err := json.Unmarshal(data, &journal[i]); err != nil {
+				return nil, fmt.Errorf("could not parse journal entry %q: %v", data, err)
+			}
+		}
+		var result [][]byte
+		for _, e := range f(journal) {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, data)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not update the journal: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+
+	start := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	for i, project := range []string{"a", "b", "a"} {
+		e := StoppedEntry{
+			TimeEntryID: int64(i + 1),
+			Start:       start.Add(time.Duration(i) * time.Hour),
+			Stop:        start.Add(time.Duration(i)*time.Hour + 30*time.Minute),
+			Project:     project,
+		}
+		if err := AppendJournal(d, e); err != nil {
+			t.Fatalf("could not append to journal: %v", err)
+		}
+	}
+
+	// Only entries that started in the range are returned
+	entries, err := ReadJournal(d, start.Add(time.Hour), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("could not read journal: %v", err)
+	}
+	if len(entries) != 2 || entries[0].TimeEntryID != 2 || entries[1].TimeEntryID != 3 {
+		t.Fatalf("unexpected journal entries: %+v", entries)
+	}
+	totals := ProjectTotals(entries)
+	if len(totals) != 2 || totals["a"] != 30*time.Minute || totals["b"] != 30*time.Minute {
+		t.Fatalf("unexpected project totals: %v", totals)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+package status
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock acquires an advisory lock on 'f'. For more on advisory locking, see
+// https://gavv.github.io/blog/file-locks/
+func lock(f *os.File) error {
+	for {
+		// exclusive lock, nonblocking call (fail fast)
+		err := unix.Flock(int(f.Fd()), unix.LOCK_NB|unix.LOCK_EX)
+		if err == nil {
+			return nil
+		}
+		switch err {
+		case unix.EINTR:
+			continue // interrupted--retry syscall
+		case unix.EWOULDBLOCK:
+			return fmt.Errorf("another watch process is already running")
+		default:
+			return fmt.Errorf("error locking watch file: %v", err)
+		}
+	}
+}
+
+// locked returns true if another process holds the lock on 'f' (see lock).
+// If it doesn't, a shared lock on 'f' is acquired, which is released when 'f'
+// is closed
+func locked(f *os.File) (bool, error) {
+	for {
+		switch err := unix.Flock(int(f.Fd()), unix.LOCK_NB|unix.LOCK_SH); err {
+		case nil:
+			return false, nil
+		case unix.EINTR:
+			continue // interrupted--retry syscall
+		case unix.EWOULDBLOCK:
+			return true, nil
+		default:
+			return false, fmt.Errorf("could not check watch lock file: %v", err)
+		}
+	}
+}
+
+// inodeOf returns the inode of the file described by 'info'
+func inodeOf(info os.FileInfo) inode {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode{}
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+}
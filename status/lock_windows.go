@@ -0,0 +1,46 @@
+package status
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock acquires a lock on 'f' (which Windows enforces, unlike the advisory
+// locks used elsewhere), so that no other process can lock it until 'f' is
+// closed
+func lock(f *os.File) error {
+	err := windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0,
+		new(windows.Overlapped))
+	switch err {
+	case nil:
+		return nil
+	case windows.ERROR_LOCK_VIOLATION:
+		return fmt.Errorf("another watch process is already running")
+	default:
+		return fmt.Errorf("error locking watch file: %v", err)
+	}
+}
+
+// locked returns true if another process holds the lock on 'f' (see lock)
+func locked(f *os.File) (bool, error) {
+	h := windows.Handle(f.Fd())
+	ol := new(windows.Overlapped)
+	err := windows.LockFileEx(h, windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	switch err {
+	case nil:
+		return false, windows.UnlockFileEx(h, 0, 1, 0, ol)
+	case windows.ERROR_LOCK_VIOLATION:
+		return true, nil
+	default:
+		return false, fmt.Errorf("could not check watch lock file: %v", err)
+	}
+}
+
+// inodeOf returns the zero inode, as Windows has no inode numbers. It's only
+// used by the inotify backend, which Windows doesn't support
+func inodeOf(info os.FileInfo) inode {
+	return inode{}
+}
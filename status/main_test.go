@@ -0,0 +1,46 @@
+package status
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/watchtest"
+)
+
+var cleanUpFlag = flag.Bool("cleanup", true, "If --cleanup=false is set, "+
+	"temporary directories created by this test will be left behind so they can "+
+	"be inspected")
+
+// GetTestDir creates a new temporary directory for the calling test (see
+// watchtest.Dir)
+func GetTestDir(t testing.TB) string {
+	t.Helper()
+	return watchtest.Dir(t)
+}
+
+// StartForTest starts a Watch whose state is kept in a new directory next to
+// 'dir', which is removed along with 'dir' when the test finishes
+func StartForTest(t testing.TB, dir string) *Watch {
+	t.Helper()
+	stateDir := dir + "-state"
+	if err := os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatalf("could not create watch state dir %q: %v", stateDir, err)
+	}
+	t.Cleanup(func() {
+		if !watchtest.Keep {
+			os.RemoveAll(stateDir)
+		}
+	})
+	w, err := Start(stateDir)
+	if err != nil {
+		t.Fatalf("could not start watch: %v", err)
+	}
+	return w
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	watchtest.Keep = !*cleanUpFlag
+	os.Exit(m.Run())
+}
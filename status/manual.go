@@ -0,0 +1,186 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// EntryEdit is a change to a stopped time entry (see EditEntry). Only non-nil
+// fields are changed
+type EntryEdit struct {
+	Project     *string
+	Description *string
+	Tags        []string
+	Billable    *bool
+	Start       *time.Time
+	Stop        *time.Time
+}
+
+// apply applies 'u' to 'e', and returns an error if 'e' would then stop
+// before it starts
+func (u EntryEdit) apply(e *StoppedEntry) error {
+	if u.Project != nil {
+		e.Project = *u.Project
+	}
+	if u.Description != nil {
+		e.Description = *u.Description
+	}
+	if u.Tags != nil {
+		e.Tags = u.Tags
+	}
+	if u.Billable != nil {
+		e.Billable = *u.Billable
+	}
+	if u.Start != nil {
+		e.Start = *u.Start
+	}
+	if u.Stop != nil {
+		e.Stop = *u.Stop
+	}
+	return checkSpan(*e)
+}
+
+// checkSpan returns an error if 'e' doesn't stop after it starts
+func checkSpan(e StoppedEntry) error {
+	if !e.Stop.After(e.Start) {
+		return fmt.Errorf("time entry would stop (%s) before it starts (%s)",
+			e.Stop.Format(time.RFC3339), e.Start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// AddEntry records 'e', a time entry for work that tg didn't observe (e.g. a
+// meeting), in Toggl via 'client' (or, if it's in a profile, the profile's
+// client in 'profiles') and in the journal in 'tgStateDir'. If 'client' is
+// nil, the entry is only recorded in the journal, from which 'tg sync' can
+// push it to Toggl later. The recorded entry is returned
+func AddEntry(ctx context.Context, tgStateDir string, client *togglclient.Client,
+	profiles map[string]*togglclient.Client, e StoppedEntry) (StoppedEntry, error) {
+	if err := checkSpan(e); err != nil {
+		return StoppedEntry{}, err
+	}
+	if client != nil {
+		s := newCreator(ctx, tgStateDir, client, profiles)
+		created, err := s.createStopped(e.Project, e.Start, e.Stop, e.options())
+		if err != nil {
+			return StoppedEntry{}, fmt.Errorf("could not create time entry: %v", err)
+		}
+		e.TimeEntryID = created.ID
+	}
+	return e, AppendJournal(tgStateDir, e)
+}
+
+// EditEntry applies 'edit' to the stopped Toggl time entry whose ID is 'id',
+// in Toggl (via 'client') and, if it's recorded there, in the journal in
+// 'tgStateDir'. The edited entry is returned
+func EditEntry(ctx context.Context, tgStateDir string, client *togglclient.Client, id int64, edit EntryEdit) (StoppedEntry, error) {
+	te, err := client.GetTimeEntry(ctx, id)
+	if err != nil {
+		return StoppedEntry{}, fmt.Errorf("could not get time entry %d: %v", id, err)
+	}
+	if te.Stop == nil || te.Running() {
+		return StoppedEntry{}, fmt.Errorf("time entry %d is still running", id)
+	}
+	journal, err := readJournalFile(tgStateDir)
+	if err != nil {
+		return StoppedEntry{}, err
+	}
+	e := StoppedEntry{
+		TimeEntryID: id,
+		Start:       te.Start,
+		Stop:        *te.Stop,
+		Description: te.Description,
+		Tags:        te.Tags,
+		Billable:    te.Billable,
+	}
+	if i := journalIndex(journal, func(j StoppedEntry) bool { return j.TimeEntryID == id }); i >= 0 {
+		e.Project = journal[i].Project
+	} else if te.ProjectID != 0 {
+		projects, err := client.ListProjects(ctx, te.WorkspaceID)
+		if err != nil {
+			return StoppedEntry{}, err
+		}
+		for _, p := range projects {
+			if p.ID == te.ProjectID {
+				e.Project = p.Name
+			}
+		}
+	}
+	if err := edit.apply(&e); err != nil {
+		return StoppedEntry{}, err
+	}
+
+	u := togglclient.TimeEntryUpdate{
+		Description: edit.Description,
+		Tags:        edit.Tags,
+		Billable:    edit.Billable,
+	}
+	if edit.Project != nil {
+		p, err := client.ResolveProject(ctx, te.WorkspaceID, 0, *edit.Project)
+		if err != nil {
+			return StoppedEntry{}, fmt.Errorf("could not resolve project %q: %v",
+				*edit.Project, err)
+		}
+		e.Project, u.ProjectID = p.Name, &p.ID
+	}
+	if edit.Start != nil || edit.Stop != nil {
+		// Send both, so that Toggl recomputes the entry's duration from them
+		u.Start, u.Stop = &e.Start, &e.Stop
+	}
+	if _, err := client.UpdateTimeEntry(ctx, te.WorkspaceID, id, u); err != nil {
+		return StoppedEntry{}, fmt.Errorf("could not update time entry %d: %v", id, err)
+	}
+	err = updateJournal(tgStateDir, func(journal []StoppedEntry) []StoppedEntry {
+		if i := journalIndex(journal, func(j StoppedEntry) bool { return j.TimeEntryID == id }); i >= 0 {
+			journal[i] = e
+		}
+		return journal
+	})
+	return e, err
+}
+
+// EditLocalEntry applies 'edit' to the entry in the journal in 'tgStateDir'
+// that started at 'start' (to the minute) and hasn't been sent to Toggl or
+// another tracker, e.g. because the local backend is in use. The edited entry
+// is returned
+func EditLocalEntry(tgStateDir string, start time.Time, edit EntryEdit) (StoppedEntry, error) {
+	var (
+		e       StoppedEntry
+		editErr = fmt.Errorf("no local time entry started at %s",
+			start.In(time.Local).Format("2006-01-02 15:04"))
+	)
+	err := updateJournal(tgStateDir, func(journal []StoppedEntry) []StoppedEntry {
+		i := journalIndex(journal, func(j StoppedEntry) bool {
+			return j.TimeEntryID == 0 && j.TrackerEntryID == "" &&
+				j.Start.Truncate(time.Minute).Equal(start.Truncate(time.Minute))
+		})
+		if i < 0 {
+			return journal
+		}
+		e = journal[i]
+		if editErr = edit.apply(&e); editErr == nil {
+			journal[i] = e
+		}
+		return journal
+	})
+	if err != nil {
+		return StoppedEntry{}, err
+	} else if editErr != nil {
+		return StoppedEntry{}, editErr
+	}
+	return e, nil
+}
+
+// journalIndex returns the index of the first entry in 'journal' for which
+// 'match' returns true, or -1 if there is none
+func journalIndex(journal []StoppedEntry, match func(StoppedEntry) bool) int {
+	for i := range journal {
+		if match(journal[i]) {
+			return i
+		}
+	}
+	return -1
+}
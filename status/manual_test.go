@@ -0,0 +1,75 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestAddAndEditEntry(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	e, err := AddEntry(ctx, d, c, nil, StoppedEntry{Start: start,
+		Stop: start.Add(time.Hour), Project: "meetings", Description: "standup"})
+	if err != nil {
+		t.Fatalf("could not add entry: %v", err)
+	}
+	remote := server.TimeEntries()
+	if len(remote) != 1 || remote[0].ID != e.TimeEntryID || remote[0].Duration != 3600 ||
+		remote[0].Description != "standup" {
+		t.Fatalf("unexpected time entries in toggl: %+v", remote)
+	}
+
+	// Moving the stop (and project) changes the entry in Toggl and the journal
+	stop, project := start.Add(30*time.Minute), "planning"
+	e, err = EditEntry(ctx, d, c, e.TimeEntryID, EntryEdit{Project: &project, Stop: &stop})
+	if err != nil {
+		t.Fatalf("could not edit entry: %v", err)
+	}
+	if remote = server.TimeEntries(); remote[0].Duration != 1800 || remote[0].ProjectID == 0 {
+		t.Fatalf("unexpected time entries in toggl: %+v", remote)
+	}
+	journal, err := ReadJournal(d, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("could not read journal: %v", err)
+	}
+	if len(journal) != 1 || journal[0].Project != "planning" || !journal[0].Stop.Equal(stop) {
+		t.Fatalf("unexpected journal entries: %+v", journal)
+	}
+
+	// An edit that would stop the entry before it starts is rejected
+	early := start.Add(-time.Minute)
+	if _, err := EditEntry(ctx, d, c, e.TimeEntryID, EntryEdit{Stop: &early}); err == nil {
+		t.Fatalf("expected an entry that stops before it starts to be rejected")
+	}
+}
+
+func TestEditLocalEntry(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+
+	start := time.Date(2019, 3, 4, 14, 0, 0, 0, time.Local)
+	if _, err := AddEntry(context.Background(), d, nil, nil, StoppedEntry{Start: start,
+		Stop: start.Add(90 * time.Minute), Project: "a"}); err != nil {
+		t.Fatalf("could not add entry: %v", err)
+	}
+	description := "design review"
+	e, err := EditLocalEntry(d, start.Add(30*time.Second), EntryEdit{Description: &description})
+	if err != nil {
+		t.Fatalf("could not edit entry: %v", err)
+	}
+	if e.Description != description || e.TimeEntryID != 0 {
+		t.Fatalf("unexpected edited entry: %+v", e)
+	}
+	if _, err := EditLocalEntry(d, start.Add(time.Hour), EntryEdit{}); err == nil {
+		t.Fatalf("expected an error editing an entry that doesn't exist")
+	}
+}
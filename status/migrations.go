@@ -0,0 +1,162 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	p "path"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// migrations are the changes that have been made to the layout of tg's state
+// directory, in order. New migrations must be appended with the next version.
+// Migrations before version 3 act on the JSON state files that preceded the
+// database, which had the same names as its documents and logs
+var migrations = []persist.Migration{
+	{
+		Version: 1,
+		Description: "rewrite tick file written without truncation (before " +
+			"state files were written atomically), which may have trailing bytes",
+		Apply: func(tgStateDir string) error {
+			tickPath := p.Join(tgStateDir, tickDoc)
+			f, err := os.Open(tickPath)
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			defer f.Close()
+			// Decode (unlike Unmarshal) ignores anything after the first value
+			fields := make(map[string]string)
+			if err := json.NewDecoder(f).Decode(&fields); err != nil {
+				return nil // unreadable; leave it to persist.ReadJSON to recover
+			}
+			return persist.WriteJSON(tickPath, fields, 0644)
+		},
+	},
+	{
+		Version: 2,
+		Description: "merge the watch state file (a map from directories to " +
+			"projects) and the watch options file into a list of root watches " +
+			"with IDs",
+		Apply: func(tgStateDir string) error {
+			statePath := p.Join(tgStateDir, watchDoc)
+			optionsPath := p.Join(tgStateDir, "watch-options")
+			data, err := ioutil.ReadFile(statePath)
+			if os.IsNotExist(err) {
+				return os.RemoveAll(optionsPath) // nothing was watched
+			} else if err != nil {
+				return err
+			}
+			var projects map[string]string
+			if err := json.Unmarshal(data, &projects); err != nil {
+				return nil // already migrated, or unreadable (see readStore)
+			}
+			options := make(map[string]RootOptions)
+			if err := persist.ReadJSON(optionsPath, &options); err != nil &&
+				!os.IsNotExist(err) {
+				return err
+			}
+			s := &watchStore{NextID: 1}
+			s.sync(projects, options)
+			if err := persist.WriteJSON(statePath, s, 0644); err != nil {
+				return err
+			}
+			return os.RemoveAll(optionsPath)
+		},
+	},
+	{
+		Version: 3,
+		Description: "move the tick, watch, outbox, pending entry, alive and " +
+			"canary state files, and the journal, activity and gap logs, into the " +
+			"state database",
+		Apply: func(tgStateDir string) error {
+			for _, name := range []string{tickDoc, watchDoc, outboxDoc, pendingEntriesDoc} {
+				if err := persist.ImportFile(tgStateDir, name); err != nil {
+					return err
+				}
+			}
+			for _, name := range []string{journalLog, activityLog, gapsLog} {
+				if err := persist.ImportLog(tgStateDir, name); err != nil {
+					return err
+				}
+			}
+			if err := importAlive(tgStateDir); err != nil {
+				return err
+			}
+			return importCanary(tgStateDir)
+		},
+	},
+}
+
+// importAlive moves the alive file, which held a time in RFC 3339 format rather
+// than JSON, into the database. A time that can't be parsed (e.g. because it
+// was cut off by a crash) is dropped, as if the daemon had never run
+func importAlive(tgStateDir string) error {
+	alivePath := p.Join(tgStateDir, aliveDoc)
+	data, err := ioutil.ReadFile(alivePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+		if err := MarkAlive(tgStateDir, t); err != nil {
+			return err
+		}
+	}
+	return os.Remove(alivePath)
+}
+
+// importCanary moves the canary file, which had one diff per line, into the
+// database as a single document. Lines that can't be parsed are dropped, as
+// by persist.ImportLog
+func importCanary(tgStateDir string) error {
+	canaryPath := p.Join(tgStateDir, canaryDoc)
+	data, err := ioutil.ReadFile(canaryPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var diffs []CanaryDiff
+	for _, line := range strings.Split(string(data), "\n") {
+		var d CanaryDiff
+		if err := json.Unmarshal([]byte(line), &d); err == nil {
+			diffs = append(diffs, d)
+		}
+	}
+	if err := persist.WriteDoc(tgStateDir, canaryDoc, diffs); err != nil {
+		return err
+	}
+	return os.Remove(canaryPath)
+}
+
+// MigrateState brings the state directory 'tgStateDir' up to date with the
+// current version of tg (see persist.Migrate). It does nothing if
+// 'tgStateDir' doesn't exist yet
+func MigrateState(tgStateDir string) error {
+	if _, err := os.Stat(tgStateDir); os.IsNotExist(err) {
+		return nil
+	}
+	return persist.Migrate(tgStateDir, migrations)
+}
+
+// InitState creates the state directory 'tgStateDir' (and its parents) if it
+// doesn't exist yet, and brings it up to date with the current version of tg.
+// It returns true if the directory was created
+func InitState(tgStateDir string) (created bool, err error) {
+	if _, err := os.Stat(tgStateDir); os.IsNotExist(err) {
+		if err := persist.MakeStateDir(tgStateDir); err != nil {
+			return false, err
+		}
+		created = true
+	} else if err != nil {
+		return false, fmt.Errorf("could not stat state dir at %q: %v", tgStateDir, err)
+	}
+	return created, persist.Migrate(tgStateDir, migrations)
+}
@@ -0,0 +1,508 @@
+package status
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	p "path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/redact"
+	"github.com/msteffen/toggl-watcher/watcher"
+)
+
+// RootOptions are optional settings for a single root watch
+type RootOptions struct {
+	// Excludes are patterns (see Watch.SetIgnorePatterns) of paths under the
+	// root in which writes are ignored
+	Excludes []string `json:"excludes,omitempty"`
+
+	// Only, if set, are patterns (e.g. "*.go") of file names under the root:
+	// writes to files whose names match none of them are ignored, so that
+	// e.g. build artifacts and logs don't extend time entries. Directories
+	// are watched regardless
+	Only []string `json:"only,omitempty"`
+
+	// Tags are added to the time entries created for writes under the root
+	Tags []string `json:"tags,omitempty"`
+
+	// Billable marks the time entries created for writes under the root as
+	// billable (except during a non-billable detour; see Status.StartDetour)
+	Billable bool `json:"billable,omitempty"`
+
+	// Template is a text/template for the descriptions of time entries
+	// created for writes under the root (see DescriptionData for its fields).
+	// If unset, the global description_template setting is used
+	Template string `json:"template,omitempty"`
+
+	// NoIgnoreFiles disables .gitignore and .tgignore files under the root,
+	// so that writes are only filtered by 'Excludes' and the global ignore
+	// patterns
+	NoIgnoreFiles bool `json:"no_ignore_files,omitempty"`
+
+	// Group, if set, is the name of the group to which the root belongs, so
+	// that it can be managed along with the group's other roots (see
+	// UpdateRootWatches)
+	Group string `json:"group,omitempty"`
+
+	// Disabled is true if writes under the root are ignored, without the root
+	// being unwatched
+	Disabled bool `json:"disabled,omitempty"`
+
+	// MaxDepth, if positive, is the number of directory levels under the root
+	// that are watched, and NoRecursive, if true, means only the root itself
+	// is watched. Writes deeper than that are found by periodically scanning
+	// for modified files (see rescan), which needs no inotify watches
+	MaxDepth    int  `json:"max_depth,omitempty"`
+	NoRecursive bool `json:"no_recursive,omitempty"`
+
+	// Redactions rewrite text derived from the root (e.g. descriptions
+	// rendered from Template) before the global redactions in tg's config
+	Redactions []redact.Spec `json:"redactions,omitempty"`
+
+	// Backend, if set, must be BackendPoll, in which case writes under the
+	// root are found by scanning it for modified files every PollInterval (a
+	// time.ParseDuration string, 10s if unset), rather than through the
+	// daemon's watch backend. This is needed for network filesystems (e.g.
+	// NFS or sshfs), on which inotify events aren't generated
+	Backend      Backend `json:"backend,omitempty"`
+	PollInterval string  `json:"poll_interval,omitempty"`
+
+	// Workspace is the name or ID of the Toggl workspace in which time
+	// entries for writes under the root are created (the configured workspace
+	// if unset), and Client is the customer (Toggl client) to whose project
+	// they're assigned (see EntryOptions)
+	Workspace string `json:"workspace,omitempty"`
+	Client    string `json:"client,omitempty"`
+
+	// Profile, if set, is the name of the profile (Toggl account) to which
+	// time entries for writes under the root are sent, rather than the
+	// default account (see EntryOptions.Profile)
+	Profile string `json:"profile,omitempty"`
+
+	// MinActivity, if set, overrides the global min_activity setting for
+	// writes under the root (see ParseActivityThreshold for its format)
+	MinActivity string `json:"min_activity,omitempty"`
+
+	// Events, if set, are the classes of event (see EventClasses) under the
+	// root that count as writes, in place of the global watch_events setting
+	// (e.g. to ignore deletes, or to count permission changes)
+	Events []string `json:"events,omitempty"`
+
+	// Skip, if set, are the heuristics (see SkipHeuristics) that decide which
+	// directories under the root aren't watched, in place of the default
+	// (every heuristic). "none" disables them, so that e.g. hidden
+	// directories are watched too
+	Skip []string `json:"skip,omitempty"`
+}
+
+// SkipHeuristics maps the name of each heuristic that can skip a directory
+// under a root watch (see RootOptions.Skip) to a function returning the
+// reason why the directory 'path' is skipped, or "" if it isn't
+var SkipHeuristics = map[string]func(path string) string{
+	"hidden": func(path string) string {
+		if strings.HasPrefix(p.Base(path), ".") {
+			return "hidden"
+		}
+		return ""
+	},
+	// Avoid golang vendor directories, since I typically use this with go
+	// projects
+	"vendor": func(path string) string {
+		if p.Base(path) != "vendor" {
+			return ""
+		}
+		if _, err := os.Stat(p.Join(p.Dir(path), "Gopkg.lock")); err == nil {
+			return "a dep vendor dir" // vendor dir managed by 'dep'
+		}
+		if _, err := os.Stat(p.Join(path, "vendor.json")); err == nil {
+			return "a govendor dir" // vendor dir managed by 'govendor'
+		}
+		return ""
+	},
+}
+
+// SkipHeuristicNames are the keys of SkipHeuristics, in the order in which
+// they're applied
+var SkipHeuristicNames = []string{"hidden", "vendor"}
+
+// skipNone is the value of RootOptions.Skip that disables every heuristic
+const skipNone = "none"
+
+// ParseSkipHeuristics returns the heuristics 'names' (see RootOptions.Skip),
+// or SkipHeuristicNames if there are none
+func ParseSkipHeuristics(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return SkipHeuristicNames, nil
+	}
+	if len(names) == 1 && names[0] == skipNone {
+		return nil, nil
+	}
+	for _, name := range names {
+		if _, ok := SkipHeuristics[name]; !ok {
+			return nil, fmt.Errorf("invalid skip heuristic %q (expected %q, or any "+
+				"of %s)", name, skipNone, strings.Join(SkipHeuristicNames, ", "))
+		}
+	}
+	return names, nil
+}
+
+// skipHeuristics returns the heuristics that skip directories under the root
+// (see Skip)
+func (o RootOptions) skipHeuristics() []string {
+	names, _ := ParseSkipHeuristics(o.Skip) // validated when it's set
+	return names
+}
+
+// EventClasses maps the names of the classes of event that can count as
+// writes (see RootOptions.Events) to the watcher events in them
+var EventClasses = map[string]watcher.EventType{
+	"create": watcher.Create,
+	"delete": watcher.Delete,
+	"modify": watcher.Modify,
+	"rename": watcher.Rename,
+	"attrib": watcher.Attrib,
+}
+
+// EventClassNames are the keys of EventClasses, in the order in which they're
+// documented
+var EventClassNames = []string{"create", "delete", "modify", "rename", "attrib"}
+
+// ParseEventClasses returns the mask of the event classes 'names' (see
+// EventClasses), or watcher.DefaultMask (every class but "attrib") if there
+// are none
+func ParseEventClasses(names []string) (watcher.EventMask, error) {
+	if len(names) == 0 {
+		return watcher.DefaultMask, nil
+	}
+	var m watcher.EventMask
+	for _, name := range names {
+		t, ok := EventClasses[name]
+		if !ok {
+			return 0, fmt.Errorf("invalid event class %q (expected one of %s)", name,
+				strings.Join(EventClassNames, ", "))
+		}
+		m |= watcher.MaskOf(t)
+	}
+	return m, nil
+}
+
+// inotifyEvents returns the events in 'm' that change the mask of an inotify
+// watch (see watcher.Options.Mask), so that changing them requires the
+// affected directories to be watched again
+func inotifyEvents(m watcher.EventMask) watcher.EventMask {
+	return m & watcher.MaskOf(watcher.Modify, watcher.Attrib)
+}
+
+// isZero returns true if no options are set in 'o'
+func (o RootOptions) isZero() bool {
+	return len(o.Excludes) == 0 && len(o.Only) == 0 && len(o.Tags) == 0 && !o.Billable && o.Template == "" &&
+		!o.NoIgnoreFiles && o.Group == "" && !o.Disabled && len(o.Redactions) == 0 &&
+		o.MaxDepth == 0 && !o.NoRecursive && o.Backend == "" && o.PollInterval == "" &&
+		o.MinActivity == "" && o.Workspace == "" && o.Client == "" && o.Profile == "" &&
+		len(o.Events) == 0 && len(o.Skip) == 0
+}
+
+// depthLimit returns the depth below the root past which directories aren't
+// watched (0 if only the root is watched), or -1 if there's no limit
+func (o RootOptions) depthLimit() int {
+	switch {
+	case o.NoRecursive:
+		return 0
+	case o.MaxDepth > 0:
+		return o.MaxDepth
+	}
+	return -1
+}
+
+// Threshold returns the activity threshold for writes under the root, or
+// 'defaultThreshold' (the global min_activity setting) if the root sets none
+func (o RootOptions) Threshold(defaultThreshold ActivityThreshold) ActivityThreshold {
+	if o.MinActivity == "" {
+		return defaultThreshold
+	}
+	t, _ := ParseActivityThreshold(o.MinActivity) // validated when it's set
+	return t
+}
+
+// pollInterval returns how often the root is scanned for writes, if it's
+// polled (see Backend)
+func (o RootOptions) pollInterval() time.Duration {
+	if d, err := time.ParseDuration(o.PollInterval); err == nil && d > 0 {
+		return d
+	}
+	return defaultPollInterval
+}
+
+// DescriptionData are the fields to which description templates (see
+// RootOptions.Template) may refer
+type DescriptionData struct {
+	// Project is the time entry's project
+	Project string
+	// Dir is the root watch directory under which the writes occurred
+	Dir string
+	// GitBranch is the branch checked out in Dir, if it's in a git repository
+	GitBranch string
+	// Files is the number of distinct files written in the bucket of writes
+	// that started the time entry
+	Files int
+	// TopFiles are the files written most often in that bucket (relative to
+	// Dir), comma-separated
+	TopFiles string
+}
+
+// ParseTemplate parses the description template 'text', named 'name' in
+// errors. Templates that refer to fields not in DescriptionData are rejected
+func ParseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Parse(text)
+	if err == nil {
+		err = t.Execute(ioutil.Discard, DescriptionData{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %q: %v", name, err)
+	}
+	return t, nil
+}
+
+// RenderDescription renders the description template 'text' (see
+// ParseTemplate) with 'data'. If 'text' is empty, so is the description
+func RenderDescription(name, text string, data DescriptionData) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("could not render template for %q: %v", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Description renders o.Template or, if it's unset, 'defaultTemplate' (the
+// global description_template setting) for a time entry started by writes
+// under the root data.Dir
+func (o RootOptions) Description(defaultTemplate string, data DescriptionData) (string, error) {
+	if o.Template != "" {
+		return RenderDescription(data.Dir, o.Template, data)
+	}
+	return RenderDescription("description_template", defaultTemplate, data)
+}
+
+// RootWatch is a root watch directory, along with its project and options
+type RootWatch struct {
+	// ID identifies the root watch once it's stored (e.g. for 'tg unwatch
+	// --id'). It's assigned when the root watch is first added, and ignored in
+	// root watches passed to AddWatches or SaveRootWatches
+	ID      int    `json:"id,omitempty"`
+	Dir     string `json:"dir"`
+	Project string `json:"project"`
+	RootOptions
+}
+
+// Validate returns an error if 'rw' can't be watched (e.g. because its
+// directory doesn't exist, or one of its options is malformed)
+func (rw *RootWatch) Validate() error {
+	if info, err := os.Stat(rw.Dir); err != nil {
+		return fmt.Errorf("could not stat %q: %v", rw.Dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", rw.Dir)
+	}
+	if rw.Project == "" {
+		return fmt.Errorf("no project given for %q", rw.Dir)
+	}
+	for _, pattern := range rw.Excludes {
+		if _, err := p.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q for %q: %v", pattern, rw.Dir, err)
+		}
+	}
+	for _, pattern := range rw.Only {
+		if _, err := p.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q for %q: %v", pattern, rw.Dir, err)
+		} else if strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid file pattern %q for %q (patterns match file "+
+				"names, so they can't contain '/')", pattern, rw.Dir)
+		}
+	}
+	if _, err := ParseTemplate(rw.Dir, rw.Template); err != nil {
+		return err
+	}
+	if rw.MaxDepth < 0 {
+		return fmt.Errorf("invalid max depth %d for %q", rw.MaxDepth, rw.Dir)
+	} else if rw.MaxDepth > 0 && rw.NoRecursive {
+		return fmt.Errorf("cannot set both a max depth and no_recursive for %q", rw.Dir)
+	}
+	if _, err := redact.Compile(rw.Redactions); err != nil {
+		return fmt.Errorf("%v for %q", err, rw.Dir)
+	}
+	if rw.Backend != "" && rw.Backend != BackendPoll {
+		return fmt.Errorf("invalid backend %q for %q (only %q may be set for a "+
+			"single watch)", rw.Backend, rw.Dir, BackendPoll)
+	}
+	if rw.PollInterval != "" {
+		if d, err := time.ParseDuration(rw.PollInterval); err != nil || d < time.Second {
+			return fmt.Errorf("invalid poll interval %q for %q (expected a duration "+
+				"of at least 1s)", rw.PollInterval, rw.Dir)
+		} else if rw.Backend != BackendPoll {
+			return fmt.Errorf("cannot set a poll interval for %q, which isn't polled",
+				rw.Dir)
+		}
+	}
+	if _, err := ParseActivityThreshold(rw.MinActivity); err != nil {
+		return fmt.Errorf("%v for %q", err, rw.Dir)
+	}
+	if _, err := ParseEventClasses(rw.Events); err != nil {
+		return fmt.Errorf("%v for %q", err, rw.Dir)
+	}
+	if _, err := ParseSkipHeuristics(rw.Skip); err != nil {
+		return fmt.Errorf("%v for %q", err, rw.Dir)
+	}
+	return nil
+}
+
+// setRootOptions sets the options of each of 'watches' in 'options'
+func setRootOptions(options map[string]RootOptions, watches []RootWatch) {
+	for _, rw := range watches {
+		if rw.isZero() {
+			delete(options, rw.Dir)
+		} else {
+			options[rw.Dir] = rw.RootOptions
+		}
+	}
+}
+
+// AddWatches validates all of 'watches' and, only if they're all valid,
+// starts monitoring each of them (replacing the project and options of any
+// that are already watched)
+func (w *Watch) AddWatches(watches []RootWatch) error {
+	for i := range watches {
+		if err := watches[i].Validate(); err != nil {
+			return err
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var added, rewatched []string
+	for _, rw := range watches {
+		if _, ok := w.rootWatches[rw.Dir]; !ok {
+			added = append(added, rw.Dir)
+		} else if prev := w.rootOptions[rw.Dir]; prev.depthLimit() != rw.depthLimit() ||
+			prev.Backend != rw.Backend || prev.pollInterval() != rw.pollInterval() ||
+			strings.Join(prev.skipHeuristics(), ",") != strings.Join(rw.skipHeuristics(), ",") ||
+			inotifyEvents(w.eventsOf(prev)) != inotifyEvents(w.eventsOf(rw.RootOptions)) {
+			rewatched = append(rewatched, rw.Dir)
+		}
+		delete(w.broken, rw.Dir)
+		w.rootWatches[rw.Dir] = rw.Project
+	}
+	setRootOptions(w.rootOptions, watches)
+	if err := w.saveState(); err != nil {
+		return err
+	}
+	for _, rw := range watches {
+		w.loadIgnoreRules(rw.Dir)
+	}
+	for _, dir := range added {
+		if err := w.addWatch(dir); err != nil {
+			return err
+		}
+	}
+	for _, dir := range rewatched {
+		if err := w.rewatch(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewatch removes the inotify watches and rescans of the directories in the
+// root watch 'root' and adds them again, e.g. after the root's depth limit,
+// skip heuristics, or backend changed. w.mu must be held by the caller
+func (w *Watch) rewatch(root string) error {
+	if w.iw != nil {
+		if err := w.iw.Remove(root); err != nil {
+			return err
+		}
+	}
+	for path, r := range w.rescans {
+		if r.root == root && !r.once {
+			delete(w.rescans, path)
+		}
+	}
+	// Roots nested under 'root' were removed too
+	for _, path := range w.sortedRoots() {
+		if isUnder(path, root) {
+			if err := w.addWatch(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eventsOf returns the events that count as writes under a root watch with
+// the options 'o' (see RootOptions.Events). w.mu must be held by the caller
+func (w *Watch) eventsOf(o RootOptions) watcher.EventMask {
+	if len(o.Events) == 0 {
+		return w.defaultEvents
+	}
+	m, _ := ParseEventClasses(o.Events) // validated when it's set
+	return m
+}
+
+// eventMask returns the events that count as writes under the root watch
+// containing 'dir' (or the default events, if there's none). It satisfies
+// watcher.Options.Mask. w.mu must be held by the caller
+func (w *Watch) eventMask(dir string) watcher.EventMask {
+	return w.eventsOf(w.rootOptions[w.rootFor(dir)])
+}
+
+// SetDefaultEvents sets the events that count as writes under root watches
+// that don't set their own (see RootOptions.Events). Directories whose inotify
+// watches would change are watched again
+func (w *Watch) SetDefaultEvents(m watcher.EventMask) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev := w.defaultEvents
+	w.defaultEvents = m
+	if w.backend != BackendInotify || w.closed || inotifyEvents(prev) == inotifyEvents(m) {
+		return
+	}
+	for _, root := range w.sortedRoots() {
+		if len(w.rootOptions[root].Events) > 0 {
+			continue
+		}
+		if err := w.rewatch(root); err != nil {
+			watchLog.Errorf("could not watch %q again with new events: %v", root, err)
+		}
+	}
+}
+
+// Options returns the options of the root watch 'root'
+func (w *Watch) Options(root string) RootOptions {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rootOptions[root]
+}
+
+// SaveRootWatches validates all of 'watches' and, only if they're all valid,
+// persists them in the watch state in 'tgStateDir', without starting a Watch
+// (see SaveRootWatch)
+func SaveRootWatches(tgStateDir string, watches []RootWatch) error {
+	for i := range watches {
+		if err := watches[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return updateStore(tgStateDir, func(s *watchStore) error {
+		for _, rw := range watches {
+			s.put(rw)
+		}
+		return nil
+	})
+}
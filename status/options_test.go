@@ -0,0 +1,134 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSaveRootWatchesValidatesAll(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	if err := os.Mkdir(j(d, "a"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a"), err)
+	}
+
+	// One invalid watch prevents all of them from being saved
+	err := SaveRootWatches(d, []RootWatch{
+		{Dir: j(d, "a"), Project: "a"},
+		{Dir: j(d, "nonexistent"), Project: "b"},
+	})
+	if err == nil {
+		t.Fatalf("expected error saving watch of nonexistent dir")
+	}
+	if roots, err := ReadRootWatches(d); err != nil || len(roots) != 0 {
+		t.Fatalf("expected no saved watches, but got %v (%v)", roots, err)
+	}
+
+	err = SaveRootWatches(d, []RootWatch{{
+		Dir:         j(d, "a"),
+		Project:     "a",
+		RootOptions: RootOptions{Excludes: []string{".git"}, Tags: []string{"x"}},
+	}})
+	if err != nil {
+		t.Fatalf("could not save watches: %v", err)
+	}
+	if roots, err := ReadRootWatches(d); err != nil || roots[j(d, "a")] != "a" {
+		t.Fatalf("expected saved watch of %q, but got %v (%v)", j(d, "a"), roots, err)
+	}
+	listed, err := ListRootWatches(d)
+	if err != nil || len(listed) != 1 || len(listed[0].Excludes) != 1 {
+		t.Fatalf("expected saved options for %q, but got %+v (%v)", j(d, "a"), listed, err)
+	}
+}
+
+func TestDescription(t *testing.T) {
+	data := DescriptionData{Project: "tg", Dir: "/src/tg", GitBranch: "feature/foo",
+		Files: 3}
+
+	// The root's own template takes precedence over the global one
+	opts := RootOptions{Template: "coding on {{.GitBranch}} in {{.Project}}"}
+	if desc, err := opts.Description("{{.Dir}}", data); err != nil ||
+		desc != "coding on feature/foo in tg" {
+		t.Fatalf("unexpected description %q (%v)", desc, err)
+	}
+	if desc, err := (RootOptions{}).Description("{{.Files}} files in {{.Dir}}", data); err != nil ||
+		desc != "3 files in /src/tg" {
+		t.Fatalf("unexpected description %q (%v)", desc, err)
+	}
+	if desc, err := (RootOptions{}).Description("", data); err != nil || desc != "" {
+		t.Fatalf("expected no description, but got %q (%v)", desc, err)
+	}
+
+	// Unknown fields are rejected when the template is parsed
+	if _, err := ParseTemplate("x", "{{.Branch}}"); err == nil {
+		t.Fatalf("expected error parsing template with an unknown field")
+	}
+}
+
+func TestOnly(t *testing.T) {
+	t.Parallel()
+	w := &Watch{rootOptions: map[string]RootOptions{
+		"/src/tg": {Only: []string{"*.go", "*.md"}, Excludes: []string{"vendor"}},
+	}}
+	for _, c := range []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/src/tg/main.go", false, false},
+		{"/src/tg/docs/README.md", false, false},
+		{"/src/tg/tg.o", false, true},
+		{"/src/tg/build.log", false, true},
+		{"/src/tg/build", true, false}, // directories are still watched
+		{"/src/tg/vendor/x.go", false, true},
+	} {
+		if got := w.ignored("/src/tg", c.path, c.isDir); got != c.ignored {
+			t.Errorf("ignored(%q, %t): expected %t, but got %t", c.path, c.isDir, c.ignored, got)
+		}
+	}
+	rw := RootWatch{Dir: GetTestDir(t), Project: "tg",
+		RootOptions: RootOptions{Only: []string{"src/*.go"}}}
+	if err := rw.Validate(); err == nil {
+		t.Fatalf("expected a file pattern containing '/' to be rejected")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	for _, dir := range []string{j(d, "a", ".git"), j(d, "a", "vendor"),
+		j(d, "b", ".git"), j(d, "b", "vendor"), j(d, "c", ".git")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", dir, err)
+		}
+	}
+	for _, root := range []string{j(d, "a"), j(d, "b")} {
+		if err := ioutil.WriteFile(j(root, "Gopkg.lock"), nil, 0644); err != nil {
+			t.Fatalf("could not write Gopkg.lock: %v", err)
+		}
+	}
+	w := &Watch{rootOptions: map[string]RootOptions{
+		j(d, "b"): {Skip: []string{"vendor"}},
+		j(d, "c"): {Skip: []string{"none"}},
+	}}
+	for _, c := range []struct {
+		root, path string
+		skipped    bool
+	}{
+		// By default, every heuristic applies
+		{j(d, "a"), j(d, "a", ".git"), true},
+		{j(d, "a"), j(d, "a", "vendor"), true},
+		{j(d, "b"), j(d, "b", ".git"), false},
+		{j(d, "b"), j(d, "b", "vendor"), true},
+		{j(d, "c"), j(d, "c", ".git"), false},
+	} {
+		if got := w.skipDir(c.root, c.path) != ""; got != c.skipped {
+			t.Errorf("skipDir(%q): expected %t, but got %t", c.path, c.skipped, got)
+		}
+	}
+	rw := RootWatch{Dir: d, Project: "tg", RootOptions: RootOptions{Skip: []string{"build"}}}
+	if err := rw.Validate(); err == nil {
+		t.Fatalf("expected an unknown skip heuristic to be rejected")
+	}
+}
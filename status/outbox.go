@@ -0,0 +1,228 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// outboxDoc is the document in tgStateDir's database where Toggl API
+// mutations that couldn't be sent (e.g. because the network or Toggl was down)
+// are queued until they can be replayed
+const outboxDoc = "outbox"
+
+const (
+	// minRetryDelay and maxRetryDelay bound the exponential backoff between
+	// attempts to send a queued mutation
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+)
+
+// Kinds of queued mutations
+const (
+	opStop   = "stop"
+	opUpdate = "update"
+)
+
+// op is a Toggl API mutation in the outbox
+type op struct {
+	Kind        string `json:"kind"`
+	TimeEntryID int64  `json:"time_entry_id"`
+	// WorkspaceID is the workspace of the entry, or 0 for the client's
+	WorkspaceID int64 `json:"workspace_id,omitempty"`
+	// Profile is the profile (Toggl account) of the entry, or "" for the
+	// default account (see EntryOptions.Profile)
+	Profile string `json:"profile,omitempty"`
+
+	// Stop is the time at which a stopped entry stopped, and Project and
+	// Description are recorded in the journal once it's stopped (opStop only)
+	Stop        time.Time `json:"stop,omitempty"`
+	Project     string    `json:"project,omitempty"`
+	Description string    `json:"description,omitempty"`
+
+	// Update is the change to apply to the entry (opUpdate only)
+	Update *togglclient.TimeEntryUpdate `json:"update,omitempty"`
+
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// readOutbox reads the queued mutations in 'tgStateDir', oldest first
+func readOutbox(tgStateDir string) ([]op, error) {
+	var result []op
+	err := persist.ReadDoc(tgStateDir, outboxDoc, &result)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read outbox: %v", err)
+	}
+	return result, nil
+}
+
+// writeOutbox replaces the queued mutations in 'tgStateDir' with 'ops'
+func writeOutbox(tgStateDir string, ops []op) error {
+	if ops == nil {
+		ops = []op{} // write "[]" rather than "null"
+	}
+	if err := persist.WriteDoc(tgStateDir, outboxDoc, ops); err != nil {
+		return fmt.Errorf("could not write outbox: %v", err)
+	}
+	return nil
+}
+
+// PendingOps returns the number of Toggl API mutations queued in 'tgStateDir'
+// that haven't been sent yet
+func PendingOps(tgStateDir string) (int, error) {
+	ops, err := readOutbox(tgStateDir)
+	return len(ops), err
+}
+
+// submit queues 'o' and then tries to send every due mutation in the outbox,
+// in order. If 'o' can't be sent but may succeed later, it stays queued and
+// no error is returned
+func (s *Status) submit(o op) error {
+	o.Profile = s.profile // mutations are always to the open entry
+	ops, err := readOutbox(s.tgStateDir)
+	if err != nil {
+		return err
+	}
+	if o.Kind == opUpdate {
+		// A newer update to an entry is merged with any that are still queued,
+		// and supersedes them
+		kept := ops[:0]
+		for _, queued := range ops {
+			if queued.Kind != opUpdate || queued.TimeEntryID != o.TimeEntryID {
+				kept = append(kept, queued)
+				continue
+			}
+			merged := mergeUpdates(*queued.Update, *o.Update)
+			o.Update = &merged
+		}
+		ops = kept
+	}
+	ops = append(ops, o)
+	return s.flush(ops, time.Now())
+}
+
+// mergeUpdates returns an update that applies 'older' and then 'newer'
+func mergeUpdates(older, newer togglclient.TimeEntryUpdate) togglclient.TimeEntryUpdate {
+	if newer.ProjectID == nil {
+		newer.ProjectID = older.ProjectID
+	}
+	if newer.Description == nil {
+		newer.Description = older.Description
+	}
+	if newer.Billable == nil {
+		newer.Billable = older.Billable
+	}
+	if newer.Tags == nil {
+		newer.Tags = older.Tags
+	}
+	if newer.Stop == nil {
+		newer.Stop = older.Stop
+	}
+	return newer
+}
+
+// RetryOutbox tries to send every mutation in the outbox whose next attempt
+// is due at 'now'. It may be called periodically to replay mutations once
+// connectivity returns
+func (s *Status) RetryOutbox(now time.Time) error {
+	ops, err := readOutbox(s.tgStateDir)
+	if err != nil || len(ops) == 0 {
+		return err
+	}
+	return s.flush(ops, now)
+}
+
+// flush sends each of 'ops' in order, stopping at the first one that isn't due
+// at 'now' or that fails with a retryable error (so that mutations to an
+// entry are never reordered), and persists those that remain. Mutations that
+// fail permanently are dropped, and their errors returned
+func (s *Status) flush(ops []op, now time.Time) error {
+	var errs []error
+	for len(ops) > 0 && !ops[0].NextAttempt.After(now) {
+		err := s.send(&ops[0])
+		if err != nil && togglclient.Retryable(err) {
+			ops[0].Attempts++
+			ops[0].LastError = err.Error()
+			ops[0].NextAttempt = now.Add(retryDelay(ops[0].Attempts))
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not %s time entry %d: %v",
+				ops[0].Kind, ops[0].TimeEntryID, err))
+		}
+		ops = ops[1:]
+	}
+	if err := writeOutbox(s.tgStateDir, ops); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// retryDelay returns the delay before the next attempt to send a mutation
+// that has failed 'attempts' times
+func retryDelay(attempts int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// send sends the mutation 'o' to Toggl
+func (s *Status) send(o *op) error {
+	client := s.clientFor(o.Profile)
+	if client == nil {
+		return fmt.Errorf("no toggl client")
+	}
+	switch o.Kind {
+	case opUpdate:
+		_, err := client.UpdateTimeEntry(s.ctx, o.WorkspaceID, o.TimeEntryID, *o.Update)
+		return err
+	case opStop:
+		// Stop the entry when work actually stopped (e.g. at the last tick
+		// before going idle), rather than when the request is sent
+		e, err := client.UpdateTimeEntry(s.ctx, o.WorkspaceID, o.TimeEntryID,
+			togglclient.TimeEntryUpdate{Stop: &o.Stop})
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			s.recordStop(*o, e)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown outbox operation %q", o.Kind)
+}
+
+// recordStop records the time entry 'e', stopped by 'o', in the journal and
+// passes it to s.onStop
+func (s *Status) recordStop(o op, e *togglclient.TimeEntry) {
+	stopped := StoppedEntry{
+		TimeEntryID: o.TimeEntryID,
+		Start:       e.Start,
+		Stop:        o.Stop,
+		Project:     o.Project,
+		Description: o.Description,
+		Profile:     o.Profile,
+	}
+	if e.Stop != nil {
+		stopped.Stop = *e.Stop
+	}
+	if err := AppendJournal(s.tgStateDir, stopped); err != nil {
+		statusLog.Errorf("%v", err) // the entry was still stopped
+	}
+	if s.onStop != nil {
+		s.onStop(stopped)
+	}
+}
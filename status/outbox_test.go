@@ -0,0 +1,78 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	e, err := client.CreateTimeEntry(context.Background(), togglclient.TimeEntry{Start: start})
+	if err != nil {
+		t.Fatalf("could not create time entry: %v", err)
+	}
+
+	s := New(d)
+	s.SetClient(client)
+	s.timeEntryID = e.ID
+	s.projectName = "project"
+
+	// While Toggl is down, the stop is queued and the entry is closed locally
+	server.SetUnavailable(true)
+	stop := start.Add(30 * time.Minute)
+	if err := s.Stop(stop); err != nil {
+		t.Fatalf("expected stop to be queued, but got: %v", err)
+	}
+	if s.TimeEntryID() != 0 {
+		t.Fatalf("expected no open time entry, but have %d", s.TimeEntryID())
+	}
+	if n, err := PendingOps(d); n != 1 || err != nil {
+		t.Fatalf("expected 1 pending op, but got %d (%v)", n, err)
+	}
+
+	// Retries aren't attempted before the backoff expires
+	server.SetUnavailable(false)
+	if err := s.RetryOutbox(time.Now()); err != nil {
+		t.Fatalf("could not retry outbox: %v", err)
+	}
+	if n, _ := PendingOps(d); n != 1 {
+		t.Fatalf("expected op to wait for its backoff, but have %d pending", n)
+	}
+
+	// Once it's replayed, the entry is stopped when it actually stopped
+	if err := s.RetryOutbox(time.Now().Add(maxRetryDelay)); err != nil {
+		t.Fatalf("could not retry outbox: %v", err)
+	}
+	if n, _ := PendingOps(d); n != 0 {
+		t.Fatalf("expected outbox to be empty, but have %d pending", n)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Stop == nil || !entries[0].Stop.Equal(stop) {
+		t.Fatalf("expected entry to stop at %s, but got %+v", stop, entries)
+	}
+	journal, err := ReadJournal(d, start, time.Now())
+	if err != nil || len(journal) != 1 || journal[0].Project != "project" {
+		t.Fatalf("expected stopped entry in journal, but got %+v (%v)", journal, err)
+	}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	t.Parallel()
+	project, older, newer := int64(1), "older", "newer"
+	merged := mergeUpdates(
+		togglclient.TimeEntryUpdate{ProjectID: &project, Description: &older},
+		togglclient.TimeEntryUpdate{Description: &newer})
+	if merged.ProjectID == nil || *merged.ProjectID != project ||
+		merged.Description == nil || *merged.Description != newer {
+		t.Fatalf("expected the older project and newer description, but got %+v", merged)
+	}
+}
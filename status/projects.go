@@ -0,0 +1,173 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// projectCache maps the projects in the Toggl workspaces that 's' has used
+// (see projectKey) to their IDs, so that ticks don't require listing every
+// project in Toggl
+type projectCache map[string]int64
+
+// projectKey returns the key of the project named 'name' in the workspace
+// 'wid' in a projectCache. Project names are lower-cased, since they're
+// matched case-insensitively, and if 'clientID' is 0, the key matches a
+// project of any (or no) customer
+func projectKey(wid, clientID int64, name string) string {
+	return fmt.Sprintf("%d/%d/%s", wid, clientID, strings.ToLower(name))
+}
+
+// RefreshProjects replaces the cached projects (see Tick) with those in the
+// client's Toggl workspace (and any other workspace that root watches have
+// used). It may be called periodically to pick up projects that were renamed
+// or created in the Toggl web UI
+func (s *Status) RefreshProjects() error {
+	if s.client == nil {
+		return fmt.Errorf("cannot list projects: no toggl client")
+	}
+	wid, err := s.client.Workspace(s.ctx)
+	if err != nil {
+		return err
+	}
+	cache := make(projectCache)
+	wids := map[int64]bool{wid: true}
+	for _, id := range s.workspaces {
+		wids[id] = true
+	}
+	for wid := range wids {
+		projects, err := s.client.ListProjects(s.ctx, wid)
+		if err != nil {
+			return fmt.Errorf("could not list projects: %v", err)
+		}
+		for _, p := range projects {
+			if _, ok := cache[projectKey(wid, 0, p.Name)]; !ok {
+				cache[projectKey(wid, 0, p.Name)] = p.ID
+			}
+			cache[projectKey(wid, p.ClientID, p.Name)] = p.ID
+		}
+	}
+	s.projects = cache
+	return nil
+}
+
+// resolveWorkspace returns the ID of the Toggl workspace whose name or ID is
+// 'nameOrID', or of the client's workspace if it's empty
+func (s *Status) resolveWorkspace(nameOrID string) (int64, error) {
+	if nameOrID == "" {
+		return s.client.Workspace(s.ctx)
+	}
+	key := strings.ToLower(nameOrID)
+	if id, ok := s.workspaces[key]; ok {
+		return id, nil
+	}
+	ws, err := s.client.FindWorkspace(s.ctx, nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	if s.workspaces == nil {
+		s.workspaces = make(map[string]int64)
+	}
+	s.workspaces[key] = ws.ID
+	s.projects = nil // list the new workspace's projects too
+	return ws.ID, nil
+}
+
+// resolveCustomer returns the ID of the customer (Toggl client) named 'name'
+// in the workspace 'wid', creating it if it doesn't exist
+func (s *Status) resolveCustomer(wid int64, name string) (int64, error) {
+	key := projectKey(wid, 0, name) // customers are keyed like projects
+	if id, ok := s.customers[key]; ok {
+		return id, nil
+	}
+	cu, err := s.client.ResolveCustomer(s.ctx, wid, name)
+	if err != nil {
+		return 0, fmt.Errorf("could not resolve client %q: %v", name, err)
+	}
+	if s.customers == nil {
+		s.customers = make(map[string]int64)
+	}
+	s.customers[key] = cu.ID
+	return cu.ID, nil
+}
+
+// newCreator returns a Status with no time entry, which is used by functions
+// that create stopped time entries (e.g. Push) for its cache of Toggl projects
+// and its choice of client by profile (see createStopped)
+func newCreator(ctx context.Context, tgStateDir string, client *togglclient.Client,
+	profiles map[string]*togglclient.Client) *Status {
+	s := New(tgStateDir)
+	s.SetClient(client)
+	for name, c := range profiles {
+		s.SetProfileClient(name, c)
+	}
+	s.SetContext(ctx)
+	return s
+}
+
+// createStopped creates a time entry in Toggl for work in the project named
+// 'name' from 'start' to 'stop', in the account, workspace, and customer (Toggl
+// client) set in 'opts', and returns it
+func (s *Status) createStopped(name string, start, stop time.Time, opts EntryOptions) (*togglclient.TimeEntry, error) {
+	s.useProfile(opts.Profile)
+	if s.client == nil {
+		return nil, fmt.Errorf("no toggl client")
+	}
+	wid, projectID, err := s.resolveProject(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.CreateTimeEntry(s.ctx, togglclient.TimeEntry{
+		WorkspaceID: wid,
+		ProjectID:   projectID,
+		Description: opts.Description,
+		Tags:        opts.Tags,
+		Billable:    opts.Billable,
+		Start:       start,
+		Stop:        &stop,
+	})
+}
+
+// resolveProject returns the IDs of the Toggl workspace and project for work
+// in the project named 'name' (ignoring case), in the workspace and customer
+// (Toggl client) set in 'opts'. If the project isn't cached, the cache is
+// refreshed, and if there's still no such project, it's created
+func (s *Status) resolveProject(name string, opts EntryOptions) (wid, projectID int64, err error) {
+	if wid, err = s.resolveWorkspace(opts.Workspace); err != nil {
+		return 0, 0, err
+	}
+	var clientID int64
+	if opts.Client != "" {
+		if clientID, err = s.resolveCustomer(wid, opts.Client); err != nil {
+			return 0, 0, err
+		}
+	}
+	key := projectKey(wid, clientID, name)
+	if id, ok := s.projects[key]; ok {
+		return wid, id, nil
+	}
+	if err := s.RefreshProjects(); err != nil {
+		return 0, 0, err
+	}
+	if id, ok := s.projects[key]; ok {
+		return wid, id, nil
+	}
+	p, err := s.client.CreateProject(s.ctx, togglclient.Project{
+		WorkspaceID: wid,
+		ClientID:    clientID,
+		Name:        name,
+		Active:      true,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not create project %q: %v", name, err)
+	}
+	s.projects[key] = p.ID
+	if _, ok := s.projects[projectKey(wid, 0, name)]; !ok {
+		s.projects[projectKey(wid, 0, name)] = p.ID
+	}
+	return wid, p.ID, nil
+}
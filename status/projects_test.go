@@ -0,0 +1,170 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestProjectCache(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	alpha, err := client.CreateProject(context.Background(), togglclient.Project{WorkspaceID: 1, Name: "Alpha"})
+	if err != nil {
+		t.Fatalf("could not create project: %v", err)
+	}
+
+	s := New(d)
+	s.SetClient(client)
+	if err := s.RefreshProjects(); err != nil {
+		t.Fatalf("could not refresh projects: %v", err)
+	}
+
+	// Cached projects are matched regardless of case
+	if err := s.Tick("alpha"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s.projectID != alpha.ID {
+		t.Fatalf("expected project ID %d, but got %d", alpha.ID, s.projectID)
+	}
+
+	// A project created since the cache was filled is found on a cache miss
+	beta, err := client.CreateProject(context.Background(), togglclient.Project{WorkspaceID: 1, Name: "Beta"})
+	if err != nil {
+		t.Fatalf("could not create project: %v", err)
+	}
+	if err := s.Tick("Beta"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s.projectID != beta.ID {
+		t.Fatalf("expected project ID %d, but got %d", beta.ID, s.projectID)
+	}
+
+	// Missing projects are created
+	if err := s.Tick("gamma"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	projects := server.Projects()
+	if len(projects) != 3 || projects[2].Name != "gamma" || s.projectID != projects[2].ID {
+		t.Fatalf("expected project \"gamma\" (ID %d) to be created, but have %+v",
+			s.projectID, projects)
+	}
+
+	// The project ID is persisted with the tick
+	read, err := Read(d)
+	if err != nil || read.projectID != s.projectID {
+		t.Fatalf("expected persisted project ID %d, but got %+v (%v)", s.projectID, read, err)
+	}
+}
+
+func TestWorkspaceAndClient(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	wid := server.AddWorkspace("Consulting")
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetMinSwitchDuration(time.Minute)
+
+	// The entry is created in the workspace, in a project of the client, both
+	// of which are created
+	opts := EntryOptions{Workspace: "consulting", Client: "Acme"}
+	if err := s.TickWith("Website", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	customers, projects := server.Customers(), server.Projects()
+	if len(customers) != 1 || customers[0].WorkspaceID != wid || customers[0].Name != "Acme" {
+		t.Fatalf("expected client Acme in workspace %d, but got %+v", wid, customers)
+	}
+	if len(projects) != 1 || projects[0].WorkspaceID != wid ||
+		projects[0].ClientID != customers[0].ID {
+		t.Fatalf("expected a project of client %d, but got %+v", customers[0].ID, projects)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].WorkspaceID != wid || entries[0].ProjectID != projects[0].ID {
+		t.Fatalf("expected an entry in workspace %d, but got %+v", wid, entries)
+	}
+
+	// A project with the same name for another client is a different project
+	if err := s.TickWith("Website", EntryOptions{Workspace: "Consulting", Client: "Globex"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	projects = server.Projects()
+	if len(projects) != 2 || s.projectID != projects[1].ID {
+		t.Fatalf("expected a second project, but got %+v", projects)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 1 || entries[0].ProjectID != projects[1].ID {
+		t.Fatalf("expected the entry to move to project %d, but got %+v", projects[1].ID, entries)
+	}
+
+	// Entries aren't moved between workspaces (even within the minimum switch
+	// duration); the open one is stopped (in its workspace) instead
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 2 || entries[0].Running() || !entries[1].Running() ||
+		entries[1].WorkspaceID != 1 {
+		t.Fatalf("expected a stopped entry and one running in workspace 1, but got %+v", entries)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server, acme := toggltest.NewServer(), toggltest.NewServer()
+	defer server.Close()
+	defer acme.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetProfileClient("acme", acme.Client())
+	s.SetMinSwitchDuration(time.Minute)
+
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	// Entries aren't moved between accounts (even within the minimum switch
+	// duration); the open one is stopped in the default account, and the new
+	// one is created in the profile's
+	if err := s.TickWith("Website", EntryOptions{Profile: "acme"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Running() {
+		t.Fatalf("expected a stopped entry in the default account, but got %+v", entries)
+	}
+	remote := acme.TimeEntries()
+	if len(remote) != 1 || !remote[0].Running() || len(acme.Projects()) != 1 {
+		t.Fatalf("expected a running entry in the profile's account, but got %+v", remote)
+	}
+
+	// The profile is persisted, so the entry is stopped in its account after
+	// a restart
+	s, err := Read(d)
+	if err != nil {
+		t.Fatalf("could not read status: %v", err)
+	}
+	s.SetClient(server.Client())
+	s.SetProfileClient("acme", acme.Client())
+	if err := s.Stop(time.Now()); err != nil {
+		t.Fatalf("could not stop: %v", err)
+	}
+	if remote = acme.TimeEntries(); remote[0].Running() {
+		t.Fatalf("expected the profile's entry to be stopped, but got %+v", remote)
+	}
+	journal, err := ReadJournal(d, time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("could not read journal: %v", err)
+	}
+	if len(journal) != 2 || journal[0].Profile != "" || journal[1].Profile != "acme" {
+		t.Fatalf("unexpected journal entries: %+v", journal)
+	}
+}
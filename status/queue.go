@@ -0,0 +1,104 @@
+package status
+
+import (
+	"sync"
+
+	"github.com/msteffen/toggl-watcher/metrics"
+)
+
+// shardQueueDepth is the number of pending tasks that each shard of a
+// shardedQueue can hold before Submit blocks
+const shardQueueDepth = 100
+
+// shardedQueue runs tasks concurrently across keys, but strictly in submission
+// order within each key. Watch uses it to process inotify events for
+// different root directories in parallel, while guaranteeing that e.g. the
+// create and delete of the same path under one root are never reordered
+// (which would corrupt the watch maps)
+type shardedQueue struct {
+	// mu guards 'shards' and 'closed'
+	mu     sync.Mutex
+	shards map[string]*shard
+	closed bool
+
+	// wg tracks the goroutines draining each shard
+	wg sync.WaitGroup
+}
+
+// shard is the queue of tasks for one key of a shardedQueue
+type shard struct {
+	tasks chan func()
+	// senders tracks the Submit calls sending to 'tasks', which is only closed
+	// once they're done
+	senders sync.WaitGroup
+}
+
+// close closes s.tasks once no Submit call is sending to it, so that the
+// goroutine draining it exits after running the tasks already queued
+func (s *shard) close() {
+	s.senders.Wait()
+	close(s.tasks)
+}
+
+func newShardedQueue() *shardedQueue {
+	return &shardedQueue{
+		shards: make(map[string]*shard),
+	}
+}
+
+// Submit queues 'task' to run after all tasks previously submitted with 'key'
+func (q *shardedQueue) Submit(key string, task func()) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	s, ok := q.shards[key]
+	if !ok {
+		s = &shard{tasks: make(chan func(), shardQueueDepth)}
+		q.shards[key] = s
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for task := range s.tasks {
+				task()
+				metrics.QueueDepth.Add("events", -1)
+			}
+		}()
+	}
+	// Register as a sender while holding q.mu, so that Close and Drop (which
+	// remove 's' under q.mu) don't close s.tasks until the task is sent
+	s.senders.Add(1)
+	q.mu.Unlock()
+	defer s.senders.Done()
+	metrics.QueueDepth.Add("events", 1)
+	s.tasks <- task
+}
+
+// Drop stops the shard for 'key' (e.g. that of a removed root watch) once the
+// tasks already submitted with 'key' have run. It doesn't wait for them, so it
+// may be called while holding locks that the tasks take. Tasks submitted with
+// 'key' later are run by a new shard
+func (q *shardedQueue) Drop(key string) {
+	q.mu.Lock()
+	s, ok := q.shards[key]
+	delete(q.shards, key)
+	q.mu.Unlock()
+	if ok {
+		go s.close()
+	}
+}
+
+// Close waits for all submitted tasks to finish. Tasks submitted after Close
+// is called are dropped
+func (q *shardedQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	shards := q.shards
+	q.shards = make(map[string]*shard)
+	q.mu.Unlock()
+	for _, s := range shards {
+		s.close()
+	}
+	q.wg.Wait()
+}
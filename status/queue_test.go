@@ -0,0 +1,93 @@
+package status
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShardedQueueOrdering submits tasks that sleep for random durations (to
+// inject reordering between shards) and checks that tasks for each key still
+// run in submission order
+func TestShardedQueueOrdering(t *testing.T) {
+	t.Parallel()
+	r := rand.New(rand.NewSource(7))
+	q := newShardedQueue()
+	var (
+		mu  sync.Mutex
+		ran = make(map[string][]int)
+		all []string
+	)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("root-%d", r.Intn(4))
+		delay := time.Duration(r.Intn(500)) * time.Microsecond
+		i := i
+		q.Submit(key, func() {
+			time.Sleep(delay)
+			mu.Lock()
+			defer mu.Unlock()
+			ran[key] = append(ran[key], i)
+			all = append(all, key)
+		})
+	}
+	q.Close()
+
+	total := 0
+	for key, order := range ran {
+		total += len(order)
+		for j := 1; j < len(order); j++ {
+			if order[j] < order[j-1] {
+				t.Fatalf("tasks for %q ran out of order: %v", key, order)
+			}
+		}
+	}
+	if total != 200 {
+		t.Fatalf("expected 200 tasks to run, but %d did", total)
+	}
+
+	// Different keys should have been interleaved (i.e. actually concurrent)
+	switches := 0
+	for j := 1; j < len(all); j++ {
+		if all[j] != all[j-1] {
+			switches++
+		}
+	}
+	if switches == 0 {
+		t.Fatalf("expected tasks for different keys to interleave")
+	}
+}
+
+func TestShardedQueueClose(t *testing.T) {
+	t.Parallel()
+	q := newShardedQueue()
+	q.Close()
+	q.Submit("a", func() { t.Fatalf("task submitted after Close should not run") })
+}
+
+// TestShardedQueueSubmitDuringClose submits tasks while the queue is closed
+// (and while shards are dropped), which must neither panic nor lose tasks
+// submitted before Close
+func TestShardedQueueSubmitDuringClose(t *testing.T) {
+	t.Parallel()
+	for i := 0; i < 50; i++ {
+		q := newShardedQueue()
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			key := fmt.Sprintf("root-%d", j)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 2*shardQueueDepth; k++ {
+					q.Submit(key, func() {})
+					if k%50 == 0 {
+						q.Drop(key)
+					}
+				}
+			}()
+		}
+		q.Close()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,103 @@
+package status
+
+import (
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/metrics"
+)
+
+// defaultRepairInterval is how often a Watch re-walks its root watches to
+// find directories whose watches were missed or left behind (see Repair)
+const defaultRepairInterval = 10 * time.Minute
+
+// RepairResult describes the watches changed by Repair
+type RepairResult struct {
+	// Added are the directories that should have been watched but weren't
+	// (e.g. because their creation events were lost in a race or an overflow)
+	Added []string `json:"added,omitempty"`
+	// Pruned are the directories that were watched but shouldn't have been
+	// (e.g. because they were deleted, and the event saying so was lost)
+	Pruned []string `json:"pruned,omitempty"`
+}
+
+// Repair re-walks every root watch and compares the directories under it with
+// those that w.iw is watching (see compareWatches). It watches any that were
+// missed, scanning them once for writes since the previous repair, and stops
+// watching any that no longer exist or shouldn't be watched. Other backends
+// don't watch directories individually, so there's nothing to repair
+func (w *Watch) Repair() (RepairResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var result RepairResult
+	if w.closed {
+		return result, errClosed
+	}
+	if w.backend != BackendInotify {
+		return result, nil
+	}
+	since := w.lastRepair
+	w.lastRepair = time.Now()
+
+	pruned, err := w.iw.Prune()
+	if err != nil {
+		return result, err
+	}
+	result.Pruned = pruned
+	before := make(map[string]bool)
+	for _, dir := range w.watched() {
+		before[dir] = true
+	}
+	var added []string
+	for _, m := range w.compareWatches() {
+		switch {
+		case !m.missing:
+			if err := w.iw.Remove(m.path); err != nil {
+				return result, err
+			}
+			result.Pruned = append(result.Pruned, m.path)
+		case len(added) > 0 && isUnder(m.path, added[len(added)-1]):
+			continue // watched along with its parent (mismatches are sorted)
+		default:
+			if err := w.iw.Add(m.path); err != nil {
+				watchLog.Warnf("could not watch missed directory %q: %v", m.path, err)
+				continue
+			}
+			// Writes in it since the previous repair weren't observed
+			w.addRescan(m.root, m.path, since, true)
+			added = append(added, m.path)
+		}
+	}
+	for _, dir := range w.watched() {
+		if !before[dir] {
+			result.Added = append(result.Added, dir)
+		}
+	}
+	sort.Strings(result.Pruned)
+	if len(added) > 0 {
+		w.triggerRescan()
+	}
+	if len(result.Added) > 0 || len(result.Pruned) > 0 {
+		metrics.Errors.Add("watch_repair", 1)
+		watchLog.Warnf("repaired watches: watched %d missed director(ies) and "+
+			"stopped watching %d stale one(s)", len(result.Added), len(result.Pruned))
+	}
+	return result, nil
+}
+
+// repairLoop repairs w's watches (see Repair) every w.repairInterval until 'w'
+// is closed
+func (w *Watch) repairLoop() {
+	for {
+		select {
+		case <-time.After(w.repairInterval):
+		case <-w.done:
+			return
+		}
+		if _, err := w.Repair(); err == errClosed {
+			return
+		} else if err != nil {
+			watchLog.Errorf("could not repair watches: %v", err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package status
+
+import (
+	"os"
+	fp "path/filepath"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/metrics"
+)
+
+// defaultRescanInterval is how often subtrees that can't be watched (see
+// rescan) are scanned for writes
+const defaultRescanInterval = time.Minute
+
+// mtimeSlack is subtracted from the time of each scan when finding files
+// modified since then, as file modification times come from a coarser clock
+// than time.Now (and may only have a resolution of seconds)
+const mtimeSlack = time.Second
+
+// rescan is a subtree in which writes are found by scanning for modified
+// files, because its inotify events were lost (the kernel's event queue
+// overflowed), can't be received (the inotify watch limit was reached, or
+// it's polled, e.g. because it's on a network filesystem), or aren't wanted
+// (it's deeper than its root's depth limit)
+type rescan struct {
+	// root is the root watch that the subtree is under
+	root string
+
+	// since is the time of the previous scan: files modified after it are
+	// reported as writes
+	since time.Time
+
+	// recent maps the files that the previous scan reported within
+	// mtimeSlack of 'since' to their modification times, so that they're not
+	// reported again unless they're modified again
+	recent map[string]time.Time
+
+	// once is true if the subtree is watched, and is only scanned to find the
+	// writes that were lost in an overflow. Otherwise it's scanned every
+	// rescanInterval until a watch can be added for it
+	once bool
+
+	// limited is true if the subtree couldn't be watched because the inotify
+	// watch limit was reached
+	limited bool
+
+	// interval, if set, is how often the subtree is scanned, in place of
+	// w.rescanInterval (see pollInterval)
+	interval time.Duration
+}
+
+// every returns how often 'r' is scanned
+func (w *Watch) every(r rescan) time.Duration {
+	if r.interval > 0 {
+		return r.interval
+	}
+	return w.rescanInterval
+}
+
+// addRescan starts scanning the subtree 'path' under 'root' for writes after
+// 'since' (see rescan). An existing rescan of 'path' keeps its earlier 'since'.
+// w.mu must be held by the caller
+func (w *Watch) addRescan(root, path string, since time.Time, once bool) {
+	r, ok := w.rescans[path]
+	if ok && r.since.Before(since) {
+		since = r.since
+	}
+	r.root, r.since, r.once = root, since, once && (!ok || r.once)
+	w.rescans[path] = r
+	metrics.RescannedDirs.Set(int64(len(w.rescans)))
+}
+
+// watchLimitReached records that a directory couldn't be watched because the
+// inotify watch limit was reached, and logs it the first time. w.mu must be
+// held by the caller
+func (w *Watch) watchLimitReached(root, path string) {
+	metrics.Errors.Add("inotify_watch_limit", 1)
+	if !w.overLimit {
+		w.overLimit = true
+		watchLog.Errorf("the inotify watch limit was reached (see "+
+			"/proc/sys/fs/inotify/max_user_watches); directories that can't be "+
+			"watched, starting with %q, are scanned for writes every %s instead",
+			path, w.rescanInterval)
+	}
+	w.addRescan(root, path, time.Now(), false)
+	r := w.rescans[path]
+	r.limited = true
+	w.rescans[path] = r
+}
+
+// triggerRescan wakes rescanLoop, so that new rescans are scanned promptly
+func (w *Watch) triggerRescan() {
+	select {
+	case w.rescanNow <- struct{}{}:
+	default: // a scan is already pending
+	}
+}
+
+// rescanLoop scans each of w.rescans for writes, which it sends to
+// 'eventChan', whenever it's due (see every) or when triggered, until 'w' is
+// closed
+func (w *Watch) rescanLoop(eventChan chan<- write) {
+	for {
+		w.mu.Lock()
+		wait, closed := w.rescanInterval, w.closed
+		for _, r := range w.rescans {
+			if due := time.Until(r.since.Add(w.every(r))); due < wait {
+				wait = due
+			}
+		}
+		w.mu.Unlock()
+		if closed {
+			return
+		}
+		all := false
+		select {
+		case <-w.rescanNow:
+			all = true
+		case <-time.After(wait):
+		}
+		for _, wr := range w.scanRescans(all) {
+			w.publish(wr, false, nil, nil, eventChan)
+		}
+	}
+}
+
+// scanRescans scans each of w.rescans that's due (or all of them, if 'all' is
+// true) for files modified since its previous scan, and then tries again to
+// watch each subtree that couldn't be watched. It returns the writes it
+// found, in the order in which they occurred
+func (w *Watch) scanRescans(all bool) []write {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	var writes []write
+	paths := make([]string, 0, len(w.rescans))
+	for path := range w.rescans {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		r := w.rescans[path]
+		if !all && !r.once && now.Before(r.since.Add(w.every(r))) {
+			continue // scanned recently
+		}
+		delete(w.rescans, path)
+		if _, ok := w.rootWatches[r.root]; !ok || w.closed {
+			continue // the root was removed after the rescan was added
+		}
+		started := time.Now()
+		found, recent := w.scan(r, path, started)
+		writes = append(writes, found...)
+		if r.once {
+			continue
+		}
+		// Try to watch the subtree again (e.g. the watch limit was raised, or
+		// other watches were removed). Anything that still can't be watched is
+		// added back, with its writes found from 'started' onwards
+		w.addWatch(path)
+		for dir, next := range w.rescans {
+			if isUnder(dir, path) && !next.once {
+				next.since, next.recent = started, recent
+				w.rescans[dir] = next
+			}
+		}
+	}
+	metrics.RescannedDirs.Set(int64(len(w.rescans)))
+	if w.overLimit {
+		limited := false
+		for _, r := range w.rescans {
+			limited = limited || r.limited
+		}
+		if !limited {
+			w.overLimit = false
+			watchLog.Infof("the inotify watch limit is no longer preventing " +
+				"any directory from being watched")
+		}
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].time.Before(writes[j].time) })
+	return writes
+}
+
+// scan returns a write for each file under 'path' that was modified since the
+// previous scan of 'r' and isn't ignored, along with the files that the next
+// scan (at 'now') should skip (see rescan.recent). w.mu must be held by the
+// caller
+func (w *Watch) scan(r rescan, path string, now time.Time) ([]write, map[string]time.Time) {
+	var writes []write
+	recent := make(map[string]time.Time)
+	root := r.root
+	fp.Walk(path+"/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // e.g. deleted while walking
+		}
+		path = fp.Clean(path)
+		if info.IsDir() {
+			if w.skipDir(root, path) != "" {
+				return fp.SkipDir
+			}
+			return nil
+		}
+		mtime := info.ModTime()
+		if !mtime.After(r.since.Add(-mtimeSlack)) || w.ignored(root, path, false) {
+			return nil
+		}
+		if mtime.After(now.Add(-mtimeSlack)) {
+			recent[path] = mtime
+		}
+		if prev, ok := r.recent[path]; !ok || !prev.Equal(mtime) {
+			writes = append(writes, write{root: root, path: path, time: mtime})
+		}
+		return nil
+	})
+	return writes, recent
+}
+
+// rescanned returns true if the directory 'path' isn't watched because it's
+// in a subtree that's scanned instead. w.mu must be held by the caller
+func (w *Watch) rescanned(path string) bool {
+	for dir, r := range w.rescans {
+		if !r.once && isUnder(path, dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,21 +2,32 @@
 package status
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"path"
+	"strconv"
+	"strings"
 	"time"
-)
 
-const (
-	tickFile = "tick"
+	"github.com/msteffen/toggl-watcher/logging"
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/tracker"
 )
 
+// tickDoc is the document in tgStateDir's database (see persist.DBFile) where
+// the latest tick info (i.e. a Status) is stored
+const tickDoc = "tick"
+
 var (
-	// maxTickGap is the amount of time such that if the last tick is farther than
-	// this in the past, the previous time entry will be stopped
-	maxTickGap = 24 * time.Minute
+	// defaultIdleTimeout is the default amount of time such that if the last
+	// tick is farther than this in the past, the previous time entry will be
+	// stopped (see SetIdleTimeout)
+	defaultIdleTimeout = 24 * time.Minute
+
+	// statusLog logs changes to the open time entry
+	statusLog = logging.With("component", "status")
 )
 
 // Status is the data structure that toggl-watcher uses to track your work
@@ -30,17 +41,204 @@ type Status struct {
 	// registered write was associated (used by `tg tick`)
 	projectName string
 	// projectID is ID of the same toggl project
-	projectID string
+	projectID int64
+	// workspaceID is the ID of the Toggl workspace of the open time entry, or
+	// 0 if it's in the client's workspace
+	workspaceID int64
+	// workspace and customer are the workspace and customer (Toggl client) in
+	// which the most recently registered write's project is (see
+	// EntryOptions.Workspace)
+	workspace string
+	customer  string
+	// profile is the profile (Toggl account) of the open time entry, or "" if
+	// it's in the account of the client set by SetClient (see
+	// EntryOptions.Profile)
+	profile string
 	// timeEntryID is the ID of the currently open Toggl time entry (if any)
-	timeEntryID string
+	timeEntryID int64
+	// description is the description of the open time entry (if any),
+	// excluding any "last active" note added by UpdateLastActive
+	description string
+	// trackerEntryID is the ID of the open time entry in s.tracker, if it's
+	// recorded there (see TrackerEntryID)
+	trackerEntryID string
+	// tags and billable are the tags and billable flag of the open time entry,
+	// if it's tracked locally (see LocalEntryID) or in s.tracker
+	tags     []string
+	billable bool
+	// branch is the git branch on which the open time entry's work happened,
+	// if it's known (see EntryOptions.Branch)
+	branch string
+	// entryStart is the time at which the current stretch of work began. If
+	// its time entry hasn't been created yet (e.g. because Toggl was
+	// unreachable), the entry is backdated to this time once it is
+	entryStart time.Time
+	// idleTimeout is the amount of time such that if the last tick is farther
+	// than this in the past, the previous time entry will be stopped (not
+	// persisted)
+	idleTimeout time.Duration
+	// stopGrace is added to the last tick to get the time at which an idle
+	// time entry is stopped (not persisted; see SetStopGrace)
+	stopGrace time.Duration
+	// bridgeGap is the longest break after which work may resume in the open
+	// time entry, rather than a new one (not persisted; see SetBridgeGap)
+	bridgeGap time.Duration
+	// idleAt, if set, is when the user went idle (by input; see
+	// StopInputIdle) while the open time entry is kept open across the bridge
+	// gap. It's where the entry is stopped if work doesn't resume in time (not
+	// persisted)
+	idleAt time.Time
+	// minSwitchDuration is how long a time entry must have been open before
+	// work on another project starts a new entry (not persisted; see
+	// SetMinSwitchDuration)
+	minSwitchDuration time.Duration
+	// requireApproval is true if new time entries must be approved before
+	// they're created in Toggl (not persisted; see SetRequireApproval)
+	requireApproval bool
+	// localOnly is true if time entries are only recorded in the journal,
+	// and never sent to Toggl (not persisted; see SetLocalOnly)
+	localOnly bool
+	// detour is true while the user is on a detour (see StartDetour)
+	detour bool
+	// detourProject, if set, is the project to which all ticks are attributed
+	// during a detour
+	detourProject string
+	// warmup holds ticks in a project that haven't yet met its activity
+	// threshold (not persisted; see EntryOptions.MinActivity)
+	warmup warmup
+
+	// lastActiveSent is the tick most recently sent to Toggl by
+	// UpdateLastActive (not persisted)
+	lastActiveSent time.Time
+
+	// client is used to send updates to Toggl, with requests that are
+	// abandoned when ctx is done (see SetContext). It's the client of
+	// s.profile: either baseClient or one of profileClients (not persisted;
+	// see SetProfileClient)
+	client         *togglclient.Client
+	baseClient     *togglclient.Client
+	profileClients map[string]*togglclient.Client
+	ctx            context.Context
+	// projects caches the IDs of the projects in the client's workspace (not
+	// persisted; see RefreshProjects)
+	projects projectCache
+	// workspaces and customers cache the IDs of the Toggl workspaces and
+	// customers (Toggl clients) set in EntryOptions, by lower-cased name (not
+	// persisted)
+	workspaces map[string]int64
+	customers  map[string]int64
+	// tracker, if set, records time entries instead of the client (not
+	// persisted; see SetTracker)
+	tracker tracker.TimeTracker
+
+	// onStop, if set, is called with each time entry stopped by Stop
+	onStop func(StoppedEntry)
+	// onChange, if set, is called when tracking starts, switches projects, or
+	// stops after an idle period
+	onChange func(Change)
+}
+
+// ChangeKind is the kind of a Change
+type ChangeKind int
+
+const (
+	// Started means a time entry was started, with no entry open before
+	Started ChangeKind = iota
+	// Switched means work moved from one project (or git branch) to another
+	Switched
+	// IdleStopped means the open time entry was stopped because the user went
+	// idle
+	IdleStopped
+)
+
+// Change describes a change in what's being tracked (see SetChangeCallback)
+type Change struct {
+	Kind ChangeKind
+	// Project is the project that's tracked now or, for IdleStopped, the
+	// project that was tracked
+	Project string
+	// From is the project that was tracked before a switch
+	From string
+	// Branch and FromBranch are the git branches of Project and From, if work
+	// switched between branches (see EntryOptions.Branch)
+	Branch, FromBranch string
+}
+
+// StoppedEntry describes a time entry that was stopped by Status.Stop
+type StoppedEntry struct {
+	// TimeEntryID is the ID of the entry in Toggl, or 0 if it was recorded
+	// locally and hasn't been sent to Toggl (see SetLocalOnly) or was recorded
+	// in another tracker
+	TimeEntryID int64 `json:"time_entry_id"`
+	// TrackerEntryID is the ID of the entry in the tracker other than Toggl
+	// in which it was recorded, if any (see SetTracker)
+	TrackerEntryID string    `json:"tracker_entry_id,omitempty"`
+	Start          time.Time `json:"start"`
+	Stop           time.Time `json:"stop"`
+	Project        string    `json:"project"`
+	Description    string    `json:"description,omitempty"`
+	// Profile is the profile (Toggl account) in which the entry was recorded,
+	// or "" for the default account (see EntryOptions.Profile)
+	Profile string `json:"profile,omitempty"`
+	// Tags, Billable, Workspace, and Client are only recorded for entries
+	// that weren't sent to Toggl, so that they can be sent later
+	Tags      []string `json:"tags,omitempty"`
+	Billable  bool     `json:"billable,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+	Client    string   `json:"client,omitempty"`
+}
+
+// options returns the settings with which 'e' is created in Toggl
+func (e *StoppedEntry) options() EntryOptions {
+	return EntryOptions{
+		Description: e.Description,
+		Tags:        e.Tags,
+		Billable:    e.Billable,
+		Workspace:   e.Workspace,
+		Client:      e.Client,
+		Profile:     e.Profile,
+	}
 }
 
 // MarshalJSON allows Status to implement the json.Marshaller interface
 func (s *Status) MarshalJSON() ([]byte, error) {
 	output := map[string]string{
-		"tick":         s.latestTick.Format(time.RFC3339),
-		"project_name": s.projectName,
-		"project_id":   s.projectID,
+		"tick":          s.latestTick.Format(time.RFC3339),
+		"project_name":  s.projectName,
+		"project_id":    strconv.FormatInt(s.projectID, 10),
+		"time_entry_id": strconv.FormatInt(s.timeEntryID, 10),
+		"description":   s.description,
+	}
+	if s.detour {
+		output["detour"] = "true"
+		output["detour_project"] = s.detourProject
+	}
+	if !s.entryStart.IsZero() {
+		output["entry_start"] = s.entryStart.Format(time.RFC3339)
+	}
+	if len(s.tags) > 0 {
+		output["tags"] = strings.Join(s.tags, ",")
+	}
+	if s.billable {
+		output["billable"] = "true"
+	}
+	if s.branch != "" {
+		output["branch"] = s.branch
+	}
+	if s.trackerEntryID != "" {
+		output["tracker_entry_id"] = s.trackerEntryID
+	}
+	if s.workspaceID != 0 {
+		output["workspace_id"] = strconv.FormatInt(s.workspaceID, 10)
+	}
+	if s.workspace != "" {
+		output["workspace"] = s.workspace
+	}
+	if s.customer != "" {
+		output["client"] = s.customer
+	}
+	if s.profile != "" {
+		output["profile"] = s.profile
 	}
 	return json.Marshal(output)
 }
@@ -52,66 +250,715 @@ func (s *Status) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	s.projectName = fields["project_name"]
-	s.projectID = fields["project_id"]
+	s.description = fields["description"]
+	s.detour = fields["detour"] == "true"
+	s.detourProject = fields["detour_project"]
+	s.branch = fields["branch"]
+	s.trackerEntryID = fields["tracker_entry_id"]
+	s.workspace = fields["workspace"]
+	s.customer = fields["client"]
+	s.profile = fields["profile"]
+	s.billable = fields["billable"] == "true"
+	if tags := fields["tags"]; tags != "" {
+		s.tags = strings.Split(tags, ",")
+	}
 	var err error
+	if s.projectID, err = parseID(fields["project_id"]); err != nil {
+		return fmt.Errorf("could not parse project ID: %v", err)
+	}
+	if s.timeEntryID, err = parseID(fields["time_entry_id"]); err != nil {
+		return fmt.Errorf("could not parse time entry ID: %v", err)
+	}
+	if s.workspaceID, err = parseID(fields["workspace_id"]); err != nil {
+		return fmt.Errorf("could not parse workspace ID: %v", err)
+	}
 	s.latestTick, err = time.Parse(time.RFC3339, fields["tick"])
 	if err != nil {
 		return fmt.Errorf("could not parse time %q: %v", fields["tick"], err)
 	}
+	if start, ok := fields["entry_start"]; ok {
+		if s.entryStart, err = time.Parse(time.RFC3339, start); err != nil {
+			return fmt.Errorf("could not parse time %q: %v", start, err)
+		}
+	}
 	return nil
 }
 
-// Read reads the latest tick info from tgStateDir/tick into memory
+// parseID parses a Toggl ID that was serialized by MarshalJSON. Empty IDs
+// (e.g. in status files written by older versions of tg) are parsed as 0
+func parseID(id string) (int64, error) {
+	if id == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(id, 10, 64)
+}
+
+// New returns an empty Status (i.e. no work has been observed yet) that will be
+// persisted in 'tgStateDir'
+func New(tgStateDir string) *Status {
+	return &Status{
+		tgStateDir:  tgStateDir,
+		idleTimeout: defaultIdleTimeout,
+		ctx:         context.Background(),
+	}
+}
+
+// Read reads the latest tick info in tgStateDir into memory
 func Read(tgStateDir string) (*Status, error) {
 	if _, err := os.Stat(tgStateDir); err != nil {
 		return nil, fmt.Errorf("could not stat status directory at %q: %v", tgStateDir, err)
 	}
-	tickFile := path.Join(tgStateDir, tickFile)
-	f, err := os.Open(tickFile)
-	if err != nil {
+	result := New(tgStateDir)
+	if err := persist.ReadDoc(tgStateDir, tickDoc, result); err != nil {
 		return nil, err
 	}
-	result := &Status{
-		tgStateDir: tgStateDir,
+	return result, nil
+}
+
+// Project returns the name of the project with which the most recent tick
+// was associated
+func (s *Status) Project() string {
+	return s.projectName
+}
+
+// LatestTick returns the time of the most recent tick
+func (s *Status) LatestTick() time.Time {
+	return s.latestTick
+}
+
+// EntryStart returns the time at which the open time entry (or the current
+// stretch of work, if the entry hasn't been created yet) began
+func (s *Status) EntryStart() time.Time {
+	return s.entryStart
+}
+
+// TimeEntryID returns the ID of the open Toggl time entry, 0 if there is none,
+// LocalEntryID if it's awaiting approval, or TrackerEntryID if it's recorded
+// in another tracker
+func (s *Status) TimeEntryID() int64 {
+	return s.timeEntryID
+}
+
+// SetClient sets the client that 's' uses to send updates to Toggl, for time
+// entries that aren't in a profile (see SetProfileClient)
+func (s *Status) SetClient(c *togglclient.Client) {
+	s.baseClient = c
+	s.client = s.clientFor(s.profile)
+	s.resetCaches()
+}
+
+// SetProfileClient sets the client that 's' uses to send updates to the Toggl
+// account of the profile 'name' (see EntryOptions.Profile)
+func (s *Status) SetProfileClient(name string, c *togglclient.Client) {
+	if s.profileClients == nil {
+		s.profileClients = make(map[string]*togglclient.Client)
+	}
+	s.profileClients[name] = c
+	if name == s.profile {
+		s.client = c
+		s.resetCaches()
 	}
-	if err := json.NewDecoder(f).Decode(result); err != nil {
-		return nil, err
+}
+
+// clientFor returns the client of the profile 'name'. Profiles without a
+// client (e.g. because they were removed from the config) fall back to the
+// client set by SetClient
+func (s *Status) clientFor(name string) *togglclient.Client {
+	if c, ok := s.profileClients[name]; ok && name != "" {
+		return c
 	}
-	return result, nil
+	return s.baseClient
+}
+
+// useProfile makes 'name' the profile of s.client, and drops the cached IDs of
+// the previous profile's workspaces, customers, and projects
+func (s *Status) useProfile(name string) {
+	if name == s.profile {
+		return
+	}
+	if _, ok := s.profileClients[name]; !ok && name != "" && s.baseClient != nil {
+		statusLog.Warnf("no client for profile %q; using the default account", name)
+	}
+	s.profile = name
+	s.client = s.clientFor(name)
+	s.resetCaches()
+}
+
+// resetCaches drops the cached IDs of Toggl workspaces, customers, and
+// projects, e.g. after the client changes
+func (s *Status) resetCaches() {
+	s.projects = nil
+	s.workspaces = nil
+	s.customers = nil
+}
+
+// SetContext sets the context of the requests that 's' sends to Toggl. Once
+// 'ctx' is done, they're abandoned (and queued, as if Toggl were unreachable)
+func (s *Status) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// SetIdleTimeout sets the amount of time after the latest tick at which 's'
+// stops the open time entry
+func (s *Status) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+// SetStopGrace sets the amount of time after the latest tick at which 's'
+// stops a time entry once the user has gone idle. It's capped at the idle
+// timeout, so that entries never include time after the user went idle
+func (s *Status) SetStopGrace(d time.Duration) {
+	s.stopGrace = d
+}
+
+// SetBridgeGap sets the longest break after which work continues the open
+// time entry. Once the user goes idle (see StopIfIdle and StopInputIdle), the
+// entry is kept open for 'd' in case work resumes; if it doesn't, the entry is
+// stopped where the work ended, so a longer break splits it there. If 'd' is
+// 0, entries are stopped as soon as the user is idle
+func (s *Status) SetBridgeGap(d time.Duration) {
+	s.bridgeGap = d
+}
+
+// SetMinSwitchDuration sets how long a time entry must have been open before
+// work on a different project stops it and starts a new one. Until then, the
+// open entry is reassigned to the new project instead, so that touching two
+// projects in quick succession doesn't leave a trail of very short entries
+func (s *Status) SetMinSwitchDuration(d time.Duration) {
+	s.minSwitchDuration = d
+}
+
+// SetRequireApproval sets whether new time entries must be approved before
+// they're created in Toggl. If so, each entry is tracked locally until it
+// stops, and is then kept with the other pending entries (see
+// ApprovePendingEntries). An entry that's already open when this changes is
+// finished as it began
+func (s *Status) SetRequireApproval(required bool) {
+	s.requireApproval = required
 }
 
-// Save persists 's' to the file 's.tgStateDir/tick
+// SetLocalOnly sets whether 's' tracks time without Toggl. If so, each time
+// entry is tracked locally, like an entry awaiting approval, and is recorded
+// in the journal (with no TimeEntryID) once it stops, without being sent to
+// Toggl. An entry that's already open when this changes is recorded where
+// the setting says when it stops
+func (s *Status) SetLocalOnly(localOnly bool) {
+	s.localOnly = localOnly
+}
+
+// LocalOnly returns true if 's' tracks time without Toggl (see SetLocalOnly)
+func (s *Status) LocalOnly() bool {
+	return s.localOnly
+}
+
+// idleStop returns the time at which an entry that has gone idle is stopped:
+// the latest tick plus the grace period, but no later than 'now'
+func (s *Status) idleStop(now time.Time) time.Time {
+	grace := s.stopGrace
+	if grace > s.idleTimeout {
+		grace = s.idleTimeout
+	}
+	if stop := s.latestTick.Add(grace); stop.Before(now) {
+		return stop
+	}
+	return now
+}
+
+// idleSince returns the time at which the open time entry's work ended, if
+// the user has been idle since then as of 'now' (see StopIfIdle and
+// StopInputIdle), and whether they have
+func (s *Status) idleSince(now time.Time) (time.Time, bool) {
+	if !s.idleAt.IsZero() {
+		return s.idleAt, true
+	}
+	if now.Sub(s.latestTick) > s.idleTimeout {
+		return s.idleStop(now), true
+	}
+	return time.Time{}, false
+}
+
+// SetStopCallback sets a function that is called with each time entry that 's'
+// stops
+func (s *Status) SetStopCallback(cb func(StoppedEntry)) {
+	s.onStop = cb
+}
+
+// SetChangeCallback sets a function that is called when 's' starts tracking,
+// switches projects, or stops tracking after an idle period
+func (s *Status) SetChangeCallback(cb func(Change)) {
+	s.onChange = cb
+}
+
+// changed passes 'c' to s.onChange, if it's set
+func (s *Status) changed(c Change) {
+	if s.onChange != nil {
+		s.onChange(c)
+	}
+}
+
+// Save persists 's' in s.tgStateDir
 func (s *Status) Save() error {
-	if _, err := os.Stat(s.tgStateDir); err != nil {
-		if err := os.MkdirAll(s.tgStateDir, 0755); err != nil {
-			return fmt.Errorf("could not create state dir at %q: %v", s.tgStateDir, err)
-		}
+	if err := persist.MakeStateDir(s.tgStateDir); err != nil {
+		return err
 	}
-	tickFile := path.Join(s.tgStateDir, tickFile)
-	f, err := os.OpenFile(tickFile, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("could not create status file at %q: %v", tickFile, err)
+	return persist.WriteDoc(s.tgStateDir, tickDoc, s)
+}
+
+// EntryOptions are the settings of the time entries started by TickWith
+type EntryOptions struct {
+	Description string
+	Tags        []string
+	// Billable marks the entry as billable, unless the user is on a
+	// non-billable detour (see StartDetour)
+	Billable bool
+
+	// Branch, if set, is the git branch on which the work happened. Work on a
+	// different branch than that of the open time entry stops it (or
+	// reassigns it, as with work on a different project), even if the project
+	// is the same
+	Branch string
+
+	// MinActivity is the activity in the project that's required before a
+	// time entry is started for it (or work switches to it from another
+	// project). Ticks until then are held back, and credited to the entry
+	// once the threshold is met
+	MinActivity ActivityThreshold
+
+	// Workspace is the name or ID of the Toggl workspace in which the time
+	// entry is created, and Client is the name of the customer (Toggl client)
+	// to whose project it's assigned. The client's workspace is used if
+	// Workspace is unset, and the client (and project) are created if they
+	// don't exist. Entries can't move between workspaces, so work that
+	// switches workspaces always starts a new entry
+	Workspace string
+	Client    string
+
+	// Profile is the name of the profile (Toggl account) in which the time
+	// entry is created (see SetProfileClient), or "" for the account of the
+	// client set by SetClient. Like workspaces, entries can't move between
+	// accounts, so work that switches profiles always starts a new entry
+	Profile string
+}
+
+// workLabel describes work on 'project' and (if it's set) 'branch' in logs
+func workLabel(project, branch string) string {
+	if branch == "" {
+		return fmt.Sprintf("%q", project)
 	}
-	return json.NewEncoder(f).Encode(s)
+	return fmt.Sprintf("%q (branch %q)", project, branch)
 }
 
 // Tick notifies 's' that a new work event has occurred on the project
-// 'projectName'
+// 'projectName' (see TickWith)
 func (s *Status) Tick(projectName string) error {
+	return s.TickWith(projectName, EntryOptions{})
+}
+
+// TickWith notifies 's' that a new work event has occurred on the project
+// 'projectName'. If 's' has a client, the project's ID is looked up (and the
+// project created, if necessary), and if there's no open time entry, one is
+// started with the settings in 'opts'. Work on a different project (or, if
+// opts.Branch is set, git branch) than that of the open entry stops it. If
+// Toggl can't be reached, the tick is still recorded, and the entry is started
+// by a later tick
+func (s *Status) TickWith(projectName string, opts EntryOptions) error {
 	now := time.Now()
-	if now.Sub(s.latestTick) > maxTickGap {
-		s.Stop(s.latestTick)
+	// Work in the same project after a break shorter than the bridge gap
+	// continues the open entry; otherwise, the entry stops where work ended
+	resumed := s.sameProject(projectName, opts) ||
+		(s.detour && s.detourProject == s.projectName)
+	if stop, idle := s.idleSince(now); idle && resumed && now.Sub(stop) <= s.bridgeGap {
+		if s.timeEntryID != 0 {
+			statusLog.Infof("continuing time entry %d after a break of %s",
+				s.timeEntryID, now.Sub(stop).Round(time.Second))
+		}
+		s.idleAt = time.Time{}
+	} else if idle {
+		wasOpen := s.timeEntryID != 0
+		if wasOpen {
+			statusLog.Infof("stopping time entry %d after an idle period", s.timeEntryID)
+		}
+		if err := s.Stop(stop); err != nil {
+			return err
+		}
+		if wasOpen {
+			s.changed(Change{Kind: IdleStopped, Project: s.projectName})
+		}
+		s.entryStart = time.Time{} // the entry was never created
+		s.EndDetour()              // detours end after an idle period
+	}
+	if s.detour && s.detourProject != "" {
+		projectName = s.detourProject
+	}
+	begin := now // when the work being ticked began
+	if !s.sameProject(projectName, opts) || (s.timeEntryID == 0 && s.entryStart.IsZero()) {
+		var ok bool
+		if begin, ok = s.warmUp(projectName, now, opts.MinActivity); !ok {
+			return nil
+		}
+	} else {
+		s.warmup = warmup{} // stray ticks elsewhere don't add up across entries
 	}
+	reassign := false
+	var switchedFrom string // the project of the entry that work switched from
+	var switchedBranch bool // true if work switched between git branches
+	fromBranch := s.branch
+	branchChanged := opts.Branch != "" && s.branch != "" && opts.Branch != s.branch
+	if !s.sameProject(projectName, opts) || branchChanged {
+		from, to := workLabel(s.projectName, ""), workLabel(projectName, "")
+		if branchChanged {
+			from, to = workLabel(s.projectName, s.branch), workLabel(projectName, opts.Branch)
+		}
+		if s.timeEntryID != 0 {
+			switchedFrom, switchedBranch = s.projectName, branchChanged
+		}
+		if s.timeEntryID != 0 && now.Sub(s.entryStart) < s.minSwitchDuration &&
+			s.canReassign() && opts.Profile == s.profile {
+			statusLog.Infof("reassigning time entry %d from %s to %s", s.timeEntryID,
+				from, to)
+			reassign = true
+		} else {
+			if s.timeEntryID != 0 {
+				statusLog.Infof("stopping time entry %d, as work switched from %s to %s",
+					s.timeEntryID, from, to)
+			}
+			if err := s.Stop(begin); err != nil {
+				return err
+			}
+			s.entryStart = time.Time{} // work on the new project starts at 'begin'
+		}
+	}
+	if s.timeEntryID == 0 && s.entryStart.IsZero() {
+		s.entryStart = begin
+	}
+	hadEntry := s.timeEntryID != 0
 	s.latestTick = now
 	s.projectName = projectName
-	// TODO look up project ID
+	s.workspace, s.customer = opts.Workspace, opts.Client
+	s.useProfile(opts.Profile)
+	s.projectID = 0
+	if opts.Branch != "" {
+		s.branch = opts.Branch
+	}
+	var err error
+	if s.timeEntryID == LocalEntryID ||
+		((s.requireApproval || s.localOnly) && s.timeEntryID == 0) {
+		if s.timeEntryID == 0 || reassign {
+			s.startLocal(opts)
+		}
+	} else if s.timeEntryID == TrackerEntryID || s.tracker != nil {
+		if s.timeEntryID == 0 {
+			err = s.startTracked(opts)
+		}
+	} else if s.client != nil {
+		var wid int64
+		wid, s.projectID, err = s.resolveProject(projectName, opts)
+		if err == nil && reassign && s.workspaceID != 0 && wid != s.workspaceID {
+			statusLog.Infof("stopping time entry %d, as it can't be moved to "+
+				"workspace %d", s.timeEntryID, wid)
+			if err = s.Stop(now); err == nil {
+				s.entryStart = now
+			}
+		}
+		if err == nil && s.timeEntryID == 0 {
+			err = s.start(wid, opts)
+		} else if err == nil && reassign {
+			err = s.reassign(opts)
+		}
+	}
+	if saveErr := s.Save(); saveErr != nil {
+		return saveErr
+	}
+	if err == nil && s.timeEntryID != 0 && (!hadEntry || reassign) {
+		if switchedFrom != "" && switchedBranch {
+			s.changed(Change{Kind: Switched, Project: projectName, From: switchedFrom,
+				Branch: opts.Branch, FromBranch: fromBranch})
+		} else if switchedFrom != "" {
+			s.changed(Change{Kind: Switched, Project: projectName, From: switchedFrom})
+		} else {
+			s.changed(Change{Kind: Started, Project: projectName})
+		}
+	}
+	return err
+}
+
+// sameProject returns true if work in 'projectName' with 'opts' is in the
+// project of the most recently registered write (in the same workspace, for
+// the same customer, and in the same profile)
+func (s *Status) sameProject(projectName string, opts EntryOptions) bool {
+	return projectName == s.projectName && opts.Workspace == s.workspace &&
+		opts.Client == s.customer && opts.Profile == s.profile
+}
+
+// SameProject returns true if activity in 'projectName' with 'opts' would
+// continue the open time entry (or, if there's none, the most recent work)
+// rather than switch away from it
+func (s *Status) SameProject(projectName string, opts EntryOptions) bool {
+	return s.sameProject(projectName, opts)
+}
+
+// entryBillable returns true if a time entry started with 'opts' is billable
+func (s *Status) entryBillable(opts EntryOptions) bool {
+	return opts.Billable && !(s.detour && s.detourProject == "")
+}
+
+// start creates a running time entry for s.projectName in the workspace
+// 'wid', starting at s.entryStart
+func (s *Status) start(wid int64, opts EntryOptions) error {
+	e, err := s.client.CreateTimeEntry(s.ctx, togglclient.TimeEntry{
+		WorkspaceID: wid,
+		ProjectID:   s.projectID,
+		Description: opts.Description,
+		Tags:        opts.Tags,
+		Billable:    s.entryBillable(opts),
+		Start:       s.entryStart,
+	})
+	if err != nil {
+		return fmt.Errorf("could not start time entry: %v", err)
+	}
+	s.timeEntryID = e.ID
+	s.workspaceID = wid
+	s.description = opts.Description
+	statusLog.Infof("started time entry %d for %q", e.ID, s.projectName)
+	return nil
+}
+
+// startLocal starts (or, if one is open, reassigns) a time entry that's
+// tracked locally until it's approved (see SetRequireApproval)
+func (s *Status) startLocal(opts EntryOptions) {
+	if s.timeEntryID == 0 && s.localOnly {
+		statusLog.Infof("started a local time entry for %q", s.projectName)
+	} else if s.timeEntryID == 0 {
+		statusLog.Infof("started a time entry for %q, to be approved", s.projectName)
+	}
+	s.timeEntryID = LocalEntryID
+	s.description = opts.Description
+	s.tags = opts.Tags
+	s.billable = s.entryBillable(opts)
+}
+
+// reassign moves the open time entry to s.projectName, with the settings in
+// 'opts'
+func (s *Status) reassign(opts EntryOptions) error {
+	projectID, desc, billable := s.projectID, opts.Description, s.entryBillable(opts)
+	err := s.submit(op{
+		Kind:        opUpdate,
+		TimeEntryID: s.timeEntryID,
+		WorkspaceID: s.workspaceID,
+		Update: &togglclient.TimeEntryUpdate{
+			ProjectID:   &projectID,
+			Description: &desc,
+			Billable:    &billable,
+			Tags:        opts.Tags,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.description = opts.Description
+	return nil
+}
+
+// StopIfIdle stops the open time entry (if any) if no tick has been
+// registered within the idle timeout of 'now' (or the user's input has been
+// idle; see StopInputIdle), and the bridge gap has passed since. Unlike Tick,
+// this doesn't require new work to arrive, so it may be called periodically to
+// end entries after the user has stopped working. It returns true if an entry
+// was stopped
+func (s *Status) StopIfIdle(now time.Time) (bool, error) {
+	stop, idle := s.idleSince(now)
+	if s.timeEntryID == 0 || !idle || now.Sub(stop) <= s.bridgeGap {
+		return false, nil
+	}
+	if err := s.Stop(stop); err != nil {
+		return false, err
+	}
+	s.changed(Change{Kind: IdleStopped, Project: s.projectName})
+	s.EndDetour()
+	return true, s.Save()
+}
+
+// StopInputIdle stops the open time entry (if any) at 'lastInput', the time
+// of the user's last keyboard or mouse input (or at the entry's start, if
+// that's later), once the bridge gap has passed since then as of 'now'. Until
+// then, the entry is kept open in case the user returns. It returns true if an
+// entry was stopped
+func (s *Status) StopInputIdle(now, lastInput time.Time) (bool, error) {
+	if s.timeEntryID == 0 {
+		return false, nil
+	}
+	if lastInput.Before(s.entryStart) {
+		lastInput = s.entryStart
+	}
+	if s.idleAt.IsZero() || lastInput.Before(s.idleAt) {
+		s.idleAt = lastInput
+	}
+	if now.Sub(s.idleAt) <= s.bridgeGap {
+		return false, nil
+	}
+	if err := s.Stop(s.idleAt); err != nil {
+		return false, err
+	}
+	s.changed(Change{Kind: IdleStopped, Project: s.projectName})
+	s.EndDetour()
+	return true, s.Save()
+}
+
+// StopWorking stops the open time entry (if any) as if the user had just gone
+// idle: at the latest tick plus the stop grace, but no later than 'now'. It's
+// used when the daemon exits, so that no entry is left running without it
+func (s *Status) StopWorking(now time.Time) error {
+	if s.timeEntryID == 0 {
+		return nil
+	}
+	stop := s.idleStop(now)
+	if !s.idleAt.IsZero() {
+		stop = s.idleAt // the user went idle first
+	}
+	if err := s.Stop(stop); err != nil {
+		return err
+	}
 	return s.Save()
 }
 
+// StartDetour marks subsequent work as a temporary detour, which lasts until
+// EndDetour is called or an idle period ends it. If 'project' is set, all work
+// during the detour is attributed to it, regardless of which directory it
+// happens in; otherwise work is attributed as usual but marked non-billable
+func (s *Status) StartDetour(project string) {
+	s.detour = true
+	s.detourProject = project
+}
+
+// EndDetour ends the current detour (if any)
+func (s *Status) EndDetour() {
+	s.detour = false
+	s.detourProject = ""
+}
+
+// Detour returns true if the user is on a detour, and the project to which
+// the detour's work is attributed (or "" if the detour is non-billable work in
+// the usual projects)
+func (s *Status) Detour() (bool, string) {
+	return s.detour, s.detourProject
+}
+
 // Stop is a helper function that causes 's' to tell toggl that work in the
-// current Toggl time event has stopped
+// current Toggl time event has stopped. If Toggl can't be reached, the stop is
+// queued in the outbox (see RetryOutbox) and the entry is closed locally
 func (s *Status) Stop(t time.Time) error {
-	resp, err := Post(fmt.Sprintf("time_entries/%s/stop", s.timeEntryID), "")
-	fmt.Printf("%+v (%v)\n", resp, err)
-	return err
+	if s.timeEntryID == 0 {
+		return nil // no open time entry
+	}
+	if s.timeEntryID == LocalEntryID {
+		var err error
+		if s.localOnly {
+			err = s.recordLocal(t)
+		} else {
+			err = addPendingEntry(s.tgStateDir, PendingEntry{
+				Project:     s.projectName,
+				Description: s.description,
+				Tags:        s.tags,
+				Billable:    s.billable,
+				Start:       s.entryStart,
+				Stop:        t,
+				Workspace:   s.workspace,
+				Client:      s.customer,
+				Profile:     s.profile,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		s.closeEntry()
+		return nil
+	}
+	if s.timeEntryID == TrackerEntryID {
+		if err := s.stopTracked(t); err != nil {
+			return err
+		}
+		s.closeEntry()
+		return nil
+	}
+	if s.client == nil {
+		return fmt.Errorf("cannot stop time entry %d: no toggl client", s.timeEntryID)
+	}
+	err := s.submit(op{
+		Kind:        opStop,
+		TimeEntryID: s.timeEntryID,
+		WorkspaceID: s.workspaceID,
+		Stop:        t,
+		Project:     s.projectName,
+		Description: s.description,
+	})
+	if err != nil {
+		return err
+	}
+	s.closeEntry()
+	return nil
+}
+
+// closeEntry clears the state of the open time entry, once it's stopped
+func (s *Status) closeEntry() {
+	s.timeEntryID = 0
+	s.workspaceID = 0
+	s.trackerEntryID = ""
+	s.description = ""
+	s.tags = nil
+	s.billable = false
+	s.branch = ""
+	s.entryStart = time.Time{}
+	s.idleAt = time.Time{}
+}
+
+// recordLocal records the open local time entry, stopped at 't', in the
+// journal and passes it to s.onStop
+func (s *Status) recordLocal(t time.Time) error {
+	stopped := StoppedEntry{
+		Start:       s.entryStart,
+		Stop:        t,
+		Project:     s.projectName,
+		Description: s.description,
+		Profile:     s.profile,
+		Tags:        s.tags,
+		Billable:    s.billable,
+		Workspace:   s.workspace,
+		Client:      s.customer,
+	}
+	if err := AppendJournal(s.tgStateDir, stopped); err != nil {
+		return err
+	}
+	statusLog.Infof("recorded a local time entry for %q", s.projectName)
+	if s.onStop != nil {
+		s.onStop(stopped)
+	}
+	return nil
+}
+
+// UpdateLastActive notes the time of the latest tick in the description of the
+// open time entry, so that the Toggl web UI shows when work last happened. It
+// does nothing if there's no open time entry or if the latest tick has already
+// been sent, so it may be called periodically to batch many ticks into a single
+// API call
+func (s *Status) UpdateLastActive() error {
+	if s.timeEntryID == 0 || s.timeEntryID == LocalEntryID || s.timeEntryID == TrackerEntryID ||
+		s.latestTick.Equal(s.lastActiveSent) {
+		return nil
+	}
+	if s.client == nil {
+		return fmt.Errorf("cannot update time entry %d: no toggl client", s.timeEntryID)
+	}
+	desc := strings.TrimSpace(fmt.Sprintf("%s (last active %s)", s.description,
+		s.latestTick.Format("15:04")))
+	err := s.submit(op{
+		Kind:        opUpdate,
+		TimeEntryID: s.timeEntryID,
+		WorkspaceID: s.workspaceID,
+		Update:      &togglclient.TimeEntryUpdate{Description: &desc},
+	})
+	if err != nil {
+		return err
+	}
+	s.lastActiveSent = s.latestTick
+	return nil
 }
@@ -0,0 +1,370 @@
+package status
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestStopIfIdle(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	e, err := client.CreateTimeEntry(context.Background(), togglclient.TimeEntry{Start: time.Now()})
+	if err != nil {
+		t.Fatalf("could not create time entry: %v", err)
+	}
+
+	s := New(d)
+	s.SetClient(client)
+	s.timeEntryID = e.ID
+	s.latestTick = time.Now()
+	s.SetStopGrace(time.Minute)
+	if stopped, err := s.StopIfIdle(s.latestTick.Add(s.idleTimeout)); stopped || err != nil {
+		t.Fatalf("expected entry to stay open before the idle timeout, but got %t (%v)", stopped, err)
+	}
+	if stopped, err := s.StopIfIdle(s.latestTick.Add(s.idleTimeout + time.Second)); !stopped || err != nil {
+		t.Fatalf("expected entry to be stopped after the idle timeout, but got %t (%v)", stopped, err)
+	}
+	if s.TimeEntryID() != 0 {
+		t.Fatalf("expected no open time entry, but have %d", s.TimeEntryID())
+	}
+	// The entry is stopped a grace period after the last tick
+	stop := s.latestTick.Add(time.Minute)
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Stop == nil || !entries[0].Stop.Equal(stop) {
+		t.Fatalf("expected one time entry stopped at %s, but got %+v", stop, entries)
+	}
+}
+
+func TestChangeCallback(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	var changes []Change
+	s.SetChangeCallback(func(c Change) { changes = append(changes, c) })
+
+	for _, project := range []string{"a", "a", "b"} {
+		if err := s.Tick(project); err != nil {
+			t.Fatalf("could not tick: %v", err)
+		}
+	}
+	s.latestTick = time.Now().Add(-s.idleTimeout - time.Minute)
+	if stopped, err := s.StopIfIdle(time.Now()); !stopped || err != nil {
+		t.Fatalf("expected entry to be stopped, but got %t (%v)", stopped, err)
+	}
+	expected := []Change{
+		{Kind: Started, Project: "a"},
+		{Kind: Switched, Project: "b", From: "a"},
+		{Kind: IdleStopped, Project: "b"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %+v, but got %+v", expected, changes)
+	}
+}
+
+func TestBranchSwitch(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	var changes []Change
+	s.SetChangeCallback(func(c Change) { changes = append(changes, c) })
+
+	// Work without a branch (e.g. from a window title) extends the entry, but
+	// work on another branch of the same project starts a new one
+	ticks := []EntryOptions{
+		{Branch: "main", Tags: []string{"main"}},
+		{},
+		{Branch: "feature/foo", Tags: []string{"feature/foo"}},
+	}
+	for _, opts := range ticks {
+		if err := s.TickWith("a", opts); err != nil {
+			t.Fatalf("could not tick: %v", err)
+		}
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 2 || entries[0].Running() || !entries[1].Running() ||
+		!reflect.DeepEqual(entries[1].Tags, []string{"feature/foo"}) {
+		t.Fatalf("expected a stopped entry and a running one on feature/foo, but "+
+			"got %+v", entries)
+	}
+	expected := []Change{
+		{Kind: Started, Project: "a"},
+		{Kind: Switched, Project: "a", From: "a", Branch: "feature/foo",
+			FromBranch: "main"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected changes %+v, but got %+v", expected, changes)
+	}
+
+	// The branch is persisted with the open entry
+	saved, err := Read(d)
+	if err != nil || saved.branch != "feature/foo" {
+		t.Fatalf("expected saved branch feature/foo, but got %q (%v)", saved.branch, err)
+	}
+}
+
+func TestBillable(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+
+	opts := EntryOptions{Tags: []string{"deepwork", "clientX"}, Billable: true}
+	if err := s.TickWith("a", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	// Entries started during a non-billable detour aren't billable
+	s.StartDetour("")
+	if err := s.TickWith("b", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 2 || !entries[0].Billable || entries[1].Billable ||
+		!reflect.DeepEqual(entries[0].Tags, opts.Tags) {
+		t.Fatalf("expected a billable entry with tags %v and a non-billable one, "+
+			"but got %+v", opts.Tags, entries)
+	}
+}
+
+func TestLocalOnly(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	s := New(d) // no client: entries never reach Toggl
+	s.SetLocalOnly(true)
+	var stopped []StoppedEntry
+	s.SetStopCallback(func(e StoppedEntry) { stopped = append(stopped, e) })
+
+	if err := s.TickWith("a", EntryOptions{Tags: []string{"x"}, Billable: true}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s.TimeEntryID() != LocalEntryID {
+		t.Fatalf("expected a local entry, but have %d", s.TimeEntryID())
+	}
+	if err := s.Tick("b"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	journal, err := ReadJournal(d, time.Now().Add(-time.Hour), time.Now())
+	if err != nil || len(journal) != 1 || journal[0].TimeEntryID != 0 ||
+		journal[0].Project != "a" || !journal[0].Billable || len(journal[0].Tags) != 1 {
+		t.Fatalf("expected one local entry for \"a\" in the journal, but got %+v (%v)",
+			journal, err)
+	}
+	if len(stopped) != 1 || stopped[0].Project != "a" {
+		t.Fatalf("expected the stop callback for \"a\", but got %+v", stopped)
+	}
+	if pending, err := ReadPendingEntries(d); err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending entries, but got %+v (%v)", pending, err)
+	}
+}
+
+func TestTickStartsEntries(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+
+	// The first tick starts an entry, and later ticks extend it
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || !entries[0].Running() || entries[0].ProjectID != s.projectID {
+		t.Fatalf("expected one running entry in project %d, but got %+v", s.projectID, entries)
+	}
+
+	// Work on another project stops the entry and starts a new one
+	if err := s.TickWith("b", EntryOptions{Description: "d"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 2 || entries[0].Running() || !entries[1].Running() ||
+		entries[1].Description != "d" {
+		t.Fatalf("expected a stopped and a running entry, but got %+v", entries)
+	}
+
+	// After an idle period, the entry is stopped at the last tick
+	s.latestTick = time.Now().Add(-time.Hour)
+	last := s.latestTick
+	if err := s.Tick("b"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 3 || entries[1].Stop == nil || !entries[1].Stop.Equal(last) {
+		t.Fatalf("expected entry to stop at %s, but got %+v", last, entries)
+	}
+
+	// If Toggl is unreachable when work begins, the entry is started (at the
+	// time work began) by a later tick
+	s.latestTick = time.Now().Add(-time.Hour)
+	server.SetUnavailable(true)
+	if err := s.Tick("c"); err == nil {
+		t.Fatalf("expected error starting entry while Toggl is unavailable")
+	}
+	began := s.latestTick
+	server.SetUnavailable(false)
+	if err := s.RetryOutbox(time.Now().Add(maxRetryDelay)); err != nil {
+		t.Fatalf("could not retry outbox: %v", err)
+	}
+	if err := s.Tick("c"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 4 || entries[2].Running() || !entries[3].Running() ||
+		!entries[3].Start.Equal(began) {
+		t.Fatalf("expected entry starting at %s, but got %+v", began, entries)
+	}
+}
+
+func TestMinSwitchDuration(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetMinSwitchDuration(time.Minute)
+
+	// Switching projects soon after an entry starts moves the entry to the new
+	// project, rather than starting another one
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if err := s.TickWith("b", EntryOptions{Description: "in b"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || !entries[0].Running() || entries[0].ProjectID != s.projectID ||
+		entries[0].Description != "in b" {
+		t.Fatalf("expected one running entry in project %d, but got %+v", s.projectID, entries)
+	}
+
+	// Once the entry has been open long enough, switching starts a new entry
+	s.entryStart = s.entryStart.Add(-time.Minute)
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 2 || entries[0].Running() || !entries[1].Running() {
+		t.Fatalf("expected a stopped and a running entry, but got %+v", entries)
+	}
+}
+
+func TestStopWorkingAfterRestart(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+
+	// The open entry survives a restart, and is stopped at the last tick (plus
+	// the stop grace) when the daemon exits
+	s, err := Read(d)
+	if err != nil {
+		t.Fatalf("could not read status: %v", err)
+	}
+	s.SetClient(server.Client())
+	s.SetStopGrace(time.Minute)
+	last := s.LatestTick()
+	if err := s.StopWorking(last.Add(time.Hour)); err != nil {
+		t.Fatalf("could not stop working: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || entries[0].Stop == nil || !entries[0].Stop.Equal(last.Add(time.Minute)) {
+		t.Fatalf("expected one entry stopped at %s, but got %+v", last.Add(time.Minute), entries)
+	}
+	if saved, err := Read(d); err != nil || saved.TimeEntryID() != 0 {
+		t.Fatalf("expected no saved open entry, but got %d (%v)", saved.TimeEntryID(), err)
+	}
+	// Stopping again does nothing
+	if err := s.StopWorking(time.Now()); err != nil || len(server.TimeEntries()) != 1 {
+		t.Fatalf("expected stopping again to be a no-op, but got %v", err)
+	}
+}
+
+func TestBridgeGap(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	s.SetIdleTimeout(5 * time.Minute)
+	s.SetBridgeGap(15 * time.Minute)
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+
+	// A break longer than the idle timeout but shorter than the bridge gap
+	// leaves the entry open, and work after it continues the entry
+	s.latestTick = s.latestTick.Add(-10 * time.Minute)
+	if stopped, err := s.StopIfIdle(time.Now()); stopped || err != nil {
+		t.Fatalf("expected the entry to stay open across the break, but got %t (%v)", stopped, err)
+	}
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if entries := server.TimeEntries(); len(entries) != 1 || !entries[0].Running() {
+		t.Fatalf("expected one running entry, but got %+v", entries)
+	}
+
+	// A longer break splits the entry where the work ended
+	s.latestTick = s.latestTick.Add(-20 * time.Minute)
+	last := s.latestTick
+	if err := s.Tick("a"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 2 || entries[0].Stop == nil || !entries[0].Stop.Equal(last) ||
+		!entries[1].Running() {
+		t.Fatalf("expected an entry stopped at %s and a running one, but got %+v", last, entries)
+	}
+
+	// So does a break after which work happens in another project
+	s.latestTick = s.latestTick.Add(-10 * time.Minute)
+	last = s.latestTick
+	if err := s.Tick("b"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 3 || entries[1].Stop == nil || !entries[1].Stop.Equal(last) {
+		t.Fatalf("expected an entry stopped at %s, but got %+v", last, entries)
+	}
+
+	// An input idle entry is kept open for the bridge gap, then stopped at the
+	// last input
+	lastInput := time.Now()
+	if stopped, err := s.StopInputIdle(lastInput.Add(time.Minute), lastInput); stopped || err != nil {
+		t.Fatalf("expected the entry to stay open across the break, but got %t (%v)", stopped, err)
+	}
+	if stopped, err := s.StopIfIdle(lastInput.Add(16 * time.Minute)); !stopped || err != nil {
+		t.Fatalf("expected the entry to be stopped after the bridge gap, but got %t (%v)", stopped, err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 3 || entries[2].Stop == nil || !entries[2].Stop.Equal(lastInput) {
+		t.Fatalf("expected an entry stopped at %s, but got %+v", lastInput, entries)
+	}
+}
@@ -0,0 +1,159 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+// watchDoc is the document in tgStateDir's database (see persist.DBFile)
+// where the root watches are stored (see watchStore)
+const watchDoc = "watch"
+
+// watchStore is the contents of the watch document: every root watch, in the
+// order in which they were added, along with its project and options
+type watchStore struct {
+	// NextID is the ID given to the next root watch that's added. IDs aren't
+	// reused, so that a stale ID can't select a different root watch
+	NextID  int         `json:"next_id"`
+	Watches []RootWatch `json:"watches"`
+}
+
+// errReadOnly is returned by updateStore callbacks that don't modify the
+// store, so that the watch document isn't rewritten
+var errReadOnly = errors.New("read only")
+
+// readStore reads the watch document in 'tgStateDir'. If it doesn't exist, or
+// can't be read, an empty store is returned (along with the error, in the
+// latter case)
+func readStore(tgStateDir string) (*watchStore, error) {
+	s := &watchStore{}
+	err := persist.ReadDoc(tgStateDir, watchDoc, s)
+	if os.IsNotExist(err) {
+		s, err = &watchStore{}, nil // nothing has been watched yet
+	} else if err != nil {
+		s, err = &watchStore{}, fmt.Errorf("could not read watch state: %v", err)
+	}
+	s.assignNextID()
+	return s, err
+}
+
+// assignNextID makes sure that s.NextID is greater than the ID of every root
+// watch in 's'
+func (s *watchStore) assignNextID() {
+	if s.NextID < 1 {
+		s.NextID = 1
+	}
+	for i := range s.Watches {
+		if s.Watches[i].ID >= s.NextID {
+			s.NextID = s.Watches[i].ID + 1
+		}
+	}
+}
+
+// writeStore atomically replaces the watch document in 'tgStateDir' with 's'
+func writeStore(tgStateDir string, s *watchStore) error {
+	if err := persist.WriteDoc(tgStateDir, watchDoc, s); err != nil {
+		return fmt.Errorf("could not write watch state: %v", err)
+	}
+	return nil
+}
+
+// updateStore reads the watch document in 'tgStateDir', applies 'f' to it,
+// and writes the result back (unless 'f' returns an error, such as
+// errReadOnly), in one transaction
+func updateStore(tgStateDir string, f func(s *watchStore) error) error {
+	s := &watchStore{}
+	return persist.UpdateDoc(tgStateDir, watchDoc, s, func() error {
+		s.assignNextID()
+		return f(s)
+	})
+}
+
+// find returns the root watch of 'dir' in 's', or nil if there is none
+func (s *watchStore) find(dir string) *RootWatch {
+	for i := range s.Watches {
+		if s.Watches[i].Dir == dir {
+			return &s.Watches[i]
+		}
+	}
+	return nil
+}
+
+// put adds 'rw' to 's' with a new ID or, if its directory is already watched,
+// replaces that root watch's project and options (keeping its ID)
+func (s *watchStore) put(rw RootWatch) {
+	if existing := s.find(rw.Dir); existing != nil {
+		rw.ID = existing.ID
+		*existing = rw
+		return
+	}
+	rw.ID = s.NextID
+	s.NextID++
+	s.Watches = append(s.Watches, rw)
+}
+
+// remove removes the root watch of 'dir' from 's', and returns it
+func (s *watchStore) remove(dir string) (RootWatch, bool) {
+	for i, rw := range s.Watches {
+		if rw.Dir == dir {
+			s.Watches = append(s.Watches[:i], s.Watches[i+1:]...)
+			return rw, true
+		}
+	}
+	return RootWatch{}, false
+}
+
+// sync makes 's' match 'projects' (a map from root watches to their projects)
+// and 'options': root watches that aren't in 'projects' are removed, and those
+// that aren't in 's' are added (in order of directory) with new IDs
+func (s *watchStore) sync(projects map[string]string, options map[string]RootOptions) {
+	kept := s.Watches[:0]
+	for _, rw := range s.Watches {
+		if project, ok := projects[rw.Dir]; ok {
+			rw.Project, rw.RootOptions = project, options[rw.Dir]
+			kept = append(kept, rw)
+		}
+	}
+	s.Watches = kept
+	added := make([]string, 0, len(projects))
+	for dir := range projects {
+		if s.find(dir) == nil {
+			added = append(added, dir)
+		}
+	}
+	sort.Strings(added)
+	for _, dir := range added {
+		s.put(RootWatch{Dir: dir, Project: projects[dir], RootOptions: options[dir]})
+	}
+}
+
+// projects returns a map from each root watch in 's' to its project
+func (s *watchStore) projects() map[string]string {
+	result := make(map[string]string, len(s.Watches))
+	for _, rw := range s.Watches {
+		result[rw.Dir] = rw.Project
+	}
+	return result
+}
+
+// options returns a map from each root watch in 's' that has options to them
+func (s *watchStore) options() map[string]RootOptions {
+	result := make(map[string]RootOptions)
+	for _, rw := range s.Watches {
+		if !rw.isZero() {
+			result[rw.Dir] = rw.RootOptions
+		}
+	}
+	return result
+}
+
+// sorted returns a copy of the root watches in 's', sorted by directory
+func (s *watchStore) sorted() []RootWatch {
+	result := append([]RootWatch(nil), s.Watches...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Dir < result[j].Dir })
+	return result
+}
@@ -0,0 +1,169 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/persist"
+)
+
+func TestRootWatchIDs(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Mkdir(j(d, name), 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", j(d, name), err)
+		}
+	}
+	err := SaveRootWatches(d, []RootWatch{
+		{Dir: j(d, "b"), Project: "b"},
+		{Dir: j(d, "a"), Project: "a", ID: 10}, // ignored
+	})
+	if err != nil {
+		t.Fatalf("could not save watches: %v", err)
+	}
+	if _, err := RemoveRootWatch(d, j(d, "b")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	if err := SaveRootWatch(d, j(d, "c"), "c"); err != nil {
+		t.Fatalf("could not save watch: %v", err)
+	}
+	err = UpdateRootWatches(d, func(rw *RootWatch) error {
+		rw.Project += "-renamed"
+		rw.ID = 0 // can't be changed
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not update watches: %v", err)
+	}
+
+	// IDs are kept by updates, and not reused after a watch is removed
+	listed, err := ListRootWatches(d)
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("expected 2 watches, but got %+v (%v)", listed, err)
+	}
+	if a := listed[0]; a.ID != 2 || a.Dir != j(d, "a") || a.Project != "a-renamed" {
+		t.Fatalf("expected %q to have the ID 2, but got %+v", j(d, "a"), a)
+	}
+	if c := listed[1]; c.ID != 3 || c.Dir != j(d, "c") || c.Project != "c-renamed" {
+		t.Fatalf("expected %q to have the ID 3, but got %+v", j(d, "c"), c)
+	}
+	if rw, err := RootWatchByID(d, 3); err != nil || rw.Dir != j(d, "c") {
+		t.Fatalf("expected ID 3 to be %q, but got %+v (%v)", j(d, "c"), rw, err)
+	}
+	if rw, err := RootWatchByID(d, 1); err == nil {
+		t.Fatalf("expected no watch with the ID 1, but got %+v", rw)
+	}
+}
+
+func TestMigrateWatchStore(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	files := map[string]string{
+		"schema-version": "1",
+		watchDoc:         `{"/b": "b", "/a": "a"}`,
+		"watch-options":  `{"/b": {"tags": ["x"]}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(j(d, name), []byte(data), 0644); err != nil {
+			t.Fatalf("could not write %q: %v", name, err)
+		}
+	}
+	for i := 0; i < 2; i++ { // migrating again does nothing
+		if err := MigrateState(d); err != nil {
+			t.Fatalf("could not migrate state: %v", err)
+		}
+		listed, err := ListRootWatches(d)
+		if err != nil || len(listed) != 2 {
+			t.Fatalf("expected 2 watches, but got %+v (%v)", listed, err)
+		}
+		if a := listed[0]; a.ID != 1 || a.Dir != "/a" || a.Project != "a" || !a.isZero() {
+			t.Fatalf("expected /a to be migrated with the ID 1, but got %+v", a)
+		}
+		if b := listed[1]; b.ID != 2 || b.Dir != "/b" || b.Project != "b" ||
+			len(b.Tags) != 1 || b.Tags[0] != "x" {
+			t.Fatalf("expected /b to be migrated with the ID 2, but got %+v", b)
+		}
+	}
+	for _, name := range []string{"watch-options", watchDoc} {
+		if _, err := os.Stat(j(d, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %q to be removed, but got %v", name, err)
+		}
+	}
+}
+
+func TestMigrateToDB(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	start := time.Date(2019, 3, 4, 9, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"schema-version": "2",
+		tickDoc: `{"project_name":"a","project_id":"0","time_entry_id":"0",` +
+			`"tick":"2019-03-04T09:30:00Z"}`,
+		// The journal's last line was cut off by a crash
+		journalLog: `{"project":"a","start":"2019-03-04T09:00:00Z","stop":"2019-03-04T09:30:00Z"}` +
+			"\n{\"project\":",
+		aliveDoc: "2019-03-04T09:30:00Z\n",
+		gapsLog:  `{"start":"2019-03-03T18:00:00Z","end":"2019-03-04T09:00:00Z"}` + "\n{\"st",
+		canaryDoc: `{"time":"2019-03-04T09:10:00Z","root":"/r","project":"a","path":"/r/x"}` +
+			"\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(j(d, name), []byte(data), 0644); err != nil {
+			t.Fatalf("could not write %q: %v", name, err)
+		}
+	}
+	if err := MigrateState(d); err != nil {
+		t.Fatalf("could not migrate state: %v", err)
+	}
+	s, err := Read(d)
+	if err != nil || s.Project() != "a" || !s.LatestTick().Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("expected the tick file to be imported, but got %+v (%v)", s, err)
+	}
+	journal, err := ReadJournal(d, start, start.Add(time.Hour))
+	if err != nil || len(journal) != 1 || journal[0].Project != "a" {
+		t.Fatalf("expected one journal entry, but got %+v (%v)", journal, err)
+	}
+	if last, err := LastAlive(d); err != nil || !last.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("expected the alive file to be imported, but got %v (%v)", last, err)
+	}
+	if gaps, err := ReadGaps(d, start.Add(-24*time.Hour)); err != nil || len(gaps) != 1 {
+		t.Fatalf("expected one gap, but got %+v (%v)", gaps, err)
+	}
+	diffs, err := ReadCanary(d, start, start.Add(time.Hour))
+	if err != nil || len(diffs) != 1 || diffs[0].Path != "/r/x" {
+		t.Fatalf("expected one canary diff, but got %+v (%v)", diffs, err)
+	}
+	if err := ClearCanary(d); err != nil {
+		t.Fatalf("could not clear canary diffs: %v", err)
+	}
+	if diffs, err := ReadCanary(d, start, start.Add(time.Hour)); err != nil || len(diffs) != 0 {
+		t.Fatalf("expected no canary diffs after clearing, but got %+v (%v)", diffs, err)
+	}
+	for _, name := range []string{tickDoc, journalLog, aliveDoc, gapsLog, canaryDoc} {
+		if _, err := os.Stat(j(d, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %q to be removed, but got %v", name, err)
+		}
+	}
+}
+
+func TestInitState(t *testing.T) {
+	t.Parallel()
+	d := j(GetTestDir(t), "new", "state")
+	for i, want := range []bool{true, false} { // initializing again does nothing
+		created, err := InitState(d)
+		if err != nil || created != want {
+			t.Fatalf("InitState #%d: expected created=%t, but got %t (%v)", i, want,
+				created, err)
+		}
+	}
+	if version, err := persist.SchemaVersion(d); err != nil ||
+		version != migrations[len(migrations)-1].Version {
+		t.Fatalf("expected the latest schema version, but got %d (%v)", version, err)
+	}
+	if listed, err := ListRootWatches(d); err != nil || len(listed) != 0 {
+		t.Fatalf("expected no watches, but got %+v (%v)", listed, err)
+	}
+}
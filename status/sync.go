@@ -0,0 +1,244 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// DiscrepancyKind is the kind of a Discrepancy
+type DiscrepancyKind int
+
+const (
+	// MissingRemote means a time entry is in the journal but not in Toggl
+	// (e.g. because it was recorded locally, or deleted in Toggl)
+	MissingRemote DiscrepancyKind = iota
+	// MissingLocal means a time entry is in Toggl but not in the journal (e.g.
+	// because it was added in the Toggl web UI)
+	MissingLocal
+	// Changed means a time entry is in both, but its project, description, or
+	// times differ (e.g. because it was edited in the Toggl web UI)
+	Changed
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case MissingRemote:
+		return "missing in toggl"
+	case MissingLocal:
+		return "missing locally"
+	case Changed:
+		return "changed"
+	}
+	return fmt.Sprintf("DiscrepancyKind(%d)", int(k))
+}
+
+// Discrepancy is a difference between the journal and Toggl (see Reconcile)
+type Discrepancy struct {
+	Kind DiscrepancyKind
+	// Local is the entry in the journal (unset for MissingLocal), and Remote
+	// is the entry in Toggl (unset for MissingRemote)
+	Local, Remote StoppedEntry
+
+	// index is the position of Local in the journal file
+	index int
+}
+
+// Reconcile compares the time entries in the journal in 'tgStateDir' with the
+// stopped time entries in Toggl that started in [from, to), and returns their
+// differences, ordered by start time. Entries are matched by their Toggl ID
+// or, for journal entries with none, by their project and a start within
+// 'tolerance', and times that differ by no more than 'tolerance' are treated
+// as the same. 'client' is the client of the profile 'profile' (or "" for the
+// default account), and journal entries recorded in other profiles or in other
+// trackers (see SetTracker) are ignored
+func Reconcile(ctx context.Context, tgStateDir string, client *togglclient.Client, profile string,
+	from, to time.Time, tolerance time.Duration) ([]Discrepancy, error) {
+	journal, err := readJournalFile(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := remoteEntries(ctx, client, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]int) // Toggl ID -> index in 'remote'
+	for i, r := range remote {
+		remote[i].Profile = profile
+		byID[r.TimeEntryID] = i
+	}
+	matched := make(map[int]bool) // indices in 'remote' with a journal entry
+	var result []Discrepancy
+	for i, l := range journal {
+		if l.TrackerEntryID != "" || l.Profile != profile || l.Start.Before(from) ||
+			!l.Start.Before(to) {
+			continue
+		}
+		j, ok := byID[l.TimeEntryID]
+		if l.TimeEntryID == 0 {
+			j, ok = matchRemote(l, remote, matched, tolerance)
+		}
+		if !ok || matched[j] {
+			result = append(result, Discrepancy{Kind: MissingRemote, Local: l, index: i})
+			continue
+		}
+		matched[j] = true
+		if differs(l, remote[j], tolerance) {
+			result = append(result, Discrepancy{Kind: Changed, Local: l, Remote: remote[j],
+				index: i})
+		}
+	}
+	for j, r := range remote {
+		if !matched[j] {
+			result = append(result, Discrepancy{Kind: MissingLocal, Remote: r, index: -1})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].start().Before(result[j].start())
+	})
+	return result, nil
+}
+
+// start returns the start of the entry described by 'd'
+func (d Discrepancy) start() time.Time {
+	if d.Kind == MissingLocal {
+		return d.Remote.Start
+	}
+	return d.Local.Start
+}
+
+// matchRemote returns the index of the first entry in 'remote' that isn't
+// 'matched' and has the same project as 'l' and a start within 'tolerance'
+func matchRemote(l StoppedEntry, remote []StoppedEntry, matched map[int]bool,
+	tolerance time.Duration) (int, bool) {
+	for j, r := range remote {
+		if !matched[j] && strings.EqualFold(r.Project, l.Project) &&
+			within(r.Start, l.Start, tolerance) {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// within returns true if 'a' and 'b' differ by no more than 'tolerance'
+func within(a, b time.Time, tolerance time.Duration) bool {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d <= tolerance
+}
+
+// differs returns true if the journal entry 'l' doesn't match its Toggl entry
+// 'r'
+func differs(l, r StoppedEntry, tolerance time.Duration) bool {
+	return l.TimeEntryID != r.TimeEntryID || !strings.EqualFold(l.Project, r.Project) ||
+		l.Description != r.Description || !within(l.Start, r.Start, tolerance) ||
+		!within(l.Stop, r.Stop, tolerance)
+}
+
+// remoteEntries returns the stopped time entries in Toggl that started in
+// [from, to), as journal entries
+func remoteEntries(ctx context.Context, client *togglclient.Client, from, to time.Time) ([]StoppedEntry, error) {
+	entries, err := client.ListTimeEntries(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not list Toggl time entries: %v", err)
+	}
+	names := make(map[int64]string) // project ID -> name
+	listed := make(map[int64]bool)  // workspace ID -> projects fetched
+	var result []StoppedEntry
+	for _, e := range entries {
+		if e.Stop == nil || e.Running() {
+			continue // the open entry is journaled once it stops
+		}
+		if e.ProjectID != 0 && !listed[e.WorkspaceID] {
+			projects, err := client.ListProjects(ctx, e.WorkspaceID)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range projects {
+				names[p.ID] = p.Name
+			}
+			listed[e.WorkspaceID] = true
+		}
+		result = append(result, StoppedEntry{
+			TimeEntryID: e.ID,
+			Start:       e.Start,
+			Stop:        *e.Stop,
+			Project:     names[e.ProjectID],
+			Description: e.Description,
+			Tags:        e.Tags,
+			Billable:    e.Billable,
+		})
+	}
+	return result, nil
+}
+
+// Push creates the entries of the MissingRemote discrepancies in 'ds' in Toggl,
+// using 'client' (or, for entries in a profile, its client in 'profiles'), and
+// records their new IDs in the journal in 'tgStateDir'. It returns the number
+// of entries created, which is less than the number of such discrepancies only
+// if there's an error
+func Push(ctx context.Context, tgStateDir string, client *togglclient.Client,
+	profiles map[string]*togglclient.Client, ds []Discrepancy) (int, error) {
+	s := newCreator(ctx, tgStateDir, client, profiles)
+	ids := make(map[int]int64) // journal index -> new Toggl ID
+	var err error
+	for _, d := range ds {
+		if d.Kind != MissingRemote {
+			continue
+		}
+		var created *togglclient.TimeEntry
+		created, err = s.createStopped(d.Local.Project, d.Local.Start, d.Local.Stop,
+			d.Local.options())
+		if err != nil {
+			err = fmt.Errorf("could not push entry for %q at %s: %v", d.Local.Project,
+				d.Local.Start.Format(time.RFC3339), err)
+			break
+		}
+		ids[d.index] = created.ID
+	}
+	if len(ids) == 0 {
+		return 0, err
+	}
+	// Record the entries that were created, even after an error, so that
+	// they're not pushed again
+	if updateErr := updateJournal(tgStateDir, func(journal []StoppedEntry) []StoppedEntry {
+		for i, id := range ids {
+			journal[i].TimeEntryID = id
+		}
+		return journal
+	}); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	return len(ids), err
+}
+
+// Pull updates the journal in 'tgStateDir' to match Toggl, per the Changed and
+// MissingLocal discrepancies in 'ds'. It returns the number of journal entries
+// updated or added
+func Pull(tgStateDir string, ds []Discrepancy) (int, error) {
+	n := 0
+	err := updateJournal(tgStateDir, func(journal []StoppedEntry) []StoppedEntry {
+		for _, d := range ds {
+			switch d.Kind {
+			case Changed:
+				journal[d.index] = d.Remote
+			case MissingLocal:
+				journal = append(journal, d.Remote)
+			default:
+				continue
+			}
+			n++
+		}
+		sort.SliceStable(journal, func(i, j int) bool {
+			return journal[i].Start.Before(journal[j].Start)
+		})
+		return journal
+	})
+	return n, err
+}
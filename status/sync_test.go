@@ -0,0 +1,73 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	c := server.Client()
+	wid, err := c.Workspace(context.Background())
+	if err != nil {
+		t.Fatalf("could not get workspace: %v", err)
+	}
+	p, err := c.ResolveProject(context.Background(), wid, 0, "a")
+	if err != nil {
+		t.Fatalf("could not create project: %v", err)
+	}
+	start := time.Now().Add(-5 * time.Hour).Truncate(time.Second)
+	createRemote := func(offset time.Duration, desc string) int64 {
+		stop := start.Add(offset + 30*time.Minute)
+		e, err := c.CreateTimeEntry(context.Background(), togglclient.TimeEntry{ProjectID: p.ID,
+			Description: desc, Start: start.Add(offset), Stop: &stop})
+		if err != nil {
+			t.Fatalf("could not create time entry: %v", err)
+		}
+		return e.ID
+	}
+	edited := createRemote(0, "edited in toggl")
+	createRemote(2*time.Hour, "added in toggl")
+	for _, e := range []StoppedEntry{
+		{TimeEntryID: edited, Start: start, Stop: start.Add(30 * time.Minute),
+			Project: "a", Description: "original"},
+		{Start: start.Add(time.Hour), Stop: start.Add(90 * time.Minute), Project: "b"},
+	} {
+		if err := AppendJournal(d, e); err != nil {
+			t.Fatalf("could not append to journal: %v", err)
+		}
+	}
+
+	from, to := start.Add(-time.Hour), time.Now()
+	ds, err := Reconcile(context.Background(), d, c, "", from, to, time.Minute)
+	if err != nil {
+		t.Fatalf("could not reconcile: %v", err)
+	}
+	if len(ds) != 3 || ds[0].Kind != Changed || ds[1].Kind != MissingRemote ||
+		ds[2].Kind != MissingLocal || ds[2].Remote.Project != "a" {
+		t.Fatalf("unexpected discrepancies: %+v", ds)
+	}
+
+	// Pushing creates the local entry in Toggl, and pulling updates the journal
+	if n, err := Push(context.Background(), d, c, nil, ds); err != nil || n != 1 {
+		t.Fatalf("expected to push 1 entry, but pushed %d (%v)", n, err)
+	}
+	if n, err := Pull(d, ds); err != nil || n != 2 {
+		t.Fatalf("expected to pull 2 entries, but pulled %d (%v)", n, err)
+	}
+	if ds, err = Reconcile(context.Background(), d, c, "", from, to, time.Minute); err != nil || len(ds) != 0 {
+		t.Fatalf("expected no discrepancies after syncing, but got %+v (%v)", ds, err)
+	}
+	journal, err := ReadJournal(d, from, to)
+	if err != nil || len(journal) != 3 || journal[0].Description != "edited in toggl" ||
+		journal[1].TimeEntryID == 0 || journal[2].Description != "added in toggl" {
+		t.Fatalf("unexpected journal after syncing: %+v (%v)", journal, err)
+	}
+}
@@ -0,0 +1,132 @@
+package status
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActivityThreshold is the activity in a project that's required before a
+// time entry is started for it, so that a stray write (e.g. a single save in
+// a repository that isn't otherwise being worked on) doesn't start a
+// seconds-long entry. Either criterion that's set suffices. Once the
+// threshold is met, the entry is backdated to the start of the activity that
+// met it
+type ActivityThreshold struct {
+	// Ticks, if positive, is the number of ticks (writes consolidated by the
+	// debounce window) that must occur within Window
+	Ticks  int
+	Window time.Duration
+
+	// Sustained, if positive, is how long activity must continue, with no
+	// idle period, before an entry is started
+	Sustained time.Duration
+}
+
+// ParseActivityThreshold parses an ActivityThreshold from 's', which is
+// either "<ticks>/<window>" (e.g. "3/5m": three ticks within five minutes) or
+// a duration of sustained activity (e.g. "2m"). "" and "off" mean no threshold
+func ParseActivityThreshold(s string) (ActivityThreshold, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return ActivityThreshold{}, nil
+	}
+	if i := strings.Index(s, "/"); i >= 0 {
+		ticks, err := strconv.Atoi(s[:i])
+		window, werr := time.ParseDuration(s[i+1:])
+		if err != nil || werr != nil || ticks < 1 || window <= 0 {
+			return ActivityThreshold{}, fmt.Errorf("invalid activity threshold %q "+
+				"(expected <ticks>/<window>, e.g. \"3/5m\")", s)
+		}
+		return ActivityThreshold{Ticks: ticks, Window: window}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return ActivityThreshold{}, fmt.Errorf("invalid activity threshold %q "+
+			"(expected <ticks>/<window>, e.g. \"3/5m\", or a duration of sustained "+
+			"activity, e.g. \"2m\")", s)
+	}
+	return ActivityThreshold{Sustained: d}, nil
+}
+
+// String returns 't' in the format accepted by ParseActivityThreshold
+func (t ActivityThreshold) String() string {
+	switch {
+	case t.Ticks > 0:
+		return fmt.Sprintf("%d/%s", t.Ticks, shortDuration(t.Window))
+	case t.Sustained > 0:
+		return shortDuration(t.Sustained)
+	}
+	return "off"
+}
+
+// shortDuration formats 'd' without trailing zero units (e.g. "5m" rather
+// than "5m0s")
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// IsZero returns true if 't' requires no activity before an entry is started
+func (t ActivityThreshold) IsZero() bool {
+	return t.Ticks <= 0 && t.Sustained <= 0
+}
+
+// warmup holds the ticks in a project for which no time entry has been
+// started yet, because they haven't met its ActivityThreshold
+type warmup struct {
+	project string
+	// since is when the project's current stretch of activity began
+	since time.Time
+	// ticks are the times of the ticks within the threshold's window
+	ticks []time.Time
+}
+
+// warmUp records a tick in 'project' at 'now' and returns true if the
+// project's activity has met 't', along with the time at which the activity
+// that met it began. Activity in another project, or an idle period, starts
+// the count over
+func (s *Status) warmUp(project string, now time.Time, t ActivityThreshold) (time.Time, bool) {
+	w := &s.warmup
+	if t.IsZero() {
+		*w = warmup{}
+		return now, true
+	}
+	if w.project != project || now.Sub(w.latest()) > s.idleTimeout {
+		*w = warmup{project: project, since: now}
+	}
+	w.ticks = append(w.ticks, now)
+	if t.Ticks > 0 {
+		for len(w.ticks) > 0 && now.Sub(w.ticks[0]) > t.Window {
+			w.ticks = w.ticks[1:]
+		}
+		if len(w.ticks) >= t.Ticks {
+			begin := w.ticks[0]
+			*w = warmup{}
+			return begin, true
+		}
+	}
+	if t.Sustained > 0 && now.Sub(w.since) >= t.Sustained {
+		begin := w.since
+		*w = warmup{}
+		return begin, true
+	}
+	statusLog.Debugf("not starting a time entry for %q until its activity "+
+		"reaches %s", project, t)
+	return time.Time{}, false
+}
+
+// latest returns the time of the latest tick in 'w' (or the zero time)
+func (w *warmup) latest() time.Time {
+	if len(w.ticks) == 0 {
+		return time.Time{}
+	}
+	return w.ticks[len(w.ticks)-1]
+}
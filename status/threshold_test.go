@@ -0,0 +1,90 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/toggltest"
+)
+
+func TestParseActivityThreshold(t *testing.T) {
+	for s, expected := range map[string]ActivityThreshold{
+		"":     {},
+		"off":  {},
+		"3/5m": {Ticks: 3, Window: 5 * time.Minute},
+		"90s":  {Sustained: 90 * time.Second},
+	} {
+		if got, err := ParseActivityThreshold(s); err != nil || got != expected {
+			t.Fatalf("expected %q to parse as %+v, but got %+v (%v)", s, expected, got, err)
+		}
+	}
+	for _, s := range []string{"3/", "0/5m", "x/5m", "-1m", "soon"} {
+		if _, err := ParseActivityThreshold(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+	if s := (ActivityThreshold{Ticks: 3, Window: time.Hour}).String(); s != "3/1h" {
+		t.Fatalf("expected \"3/1h\", but got %q", s)
+	}
+}
+
+func TestMinActivity(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	server := toggltest.NewServer()
+	defer server.Close()
+	s := New(d)
+	s.SetClient(server.Client())
+	opts := EntryOptions{MinActivity: ActivityThreshold{Ticks: 3, Window: time.Minute}}
+
+	// Ticks short of the threshold don't start an entry
+	for i := 0; i < 2; i++ {
+		if err := s.TickWith("a", opts); err != nil {
+			t.Fatalf("could not tick: %v", err)
+		}
+	}
+	if entries := server.TimeEntries(); len(entries) != 0 {
+		t.Fatalf("expected no entries before the threshold is met, but got %+v", entries)
+	}
+
+	// Once it's met, the entry starts at the first of the ticks that met it
+	first := s.warmup.ticks[0]
+	if err := s.TickWith("a", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries := server.TimeEntries()
+	if len(entries) != 1 || !entries[0].Running() || !entries[0].Start.Equal(first) {
+		t.Fatalf("expected one running entry starting at %s, but got %+v", first, entries)
+	}
+
+	// A stray tick in another project doesn't switch the open entry, and ticks
+	// outside the window don't count toward the threshold
+	if err := s.TickWith("b", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	s.warmup.ticks[0] = s.warmup.ticks[0].Add(-2 * time.Minute)
+	if err := s.TickWith("b", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if entries := server.TimeEntries(); len(entries) != 1 || !entries[0].Running() ||
+		s.Project() != "a" {
+		t.Fatalf("expected the entry in \"a\" to stay open, but got %+v", entries)
+	}
+
+	// Sustained activity in the other project switches to it, with the open
+	// entry stopped where that activity began
+	opts = EntryOptions{MinActivity: ActivityThreshold{Sustained: time.Minute}}
+	if err := s.TickWith("b", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	s.warmup.since = s.warmup.since.Add(-time.Minute)
+	began := s.warmup.since
+	if err := s.TickWith("b", opts); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	entries = server.TimeEntries()
+	if len(entries) != 2 || entries[0].Stop == nil || !entries[0].Stop.Equal(began) ||
+		!entries[1].Running() || !entries[1].Start.Equal(began) {
+		t.Fatalf("expected a switch to \"b\" at %s, but got %+v", began, entries)
+	}
+}
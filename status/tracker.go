@@ -0,0 +1,97 @@
+package status
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/tracker"
+)
+
+// TrackerEntryID is the TimeEntryID of an open time entry that's recorded in a
+// tracker other than Toggl (see SetTracker). Its ID in the tracker is
+// persisted separately
+const TrackerEntryID = -2
+
+// SetTracker sets a time tracker other than Toggl (e.g. Clockify) in which 's'
+// records time entries instead of sending them to Toggl through its client.
+// Trackers can't move open entries between projects, so work on a different
+// project always stops the open entry and starts a new one
+func (s *Status) SetTracker(t tracker.TimeTracker) {
+	s.tracker = t
+}
+
+// Tracker returns the tracker set by SetTracker, or nil if time entries are
+// sent to Toggl
+func (s *Status) Tracker() tracker.TimeTracker {
+	return s.tracker
+}
+
+// TrackerEntryID returns the ID of the open time entry in the tracker set by
+// SetTracker, or "" if there is none
+func (s *Status) TrackerEntryID() string {
+	return s.trackerEntryID
+}
+
+// canReassign returns true if the open time entry can be moved to another
+// project, rather than stopped, when work switches (see SetMinSwitchDuration)
+func (s *Status) canReassign() bool {
+	return s.timeEntryID != TrackerEntryID
+}
+
+// startTracked starts a time entry for s.projectName in s.tracker, starting at
+// s.entryStart
+func (s *Status) startTracked(opts EntryOptions) error {
+	projectID, err := s.tracker.EnsureProject(s.ctx, s.projectName)
+	if err != nil {
+		return fmt.Errorf("could not resolve %s project %q: %v", s.tracker.Name(),
+			s.projectName, err)
+	}
+	billable := s.entryBillable(opts)
+	id, err := s.tracker.StartEntry(s.ctx, tracker.Entry{
+		ProjectID:   projectID,
+		Description: opts.Description,
+		Tags:        opts.Tags,
+		Billable:    billable,
+		Start:       s.entryStart,
+	})
+	if err != nil {
+		return fmt.Errorf("could not start %s time entry: %v", s.tracker.Name(), err)
+	}
+	s.timeEntryID = TrackerEntryID
+	s.trackerEntryID = id
+	s.description = opts.Description
+	s.tags = opts.Tags
+	s.billable = billable
+	statusLog.Infof("started %s time entry %s for %q", s.tracker.Name(), id, s.projectName)
+	return nil
+}
+
+// stopTracked stops the open time entry in s.tracker at 't', and records it in
+// the journal. If the tracker can't be reached, the entry stays open, and
+// stopping it is retried by the next stop (e.g. the daemon's idle checks)
+func (s *Status) stopTracked(t time.Time) error {
+	if s.tracker == nil {
+		return fmt.Errorf("cannot stop time entry %s: no tracker", s.trackerEntryID)
+	}
+	if err := s.tracker.StopEntry(s.ctx, s.trackerEntryID, s.entryStart, t); err != nil {
+		return fmt.Errorf("could not stop %s time entry %s: %v", s.tracker.Name(),
+			s.trackerEntryID, err)
+	}
+	stopped := StoppedEntry{
+		TrackerEntryID: s.trackerEntryID,
+		Start:          s.entryStart,
+		Stop:           t,
+		Project:        s.projectName,
+		Description:    s.description,
+		Tags:           s.tags,
+		Billable:       s.billable,
+	}
+	if err := AppendJournal(s.tgStateDir, stopped); err != nil {
+		return err
+	}
+	statusLog.Infof("stopped %s time entry %s", s.tracker.Name(), s.trackerEntryID)
+	if s.onStop != nil {
+		s.onStop(stopped)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/tracker"
+)
+
+// fakeTracker is a tracker.TimeTracker that keeps time entries in memory
+type fakeTracker struct {
+	entries []tracker.Entry
+	// down, if true, makes every request fail
+	down bool
+}
+
+func (f *fakeTracker) Name() string { return "fake" }
+
+func (f *fakeTracker) EnsureProject(_ context.Context, name string) (string, error) {
+	if f.down {
+		return "", errors.New("tracker is down")
+	}
+	return "id-" + name, nil
+}
+
+func (f *fakeTracker) StartEntry(_ context.Context, e tracker.Entry) (string, error) {
+	if f.down {
+		return "", errors.New("tracker is down")
+	}
+	e.ID = fmt.Sprint(len(f.entries) + 1)
+	f.entries = append(f.entries, e)
+	return e.ID, nil
+}
+
+func (f *fakeTracker) StopEntry(_ context.Context, id string, start, stop time.Time) error {
+	if f.down {
+		return errors.New("tracker is down")
+	}
+	for i := range f.entries {
+		if f.entries[i].ID == id {
+			f.entries[i].Stop = &stop
+			return nil
+		}
+	}
+	return fmt.Errorf("no entry %s", id)
+}
+
+func (f *fakeTracker) ListEntries(_ context.Context, from, to time.Time) ([]tracker.Entry, error) {
+	return f.entries, nil
+}
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	tr := &fakeTracker{}
+	s := New(d) // no client: entries are recorded in the tracker
+	s.SetTracker(tr)
+	s.SetMinSwitchDuration(time.Hour)
+
+	if err := s.TickWith("a", EntryOptions{Tags: []string{"x"}}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s.TimeEntryID() != TrackerEntryID || len(tr.entries) != 1 ||
+		tr.entries[0].ProjectID != "id-a" || len(tr.entries[0].Tags) != 1 {
+		t.Fatalf("expected one entry in the tracker, but have %d and %+v",
+			s.TimeEntryID(), tr.entries)
+	}
+
+	// The open entry survives a restart
+	s, err := Read(d)
+	if err != nil || s.TrackerEntryID() != "1" {
+		t.Fatalf("expected to read tracker entry 1, but got %v (%v)", s, err)
+	}
+	s.SetTracker(tr)
+	s.SetMinSwitchDuration(time.Hour)
+
+	// Trackers can't reassign entries, so a switch always starts a new entry
+	if err := s.Tick("b"); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if len(tr.entries) != 2 || tr.entries[0].Stop == nil || tr.entries[1].Stop != nil {
+		t.Fatalf("expected a stopped and a running entry, but got %+v", tr.entries)
+	}
+	journal, err := ReadJournal(d, time.Now().Add(-time.Hour), time.Now())
+	if err != nil || len(journal) != 1 || journal[0].TrackerEntryID != "1" ||
+		journal[0].Project != "a" {
+		t.Fatalf("expected entry 1 in the journal, but got %+v (%v)", journal, err)
+	}
+
+	// If the tracker is down, the entry stays open until it can be stopped
+	tr.down = true
+	if err := s.Stop(time.Now()); err == nil {
+		t.Fatalf("expected an error stopping an entry while the tracker is down")
+	}
+	if s.TimeEntryID() != TrackerEntryID {
+		t.Fatalf("expected the entry to stay open, but have %d", s.TimeEntryID())
+	}
+	tr.down = false
+	if err := s.Stop(time.Now()); err != nil || s.TimeEntryID() != 0 ||
+		tr.entries[1].Stop == nil {
+		t.Fatalf("expected the entry to be stopped, but have %d and %+v (%v)",
+			s.TimeEntryID(), tr.entries, err)
+	}
+}
@@ -2,27 +2,56 @@ package status
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	p "path"
 	fp "path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-	"unsafe"
 
-	"golang.org/x/sys/unix"
+	"github.com/msteffen/toggl-watcher/logging"
+	"github.com/msteffen/toggl-watcher/metrics"
+	"github.com/msteffen/toggl-watcher/watcher"
 )
 
 const (
-	stateFileName = "watch"
+	// lockFileName is the file in tgStateDir that a Watch locks, so that only
+	// one process watches the directories in the watch state at a time (the
+	// state database is only locked while it's read or written)
+	lockFileName = "watch.lock"
 
-	// The duration over which work events are consolidated (all events that
-	// happen within a 'eventBucketSize'-length period of time are registered as a
-	// single event)
-	eventBucketSize = 3 * time.Second
+	// The default duration over which work events are consolidated (all events
+	// that happen within a 'bucketSize'-length period of time are registered as
+	// a single event). See SetBucketSize
+	defaultEventBucketSize = 3 * time.Second
+
+	// minRestartBackoff and maxRestartBackoff bound how long superviseEvents
+	// waits before replacing a failed inotify watcher (or fanotify fd)
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 5 * time.Minute
 )
 
+// overflowError is returned by readWatcher if the kernel's inotify queue
+// overflowed, in which case events after 'since' (possibly including the
+// creation of directories that must be watched) may have been lost
+type overflowError struct {
+	since time.Time
+}
+
+func (e overflowError) Error() string {
+	return "inotify event queue overflowed"
+}
+
+// errClosed is returned by readWatcher once the Watch has been closed
+var errClosed = errors.New("watch was closed")
+
+// watchLog logs the progress of every Watch
+var watchLog = logging.With("component", "watch")
+
 // Watch is an object that watches directories for changes that happen below
 // them, by watching all subdirectories, and adding new watches when new child
 // directories are created
@@ -30,27 +59,148 @@ type Watch struct {
 	// The directory where tg is storing its state
 	tgStateDir string
 
-	// stateFile is an open file descriptor for the file in tgStateDir where this
-	// Watch stores and retrieves its state
-	stateFile *os.File
+	// lockFile is an open file descriptor for the file in tgStateDir that this
+	// Watch holds locked while it's running
+	lockFile *os.File
+
+	// backend is the kernel API used to observe writes. It's fixed when the
+	// Watch is started
+	backend Backend
+
+	// iw watches the directories under every root watch, if backend is
+	// BackendInotify, and fanotifyFd is the unix file descriptor from which
+	// fanotify events are read, if it's BackendFanotify. They're replaced if
+	// reading from them fails (see superviseEvents), at which point
+	// 'generation' is incremented. All three are guarded by 'mu'
+	iw         *watcher.Watcher
+	fanotifyFd int
+	generation int
 
-	// inotifyFd is the unix file descriptor where inotify events corresponding
-	// to writes in the watched directories can be read
-	inotifyFd int
+	// closed is set by Close, after which the goroutine reading events exits.
+	// Guarded by 'mu'. done is closed along with it, to stop repairLoop
+	closed bool
+	done   chan struct{}
+
+	// lastRepair is when w's watches were last repaired (see Repair), and
+	// repairInterval is how often that happens. lastRepair is guarded by 'mu'
+	lastRepair     time.Time
+	repairInterval time.Duration
+
+	// rescans maps subtrees whose writes are found by scanning rather than
+	// via inotify to their state (see rescan), and overLimit is true while
+	// the inotify watch limit prevents any directory from being watched. Both
+	// are guarded by 'mu'. rescanNow wakes the goroutine that scans them
+	rescans        map[string]rescan
+	overLimit      bool
+	rescanInterval time.Duration
+	rescanNow      chan struct{}
+
+	// inotifyAddWatch, if set, replaces unix.InotifyAddWatch (see
+	// addDirWatch), e.g. in tests that exhaust the watch limit. Guarded by 'mu'
+	inotifyAddWatch func(fd int, path string, mask uint32) (int, error)
+
+	// mu guards 'rootWatches', which is modified both by the goroutine
+	// reading events and by callers of AddWatch/RemoveWatch. It's also held
+	// while w.iw watches new directories (see watcher.Options.Locker)
+	mu sync.Mutex
 
 	// watches map paths to Toggl projects. When a write occurs under any key
 	// a time entry will be created/extended in the corresponding project
 	rootWatches map[string]string
 
-	// wdToPath maps watch descriptors to directories being watched, so that
-	// watch events can be matched to a directory
-	wdToPath map[int]string
+	// store is the contents of the watch state, as last read or written by
+	// w (see saveState). Guarded by 'mu'
+	store *watchStore
+
+	// broken maps root watches whose directories were moved to an unknown path
+	// (or were missing when w started) to their projects. They're kept in the
+	// watch state, but aren't watched until they're added again (see Broken).
+	// Guarded by 'mu'
+	broken map[string]string
+
+	// queue processes inotify events in order per root watch (see
+	// shardedQueue)
+	queue *shardedQueue
+
+	// ignorePatterns are patterns matching paths in which writes are ignored
+	// (see SetIgnorePatterns). Guarded by 'mu'
+	ignorePatterns []string
+
+	// defaultEvents are the events that count as writes under root watches
+	// that don't set their own (see SetDefaultEvents). Guarded by 'mu'
+	defaultEvents watcher.EventMask
 
-	// callbackMu protects 'callback'
+	// rootOptions maps root watches to their options, if any (see
+	// RootOptions). Guarded by 'mu'
+	rootOptions map[string]RootOptions
+
+	// ignoreRules maps root watches to the rules in the .gitignore and
+	// .tgignore files under them. Guarded by 'mu'
+	ignoreRules map[string]ignoreRules
+
+	// canaryPatterns and canary, if set, are a candidate set of ignore
+	// patterns and the function called with each write on which they disagree
+	// with 'ignorePatterns' (see SetCanary). Guarded by 'mu'
+	canaryPatterns []string
+	canary         func(CanaryDiff)
+
+	// lostRoot, if set, is called with each root watch whose directory is
+	// deleted or moved away (see SetLostRootCallback). Guarded by 'mu'
+	lostRoot func(LostRoot)
+
+	// lastEvent maps root watches to the time of the latest write observed
+	// under them (see Health). Guarded by 'mu'
+	lastEvent map[string]time.Time
+
+	// callbackMu protects 'callback' and 'bucketSize'
 	callbackMu sync.Mutex
 
-	// callback is called whenever a file event is observed
-	callback func()
+	// callback is called with the writes observed in each project, once per
+	// event bucket
+	callback func(WriteEvent)
+
+	// bucketSize is the length of each event bucket
+	bucketSize time.Duration
+}
+
+// WriteEvent describes the writes observed under a single project during one
+// event bucket (see SetBucketSize)
+type WriteEvent struct {
+	// Root is the root watch directory under which the most recent write
+	// occurred
+	Root string
+	// Project is the Toggl project associated with 'Root'
+	Project string
+	// Count is the number of inotify events observed under the project's root
+	// directories during the bucket
+	Count int
+	// Files is the number of distinct paths written during the bucket
+	Files int
+	// TopFiles are the paths (relative to the root watch under which they
+	// were written) written most often during the bucket, most first, up to
+	// MaxTopFiles
+	TopFiles []string
+	// Path is the most recently written path in the bucket (e.g. to find the
+	// git repository in which the writes happened)
+	Path string
+	// Start and End are the times of the first and last writes in the bucket
+	Start, End time.Time
+}
+
+// LostRoot describes a root watch that stopped being watched because its
+// directory was deleted or moved away (see SetLostRootCallback)
+type LostRoot struct {
+	Dir, Project string
+	// Moved is true if the directory was moved to an unknown path, rather than
+	// deleted, in which case the root watch is broken (see Broken)
+	Moved bool
+}
+
+// write is a single write observed under a root watch, passed from
+// readWatcher to handleEvents
+type write struct {
+	root, path string
+	time       time.Time
 }
 
 // MarshalJSON satisfies the json.Marshaller interface
@@ -63,158 +213,643 @@ func (w *Watch) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &w.rootWatches)
 }
 
-// lock acquires an advisory lock on the file opened at fd. For more on
-// advisory locking, see https://gavv.github.io/blog/file-locks/
-func lock(fd int) error {
-	for {
-		// exclusive lock, nonblocking call (fail fast)
-		err := unix.Flock(fd, unix.LOCK_NB|unix.LOCK_EX)
-		if err == nil {
-			return nil
-		}
-		switch err {
-		case unix.EINTR:
-			continue // interrupted--retry syscall
-		case unix.EWOULDBLOCK:
-			return fmt.Errorf("another watch process is already running")
-		default:
-			return fmt.Errorf("error locking watch file: %v", err)
+// addWatch adds inotify watches for 'path' and every directory under it,
+// except for ignored directories (see skipWatch). w.mu must be held by the
+// caller
+func (w *Watch) addWatch(path string) error {
+	if w.closed {
+		return errClosed // w.iw may already be closed
+	}
+	root := w.rootFor(path)
+	if w.polled(root) {
+		// Scan 'path' for writes from now on, rather than walking it (see
+		// rescanLoop, which calls addWatch again after each scan)
+		w.addRescan(root, path, time.Now(), false)
+		r := w.rescans[path]
+		r.interval = w.rootOptions[root].pollInterval()
+		w.rescans[path] = r
+		return nil
+	}
+	if w.backend == BackendFanotify {
+		// Files' paths are reported with symlinks resolved, so they won't match
+		// a root watch that's reached through a symlink
+		if resolved, err := fp.EvalSymlinks(path); err == nil && resolved != path {
+			watchLog.Warnf("%q is a symlink (to %q), so writes in it aren't seen "+
+				"by the fanotify backend; watch %q instead", path, resolved, resolved)
 		}
+		return fanotifyMark(w.fanotifyFd, path) // no need to walk 'path'
 	}
+	return w.iw.Add(path)
 }
 
-func (w *Watch) addWatch(path string) error {
-	// Walk the directory tree under 'path'
-	err := fp.Walk(path, func(path string, info os.FileInfo, err error) error {
-		fmt.Printf("might watch %q\n", path)
-		// Only watch directories
-		if !info.IsDir() {
-			fmt.Printf("%q is not a dir\n", path)
-			return nil
+// skipWatch returns true if w.iw shouldn't watch the directory 'path' (see
+// watcher.Options.Skip), either because writes in it are ignored (see
+// skipDir) or because it's scanned instead (see rescan). w.mu must be held by
+// the caller
+func (w *Watch) skipWatch(path string) bool {
+	root := w.rootFor(path)
+	if reason := w.skipDir(root, path); reason != "" {
+		watchLog.Debugf("not watching %q, as it is %s", path, reason)
+		return true
+	}
+	if w.polled(root) {
+		return true // e.g. a nested root that's scanned instead (see polled)
+	}
+	rel, _ := fp.Rel(root, path)
+	if limit := w.rootOptions[root].depthLimit(); root != "" && limit >= 0 &&
+		rel != "." && depth(rel) > limit {
+		watchLog.Debugf("scanning %q instead of watching it, as it is deeper "+
+			"than %d", path, limit)
+		w.addRescan(root, path, time.Now(), false)
+		return true
+	}
+	return false
+}
+
+// addFailed handles a directory that w.iw couldn't watch (see
+// watcher.Options.AddFailed). If the inotify watch limit was reached, the
+// directory is scanned instead (see watchLimitReached). w.mu must be held by
+// the caller
+func (w *Watch) addFailed(path string, err error) error {
+	if root := w.rootFor(path); err == syscall.ENOSPC && root != "" {
+		w.watchLimitReached(root, path)
+		return nil
+	}
+	return err
+}
+
+// watched returns the directories that w.iw is watching. w.mu must be held by
+// the caller
+func (w *Watch) watched() []string {
+	if w.iw == nil {
+		return nil
+	}
+	return w.iw.Watched()
+}
+
+// WatchDescriptors returns the number of inotify watches that 'w' is using
+func (w *Watch) WatchDescriptors() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.watched())
+}
+
+// polled returns true if writes under the root watch 'root' are found by
+// scanning it (see BackendPoll), rather than via w.backend. w.mu must be held
+// by the caller
+func (w *Watch) polled(root string) bool {
+	return w.backend == BackendPoll || (root != "" && w.rootOptions[root].Backend == BackendPoll)
+}
+
+// skipDir returns the reason why the directory 'path' (under the root watch
+// 'root', if it's set) isn't watched, or "" if it should be watched. w.mu must
+// be held by the caller
+func (w *Watch) skipDir(root, path string) string {
+	// Skip directories in which all writes would be ignored anyway
+	if path != root && root != "" && w.ignored(root, path, true) {
+		return "ignored"
+	}
+
+	for _, name := range w.rootOptions[root].skipHeuristics() {
+		if reason := SkipHeuristics[name](path); reason != "" {
+			return reason
 		}
+	}
+	return ""
+}
 
-		// heuristic: skip hidden directories
-		// TODO make this flag-controlled
-		filename := p.Base(path)
-		if strings.HasPrefix(filename, ".") {
-			fmt.Printf("%q is hidden\n", path)
-			return fp.SkipDir
+// Verify checks that the directories watched by 'w' are exactly those that
+// would be watched if every root were added now, and returns an error listing
+// any that are missing or unexpected. Directories created or deleted while it
+// runs may be reported before their events are processed, so callers should
+// re-check before treating a discrepancy as a bug
+func (w *Watch) Verify() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.backend != BackendInotify {
+		return nil // directories aren't watched individually
+	}
+	var problems []string
+	for _, m := range w.compareWatches() {
+		switch {
+		case m.missing:
+			problems = append(problems, fmt.Sprintf("%q should be watched but isn't", m.path))
+		case m.err != nil:
+			problems = append(problems, fmt.Sprintf("%q is watched but can't be "+
+				"read: %v", m.path, m.err))
+		default:
+			problems = append(problems, fmt.Sprintf("%q is watched but shouldn't be", m.path))
 		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("watches don't match the directories on disk: %s",
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// watchMismatch is a directory that w.iw is watching but shouldn't be, or
+// vice versa (see compareWatches)
+type watchMismatch struct {
+	path string
+	// root is the root watch that 'path' is under, if it's missing
+	root string
+	// missing is true if 'path' should be watched but isn't
+	missing bool
+	// err is set if 'path' is watched but can't be read (e.g. it was deleted)
+	err error
+}
 
-		// heuristic: avoid golang vendor directories, since I typically use this
-		// with go projects
-		if filename == "vendor" {
-			if _, err := os.Stat(p.Join(p.Dir(path), "Gopkg.lock")); err == nil {
-				fmt.Printf("%q is a dep vendor dir\n", path)
-				return fp.SkipDir // vendor dir managed by 'dep'
+// compareWatches compares the directories watched by w.iw with those that
+// would be watched if every root were added now. w.mu must be held by the
+// caller
+func (w *Watch) compareWatches() []watchMismatch {
+	// Directories are compared by inode, as a directory reachable under two
+	// roots is only watched under one of them (see addWatch)
+	type wanted struct{ path, root string }
+	want := make(map[inode]wanted)
+	for _, root := range w.sortedRoots() {
+		fp.Walk(root+"/", func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil // e.g. deleted while walking
 			}
-			if _, err := os.Stat(p.Join(path, "vendor.json")); err == nil {
-				fmt.Printf("%q is a govendor dir\n", path)
-				return fp.SkipDir // vendor dir managed by 'govendor'
+			path = fp.Clean(path)
+			if w.skipDir(root, path) != "" || w.rescanned(path) {
+				return fp.SkipDir // rescanned subtrees aren't watched
 			}
-		}
-
-		// Add inotify watch to this child
-		fmt.Printf("adding watch for %q\n", path)
-		wd, err := unix.InotifyAddWatch(w.inotifyFd, path,
-			unix.IN_CREATE|unix.IN_DELETE|unix.IN_MODIFY|
-				unix.IN_MOVED_TO|
-				unix.IN_DELETE_SELF|unix.IN_DELETE_SELF)
+			if _, ok := want[inodeOf(info)]; !ok {
+				want[inodeOf(info)] = wanted{path: path, root: root}
+			}
+			return nil
+		})
+	}
+	var result []watchMismatch
+	have := make(map[inode]bool)
+	for _, path := range w.watched() {
+		info, err := os.Stat(path)
 		if err != nil {
-			return fmt.Errorf("could not add watch: %v", err)
+			result = append(result, watchMismatch{path: path, err: err})
+			continue
 		}
-		w.wdToPath[wd] = path
-		return nil
-	})
-	return err
+		have[inodeOf(info)] = true
+		if _, ok := want[inodeOf(info)]; !ok {
+			result = append(result, watchMismatch{path: path})
+		}
+	}
+	for ino, wd := range want {
+		if !have[ino] {
+			result = append(result, watchMismatch{path: wd.path, root: wd.root,
+				missing: true})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].path < result[j].path })
+	return result
+}
+
+// inode identifies a file by its device and inode number
+type inode struct {
+	dev, ino uint64
 }
 
-// readEvents is a helper function that reads unix inotify events from
-// w.inotifyFd and writes empty structs to eventChan. It also installs new
-// listeners for new child directories that the user creates
-func (w *Watch) readEvents(eventChan chan<- struct{}) {
-	buf := make([]byte, 1024*unix.SizeofInotifyEvent) // huge buffer, to hold all events
+// superviseEvents reads events into 'eventChan' (see readWatcher) until
+// reading fails, and then replaces w.iw (or w.fanotifyFd) and re-establishes
+// every watch (see restart), retrying with exponential backoff. It returns
+// once the Watch is closed
+func (w *Watch) superviseEvents(eventChan chan<- write) {
+	backoff := minRestartBackoff
 	for {
-		n, err := unix.Read(w.inotifyFd, buf)
-		// TODO all of these os.Exit() calls are silly -- try to recover
-		// TODO do I need all of these cases?
-		switch {
-		case n < 0:
-			fmt.Fprintf(os.Stderr, "inotify read error: %v", err)
-		case n == 0:
+		w.mu.Lock()
+		iw, fd := w.iw, w.fanotifyFd
+		w.mu.Unlock()
+		started := time.Now()
+		var err error
+		if w.backend == BackendFanotify {
+			err = w.readFanotify(fd, eventChan)
+		} else {
+			err = w.readWatcher(iw, eventChan)
+		}
+		if err == errClosed {
 			return
-		case n < unix.SizeofInotifyEvent:
-			fmt.Fprintf(os.Stderr, "short read of %d bytes: %v", n, err)
-		case err != nil:
-			fmt.Fprintf(os.Stderr, "inotify read error (n != 0?): %v", err)
-		default:
-			// success
-		}
-		idx := 0
-		for idx < n {
-			fmt.Printf("idx: %d -> ", idx)
-			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[idx]))
-			if idx+unix.SizeofInotifyEvent+int(event.Len) > n {
-				fmt.Fprint(os.Stderr, "short read\n")
+		}
+		overflow, overflowed := err.(overflowError)
+		if overflowed {
+			metrics.Errors.Add("inotify_overflow", 1)
+			watchLog.Errorf("%v (see /proc/sys/fs/inotify/max_queued_events); "+
+				"re-establishing watches, and scanning for writes since %s",
+				err, overflow.since.Format(time.RFC3339))
+		} else {
+			metrics.Errors.Add("inotify", 1)
+			watchLog.Errorf("%v; re-establishing watches", err)
+		}
+		if time.Since(started) > maxRestartBackoff {
+			backoff = minRestartBackoff // the previous watcher was healthy for a while
+		}
+		for {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
 			}
-			idx += unix.SizeofInotifyEvent
-
-			// extract name from stat struct
-			var name string
-			for r := int(event.Len); r > 0; r-- {
-				if buf[idx+r-1] != 0 {
-					name = string(buf[idx : idx+r])
-					break
-				}
+			if err := w.restart(); err == errClosed {
+				return
+			} else if err != nil {
+				watchLog.Errorf("%v; retrying in %s", err, backoff)
+				continue
 			}
-			idx += int(event.Len)
-			fmt.Printf("%d/%d\n", idx, n)
-			path := p.Clean(p.Join(w.wdToPath[int(event.Wd)], name))
-
-			// If event involves creating or moving a subdirectory, add watches for
-			// the new subdirectory
-			fmt.Printf("event: %s\n", Render(event, path))
-			if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) > 0 {
-				fInfo, err := os.Stat(path)
-				if err != nil {
-					// TODO log somewhere real
-					fmt.Fprintf(os.Stderr, "could not stat new path %q: %v", path, err)
-				}
-				if fInfo.IsDir() {
-					w.addWatch(path) // Add inotify watch to this child
+			if overflowed {
+				// Find the writes whose events were lost, now that new writes
+				// are being observed again
+				w.mu.Lock()
+				for root := range w.rootWatches {
+					w.addRescan(root, root, overflow.since, true)
 				}
+				w.mu.Unlock()
+				w.triggerRescan()
 			}
-			if event.Mask&(unix.IN_DELETE) > 0 {
-				for _, p2 := range w.wdToPath {
-					if path == p2 {
-						fmt.Printf("There should be an IN_IGNORE event for %s\n", path)
-					}
-				}
+			break
+		}
+	}
+}
+
+// readWatcher reads events from 'iw', and writes each one that's under a root
+// watch to 'eventChan'. It returns when 'iw' stops or its queue overflows (see
+// superviseEvents), or errClosed once 'w' is closed
+func (w *Watch) readWatcher(iw *watcher.Watcher, eventChan chan<- write) error {
+	defer iw.Close() // so that no events are read after an overflow
+	for e := range iw.Events() {
+		if e.Type == watcher.Overflow {
+			return overflowError{since: e.Time}
+		}
+		metrics.EventsReceived.Add(1)
+		watchLog.Debugf("event: %s", e)
+		w.mu.Lock()
+		if e.Type == watcher.Rename && e.IsDir {
+			w.moveRoots(e.OldPath, e.Path)
+		}
+		root := w.rootFor(e.Path)
+		ignored, diff := w.classify(root, e.Path, e.IsDir)
+		if root != "" && !w.eventMask(root).Has(e.Type) {
+			ignored, diff = true, nil // not a write, per the root's events
+		}
+		canary, lostRoot := w.canary, w.lostRoot
+		var lost []LostRoot
+		if e.Type == watcher.Delete && e.IsDir {
+			lost = w.loseRoots(e.Path, e.Moved)
+		}
+		w.mu.Unlock()
+		if lostRoot != nil {
+			for _, l := range lost {
+				lostRoot(l)
 			}
+		}
+		if root == "" {
+			metrics.EventsFiltered.Add("unwatched", 1)
+			continue
+		}
 
-			// If the event concerns a watch descriptor, update the relevant maps
-			if event.Mask&(unix.IN_MOVE_SELF|unix.IN_DELETE_SELF) > 0 {
-				// unix.InotifyRmWatch(w.inotifyFd, uint32(event.Wd))
-				fmt.Printf("removing %d from %v\n", event.Wd, w.wdToPath)
-				delete(w.wdToPath, int(event.Wd))
-				fmt.Printf("removing %s from %v\n", path, w.rootWatches)
-				delete(w.rootWatches, path)
+		// Process the event asynchronously, but in order with all other events
+		// under the same root
+		path, oldPath, now := e.Path, e.OldPath, e.Time
+		w.queue.Submit(root, func() {
+			if isIgnoreFile(path) || isIgnoreFile(oldPath) {
+				w.mu.Lock()
+				w.loadIgnoreRules(root) // the ignore file changed
+				w.mu.Unlock()
 			}
-			eventChan <- struct{}{} // notify watcher that an event has occurred
+			w.publish(write{root: root, path: path, time: now}, ignored, diff, canary,
+				eventChan)
+		})
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errClosed
+	}
+	if err := iw.Err(); err != nil {
+		return err
+	}
+	return errors.New("inotify watcher was closed")
+}
+
+// moveRoots follows the root watches under 'from', which was moved to 'to'
+// within the watched directories, so that they're persisted (along with their
+// options) and attributed writes under their new paths. w.mu must be held by
+// the caller
+func (w *Watch) moveRoots(from, to string) {
+	var moved []string
+	for _, root := range w.sortedRoots() {
+		if !isUnder(root, from) {
+			continue
+		}
+		newRoot := to + strings.TrimPrefix(root, from)
+		watchLog.Infof("root watch %q was moved to %q", root, newRoot)
+		if rw := w.store.find(root); rw != nil {
+			rw.Dir = newRoot // keep its ID
+		}
+		w.rootWatches[newRoot] = w.rootWatches[root]
+		delete(w.rootWatches, root)
+		if opts, ok := w.rootOptions[root]; ok {
+			w.rootOptions[newRoot] = opts
+			delete(w.rootOptions, root)
+		}
+		delete(w.ignoreRules, root)
+		w.loadIgnoreRules(newRoot)
+		moved = append(moved, newRoot)
+	}
+	if len(moved) == 0 {
+		return
+	}
+	if err := w.saveState(); err != nil {
+		watchLog.Errorf("%v", err)
+	}
+	// w.iw kept watching the moved directories, but add them again so that the
+	// moved roots' options (e.g. depth limits) apply under their new paths
+	for _, root := range moved {
+		if err := w.addWatch(root); err != nil {
+			watchLog.Errorf("could not watch moved root watch %q: %v", root, err)
 		}
 	}
 }
 
-func (w *Watch) handleEvents(eventChan <-chan struct{}) {
+// loseRoots stops watching the root watches under 'dir', which was deleted or
+// (if 'moved' is set) moved away, and returns them. Deleted roots are removed
+// from the watch state. Moved roots are kept in it, but marked broken (see
+// Broken), as their new path is unknown. w.mu must be held by the caller
+func (w *Watch) loseRoots(dir string, moved bool) []LostRoot {
+	var result []LostRoot
+	for _, root := range w.sortedRoots() {
+		if !isUnder(root, dir) {
+			continue
+		}
+		project := w.rootWatches[root]
+		delete(w.rootWatches, root)
+		delete(w.ignoreRules, root)
+		if moved {
+			watchLog.Warnf("root watch %q was moved away, so it's no longer "+
+				"watched; watch its new path with 'tg watch', or remove it with "+
+				"'tg unwatch'", root)
+			w.broken[root] = project
+		} else {
+			watchLog.Infof("root watch %q was deleted, so it's no longer watched", root)
+		}
+		result = append(result, LostRoot{Dir: root, Project: project, Moved: moved})
+	}
+	if len(result) > 0 && !moved {
+		if err := w.saveState(); err != nil {
+			watchLog.Errorf("%v", err)
+		}
+	}
+	return result
+}
+
+// open creates the watcher.Watcher or fanotify fd from which w's events are
+// read, depending on w.backend. BackendPoll doesn't read events, so it
+// returns neither
+func (w *Watch) open() (*watcher.Watcher, int, error) {
+	switch w.backend {
+	case BackendInotify:
+		iw, err := watcher.NewWatcher(watcher.Options{
+			Skip:            w.skipWatch,
+			AddFailed:       w.addFailed,
+			Locker:          &w.mu,
+			Mask:            w.eventMask,
+			InotifyAddWatch: w.addDirWatch,
+		})
+		return iw, -1, err
+	case BackendFanotify:
+		fd, err := fanotifyInit()
+		return nil, fd, err
+	}
+	return nil, -1, nil
+}
+
+// restart replaces w.iw (or w.fanotifyFd) with a new one, and adds watches
+// for every root watch to it
+func (w *Watch) restart() error {
+	iw, fd, err := w.open()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		if iw != nil {
+			iw.Close()
+		} else {
+			closeFanotify(fd)
+			closeFanotify(w.fanotifyFd)
+		}
+		return errClosed
+	}
+	if iw != nil {
+		w.iw.Close() // already stopped, but make sure
+		w.iw = iw
+	} else {
+		closeFanotify(w.fanotifyFd)
+		w.fanotifyFd = fd
+	}
+	w.generation++
+	// Subtrees that couldn't be watched are retried below. Any that still
+	// can't be are rescanned from their previous scans
+	unwatched := make(map[string]rescan)
+	for path, r := range w.rescans {
+		if !r.once {
+			unwatched[path] = r
+			delete(w.rescans, path)
+		}
+	}
+	for _, root := range w.sortedRoots() {
+		if err := w.addWatch(root); err != nil {
+			// Keep the root, so that it's watched again after the next restart
+			watchLog.Errorf("could not re-establish watch for %q: %v", root, err)
+		}
+	}
+	for path, r := range w.rescans {
+		for prev, u := range unwatched {
+			if isUnder(path, prev) && u.since.Before(r.since) {
+				r.since = u.since
+				w.rescans[path] = r
+			}
+		}
+	}
+	watchLog.Infof("re-established watches for %d director(ies)", len(w.rootWatches))
+	return nil
+}
+
+// closeEvents closes w.iw, so that the goroutine reading its events exits
+// (the fanotify backend polls, so it notices 'closed' by itself, and closes
+// w.fanotifyFd). w.mu must be held by the caller
+func (w *Watch) closeEvents() {
+	if w.iw != nil {
+		w.iw.Close()
+	}
+}
+
+// sortedRoots returns w's root watches in lexical order. w.mu must be held by
+// the caller
+func (w *Watch) sortedRoots() []string {
+	roots := make([]string, 0, len(w.rootWatches))
+	for path := range w.rootWatches {
+		roots = append(roots, path)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// classify returns whether a write to 'path' (under the root watch 'root',
+// if it's set) is ignored and, if there's a canary config that disagrees,
+// the CanaryDiff to report. w.mu must be held by the caller
+func (w *Watch) classify(root, path string, isDir bool) (ignored bool, diff *CanaryDiff) {
+	if root == "" {
+		return false, nil
+	}
+	ignored = w.ignored(root, path, isDir)
+	if w.canary != nil {
+		if c := w.ignoredWith(w.canaryPatterns, root, path, isDir); c != ignored {
+			diff = &CanaryDiff{Root: root, Project: w.rootWatches[root], Path: path,
+				ActiveIgnored: ignored, CandidateIgnored: c}
+		}
+	}
+	return ignored, diff
+}
+
+// publish reports 'wr' to 'canary' (if the canary config disagrees about it)
+// and, unless it's ignored, to handleEvents via 'eventChan'. Once 'w' is
+// closed, 'wr' is dropped rather than waiting for handleEvents
+func (w *Watch) publish(wr write, ignored bool, diff *CanaryDiff, canary func(CanaryDiff),
+	eventChan chan<- write) {
+	if diff != nil {
+		diff.Time = wr.time
+		canary(*diff)
+	}
+	if ignored {
+		metrics.EventsFiltered.Add("ignored", 1)
+		return
+	}
+	// notify watcher that an event has occurred
+	metrics.QueueDepth.Add("writes", 1)
+	select {
+	case eventChan <- wr:
+	case <-w.done:
+		metrics.QueueDepth.Add("writes", -1)
+	}
+}
+
+// rootFor returns the root watch containing 'path', or "" if there is none.
+// w.mu must be held by the caller
+func (w *Watch) rootFor(path string) string {
+	var result string
+	for root := range w.rootWatches {
+		if isUnder(path, root) && len(root) > len(result) {
+			result = root // prefer the innermost root
+		}
+	}
+	return result
+}
+
+// ignored returns true if 'path' (under the root watch 'root') matches any of
+// w.ignorePatterns or the root's excludes, is ignored by an ignore file under
+// the root, or is a file whose name doesn't match the root's file patterns
+// (see RootOptions.Only). w.mu must be held by the caller
+func (w *Watch) ignored(root, path string, isDir bool) bool {
+	return w.ignoredWith(w.ignorePatterns, root, path, isDir)
+}
+
+// ignoredWith is like ignored, but uses 'patterns' in place of
+// w.ignorePatterns. w.mu must be held by the caller
+func (w *Watch) ignoredWith(patterns []string, root, path string, isDir bool) bool {
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+	opts := w.rootOptions[root]
+	if !isDir && len(opts.Only) > 0 && !matchesAny(opts.Only, p.Base(rel)) {
+		return true
+	}
+	return matchesAny(patterns, rel) || matchesAny(opts.Excludes, rel) ||
+		w.ignoreRules[root].ignored(rel, isDir)
+}
+
+// loadIgnoreRules (re)reads the ignore files under the root watch 'root',
+// unless they're disabled by the root's options. w.mu must be held by the
+// caller
+func (w *Watch) loadIgnoreRules(root string) {
+	if w.rootOptions[root].NoIgnoreFiles {
+		delete(w.ignoreRules, root)
+		return
+	}
+	w.ignoreRules[root] = loadIgnoreRules(root)
+}
+
+// matchesAny returns true if the relative path 'rel', or any component of it,
+// matches any of 'patterns'
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := p.Match(pattern, rel); ok {
+			return true
+		}
+		for _, part := range strings.Split(rel, "/") {
+			if ok, _ := p.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleEvents groups the events written to 'eventChan' into buckets of
+// length 'w.bucketSize', and calls w.callback once for each project that saw
+// writes in each bucket, until 'w' is closed
+func (w *Watch) handleEvents(eventChan <-chan write) {
 	for {
-		<-eventChan // wait for an event
-		// read as many events as possible in 'eventBucketSize'
-		timer := time.After(eventBucketSize)
+		var (
+			bucket   = make(map[string]*WriteEvent)    // project -> event
+			projects []string                          // projects in order of first write
+			paths    = make(map[string]bool)           // paths written in the bucket
+			counts   = make(map[string]map[string]int) // project -> file -> writes
+		)
+		add := func(wr write) {
+			metrics.QueueDepth.Add("writes", -1)
+			w.mu.Lock()
+			project, ok := w.rootWatches[wr.root]
+			disabled := w.rootOptions[wr.root].Disabled
+			if ok && wr.time.After(w.lastEvent[wr.root]) {
+				w.lastEvent[wr.root] = wr.time
+			}
+			w.mu.Unlock()
+			if !ok || disabled {
+				return // root was unwatched (or disabled) after the event was read
+			}
+			e, ok := bucket[project]
+			if !ok {
+				e = &WriteEvent{Project: project, Start: wr.time}
+				bucket[project] = e
+				projects = append(projects, project)
+				counts[project] = make(map[string]int)
+			}
+			counts[project][strings.TrimPrefix(wr.path, wr.root+"/")]++
+			e.Root = wr.root
+			e.Path = wr.path
+			e.Count++
+			e.End = wr.time
+			if !paths[wr.path] {
+				paths[wr.path] = true
+				e.Files++
+			}
+		}
+
+		select { // wait for an event
+		case wr := <-eventChan:
+			add(wr)
+		case <-w.done:
+			return
+		}
+		// read as many events as possible in 'w.bucketSize'
+		w.callbackMu.Lock()
+		timer := time.After(w.bucketSize)
+		w.callbackMu.Unlock()
 	waitForEvents:
 		for {
 			select {
-			case <-eventChan:
-				continue // discard event
+			case wr := <-eventChan:
+				add(wr)
 			case <-timer:
 				break waitForEvents
 			}
@@ -224,32 +859,102 @@ func (w *Watch) handleEvents(eventChan <-chan struct{}) {
 		w.callbackMu.Lock()
 		cb := w.callback
 		w.callbackMu.Unlock()
-		if cb != nil {
-			cb()
+		if cb == nil {
+			continue
+		}
+		for _, project := range projects {
+			metrics.DebounceLatency.Since(bucket[project].Start)
+			bucket[project].TopFiles = topFiles(counts[project], MaxTopFiles)
+			cb(*bucket[project])
 		}
 	}
 }
 
-// SetCallback sets that function that 'w' calls on write events
-func (w *Watch) SetCallback(f func()) {
+// SetCallback sets that function that 'w' calls on write events. 'f' is
+// called once per project per event bucket
+func (w *Watch) SetCallback(f func(WriteEvent)) {
 	w.callbackMu.Lock()
 	defer w.callbackMu.Unlock()
 	w.callback = f
 }
 
+// SetBucketSize sets the length of the period over which 'w' consolidates
+// writes, so that all writes to a project within one period produce a single
+// callback
+func (w *Watch) SetBucketSize(d time.Duration) {
+	w.callbackMu.Lock()
+	defer w.callbackMu.Unlock()
+	w.bucketSize = d
+}
+
+// SetIgnorePatterns sets the patterns (see path.Match) of paths in which
+// writes are ignored. A write is ignored if its path relative to its root
+// watch, or any component of that path, matches a pattern
+func (w *Watch) SetIgnorePatterns(patterns []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ignorePatterns = patterns
+}
+
+// SetCanary evaluates the ignore patterns 'patterns' in shadow mode: they
+// don't affect which writes are ignored, but 'f' is called with each write
+// that they would have handled differently from the patterns passed to
+// SetIgnorePatterns. If 'f' is nil, shadow evaluation stops
+func (w *Watch) SetCanary(patterns []string, f func(CanaryDiff)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.canaryPatterns, w.canary = patterns, f
+}
+
+// Roots returns a copy of the map from each watched root directory to its
+// Toggl project. Broken root watches aren't included (see Broken)
+func (w *Watch) Roots() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(map[string]string, len(w.rootWatches))
+	for dir, project := range w.rootWatches {
+		result[dir] = project
+	}
+	return result
+}
+
+// Broken returns a copy of the map from each broken root watch to its Toggl
+// project. A root watch is broken if its directory was moved to an unknown
+// path (or was missing when w started). It isn't watched, but stays in the
+// watch state until it's removed with RemoveWatch or added again
+func (w *Watch) Broken() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(map[string]string, len(w.broken))
+	for dir, project := range w.broken {
+		result[dir] = project
+	}
+	return result
+}
+
+// SetLostRootCallback sets the function that 'w' calls when a root watch's
+// directory is deleted or moved away, after it stops watching it
+func (w *Watch) SetLostRootCallback(f func(LostRoot)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lostRoot = f
+}
+
 // AddWatch tells this Watch to start monitoring a new directory
 func (w *Watch) AddWatch(dir, project string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	_, alreadyWatched := w.rootWatches[dir]
 	changedProject := alreadyWatched && w.rootWatches[dir] != project
 	if !alreadyWatched || changedProject {
+		delete(w.broken, dir)
 		w.rootWatches[dir] = project
-		w.stateFile.Seek(0 /* relative to origin of file */, 0)
-		w.stateFile.Truncate(0)
-		if err := json.NewEncoder(w.stateFile).Encode(w); err != nil {
+		if err := w.saveState(); err != nil {
 			return err
 		}
 	}
 	if !alreadyWatched {
+		w.loadIgnoreRules(dir)
 		if err := w.addWatch(dir); err != nil {
 			return err
 		}
@@ -257,58 +962,253 @@ func (w *Watch) AddWatch(dir, project string) error {
 	return nil
 }
 
+// RemoveWatch tells this Watch to stop monitoring 'dir' (which must have been
+// passed to AddWatch), and returns the project that 'dir' was associated with
+func (w *Watch) RemoveWatch(dir string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if project, ok := w.broken[dir]; ok {
+		delete(w.broken, dir)
+		return project, w.saveState() // 'dir' isn't watched
+	}
+	project, ok := w.rootWatches[dir]
+	if !ok {
+		return "", fmt.Errorf("%q is not being watched", dir)
+	}
+	delete(w.rootWatches, dir)
+	if err := w.saveState(); err != nil {
+		return "", err
+	}
+	w.queue.Drop(dir)
+
+	// Remove the inotify watches under 'dir', and then re-add any roots that
+	// were watched through it: those nested under it, and those that are the
+	// same directory (see watcher.Watcher.Add)
+	if w.iw == nil {
+		return project, nil
+	}
+	if err := w.iw.Remove(dir); err != nil {
+		return "", err
+	}
+	removed, _ := os.Stat(dir)
+	for _, root := range w.sortedRoots() {
+		info, err := os.Stat(root)
+		if isUnder(root, dir) || (err == nil && removed != nil && os.SameFile(info, removed)) {
+			if err := w.addWatch(root); err != nil {
+				return "", err
+			}
+		}
+	}
+	return project, nil
+}
+
+// isUnder returns true if 'path' is 'dir' or is inside 'dir'
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// saveState writes w's root watches, including broken ones, and their options
+// to the watch state, keeping the IDs of those that were already in it.
+// w.mu must be held by the caller
+func (w *Watch) saveState() error {
+	projects := make(map[string]string, len(w.rootWatches)+len(w.broken))
+	for dir, project := range w.broken {
+		projects[dir] = project
+	}
+	for dir, project := range w.rootWatches {
+		projects[dir] = project
+	}
+	w.store.sync(projects, w.rootOptions)
+	return writeStore(w.tgStateDir, w.store)
+}
+
+// restoreRoot starts watching the root watch 'dir', restored from the state
+// file, unless its directory is missing, in which case it's marked broken (see
+// Broken). w.mu must be held by the caller
+func (w *Watch) restoreRoot(dir, project string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if _, ok := w.broken[dir]; !ok {
+			watchLog.Warnf("root watch %q doesn't exist, so it isn't watched; "+
+				"watch its new path with 'tg watch', or remove it with 'tg unwatch'", dir)
+		}
+		delete(w.rootWatches, dir)
+		w.broken[dir] = project
+		return nil
+	}
+	delete(w.broken, dir)
+	w.rootWatches[dir] = project
+	w.loadIgnoreRules(dir)
+	return w.addWatch(dir)
+}
+
+// Reload re-reads the watch state (which may have been modified by
+// SaveRootWatch) and starts watching any root directories that were added
+func (w *Watch) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	store, err := readStore(w.tgStateDir)
+	if err != nil {
+		return err
+	}
+	w.store, w.rootOptions = store, store.options()
+	for dir, project := range store.projects() {
+		if _, alreadyWatched := w.rootWatches[dir]; alreadyWatched {
+			w.rootWatches[dir] = project
+			w.loadIgnoreRules(dir)
+		} else if err := w.restoreRoot(dir, project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveRootWatch persists a mapping from 'dir' to 'project' in the watch state
+// in 'tgStateDir', without starting a Watch (which would fail if the tg daemon
+// is running, as it holds the watch lock). A running daemon
+// must be told to Reload() its Watch to begin watching 'dir'
+func SaveRootWatch(tgStateDir, dir, project string) error {
+	return updateStore(tgStateDir, func(s *watchStore) error {
+		if rw := s.find(dir); rw != nil {
+			rw.Project = project // keep its options
+		} else {
+			s.put(RootWatch{Dir: dir, Project: project})
+		}
+		return nil
+	})
+}
+
+// RemoveRootWatch removes 'dir' from the watch state in 'tgStateDir',
+// without starting a Watch (see SaveRootWatch). It returns the project that
+// 'dir' was associated with
+func RemoveRootWatch(tgStateDir, dir string) (string, error) {
+	var project string
+	err := updateStore(tgStateDir, func(s *watchStore) error {
+		rw, ok := s.remove(dir)
+		if !ok {
+			return fmt.Errorf("%q is not being watched", dir)
+		}
+		project = rw.Project
+		return nil
+	})
+	return project, err
+}
+
+// ReadRootWatches returns the map from watched directories to projects in the
+// watch state in 'tgStateDir'
+func ReadRootWatches(tgStateDir string) (map[string]string, error) {
+	s, err := readStore(tgStateDir)
+	if err != nil {
+		return nil, err
+	}
+	return s.projects(), nil
+}
+
 // Start starts a new watcher, with which child paths can be registered
 func Start(tgStateDir string) (*Watch, error) {
-	statePath := p.Join(tgStateDir, stateFileName)
-	var (
-		stateFile *os.File
-		err       error
-	)
-	if _, err = os.Stat(statePath); err != nil {
-		stateFile, err = os.OpenFile(statePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("could not create watch state file: %v", err)
-		}
-	} else {
-		stateFile, err = os.OpenFile(statePath, os.O_RDWR, 0644)
+	return StartWithBackend(tgStateDir, DefaultBackend)
+}
+
+// StartWithBackend is like Start, but observes writes using 'backend'
+func StartWithBackend(tgStateDir string, backend Backend) (*Watch, error) {
+	// lock the lock file, to make sure no other process is watching these paths
+	lockPath := p.Join(tgStateDir, lockFileName)
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open watch lock file: %v", err)
 	}
-	// lock the state file, to make sure no other process is watching these paths
-	if err := lock(int(stateFile.Fd())); err != nil {
+	if err := lock(lockFile); err != nil {
+		lockFile.Close()
 		return nil, err
 	}
 
-	// Deserialize the list of watched directories from the watch file
+	// Deserialize the list of watched directories from the watch state
 	w := &Watch{
 		tgStateDir:  tgStateDir,
 		rootWatches: make(map[string]string),
+		broken:      make(map[string]string),
+		lastEvent:   make(map[string]time.Time),
+		backend:     backend,
+
+		lockFile:      lockFile,
+		ignoreRules:   make(map[string]ignoreRules),
+		defaultEvents: watcher.DefaultMask,
+		queue:         newShardedQueue(),
+		bucketSize:    defaultEventBucketSize,
+
+		rescans:        make(map[string]rescan),
+		rescanInterval: defaultRescanInterval,
+		rescanNow:      make(chan struct{}, 1),
 
-		// todo does this need to be in w at all?
-		stateFile: stateFile,
-		wdToPath:  make(map[int]string),
+		done:           make(chan struct{}),
+		lastRepair:     time.Now(),
+		repairInterval: defaultRepairInterval,
 	}
-	if w.stateFile == nil {
-		return nil, fmt.Errorf("watchFd is not a valid file descriptor")
+	if w.store, err = readStore(tgStateDir); err != nil {
+		// Corrupt watch state has been moved aside, so continue with no watches
+		watchLog.Errorf("%v", err)
 	}
-	json.NewDecoder(w.stateFile).Decode(w)
+	w.rootWatches, w.rootOptions = w.store.projects(), w.store.options()
 
-	// Create inotify fd and start goroutines to publish and process watch events
-	// TODO use an errgroup and context to re-establish watches if w.readEvents
-	// fails
-	eventChan := make(chan struct{}, 100)
-	w.inotifyFd, err = unix.InotifyInit()
+	// Create the inotify watcher (or fanotify fd) and start goroutines to
+	// publish and process watch events
+	eventChan := make(chan write, 100)
+	w.iw, w.fanotifyFd, err = w.open()
 	if err != nil {
+		lockFile.Close()
 		return nil, err
 	}
-	// copy inotify events on w.fd to 'eventChan'
-	go w.readEvents(eventChan)
+	// copy events from w.iw to 'eventChan', recovering from failures
+	// (BackendPoll has no events, and only scans for writes, below)
+	if backend != BackendPoll {
+		go w.superviseEvents(eventChan)
+	}
 	// Receive/batch events from 'eventChan' and call w.callback() when they occur
 	go w.handleEvents(eventChan)
+	// Scan subtrees that can't be watched (see rescan) for writes
+	go w.rescanLoop(eventChan)
+	// Watch any directories whose creation events were missed, and stop
+	// watching any that were deleted unnoticed
+	if backend == BackendInotify {
+		go w.repairLoop()
+	}
 
-	// Start watching the watched directories
-	for path, project := range w.rootWatches {
-		if err := w.AddWatch(path, project); err != nil {
-			return nil, err // right? Can I handle this error in any meaningful way
+	// Start watching the watched directories (restored from the watch state
+	// above, so use addWatch rather than AddWatch, which would skip them)
+	// Roots are added in order, so that if two are the same directory (see
+	// addWatch), the same one is always attributed its writes
+	w.mu.Lock()
+	for _, path := range w.sortedRoots() {
+		if err = w.restoreRoot(path, w.rootWatches[path]); err != nil {
+			break
 		}
 	}
+	w.mu.Unlock()
+	if err != nil {
+		w.Close() // release the lock, so that Start may be retried
+		return nil, err
+	}
 	return w, nil
 }
+
+// Close stops 'w' from watching for writes, and releases its lock on the state
+// directory, so that another Watch may be started there. Writes that were
+// already observed may still be passed to the callback after Close returns
+func (w *Watch) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done) // so that handleEvents exits, and queued events are dropped
+	w.closeEvents()
+	w.triggerRescan() // so that rescanLoop exits
+	err := w.lockFile.Close()
+	w.mu.Unlock()
+	// Queued events take w.mu, so wait for them without holding it
+	w.queue.Close()
+	if err != nil {
+		return fmt.Errorf("could not release watch lock file: %v", err)
+	}
+	return nil
+}
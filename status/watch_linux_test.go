@@ -0,0 +1,1082 @@
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/watchtest"
+	"golang.org/x/sys/unix"
+)
+
+func TestFileCreated(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Do file events & watch for touches
+	os.Create(j(d, "a"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestFileModified(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	os.Create(j(d, "a"))
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Do file events & watch for touches
+	f, err := os.OpenFile(j(d, "a"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("could not open %q for writing: %v", j(d, "a"), err)
+	}
+	f.WriteString("This is a test")
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestFileDeleted(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	os.Create(j(d, "a"))
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Do file events & watch for touches
+	err := os.Remove(j(d, "a"))
+	if err != nil {
+		t.Fatalf("could not delete %q: %v", j(d, "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestFileMoved(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	os.Create(j(d, "a"))
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Do file events & watch for touches
+	err := os.Rename(j(d, "a"), j(d, "b"))
+	if err != nil {
+		t.Fatalf("could not move %q to %q: %v", j(d, "a"), j(d, "b"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestChildDirCreated(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Create child directory
+	if err := os.Mkdir(j(d, "d"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "d"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Do file events & watch for touches
+	f, err := os.OpenFile(j(d, "d", "a"), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "d", "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	_, err = f.WriteString("This is a test")
+	if err != nil {
+		t.Fatalf("could not write to %q: %v", j(d, "d", "a"), err)
+	}
+	err = f.Sync()
+	if err != nil {
+		t.Fatalf("could not sync %q: %v", j(d, "d", "a"), err)
+	}
+	// An open file pins its directory, which would delay the directory's
+	// IN_IGNORED event until the file is closed
+	if err := f.Close(); err != nil {
+		t.Fatalf("could not close %q: %v", j(d, "d", "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestChildDirDeleted(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Create child directory
+	if err := os.Mkdir(j(d, "d"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "d"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	f, err := os.OpenFile(j(d, "d", "a"), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "d", "a"), err)
+	}
+	_, err = f.WriteString("This is a test")
+	if err != nil {
+		t.Fatalf("could not write to %q: %v", j(d, "d", "a"), err)
+	}
+	err = f.Sync()
+	if err != nil {
+		t.Fatalf("could not sync %q: %v", j(d, "d", "a"), err)
+	}
+	// An open file pins its directory, which would delay the directory's
+	// IN_IGNORED event until the file is closed
+	if err := f.Close(); err != nil {
+		t.Fatalf("could not close %q: %v", j(d, "d", "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches) // events will be batched into one event
+
+	// Delete the child dir, and make sure the event is registered
+	fmt.Printf("about to remove %q\n", j(d, "d"))
+	if err := os.RemoveAll(j(d, "d")); err != nil {
+		t.Fatalf("could not remove %q: %v", j(d, "d"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Make sure w's internal maps were updated
+	if watched := w.iw.Watched(); len(watched) != 1 {
+		t.Fatalf("w should be watching one dir, but is watching %d: %v", len(watched), watched)
+	}
+}
+
+func TestChildDirMoved(t *testing.T) {
+	t.Parallel()
+	// Initialize tmp dir
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+
+	// Add watch for tmp dir
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Create child directory
+	if err := os.Mkdir(j(d, "e"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "d"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Move child directory
+	if err := os.Rename(j(d, "e"), j(d, "d")); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "d"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Do file events & watch for touches
+	f, err := os.OpenFile(j(d, "d", "a"), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "d", "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	_, err = f.WriteString("This is a test")
+	if err != nil {
+		t.Fatalf("could not write to %q: %v", j(d, "d", "a"), err)
+	}
+	err = f.Sync()
+	if err != nil {
+		t.Fatalf("could not sync %q: %v", j(d, "d", "a"), err)
+	}
+	// An open file pins its directory, which would delay the directory's
+	// IN_IGNORED event until the file is closed
+	if err := f.Close(); err != nil {
+		t.Fatalf("could not close %q: %v", j(d, "d", "a"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+// TestChildDirTreeMoved moves a directory tree within a watched directory, and
+// makes sure that the move is seen once and that the tree stays watched at its
+// new path
+func TestChildDirTreeMoved(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "b", "c"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "b", "c"), err)
+	}
+	if err := os.Mkdir(j(d, "x"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "x"), err)
+	}
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	if err := os.Rename(j(d, "a"), j(d, "x", "y")); err != nil {
+		t.Fatalf("could not move %q to %q: %v", j(d, "a"), j(d, "x", "y"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	if err := w.Verify(); err != nil {
+		t.Fatalf("expected the moved tree to be watched, but: %v", err)
+	}
+
+	if err := ioutil.WriteFile(j(d, "x", "y", "b", "c", "f"), []byte("f"), 0644); err != nil {
+		t.Fatalf("could not write %q: %v", j(d, "x", "y", "b", "c", "f"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+// TestNewDirTree creates a deep directory tree (and a file at the bottom of it)
+// faster than watches can be added for it, and makes sure that everything in
+// it is seen, and that every new directory ends up watched
+func TestNewDirTree(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	w.AddWatch(d, "project")
+	files := make(chan int, 10)
+	w.SetCallback(func(e WriteEvent) {
+		files <- e.Files
+	})
+
+	deep := j(d, "a", "b", "c", "d", "e", "f")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", deep, err)
+	}
+	if err := ioutil.WriteFile(j(deep, "x"), []byte("x"), 0644); err != nil {
+		t.Fatalf("could not write %q: %v", j(deep, "x"), err)
+	}
+	// The six directories and the file are all seen, even though most of them
+	// were created before their parent was watched
+	var seen int
+	timeout := time.After(10 * time.Second)
+	for seen < 7 {
+		select {
+		case n := <-files:
+			seen += n
+		case <-timeout:
+			t.Fatalf("expected writes to 7 paths, but only saw %d", seen)
+		}
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("expected every new directory to be watched, but: %v", err)
+	}
+
+	// Writes at the bottom of the new tree are seen
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	if err := ioutil.WriteFile(j(deep, "y"), []byte("y"), 0644); err != nil {
+		t.Fatalf("could not write %q: %v", j(deep, "y"), err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestTwoProjects(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(j(d, dir), 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", j(d, dir), err)
+		}
+		w.AddWatch(j(d, dir), "project-"+dir)
+	}
+	events := make(chan WriteEvent, 10)
+	w.SetCallback(func(e WriteEvent) {
+		events <- e
+	})
+
+	// Writes in both roots within one bucket produce one event per project
+	os.Create(j(d, "a", "1"))
+	os.Create(j(d, "a", "2"))
+	os.Create(j(d, "b", "1"))
+	got := make(map[string]WriteEvent)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			got[e.Project] = e
+		case <-time.After(defaultEventBucketSize * 2):
+			t.Fatalf("timed out waiting for write events (got %v)", got)
+		}
+	}
+	if e := got["project-a"]; e.Root != j(d, "a") || e.Count != 2 ||
+		len(e.TopFiles) != 2 || e.TopFiles[0] != "1" || e.TopFiles[1] != "2" {
+		t.Fatalf("unexpected event for project-a: %+v", e)
+	}
+	if e := got["project-b"]; e.Root != j(d, "b") || e.Count != 1 {
+		t.Fatalf("unexpected event for project-b: %+v", e)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(j(d, dir), 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", j(d, dir), err)
+		}
+	}
+	w.AddWatch(j(d, "a"), "a")
+
+	// Add a root watch out-of-band (as 'tg watch' does) and reload
+	if err := SaveRootWatch(d+"-state", j(d, "b"), "b"); err != nil {
+		t.Fatalf("could not save root watch: %v", err)
+	}
+	if err := w.Reload(); err != nil {
+		t.Fatalf("could not reload watch: %v", err)
+	}
+	roots := w.Roots()
+	if len(roots) != 2 || roots[j(d, "a")] != "a" || roots[j(d, "b")] != "b" {
+		t.Fatalf("unexpected root watches after reload: %v", roots)
+	}
+}
+
+func TestRemoveWatch(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "b"), err)
+	}
+	w.AddWatch(j(d, "a"), "a")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	project, err := w.RemoveWatch(j(d, "a"))
+	if err != nil || project != "a" {
+		t.Fatalf("expected to remove watch for project \"a\", but got %q (%v)", project, err)
+	}
+	if len(w.Roots()) != 0 || len(w.iw.Watched()) != 0 {
+		t.Fatalf("expected no watches, but have %v and %v", w.Roots(), w.iw.Watched())
+	}
+	if roots, err := ReadRootWatches(d + "-state"); err != nil || len(roots) != 0 {
+		t.Fatalf("expected empty state file, but got %v (%v)", roots, err)
+	}
+
+	// Writes in the unwatched dir should not be observed
+	os.Create(j(d, "a", "b", "c"))
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+}
+
+// TestRootWatchIDsKept checks that a Watch keeps the IDs of its root watches
+// when it saves them
+func TestRootWatchIDsKept(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(j(d, name), 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", j(d, name), err)
+		}
+		w.AddWatch(j(d, name), name)
+	}
+	if _, err := w.RemoveWatch(j(d, "a")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	w.AddWatch(j(d, "b"), "b2")
+	w.AddWatch(j(d, "a"), "a")
+	listed, err := ListRootWatches(d + "-state")
+	if err != nil || len(listed) != 2 || listed[0].ID != 3 || listed[1].ID != 2 ||
+		listed[1].Project != "b2" {
+		t.Fatalf("expected \"a\" and \"b\" to have the IDs 3 and 2, but got %+v (%v)",
+			listed, err)
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.Mkdir(j(d, ".git"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, ".git"), err)
+	}
+	w.AddWatch(d, "project")
+	w.SetIgnorePatterns([]string{".git", "*.swp"})
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Writes matching an ignore pattern (directly or via a parent directory)
+	// should not be observed
+	os.Create(j(d, ".git", "index"))
+	os.Create(j(d, ".a.swp"))
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+}
+
+func TestCanary(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	w.AddWatch(d, "project")
+	w.SetIgnorePatterns([]string{"*.swp"})
+	diffs := make(chan CanaryDiff, 10)
+	w.SetCanary([]string{"*.log"}, func(diff CanaryDiff) {
+		diffs <- diff
+	})
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// The candidate patterns don't change which writes are observed...
+	os.Create(j(d, "a.log"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// ...but writes on which they disagree with the active patterns are reported
+	os.Create(j(d, ".b.swp"))
+	os.Create(j(d, "c.go"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	w.SetCanary(nil, nil)
+	got := make(map[string]bool) // a single write may produce several events
+	for len(diffs) > 0 {
+		diff := <-diffs
+		if diff.Project != "project" || diff.ActiveIgnored == diff.CandidateIgnored {
+			t.Fatalf("unexpected canary diff: %+v", diff)
+		}
+		got[diff.Path] = true
+	}
+	if len(got) != 2 || !got[j(d, "a.log")] || !got[j(d, ".b.swp")] {
+		t.Fatalf("expected canary diffs for a.log and .b.swp, but got %v", got)
+	}
+}
+
+func TestExcludedDirsNotWatched(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, dir := range []string{j(d, "a", "build", "x"), j(d, "a", "src")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", dir, err)
+		}
+	}
+	err := w.AddWatches([]RootWatch{{
+		Dir:         j(d, "a"),
+		Project:     "a",
+		RootOptions: RootOptions{Excludes: []string{"build"}},
+	}})
+	if err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watched := make(map[string]bool)
+	for _, path := range w.iw.Watched() {
+		watched[path] = true
+	}
+	if len(watched) != 2 || !watched[j(d, "a")] || !watched[j(d, "a", "src")] {
+		t.Fatalf("expected only %q and %q to be watched, but got %v", j(d, "a"),
+			j(d, "a", "src"), w.iw.Watched())
+	}
+}
+
+// TestRootEvents checks that only the classes of event set for a root watch
+// count as writes under it
+func TestRootEvents(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, path := range []string{j(d, "a", "f"), j(d, "b", "f")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("could not make dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("could not write %q: %v", path, err)
+		}
+	}
+	err := w.AddWatches([]RootWatch{
+		{Dir: j(d, "a"), Project: "a", RootOptions: RootOptions{Events: []string{"modify"}}},
+		{Dir: j(d, "b"), Project: "b", RootOptions: RootOptions{Events: []string{"attrib"}}},
+	})
+	if err != nil {
+		t.Fatalf("could not add watches: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Creating and deleting files in "a" isn't a write, but modifying one is
+	if err := ioutil.WriteFile(j(d, "a", "g"), nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	if err := os.Remove(j(d, "a", "g")); err != nil {
+		t.Fatalf("could not remove file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+	if err := ioutil.WriteFile(j(d, "a", "f"), []byte("x"), 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// In "b", only changing a file's permissions is
+	if err := ioutil.WriteFile(j(d, "b", "f"), []byte("x"), 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+	if err := os.Chmod(j(d, "b", "f"), 0600); err != nil {
+		t.Fatalf("could not chmod file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Invalid classes are rejected
+	rw := RootWatch{Dir: j(d, "a"), Project: "a", RootOptions: RootOptions{Events: []string{"read"}}}
+	if err := w.AddWatches([]RootWatch{rw}); err == nil {
+		t.Fatalf("expected an invalid event class to be rejected")
+	}
+}
+
+func TestDuplicateRoots(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "src"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "src"), err)
+	}
+	if err := os.Symlink("a", j(d, "b")); err != nil {
+		t.Fatalf("could not symlink %q: %v", j(d, "b"), err)
+	}
+	for _, root := range []string{"a", "b"} {
+		if err := w.AddWatch(j(d, root), root); err != nil {
+			t.Fatalf("could not add watch: %v", err)
+		}
+	}
+	// Writes in 'b' are attributed to 'a', which was watched first
+	watched := func() map[string]bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		result := make(map[string]bool)
+		for _, path := range w.iw.Watched() {
+			result[path] = true
+		}
+		return result
+	}
+	if paths := watched(); len(paths) != 2 || !paths[j(d, "a")] || !paths[j(d, "a", "src")] {
+		t.Fatalf("expected only %q and %q to be watched, but got %v", j(d, "a"),
+			j(d, "a", "src"), paths)
+	}
+
+	// Once 'a' is unwatched, 'b' is watched directly
+	if _, err := w.RemoveWatch(j(d, "a")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	if paths := watched(); len(paths) != 2 || !paths[j(d, "b")] || !paths[j(d, "b", "src")] {
+		t.Fatalf("expected only %q and %q to be watched, but got %v", j(d, "b"),
+			j(d, "b", "src"), paths)
+	}
+}
+
+func TestRecoverFromReadError(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.Mkdir(j(d, "sub"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "sub"), err)
+	}
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Replace the inotify fd with /dev/null. The pending poll returns with the
+	// next event, and then reading it fails
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	w.mu.Lock()
+	err = unix.Dup3(int(devNull.Fd()), w.iw.Fd(), 0)
+	w.mu.Unlock()
+	if err != nil {
+		t.Fatalf("could not replace inotify fd: %v", err)
+	}
+	f, err := os.Create(j(d, "a"))
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "a"), err)
+	}
+	f.Close()
+	watchtest.CheckEvent(t, watchtest.AtMost(1), touches)
+
+	// The watch recovers, including the watch on the subdirectory
+	w.mu.Lock()
+	gen := w.generation
+	w.mu.Unlock()
+	if gen == 0 {
+		t.Fatalf("expected the inotify fd to have been replaced")
+	}
+	f, err = os.Create(j(d, "sub", "b"))
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "sub", "b"), err)
+	}
+	f.Close()
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	w.AddWatch(d, "project")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close watch: %v", err)
+	}
+
+	// No events are delivered once the watch is closed
+	f, err := os.Create(j(d, "a"))
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "a"), err)
+	}
+	f.Close()
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+
+	// ...and the lock is released, so another Watch may start right away
+	w2, err := Start(d + "-state")
+	if err != nil {
+		t.Fatalf("could not restart watch after closing it: %v", err)
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatalf("could not close second watch: %v", err)
+	}
+}
+
+func TestWatchLimitReached(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	for _, dir := range []string{j(d, "small"), j(d, "big", "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", dir, err)
+		}
+	}
+	w := StartForTest(t, d)
+	// Simulate exhausting the watch limit while watching "big"
+	w.mu.Lock()
+	w.inotifyAddWatch = func(fd int, path string, mask uint32) (int, error) {
+		if isUnder(path, j(d, "big")) {
+			return -1, unix.ENOSPC
+		}
+		return unix.InotifyAddWatch(fd, path, mask)
+	}
+	w.mu.Unlock()
+	if err := w.AddWatch(d, "project"); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	if err := w.Verify(); err != nil {
+		t.Fatalf("expected rescanned dirs to be accounted for, but: %v", err)
+	}
+
+	// Writes in "big" are found by rescanning it
+	f, err := os.Create(j(d, "big", "sub", "a"))
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "big", "sub", "a"), err)
+	}
+	f.Close()
+	w.triggerRescan()
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	w.triggerRescan() // ...once
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+
+	// Once the limit is lifted, "big" is watched again on the next rescan
+	w.mu.Lock()
+	w.inotifyAddWatch = unix.InotifyAddWatch
+	w.mu.Unlock()
+	w.triggerRescan()
+	time.Sleep(100 * time.Millisecond)
+	w.mu.Lock()
+	rescans := len(w.rescans)
+	w.mu.Unlock()
+	if rescans != 0 {
+		t.Fatalf("expected no rescanned dirs once the limit was lifted, but have %d", rescans)
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	f, err = os.Create(j(d, "big", "sub", "b"))
+	if err != nil {
+		t.Fatalf("could not create %q: %v", j(d, "big", "sub", "b"), err)
+	}
+	f.Close()
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+}
+
+func TestMaxDepth(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	if err := os.MkdirAll(j(d, "a", "b", "c"), 0755); err != nil {
+		t.Fatalf("could not make dirs: %v", err)
+	}
+	w := StartForTest(t, d)
+	rw := RootWatch{Dir: d, Project: "project", RootOptions: RootOptions{MaxDepth: 1}}
+	if err := w.AddWatches([]RootWatch{rw}); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	w.mu.Lock()
+	watched := len(w.iw.Watched())
+	w.mu.Unlock()
+	if watched != 2 {
+		t.Fatalf("expected only %q and %q to be watched, but %d dirs are", d,
+			j(d, "a"), watched)
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	create := func(path string) {
+		t.Helper()
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("could not create %q: %v", path, err)
+		}
+		f.Close()
+	}
+	// Writes within the limit are watched, and deeper writes are found by
+	// scanning
+	create(j(d, "a", "x"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	create(j(d, "a", "b", "c", "y"))
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+	w.triggerRescan()
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	// Changing the limit of a watched root re-adds its watches
+	rw.MaxDepth, rw.NoRecursive = 0, true
+	if err := w.AddWatches([]RootWatch{rw}); err != nil {
+		t.Fatalf("could not update watch: %v", err)
+	}
+	w.mu.Lock()
+	watched = len(w.iw.Watched())
+	w.mu.Unlock()
+	if watched != 1 {
+		t.Fatalf("expected only %q to be watched, but %d dirs are", d, watched)
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "src"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "src"), err)
+	}
+	if err := w.AddWatch(j(d, "a"), "a"); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("expected watches to match disk, but got: %v", err)
+	}
+
+	// Forget the watch on a/src, as if its creation event had been dropped
+	if err := w.iw.Remove(j(d, "a", "src")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	if err := w.Verify(); err == nil || !strings.Contains(err.Error(), "should be watched") {
+		t.Fatalf("expected missing watch to be reported, but got: %v", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	for _, dir := range []string{j(d, "a", "src", "pkg"), j(d, "outside")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("could not make dir %q: %v", dir, err)
+		}
+	}
+	if err := w.AddWatch(j(d, "a"), "a"); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	// Forget the watches on a/src, as if its creation event had been dropped,
+	// and watch a directory outside of any root watch
+	if err := w.iw.Remove(j(d, "a", "src")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	if err := w.iw.Add(j(d, "outside")); err != nil {
+		t.Fatalf("could not add watch: %v", err)
+	}
+	if err := ioutil.WriteFile(j(d, "a", "src", "pkg", "f"), nil, 0644); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+
+	// Repair watches a/src again, and finds the write that was missed
+	result, err := w.Repair()
+	if err != nil {
+		t.Fatalf("could not repair watches: %v", err)
+	}
+	if len(result.Added) != 2 || result.Added[0] != j(d, "a", "src") ||
+		result.Added[1] != j(d, "a", "src", "pkg") {
+		t.Fatalf("expected a/src and a/src/pkg to be added, but got %v", result.Added)
+	}
+	if len(result.Pruned) != 1 || result.Pruned[0] != j(d, "outside") {
+		t.Fatalf("expected only %q to be pruned, but got %v", j(d, "outside"), result.Pruned)
+	}
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+	if err := w.Verify(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// ...and once they match, there's nothing to repair
+	if result, err = w.Repair(); err != nil || len(result.Added)+len(result.Pruned) > 0 {
+		t.Fatalf("expected nothing to repair, but got %+v (err: %v)", result, err)
+	}
+}
+
+// nextLostRoot returns the next root watch sent to 'lost', or fails the test
+// if there's none within a few seconds
+func nextLostRoot(t *testing.T, lost <-chan LostRoot) LostRoot {
+	t.Helper()
+	select {
+	case l := <-lost:
+		return l
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a root watch to be lost")
+	}
+	return LostRoot{}
+}
+
+// TestRootDirMoved moves a root watch's directory to a path that isn't watched,
+// and makes sure that the root watch is marked broken (even after a restart)
+// until it's removed
+func TestRootDirMoved(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.Mkdir(j(d, "a"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a"), err)
+	}
+	w.AddWatch(j(d, "a"), "a")
+	lost := make(chan LostRoot, 10)
+	w.SetLostRootCallback(func(l LostRoot) {
+		lost <- l
+	})
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+
+	if err := os.Rename(j(d, "a"), j(d, "b")); err != nil {
+		t.Fatalf("could not move %q to %q: %v", j(d, "a"), j(d, "b"), err)
+	}
+	if l := nextLostRoot(t, lost); l != (LostRoot{Dir: j(d, "a"), Project: "a", Moved: true}) {
+		t.Fatalf("expected %q to be moved away, but got %+v", j(d, "a"), l)
+	}
+	if len(w.Roots()) != 0 || len(w.iw.Watched()) != 0 {
+		t.Fatalf("expected no watches, but have %v and %v", w.Roots(), w.iw.Watched())
+	}
+	if broken := w.Broken(); len(broken) != 1 || broken[j(d, "a")] != "a" {
+		t.Fatalf("expected %q to be broken, but have %v", j(d, "a"), broken)
+	}
+	if roots, err := ReadRootWatches(d + "-state"); err != nil || roots[j(d, "a")] != "a" {
+		t.Fatalf("expected %q to stay in the state file, but got %v (%v)",
+			j(d, "a"), roots, err)
+	}
+	os.Create(j(d, "b", "c"))
+	watchtest.CheckEvent(t, watchtest.Exactly(0), touches)
+
+	// The root watch is still broken after a restart, rather than failing it
+	w.Close()
+	w, err := Start(d + "-state")
+	if err != nil {
+		t.Fatalf("could not restart watch: %v", err)
+	}
+	defer w.Close()
+	if broken := w.Broken(); len(broken) != 1 || broken[j(d, "a")] != "a" {
+		t.Fatalf("expected %q to be broken after restarting, but have %v", j(d, "a"), broken)
+	}
+	project, err := w.RemoveWatch(j(d, "a"))
+	if err != nil || project != "a" {
+		t.Fatalf("expected to remove watch for project \"a\", but got %q (%v)", project, err)
+	}
+	if roots, err := ReadRootWatches(d + "-state"); err != nil || len(roots) != 0 {
+		t.Fatalf("expected empty state file, but got %v (%v)", roots, err)
+	}
+	if broken := w.Broken(); len(broken) != 0 {
+		t.Fatalf("expected no broken root watches, but have %v", broken)
+	}
+}
+
+func TestRootDirDeleted(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "b"), err)
+	}
+	w.AddWatch(j(d, "a"), "a")
+	lost := make(chan LostRoot, 10)
+	w.SetLostRootCallback(func(l LostRoot) {
+		lost <- l
+	})
+
+	if err := os.RemoveAll(j(d, "a")); err != nil {
+		t.Fatalf("could not remove %q: %v", j(d, "a"), err)
+	}
+	if l := nextLostRoot(t, lost); l != (LostRoot{Dir: j(d, "a"), Project: "a"}) {
+		t.Fatalf("expected %q to be deleted, but got %+v", j(d, "a"), l)
+	}
+	if len(w.Roots()) != 0 || len(w.Broken()) != 0 {
+		t.Fatalf("expected no root watches, but have %v and %v", w.Roots(), w.Broken())
+	}
+	if roots, err := ReadRootWatches(d + "-state"); err != nil || len(roots) != 0 {
+		t.Fatalf("expected empty state file, but got %v (%v)", roots, err)
+	}
+}
+
+// TestNestedRootDirMoved moves the parent of a root watch that's nested in
+// another one, and makes sure that the nested root watch follows it
+func TestNestedRootDirMoved(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "b"), err)
+	}
+	w.AddWatch(d, "outer")
+	w.AddWatch(j(d, "a", "b"), "inner")
+	writes := make(chan WriteEvent, 10)
+	w.SetCallback(func(e WriteEvent) {
+		writes <- e
+	})
+
+	if err := os.Rename(j(d, "a"), j(d, "c")); err != nil {
+		t.Fatalf("could not move %q to %q: %v", j(d, "a"), j(d, "c"), err)
+	}
+	if e := <-writes; e.Project != "outer" {
+		t.Fatalf("expected the move to be attributed to \"outer\", but got %+v", e)
+	}
+	roots := w.Roots()
+	if len(roots) != 2 || roots[j(d, "c", "b")] != "inner" {
+		t.Fatalf("expected %q to be followed to %q, but have %v", j(d, "a", "b"),
+			j(d, "c", "b"), roots)
+	}
+	if saved, err := ReadRootWatches(d + "-state"); err != nil || saved[j(d, "c", "b")] != "inner" {
+		t.Fatalf("expected %q in the state file, but got %v (%v)", j(d, "c", "b"), saved, err)
+	}
+	if err := w.Verify(); err != nil {
+		t.Fatalf("expected the moved root watch to be watched, but: %v", err)
+	}
+
+	os.Create(j(d, "c", "b", "f"))
+	select {
+	case e := <-writes:
+		if e.Project != "inner" || e.Root != j(d, "c", "b") {
+			t.Fatalf("expected a write in %q, but got %+v", j(d, "c", "b"), e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for a write in %q", j(d, "c", "b"))
+	}
+}
+
+// TestDeleteDirTree deletes an entire directory tree, and then makes sure that
+// the corresponding watch descriptors are removed from the watch's internal
+// maps
+func TestDeleteDirTree(t *testing.T) {
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	d := GetTestDir(t)
+	w := StartForTest(t, d)
+	if err := os.MkdirAll(j(d, "a", "sub"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "a", "sub"), err)
+	}
+	if err := os.Mkdir(j(d, "b"), 0755); err != nil {
+		t.Fatalf("could not make dir %q: %v", j(d, "b"), err)
+	}
+	w.AddWatch(j(d, "a"), "project-a")
+	w.AddWatch(j(d, "b"), "project-b")
+	touches := make(chan struct{}, 10)
+	w.SetCallback(func(WriteEvent) {
+		touches <- struct{}{}
+	})
+	os.Create(j(d, "a", "sub", "1"))
+	watchtest.CheckEvent(t, watchtest.Exactly(1), touches)
+
+	health := w.Health()
+	if len(health) != 2 {
+		t.Fatalf("expected the health of 2 root watches, but got %+v", health)
+	}
+	a, b := health[0], health[1]
+	if a.Dir != j(d, "a") || a.Project != "project-a" || a.Backend != BackendInotify ||
+		a.Descriptors != 2 || a.LastEvent.IsZero() || !a.Exists || a.Broken {
+		t.Fatalf("unexpected health for %q: %+v", j(d, "a"), a)
+	}
+	if b.Dir != j(d, "b") || b.Descriptors != 1 || !b.LastEvent.IsZero() || !b.Exists {
+		t.Fatalf("unexpected health for %q: %+v", j(d, "b"), b)
+	}
+}
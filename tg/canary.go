@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// maxCanaryExamples is the number of example paths printed per project by
+// 'tg canary'
+const maxCanaryExamples = 3
+
+func canary() *cobra.Command {
+	var (
+		since   = config.Duration(7 * 24 * time.Hour)
+		verbose bool
+	)
+	cmd := &cobra.Command{
+		Use:   "canary",
+		Short: "Show where the candidate config would have attributed writes differently",
+		Long: "Show the writes on which the candidate config (see 'tg config set " +
+			"--candidate') and the active config disagree, as observed by the " +
+			"daemon. Once satisfied, switch to the candidate config with 'tg " +
+			"config promote', or drop it with 'tg config discard'",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			if _, ok, err := config.ReadCandidate(statusDir); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("there is no candidate config; create one with " +
+					"'tg config set --candidate <setting> <value>'")
+			}
+			end := time.Now()
+			diffs, err := status.ReadCanary(statusDir, end.Add(-time.Duration(since)), end)
+			if err != nil {
+				return err
+			}
+			if len(diffs) == 0 {
+				fmt.Printf("the candidate and active configs agree on all writes in "+
+					"the last %s\n", since)
+				return nil
+			}
+			if verbose {
+				for _, d := range diffs {
+					fmt.Printf("%s %-24s %s (%s)\n", d.Time.Format("2006-01-02 15:04:05"),
+						d.Project, d.Path, canaryChange(d))
+				}
+				return nil
+			}
+			// Summarize the diffs per project and per change
+			type key struct{ project, change string }
+			counts := make(map[key]int)
+			examples := make(map[key][]string)
+			var keys []key
+			for _, d := range diffs {
+				k := key{d.Project, canaryChange(d)}
+				if counts[k] == 0 {
+					keys = append(keys, k)
+				}
+				counts[k]++
+				if len(examples[k]) < maxCanaryExamples {
+					examples[k] = append(examples[k], d.Path)
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				if keys[i].project != keys[j].project {
+					return keys[i].project < keys[j].project
+				}
+				return keys[i].change < keys[j].change
+			})
+			for _, k := range keys {
+				fmt.Printf("%s: %d writes %s\n", k.project, counts[k], k.change)
+				for _, path := range examples[k] {
+					fmt.Printf("  %s\n", path)
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Show differences that occurred within "+
+		"this duration of now (e.g. \"7d\")")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print every write "+
+		"on which the configs disagree")
+	return cmd
+}
+
+// canaryChange describes how the candidate config would have handled the
+// write in 'd'
+func canaryChange(d status.CanaryDiff) string {
+	if d.CandidateIgnored {
+		return "would be ignored"
+	}
+	return "would be tracked"
+}
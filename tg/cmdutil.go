@@ -1,53 +1,45 @@
 package main
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/spf13/cobra"
 )
 
+// command is the body of a tg command, which receives the command's
+// positional arguments
 type command func([]string) error
 
-// UnboundedCommand is a convenience function that takes a function accepting a
-// slice of arguments and returning an error, and puts it in a cobra command
-func UnboundedCommand(f command) func(*cobra.Command, []string) {
-	return func(cmd *cobra.Command, args []string) {
-		if err := f(args); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
-		}
+// RunCommand is a convenience function that takes a function accepting a
+// slice of arguments and returning an error, and puts it in a cobra command's
+// RunE. Errors returned by 'f' are printed by main (see exitWithError), which
+// then exits nonzero
+func RunCommand(f command) func(*cobra.Command, []string) error {
+	return func(_ *cobra.Command, args []string) error {
+		return f(args)
 	}
 }
 
-// BoundedCommand is a convenience function that takes a lower and upper bound
-// on the number of positional arguments that a cobra command can recieve, and
-// a definition of the command itself (in 'f') and return a func that can be
-// added to a Cobra command-line tool
-// TODO print usage
-func BoundedCommand(minargs, maxargs int, f command) func(*cobra.Command, []string) {
-	return func(cmd *cobra.Command, args []string) {
-		var err error
-		argc := len(args)
-		switch {
-		case minargs > maxargs:
-			err = fmt.Errorf("invalid arguments to 'boundedCommand': 'minargs' "+
-				"must be <= 'maxargs', but got %d > %d", minargs, maxargs)
-		case minargs == maxargs && argc != minargs:
-			err = fmt.Errorf(fmt.Sprintf("expected exactly %d arguments, but got %d",
-				minargs, argc))
-		case argc < minargs:
-			err = fmt.Errorf("expected at least %d arguments, but got %d",
-				minargs, argc)
-		case argc > maxargs:
-			err = fmt.Errorf("expected at most %d arguments, but got %d",
-				maxargs, argc)
-		default:
-			err = f(args)
-		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+// Unbounded may be passed to ArgsBetween as 'maxargs' for commands that accept
+// any number of positional arguments
+const Unbounded = -1
+
+// ArgsBetween returns a validator for a cobra command's Args that accepts
+// between 'minargs' and 'maxargs' positional arguments (inclusive). If a
+// command receives any other number of arguments, it fails with a usageError,
+// so that its usage is printed with the error
+func ArgsBetween(minargs, maxargs int) cobra.PositionalArgs {
+	var check cobra.PositionalArgs
+	switch {
+	case maxargs == Unbounded:
+		check = cobra.MinimumNArgs(minargs)
+	case minargs == maxargs:
+		check = cobra.ExactArgs(minargs)
+	default:
+		check = cobra.RangeArgs(minargs, maxargs)
+	}
+	return func(cmd *cobra.Command, args []string) error {
+		if err := check(cmd, args); err != nil {
+			return &usageError{cmd: cmd, err: err}
 		}
+		return nil
 	}
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// projectCacheFile is the file in the state directory where the names of
+	// the projects in the Toggl workspace are cached for shell completion, so
+	// that Toggl isn't queried on every <tab>
+	projectCacheFile = "completion-projects"
+
+	// projectCacheTTL is how long the cached project names are used before
+	// they're fetched from Toggl again
+	projectCacheTTL = time.Hour
+
+	// projectFetchTimeout is how long completion waits for Toggl before
+	// falling back to the cached (or no) project names
+	projectFetchTimeout = 2 * time.Second
+)
+
+// bashCompletion is added to the bash completion script generated by cobra.
+// cobra calls __custom_func when it has no completions of its own (e.g. for
+// the arguments of a command with no subcommands); it completes watched
+// directories and known projects by calling 'tg __complete'.
+// __tg_complete_projects completes flags marked with it by MarkFlagCustom
+const bashCompletion = `__tg_complete()
+{
+    local IFS=$'\n'
+    COMPREPLY=( $(compgen -W "$(tg __complete "$1" 2>/dev/null)" -- "$cur") )
+}
+
+__tg_complete_projects()
+{
+    __tg_complete projects
+}
+
+__custom_func()
+{
+    case ${last_command} in
+        tg_unwatch | tg_disable | tg_enable | tg_group_remove)
+            __tg_complete dirs
+            ;;
+        tg_watch | tg_tick | tg_detour | tg_add)
+            if [[ ${#nouns[@]} -eq 0 ]]; then
+                __tg_complete projects
+            fi
+            ;;
+    esac
+}
+`
+
+// zshCompletion is the zsh completion script. %s is the list of tg's
+// commands, in _describe's "name:description" format
+const zshCompletion = `#compdef tg
+
+_tg() {
+    local -a commands
+    commands=(
+%s    )
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        return
+    fi
+    case ${words[2]} in
+        unwatch|disable|enable)
+            compadd -- ${(f)"$(tg __complete dirs 2>/dev/null)"}
+            ;;
+        tick|detour|add)
+            (( CURRENT == 3 )) && compadd -- ${(f)"$(tg __complete projects 2>/dev/null)"}
+            ;;
+        watch)
+            if (( CURRENT == 3 )); then
+                compadd -- ${(f)"$(tg __complete projects 2>/dev/null)"}
+            else
+                _files -/
+            fi
+            ;;
+        *)
+            _files
+            ;;
+    esac
+}
+
+compdef _tg tg
+`
+
+// fishCompletion is the end of the fish completion script, after the
+// completions of tg's commands
+const fishCompletion = `complete -c tg -n '__fish_seen_subcommand_from unwatch disable enable' -f -a '(tg __complete dirs 2>/dev/null)'
+complete -c tg -n '__fish_seen_subcommand_from tick detour add' -f -a '(tg __complete projects 2>/dev/null)'
+complete -c tg -n '__fish_seen_subcommand_from watch' -a '(tg __complete projects 2>/dev/null)'
+`
+
+func completion(root *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion [bash|zsh|fish]",
+		Short: "Print a shell completion script for tg",
+		Long: "Print a script that, when evaluated by your shell (e.g. " +
+			"'source <(tg completion bash)' in ~/.bashrc), completes tg's " +
+			"commands and flags, the watched directories for 'tg unwatch', and " +
+			"known project names for 'tg watch' and 'tg tick'. The shell defaults " +
+			"to the one in $SHELL",
+		Args: ArgsBetween(0, 1),
+		// Run by every new shell
+		Annotations: lightweight,
+		RunE: RunCommand(func(args []string) error {
+			shell := path.Base(os.Getenv("SHELL"))
+			if len(args) > 0 {
+				shell = args[0]
+			}
+			switch shell {
+			case "bash":
+				root.BashCompletionFunction = bashCompletion
+				return root.GenBashCompletion(os.Stdout)
+			case "zsh":
+				var commands strings.Builder
+				for _, c := range root.Commands() {
+					if c.IsAvailableCommand() {
+						fmt.Fprintf(&commands, "        '%s:%s'\n", c.Name(), zshQuote(c.Short))
+					}
+				}
+				fmt.Printf(zshCompletion, commands.String())
+			case "fish":
+				fmt.Println("complete -c tg -e")
+				for _, c := range root.Commands() {
+					if c.IsAvailableCommand() {
+						fmt.Printf("complete -c tg -f -n '__fish_use_subcommand' -a %s -d '%s'\n",
+							c.Name(), fishQuote(c.Short))
+					}
+				}
+				fmt.Print(fishCompletion)
+			default:
+				return fmt.Errorf("unsupported shell %q (expected bash, zsh, or fish)", shell)
+			}
+			return nil
+		}),
+	}
+}
+
+// zshQuote escapes 's' for a single-quoted _describe entry
+func zshQuote(s string) string {
+	return strings.NewReplacer("'", `'\''`, ":", `\:`).Replace(s)
+}
+
+// fishQuote escapes 's' for a single-quoted fish string
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+// complete is run by the completion scripts to list the candidates for an
+// argument, one per line. Errors are ignored, so that completion just offers
+// fewer candidates
+func complete() *cobra.Command {
+	return &cobra.Command{
+		Use:    "__complete [dirs|projects]",
+		Hidden: true,
+		// Run on every <tab>
+		Annotations: lightweight,
+		Args:        ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			var candidates []string
+			switch args[0] {
+			case "dirs":
+				watches, _ := status.ReadRootWatches(statusDir)
+				for dir := range watches {
+					candidates = append(candidates, dir)
+				}
+			case "projects":
+				candidates = projectNames()
+			}
+			sort.Strings(candidates)
+			for _, c := range candidates {
+				fmt.Println(c)
+			}
+			return nil
+		}),
+	}
+}
+
+// projectNames returns the names of the projects that tg knows of: those of
+// its root watches and, if Toggl is the backend, those in the Toggl workspace
+// (cached in projectCacheFile)
+func projectNames() []string {
+	seen := make(map[string]bool)
+	var result []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	watches, _ := status.ReadRootWatches(statusDir)
+	for _, project := range watches {
+		add(project)
+	}
+	cfg, err := config.Read(statusDir)
+	if err != nil || (cfg.Backend != "" && cfg.Backend != config.BackendToggl) {
+		return result // only Toggl projects are cached
+	}
+	for _, name := range togglProjectNames() {
+		add(name)
+	}
+	return result
+}
+
+// togglProjectNames returns the names of the projects in the Toggl workspace,
+// from projectCacheFile if it's fresh, and otherwise from Toggl (if it answers
+// within projectFetchTimeout)
+func togglProjectNames() []string {
+	cachePath := path.Join(statusDir, projectCacheFile)
+	var cached []string
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		json.Unmarshal(data, &cached)
+		if info, err := os.Stat(cachePath); err == nil &&
+			time.Since(info.ModTime()) < projectCacheTTL {
+			return cached
+		}
+	}
+	c, err := newClient()
+	if err != nil {
+		return cached
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), projectFetchTimeout)
+	defer cancel()
+	wid, err := c.Workspace(ctx)
+	if err != nil {
+		return cached
+	}
+	projects, err := c.ListProjects(ctx, wid)
+	if err != nil {
+		return cached
+	}
+	names := make([]string, 0, len(projects))
+	for _, p := range projects {
+		names = append(names, p.Name)
+	}
+	if data, err := json.Marshal(names); err == nil {
+		persist.WriteFile(cachePath, data, 0644)
+	}
+	return names
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// readConfig reads the active config or, if 'candidate' is true, the
+// candidate config (which starts as a copy of the active config)
+func readConfig(candidate bool) (config.Config, error) {
+	if candidate {
+		c, ok, err := config.ReadCandidate(statusDir)
+		if err != nil || ok {
+			return c, err
+		}
+	}
+	return config.Read(statusDir)
+}
+
+// reloadDaemon signals the tg daemon (if it's running) to reload its config
+func reloadDaemon() error {
+	if pid, err := daemon.PID(statusDir); err == nil {
+		return daemon.Reload(statusDir, pid)
+	}
+	return nil
+}
+
+func configGet() *cobra.Command {
+	var candidate bool
+	cmd := &cobra.Command{
+		Use:   "get [setting]",
+		Short: "Print the value of a setting (or of all settings)",
+		Args:  ArgsBetween(0, 1),
+		RunE: RunCommand(func(args []string) error {
+			c, err := readConfig(candidate)
+			if err != nil {
+				return err
+			}
+			if len(args) == 1 {
+				value, err := c.Get(args[0])
+				if err != nil {
+					return err
+				}
+				fmt.Println(value)
+				return nil
+			}
+			for _, key := range config.Keys() {
+				value, _ := c.Get(key)
+				fmt.Printf("%s: %s\n", key, value)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&candidate, "candidate", false, "Read the candidate "+
+		"config (see 'tg config set --candidate')")
+	return cmd
+}
+
+func configSet() *cobra.Command {
+	var candidate bool
+	cmd := &cobra.Command{
+		Use:   "set <setting> <value>",
+		Short: "Change the value of a setting",
+		Long: "Change the value of a setting in the config file. List settings " +
+			"(ignore_patterns, window_titles, redactions) are comma-separated, " +
+			"each window title is <pattern>=<project>, and each redaction is " +
+			"<pattern>=<replacement>. If the tg daemon is running, " +
+			"it's signalled to reload its config. With --candidate, the setting is " +
+			"changed in a candidate config instead, which the daemon evaluates in " +
+			"shadow mode (see 'tg canary') until it's promoted or discarded",
+		Args: ArgsBetween(2, 2),
+		RunE: RunCommand(func(args []string) error {
+			c, err := readConfig(candidate)
+			if err != nil {
+				return err
+			}
+			if err := c.Set(args[0], args[1]); err != nil {
+				return err
+			}
+			if err := persist.MakeStateDir(statusDir); err != nil {
+				return err
+			}
+			save := c.Save
+			if candidate {
+				save = c.SaveCandidate
+			}
+			if err := save(statusDir); err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+	cmd.Flags().BoolVar(&candidate, "candidate", false, "Change the "+
+		"candidate config rather than the active one")
+	return cmd
+}
+
+func configPromote() *cobra.Command {
+	return &cobra.Command{
+		Use:   "promote",
+		Short: "Replace the active config with the candidate config",
+		Args:  ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			if err := config.PromoteCandidate(statusDir); err != nil {
+				return err
+			}
+			if err := status.ClearCanary(statusDir); err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func configDiscard() *cobra.Command {
+	return &cobra.Command{
+		Use:   "discard",
+		Short: "Discard the candidate config, ending its shadow evaluation",
+		Args:  ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			if err := config.DiscardCandidate(statusDir); err != nil {
+				return err
+			}
+			if err := status.ClearCanary(statusDir); err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Read or change tg's settings",
+		Long: "Read or change the settings in tg's config file (" +
+			config.FileName + " in tg's state directory)",
+	}
+	cmd.AddCommand(configGet())
+	cmd.AddCommand(configSet())
+	cmd.AddCommand(configPromote())
+	cmd.AddCommand(configDiscard())
+	return cmd
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/logging"
+	"github.com/spf13/cobra"
+)
+
+// daemonTimeout bounds how long 'tg daemon stop' and 'tg daemon restart' wait
+// for the daemon to exit or to start
+const daemonTimeout = 30 * time.Second
+
+// daemonInfo asks the running daemon to identify itself. It returns
+// control.ErrNotRunning if no daemon is listening on the control socket
+func daemonInfo() (control.DaemonInfo, error) {
+	var info control.DaemonInfo
+	err := control.Call(statusDir, control.MethodDaemonInfo, nil, &info)
+	return info, err
+}
+
+// exits waits up to 'timeout' for the process 'pid' to exit, and returns true
+// if it did
+func exits(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); daemon.ProcessExists(pid); {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// stopDaemon shuts the running daemon down, and waits for it to exit
+func stopDaemon(info control.DaemonInfo) error {
+	err := control.Call(statusDir, control.MethodShutdown, nil, nil)
+	if err != nil && !exits(info.PID, time.Second) {
+		// Otherwise, the response was just lost as the daemon exited
+		return fmt.Errorf("could not stop the tg daemon: %v", err)
+	}
+	if !exits(info.PID, daemonTimeout) {
+		return fmt.Errorf("the tg daemon (PID %d) didn't exit within %s",
+			info.PID, daemonTimeout)
+	}
+	return nil
+}
+
+// startDaemon starts 'tg <args>' (e.g. "tg resume") in the background, and
+// waits until it's listening on the control socket
+func startDaemon(args []string) (control.DaemonInfo, error) {
+	tg, err := os.Executable()
+	if err != nil {
+		return control.DaemonInfo{}, fmt.Errorf("could not find the tg executable: %v", err)
+	}
+	cmd := exec.Command(tg, args...)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return control.DaemonInfo{}, fmt.Errorf("could not start the tg daemon: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for deadline := time.Now().Add(daemonTimeout); ; {
+		if info, err := daemonInfo(); err == nil {
+			return info, nil
+		}
+		select {
+		case err := <-exited:
+			return control.DaemonInfo{}, fmt.Errorf("the tg daemon exited (%v); "+
+				"see %s in %s", err, logging.FileName, statusDir)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return control.DaemonInfo{}, fmt.Errorf("the tg daemon didn't start "+
+				"within %s", daemonTimeout)
+		}
+	}
+}
+
+func daemonStatus() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Report whether the tg daemon is running, with its PID and uptime",
+		Args:  ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			info, err := daemonInfo()
+			if err == control.ErrNotRunning {
+				if pid, pidErr := daemon.PID(statusDir); pidErr == nil {
+					return fmt.Errorf("process %d holds the daemon's PID file, but "+
+						"isn't listening on the control socket (it may be wedged, or "+
+						"still starting)", pid)
+				}
+				return err
+			} else if err != nil {
+				return err
+			}
+			fmt.Printf("running:    PID %d\n", info.PID)
+			fmt.Printf("uptime:     %s\n", time.Since(info.Started).Round(time.Second))
+			if info.Systemd {
+				fmt.Println("supervisor: systemd")
+			}
+			return nil
+		}),
+	}
+}
+
+func daemonStop() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the tg daemon, stopping the open time entry",
+		Long: "Stop the tg daemon as SIGTERM would: the open time entry is " +
+			"stopped (as if you'd gone idle) and the daemon's state is saved",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			info, err := daemonInfo()
+			if err != nil {
+				return err
+			}
+			if err := stopDaemon(info); err != nil {
+				return err
+			}
+			fmt.Printf("stopped the tg daemon (PID %d)\n", info.PID)
+			return nil
+		}),
+	}
+}
+
+func daemonRestart() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the tg daemon (or start it, if it isn't running)",
+		Long: "Stop the tg daemon and start it again in the background, with the " +
+			"same arguments. If it isn't running, 'tg resume' is started",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			args := []string{"resume"}
+			info, err := daemonInfo()
+			switch {
+			case err == nil && info.Systemd:
+				return fmt.Errorf("the tg daemon is managed by systemd; run "+
+					"'systemctl --user restart %s' instead", serviceName)
+			case err == nil:
+				if err := stopDaemon(info); err != nil {
+					return err
+				}
+				if len(info.Args) > 0 {
+					args = info.Args
+				}
+			case err != control.ErrNotRunning:
+				return err
+			}
+			info, err = startDaemon(args)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("started the tg daemon (PID %d)\n", info.PID)
+			return nil
+		}),
+	}
+}
+
+func daemonCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Check on, stop, or restart the tg daemon",
+	}
+	cmd.AddCommand(daemonStatus())
+	cmd.AddCommand(daemonStop())
+	cmd.AddCommand(daemonRestart())
+	return cmd
+}
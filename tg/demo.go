@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/toggltest"
+	"github.com/spf13/cobra"
+)
+
+// demoFiles are the files that the demo workload edits
+var demoFiles = []string{"main.go", "server.go", "server_test.go", "README.md"}
+
+// generateActivity simulates a person working in 'dir': bursts of edits to a
+// few files, separated by pauses of varying length, until 'stop' is closed
+func generateActivity(dir string, stop <-chan struct{}) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; ; i++ {
+		// A burst of 1-5 saves
+		for j := r.Intn(5); j >= 0; j-- {
+			name := path.Join(dir, demoFiles[r.Intn(len(demoFiles))])
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "demo: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(f, "// edit %d.%d\n", i, j)
+			f.Close()
+		}
+		// Occasionally create a new package
+		if r.Intn(10) == 0 {
+			os.MkdirAll(path.Join(dir, fmt.Sprintf("pkg%d", i)), 0755)
+		}
+		// Pause for 1-10s (as if reading or thinking)
+		select {
+		case <-time.After(time.Duration(1+r.Intn(10)) * time.Second):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func demo() *cobra.Command {
+	duration := config.Duration(time.Minute)
+	cmd := &cobra.Command{
+		Use:   "demo",
+		Short: "Show tg at work on simulated activity, without touching real data",
+		Long: "Run the tg daemon against a temporary project directory with " +
+			"simulated file activity and a fake Toggl server, displaying its " +
+			"state as it runs. Nothing is sent to Toggl and no state outside of " +
+			"a temporary directory is modified",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			tmp, err := ioutil.TempDir("", "tg-demo-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmp)
+			stateDir, projectDir := path.Join(tmp, "state"), path.Join(tmp, "toggl-watcher")
+			for _, dir := range []string{stateDir, projectDir} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					return err
+				}
+			}
+			if err := status.SaveRootWatch(stateDir, projectDir, "toggl-watcher"); err != nil {
+				return err
+			}
+
+			server := toggltest.NewServer()
+			defer server.Close()
+			d, err := daemon.New(stateDir, server.Client(), daemon.DefaultOptions())
+			if err != nil {
+				return err
+			}
+			errCh := make(chan error, 1)
+			go func() { errCh <- d.Run() }()
+			defer d.Stop()
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go generateActivity(projectDir, stop)
+
+			fmt.Printf("watching %s for %s (Ctrl-C to quit)\n", projectDir, duration)
+			deadline := time.After(time.Duration(duration))
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case err := <-errCh:
+					return err
+				case <-deadline:
+					fmt.Printf("\ndemo finished; %d time entries were created\n",
+						len(server.TimeEntries()))
+					return nil
+				case <-ticker.C:
+				}
+				var s control.StatusResult
+				if err := control.Call(stateDir, control.MethodStatus, nil, &s); err != nil {
+					continue // daemon may still be starting
+				}
+				line := "no activity yet"
+				if !s.LatestTick.IsZero() {
+					line = fmt.Sprintf("project: %s | last write: %s ago | open entry: %d",
+						s.Project, time.Since(s.LatestTick).Round(time.Second), s.TimeEntryID)
+				}
+				fmt.Printf("\r\033[K%s", line) // redraw the status line in place
+			}
+		}),
+	}
+	cmd.Flags().Var(&duration, "duration", "How long to run the demo")
+	return cmd
+}
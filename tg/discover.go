@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// skippedDirs are directories that 'tg discover' doesn't search for
+// repositories, as they typically contain many dependencies' repositories
+// rather than the user's own
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+}
+
+// buildDirs are directories that, if present at the top of a discovered
+// repository, are excluded from its proposed watch, as they contain generated
+// files (and often many subdirectories, each of which would need a watch)
+var buildDirs = []string{
+	"node_modules", "vendor", "target", "build", "dist", "out", ".venv",
+	"__pycache__",
+}
+
+func discover() *cobra.Command {
+	var (
+		yes   bool
+		group string
+	)
+	cmd := &cobra.Command{
+		Use:   "discover <directory>",
+		Short: "Find git repositories under a directory and watch them",
+		Long: "Search <directory> for git repositories that aren't watched yet, and " +
+			"propose a watch for each one, attributed to a project named after the " +
+			"repository and excluding .git and any build directories. Each " +
+			"accepted watch is added at once, as with 'tg watch --from-file'",
+		Args: ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			root, err := filepath.Abs(args[0])
+			if err != nil {
+				return fmt.Errorf("could not resolve %q: %v", args[0], err)
+			}
+			repos, err := findRepos(root)
+			if err != nil {
+				return err
+			}
+			watched, err := status.ReadRootWatches(statusDir)
+			if err != nil {
+				return err
+			}
+			stdin := bufio.NewReader(os.Stdin)
+			var watches []status.RootWatch
+			for _, repo := range repos {
+				if _, ok := watched[repo]; ok {
+					continue
+				}
+				rw := proposeWatch(repo)
+				rw.Group = group
+				prompt := fmt.Sprintf("watch %s as %q (excluding %s)?", rw.Dir,
+					rw.Project, strings.Join(rw.Excludes, ", "))
+				if yes {
+					fmt.Println(prompt, "yes")
+				} else if !confirm(stdin, prompt) {
+					continue
+				}
+				watches = append(watches, rw)
+			}
+			if len(watches) == 0 {
+				fmt.Printf("no new repositories to watch under %s\n", root)
+				return nil
+			}
+			return addWatches(watches, true)
+		}),
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Watch every discovered "+
+		"repository without asking")
+	cmd.Flags().StringVar(&group, "group", "", "Add the new watches to this "+
+		"group (see 'tg group')")
+	return cmd
+}
+
+// findRepos returns the git repositories under 'root' (including 'root'
+// itself), in lexical order. Repositories nested inside another repository
+// (e.g. submodules) aren't returned, and hidden directories aren't searched
+func findRepos(root string) ([]string, error) {
+	var result []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // e.g. a directory that can't be read; keep searching
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != root && (strings.HasPrefix(name, ".") || skippedDirs[name]) {
+			return filepath.SkipDir
+		}
+		// Worktrees and submodules have a .git file rather than a directory
+		if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+			result = append(result, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not search %q: %v", root, err)
+	}
+	return result, nil
+}
+
+// proposeWatch returns the watch that 'tg discover' proposes for the git
+// repository 'repo'
+func proposeWatch(repo string) status.RootWatch {
+	excludes := []string{".git"}
+	for _, dir := range buildDirs {
+		if info, err := os.Stat(filepath.Join(repo, dir)); err == nil && info.IsDir() {
+			excludes = append(excludes, dir)
+		}
+	}
+	return status.RootWatch{
+		Dir:         repo,
+		Project:     filepath.Base(repo),
+		RootOptions: status.RootOptions{Excludes: excludes},
+	}
+}
+
+// confirm prints 'prompt' and returns true if the user answers yes
+func confirm(stdin *bufio.Reader, prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
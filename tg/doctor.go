@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/spf13/cobra"
+)
+
+// The outcomes of a 'tg doctor' check, in increasing order of severity
+const (
+	checkSkipped = iota
+	checkOK
+	checkWarn
+	checkFailed
+)
+
+// checkLabels are how each outcome is printed
+var checkLabels = map[int]string{
+	checkSkipped: "skip",
+	checkOK:      "ok",
+	checkWarn:    "warn",
+	checkFailed:  "FAIL",
+}
+
+const (
+	// maxClockSkew is the largest difference between the local clock and
+	// Toggl's that 'tg doctor' accepts, beyond the round trip of the request
+	// that measured it (Toggl's Date header is only accurate to the second)
+	maxClockSkew = 5 * time.Second
+
+	// inotifyWarnRatio is the share of the inotify watch limit in use at which
+	// 'tg doctor' warns that the limit is close
+	inotifyWarnRatio = 0.75
+)
+
+// checkResult is the outcome of one of the checks run by 'tg doctor', with a
+// fix for any problem it found
+type checkResult struct {
+	name    string
+	outcome int
+	detail  string
+	fix     string
+}
+
+// print prints 'r' as a line of 'tg doctor' output
+func (r checkResult) print() {
+	fmt.Printf("[%-4s] %-12s %s\n", checkLabels[r.outcome], r.name, r.detail)
+	if r.fix != "" {
+		fmt.Printf("       %-12s fix: %s\n", "", r.fix)
+	}
+}
+
+// checkDaemon checks that the daemon is running and responsive. It also
+// returns whether it is, and if so, its watch health
+func checkDaemon() (r checkResult, running bool, health []status.RootHealth) {
+	r = checkResult{name: "daemon"}
+	info, err := daemonInfo()
+	switch {
+	case err == control.ErrNotRunning:
+		r.outcome, r.detail = checkWarn, "not running, so writes aren't tracked"
+		r.fix = "start it with 'tg resume' (or 'tg install-service', to start " +
+			"it at login)"
+		if pid, pidErr := daemon.PID(statusDir); pidErr == nil {
+			r.outcome = checkFailed
+			r.detail = fmt.Sprintf("process %d holds the PID file, but isn't "+
+				"listening on the control socket", pid)
+			r.fix = fmt.Sprintf("if it doesn't start within a minute, it's wedged; "+
+				"stop it with 'kill %d' and run 'tg resume'", pid)
+		}
+		return r, false, nil
+	case err != nil:
+		r.outcome, r.detail = checkFailed, fmt.Sprintf("not responding: %v", err)
+		r.fix = "restart it with 'tg daemon restart'"
+		return r, false, nil
+	}
+	r.outcome = checkOK
+	r.detail = fmt.Sprintf("running (PID %d, up %s)", info.PID,
+		time.Since(info.Started).Round(time.Second))
+	if err := control.Call(statusDir, control.MethodWatchHealth, nil, &health); err != nil {
+		r.outcome, r.detail = checkWarn, r.detail+fmt.Sprintf(", but could not "+
+			"list its watches: %v", err)
+	}
+	return r, true, health
+}
+
+// checkStaleFiles checks for lock, PID, and socket files left behind by a
+// daemon that's no longer running
+func checkStaleFiles(running bool) []checkResult {
+	var result []checkResult
+	if running {
+		return []checkResult{{name: "lock files", outcome: checkOK,
+			detail: "held by the running daemon"}}
+	}
+	lockPath := status.WatchLockPath(statusDir)
+	if locked, err := status.WatchLocked(statusDir); err != nil {
+		result = append(result, checkResult{name: "lock files", outcome: checkWarn,
+			detail: err.Error()})
+	} else if locked {
+		result = append(result, checkResult{name: "lock files", outcome: checkFailed,
+			detail: fmt.Sprintf("%s is held by another process (e.g. a wedged "+
+				"daemon), so the daemon can't start", lockPath),
+			fix: fmt.Sprintf("find the process with 'fuser %s' and stop it", lockPath)})
+	}
+	pidPath := daemon.PIDPath(statusDir)
+	if _, err := os.Stat(pidPath); err == nil {
+		if _, err := daemon.PID(statusDir); err != nil {
+			result = append(result, checkResult{name: "lock files", outcome: checkWarn,
+				detail: fmt.Sprintf("%s is stale (%v)", pidPath, err),
+				fix:    fmt.Sprintf("rm %s", pidPath)})
+		}
+	}
+	sockPath := control.SocketPath(statusDir)
+	if _, err := os.Stat(sockPath); err == nil {
+		result = append(result, checkResult{name: "lock files", outcome: checkWarn,
+			detail: fmt.Sprintf("%s is stale (nothing is listening on it)", sockPath),
+			fix:    fmt.Sprintf("rm %s (the daemon also removes it when it starts)", sockPath)})
+	}
+	if len(result) == 0 {
+		result = append(result, checkResult{name: "lock files", outcome: checkOK,
+			detail: "none are stale"})
+	}
+	return result
+}
+
+// checkInotify compares the inotify watches used by the daemon (per 'health')
+// with the system's limit
+func checkInotify(cfg config.Config, running bool, health []status.RootHealth) checkResult {
+	r := checkResult{name: "inotify"}
+	backend, err := status.ParseBackend(cfg.WatchBackend)
+	if err != nil || backend != status.BackendInotify {
+		r.detail = "not used by the watch backend"
+		return r
+	}
+	watches, instances, err := status.InotifyLimits()
+	if err != nil {
+		r.outcome, r.detail = checkWarn, fmt.Sprintf("could not read limits: %v", err)
+		return r
+	}
+	r.outcome = checkOK
+	if !running {
+		r.detail = fmt.Sprintf("limit %d watches, %d instances (usage unknown "+
+			"while the daemon isn't running)", watches, instances)
+		return r
+	}
+	used := 0
+	for _, h := range health {
+		used += h.Descriptors
+	}
+	r.detail = fmt.Sprintf("%d of %d watches in use by tg (limit %d instances)",
+		used, watches, instances)
+	if float64(used) >= inotifyWarnRatio*float64(watches) {
+		r.outcome = checkWarn
+		if used >= watches {
+			r.outcome = checkFailed
+			r.detail += "; directories over the limit are scanned for writes instead"
+		}
+		r.fix = fmt.Sprintf("raise the limit with 'sudo sysctl "+
+			"fs.inotify.max_user_watches=%d' (and set it in /etc/sysctl.d/ to keep "+
+			"it after a reboot), or limit deep trees with 'tg watch --max-depth'",
+			2*watches)
+	}
+	return r
+}
+
+// checkStateFiles checks that tg's config and state files can be parsed
+func checkStateFiles() []checkResult {
+	var result []checkResult
+	if _, err := config.Read(statusDir); err != nil {
+		result = append(result, checkResult{name: "config", outcome: checkFailed,
+			detail: err.Error(), fix: "fix the file by hand, or move it aside to " +
+				"use the default settings"})
+	}
+	problems, err := status.CheckStateFiles(statusDir)
+	if err != nil {
+		return append(result, checkResult{name: "state files", outcome: checkWarn,
+			detail: err.Error()})
+	}
+	for _, p := range problems {
+		r := checkResult{name: "state files", outcome: checkFailed,
+			detail: fmt.Sprintf("%s: %v", p.Path, p.Err)}
+		switch {
+		case p.MovedAside:
+			r.outcome, r.fix = checkWarn, fmt.Sprintf("inspect it, then rm %s", p.Path)
+		case p.Doc:
+			r.fix = "stop the daemon ('tg daemon stop') and run any tg command (e.g. " +
+				"'tg status'), which moves unreadable state aside"
+		default:
+			r.fix = fmt.Sprintf("fix or remove the bad data, or move the file aside "+
+				"(mv %s %s.corrupt)", p.Path, p.Path)
+		}
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		result = append(result, checkResult{name: "state files", outcome: checkOK,
+			detail: "all can be parsed"})
+	}
+	return result
+}
+
+// checkToken checks that Toggl accepts the token of 'c' (the client of the
+// profile 'name', or of the default account if it's ""), and that the local
+// clock agrees with Toggl's
+func checkToken(name string, c *togglclient.Client, err error) []checkResult {
+	label, login := "token", "tg login"
+	if name != "" {
+		label, login = "token:"+name, "tg login --profile "+name
+	}
+	r := checkResult{name: label}
+	if err != nil {
+		r.outcome, r.detail, r.fix = checkFailed, err.Error(), "store a token with '"+login+"'"
+		return []checkResult{r}
+	}
+	start := time.Now()
+	me, serverTime, err := c.Me(context.Background())
+	rtt := time.Since(start)
+	if apiErr, ok := err.(*togglclient.APIError); ok &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		r.outcome, r.detail = checkFailed, "rejected by Toggl"
+		r.fix = "copy a new token from your Toggl profile page and run '" + login + "'"
+		return []checkResult{r}
+	} else if err != nil {
+		r.outcome, r.detail = checkFailed, err.Error()
+		r.fix = "check your network connection (and any proxy settings)"
+		return []checkResult{r}
+	}
+	r.outcome, r.detail = checkOK, fmt.Sprintf("valid for %s", me.Email)
+	if name != "" {
+		return []checkResult{r} // the clock was checked with the default account
+	}
+
+	clock := checkResult{name: "clock"}
+	if serverTime.IsZero() {
+		clock.detail = "Toggl didn't report its time"
+		return []checkResult{r, clock}
+	}
+	// Toggl's Date is truncated to the second, so compare its midpoint with
+	// the local time halfway through the request
+	skew := serverTime.Add(500 * time.Millisecond).Sub(start.Add(rtt / 2))
+	clock.outcome = checkOK
+	clock.detail = fmt.Sprintf("within %s of Toggl's", (maxClockSkew + rtt).Round(time.Second))
+	if skew > maxClockSkew+rtt || -skew > maxClockSkew+rtt {
+		clock.outcome = checkWarn
+		clock.detail = fmt.Sprintf("%s off from Toggl's, so time entries will be "+
+			"shifted", skew.Round(time.Second))
+		clock.fix = "sync your clock with NTP (e.g. 'sudo timedatectl set-ntp true')"
+	}
+	return []checkResult{r, clock}
+}
+
+// checkTokens checks the tokens of the default account and of every profile,
+// for the Toggl backend
+func checkTokens(cfg config.Config) []checkResult {
+	switch cfg.Backend {
+	case config.BackendLocal:
+		return []checkResult{{name: "token", detail: "not needed by the local backend"}}
+	case config.BackendClockify, config.BackendHarvest:
+		if _, err := newTracker(); err != nil {
+			return []checkResult{{name: "token", outcome: checkFailed, detail: err.Error(),
+				fix: "store a token with 'tg login --tracker " + cfg.Backend + "'"}}
+		}
+		return []checkResult{{name: "token", detail: "found, but not checked with " +
+			cfg.Backend}}
+	}
+	c, err := newClient()
+	result := checkToken(profile, c, err)
+	var names []string
+	for name := range cfg.Profiles {
+		if name != profile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, err := newProfileClient(cfg, name)
+		result = append(result, checkToken(name, c, err)...)
+	}
+	return result
+}
+
+func doctor() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose problems with tg's setup",
+		Long: "Check that the daemon is running and responsive, that no stale " +
+			"lock files would stop it from starting, that the inotify watch limit " +
+			"isn't nearly used up, that tg's config and state files can be parsed, " +
+			"that Toggl accepts your API token(s), and that your clock agrees with " +
+			"Toggl's. A fix is printed for each problem, and the exit status is " +
+			"nonzero if any check fails",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			var results []checkResult
+			daemonResult, running, health := checkDaemon()
+			results = append(results, daemonResult)
+			results = append(results, checkStaleFiles(running)...)
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				cfg = config.Default() // reported by checkStateFiles
+			}
+			results = append(results, checkInotify(cfg, running, health))
+			results = append(results, checkStateFiles()...)
+			if err == nil {
+				results = append(results, checkTokens(cfg)...)
+			}
+
+			failed := 0
+			for _, r := range results {
+				r.print()
+				if r.outcome == checkFailed {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// suggestionError is an error for a mistyped name (e.g. a subcommand or
+// project), along with the known names that are closest to it
+type suggestionError struct {
+	msg         string
+	suggestions []string
+}
+
+func (e *suggestionError) Error() string {
+	if len(e.suggestions) == 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("%s\n\nDid you mean this?\n\t%s", e.msg,
+		strings.Join(e.suggestions, "\n\t"))
+}
+
+// usageError is an error in how a command was invoked (e.g. the wrong number
+// of arguments, or an unknown flag). renderError prints the command's usage
+// after it
+type usageError struct {
+	cmd *cobra.Command
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// unknownCommandError returns the error for 'name', which is not a subcommand
+// of 'cmd'
+func unknownCommandError(cmd *cobra.Command, name string) error {
+	var candidates []string
+	for _, c := range cmd.Commands() {
+		if !c.IsAvailableCommand() {
+			continue
+		}
+		candidates = append(candidates, c.Name())
+		candidates = append(candidates, c.Aliases...)
+	}
+	return &suggestionError{
+		msg: fmt.Sprintf("unknown command %q for %q (see '%s --help')", name,
+			cmd.CommandPath(), cmd.CommandPath()),
+		suggestions: suggest(name, candidates),
+	}
+}
+
+// checkProject returns an error if 'project' isn't one of the projects that tg
+// has seen before (i.e. the projects of its root watches) but is close to one
+// of them, as it's likely a typo. Names that aren't close to any known project
+// are accepted, as they may be new projects
+func checkProject(project string) error {
+	rootWatches, err := status.ReadRootWatches(statusDir)
+	if err != nil {
+		return nil // can't check the name; don't block the command over it
+	}
+	var known []string
+	for _, p := range rootWatches {
+		if p == project {
+			return nil
+		}
+		known = append(known, p)
+	}
+	if suggestions := suggest(project, known); len(suggestions) > 0 {
+		return &suggestionError{
+			msg: fmt.Sprintf("unknown project %q (pass --force to use it "+
+				"anyway)", project),
+			suggestions: suggestions,
+		}
+	}
+	return nil
+}
+
+// maxSuggestions is the largest number of suggestions shown for a name
+const maxSuggestions = 3
+
+// suggest returns the members of 'candidates' that are close to 'input'
+// (within a Levenshtein distance that grows with the length of 'input', or
+// having 'input' as a prefix), nearest first
+func suggest(input string, candidates []string) []string {
+	input = strings.ToLower(input)
+	maxDist := len(input) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		lc := strings.ToLower(c)
+		dist := levenshtein(input, lc)
+		if dist <= maxDist || (input != "" && strings.HasPrefix(lc, input)) {
+			matches = append(matches, match{c, dist})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+	var result []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		result = append(result, matches[i].name)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between 'a' and 'b'
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// renderError writes 'err' to 'w'. All errors returned by tg's commands are
+// printed by renderError, so that they're formatted consistently
+func renderError(w io.Writer, err error) {
+	fmt.Fprintf(w, "Error: %v\n", err)
+	if ue, ok := err.(*usageError); ok {
+		fmt.Fprintf(w, "\n%s", ue.cmd.UsageString())
+	}
+}
+
+// exitWithError prints 'err' with renderError and exits. The exit status is 2
+// for usage errors, as is conventional, and 1 otherwise
+func exitWithError(err error) {
+	renderError(os.Stderr, err)
+	if _, ok := err.(*usageError); ok {
+		os.Exit(2)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/export"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+func exportCmd() *cobra.Command {
+	var (
+		since  = config.Duration(7 * 24 * time.Hour)
+		group  string
+		format string
+		output string
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the blocks of work in tg's local activity log",
+		Long: "Export each block of continuous work on a project, computed from " +
+			"the writes that tg observed (as in 'tg report'), as CSV or JSON (for " +
+			"spreadsheets) or as an iCalendar file (which can be imported into " +
+			"Google Calendar or Outlook to review tracked time)",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			f, err := export.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				return err
+			}
+			end := time.Now()
+			buckets, err := status.ReadActivity(statusDir, end.Add(-time.Duration(since)), end)
+			if err != nil {
+				return err
+			}
+			if group != "" {
+				if buckets, err = inGroup(buckets, group); err != nil {
+					return err
+				}
+			}
+			blocks := status.Blocks(buckets, time.Duration(cfg.IdleTimeout))
+			var w io.Writer = os.Stdout
+			if output != "" && output != "-" {
+				out, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("could not create %q: %v", output, err)
+				}
+				defer out.Close()
+				w = out
+			}
+			return export.Write(w, f, blocks)
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Export activity that started within "+
+		"this duration of now (e.g. \"7d\")")
+	cmd.Flags().StringVar(&group, "group", "", "Only export activity in the "+
+		"directories in this group")
+	cmd.Flags().StringVar(&format, "format", string(export.CSV), "The format "+
+		"of the export (one of csv, json, or ics)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "The file to which the "+
+		"export is written (stdout if unset)")
+	return cmd
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+func groupAdd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <group> <directory...>",
+		Short: "Add watched directories to a group (creating it if necessary)",
+		Long: "Add watched directories to <group>, moving them out of any other " +
+			"group. Groups exist as long as they have at least one directory",
+		Args: ArgsBetween(2, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			group := args[0]
+			err := updateDirs(args[1:], func(rw *status.RootWatch) {
+				rw.Group = group
+			})
+			if err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func groupRemove() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <directory...>",
+		Short: "Remove watched directories from their group",
+		Args:  ArgsBetween(1, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			err := updateDirs(args, func(rw *status.RootWatch) {
+				rw.Group = ""
+			})
+			if err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func groupTag() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tag <group> [tag...]",
+		Short: "Set the tags of every directory in a group",
+		Long: "Replace the tags added to time entries for writes in each " +
+			"directory in <group>. With no tags, the directories' tags are cleared",
+		Args: ArgsBetween(1, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			tags := args[1:]
+			if len(tags) == 0 {
+				tags = nil
+			}
+			err := status.UpdateGroup(statusDir, args[0], func(rw *status.RootWatch) {
+				rw.Tags = tags
+			})
+			if err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func groupProject() *cobra.Command {
+	return &cobra.Command{
+		Use:   "project <group> <project>",
+		Short: "Attribute writes in every directory in a group to a project",
+		Long: "Attribute writes in each directory in <group> to <project> (if " +
+			"there is any existing project with the same name modulo case, that " +
+			"project will be reused, otherwise a new toggl project will be created)",
+		Args: ArgsBetween(2, 2),
+		RunE: RunCommand(func(args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			wid, err := c.Workspace(context.Background())
+			if err != nil {
+				return err
+			}
+			p, err := c.ResolveProject(context.Background(), wid, 0, args[1])
+			if err != nil {
+				return fmt.Errorf("could not resolve project %q: %v", args[1], err)
+			}
+			err = status.UpdateGroup(statusDir, args[0], func(rw *status.RootWatch) {
+				rw.Project = p.Name
+			})
+			if err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func groupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "group",
+		Short: "Manage groups of watched directories",
+		Long: "Group watched directories (e.g. all of a client's repos) so that " +
+			"they can be managed together. Groups can also be passed to 'tg list', " +
+			"'tg disable', 'tg enable', and 'tg report' with --group",
+	}
+	cmd.AddCommand(groupAdd())
+	cmd.AddCommand(groupRemove())
+	cmd.AddCommand(groupTag())
+	cmd.AddCommand(groupProject())
+	return cmd
+}
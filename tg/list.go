@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// listEntry is a line of 'tg list' output: a root watch, and how it's being
+// watched
+type listEntry struct {
+	ID       int      `json:"id"`
+	Group    string   `json:"group,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	status.RootHealth
+}
+
+// state summarizes whether writes under e's directory are being tracked
+func (e *listEntry) state() string {
+	switch {
+	case e.Broken:
+		return "broken"
+	case !e.Exists:
+		return "missing"
+	case e.Disabled:
+		return "disabled"
+	}
+	return "enabled"
+}
+
+// listEntries returns the root watches in 'group' (or all of them, if it's
+// ""), with their health as reported by the daemon. If the daemon isn't
+// running, their backends are those it would use, and they have no inotify
+// watches or events
+func listEntries(group string) (entries []listEntry, daemonRunning bool, err error) {
+	watches, err := status.ListRootWatches(statusDir)
+	if err != nil {
+		return nil, false, err
+	}
+	var health []status.RootHealth
+	switch err := control.Call(statusDir, control.MethodWatchHealth, nil, &health); err {
+	case nil:
+		daemonRunning = true
+	case control.ErrNotRunning:
+	default:
+		return nil, false, err
+	}
+	healthByDir := make(map[string]status.RootHealth, len(health))
+	for _, h := range health {
+		healthByDir[h.Dir] = h
+	}
+	var backend status.Backend
+	if !daemonRunning {
+		cfg, err := config.Read(statusDir)
+		if err != nil {
+			return nil, false, err
+		}
+		if backend, err = status.ParseBackend(cfg.WatchBackend); err != nil {
+			backend = status.DefaultBackend // as the daemon does
+		}
+	}
+	for _, rw := range watches {
+		if group != "" && rw.Group != group {
+			continue
+		}
+		h, ok := healthByDir[rw.Dir]
+		if !ok {
+			h = status.RootHealth{Dir: rw.Dir, Project: rw.Project, Broken: daemonRunning}
+			if !daemonRunning {
+				h.Backend = backend
+				if rw.Backend != "" {
+					h.Backend = rw.Backend
+				}
+			}
+			info, err := os.Stat(rw.Dir)
+			h.Exists = err == nil && info.IsDir()
+		}
+		entries = append(entries, listEntry{
+			ID:         rw.ID,
+			Group:      rw.Group,
+			Disabled:   rw.Disabled,
+			Tags:       rw.Tags,
+			RootHealth: h,
+		})
+	}
+	return entries, daemonRunning, nil
+}
+
+// eventTime formats the time of a root watch's latest event for 'tg list'
+func eventTime(t time.Time) string {
+	switch {
+	case t.IsZero():
+		return "-"
+	case time.Since(t) < 24*time.Hour:
+		return t.Local().Format("15:04:05")
+	}
+	return t.Local().Format("Jan 02 15:04")
+}
+
+func list() *cobra.Command {
+	var (
+		group  string
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the watched directories",
+		Long: "List the watched directories with their projects, and how each " +
+			"is being watched: the watch backend, the number of inotify watches " +
+			"on it and the directories under it (WDS), the time of the latest " +
+			"write observed under it since the daemon started, and whether it " +
+			"still exists. The backend, watches, and events are reported by the " +
+			"daemon, if it's running",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			entries, daemonRunning, err := listEntries(group)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				if entries == nil {
+					entries = []listEntry{}
+				}
+				return json.NewEncoder(os.Stdout).Encode(entries)
+			}
+			fmt.Printf("%-4s %-40s %-24s %-16s %-8s %-8s %-5s %-12s %s\n", "ID",
+				"DIRECTORY", "PROJECT", "GROUP", "STATE", "BACKEND", "WDS", "LAST EVENT",
+				"TAGS")
+			for _, e := range entries {
+				fmt.Printf("%-4d %-40s %-24s %-16s %-8s %-8s %-5d %-12s %s\n", e.ID,
+					e.Dir, e.Project, e.Group, e.state(), e.Backend, e.Descriptors,
+					eventTime(e.LastEvent), strings.Join(e.Tags, ","))
+			}
+			if !daemonRunning {
+				fmt.Println("(the daemon isn't running, so no directories are being " +
+					"watched; start it with 'tg resume')")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&group, "group", "", "Only list the directories in "+
+		"this group")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the directories as JSON")
+	return cmd
+}
+
+func repair() *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair",
+		Short: "Watch any directories that the daemon missed",
+		Long: "Re-walk every watched directory now, rather than waiting for the " +
+			"daemon's periodic check. Subdirectories whose creation the daemon " +
+			"missed (e.g. because inotify's event queue overflowed) are watched, " +
+			"and scanned for writes that weren't seen, and watches on directories " +
+			"that no longer exist are removed",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			var result status.RepairResult
+			err := control.Call(statusDir, control.MethodRepair, nil, &result)
+			if err == control.ErrNotRunning {
+				return fmt.Errorf("the daemon isn't running; its watches are " +
+					"established from scratch when it starts ('tg resume')")
+			} else if err != nil {
+				return err
+			}
+			for _, dir := range result.Added {
+				fmt.Printf("watched %s\n", dir)
+			}
+			for _, dir := range result.Pruned {
+				fmt.Printf("unwatched %s\n", dir)
+			}
+			if len(result.Added) == 0 && len(result.Pruned) == 0 {
+				fmt.Println("watches match the directories on disk")
+			}
+			return nil
+		}),
+	}
+}
+
+func disable() *cobra.Command {
+	var group string
+	cmd := &cobra.Command{
+		Use:   "disable [directory...]",
+		Short: "Ignore writes in watched directories without unwatching them",
+		Long: "Ignore writes in the given watched directories (or, with --group, " +
+			"in every directory in a group) until they're re-enabled with 'tg " +
+			"enable'",
+		Args: ArgsBetween(0, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			return setDisabled(args, group, true)
+		}),
+	}
+	cmd.Flags().StringVar(&group, "group", "", "Disable every directory in "+
+		"this group")
+	return cmd
+}
+
+func enable() *cobra.Command {
+	var group string
+	cmd := &cobra.Command{
+		Use:   "enable [directory...]",
+		Short: "Resume tracking writes in directories disabled by 'tg disable'",
+		Args:  ArgsBetween(0, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			return setDisabled(args, group, false)
+		}),
+	}
+	cmd.Flags().StringVar(&group, "group", "", "Enable every directory in "+
+		"this group")
+	return cmd
+}
+
+// setDisabled disables or enables the watched directories 'dirs', or every
+// directory in 'group'
+func setDisabled(dirs []string, group string, disabled bool) error {
+	var err error
+	switch {
+	case group != "" && len(dirs) > 0:
+		return fmt.Errorf("cannot pass both directories and --group")
+	case group != "":
+		err = status.UpdateGroup(statusDir, group, func(rw *status.RootWatch) {
+			rw.Disabled = disabled
+		})
+	case len(dirs) > 0:
+		err = updateDirs(dirs, func(rw *status.RootWatch) {
+			rw.Disabled = disabled
+		})
+	default:
+		return fmt.Errorf("expected at least one directory, or --group")
+	}
+	if err != nil {
+		return err
+	}
+	return reloadDaemon()
+}
+
+// updateDirs applies 'f' to the root watch of each of 'dirs', failing if any
+// of them isn't watched
+func updateDirs(dirs []string, f func(rw *status.RootWatch)) error {
+	roots, err := status.ReadRootWatches(statusDir)
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]bool)
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("could not resolve %q: %v", dir, err)
+		}
+		if _, ok := roots[abs]; !ok {
+			return fmt.Errorf("%q is not being watched", abs)
+		}
+		selected[abs] = true
+	}
+	return status.UpdateRootWatches(statusDir, func(rw *status.RootWatch) error {
+		if selected[rw.Dir] {
+			f(rw)
+		}
+		return nil
+	})
+}
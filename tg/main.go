@@ -1,80 +1,938 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/credentials"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/logging"
+	"github.com/msteffen/toggl-watcher/persist"
 	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/msteffen/toggl-watcher/tracker"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-const (
-	statusDirectoryEnvVar = "TOGGL_WATCHER_DIRECTORY"
-	watchesDirectory      = "watches"
-)
+const statusDirectoryEnvVar = "TOGGL_WATCHER_DIRECTORY"
 
 // statusDir is the directory where toggl-tool keeps its state. May be set to a
-// temporary directory for tests
-var statusDir = func() string {
-	if dir, ok := os.LookupEnv(statusDirectoryEnvVar); ok {
-		return dir
+// temporary directory for tests. If it can't be determined, statusDirErr is
+// set, and every command fails with it (see checkStatusDir)
+var statusDir, statusDirWarning, statusDirErr = resolveStatusDir()
+
+// workspace is the name or ID of the Toggl workspace that tg uses, if set via
+// --workspace. Otherwise, the configured workspace (or the user's default
+// workspace) is used
+var workspace string
+
+// profile is the name of the profile (see config.Profiles) whose Toggl
+// account tg uses, if set via --profile. Otherwise, the default account is used
+var profile string
+
+// lightweightAnnotation marks commands (in their Annotations) that run often
+// and in the background, e.g. from a shell prompt or completion script. They
+// only read tg's state, so checkStatusDir doesn't set up the state directory
+// (or print anything) before them
+const lightweightAnnotation = "lightweight"
+
+// lightweight is the Annotations of commands marked by lightweightAnnotation
+var lightweight = map[string]string{lightweightAnnotation: "true"}
+
+// checkStatusDir fails if tg has nowhere to keep its state, and otherwise
+// prints a warning if the state directory is in a non-durable location,
+// creates it (or moves it from its old location) if this is tg's first run,
+// and migrates it to the current schema. It runs before every command, but
+// only does the first of these before lightweight ones (see
+// lightweightAnnotation)
+func checkStatusDir(cmd *cobra.Command, _ []string) error {
+	if statusDirErr != nil {
+		return statusDirErr
+	}
+	if cmd.Annotations[lightweightAnnotation] != "" {
+		return nil
+	}
+	if statusDirWarning != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", statusDirWarning)
+	}
+	note, err := initStatusDir()
+	if note != "" {
+		fmt.Fprintf(os.Stderr, "note: %s\n", note)
+	}
+	return err
+}
+
+// newClient returns a Toggl client authenticated with the user's API token(s),
+// which uses the workspace selected by --workspace or the config. If
+// --profile is set, the client is for that profile's account instead (see
+// newProfileClient)
+func newClient() (*togglclient.Client, error) {
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	if profile != "" {
+		c, err := newProfileClient(cfg, profile)
+		if err == nil && workspace != "" {
+			c.SetWorkspace(workspace)
+		}
+		return c, err
+	}
+	read, write, err := credentials.Tokens(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	c := togglclient.NewScoped(read, write)
+	c.SetTimeout(time.Duration(cfg.APITimeout))
+	if workspace != "" {
+		c.SetWorkspace(workspace)
+	} else {
+		c.SetWorkspace(cfg.Workspace)
+	}
+	return c, nil
+}
+
+// newProfileClient returns a Toggl client for the account of the profile
+// 'name' in 'cfg', authenticated with the profile's API token, which uses the
+// profile's workspace
+func newProfileClient(cfg config.Config, name string) (*togglclient.Client, error) {
+	p, err := cfg.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	token, err := credentials.ProfileToken(statusDir, name)
+	if err != nil {
+		return nil, err
+	}
+	c := togglclient.New(token)
+	c.SetTimeout(time.Duration(cfg.APITimeout))
+	c.SetWorkspace(p.Workspace)
+	return c, nil
+}
+
+// profileTags returns the tags of the profile selected by --profile, if any,
+// which are added to the time entries that tg creates in its account
+func profileTags() ([]string, error) {
+	if profile == "" {
+		return nil, nil
+	}
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cfg.Profile(profile)
+	return p.Tags, err
+}
+
+// localOnly returns true if the config's backend is config.BackendLocal, in
+// which case tg records time without Toggl (and doesn't need a token)
+func localOnly() (bool, error) {
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return false, err
+	}
+	return cfg.Backend == config.BackendLocal, nil
+}
+
+// setBackend configures 's' to record time entries the way the daemon would
+// (see daemon.New), for commands that update its state directly because it
+// isn't running: only locally if the config's backend is config.BackendLocal
+// (in which case no token is needed), in the configured tracker if it's
+// another tracker (e.g. Clockify), and otherwise in Toggl, with each profile's
+// entries in that profile's account
+func setBackend(s *status.Status) error {
+	local, err := localOnly()
+	if err != nil {
+		return err
+	}
+	if local {
+		s.SetLocalOnly(true)
+		return nil
+	}
+	t, err := newTracker()
+	if err != nil {
+		return err
+	} else if t != nil {
+		s.SetTracker(t)
+		return nil
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	s.SetClient(c)
+	profiles, err := profileClients()
+	if err != nil {
+		return err
+	}
+	for name, pc := range profiles {
+		s.SetProfileClient(name, pc)
+	}
+	return nil
+}
+
+// profileClients returns a Toggl client (see newProfileClient) for each
+// profile in the config, by name
+func profileClients() (map[string]*togglclient.Client, error) {
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[string]*togglclient.Client)
+	for name := range cfg.Profiles {
+		if clients[name], err = newProfileClient(cfg, name); err != nil {
+			return nil, err
+		}
 	}
-	return path.Join(os.Getenv("HOME"), ".toggle-tool")
-}()
+	return clients, nil
+}
+
+// newTracker returns the time tracker for the config's backend, authenticated
+// with its stored token, if it's a tracker other than Toggl (e.g. Clockify),
+// or nil otherwise
+func newTracker() (tracker.TimeTracker, error) {
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case config.BackendClockify:
+		key, err := credentials.TrackerToken(statusDir, cfg.Backend)
+		if err != nil {
+			return nil, err
+		}
+		ws := cfg.Workspace
+		if workspace != "" {
+			ws = workspace
+		}
+		return tracker.NewClockify(key, ws), nil
+	case config.BackendHarvest:
+		if cfg.HarvestAccountID == "" {
+			return nil, fmt.Errorf("harvest_account_id must be set to use the " +
+				"harvest backend ('tg config set harvest_account_id <id>')")
+		}
+		token, err := credentials.TrackerToken(statusDir, cfg.Backend)
+		if err != nil {
+			return nil, err
+		}
+		return tracker.NewHarvest(token, cfg.HarvestAccountID), nil
+	}
+	return nil, nil
+}
+
+func login() *cobra.Command {
+	var (
+		useKeyring bool
+		scope      string
+		trackerFor string
+	)
+	cmd := &cobra.Command{
+		Use:   "login [api-token]",
+		Short: "Store your Toggl API token for use by tg",
+		Long: "Store your Toggl API token (found at the bottom of your Toggl " +
+			"profile page) in the tg state directory, or in the system keyring if " +
+			"--keyring is set. If no token is passed as an argument, it's read " +
+			"from stdin. If you use limited-scope tokens, store each one with " +
+			"--scope; tg uses the read token for requests that only read from " +
+			"Toggl and the write token for everything else. To store the token " +
+			"for another tracker (see the backend setting), set --tracker. To " +
+			"store the token of another Toggl account, set --profile (which " +
+			"adds the profile to the config, if it's not there yet)",
+		Args: ArgsBetween(0, 1),
+		RunE: RunCommand(func(args []string) error {
+			s, err := credentials.ParseScope(scope)
+			if err != nil {
+				return err
+			}
+			if trackerFor != "" {
+				if scope != "" {
+					return fmt.Errorf("cannot set both --scope and --tracker")
+				}
+				s = credentials.TrackerScope(trackerFor)
+			}
+			if profile != "" {
+				if scope != "" || trackerFor != "" {
+					return fmt.Errorf("cannot set --profile with --scope or --tracker")
+				}
+				if err := addProfile(profile, nil); err != nil {
+					return err
+				}
+				s = credentials.ProfileScope(profile)
+			}
+			var token string
+			if len(args) > 0 {
+				token = args[0]
+			} else {
+				fmt.Fprint(os.Stderr, "Toggl API token: ")
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("could not read API token: %v", err)
+				}
+				token = line
+			}
+			return credentials.Save(statusDir, s, token, useKeyring)
+		}),
+	}
+	cmd.Flags().BoolVar(&useKeyring, "keyring", false, "Store the API token "+
+		"in the system keyring (via secret-tool) instead of a file")
+	cmd.Flags().StringVar(&scope, "scope", "", "If set (to \"read\" or "+
+		"\"write\"), store a limited-scope token that's only used for requests "+
+		"in that scope")
+	cmd.Flags().StringVar(&trackerFor, "tracker", "", "If set (to \""+
+		config.BackendClockify+"\" or \""+config.BackendHarvest+"\"), store the "+
+		"API token for that tracker instead of Toggl")
+	return cmd
+}
 
 func resume() *cobra.Command {
-	return &cobra.Command{
+	opts := daemon.DefaultOptions()
+	cmd := &cobra.Command{
 		Use:   "resume",
 		Short: "Resume watching directories for writes (should run on startup)",
-		Long: "Resume runs in the background, watching the directories indicated " +
-			"in %s/%s for writes and either ends/continues the associated Toggl " +
-			"time entries",
-		Run: BoundedCommand(0, 0, func(_ []string) error { return nil }),
+		Long: "Resume runs in the foreground until killed, watching the " +
+			"directories registered with 'tg watch' for writes and either " +
+			"ends/continues the associated Toggl time entries. If the backend " +
+			"setting is \"local\", time entries are only recorded in tg's " +
+			"journal, and no Toggl token is needed. If it's \"clockify\" or " +
+			"\"harvest\", time entries are recorded there instead of in Toggl " +
+			"(see 'tg login --tracker'). Writes in directories assigned to a " +
+			"profile (see 'tg profile') are recorded in that profile's Toggl account",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			if profile != "" {
+				return fmt.Errorf("--profile can't be set for the daemon, which " +
+					"uses every profile (assign directories to one with 'tg watch " +
+					"--profile')")
+			}
+			local, err := localOnly()
+			if err != nil {
+				return err
+			}
+			if opts.Tracker, err = newTracker(); err != nil {
+				return err
+			}
+			var c *togglclient.Client
+			if !local && opts.Tracker == nil {
+				if c, err = newClient(); err != nil {
+					return err
+				}
+				cfg, err := config.Read(statusDir)
+				if err != nil {
+					return err
+				}
+				opts.Profiles = make(map[string]*togglclient.Client)
+				for name := range cfg.Profiles {
+					if opts.Profiles[name], err = newProfileClient(cfg, name); err != nil {
+						return err
+					}
+				}
+			}
+			d, err := daemon.New(statusDir, c, opts)
+			if err != nil {
+				return err
+			}
+			return d.Run()
+		}),
 	}
+	cmd.Flags().StringVar(&opts.HealthcheckURL, "healthcheck-url", "", "If "+
+		"set, send a GET request to this URL with every heartbeat (e.g. a "+
+		"healthchecks.io check URL)")
+	cmd.Flags().Var(&opts.HeartbeatInterval, "heartbeat-interval", "How often "+
+		"to ping --healthcheck-url (e.g. \"1m\", or \"off\")")
+	cmd.Flags().Var(&opts.LiveUpdateInterval, "live-update-interval", "If set "+
+		"(e.g. \"5m\"), periodically note the time of the latest write in the "+
+		"open time entry's description, so the Toggl web UI reflects live state")
+	cmd.Flags().StringVar(&opts.DailyNoteDir, "daily-note-dir", "", "If set, "+
+		"append each stopped time entry to a markdown note named YYYY-MM-DD.md "+
+		"in this directory (e.g. an Obsidian vault's daily notes folder)")
+	cmd.Flags().StringVar(&opts.TraceEndpoint, "otlp-endpoint", "", "If set "+
+		"(e.g. \"http://localhost:4318\"), export traces of each write's path "+
+		"to Toggl to this OpenTelemetry collector (OTLP/HTTP)")
+	cmd.Flags().StringVar(&opts.MetricsAddr, "metrics-addr", "", "If set "+
+		"(e.g. \"localhost:9100\"), serve internal metrics (queue depths, "+
+		"latencies, error counts) on this address, as expvar JSON at /debug/vars "+
+		"and for Prometheus at /metrics")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Log the requests that "+
+		"would create or change Toggl projects and time entries instead of "+
+		"sending them, and keep tracking state apart from the real state (in "+
+		daemon.DryRunDir+" in the state directory)")
+	cmd.Flags().IntVar(&opts.StatusPort, "status-port", 0, "If set, serve "+
+		"the daemon's state as JSON on localhost at this port (/status, "+
+		"/watches, and /healthz), for status bars such as polybar or waybar")
+	cmd.Flags().StringVar(&opts.ControlReadGroup, "control-read-group", "", "If "+
+		"set, members of this group may query the daemon's status via its "+
+		"control socket (but not start or stop timers). Otherwise only the "+
+		"current user may use it")
+	cmd.Flags().Var(&opts.LogLevel, "log-level", "The least severe messages "+
+		"to log (one of debug, info, warn, error)")
+	cmd.Flags().Var(&opts.LogFormat, "log-format", "The format of each log "+
+		"line (text or json). The log is written to stderr and to "+
+		logging.FileName+" in the state directory")
+	return cmd
 }
 
 func watch() *cobra.Command {
-	return &cobra.Command{
+	var (
+		manifest     string
+		ignores      []string
+		only         []string
+		maxDepth     int
+		noRecursive  bool
+		backend      string
+		pollInterval string
+		description  string
+		tags         []string
+		billable     bool
+		minActivity  string
+		client       string
+		events       []string
+		skip         []string
+	)
+	cmd := &cobra.Command{
 		Use:   "watch <project> <directory>",
 		Short: "Begin watching a new project directory",
 		Long: "Begin watching <directory> for writes, and use those writes to " +
 			"create time events in <project> (if there is any existing project with " +
 			"the same name modulo case, that project will be reused, otherwise a new " +
-			"toggl project will be created)",
-		Run: BoundedCommand(0, 0, func(_ []string) error { return nil }),
+			"toggl project will be created). With --from-file, all of the watches " +
+			"in a manifest file are added at once (see readManifest). In very large " +
+			"trees, --max-depth or --no-recursive limit how many directories are " +
+			"watched; writes deeper than that are found by scanning for modified " +
+			"files every minute instead. On network filesystems (e.g. NFS or " +
+			"sshfs), which don't report writes, --backend=poll scans the whole " +
+			"directory every --poll-interval instead. --description sets a " +
+			"template for the descriptions of the time entries started by writes " +
+			"in <directory>, which may refer to {{.Project}}, {{.Dir}}, " +
+			"{{.GitBranch}}, {{.Files}}, and {{.TopFiles}} (the files written " +
+			"most often) (e.g. \"coding on {{.GitBranch}} in " +
+			"{{.Project}}\"); without it, the description_template setting is " +
+			"used. --tag and --billable set the tags and billable flag of those " +
+			"time entries, and --min-activity overrides the min_activity setting " +
+			"for them. --events sets which kinds of change count as writes (e.g. " +
+			"\"modify\" to ignore files being created and deleted); without it, " +
+			"the watch_events setting is used. --skip sets which heuristics " +
+			"keep directories under <directory> from being watched (by default, " +
+			"hidden directories and Go vendor directories are skipped; \"none\" " +
+			"watches them all). --client assigns <project> to " +
+			"that Toggl client, and --workspace creates it (and the time " +
+			"entries) in that workspace; either is created if it doesn't exist, " +
+			"and both are kept with the watch. --profile sends the time entries " +
+			"to that profile's Toggl account (see 'tg profile'). With " +
+			"--from-file, --workspace and --profile apply to the watches that " +
+			"don't set their own",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if manifest != "" {
+				return nil // <project> and <directory> are rejected below
+			}
+			return ArgsBetween(2, 2)(cmd, args)
+		},
+		RunE: RunCommand(func(args []string) error {
+			var watches []status.RootWatch
+			hasOptions := len(ignores) > 0 || len(only) > 0 || maxDepth != 0 ||
+				noRecursive || backend != "" || pollInterval != "" ||
+				description != "" || len(tags) > 0 || billable || minActivity != "" ||
+				client != "" || len(events) > 0 || len(skip) > 0
+			switch {
+			case manifest != "" && (len(args) > 0 || hasOptions):
+				return fmt.Errorf("cannot pass <project>, <directory>, --ignore, " +
+					"--only, --max-depth, --no-recursive, --backend, --poll-interval, " +
+					"--description, --tag, --billable, --min-activity, --events, " +
+					"--skip, or --client with --from-file (set them in the manifest instead)")
+			case manifest != "":
+				var err error
+				if watches, err = readManifest(manifest); err != nil {
+					return err
+				}
+			default:
+				dir, err := filepath.Abs(args[1])
+				if err != nil {
+					return fmt.Errorf("could not resolve %q: %v", args[1], err)
+				}
+				watches = []status.RootWatch{{
+					Dir:     dir,
+					Project: args[0],
+					RootOptions: status.RootOptions{
+						Excludes:     ignores,
+						Only:         only,
+						MaxDepth:     maxDepth,
+						NoRecursive:  noRecursive,
+						Backend:      status.Backend(backend),
+						PollInterval: pollInterval,
+						Template:     description,
+						Tags:         tags,
+						Billable:     billable,
+						MinActivity:  minActivity,
+						Client:       client,
+						Events:       events,
+						Skip:         skip,
+					},
+				}}
+			}
+			return addWatches(watches, manifest != "" || hasOptions || workspace != "" ||
+				profile != "")
+		}),
 	}
+	cmd.Flags().StringVar(&manifest, "from-file", "", "Add all of the watches "+
+		"in this JSON manifest file, validating all of them before adding any")
+	cmd.Flags().StringArrayVar(&ignores, "ignore", nil, "Ignore writes to "+
+		"paths under <directory> matching this glob (e.g. \"*.tmp\" or "+
+		"\"build\"); may be repeated. Matching directories aren't watched at all")
+	cmd.Flags().StringSliceVar(&only, "only", nil, "Only count writes to "+
+		"files under <directory> whose names match one of these globs "+
+		"(comma-separated, e.g. \"*.go,*.md\"), so that e.g. build artifacts "+
+		"and logs are ignored")
+	cmd.Flags().IntVar(&maxDepth, "max-depth", 0, "Only watch directories up "+
+		"to this many levels below <directory> (0 for no limit)")
+	cmd.Flags().BoolVar(&noRecursive, "no-recursive", false, "Only watch "+
+		"<directory> itself")
+	cmd.Flags().StringVar(&backend, "backend", "", "Set to \"poll\" to find "+
+		"writes under <directory> by scanning it for modified files, rather than "+
+		"with the daemon's watch backend (e.g. on NFS or sshfs)")
+	cmd.Flags().StringVar(&pollInterval, "poll-interval", "", "How often to "+
+		"scan <directory> with --backend=poll (e.g. \"30s\"; default 10s)")
+	cmd.Flags().StringVar(&description, "description", "", "Template for the "+
+		"descriptions of time entries started by writes in <directory> (see above)")
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Add this tag to time "+
+		"entries started by writes in <directory>; may be repeated")
+	cmd.Flags().BoolVar(&billable, "billable", false, "Mark time entries "+
+		"started by writes in <directory> as billable")
+	cmd.Flags().StringVar(&minActivity, "min-activity", "", "Activity in "+
+		"<directory> required before a time entry is started (e.g. \"3/5m\" "+
+		"for three writes within five minutes, or \"2m\" for two minutes of "+
+		"activity); overrides the min_activity setting")
+	cmd.Flags().StringSliceVar(&events, "events", nil, "The kinds of change "+
+		"under <directory> that count as writes (comma-separated; any of "+
+		strings.Join(status.EventClassNames, ", ")+")")
+	cmd.Flags().StringSliceVar(&skip, "skip", nil, "The heuristics that keep "+
+		"directories under <directory> from being watched (comma-separated; "+
+		"\"none\", or any of "+strings.Join(status.SkipHeuristicNames, ", ")+
+		"; default all)")
+	cmd.Flags().StringVar(&client, "client", "", "Assign <project> to this "+
+		"Toggl client, creating it if it doesn't exist")
+	return cmd
 }
 
-func tick() *cobra.Command {
+// addWatches validates 'watches', resolves (or creates) their Toggl projects,
+// and asks the daemon to start watching them. If 'batch' is false, 'watches'
+// must contain exactly one watch with no options, which is added via the
+// simpler MethodWatch
+func addWatches(watches []status.RootWatch, batch bool) error {
+	for i := range watches {
+		if err := watches[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Resolve (or create) the projects in the tracker, unless time is only
+	// recorded locally
+	local, err := localOnly()
+	if err != nil {
+		return err
+	}
+	t, err := newTracker()
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		for i := range watches {
+			if watches[i].Client != "" || watches[i].Workspace != "" ||
+				watches[i].Profile != "" || profile != "" {
+				return fmt.Errorf("a client, workspace, or profile can't be set "+
+					"for %q, as they're only supported by the toggl backend",
+					watches[i].Dir)
+			}
+			if _, err := t.EnsureProject(context.Background(), watches[i].Project); err != nil {
+				return fmt.Errorf("could not resolve %s project %q: %v", t.Name(),
+					watches[i].Project, err)
+			}
+		}
+	} else if !local {
+		cfg, err := config.Read(statusDir)
+		if err != nil {
+			return err
+		}
+		clients := make(map[string]*togglclient.Client) // by profile
+		for i := range watches {
+			if watches[i].Profile == "" {
+				watches[i].Profile = profile
+			}
+			c, ok := clients[watches[i].Profile]
+			if !ok {
+				if watches[i].Profile == "" {
+					c, err = newClient()
+				} else {
+					c, err = newProfileClient(cfg, watches[i].Profile)
+				}
+				if err != nil {
+					return err
+				}
+				clients[watches[i].Profile] = c
+			}
+			if err := resolveWatchProject(c, &watches[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Ask the daemon to start watching the directories (or, if it's not
+	// running, persist the watches so they're picked up by the next 'tg
+	// resume')
+	if !batch {
+		err = control.Call(statusDir, control.MethodWatch, control.WatchParams{
+			Dir: watches[0].Dir, Project: watches[0].Project,
+		}, nil)
+	} else {
+		err = control.Call(statusDir, control.MethodWatchBatch,
+			control.WatchBatchParams{Watches: watches}, nil)
+	}
+	if err != control.ErrNotRunning {
+		return err
+	}
+	if err := persist.MakeStateDir(statusDir); err != nil {
+		return err
+	}
+	if err := status.SaveRootWatches(statusDir, watches); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%v; %d director(ies) will be watched once 'tg "+
+		"resume' is started\n", err, len(watches))
+	return nil
+}
+
+// resolveWatchProject resolves (or creates) the Toggl workspace, client, and
+// project of 'rw', and sets them in 'rw' as they're named in Toggl. If
+// --workspace is passed, it's the workspace of watches that don't set one
+func resolveWatchProject(c *togglclient.Client, rw *status.RootWatch) error {
+	ctx := context.Background()
+	if rw.Workspace == "" {
+		rw.Workspace = workspace
+	}
+	wid, err := c.Workspace(ctx)
+	if rw.Workspace != "" {
+		var ws *togglclient.Workspace
+		if ws, err = c.FindWorkspace(ctx, rw.Workspace); err == nil {
+			wid, rw.Workspace = ws.ID, ws.Name
+		}
+	}
+	if err != nil {
+		return err
+	}
+	var clientID int64
+	if rw.Client != "" {
+		cu, err := c.ResolveCustomer(ctx, wid, rw.Client)
+		if err != nil {
+			return fmt.Errorf("could not resolve client %q: %v", rw.Client, err)
+		}
+		clientID, rw.Client = cu.ID, cu.Name
+	}
+	p, err := c.ResolveProject(ctx, wid, clientID, rw.Project)
+	if err != nil {
+		return fmt.Errorf("could not resolve project %q: %v", rw.Project, err)
+	}
+	rw.Project = p.Name
+	return nil
+}
+
+func unwatch() *cobra.Command {
+	var id int
+	cmd := &cobra.Command{
+		Use:   "unwatch <directory>",
+		Short: "Stop watching a project directory",
+		Long: "Stop watching <directory> (or, with --id, the directory with that " +
+			"ID in 'tg list') for writes. If the open time entry belongs to a " +
+			"project that's no longer watched in any directory, it is stopped",
+		Args: ArgsBetween(0, 1),
+		RunE: RunCommand(func(args []string) error {
+			var dir string
+			switch {
+			case id != 0 && len(args) > 0:
+				return fmt.Errorf("cannot pass both a directory and --id")
+			case id != 0:
+				rw, err := status.RootWatchByID(statusDir, id)
+				if err != nil {
+					return err
+				}
+				dir = rw.Dir
+			case len(args) > 0:
+				var err error
+				if dir, err = filepath.Abs(args[0]); err != nil {
+					return fmt.Errorf("could not resolve %q: %v", args[0], err)
+				}
+			default:
+				return fmt.Errorf("expected a directory, or --id")
+			}
+			err := control.Call(statusDir, control.MethodUnwatch,
+				control.UnwatchParams{Dir: dir}, nil)
+			if err != control.ErrNotRunning {
+				return err
+			}
+
+			// The daemon isn't running; update its state directly
+			project, err := status.RemoveRootWatch(statusDir, dir)
+			if err != nil {
+				return err
+			}
+			s, err := status.Read(statusDir)
+			if os.IsNotExist(err) {
+				return nil // no time entries have been created
+			} else if err != nil {
+				return err
+			}
+			if s.TimeEntryID() != 0 {
+				if err := setBackend(s); err != nil {
+					return err
+				}
+			}
+			roots, err := status.ReadRootWatches(statusDir)
+			if err != nil {
+				return err
+			}
+			return daemon.StopOrphanedEntry(s, project, roots)
+		}),
+	}
+	cmd.Flags().IntVar(&id, "id", 0, "Stop watching the directory with this ID "+
+		"(see 'tg list'), e.g. one that no longer exists")
+	return cmd
+}
+
+func stop() *cobra.Command {
 	return &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the open time entry",
+		Long: "Stop the open Toggl time entry now (e.g. when leaving for lunch, or " +
+			"switching to untracked work). A new entry is started by the next " +
+			"write in a watched directory",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			var result control.StopResult
+			err := control.Call(statusDir, control.MethodStop, nil, &result)
+			if err == control.ErrNotRunning {
+				// The daemon isn't running; update its state directly
+				s, err := status.Read(statusDir)
+				if os.IsNotExist(err) {
+					fmt.Println("no open time entry")
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := setBackend(s); err != nil {
+					return err
+				}
+				if result, err = daemon.StopNow(s); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+			if result.TimeEntryID == 0 {
+				fmt.Println("no open time entry")
+			} else {
+				fmt.Printf("stopped time entry %d\n", result.TimeEntryID)
+			}
+			return nil
+		}),
+	}
+}
+
+func detour() *cobra.Command {
+	var p control.DetourParams
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "detour [project]",
+		Short: "Mark subsequent work as a temporary detour",
+		Long: "Mark subsequent work as a temporary detour (e.g. reviewing a " +
+			"colleague's repo) until 'tg detour --end' is run or you go idle. If " +
+			"[project] is given, all work during the detour is attributed to it; " +
+			"otherwise work is attributed as usual but marked non-billable. Watch " +
+			"configuration is not changed",
+		Args: ArgsBetween(0, 1),
+		RunE: RunCommand(func(args []string) error {
+			if len(args) > 0 {
+				if p.End {
+					return fmt.Errorf("cannot pass a project with --end")
+				}
+				if !force {
+					if err := checkProject(args[0]); err != nil {
+						return err
+					}
+				}
+				p.Project = args[0]
+			}
+			err := control.Call(statusDir, control.MethodDetour, p, nil)
+			if err != control.ErrNotRunning {
+				return err
+			}
+			// The daemon isn't running; update its state directly
+			s, err := status.Read(statusDir)
+			if os.IsNotExist(err) {
+				s = status.New(statusDir)
+			} else if err != nil {
+				return err
+			}
+			daemon.SetDetour(s, p)
+			return s.Save()
+		}),
+	}
+	cmd.Flags().BoolVar(&p.End, "end", false, "End the current detour")
+	cmd.Flags().BoolVar(&force, "force", false, "Use [project] even if it "+
+		"looks like a typo of a known project")
+	return cmd
+}
+
+func tick() *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
 		Use:   "tick <project>",
 		Short: "Note work on a project (same as receiving a write notification)",
 		Long:  "Advance the \"working\" timestamp, and possibly switch projects",
-		Run: BoundedCommand(1, 1, func(args []string) error {
+		Args:  ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			if !force {
+				if err := checkProject(args[0]); err != nil {
+					return err
+				}
+			}
+			// Prefer to tick via the daemon, so that its state stays authoritative
+			err := control.Call(statusDir, control.MethodTick,
+				control.TickParams{Project: args[0], Profile: profile}, nil)
+			if err != control.ErrNotRunning {
+				return err
+			}
 			s, err := status.Read(statusDir)
+			if os.IsNotExist(err) {
+				s = status.New(statusDir)
+			} else if err != nil {
+				return err
+			}
+			if err := setBackend(s); err != nil {
+				return err
+			}
+			cfg, err := config.Read(statusDir)
 			if err != nil {
 				return err
 			}
-			return s.Tick(args[0])
+			roots, err := status.ListRootWatches(statusDir)
+			if err != nil {
+				return err
+			}
+			return s.TickWith(args[0],
+				daemon.ProjectEntryOptions(s, cfg, roots, args[0], profile))
 		}),
 	}
+	cmd.Flags().BoolVar(&force, "force", false, "Tick <project> even if it "+
+		"looks like a typo of a known project")
+	return cmd
+}
+
+func gaps() *cobra.Command {
+	since := config.Duration(7 * 24 * time.Hour)
+	cmd := &cobra.Command{
+		Use:   "gaps",
+		Short: "List periods in which the tg daemon wasn't running",
+		Long: "List periods in which the tg daemon (tg resume) wasn't running, " +
+			"and so any work done in watched directories wasn't tracked",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			gaps, err := status.ReadGaps(statusDir,
+				time.Now().Add(-time.Duration(since)))
+			if err != nil {
+				return err
+			}
+			if len(gaps) == 0 {
+				fmt.Printf("no untracked windows in the last %s\n", since)
+			}
+			for _, g := range gaps {
+				fmt.Println(g)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Show gaps that ended within this "+
+		"duration of now (e.g. \"7d\")")
+	return cmd
 }
 
 func main() {
 	rootCommand := &cobra.Command{
 		Use:   "tg",
-		Short: "track time in toggl by watching project directories with inotify",
-		Long: "tg uses inotify to watch directories that you indicate (in which " +
-			"you're doing work). Based on writes under those dirs, tg creates and " +
-			"updates projects and time entries in toggl",
+		Short: "track time in toggl by watching project directories for writes",
+		Long: "tg watches directories that you indicate (in which you're doing " +
+			"work), with inotify on Linux or by scanning them for modified files " +
+			"elsewhere. Based on writes under those dirs, tg creates and updates " +
+			"projects and time entries in toggl",
+		PersistentPreRunE: checkStatusDir,
+		// Errors are printed by renderError, which adds its own suggestions
+		SilenceErrors:      true,
+		SilenceUsage:       true,
+		DisableSuggestions: true,
 	}
+	rootCommand.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		if err == pflag.ErrHelp {
+			return err // cobra prints help
+		}
+		return &usageError{cmd: cmd, err: err}
+	})
+	rootCommand.PersistentFlags().StringVar(&workspace, "workspace", "", "The "+
+		"name or ID of the Toggl workspace to use (overrides the 'workspace' "+
+		"config setting; defaults to your default Toggl workspace)")
+	rootCommand.PersistentFlags().StringVar(&profile, "profile", "", "The "+
+		"profile (Toggl account) to use, instead of the default account (see "+
+		"'tg profile')")
+	rootCommand.AddCommand(initCmd())
 	rootCommand.AddCommand(tick())
+	rootCommand.AddCommand(statusCmd())
+	rootCommand.AddCommand(stop())
+	rootCommand.AddCommand(detour())
+	rootCommand.AddCommand(run())
+	rootCommand.AddCommand(pause())
+	rootCommand.AddCommand(resumeTracking())
 	rootCommand.AddCommand(watch())
+	rootCommand.AddCommand(unwatch())
+	rootCommand.AddCommand(discover())
+	rootCommand.AddCommand(list())
+	rootCommand.AddCommand(repair())
+	rootCommand.AddCommand(disable())
+	rootCommand.AddCommand(enable())
+	rootCommand.AddCommand(groupCmd())
+	rootCommand.AddCommand(profileCmd())
 	rootCommand.AddCommand(resume())
+	rootCommand.AddCommand(installService())
+	rootCommand.AddCommand(daemonCmd())
+	rootCommand.AddCommand(doctor())
+	rootCommand.AddCommand(login())
+	rootCommand.AddCommand(gaps())
+	rootCommand.AddCommand(addEntry())
+	rootCommand.AddCommand(editEntry())
+	rootCommand.AddCommand(verify())
+	rootCommand.AddCommand(syncCmd())
+	rootCommand.AddCommand(report())
+	rootCommand.AddCommand(exportCmd())
+	rootCommand.AddCommand(pending())
+	rootCommand.AddCommand(approve())
+	rootCommand.AddCommand(canary())
+	rootCommand.AddCommand(demo())
+	rootCommand.AddCommand(soak())
+	rootCommand.AddCommand(shellHook())
+	rootCommand.AddCommand(shellActivity())
+	rootCommand.AddCommand(configCmd())
+	rootCommand.AddCommand(completion(rootCommand))
+	rootCommand.AddCommand(complete())
+	// Check for a mistyped subcommand before cobra does, so that it can be
+	// reported with suggestions
+	rootCommand.InitDefaultHelpCmd()
+	if _, _, err := rootCommand.Find(os.Args[1:]); err != nil &&
+		len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		exitWithError(unknownCommandError(rootCommand, os.Args[1]))
+	}
 	if err := rootCommand.Execute(); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		exitWithError(err)
 	}
-
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// manifest is the format of the file passed to 'tg watch --from-file'. For
+// example:
+//
+//	{
+//	  "watches": [
+//	    {"dir": "~/src/tg", "project": "toggl-watcher", "excludes": [".git"],
+//	     "only": ["*.go", "*.md"]},
+//	    {"dir": "~/src/monorepo", "project": "Work", "max_depth": 2,
+//	     "min_activity": "3/5m", "events": ["modify", "rename"]},
+//	    {"dir": "~/mnt/devbox/src", "project": "Work", "backend": "poll",
+//	     "poll_interval": "30s", "skip": ["vendor"]},
+//	    {"dir": "clients/acme", "project": "Website", "client": "Acme",
+//	     "workspace": "Consulting", "profile": "acme", "tags": ["deepwork"],
+//	     "billable": true,
+//	     "template": "Acme: {{.Dir}}",
+//	     "redactions": [{"pattern": "/clients/acme", "replacement": "~"}]}
+//	  ]
+//	}
+//
+// Relative directories are resolved relative to the manifest file's directory
+type manifest struct {
+	Watches []status.RootWatch `json:"watches"`
+}
+
+// readManifest reads the manifest file at 'manifestPath' and returns its
+// watches, with their directories made absolute
+func readManifest(manifestPath string) ([]status.RootWatch, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open manifest: %v", err)
+	}
+	defer f.Close()
+	var m manifest
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields() // catch typos, e.g. "exclude" vs "excludes"
+	if err := d.Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %q: %v", manifestPath, err)
+	}
+	if len(m.Watches) == 0 {
+		return nil, fmt.Errorf("manifest %q contains no watches", manifestPath)
+	}
+	base, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %q: %v", manifestPath, err)
+	}
+	seen := make(map[string]bool)
+	for i := range m.Watches {
+		dir := m.Watches[i].Dir
+		if dir == "~" || strings.HasPrefix(dir, "~/") {
+			dir = filepath.Join(os.Getenv("HOME"), dir[1:])
+		} else if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			return nil, fmt.Errorf("%q appears more than once in manifest %q", dir,
+				manifestPath)
+		}
+		seen[dir] = true
+		m.Watches[i].Dir = dir
+	}
+	return m.Watches, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/tracker"
+	"github.com/spf13/cobra"
+)
+
+// parseTime parses the time 'value' given to 'tg add' or 'tg edit', which may
+// be "now", a duration before 'now' (e.g. "2h ago"), or any time accepted by
+// parseEntryTime (where "15:04" is on the same day as 'day')
+func parseTime(value string, now, day time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return now, nil
+	}
+	if strings.HasSuffix(value, " ago") {
+		d, err := config.ParseDuration(strings.TrimSuffix(value, " ago"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %v", value, err)
+		}
+		return now.Add(-time.Duration(d)), nil
+	}
+	return parseEntryTime(value, day)
+}
+
+// parseRange parses 'value' as a time range "<start>-<end>" (e.g.
+// "14:00-15:30"), where the start is accepted by parseTime and the end by
+// parseEnd. Times of day are today. It returns false if 'value' isn't a range
+func parseRange(value string, now time.Time) (start, end time.Time, ok bool) {
+	// Times may contain '-' themselves (e.g. "2006-01-02 15:04"), so try every
+	// split until both halves parse
+	for i := strings.Index(value, "-"); i >= 0; {
+		s, serr := parseTime(value[:i], now, now)
+		e, eerr := parseEnd(value[i+1:], s, now)
+		if serr == nil && eerr == nil {
+			return s, e, true
+		}
+		j := strings.Index(value[i+1:], "-")
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// parseEnd parses the end of a time entry that began at 'start', which may be
+// a time accepted by parseTime (where "15:04" is on the same day as 'start')
+// or the entry's duration (e.g. "45m")
+func parseEnd(value string, start, now time.Time) (time.Time, error) {
+	if d, err := config.ParseDuration(value); err == nil && d > 0 {
+		return start.Add(time.Duration(d)), nil
+	}
+	return parseTime(value, now, start)
+}
+
+// splitTags splits the comma-separated tags in 'value'
+func splitTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// printEntry prints the manually added or edited time entry 'e'
+func printEntry(verb string, e status.StoppedEntry) {
+	start := e.Start.In(time.Local)
+	line := fmt.Sprintf("%s %s-%s (%s) %s", verb, start.Format("2006-01-02 15:04"),
+		e.Stop.In(time.Local).Format("15:04"), e.Stop.Sub(e.Start).Round(time.Minute),
+		e.Project)
+	if e.Description != "" {
+		line += fmt.Sprintf(" %q", e.Description)
+	}
+	if len(e.Tags) > 0 {
+		line += " [" + strings.Join(e.Tags, ", ") + "]"
+	}
+	switch {
+	case e.TimeEntryID != 0:
+		line += fmt.Sprintf(" (Toggl ID %d)", e.TimeEntryID)
+	case e.TrackerEntryID != "":
+		line += fmt.Sprintf(" (ID %s)", e.TrackerEntryID)
+	default:
+		line += " (local)"
+	}
+	fmt.Println(line)
+}
+
+// addTracked records 'e' in the tracker 't' (rather than Toggl), and in the
+// journal
+func addTracked(t tracker.TimeTracker, e status.StoppedEntry) (status.StoppedEntry, error) {
+	ctx := context.Background()
+	projectID, err := t.EnsureProject(ctx, e.Project)
+	if err != nil {
+		return e, fmt.Errorf("could not resolve %s project %q: %v", t.Name(), e.Project, err)
+	}
+	id, err := t.StartEntry(ctx, tracker.Entry{
+		ProjectID:   projectID,
+		Description: e.Description,
+		Tags:        e.Tags,
+		Billable:    e.Billable,
+		Start:       e.Start,
+	})
+	if err != nil {
+		return e, fmt.Errorf("could not create %s time entry: %v", t.Name(), err)
+	}
+	if err := t.StopEntry(ctx, id, e.Start, e.Stop); err != nil {
+		return e, fmt.Errorf("could not stop %s time entry %s: %v", t.Name(), id, err)
+	}
+	e.TrackerEntryID = id
+	return e, status.AppendJournal(statusDir, e)
+}
+
+func addEntry() *cobra.Command {
+	var (
+		tags     string
+		billable bool
+	)
+	cmd := &cobra.Command{
+		Use:   "add <project> <start> <end> [description]",
+		Short: "Record a time entry for work that tg didn't see",
+		Long: "Record a time entry (e.g. for a meeting) in the tracker and in tg's " +
+			"journal. <start> and <end> may be \"now\", a duration before now " +
+			"(e.g. \"2h ago\"), a time today (\"14:00\"), a date and time " +
+			"(\"2006-01-02 15:04\"), or RFC 3339, and <end> may also be the " +
+			"entry's duration (e.g. \"45m\"). Both may be given as a single range " +
+			"instead (e.g. 'tg add standup 9:30-9:45 \"daily standup\"'). If the " +
+			"backend is local, the entry is only recorded in the journal (see " +
+			"'tg sync'). With --profile, the entry is recorded in that profile's " +
+			"Toggl account",
+		Args: ArgsBetween(2, 4),
+		RunE: RunCommand(func(args []string) error {
+			now := time.Now().Truncate(time.Second)
+			e := status.StoppedEntry{Project: args[0], Billable: billable}
+			var rest []string
+			if start, stop, ok := parseRange(args[1], now); ok {
+				e.Start, e.Stop, rest = start, stop, args[2:]
+			} else if len(args) < 3 {
+				return fmt.Errorf("expected <start> <end> or a range " +
+					"(e.g. \"14:00-15:30\")")
+			} else {
+				var err error
+				if e.Start, err = parseTime(args[1], now, now); err != nil {
+					return err
+				}
+				if e.Stop, err = parseEnd(args[2], e.Start, now); err != nil {
+					return err
+				}
+				rest = args[3:]
+			}
+			if len(rest) > 1 {
+				return fmt.Errorf("unexpected argument %q (quote the description if "+
+					"it contains spaces)", rest[1])
+			} else if len(rest) == 1 {
+				e.Description = rest[0]
+			}
+			if tags != "" {
+				e.Tags = splitTags(tags)
+			}
+			ptags, err := profileTags()
+			if err != nil {
+				return err
+			}
+			e.Tags, e.Profile, e.Workspace = append(e.Tags, ptags...), profile, workspace
+
+			local, err := localOnly()
+			if err != nil {
+				return err
+			}
+			t, err := newTracker()
+			if err != nil {
+				return err
+			}
+			switch {
+			case t != nil:
+				e, err = addTracked(t, e)
+			case local:
+				e, err = status.AddEntry(context.Background(), statusDir, nil, nil, e)
+			default:
+				c, cerr := newClient()
+				if cerr != nil {
+					return cerr
+				}
+				profiles, cerr := profileClients()
+				if cerr != nil {
+					return cerr
+				}
+				e, err = status.AddEntry(context.Background(), statusDir, c, profiles, e)
+			}
+			if err != nil {
+				return err
+			}
+			printEntry("added", e)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&tags, "tags", "", "Tag the entry (comma-separated)")
+	cmd.Flags().BoolVar(&billable, "billable", false, "Mark the entry billable")
+	return cmd
+}
+
+func editEntry() *cobra.Command {
+	var (
+		project, description, tags, start, stop string
+		billable                                bool
+
+		cmd *cobra.Command
+	)
+	cmd = &cobra.Command{
+		Use:   "edit <entry-id>",
+		Short: "Change a stopped time entry",
+		Long: "Change a stopped time entry in Toggl and in tg's journal. " +
+			"<entry-id> is the entry's Toggl ID or, for entries that were only " +
+			"recorded locally (see 'tg config set backend local'), the time at " +
+			"which the entry started (e.g. \"14:00\"). Times accept the same " +
+			"formats as 'tg add', and are on the same day as the entry if only a " +
+			"time of day is given",
+		Args: ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			flags := cmd.Flags()
+			var edit status.EntryEdit
+			if flags.Changed("project") {
+				edit.Project = &project
+			}
+			if flags.Changed("description") {
+				edit.Description = &description
+			}
+			if flags.Changed("tags") {
+				edit.Tags = splitTags(tags)
+			}
+			if flags.Changed("billable") {
+				edit.Billable = &billable
+			}
+			// Times of day are on the same day as the entry, which is found by
+			// its ID (or, for local entries, is the day of its start)
+			now := time.Now().Truncate(time.Second)
+			setTimes := func(day time.Time) error {
+				if flags.Changed("start") {
+					t, err := parseTime(start, now, day)
+					if err != nil {
+						return err
+					}
+					edit.Start = &t
+				}
+				if flags.Changed("stop") {
+					from := day
+					if edit.Start != nil {
+						from = *edit.Start
+					}
+					t, err := parseEnd(stop, from, now)
+					if err != nil {
+						return err
+					}
+					edit.Stop = &t
+				}
+				return nil
+			}
+
+			var (
+				e   status.StoppedEntry
+				err error
+			)
+			if id, perr := strconv.ParseInt(args[0], 10, 64); perr == nil {
+				local, err := localOnly()
+				if err != nil {
+					return err
+				}
+				t, err := newTracker()
+				if err != nil {
+					return err
+				}
+				if local || t != nil {
+					return fmt.Errorf("only Toggl entries can be edited by ID; edit " +
+						"a local entry by its start time (e.g. 'tg edit 14:00')")
+				}
+				c, err := newClient()
+				if err != nil {
+					return err
+				}
+				ctx := context.Background()
+				current, err := c.GetTimeEntry(ctx, id)
+				if err != nil {
+					return fmt.Errorf("could not get time entry %d: %v", id, err)
+				}
+				if err := setTimes(current.Start); err != nil {
+					return err
+				}
+				e, err = status.EditEntry(ctx, statusDir, c, id, edit)
+			} else {
+				entryStart, perr := parseTime(args[0], now, now)
+				if perr != nil {
+					return fmt.Errorf("invalid entry ID %q (expected a Toggl ID or the "+
+						"start time of a local entry)", args[0])
+				}
+				if err := setTimes(entryStart); err != nil {
+					return err
+				}
+				e, err = status.EditLocalEntry(statusDir, entryStart, edit)
+			}
+			if err != nil {
+				return err
+			}
+			printEntry("edited", e)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&project, "project", "", "Attribute the entry to this project")
+	cmd.Flags().StringVar(&description, "description", "", "Replace the "+
+		"entry's description")
+	cmd.Flags().StringVar(&tags, "tags", "", "Replace the entry's tags "+
+		"(comma-separated)")
+	cmd.Flags().BoolVar(&billable, "billable", false, "Mark the entry billable "+
+		"(or not, with --billable=false)")
+	cmd.Flags().StringVar(&start, "start", "", "Change the entry's start time "+
+		"(e.g. \"09:30\", on the same day)")
+	cmd.Flags().StringVar(&stop, "stop", "", "Change the entry's stop time "+
+		"(e.g. \"17:45\", on the same day) or duration (e.g. \"45m\")")
+	return cmd
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+)
+
+// TestOfflineLocalBackend checks that 'tg tick' and 'tg stop' work without the
+// daemon when the backend is local, which needs no Toggl token
+func TestOfflineLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tg-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statusDir = d }(statusDir)
+	statusDir = dir
+	c := config.Default()
+	c.Backend = config.BackendLocal
+	if err := c.Save(dir); err != nil {
+		t.Fatalf("could not save config: %v", err)
+	}
+
+	if err := tick().RunE(nil, []string{"proj"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	s, err := status.Read(dir)
+	if err != nil {
+		t.Fatalf("could not read tick state: %v", err)
+	}
+	if s.TimeEntryID() == 0 || s.Project() != "proj" {
+		t.Fatalf("expected an open entry for \"proj\", but got %d for %q",
+			s.TimeEntryID(), s.Project())
+	}
+
+	if err := stop().RunE(nil, nil); err != nil {
+		t.Fatalf("could not stop: %v", err)
+	}
+	if s, err = status.Read(dir); err != nil {
+		t.Fatalf("could not read tick state: %v", err)
+	}
+	if s.TimeEntryID() != 0 {
+		t.Fatalf("expected no open entry, but got %d", s.TimeEntryID())
+	}
+}
+
+// TestOfflineTickRootOptions checks that 'tg tick' without the daemon records
+// activity with the options of the project's root watch, so that ticking a
+// project in a profile doesn't switch the open entry to the default account
+func TestOfflineTickRootOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tg-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statusDir = d }(statusDir)
+	statusDir = path.Join(dir, "state")
+	root := path.Join(dir, "root")
+	for _, d := range []string{statusDir, root} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatalf("could not create %q: %v", d, err)
+		}
+	}
+	c := config.Default()
+	c.Backend = config.BackendLocal
+	c.Profiles = map[string]config.Profile{"client-a": {Tags: []string{"a"}}}
+	if err := c.Save(statusDir); err != nil {
+		t.Fatalf("could not save config: %v", err)
+	}
+	if err := status.SaveRootWatches(statusDir, []status.RootWatch{{
+		Dir:         root,
+		Project:     "proj",
+		RootOptions: status.RootOptions{Profile: "client-a"},
+	}}); err != nil {
+		t.Fatalf("could not save root watch: %v", err)
+	}
+
+	if err := tick().RunE(nil, []string{"proj"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	s, err := status.Read(statusDir)
+	if err != nil {
+		t.Fatalf("could not read tick state: %v", err)
+	}
+	opts := status.EntryOptions{Profile: "client-a"}
+	if s.TimeEntryID() == 0 || !s.SameProject("proj", opts) {
+		t.Fatalf("expected an open entry for \"proj\" in profile \"client-a\"")
+	}
+	start := s.EntryStart()
+
+	// A second tick continues the entry, rather than starting one in the
+	// default account
+	if err := tick().RunE(nil, []string{"proj"}); err != nil {
+		t.Fatalf("could not tick: %v", err)
+	}
+	if s, err = status.Read(statusDir); err != nil {
+		t.Fatalf("could not read tick state: %v", err)
+	}
+	if !s.SameProject("proj", opts) || !s.EntryStart().Equal(start) {
+		t.Fatalf("expected the entry started at %s to stay open, but got one "+
+			"started at %s", start, s.EntryStart())
+	}
+}
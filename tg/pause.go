@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/spf13/cobra"
+)
+
+// parsePauseDuration parses the argument of 'tg pause': a number of minutes,
+// or a duration such as "1h30m"
+func parsePauseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q: must be positive", s)
+		}
+		return time.Duration(n) * time.Minute, nil
+	}
+	d, err := config.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q: must be positive", s)
+	}
+	return time.Duration(d), nil
+}
+
+func pause() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause [<minutes> | <duration>]",
+		Short: "Suspend automatic tracking",
+		Long: "Stop translating writes and window titles into time entries, " +
+			"either for <minutes> (or a duration such as \"1h30m\") or until 'tg " +
+			"resume-tracking'. The open time entry is stopped now. Watched " +
+			"directories stay watched while tracking is suspended",
+		Args: ArgsBetween(0, 1),
+		RunE: RunCommand(func(args []string) error {
+			var p control.SuspendParams
+			if len(args) == 1 {
+				d, err := parsePauseDuration(args[0])
+				if err != nil {
+					return err
+				}
+				p.Until = time.Now().Add(d)
+			}
+			err := control.Call(statusDir, control.MethodSuspend, p, nil)
+			if err == control.ErrNotRunning {
+				return fmt.Errorf("tg daemon is not running; nothing to pause")
+			} else if err != nil {
+				return fmt.Errorf("could not suspend tracking: %v", err)
+			}
+			if p.Until.IsZero() {
+				fmt.Println("tracking suspended until 'tg resume-tracking'")
+			} else {
+				fmt.Printf("tracking suspended until %s\n", p.Until.Format("15:04"))
+			}
+			return nil
+		}),
+	}
+}
+
+func resumeTracking() *cobra.Command {
+	var afterHours bool
+	cmd := &cobra.Command{
+		Use:   "resume-tracking",
+		Short: "Resume automatic tracking suspended by 'tg pause'",
+		Long: "Resume automatic tracking suspended by 'tg pause'. With " +
+			"--after-hours, writes outside working hours (see 'tg config set " +
+			"work_hours') are also tracked, until working hours begin again",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(args []string) error {
+			p := control.SuspendParams{End: true, AfterHours: afterHours}
+			err := control.Call(statusDir, control.MethodSuspend, p, nil)
+			if err == control.ErrNotRunning {
+				return fmt.Errorf("tg daemon is not running; nothing to resume")
+			} else if err != nil {
+				return fmt.Errorf("could not resume tracking: %v", err)
+			}
+			if afterHours {
+				fmt.Println("tracking resumed, including outside working hours " +
+					"until they begin again")
+			} else {
+				fmt.Println("tracking resumed")
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&afterHours, "after-hours", false, "Also track writes "+
+		"outside working hours, until they begin again")
+	return cmd
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// parseEntryTime parses the time 'value' given to 'tg pending edit', which
+// may be RFC 3339, "2006-01-02 15:04", or just "15:04" (on the same day as
+// 'current', in local time)
+func parseEntryTime(value string, current time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("15:04", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected e.g. \"15:04\", "+
+			"\"2006-01-02 15:04\", or RFC 3339)", value)
+	}
+	y, m, d := current.In(time.Local).Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, time.Local), nil
+}
+
+// parseIDs parses the pending entry IDs in 'args'
+func parseIDs(args []string) ([]int64, error) {
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry ID %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// formatIDs formats pending entry IDs as a comma-separated list
+func formatIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printPendingEntry prints 'e' as a single line of 'tg pending'
+func printPendingEntry(e status.PendingEntry) {
+	start := e.Start.In(time.Local)
+	line := fmt.Sprintf("%4d  %s-%s  %8s  %s", e.ID, start.Format("2006-01-02 15:04"),
+		e.Stop.In(time.Local).Format("15:04"), e.Stop.Sub(e.Start).Round(time.Minute),
+		e.Project)
+	if e.Description != "" {
+		line += fmt.Sprintf(" %q", e.Description)
+	}
+	if len(e.Tags) > 0 {
+		line += " [" + strings.Join(e.Tags, ", ") + "]"
+	}
+	if e.Billable {
+		line += " (billable)"
+	}
+	fmt.Println(line)
+}
+
+func pendingEdit() *cobra.Command {
+	var (
+		project, description, tags, start, stop string
+
+		cmd *cobra.Command
+	)
+	cmd = &cobra.Command{
+		Use:   "edit <id>",
+		Short: "Change a time entry before it's approved",
+		Args:  ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			flags := cmd.Flags()
+			err = status.UpdatePendingEntry(statusDir, ids[0], func(e *status.PendingEntry) error {
+				if flags.Changed("project") {
+					e.Project = project
+				}
+				if flags.Changed("description") {
+					e.Description = description
+				}
+				if flags.Changed("tags") {
+					e.Tags = nil
+					for _, tag := range strings.Split(tags, ",") {
+						if tag = strings.TrimSpace(tag); tag != "" {
+							e.Tags = append(e.Tags, tag)
+						}
+					}
+				}
+				var err error
+				if flags.Changed("start") {
+					if e.Start, err = parseEntryTime(start, e.Start); err != nil {
+						return err
+					}
+				}
+				if flags.Changed("stop") {
+					if e.Stop, err = parseEntryTime(stop, e.Stop); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			entries, err := status.ReadPendingEntries(statusDir)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if e.ID == ids[0] {
+					printPendingEntry(e)
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&project, "project", "", "Attribute the entry to this project")
+	cmd.Flags().StringVar(&description, "description", "", "Replace the "+
+		"entry's description")
+	cmd.Flags().StringVar(&tags, "tags", "", "Replace the entry's tags "+
+		"(comma-separated; empty to clear them)")
+	cmd.Flags().StringVar(&start, "start", "", "Change the entry's start time "+
+		"(e.g. \"09:30\", on the same day)")
+	cmd.Flags().StringVar(&stop, "stop", "", "Change the entry's stop time "+
+		"(e.g. \"17:45\", on the same day)")
+	return cmd
+}
+
+func pending() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pending",
+		Short: "List the time entries awaiting approval",
+		Long: "List the time entries that tg has kept locally because " +
+			"require_approval is set (see 'tg config'). Entries can be changed " +
+			"with 'tg pending edit', and are created in Toggl by 'tg approve'",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			entries, err := status.ReadPendingEntries(statusDir)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				fmt.Println("no time entries are awaiting approval")
+				return nil
+			}
+			var total time.Duration
+			for _, e := range entries {
+				printPendingEntry(e)
+				total += e.Stop.Sub(e.Start)
+			}
+			fmt.Printf("%d entries, %s in total\n", len(entries), total.Round(time.Minute))
+			return nil
+		}),
+	}
+	cmd.AddCommand(pendingEdit())
+	return cmd
+}
+
+func approve() *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "approve [id...]",
+		Short: "Create time entries that are awaiting approval in Toggl",
+		Long: "Create the time entries listed by 'tg pending' with the given IDs " +
+			"(or, with --all, every pending entry) in Toggl. If an entry can't be " +
+			"created, the entries before it are still approved, and the rest stay " +
+			"pending. If merge_gap or round_entries is set (see 'tg config'), " +
+			"adjacent entries in the same project are merged and their times " +
+			"rounded first",
+		Args: ArgsBetween(0, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			if all == (len(args) > 0) {
+				return fmt.Errorf("expected either entry IDs or --all")
+			}
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				return err
+			}
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			profiles, err := profileClients()
+			if err != nil {
+				return err
+			}
+			approved, err := status.ApprovePendingEntries(context.Background(), statusDir, c,
+				profiles, ids, cfg.Consolidation())
+			for _, e := range approved {
+				merged := ""
+				if len(e.MergedIDs) > 0 {
+					merged = fmt.Sprintf(", merged with %s", formatIDs(e.MergedIDs))
+				}
+				fmt.Printf("approved entry %d (%s, %s%s)\n", e.ID, e.Project,
+					e.Stop.Sub(e.Start).Round(time.Minute), merged)
+			}
+			if err == nil && len(approved) == 0 {
+				fmt.Println("no time entries are awaiting approval")
+			}
+			return err
+		}),
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Approve every pending entry")
+	return cmd
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// detach starts 'cmd' in its own session, detached from this terminal, so
+// that it outlives tg. Its output is still in its log file
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// writable returns true if the current user can create files in 'dir'
+func writable(dir string) bool {
+	return unix.Access(dir, unix.W_OK) == nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// detach starts 'cmd' without a console, in its own process group, so that it
+// outlives tg (and doesn't receive this console's Ctrl-C). Its output is still
+// in its log file
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+	}
+}
+
+// writable returns true if the current user can create files in 'dir'.
+// Windows has no access(2), and a directory's read-only attribute doesn't
+// stop files being created in it, so this creates (and removes) a file
+func writable(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".tg-writable-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/credentials"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// addProfile adds the profile 'name' to the config, or replaces it if it
+// exists. If 'p' is nil, an existing profile is left as it is, and a new one
+// has no settings
+func addProfile(name string, p *config.Profile) error {
+	if err := config.ValidateProfileName(name); err != nil {
+		return err
+	}
+	cfg, err := config.Read(statusDir)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[name]; ok && p == nil {
+		return nil
+	}
+	if p == nil {
+		p = &config.Profile{}
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]config.Profile)
+	}
+	cfg.Profiles[name] = *p
+	if err := cfg.Save(statusDir); err != nil {
+		return err
+	}
+	if _, err := daemon.PID(statusDir); err == nil {
+		fmt.Fprintf(os.Stderr, "note: restart the daemon ('tg daemon restart') "+
+			"for it to use profile %q\n", name)
+	}
+	return nil
+}
+
+func profileAdd() *cobra.Command {
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add (or change) a profile for another Toggl account",
+		Long: "Add a profile named <name> for another Toggl account (e.g. a " +
+			"client's), or replace its settings if it exists. --workspace sets " +
+			"the workspace in which its projects are created (by default, the " +
+			"account's default workspace), and --tag adds tags to every time " +
+			"entry recorded in it. Store the account's API token with 'tg login " +
+			"--profile <name>', and assign directories to the profile with 'tg " +
+			"watch --profile <name>'",
+		Args: ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			return addProfile(args[0], &config.Profile{Workspace: workspace, Tags: tags})
+		}),
+	}
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Add this tag to time "+
+		"entries recorded in the profile's account; may be repeated")
+	return cmd
+}
+
+func profileRemove() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a profile",
+		Long: "Remove the profile <name> from the config. Directories assigned " +
+			"to it must be unwatched (or re-watched without it) first. Its stored " +
+			"API token is left in place",
+		Args: ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				return err
+			}
+			if _, err := cfg.Profile(args[0]); err != nil {
+				return err
+			}
+			watches, err := status.ListRootWatches(statusDir)
+			if err != nil {
+				return err
+			}
+			for _, rw := range watches {
+				if rw.Profile == args[0] {
+					return fmt.Errorf("%q is still assigned to profile %q", rw.Dir, args[0])
+				}
+			}
+			delete(cfg.Profiles, args[0])
+			if err := cfg.Save(statusDir); err != nil {
+				return err
+			}
+			return reloadDaemon()
+		}),
+	}
+}
+
+func profileList() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				return err
+			}
+			var names []string
+			for name := range cfg.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Printf("%-16s %-24s %-24s %s\n", "PROFILE", "WORKSPACE", "TAGS", "TOKEN")
+			for _, name := range names {
+				p := cfg.Profiles[name]
+				ws, token := p.Workspace, "ok"
+				if ws == "" {
+					ws = "(default)"
+				}
+				if _, err := credentials.ProfileToken(statusDir, name); err != nil {
+					token = "missing ('tg login --profile " + name + "')"
+				}
+				fmt.Printf("%-16s %-24s %-24s %s\n", name, ws, strings.Join(p.Tags, ","), token)
+			}
+			return nil
+		}),
+	}
+}
+
+func profileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage profiles for tracking time in other Toggl accounts",
+		Long: "Profiles are named Toggl accounts other than the default one " +
+			"(e.g. for consultants who track time in each client's account). " +
+			"Directories watched with --profile report to that profile's " +
+			"account, and any command run with --profile uses it",
+	}
+	cmd.AddCommand(profileAdd())
+	cmd.AddCommand(profileRemove())
+	cmd.AddCommand(profileList())
+	return cmd
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+func report() *cobra.Command {
+	var (
+		since  = config.Duration(7 * 24 * time.Hour)
+		group  string
+		detail bool
+	)
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Show time per project per day, from tg's local activity log",
+		Long: "Show the time spent on each project on each day, computed from the " +
+			"writes that tg observed rather than from Toggl. This works without a " +
+			"Toggl account, and includes work done while the Toggl API was " +
+			"unreachable. With --detail, each stretch of continuous work is " +
+			"listed instead, along with the files written most often in it",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			cfg, err := config.Read(statusDir)
+			if err != nil {
+				return err
+			}
+			end := time.Now()
+			buckets, err := status.ReadActivity(statusDir, end.Add(-time.Duration(since)), end)
+			if err != nil {
+				return err
+			}
+			if group != "" {
+				if buckets, err = inGroup(buckets, group); err != nil {
+					return err
+				}
+			}
+			if len(buckets) == 0 {
+				fmt.Printf("no activity recorded in the last %s\n", since)
+				return nil
+			}
+			if detail {
+				printBlocks(status.Blocks(buckets, time.Duration(cfg.IdleTimeout)))
+				return nil
+			}
+			totals := status.DailyTotals(buckets, time.Duration(cfg.IdleTimeout))
+			var days []string
+			for day := range totals {
+				days = append(days, day)
+			}
+			sort.Strings(days)
+			fmt.Printf("%-12s %-24s %10s\n", "DAY", "PROJECT", "TIME")
+			for _, day := range days {
+				var projects []string
+				for p := range totals[day] {
+					projects = append(projects, p)
+				}
+				sort.Strings(projects)
+				for _, p := range projects {
+					fmt.Printf("%-12s %-24s %10s\n", day, p,
+						totals[day][p].Round(time.Minute))
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Report activity that started within "+
+		"this duration of now (e.g. \"7d\")")
+	cmd.Flags().StringVar(&group, "group", "", "Only report activity in the "+
+		"directories in this group")
+	cmd.Flags().BoolVar(&detail, "detail", false, "List each stretch of "+
+		"continuous work, with the files written most often in it")
+	return cmd
+}
+
+// printBlocks prints each of 'blocks' as a line of 'tg report --detail'
+// output
+func printBlocks(blocks []status.Block) {
+	fmt.Printf("%-16s %-5s %-24s %8s  %s\n", "START", "END", "PROJECT", "TIME", "FILES")
+	for _, b := range blocks {
+		files := strings.Join(b.TopFiles, ", ")
+		if files == "" {
+			files = "-" // e.g. recorded by an older version of tg
+		}
+		fmt.Printf("%-16s %-5s %-24s %8s  %s\n", b.Start.Local().Format("2006-01-02 15:04"),
+			b.End.Local().Format("15:04"), b.Project, b.End.Sub(b.Start).Round(time.Minute),
+			files)
+	}
+}
+
+// inGroup returns the buckets in 'buckets' that were recorded in directories
+// in 'group'
+func inGroup(buckets []status.Bucket, group string) ([]status.Bucket, error) {
+	watches, err := status.ListRootWatches(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool)
+	for _, rw := range watches {
+		if rw.Group == group {
+			members[rw.Dir] = true
+		}
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no watched directories are in the group %q", group)
+	}
+	var result []status.Bucket
+	for _, b := range buckets {
+		if members[b.Root] {
+			result = append(result, b)
+		}
+	}
+	return result, nil
+}
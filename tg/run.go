@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/spf13/cobra"
+)
+
+func run() *cobra.Command {
+	var project string
+	cmd := &cobra.Command{
+		Use:   "run [--project <project>] -- <command> [args...]",
+		Short: "Run a command with automatic tracking paused",
+		Long: "Run <command>, ignoring writes in watched directories until it " +
+			"exits (e.g. for noisy data-generation jobs inside a watched repo). If " +
+			"--project is given, writes are attributed to <project> instead of " +
+			"being ignored. The exit status of <command> is returned",
+		Args: ArgsBetween(1, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			p := control.PauseParams{PID: os.Getpid(), Project: project}
+			err := control.Call(statusDir, control.MethodPause, p, nil)
+			paused := err == nil
+			if err == control.ErrNotRunning {
+				fmt.Fprintln(os.Stderr, "tg daemon is not running; nothing to pause")
+			} else if err != nil {
+				return fmt.Errorf("could not pause tracking: %v", err)
+			}
+
+			// Let the wrapped command handle interrupts, so that tg outlives it and
+			// can end the pause
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+			defer signal.Stop(signals)
+
+			c := exec.Command(args[0], args[1:]...)
+			c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+			runErr := c.Start()
+			if runErr == nil {
+				go func() {
+					for sig := range signals {
+						c.Process.Signal(sig)
+					}
+				}()
+				runErr = c.Wait()
+			}
+
+			if paused {
+				p.End = true
+				if err := control.Call(statusDir, control.MethodPause, p, nil); err != nil {
+					// The daemon drops the pause once tg exits, regardless
+					fmt.Fprintf(os.Stderr, "could not resume tracking: %v\n", err)
+				}
+			}
+			if exitErr, ok := runErr.(*exec.ExitError); ok {
+				if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Exited() {
+					os.Exit(ws.ExitStatus())
+				}
+			}
+			return runErr
+		}),
+	}
+	// Flags after <command> belong to it, even without "--"
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&project, "project", "", "Attribute writes to "+
+		"<project> while <command> runs, instead of ignoring them")
+	cmd.MarkFlagCustom("project", "__tg_complete_projects")
+	return cmd
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceName is the name of the systemd user unit written by 'tg
+// install-service'
+const serviceName = "tg.service"
+
+// serviceUnit is the systemd user unit written by 'tg install-service'. The
+// first %s is the 'tg resume' command line, the second is the state
+// directory, and %d is the unit's WatchdogSec
+const serviceUnit = `[Unit]
+Description=tg: track time in Toggl from writes in watched directories
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Environment=%s
+Restart=on-failure
+RestartSec=5
+WatchdogSec=%d
+
+[Install]
+WantedBy=default.target
+`
+
+// systemdQuote quotes 's' as a single word of a systemd unit's command line
+// (see systemd.service(5), "Command lines")
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%").Replace(s)
+	if s == "" || strings.ContainsAny(s, " \t\n'") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// userUnitDir returns the directory where systemd looks for the current
+// user's units
+func userUnitDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "systemd", "user"), nil
+	}
+	return "", fmt.Errorf("neither XDG_CONFIG_HOME nor HOME is set")
+}
+
+func installService() *cobra.Command {
+	var (
+		force       bool
+		watchdogSec int
+	)
+	cmd := &cobra.Command{
+		Use:   "install-service [-- <tg resume flags>...]",
+		Short: "Write a systemd user unit that runs 'tg resume'",
+		Long: "Write a systemd user unit (" + serviceName + ") that runs 'tg " +
+			"resume' with any flags given after '--'. systemd restarts the daemon " +
+			"if it fails, or if it stops responding for --watchdog-sec seconds",
+		Args: ArgsBetween(0, Unbounded),
+		RunE: RunCommand(func(args []string) error {
+			if statusDirErr != nil {
+				return statusDirErr
+			}
+			tg, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("could not find the tg executable: %v", err)
+			}
+			dir, err := userUnitDir()
+			if err != nil {
+				return fmt.Errorf("could not find the systemd user unit directory: %v", err)
+			}
+			unitPath := filepath.Join(dir, serviceName)
+			if _, err := os.Stat(unitPath); err == nil && !force {
+				return fmt.Errorf("%s already exists (use --force to replace it)", unitPath)
+			}
+
+			command := []string{systemdQuote(tg), "resume"}
+			for _, arg := range args {
+				command = append(command, systemdQuote(arg))
+			}
+			unit := fmt.Sprintf(serviceUnit, strings.Join(command, " "),
+				systemdQuote(statusDirectoryEnvVar+"="+statusDir), watchdogSec)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("could not create %s: %v", dir, err)
+			}
+			if err := ioutil.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+				return fmt.Errorf("could not write %s: %v", unitPath, err)
+			}
+			fmt.Printf("wrote %s. To start the daemon now and on login, run:\n"+
+				"  systemctl --user daemon-reload\n"+
+				"  systemctl --user enable --now %s\n"+
+				"(Window titles, input idle time, and notifications also need "+
+				"'systemctl --user import-environment DISPLAY WAYLAND_DISPLAY' in your "+
+				"session startup)\n", unitPath, serviceName)
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing unit")
+	cmd.Flags().IntVar(&watchdogSec, "watchdog-sec", 120, "Restart the daemon "+
+		"if it stops responding for this many seconds")
+	return cmd
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/spf13/cobra"
+)
+
+// shellHooks are the scripts printed by 'tg shell-hook' for each supported
+// shell. Each runs 'tg shell-activity' in the background before every prompt,
+// so that it doesn't slow the shell down. %s is the path to tg, quoted
+var shellHooks = map[string]string{
+	"bash": `__tg_precmd() { (%s shell-activity "$PWD" >/dev/null 2>&1 &); }
+case ";${PROMPT_COMMAND};" in
+  *";__tg_precmd;"*) ;;
+  *) PROMPT_COMMAND="__tg_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`,
+	"zsh": `__tg_precmd() { (%s shell-activity "$PWD" >/dev/null 2>&1 &) }
+autoload -Uz add-zsh-hook && add-zsh-hook precmd __tg_precmd
+`,
+	"fish": `function __tg_precmd --on-event fish_prompt
+    %s shell-activity "$PWD" >/dev/null 2>&1 &
+    disown 2>/dev/null
+end
+`,
+}
+
+func shellHook() *cobra.Command {
+	return &cobra.Command{
+		Use:   "shell-hook [bash|zsh|fish]",
+		Short: "Print a shell hook that reports terminal work to tg",
+		Long: "Print a script that, when evaluated by your shell (e.g. " +
+			"'eval \"$(tg shell-hook bash)\"' in ~/.bashrc), reports the working " +
+			"directory of each command to the tg daemon. If you keep running " +
+			"commands in a directory that no watch covers while no timer is " +
+			"running, tg sends a desktop notification suggesting 'tg watch' or " +
+			"'tg tick'. The shell defaults to the one in $SHELL",
+		Args: ArgsBetween(0, 1),
+		// Run by every new shell
+		Annotations: lightweight,
+		RunE: RunCommand(func(args []string) error {
+			shell := filepath.Base(os.Getenv("SHELL"))
+			if len(args) > 0 {
+				shell = args[0]
+			}
+			hook, ok := shellHooks[shell]
+			if !ok {
+				return fmt.Errorf("unsupported shell %q (expected bash, zsh, or fish)", shell)
+			}
+			tg, err := os.Executable()
+			if err != nil {
+				tg = "tg" // rely on $PATH
+			}
+			// Single-quote the path, so that the shell doesn't expand it
+			fmt.Printf(hook, "'"+strings.Replace(tg, "'", `'\''`, -1)+"'")
+			return nil
+		}),
+	}
+}
+
+func shellActivity() *cobra.Command {
+	return &cobra.Command{
+		Use:    "shell-activity <directory>",
+		Short:  "Report a command run in <directory> (called by 'tg shell-hook')",
+		Hidden: true,
+		// Run before every shell prompt
+		Annotations: lightweight,
+		Args:        ArgsBetween(1, 1),
+		RunE: RunCommand(func(args []string) error {
+			dir, err := filepath.Abs(args[0])
+			if err != nil {
+				return fmt.Errorf("could not resolve %q: %v", args[0], err)
+			}
+			err = control.Call(statusDir, control.MethodShellActivity,
+				control.ShellActivityParams{Dir: dir}, nil)
+			if err == control.ErrNotRunning {
+				return nil // nothing to nudge about without the daemon
+			}
+			return err
+		}),
+	}
+}
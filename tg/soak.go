@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/daemon"
+	"github.com/msteffen/toggl-watcher/persist"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/toggltest"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// soakIdleTimeout is the idle timeout used during a soak, so that even a
+	// short soak exercises many cycles of starting and stopping entries
+	soakIdleTimeout = 2 * time.Minute
+
+	// soakGoroutineSlack is how many more goroutines than at the first check
+	// the soak may have before it reports a leak
+	soakGoroutineSlack = 25
+
+	// soakJournalLag is how long after an entry is stopped in Toggl it may be
+	// missing from the journal before the soak reports it
+	soakJournalLag = time.Minute
+)
+
+// soakReport accumulates the results of the checks run during 'tg soak'
+type soakReport struct {
+	start      time.Time
+	checks     int
+	failures   []string
+	goroutines struct{ first, max int }
+	heap       struct{ first, max uint64 }
+}
+
+func (r *soakReport) failf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.failures = append(r.failures, fmt.Sprintf("[%s] %s",
+		time.Since(r.start).Round(time.Second), msg))
+	fmt.Printf("FAIL: %s\n", msg)
+}
+
+func (r *soakReport) print(entries, journaled int) {
+	fmt.Printf("\nsoak ran for %s\n", time.Since(r.start).Round(time.Second))
+	fmt.Printf("  checks:      %d\n", r.checks)
+	fmt.Printf("  entries:     %d created, %d journaled\n", entries, journaled)
+	fmt.Printf("  goroutines:  %d at first check, %d max\n", r.goroutines.first,
+		r.goroutines.max)
+	fmt.Printf("  heap:        %.1f MiB at first check, %.1f MiB max\n",
+		float64(r.heap.first)/(1<<20), float64(r.heap.max)/(1<<20))
+	fmt.Printf("  failures:    %d\n", len(r.failures))
+	for _, f := range r.failures {
+		fmt.Printf("    %s\n", f)
+	}
+}
+
+// soakWorkload simulates a person moving between the projects in 'dirs' for
+// stretches of a few minutes: editing files (see generateActivity), creating
+// and deleting packages, and occasionally going idle for long enough that the
+// open entry is stopped. It runs until 'stop' is closed
+func soakWorkload(dirs []string, stop <-chan struct{}) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		dir := dirs[r.Intn(len(dirs))]
+		stretch := make(chan struct{})
+		go generateActivity(dir, stretch)
+		select {
+		case <-time.After(time.Duration(1+r.Intn(15)) * time.Minute):
+			close(stretch)
+		case <-stop:
+			close(stretch)
+			return
+		}
+		// Delete a package now and then, so that watches are removed as well as
+		// added
+		if r.Intn(4) == 0 {
+			if pkgs, _ := filepath.Glob(path.Join(dir, "pkg*")); len(pkgs) > 0 {
+				os.RemoveAll(pkgs[r.Intn(len(pkgs))])
+			}
+		}
+		if r.Intn(3) == 0 {
+			idle := soakIdleTimeout + time.Duration(r.Intn(180))*time.Second
+			select {
+			case <-time.After(idle):
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// interrupted returns true if a signal has been received on 'interrupt',
+// without waiting for one. os/signal delivers each signal to every channel
+// registered for it in one pass, so by the time the daemon (which also
+// registers for it) has shut down because of a signal, it's here too
+func interrupted(interrupt <-chan os.Signal) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// soakCheck asserts the soak's invariants, recording any violations in 'r'
+func soakCheck(r *soakReport, d *daemon.Daemon, server *toggltest.Server, stateDir string) {
+	r.checks++
+
+	// The watched directories match those on disk. Writes in flight may cause
+	// spurious differences, so only report differences that persist
+	if err := d.VerifyWatches(); err != nil {
+		time.Sleep(10 * time.Second)
+		if err := d.VerifyWatches(); err != nil {
+			r.failf("%v", err)
+		}
+	}
+
+	// Goroutines don't accumulate
+	n := runtime.NumGoroutine()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	if r.checks == 1 {
+		r.goroutines.first, r.heap.first = n, mem.HeapAlloc
+	}
+	if n > r.goroutines.max {
+		r.goroutines.max = n
+	}
+	if mem.HeapAlloc > r.heap.max {
+		r.heap.max = mem.HeapAlloc
+	}
+	if n > r.goroutines.first+soakGoroutineSlack {
+		r.failf("%d goroutines are running, up from %d at the first check", n,
+			r.goroutines.first)
+	}
+
+	// The journal matches the entries stopped in Toggl. The journal is read
+	// second, as entries are stopped in Toggl before they're journaled
+	entries := server.TimeEntries()
+	journal, err := status.ReadJournal(stateDir, r.start.Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		r.failf("could not read journal: %v", err)
+		return
+	}
+	running := 0
+	stopped := make(map[int64]time.Time)
+	for _, e := range entries {
+		if e.Running() {
+			running++
+		} else {
+			stopped[e.ID] = *e.Stop
+		}
+	}
+	if running > 1 {
+		r.failf("%d time entries are running at once", running)
+	}
+	journaled := make(map[int64]bool)
+	for _, j := range journal {
+		stop, ok := stopped[j.TimeEntryID]
+		switch {
+		case journaled[j.TimeEntryID]:
+			r.failf("time entry %d is journaled more than once", j.TimeEntryID)
+		case !ok:
+			r.failf("time entry %d is journaled, but isn't stopped in Toggl", j.TimeEntryID)
+		case !stop.Equal(j.Stop):
+			r.failf("time entry %d stopped at %s in Toggl, but at %s in the journal",
+				j.TimeEntryID, stop, j.Stop)
+		}
+		journaled[j.TimeEntryID] = true
+	}
+	var missing []string
+	for id, stop := range stopped {
+		if !journaled[id] && time.Since(stop) > soakJournalLag {
+			missing = append(missing, fmt.Sprint(id))
+		}
+	}
+	if len(missing) > 0 {
+		r.failf("time entries stopped in Toggl are missing from the journal: %s",
+			strings.Join(missing, ", "))
+	}
+	fmt.Printf("[%s] check %d: %d failure(s) so far; %d goroutines, %.1f MiB heap, "+
+		"%d entries\n", time.Since(r.start).Round(time.Second), r.checks,
+		len(r.failures), n, float64(mem.HeapAlloc)/(1<<20), len(entries))
+}
+
+func soak() *cobra.Command {
+	var (
+		hours         float64
+		projects      int
+		checkInterval = config.Duration(5 * time.Minute)
+	)
+	cmd := &cobra.Command{
+		Use:   "soak",
+		Short: "Run the daemon against a synthetic workload and check its invariants",
+		Long: "Run the tg daemon for a long time against temporary project " +
+			"directories with simulated activity and a fake Toggl server, " +
+			"periodically checking that its watches match the directories on " +
+			"disk, that it isn't leaking goroutines, and that its journal matches " +
+			"the time entries it stopped. A report is printed at the end (or on " +
+			"Ctrl-C), and the command fails if any check did. Nothing is sent to " +
+			"Toggl and no state outside of a temporary directory is modified",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			if projects < 1 {
+				return fmt.Errorf("--projects must be at least 1")
+			}
+			tmp, err := ioutil.TempDir("", "tg-soak-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmp)
+			stateDir := path.Join(tmp, "state")
+			if err := persist.MakeStateDir(stateDir); err != nil {
+				return err
+			}
+			cfg := config.Default()
+			cfg.IdleTimeout = config.Duration(soakIdleTimeout)
+			if err := cfg.Save(stateDir); err != nil {
+				return err
+			}
+			var dirs []string
+			for i := 0; i < projects; i++ {
+				dir := path.Join(tmp, fmt.Sprintf("project%d", i))
+				if err := os.Mkdir(dir, 0755); err != nil {
+					return err
+				}
+				if err := status.SaveRootWatch(stateDir, dir, path.Base(dir)); err != nil {
+					return err
+				}
+				dirs = append(dirs, dir)
+			}
+
+			server := toggltest.NewServer()
+			defer server.Close()
+			d, err := daemon.New(stateDir, server.Client(), daemon.DefaultOptions())
+			if err != nil {
+				return err
+			}
+			errCh := make(chan error, 1)
+			go func() { errCh <- d.Run() }()
+			defer d.Stop()
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go soakWorkload(dirs, stop)
+
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(interrupt)
+
+			r := &soakReport{start: time.Now()}
+			deadline := time.After(time.Duration(hours * float64(time.Hour)))
+			ticker := time.NewTicker(time.Duration(checkInterval))
+			defer ticker.Stop()
+		soakLoop:
+			for {
+				select {
+				case err := <-errCh:
+					// The daemon shuts down cleanly on SIGINT and SIGTERM too, so
+					// it may exit before 'interrupt' is read. That's not a failure
+					if err == nil && interrupted(interrupt) {
+						break soakLoop
+					}
+					r.failf("daemon exited: %v", err)
+					break soakLoop
+				case <-deadline:
+					break soakLoop
+				case <-interrupt:
+					break soakLoop
+				case <-ticker.C:
+					soakCheck(r, d, server, stateDir)
+				}
+			}
+			journal, _ := status.ReadJournal(stateDir, r.start.Add(-time.Hour),
+				time.Now().Add(time.Hour))
+			r.print(len(server.TimeEntries()), len(journal))
+			if len(r.failures) > 0 {
+				return fmt.Errorf("soak found %d invariant violation(s)", len(r.failures))
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Float64Var(&hours, "hours", 24, "How long to run the soak")
+	cmd.Flags().IntVar(&projects, "projects", 3, "How many project directories "+
+		"to simulate activity in")
+	cmd.Flags().Var(&checkInterval, "check-interval", "How often to check the "+
+		"daemon's invariants")
+	return cmd
+}
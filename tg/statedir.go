@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/credentials"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// stateDirName is the name of tg's state directory when it's created under
+	// XDG_STATE_HOME (or a fallback location, see resolveStatusDir)
+	stateDirName = "toggl-watcher"
+
+	// legacyStateDirName is the name of the state directory that older
+	// versions of tg created in the user's home directory. It's moved to the
+	// current location the first time tg runs (see initStatusDir)
+	legacyStateDirName = ".toggle-tool"
+)
+
+// creatable returns true if the current user can create 'dir' (or it already
+// exists), i.e. if its nearest existing ancestor is writable
+func creatable(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return true
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		if _, err := os.Stat(parent); err == nil {
+			return writable(parent)
+		}
+		dir = parent
+	}
+}
+
+// stateHome returns the base directory in which user-specific state is kept:
+// XDG_STATE_HOME if it's set (to an absolute path, per the XDG base directory
+// spec), and otherwise ~/.local/state. It returns "" if HOME is unset too
+func stateHome() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); path.IsAbs(dir) {
+		return dir
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return path.Join(home, ".local", "state")
+	}
+	return ""
+}
+
+// legacyStatusDir returns the state directory that older versions of tg used
+// by default, or "" if HOME is unset
+func legacyStatusDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return path.Join(home, legacyStateDirName)
+	}
+	return ""
+}
+
+// resolveStatusDir returns the directory where tg keeps its state. If the
+// directory isn't in a durable location (e.g. because HOME is unset or
+// read-only, as it is for some service accounts), 'warning' explains where
+// state is being kept instead. If there is nowhere to keep state, an error
+// explaining how to configure a location is returned
+func resolveStatusDir() (dir, warning string, err error) {
+	if dir, ok := os.LookupEnv(statusDirectoryEnvVar); ok && dir != "" {
+		return dir, "", nil
+	}
+	if base := stateHome(); base != "" {
+		dir := path.Join(base, stateDirName)
+		if creatable(dir) {
+			return dir, "", nil
+		}
+		// A legacy state directory that can't be moved is used where it is
+		if legacy := legacyStatusDir(); legacy != "" {
+			if _, err := os.Stat(legacy); err == nil {
+				return legacy, "", nil
+			}
+		}
+	}
+	home := os.Getenv("HOME")
+	reason := "HOME is unset"
+	if home != "" {
+		reason = fmt.Sprintf("%s is not writable", stateHome())
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" && writable(runtimeDir) {
+		return path.Join(runtimeDir, stateDirName), fmt.Sprintf("%s; keeping "+
+			"state in XDG_RUNTIME_DIR, which is cleared on logout (set %s to keep "+
+			"it elsewhere)", reason, statusDirectoryEnvVar), nil
+	}
+	if tmp := os.TempDir(); writable(tmp) {
+		return path.Join(tmp, fmt.Sprintf("toggl-watcher-%d", os.Getuid())),
+			fmt.Sprintf("%s; keeping state in %s, which may be cleared on reboot "+
+				"(set %s to keep it elsewhere)", reason, tmp, statusDirectoryEnvVar), nil
+	}
+	return "", "", fmt.Errorf("%s and no fallback directory is writable; set %s "+
+		"to a writable directory where tg can keep its state", reason,
+		statusDirectoryEnvVar)
+}
+
+// initStatusDir sets up statusDir the first time tg runs. If the state
+// directory of an older version of tg exists (see legacyStateDirName), it's
+// moved to statusDir, unless a daemon is still running in it, in which case
+// it keeps being used until the daemon is stopped. Otherwise, statusDir is
+// created. It returns a note describing what was done, if anything
+func initStatusDir() (note string, err error) {
+	legacy := legacyStatusDir()
+	_, envSet := os.LookupEnv(statusDirectoryEnvVar)
+	if _, err := os.Stat(statusDir); os.IsNotExist(err) && !envSet && legacy != "" &&
+		legacy != statusDir {
+		if _, err := os.Stat(legacy); err == nil {
+			var info control.DaemonInfo
+			if err := control.Call(legacy, control.MethodDaemonInfo, nil, &info); err == nil {
+				note = fmt.Sprintf("using tg's old state directory %s, as the tg "+
+					"daemon (PID %d) is running in it; stop the daemon ('tg daemon "+
+					"stop') and start it again to move the directory to %s", legacy,
+					info.PID, statusDir)
+				statusDir = legacy
+			} else if err := os.MkdirAll(path.Dir(statusDir), 0755); err != nil {
+				return "", fmt.Errorf("could not create %q: %v", path.Dir(statusDir), err)
+			} else if err := os.Rename(legacy, statusDir); err != nil {
+				note = fmt.Sprintf("could not move tg's old state directory %s to "+
+					"%s (%v); using it where it is", legacy, statusDir, err)
+				statusDir = legacy
+			} else {
+				note = fmt.Sprintf("moved tg's state directory from %s to %s", legacy,
+					statusDir)
+			}
+		}
+	}
+	created, err := status.InitState(statusDir)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		note = fmt.Sprintf("created tg's state directory at %s", statusDir)
+	}
+	return note, nil
+}
+
+func initCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init",
+		Short: "Set up tg's state directory",
+		Long: "Create the directory where tg keeps its state ($XDG_STATE_HOME/" +
+			stateDirName + ", or ~/.local/state/" + stateDirName + " by default, " +
+			"unless " + statusDirectoryEnvVar + " is set), moving the state of " +
+			"older versions of tg from ~/" + legacyStateDirName + " if it exists. " +
+			"Every tg command does this if it's needed, so running 'tg init' is " +
+			"optional",
+		Args: ArgsBetween(0, 0),
+		// The state directory is set up by checkStatusDir, before this runs
+		RunE: RunCommand(func(_ []string) error {
+			fmt.Printf("tg's state is kept in %s\n", statusDir)
+			local, _ := localOnly()
+			if _, _, err := credentials.Tokens(statusDir); err == credentials.ErrNoToken && !local {
+				fmt.Println("next, log in to Toggl with 'tg login' (or set the " +
+					"backend with 'tg config set backend local')")
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestCheckStatusDirLightweight checks that lightweight commands (e.g. the
+// shell hook's) don't set up tg's state directory, while other commands do
+func TestCheckStatusDirLightweight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tg-test-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statusDir = d }(statusDir)
+	statusDir = path.Join(dir, "state")
+	os.Setenv(statusDirectoryEnvVar, statusDir) // don't look for a legacy dir
+	defer os.Unsetenv(statusDirectoryEnvVar)
+
+	if err := checkStatusDir(shellActivity(), nil); err != nil {
+		t.Fatalf("could not check state dir: %v", err)
+	}
+	if _, err := os.Stat(statusDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the state dir not to be created, but got %v", err)
+	}
+	if err := checkStatusDir(list(), nil); err != nil {
+		t.Fatalf("could not check state dir: %v", err)
+	}
+	if _, err := os.Stat(statusDir); err != nil {
+		t.Fatalf("expected the state dir to be created, but got %v", err)
+	}
+}
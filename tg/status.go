@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/control"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// statusOutput is printed by 'tg status --json'
+type statusOutput struct {
+	control.StatusResult
+	// DaemonRunning is true if the status was reported by a running daemon
+	// (rather than read from tg's state files)
+	DaemonRunning bool `json:"daemon_running"`
+	// IdleSeconds is the number of seconds since the latest tick
+	IdleSeconds int64 `json:"idle_seconds,omitempty"`
+}
+
+// readStatus returns tg's current status, from the daemon if it's running or
+// from tg's state files if it's not
+func readStatus() (*statusOutput, error) {
+	var result statusOutput
+	err := control.Call(statusDir, control.MethodStatus, nil, &result.StatusResult)
+	switch {
+	case err == nil:
+		result.DaemonRunning = true
+	case err == control.ErrNotRunning:
+		s, err := status.Read(statusDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		} else if err == nil {
+			result.Project = s.Project()
+			result.LatestTick = s.LatestTick()
+			result.TimeEntryID = s.TimeEntryID()
+			result.Detour, result.DetourProject = s.Detour()
+		}
+		if result.Pending, err = status.PendingOps(statusDir); err != nil {
+			return nil, err
+		}
+		result.Watches, err = status.ReadRootWatches(statusDir)
+		if err != nil {
+			return nil, err
+		}
+		// The daemon marks root watches whose directories are missing as broken
+		for dir, project := range result.Watches {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				if result.Broken == nil {
+					result.Broken = make(map[string]string)
+				}
+				result.Broken[dir] = project
+				delete(result.Watches, dir)
+			}
+		}
+	default:
+		return nil, err
+	}
+	if !result.LatestTick.IsZero() {
+		result.IdleSeconds = int64(time.Since(result.LatestTick) / time.Second)
+	}
+	return &result, nil
+}
+
+func statusCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the current project, open time entry, and watched directories",
+		Long: "Show the project being worked on, the open Toggl time entry (if " +
+			"any), the time since the last write, and which directories are " +
+			"watched. With --json, the output is suitable for status bars such as " +
+			"i3blocks or polybar",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			s, err := readStatus()
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return json.NewEncoder(os.Stdout).Encode(s)
+			}
+			if !s.DaemonRunning {
+				fmt.Println("daemon:     not running (start it with 'tg resume')")
+			}
+			switch {
+			case s.LatestTick.IsZero():
+				fmt.Println("project:    (no activity yet)")
+			default:
+				fmt.Printf("project:    %s\n", s.Project)
+				fmt.Printf("last write: %s ago\n",
+					(time.Duration(s.IdleSeconds) * time.Second).String())
+			}
+			switch {
+			case s.Detour && s.DetourProject != "":
+				fmt.Printf("detour:     working on %s\n", s.DetourProject)
+			case s.Detour:
+				fmt.Println("detour:     non-billable")
+			}
+			switch {
+			case s.Paused && s.PausedProject != "":
+				fmt.Printf("paused:     writes go to %s ('tg run')\n", s.PausedProject)
+			case s.Paused:
+				fmt.Println("paused:     writes are ignored ('tg run')")
+			}
+			switch {
+			case s.Suspended && !s.SuspendedUntil.IsZero():
+				fmt.Printf("suspended:  tracking resumes at %s ('tg resume-tracking')\n",
+					s.SuspendedUntil.Local().Format("15:04"))
+			case s.Suspended:
+				fmt.Println("suspended:  until 'tg resume-tracking'")
+			}
+			if s.Pending > 0 {
+				fmt.Printf("pending:    %d Toggl updates queued until Toggl is "+
+					"reachable\n", s.Pending)
+			}
+			if s.TimeEntryID == status.LocalEntryID && s.LocalOnly {
+				fmt.Println("open entry: recorded locally (the backend is local)")
+			} else if s.TimeEntryID == status.LocalEntryID {
+				fmt.Println("open entry: kept locally until approved ('tg approve')")
+			} else if s.TimeEntryID == status.TrackerEntryID {
+				fmt.Printf("open entry: %s (in %s)\n", s.TrackerEntryID, s.Tracker)
+			} else if s.TimeEntryID != 0 {
+				fmt.Printf("open entry: %d\n", s.TimeEntryID)
+			} else {
+				fmt.Println("open entry: none")
+			}
+			dirs := make([]string, 0, len(s.Watches))
+			for dir := range s.Watches {
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			fmt.Println("watches:")
+			for _, dir := range dirs {
+				fmt.Printf("  %s -> %s\n", dir, s.Watches[dir])
+			}
+			if len(s.Broken) > 0 {
+				broken := make([]string, 0, len(s.Broken))
+				for dir := range s.Broken {
+					broken = append(broken, dir)
+				}
+				sort.Strings(broken)
+				fmt.Println("broken (moved away; 'tg watch' the new path, or 'tg unwatch'):")
+				for _, dir := range broken {
+					fmt.Printf("  %s -> %s\n", dir, s.Broken[dir])
+				}
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print status as JSON")
+	return cmd
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/spf13/cobra"
+)
+
+// entrySummary describes 'e' in a line of 'tg sync' output
+func entrySummary(e status.StoppedEntry) string {
+	project := e.Project
+	if project == "" {
+		project = "(no project)"
+	}
+	s := fmt.Sprintf("%s-%s %s", e.Start.Local().Format("Jan 02 15:04"),
+		e.Stop.Local().Format("15:04"), project)
+	if e.Description != "" {
+		s += fmt.Sprintf(" %q", e.Description)
+	}
+	return s
+}
+
+func syncCmd() *cobra.Command {
+	var (
+		since     = config.Duration(7 * 24 * time.Hour)
+		tolerance = config.Duration(time.Minute)
+		push      bool
+		pull      bool
+	)
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Reconcile tg's journal with the time entries in Toggl",
+		Long: "Compare each time entry in tg's local journal with the time " +
+			"entries in Toggl, and print the entries that are missing on either " +
+			"side or that differ (e.g. due to offline periods, clock drift, or " +
+			"edits in the Toggl web UI). With --push, entries missing from Toggl " +
+			"(including entries recorded while the backend was \"local\", and " +
+			"entries deleted in Toggl) are created there. With --pull, the " +
+			"journal is updated to match Toggl's entries",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			end := time.Now()
+			start := end.Add(-time.Duration(since))
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			ds, err := status.Reconcile(context.Background(), statusDir, c, profile, start, end,
+				time.Duration(tolerance))
+			if err != nil {
+				return err
+			}
+			if len(ds) == 0 {
+				fmt.Printf("tg and Toggl agree (within %s) for the last %s\n", tolerance, since)
+				return nil
+			}
+			for _, d := range ds {
+				switch d.Kind {
+				case status.MissingRemote:
+					fmt.Printf("%-17s %s\n", d.Kind, entrySummary(d.Local))
+				case status.MissingLocal:
+					fmt.Printf("%-17s %s (toggl entry %d)\n", d.Kind, entrySummary(d.Remote),
+						d.Remote.TimeEntryID)
+				case status.Changed:
+					fmt.Printf("%-17s %s\n%-17s -> %s (toggl entry %d)\n", d.Kind,
+						entrySummary(d.Local), "", entrySummary(d.Remote), d.Remote.TimeEntryID)
+				}
+			}
+			if push {
+				profiles, err := profileClients()
+				if err != nil {
+					return err
+				}
+				n, err := status.Push(context.Background(), statusDir, c, profiles, ds)
+				fmt.Printf("pushed %d entries to Toggl\n", n)
+				if err != nil {
+					return err
+				}
+			}
+			if pull {
+				n, err := status.Pull(statusDir, ds)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("pulled %d entries from Toggl\n", n)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Reconcile time entries that started "+
+		"within this duration of now (e.g. \"7d\")")
+	cmd.Flags().Var(&tolerance, "tolerance", "Treat start and stop times that "+
+		"differ by no more than this as the same (e.g. \"1m\")")
+	cmd.Flags().BoolVar(&push, "push", false, "Create the entries that are "+
+		"missing from Toggl")
+	cmd.Flags().BoolVar(&pull, "pull", false, "Update the journal with "+
+		"entries that were added or changed in Toggl")
+	return cmd
+}
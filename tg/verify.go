@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/config"
+	"github.com/msteffen/toggl-watcher/status"
+	"github.com/msteffen/toggl-watcher/togglclient"
+	"github.com/spf13/cobra"
+)
+
+// togglTotals returns the total duration of 'entries' in each project, by
+// project name (running entries are counted up to now)
+func togglTotals(c *togglclient.Client, entries []togglclient.TimeEntry) (map[string]time.Duration, error) {
+	names := make(map[int64]string) // project ID -> name
+	listed := make(map[int64]bool)  // workspace ID -> projects fetched
+	result := make(map[string]time.Duration)
+	for _, e := range entries {
+		if e.ProjectID != 0 && !listed[e.WorkspaceID] {
+			projects, err := c.ListProjects(context.Background(), e.WorkspaceID)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range projects {
+				names[p.ID] = p.Name
+			}
+			listed[e.WorkspaceID] = true
+		}
+		stop := time.Now()
+		if e.Stop != nil {
+			stop = *e.Stop
+		}
+		result[names[e.ProjectID]] += stop.Sub(e.Start)
+	}
+	return result, nil
+}
+
+func verify() *cobra.Command {
+	var (
+		since     = config.Duration(7 * 24 * time.Hour)
+		threshold = config.Duration(5 * time.Minute)
+	)
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Compare time tracked by tg with time recorded in Toggl",
+		Long: "Compare the total time per project in tg's local journal with the " +
+			"time entries in Toggl, and print each project whose totals differ by " +
+			"more than --threshold. Differences indicate updates that were lost " +
+			"(e.g. due to API failures) or manual edits in the Toggl web UI",
+		Args: ArgsBetween(0, 0),
+		RunE: RunCommand(func(_ []string) error {
+			end := time.Now()
+			start := end.Add(-time.Duration(since))
+			journal, err := status.ReadJournal(statusDir, start, end)
+			if err != nil {
+				return err
+			}
+			local := status.ProjectTotals(journal)
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			entries, err := c.ListTimeEntries(context.Background(), start, end)
+			if err != nil {
+				return fmt.Errorf("could not list Toggl time entries: %v", err)
+			}
+			remote, err := togglTotals(c, entries)
+			if err != nil {
+				return err
+			}
+
+			var projects []string
+			for p := range local {
+				projects = append(projects, p)
+			}
+			for p := range remote {
+				if _, ok := local[p]; !ok {
+					projects = append(projects, p)
+				}
+			}
+			sort.Strings(projects)
+			var found bool
+			for _, p := range projects {
+				diff := remote[p] - local[p]
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff <= time.Duration(threshold) {
+					continue
+				}
+				if !found {
+					fmt.Printf("%-24s %10s %10s\n", "PROJECT", "TG", "TOGGL")
+					found = true
+				}
+				name := p
+				if name == "" {
+					name = "(no project)"
+				}
+				fmt.Printf("%-24s %10s %10s\n", name, local[p].Round(time.Minute),
+					remote[p].Round(time.Minute))
+			}
+			if !found {
+				fmt.Printf("tg and Toggl agree (within %s) for the last %s\n", threshold, since)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().Var(&since, "since", "Compare time entries that started "+
+		"within this duration of now (e.g. \"7d\")")
+	cmd.Flags().Var(&threshold, "threshold", "Only report projects whose "+
+		"totals differ by more than this (e.g. \"5m\")")
+	return cmd
+}
@@ -0,0 +1,501 @@
+// Package togglclient is a small client for the subset of the Toggl API that
+// toggl-watcher uses (workspaces, projects, and time entries)
+package togglclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/metrics"
+)
+
+const (
+	// DefaultBaseURL is the root of the Toggl API (v9)
+	DefaultBaseURL = "https://api.track.toggl.com/api/v9/"
+
+	// createdWith is sent to Toggl with every new time entry, identifying this
+	// tool as the entry's creator (required by the API)
+	createdWith = "toggl-watcher"
+
+	// DefaultTimeout is how long a request to Toggl may take, including reading
+	// its response, before it's abandoned (see SetTimeout)
+	DefaultTimeout = 30 * time.Second
+)
+
+// Transport sends the requests of every Client (and of the other time
+// trackers' clients), so that connections are reused across them. Unlike
+// http.DefaultTransport, it bounds every phase of a request (dialing, the TLS
+// handshake, and waiting for response headers), so that an unresponsive
+// server can't hold a connection forever
+var Transport http.RoundTripper = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: DefaultTimeout,
+	ExpectContinueTimeout: time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   4,
+}
+
+// httpClient is shared by every Client. Timeouts are applied per request (see
+// Client.timeout), so that they can be configured per Client
+var httpClient = &http.Client{Transport: Transport}
+
+// Client sends requests to the Toggl API on behalf of a single user
+type Client struct {
+	// BaseURL is the root of the Toggl API (all request paths are resolved
+	// relative to it). It's DefaultBaseURL unless overridden (e.g. by tests)
+	BaseURL string
+
+	// readToken and writeToken are the Toggl API tokens used to authenticate
+	// requests that read from and modify Toggl, respectively. They're the same
+	// unless the user has configured limited-scope tokens
+	readToken, writeToken string
+
+	// httpClient is used to send all requests, each of which is abandoned if
+	// it takes longer than 'timeout' (unless it's 0)
+	httpClient *http.Client
+	timeout    time.Duration
+
+	// workspace is the name or ID of the workspace in which time entries are
+	// created and modified, or "" for the user's default workspace (see
+	// SetWorkspace). workspaceID is its ID, once resolved
+	workspace   string
+	workspaceID int64
+
+	// requestHook, if set, is called after every request (see SetRequestHook)
+	requestHook func(method, path string, start time.Time, err error)
+
+	// dryRun, if set, answers requests that would modify Toggl instead of
+	// sending them (see SetDryRun)
+	dryRun *dryRun
+}
+
+// New returns a Client that authenticates to Toggl with 'apiToken'
+func New(apiToken string) *Client {
+	return NewScoped(apiToken, apiToken)
+}
+
+// NewScoped returns a Client that authenticates GET requests to Toggl with
+// 'readToken' and all other requests with 'writeToken'. If 'writeToken' is
+// empty, the client can only read
+func NewScoped(readToken, writeToken string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		readToken:  readToken,
+		writeToken: writeToken,
+		httpClient: httpClient,
+		timeout:    DefaultTimeout,
+	}
+}
+
+// SetTimeout sets how long each request that 'c' sends may take before it's
+// abandoned, in which case it fails with a NetworkError. If 'd' is 0, requests
+// are only abandoned when their contexts are done
+func (c *Client) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// SetWorkspace sets the name or ID of the workspace in which 'c' creates and
+// modifies time entries. If it's never called (or 'nameOrID' is empty), the
+// user's default workspace is used. The workspace is looked up on first use
+func (c *Client) SetWorkspace(nameOrID string) {
+	c.workspace, c.workspaceID = nameOrID, 0
+}
+
+// Workspace returns the ID of the workspace in which 'c' creates and modifies
+// time entries (see SetWorkspace)
+func (c *Client) Workspace(ctx context.Context) (int64, error) {
+	if c.workspaceID != 0 {
+		return c.workspaceID, nil
+	}
+	ws, err := c.FindWorkspace(ctx, c.workspace)
+	if err != nil {
+		return 0, err
+	}
+	c.workspaceID = ws.ID
+	return ws.ID, nil
+}
+
+// workspaceOr returns 'workspaceID' if it's set, and otherwise the ID of the
+// client's workspace
+func (c *Client) workspaceOr(ctx context.Context, workspaceID int64) (int64, error) {
+	if workspaceID != 0 {
+		return workspaceID, nil
+	}
+	return c.Workspace(ctx)
+}
+
+// SetRequestHook sets a function that is called after every request that 'c'
+// sends, with the request's method and path, the time at which it was sent,
+// and the error it returned (if any). It may be used to trace requests
+func (c *Client) SetRequestHook(f func(method, path string, start time.Time, err error)) {
+	c.requestHook = f
+}
+
+// do sends a request to the Toggl API, which is abandoned if 'ctx' is done or
+// c.timeout passes. If 'in' is non-nil, it's serialized as the JSON request
+// body, and if 'out' is non-nil, the response body is deserialized into it.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	_, err := c.doHeader(ctx, method, path, in, out)
+	return err
+}
+
+// doHeader is like do, but also returns the response's headers
+func (c *Client) doHeader(ctx context.Context, method, path string, in, out interface{}) (_ http.Header, err error) {
+	if c.requestHook != nil {
+		defer func(start time.Time) { c.requestHook(method, path, start, err) }(time.Now())
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid toggl base URL %q: %v", c.BaseURL, err)
+	}
+	// Request paths are relative to the base URL's last path component only if
+	// it ends in a slash, so ".../api/v9" must not resolve "me" to ".../api/me"
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	rel, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid toggl request path %q: %v", path, err)
+	}
+	u := base.ResolveReference(rel)
+	token := c.writeToken
+	if method == "GET" {
+		token = c.readToken
+	}
+	if token == "" {
+		return nil, fmt.Errorf("cannot %s %s: no Toggl API token with access", method, u)
+	}
+
+	var body io.Reader
+	if in != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(in); err != nil {
+			return nil, fmt.Errorf("could not serialize toggl request: %v", err)
+		}
+		body = buf
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(token, "api_token")
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	metrics.APILatency.Since(start)
+	if err != nil {
+		metrics.Errors.Add("toggl_api", 1)
+		return nil, &NetworkError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.Errors.Add("toggl_api", 1)
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{
+			Method:     method,
+			URL:        u.String(),
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(msg)),
+		}
+	}
+	if out == nil {
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if ctx.Err() != nil {
+			return nil, &NetworkError{Err: ctx.Err()} // timed out reading the response
+		}
+		return nil, fmt.Errorf("could not parse toggl response to %s %s: %v",
+			method, u, err)
+	}
+	return resp.Header, nil
+}
+
+// GetWorkspaces returns all workspaces that the client's user belongs to
+func (c *Client) GetWorkspaces(ctx context.Context) ([]Workspace, error) {
+	var result []Workspace
+	if err := c.do(ctx, "GET", "me/workspaces", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Me returns the client's user (which also checks that the client's token is
+// valid), along with the time on Toggl's clock when it replied (from the
+// response's Date header, to the second), or the zero time if it sent none
+func (c *Client) Me(ctx context.Context) (*User, time.Time, error) {
+	var me User
+	header, err := c.doHeader(ctx, "GET", "me", nil, &me)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = time.Time{}
+	}
+	return &me, date, nil
+}
+
+// DefaultWorkspace returns the client's user's default workspace (or, if it
+// has none, the first workspace that the user belongs to)
+func (c *Client) DefaultWorkspace(ctx context.Context) (*Workspace, error) {
+	var me User
+	if err := c.do(ctx, "GET", "me", nil, &me); err != nil {
+		return nil, err
+	}
+	ws, err := c.GetWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ws) == 0 {
+		return nil, fmt.Errorf("toggl user does not belong to any workspaces")
+	}
+	for i := range ws {
+		if ws[i].ID == me.DefaultWorkspaceID {
+			return &ws[i], nil
+		}
+	}
+	return &ws[0], nil
+}
+
+// FindWorkspace returns the workspace whose name (modulo case) or ID is
+// 'nameOrID'. If 'nameOrID' is empty, the default workspace is returned
+func (c *Client) FindWorkspace(ctx context.Context, nameOrID string) (*Workspace, error) {
+	if nameOrID == "" {
+		return c.DefaultWorkspace(ctx)
+	}
+	ws, err := c.GetWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ws {
+		if strings.EqualFold(ws[i].Name, nameOrID) ||
+			strconv.FormatInt(ws[i].ID, 10) == nameOrID {
+			return &ws[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no toggl workspace named %q", nameOrID)
+}
+
+// ListCustomers returns all customers (Toggl clients) in the workspace
+// 'workspaceID'
+func (c *Client) ListCustomers(ctx context.Context, workspaceID int64) ([]Customer, error) {
+	var result []Customer
+	path := fmt.Sprintf("workspaces/%d/clients", workspaceID)
+	if err := c.do(ctx, "GET", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CreateCustomer creates a new customer (Toggl client). 'cu.Name' and
+// 'cu.WorkspaceID' must be set. The created customer is returned
+func (c *Client) CreateCustomer(ctx context.Context, cu Customer) (*Customer, error) {
+	if cu.Name == "" {
+		return nil, fmt.Errorf("cannot create a client with no name")
+	}
+	var result Customer
+	path := fmt.Sprintf("workspaces/%d/clients", cu.WorkspaceID)
+	if c.dryRun != nil {
+		c.dryRun.log("POST", path, &cu)
+		return c.dryRun.createCustomer(cu), nil
+	}
+	if err := c.do(ctx, "POST", path, &cu, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ResolveCustomer returns the customer (Toggl client) in 'workspaceID' whose
+// name matches 'name' (ignoring case), creating it if no such customer exists
+func (c *Client) ResolveCustomer(ctx context.Context, workspaceID int64, name string) (*Customer, error) {
+	customers, err := c.ListCustomers(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range customers {
+		if strings.EqualFold(customers[i].Name, name) {
+			return &customers[i], nil
+		}
+	}
+	return c.CreateCustomer(ctx, Customer{WorkspaceID: workspaceID, Name: name})
+}
+
+// ListProjects returns all projects in the workspace 'workspaceID'
+func (c *Client) ListProjects(ctx context.Context, workspaceID int64) ([]Project, error) {
+	var result []Project
+	path := fmt.Sprintf("workspaces/%d/projects", workspaceID)
+	if err := c.do(ctx, "GET", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CreateProject creates a new Toggl project. 'p.Name' and 'p.WorkspaceID' must
+// be set. The created project (including its ID) is returned
+func (c *Client) CreateProject(ctx context.Context, p Project) (*Project, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("cannot create a project with no name")
+	}
+	var result Project
+	path := fmt.Sprintf("workspaces/%d/projects", p.WorkspaceID)
+	if c.dryRun != nil {
+		c.dryRun.log("POST", path, &p)
+		return c.dryRun.createProject(p), nil
+	}
+	if err := c.do(ctx, "POST", path, &p, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ResolveProject returns the project in 'workspaceID' whose name matches
+// 'name' (ignoring case), creating it if no such project exists. If
+// 'clientID' is set, only the projects of that customer (Toggl client) match,
+// and the project is created for it
+func (c *Client) ResolveProject(ctx context.Context, workspaceID, clientID int64, name string) (*Project, error) {
+	projects, err := c.ListProjects(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if p := MatchProject(projects, clientID, name); p != nil {
+		return p, nil
+	}
+	return c.CreateProject(ctx, Project{
+		WorkspaceID: workspaceID,
+		ClientID:    clientID,
+		Name:        name,
+		Active:      true,
+	})
+}
+
+// MatchProject returns the project in 'projects' whose name matches 'name'
+// (ignoring case) and, if 'clientID' is set, that belongs to that customer
+// (Toggl client). It returns nil if there's no such project
+func MatchProject(projects []Project, clientID int64, name string) *Project {
+	for i := range projects {
+		if strings.EqualFold(projects[i].Name, name) &&
+			(clientID == 0 || projects[i].ClientID == clientID) {
+			return &projects[i]
+		}
+	}
+	return nil
+}
+
+// CreateTimeEntry creates a new Toggl time entry. If 'e.Stop' is nil, a running
+// time entry is started at 'e.Start' (or now, if 'e.Start' is unset). If
+// 'e.WorkspaceID' is unset, the entry is created in the client's workspace
+// (see SetWorkspace). The created time entry (including its ID) is returned
+func (c *Client) CreateTimeEntry(ctx context.Context, e TimeEntry) (*TimeEntry, error) {
+	if e.WorkspaceID == 0 {
+		wid, err := c.Workspace(ctx)
+		if err != nil {
+			return nil, err
+		}
+		e.WorkspaceID = wid
+	}
+	if e.Start.IsZero() {
+		e.Start = time.Now()
+	}
+	if e.Stop == nil {
+		e.Duration = -e.Start.Unix()
+	} else {
+		e.Duration = int64(e.Stop.Sub(e.Start) / time.Second)
+	}
+	e.CreatedWith = createdWith
+	var result TimeEntry
+	path := fmt.Sprintf("workspaces/%d/time_entries", e.WorkspaceID)
+	if c.dryRun != nil {
+		c.dryRun.log("POST", path, &e)
+		return c.dryRun.createTimeEntry(e), nil
+	}
+	if err := c.do(ctx, "POST", path, &e, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListTimeEntries returns the time entries that started in [start, end)
+func (c *Client) ListTimeEntries(ctx context.Context, start, end time.Time) ([]TimeEntry, error) {
+	q := url.Values{}
+	q.Set("start_date", start.Format(time.RFC3339))
+	q.Set("end_date", end.Format(time.RFC3339))
+	var result []TimeEntry
+	if err := c.do(ctx, "GET", "me/time_entries?"+q.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetTimeEntry returns the current user's time entry with the ID 'id'
+func (c *Client) GetTimeEntry(ctx context.Context, id int64) (*TimeEntry, error) {
+	var result TimeEntry
+	if err := c.do(ctx, "GET", fmt.Sprintf("me/time_entries/%d", id), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StopTimeEntry stops the running time entry with the ID 'id' in
+// 'workspaceID' (or, if it's 0, the client's workspace). The stopped time
+// entry is returned
+func (c *Client) StopTimeEntry(ctx context.Context, workspaceID, id int64) (*TimeEntry, error) {
+	wid, err := c.workspaceOr(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var result TimeEntry
+	path := fmt.Sprintf("workspaces/%d/time_entries/%d/stop", wid, id)
+	if c.dryRun != nil {
+		c.dryRun.log("PATCH", path, nil)
+		return c.dryRun.stopTimeEntry(id), nil
+	}
+	if err := c.do(ctx, "PATCH", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateTimeEntry applies 'u' to the time entry with the ID 'id' in
+// 'workspaceID' (or, if it's 0, the client's workspace). The updated time
+// entry is returned
+func (c *Client) UpdateTimeEntry(ctx context.Context, workspaceID, id int64, u TimeEntryUpdate) (*TimeEntry, error) {
+	wid, err := c.workspaceOr(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var result TimeEntry
+	path := fmt.Sprintf("workspaces/%d/time_entries/%d", wid, id)
+	if c.dryRun != nil {
+		c.dryRun.log("PUT", path, &u)
+		return c.dryRun.updateTimeEntry(id, u), nil
+	}
+	if err := c.do(ctx, "PUT", path, &u, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,290 @@
+package togglclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client pointed at a test server that serves
+// requests with 'handler'
+func newTestClient(t testing.TB, handler http.HandlerFunc) *Client {
+	t.Helper()
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	c := New("token")
+	c.BaseURL = s.URL + "/api/v9/"
+	c.workspaceID = 1
+	return c
+}
+
+func TestGetWorkspaces(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/v9/me/workspaces" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`[{"id": 1, "name": "a"}, {"id": 2, "name": "b"}]`))
+	})
+	ws, err := c.GetWorkspaces(context.Background())
+	if err != nil {
+		t.Fatalf("could not get workspaces: %v", err)
+	}
+	if len(ws) != 2 || ws[0].ID != 1 || ws[1].Name != "b" {
+		t.Fatalf("unexpected workspaces: %+v", ws)
+	}
+}
+
+func TestMe(t *testing.T) {
+	date := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/v9/me" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+		w.Write([]byte(`{"id": 7, "email": "a@example.com", "default_workspace_id": 1}`))
+	})
+	me, serverTime, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatalf("could not get user: %v", err)
+	}
+	if me.ID != 7 || me.Email != "a@example.com" || !serverTime.Equal(date) {
+		t.Fatalf("unexpected user %+v at %s", me, serverTime)
+	}
+}
+
+func TestRequestShape(t *testing.T) {
+	start := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		w.Write([]byte(`[]`))
+	}))
+	defer s.Close()
+
+	// The base URL's path is kept, with or without a trailing slash, and the
+	// token is sent as a single basic-auth credential ("token:api_token")
+	for _, base := range []string{s.URL + "/api/v9/", s.URL + "/api/v9"} {
+		got = nil
+		c := New("token")
+		c.BaseURL = base
+		if _, err := c.ListTimeEntries(context.Background(), start, start.Add(time.Hour)); err != nil {
+			t.Fatalf("could not list time entries: %v", err)
+		}
+		expected := "GET /api/v9/me/time_entries?end_date=2020-01-02T11%3A00%3A00Z&" +
+			"start_date=2020-01-02T10%3A00%3A00Z Basic dG9rZW46YXBpX3Rva2Vu"
+		if len(got) != 1 || got[0] != expected {
+			t.Fatalf("with base URL %q, expected request %q, but got %q", base, expected, got)
+		}
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v9/workspaces/1/projects" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var p Project
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+		p.ID = 7
+		json.NewEncoder(w).Encode(p)
+	})
+	p, err := c.CreateProject(context.Background(), Project{WorkspaceID: 1, Name: "toggl-watcher"})
+	if err != nil {
+		t.Fatalf("could not create project: %v", err)
+	}
+	if p.ID != 7 || p.Name != "toggl-watcher" || p.WorkspaceID != 1 {
+		t.Fatalf("unexpected project: %+v", p)
+	}
+}
+
+func TestCreateRunningTimeEntry(t *testing.T) {
+	start := time.Date(2018, 6, 1, 9, 0, 0, 0, time.UTC)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v9/workspaces/1/time_entries" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var e TimeEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+		e.ID = 3
+		json.NewEncoder(w).Encode(e)
+	})
+	e, err := c.CreateTimeEntry(context.Background(), TimeEntry{ProjectID: 7, Start: start})
+	if err != nil {
+		t.Fatalf("could not create time entry: %v", err)
+	}
+	if !e.Running() || e.Duration != -start.Unix() {
+		t.Fatalf("expected running time entry, but got %+v", e)
+	}
+	if e.WorkspaceID != 1 || e.CreatedWith != createdWith {
+		t.Fatalf("expected created_with %q but got %q", createdWith, e.CreatedWith)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such time entry", http.StatusNotFound)
+	})
+	_, err := c.StopTimeEntry(context.Background(), 0, 12)
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("expected *APIError, but got %T (%v)", err, err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Method != "PATCH" {
+		t.Fatalf("unexpected error: %v", apiErr)
+	}
+}
+
+func TestResolveProject(t *testing.T) {
+	var created bool
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v9/workspaces/1/projects":
+			w.Write([]byte(`[{"id": 5, "workspace_id": 1, "name": "Toggl-Watcher"}]`))
+		case r.Method == "POST" && r.URL.Path == "/api/v9/workspaces/1/projects":
+			created = true
+			w.Write([]byte(`{"id": 6, "workspace_id": 1, "name": "other"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	p, err := c.ResolveProject(context.Background(), 1, 0, "toggl-watcher")
+	if err != nil || p.ID != 5 || created {
+		t.Fatalf("expected existing project 5, but got %+v (%v, created: %v)", p, err, created)
+	}
+	p, err = c.ResolveProject(context.Background(), 1, 0, "other")
+	if err != nil || p.ID != 6 || !created {
+		t.Fatalf("expected new project 6, but got %+v (%v, created: %v)", p, err, created)
+	}
+}
+
+func TestResolveCustomerProject(t *testing.T) {
+	var body map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v9/workspaces/2/clients":
+			w.Write([]byte(`[{"id": 7, "wid": 2, "name": "Acme"}]`))
+		case r.Method == "GET" && r.URL.Path == "/api/v9/workspaces/2/projects":
+			w.Write([]byte(`[{"id": 5, "workspace_id": 2, "name": "Website"}]`))
+		case r.Method == "POST" && r.URL.Path == "/api/v9/workspaces/2/projects":
+			json.NewDecoder(r.Body).Decode(&body)
+			w.Write([]byte(`{"id": 6, "workspace_id": 2, "client_id": 7, "name": "Website"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	cu, err := c.ResolveCustomer(context.Background(), 2, "acme")
+	if err != nil || cu.ID != 7 {
+		t.Fatalf("expected existing client 7, but got %+v (%v)", cu, err)
+	}
+
+	// The existing project belongs to no client, so another is created
+	p, err := c.ResolveProject(context.Background(), 2, cu.ID, "website")
+	if err != nil || p.ID != 6 || body["client_id"] != float64(7) {
+		t.Fatalf("expected new project 6 for client 7, but got %+v (%v, sent %v)", p, err, body)
+	}
+}
+
+func TestUpdateTimeEntry(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/api/v9/workspaces/1/time_entries/3" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var fields map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+		// Only the fields being updated should be sent
+		if len(fields) != 1 || fields["description"] != "d" {
+			t.Errorf("unexpected update: %v", fields)
+		}
+		w.Write([]byte(`{"id": 3, "description": "d"}`))
+	})
+	desc := "d"
+	e, err := c.UpdateTimeEntry(context.Background(), 0, 3, TimeEntryUpdate{Description: &desc})
+	if err != nil || e.Description != "d" {
+		t.Fatalf("unexpected result %+v (%v)", e, err)
+	}
+}
+
+func TestScopedTokens(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "read-token" {
+			t.Errorf("expected GET request to use read token, but got %q", user)
+		}
+		w.Write([]byte(`[]`))
+	})
+	c.readToken, c.writeToken = "read-token", ""
+	if _, err := c.GetWorkspaces(context.Background()); err != nil {
+		t.Fatalf("could not get workspaces: %v", err)
+	}
+	// Without a write token, requests that modify Toggl fail without being sent
+	if _, err := c.StopTimeEntry(context.Background(), 0, 1); err == nil {
+		t.Fatalf("expected error stopping time entry without a write token")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release // hang until the test ends
+	})
+	c.SetTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err := c.GetWorkspaces(context.Background())
+	if _, ok := err.(*NetworkError); !ok || !Retryable(err) {
+		t.Fatalf("expected a retryable NetworkError, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s, despite a timeout of 50ms", elapsed)
+	}
+
+	// Canceling the request's context abandons it too
+	c.SetTimeout(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.GetWorkspaces(ctx); !Retryable(err) {
+		t.Fatalf("expected a retryable error, but got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("unexpected request %s %s in dry-run mode", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`[{"id": 3, "workspace_id": 1, "name": "existing"}]`))
+	})
+	c.SetDryRun(true)
+
+	// Existing projects are still read from Toggl, but new ones are faked
+	p, err := c.ResolveProject(context.Background(), 1, 0, "existing")
+	if err != nil || p.ID != 3 {
+		t.Fatalf("expected existing project 3, but got %+v (%v)", p, err)
+	}
+	p, err = c.ResolveProject(context.Background(), 1, 0, "new")
+	if err != nil || p.ID >= 0 || p.Name != "new" {
+		t.Fatalf("expected a fake project, but got %+v (%v)", p, err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	e, err := c.CreateTimeEntry(context.Background(), TimeEntry{ProjectID: p.ID, Start: start})
+	if err != nil || e.ID >= 0 || !e.Running() {
+		t.Fatalf("expected a fake running entry, but got %+v (%v)", e, err)
+	}
+	desc := "dry run"
+	if e, err = c.UpdateTimeEntry(context.Background(), 0, e.ID, TimeEntryUpdate{Description: &desc}); err != nil ||
+		e.Description != desc || !e.Start.Equal(start) {
+		t.Fatalf("unexpected updated entry %+v (%v)", e, err)
+	}
+	if e, err = c.StopTimeEntry(context.Background(), 0, e.ID); err != nil || e.Running() || e.Duration < 3600 {
+		t.Fatalf("expected a stopped entry an hour long, but got %+v (%v)", e, err)
+	}
+}
@@ -0,0 +1,128 @@
+package togglclient
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/logging"
+)
+
+var log = logging.With("component", "toggl")
+
+// firstDryRunID is the ID of the first project or time entry that a client in
+// dry-run mode pretends to create. Later ones count down from it, so that
+// they can't be mistaken for real IDs (which are positive)
+const firstDryRunID = -1000
+
+// dryRun fakes the responses to the requests of a client in dry-run mode that
+// would modify Toggl (see SetDryRun), remembering the time entries that it
+// pretended to create so that they can be updated and stopped
+type dryRun struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries map[int64]TimeEntry
+}
+
+// SetDryRun puts 'c' in (or takes it out of) dry-run mode, in which requests
+// that would modify Toggl are logged instead of sent, and answered as Toggl
+// would answer them. Requests that only read from Toggl are still sent
+func (c *Client) SetDryRun(enabled bool) {
+	c.dryRun = nil
+	if enabled {
+		c.dryRun = &dryRun{nextID: firstDryRunID, entries: make(map[int64]TimeEntry)}
+	}
+}
+
+// DryRun returns true if 'c' is in dry-run mode (see SetDryRun)
+func (c *Client) DryRun() bool {
+	return c.dryRun != nil
+}
+
+// log logs the request that wasn't sent
+func (d *dryRun) log(method, path string, in interface{}) {
+	body := ""
+	if in != nil {
+		data, _ := json.Marshal(in)
+		body = " " + string(data)
+	}
+	log.Infof("dry run: not sending %s %s%s", method, path, body)
+}
+
+// newID returns the ID of the next project or time entry that's created.
+// d.mu must be held by the caller
+func (d *dryRun) newID() int64 {
+	id := d.nextID
+	d.nextID--
+	return id
+}
+
+func (d *dryRun) createCustomer(cu Customer) *Customer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cu.ID = d.newID()
+	return &cu
+}
+
+func (d *dryRun) createProject(p Project) *Project {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p.ID = d.newID()
+	return &p
+}
+
+func (d *dryRun) createTimeEntry(e TimeEntry) *TimeEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e.ID = d.newID()
+	d.entries[e.ID] = e
+	return &e
+}
+
+// entry returns the time entry 'id' that 'd' pretended to create or, if it's
+// a real time entry, one with only its ID set. d.mu must be held by the caller
+func (d *dryRun) entry(id int64) TimeEntry {
+	if e, ok := d.entries[id]; ok {
+		return e
+	}
+	return TimeEntry{ID: id}
+}
+
+func (d *dryRun) stopTimeEntry(id int64) *TimeEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(id)
+	stop := time.Now()
+	e.Stop, e.Duration = &stop, int64(stop.Sub(e.Start)/time.Second)
+	d.entries[id] = e
+	return &e
+}
+
+func (d *dryRun) updateTimeEntry(id int64, u TimeEntryUpdate) *TimeEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(id)
+	if u.ProjectID != nil {
+		e.ProjectID = *u.ProjectID
+	}
+	if u.Description != nil {
+		e.Description = *u.Description
+	}
+	if u.Billable != nil {
+		e.Billable = *u.Billable
+	}
+	if u.Tags != nil {
+		e.Tags = u.Tags
+	}
+	if u.Start != nil {
+		e.Start = *u.Start
+	}
+	if u.Stop != nil {
+		e.Stop = u.Stop
+	}
+	if e.Stop != nil {
+		e.Duration = int64(e.Stop.Sub(e.Start) / time.Second)
+	}
+	d.entries[id] = e
+	return &e
+}
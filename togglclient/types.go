@@ -0,0 +1,113 @@
+package togglclient
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Workspace is a Toggl workspace. All projects and time entries belong to
+// exactly one workspace
+type Workspace struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Customer is a Toggl client: the customer for whom the work in its projects
+// is done. (It's called a customer here, so as not to be confused with Client)
+type Customer struct {
+	ID          int64  `json:"id,omitempty"`
+	WorkspaceID int64  `json:"wid"`
+	Name        string `json:"name"`
+}
+
+// Project is a Toggl project, to which time entries may be assigned
+type Project struct {
+	ID          int64  `json:"id,omitempty"`
+	WorkspaceID int64  `json:"workspace_id"`
+	ClientID    int64  `json:"client_id,omitempty"`
+	Name        string `json:"name"`
+	Active      bool   `json:"active"`
+	Billable    bool   `json:"billable,omitempty"`
+}
+
+// TimeEntry is a Toggl time entry. If a time entry is running, 'Stop' is nil
+// and 'Duration' is negative (per the Toggl API docs, it's -1 times the unix
+// timestamp of 'Start')
+type TimeEntry struct {
+	ID          int64      `json:"id,omitempty"`
+	WorkspaceID int64      `json:"workspace_id,omitempty"`
+	ProjectID   int64      `json:"project_id,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Start       time.Time  `json:"start"`
+	Stop        *time.Time `json:"stop,omitempty"`
+	Duration    int64      `json:"duration"`
+	Billable    bool       `json:"billable,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	CreatedWith string     `json:"created_with,omitempty"`
+}
+
+// Running returns true if 'e' has not been stopped yet
+func (e *TimeEntry) Running() bool {
+	return e.Stop == nil && e.Duration < 0
+}
+
+// TimeEntryUpdate describes a change to an existing time entry. Only non-nil
+// fields are sent to Toggl (and therefore changed)
+type TimeEntryUpdate struct {
+	ProjectID   *int64   `json:"project_id,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Billable    *bool    `json:"billable,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Start moves the start of the time entry. A stopped entry's duration is
+	// recomputed from its start and stop
+	Start *time.Time `json:"start,omitempty"`
+	// Stop stops a running time entry at the given time (unlike
+	// Client.StopTimeEntry, which stops it at the time of the request), or
+	// moves the stop of a stopped one
+	Stop *time.Time `json:"stop,omitempty"`
+}
+
+// APIError is returned by Client methods when Toggl responds to a request with
+// a non-2xx status code
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("toggl request %s %s failed with status %d: %s",
+		e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// NetworkError is returned by Client methods when Toggl can't be reached
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("could not reach toggl: %v", e.Err)
+}
+
+// Retryable returns true if 'err' (returned by a Client method) may succeed if
+// the request is retried later, e.g. because the network or Toggl was down
+func Retryable(err error) bool {
+	switch e := err.(type) {
+	case *NetworkError:
+		return true
+	case *APIError:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// User is the subset of the Toggl user (returned by the "me" endpoint) that tg
+// uses
+type User struct {
+	ID                 int64  `json:"id"`
+	Email              string `json:"email"`
+	Fullname           string `json:"fullname"`
+	DefaultWorkspaceID int64  `json:"default_workspace_id"`
+}
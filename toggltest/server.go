@@ -0,0 +1,299 @@
+// Package toggltest provides an in-memory fake of the subset of the Toggl API
+// that toggl-watcher uses, for tests and demos
+package toggltest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// Server is a fake Toggl API server. It holds all workspaces, projects, and
+// time entries in memory
+type Server struct {
+	// URL is the base URL of the fake API (suitable for Client.BaseURL)
+	URL string
+
+	server *httptest.Server
+
+	// mu guards all fields below
+	mu          sync.Mutex
+	nextID      int64
+	workspaces  []togglclient.Workspace
+	customers   map[int64]*togglclient.Customer
+	projects    map[int64]*togglclient.Project
+	timeEntries map[int64]*togglclient.TimeEntry
+	// unavailable causes every request to fail (see SetUnavailable)
+	unavailable bool
+}
+
+// NewServer starts a fake Toggl server with a single workspace. Callers must
+// call Close() when finished with it
+func NewServer() *Server {
+	s := &Server{
+		nextID:      100,
+		workspaces:  []togglclient.Workspace{{ID: 1, Name: "Default workspace"}},
+		customers:   make(map[int64]*togglclient.Customer),
+		projects:    make(map[int64]*togglclient.Project),
+		timeEntries: make(map[int64]*togglclient.TimeEntry),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL + "/api/v9/"
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns a Toggl client that sends requests to 's'
+func (s *Server) Client() *togglclient.Client {
+	c := togglclient.New("toggltest")
+	c.BaseURL = s.URL
+	return c
+}
+
+// AddWorkspace adds a workspace named 'name' to 's', and returns its ID
+func (s *Server) AddWorkspace(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws := togglclient.Workspace{ID: s.id(), Name: name}
+	s.workspaces = append(s.workspaces, ws)
+	return ws.ID
+}
+
+// SetUnavailable causes 's' to respond to every request with 503 Service
+// Unavailable (if 'unavailable' is true), as if Toggl were down
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = unavailable
+}
+
+// TimeEntries returns a copy of every time entry in 's', ordered by ID
+func (s *Server) TimeEntries() []togglclient.TimeEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]togglclient.TimeEntry, 0, len(s.timeEntries))
+	for _, e := range s.timeEntries {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Customers returns a copy of every customer (Toggl client) in 's', ordered by
+// ID
+func (s *Server) Customers() []togglclient.Customer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]togglclient.Customer, 0, len(s.customers))
+	for _, cu := range s.customers {
+		result = append(result, *cu)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Projects returns a copy of every project in 's', ordered by ID
+func (s *Server) Projects() []togglclient.Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]togglclient.Project, 0, len(s.projects))
+	for _, p := range s.projects {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// id allocates a new object ID. s.mu must be held by the caller
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pass, ok := r.BasicAuth(); !ok || pass != "api_token" {
+		http.Error(w, "missing or malformed basic auth", http.StatusForbidden)
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v9"), "/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unavailable {
+		http.Error(w, "toggltest is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	switch {
+	case r.Method == "GET" && match(path, "me"):
+		reply(w, map[string]interface{}{"default_workspace_id": s.workspaces[0].ID})
+	case r.Method == "GET" && match(path, "me", "workspaces"):
+		reply(w, s.workspaces)
+	case r.Method == "GET" && match(path, "workspaces", "*", "clients"):
+		wid, _ := strconv.ParseInt(path[1], 10, 64)
+		result := []togglclient.Customer{}
+		for _, cu := range s.customers {
+			if cu.WorkspaceID == wid {
+				result = append(result, *cu)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+		reply(w, result)
+	case r.Method == "GET" && match(path, "me", "time_entries", "*"):
+		id, _ := strconv.ParseInt(path[2], 10, 64)
+		e, ok := s.timeEntries[id]
+		if !ok {
+			http.Error(w, "no time entry with ID "+path[2], http.StatusNotFound)
+			return
+		}
+		reply(w, e)
+	case r.Method == "POST" && match(path, "workspaces", "*", "clients"):
+		var cu togglclient.Customer
+		if !decode(w, r, &cu) {
+			return
+		}
+		cu.ID = s.id()
+		cu.WorkspaceID, _ = strconv.ParseInt(path[1], 10, 64)
+		s.customers[cu.ID] = &cu
+		reply(w, cu)
+	case r.Method == "GET" && match(path, "workspaces", "*", "projects"):
+		wid, _ := strconv.ParseInt(path[1], 10, 64)
+		result := []togglclient.Project{}
+		for _, p := range s.projects {
+			if p.WorkspaceID == wid {
+				result = append(result, *p)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+		reply(w, result)
+	case r.Method == "POST" && match(path, "workspaces", "*", "projects"):
+		var p togglclient.Project
+		if !decode(w, r, &p) {
+			return
+		}
+		p.ID = s.id()
+		p.WorkspaceID, _ = strconv.ParseInt(path[1], 10, 64)
+		s.projects[p.ID] = &p
+		reply(w, p)
+	case r.Method == "GET" && match(path, "me", "time_entries"):
+		start, _ := time.Parse(time.RFC3339, r.URL.Query().Get("start_date"))
+		end, err := time.Parse(time.RFC3339, r.URL.Query().Get("end_date"))
+		if err != nil {
+			end = time.Now()
+		}
+		result := []togglclient.TimeEntry{}
+		for _, e := range s.timeEntries {
+			if !e.Start.Before(start) && e.Start.Before(end) {
+				result = append(result, *e)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+		reply(w, result)
+	case r.Method == "POST" && match(path, "workspaces", "*", "time_entries"):
+		var e togglclient.TimeEntry
+		if !decode(w, r, &e) {
+			return
+		}
+		e.ID = s.id()
+		e.WorkspaceID, _ = strconv.ParseInt(path[1], 10, 64)
+		s.timeEntries[e.ID] = &e
+		reply(w, e)
+	case r.Method == "PATCH" && match(path, "workspaces", "*", "time_entries", "*", "stop"):
+		e := s.timeEntry(w, path[1], path[3])
+		if e == nil {
+			return
+		}
+		if e.Running() {
+			now := time.Now()
+			e.Stop = &now
+			e.Duration = int64(now.Sub(e.Start) / time.Second)
+		}
+		reply(w, e)
+	case r.Method == "PUT" && match(path, "workspaces", "*", "time_entries", "*"):
+		e := s.timeEntry(w, path[1], path[3])
+		if e == nil {
+			return
+		}
+		var u togglclient.TimeEntryUpdate
+		if !decode(w, r, &u) {
+			return
+		}
+		if u.ProjectID != nil {
+			e.ProjectID = *u.ProjectID
+		}
+		if u.Description != nil {
+			e.Description = *u.Description
+		}
+		if u.Billable != nil {
+			e.Billable = *u.Billable
+		}
+		if u.Tags != nil {
+			e.Tags = u.Tags
+		}
+		if u.Start != nil {
+			e.Start = *u.Start
+		}
+		if u.Stop != nil {
+			stop := *u.Stop
+			e.Stop = &stop
+		}
+		if e.Stop != nil {
+			e.Duration = int64(e.Stop.Sub(e.Start) / time.Second)
+		}
+		reply(w, e)
+	default:
+		http.Error(w, "not implemented by toggltest", http.StatusNotFound)
+	}
+}
+
+// timeEntry returns the time entry in the workspace 'wid' whose ID is 'id',
+// or writes a 404 to 'w' and returns nil if there is no such time entry. s.mu
+// must be held
+func (s *Server) timeEntry(w http.ResponseWriter, wid, id string) *togglclient.TimeEntry {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	e, ok := s.timeEntries[n]
+	if !ok || strconv.FormatInt(e.WorkspaceID, 10) != wid {
+		http.Error(w, "no time entry with ID "+id, http.StatusNotFound)
+		return nil
+	}
+	return e
+}
+
+// match returns true if 'path' matches 'pattern', where "*" in 'pattern'
+// matches any single path component
+func match(path []string, pattern ...string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i := range path {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decode deserializes the body of 'r' into 'v', writing an error to 'w' and
+// returning false if that's not possible
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// reply writes 'v' to 'w' as JSON
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
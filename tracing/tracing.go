@@ -0,0 +1,232 @@
+// Package tracing records spans along the path from a write in a watched
+// directory to the resulting Toggl API calls, and exports them to an
+// OpenTelemetry collector (via OTLP/HTTP with JSON encoding), so that latency
+// can be attributed to a stage (debouncing, attribution, the API, etc.)
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// serviceName identifies tg's spans in the collector
+	serviceName = "tg"
+
+	// batchSize and flushInterval bound how long spans wait before being
+	// exported
+	batchSize     = 100
+	flushInterval = 5 * time.Second
+)
+
+// Span is a single timed operation. Spans with the same trace ID make up one
+// trace
+type Span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	attrs    map[string]string
+	err      error
+}
+
+// exporter, if set, receives every ended span. It's set by Start
+var (
+	exporterMu sync.Mutex
+	exporter   chan<- otlpSpan
+)
+
+// Start begins exporting spans to the OTLP/HTTP collector at 'endpoint' (e.g.
+// "http://localhost:4318"). Until Start is called, spans are discarded
+func Start(endpoint string) {
+	ch := make(chan otlpSpan, 10*batchSize)
+	exporterMu.Lock()
+	exporter = ch
+	exporterMu.Unlock()
+	go export(endpoint+"/v1/traces", ch)
+}
+
+// Enabled returns true if spans are being exported
+func Enabled() bool {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	return exporter != nil
+}
+
+// New starts a new trace, whose root span is named 'name' and started at
+// 'start'
+func New(name string, start time.Time) *Span {
+	return &Span{traceID: randomID(16), spanID: randomID(8), name: name, start: start}
+}
+
+// Child starts a span named 'name', at 'start', within the same trace as 's'.
+// Child may be called on a nil span, in which case it returns nil
+func (s *Span) Child(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{traceID: s.traceID, spanID: randomID(8), parentID: s.spanID,
+		name: name, start: start}
+}
+
+// SetAttr attaches the attribute 'key'='value' to 's'
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks 's' as failed with 'err' (if it's non-nil)
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.err = err
+	}
+}
+
+// End ends 's' at 'end' and queues it for export. If the export queue is full
+// (e.g. because the collector is unreachable), the span is dropped
+func (s *Span) End(end time.Time) {
+	if s == nil {
+		return
+	}
+	exporterMu.Lock()
+	ch := exporter
+	exporterMu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- s.otlp(end):
+	default:
+	}
+}
+
+// randomID returns a random hex-encoded ID of 'n' bytes
+func randomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// The types below are the subset of the OTLP/JSON trace format that tg uses
+// (see opentelemetry-proto's trace.proto)
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // 2 is STATUS_CODE_ERROR
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlp converts 's', ended at 'end', to its OTLP/JSON representation
+func (s *Span) otlp(end time.Time) otlpSpan {
+	result := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parentID,
+		Name:         s.name,
+		Kind:         1, // SPAN_KIND_INTERNAL
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(end.UnixNano(), 10),
+	}
+	for k, v := range s.attrs {
+		result.Attributes = append(result.Attributes, otlpAttr{k, otlpValue{v}})
+	}
+	if s.err != nil {
+		result.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+	}
+	return result
+}
+
+// export sends the spans received on 'ch' to 'url' in batches
+func export(url string, ch <-chan otlpSpan) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-ch:
+			if batch = append(batch, span); len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := post(url, batch); err != nil {
+			fmt.Fprintf(os.Stderr, "could not export %d spans: %v\n", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// post sends 'spans' to the OTLP/HTTP endpoint 'url'
+func post(url string, spans []otlpSpan) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{
+			{"service.name", otlpValue{serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{serviceName}, Spans: spans}},
+	}}}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	received := make(chan otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("could not decode export request: %v", err)
+		}
+		received <- req
+	}))
+	defer server.Close()
+
+	start := time.Unix(1000, 0)
+	root := New("write", start)
+	child := root.Child("tick", start.Add(time.Second))
+	child.SetError(errors.New("toggl is down"))
+	spans := []otlpSpan{child.otlp(start.Add(2 * time.Second)), root.otlp(start.Add(3 * time.Second))}
+	if err := post(server.URL, spans); err != nil {
+		t.Fatalf("could not export spans: %v", err)
+	}
+
+	req := <-received
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(got) != 2 || got[0].TraceID != got[1].TraceID || got[0].ParentSpanID != got[1].SpanID {
+		t.Fatalf("expected a child span and its parent in one trace, but got %+v", got)
+	}
+	if got[0].Start != "1001000000000" || got[0].Status.Code != 2 {
+		t.Fatalf("unexpected child span: %+v", got[0])
+	}
+}
@@ -0,0 +1,256 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClockifyBaseURL is the root of the Clockify API (v1)
+const ClockifyBaseURL = "https://api.clockify.me/api/v1/"
+
+// clockifyTime is the format of times in the Clockify API, which are always
+// in UTC
+const clockifyTime = "2006-01-02T15:04:05Z"
+
+// Clockify is the TimeTracker for Clockify
+type Clockify struct {
+	api
+
+	// workspace is the name or ID of the workspace in which time entries are
+	// created, or "" for the user's active workspace. workspaceID is its ID,
+	// and userID is the user's ID, once they're looked up
+	workspace           string
+	workspaceID, userID string
+
+	// projects and tags cache the IDs of projects and tags by lowercased
+	// name, as they're resolved
+	projects, tags map[string]string
+}
+
+// NewClockify returns a Clockify tracker that authenticates with the API key
+// 'apiKey' and records time entries in 'workspace' (a workspace name or ID,
+// or "" for the user's active workspace)
+func NewClockify(apiKey, workspace string) *Clockify {
+	return &Clockify{
+		api: api{
+			name:       "clockify",
+			baseURL:    ClockifyBaseURL,
+			header:     http.Header{"X-Api-Key": {apiKey}},
+			httpClient: httpClient,
+		},
+		workspace: workspace,
+		projects:  make(map[string]string),
+		tags:      make(map[string]string),
+	}
+}
+
+// SetBaseURL overrides the root of the Clockify API (e.g. in tests)
+func (c *Clockify) SetBaseURL(u string) {
+	c.baseURL = u
+}
+
+type clockifyUser struct {
+	ID              string `json:"id"`
+	ActiveWorkspace string `json:"activeWorkspace"`
+}
+
+type clockifyNamed struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type clockifyInterval struct {
+	Start string  `json:"start"`
+	End   *string `json:"end"`
+}
+
+type clockifyEntry struct {
+	ID           string            `json:"id,omitempty"`
+	Start        string            `json:"start,omitempty"`
+	End          string            `json:"end,omitempty"`
+	ProjectID    string            `json:"projectId,omitempty"`
+	Description  string            `json:"description"`
+	Billable     bool              `json:"billable"`
+	TagIDs       []string          `json:"tagIds,omitempty"`
+	TimeInterval *clockifyInterval `json:"timeInterval,omitempty"`
+
+	// Project and Tags are only returned in hydrated responses
+	Project *clockifyNamed  `json:"project,omitempty"`
+	Tags    []clockifyNamed `json:"tags,omitempty"`
+}
+
+func (c *Clockify) Name() string {
+	return "clockify"
+}
+
+// init looks up the IDs of the user and their workspace, if they aren't
+// known yet
+func (c *Clockify) init(ctx context.Context) error {
+	if c.workspaceID != "" {
+		return nil
+	}
+	var me clockifyUser
+	if err := c.do(ctx, "GET", "user", nil, &me); err != nil {
+		return err
+	}
+	workspaceID := me.ActiveWorkspace
+	if c.workspace != "" {
+		var workspaces []clockifyNamed
+		if err := c.do(ctx, "GET", "workspaces", nil, &workspaces); err != nil {
+			return err
+		}
+		workspaceID = ""
+		for _, ws := range workspaces {
+			if ws.ID == c.workspace || strings.EqualFold(ws.Name, c.workspace) {
+				workspaceID = ws.ID
+				break
+			}
+		}
+		if workspaceID == "" {
+			return fmt.Errorf("no clockify workspace named %q", c.workspace)
+		}
+	}
+	c.userID, c.workspaceID = me.ID, workspaceID
+	return nil
+}
+
+// ensure returns the ID of the project or tag (per 'kind', the name of its
+// collection in the API) named 'name', creating it if it doesn't exist
+func (c *Clockify) ensure(ctx context.Context, kind string, cache map[string]string, name string) (string, error) {
+	if id, ok := cache[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+	if err := c.init(ctx); err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("workspaces/%s/%s", c.workspaceID, kind)
+	var found []clockifyNamed
+	if err := c.do(ctx, "GET", path+"?name="+url.QueryEscape(name), nil, &found); err != nil {
+		return "", err
+	}
+	for _, f := range found {
+		if strings.EqualFold(f.Name, name) {
+			cache[strings.ToLower(name)] = f.ID
+			return f.ID, nil
+		}
+	}
+	var created clockifyNamed
+	if err := c.do(ctx, "POST", path, clockifyNamed{Name: name}, &created); err != nil {
+		return "", err
+	}
+	cache[strings.ToLower(name)] = created.ID
+	return created.ID, nil
+}
+
+func (c *Clockify) EnsureProject(ctx context.Context, name string) (string, error) {
+	return c.ensure(ctx, "projects", c.projects, name)
+}
+
+func (c *Clockify) StartEntry(ctx context.Context, e Entry) (string, error) {
+	if err := c.init(ctx); err != nil {
+		return "", err
+	}
+	var tagIDs []string
+	for _, tag := range e.Tags {
+		id, err := c.ensure(ctx, "tags", c.tags, tag)
+		if err != nil {
+			return "", err
+		}
+		tagIDs = append(tagIDs, id)
+	}
+	start := e.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	var created clockifyEntry
+	path := fmt.Sprintf("workspaces/%s/time-entries", c.workspaceID)
+	err := c.do(ctx, "POST", path, clockifyEntry{
+		Start:       start.UTC().Format(clockifyTime),
+		ProjectID:   e.ProjectID,
+		Description: e.Description,
+		Billable:    e.Billable,
+		TagIDs:      tagIDs,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *Clockify) StopEntry(ctx context.Context, id string, start, stop time.Time) error {
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	// Clockify replaces the whole entry on update, so its settings are read
+	// first to keep them
+	path := fmt.Sprintf("workspaces/%s/time-entries/%s", c.workspaceID, id)
+	var e clockifyEntry
+	if err := c.do(ctx, "GET", path, nil, &e); err != nil {
+		return err
+	}
+	if e.TimeInterval == nil {
+		return fmt.Errorf("clockify time entry %s has no start", id)
+	}
+	return c.do(ctx, "PUT", path, clockifyEntry{
+		Start:       e.TimeInterval.Start,
+		End:         stop.UTC().Format(clockifyTime),
+		ProjectID:   e.ProjectID,
+		Description: e.Description,
+		Billable:    e.Billable,
+		TagIDs:      e.TagIDs,
+	}, nil)
+}
+
+func (c *Clockify) ListEntries(ctx context.Context, from, to time.Time) ([]Entry, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("start", from.UTC().Format(clockifyTime))
+	q.Set("end", to.UTC().Format(clockifyTime))
+	q.Set("hydrated", "true")
+	q.Set("page-size", "1000")
+	var entries []clockifyEntry
+	path := fmt.Sprintf("workspaces/%s/user/%s/time-entries?%s", c.workspaceID,
+		c.userID, q.Encode())
+	if err := c.do(ctx, "GET", path, nil, &entries); err != nil {
+		return nil, err
+	}
+	result := make([]Entry, 0, len(entries))
+	for _, ce := range entries {
+		e := Entry{
+			ID:          ce.ID,
+			ProjectID:   ce.ProjectID,
+			Description: ce.Description,
+			Billable:    ce.Billable,
+		}
+		if ce.Project != nil {
+			e.Project = ce.Project.Name
+		}
+		for _, tag := range ce.Tags {
+			e.Tags = append(e.Tags, tag.Name)
+		}
+		if ce.TimeInterval == nil {
+			continue
+		}
+		var err error
+		if e.Start, err = time.Parse(time.RFC3339, ce.TimeInterval.Start); err != nil {
+			return nil, fmt.Errorf("could not parse start of clockify entry %s: %v", ce.ID, err)
+		}
+		if end := ce.TimeInterval.End; end != nil {
+			stop, err := time.Parse(time.RFC3339, *end)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse end of clockify entry %s: %v", ce.ID, err)
+			}
+			e.Stop = &stop
+		}
+		if !e.Start.Before(from) && e.Start.Before(to) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClockify serves the subset of the Clockify API that Clockify uses
+type fakeClockify struct {
+	mu       sync.Mutex
+	projects []clockifyNamed
+	tags     []clockifyNamed
+	entries  map[string]*clockifyEntry
+}
+
+func (f *fakeClockify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Api-Key") != "key" {
+		http.Error(w, "bad key", http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	switch {
+	case path == "user":
+		reply(clockifyUser{ID: "u1", ActiveWorkspace: "w1"})
+	case path == "workspaces/w1/projects" || path == "workspaces/w1/tags":
+		list := &f.projects
+		if strings.HasSuffix(path, "tags") {
+			list = &f.tags
+		}
+		if r.Method == "GET" {
+			reply(*list)
+			return
+		}
+		var n clockifyNamed
+		json.NewDecoder(r.Body).Decode(&n)
+		n.ID = n.Name + "-id"
+		*list = append(*list, n)
+		reply(n)
+	case path == "workspaces/w1/time-entries":
+		var e clockifyEntry
+		json.NewDecoder(r.Body).Decode(&e)
+		e.ID = "e1"
+		e.TimeInterval = &clockifyInterval{Start: e.Start}
+		f.entries[e.ID] = &e
+		reply(e)
+	case path == "workspaces/w1/time-entries/e1":
+		if r.Method == "PUT" {
+			var e clockifyEntry
+			json.NewDecoder(r.Body).Decode(&e)
+			end := e.End
+			f.entries["e1"].TimeInterval = &clockifyInterval{Start: e.Start, End: &end}
+		}
+		reply(f.entries["e1"])
+	case path == "workspaces/w1/user/u1/time-entries":
+		var result []clockifyEntry
+		for _, e := range f.entries {
+			hydrated := *e
+			hydrated.Project = &clockifyNamed{ID: e.ProjectID, Name: "Project"}
+			result = append(result, hydrated)
+		}
+		reply(result)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestClockify(t *testing.T) {
+	fake := &fakeClockify{entries: make(map[string]*clockifyEntry)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	ctx := context.Background()
+	c := NewClockify("key", "")
+	c.SetBaseURL(server.URL + "/")
+
+	// Projects are created once, and then found
+	projectID, err := c.EnsureProject(ctx, "Project")
+	if err != nil || projectID != "Project-id" {
+		t.Fatalf("expected project ID Project-id, but got %q (%v)", projectID, err)
+	}
+	if id, err := c.EnsureProject(ctx, "project"); err != nil || id != projectID ||
+		len(fake.projects) != 1 {
+		t.Fatalf("expected the existing project, but got %q (%v) with %d projects",
+			id, err, len(fake.projects))
+	}
+
+	start := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	id, err := c.StartEntry(ctx, Entry{ProjectID: projectID, Description: "d",
+		Tags: []string{"x"}, Start: start})
+	if err != nil {
+		t.Fatalf("could not start entry: %v", err)
+	}
+	if e := fake.entries[id]; e.Start != "2020-01-02T10:00:00Z" || len(e.TagIDs) != 1 ||
+		e.TagIDs[0] != "x-id" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if err := c.StopEntry(ctx, id, start, start.Add(time.Hour)); err != nil {
+		t.Fatalf("could not stop entry: %v", err)
+	}
+
+	entries, err := c.ListEntries(ctx, start.Add(-time.Hour), start.Add(time.Hour))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one entry, but got %+v (%v)", entries, err)
+	}
+	if e := entries[0]; e.Project != "Project" || e.Description != "d" ||
+		!e.Start.Equal(start) || e.Stop == nil || !e.Stop.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
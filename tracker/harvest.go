@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HarvestBaseURL is the root of the Harvest API (v2)
+const HarvestBaseURL = "https://api.harvestapp.com/v2/"
+
+// harvestDate is the format of dates (e.g. spent_date) in the Harvest API
+const harvestDate = "2006-01-02"
+
+// Harvest is the TimeTracker for Harvest. Harvest projects can't be created
+// by tg (they belong to clients, and users must be assigned to them), so
+// EnsureProject only finds the projects that the user is assigned to, and
+// time entries are created in the first active task of their project.
+// Harvest entries have no tags, and whether they're billable is decided by
+// their task
+type Harvest struct {
+	api
+
+	// userID is the user's ID, once it's looked up
+	userID int64
+
+	// tasks maps the IDs of the projects that EnsureProject has found to the
+	// ID of the task in which their time entries are created
+	tasks map[string]int64
+}
+
+// NewHarvest returns a Harvest tracker that authenticates with the personal
+// access token 'token', in the Harvest account 'accountID'
+func NewHarvest(token, accountID string) *Harvest {
+	return &Harvest{
+		api: api{
+			name:    "harvest",
+			baseURL: HarvestBaseURL,
+			header: http.Header{
+				"Authorization":      {"Bearer " + token},
+				"Harvest-Account-Id": {accountID},
+				"User-Agent":         {"toggl-watcher"},
+			},
+			httpClient: httpClient,
+		},
+		tasks: make(map[string]int64),
+	}
+}
+
+// SetBaseURL overrides the root of the Harvest API (e.g. in tests)
+func (h *Harvest) SetBaseURL(u string) {
+	h.baseURL = u
+}
+
+type harvestNamed struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type harvestAssignments struct {
+	ProjectAssignments []struct {
+		Project         harvestNamed `json:"project"`
+		TaskAssignments []struct {
+			Task     harvestNamed `json:"task"`
+			IsActive bool         `json:"is_active"`
+		} `json:"task_assignments"`
+	} `json:"project_assignments"`
+	NextPage *int `json:"next_page"`
+}
+
+type harvestEntry struct {
+	ID        int64         `json:"id,omitempty"`
+	ProjectID int64         `json:"project_id,omitempty"`
+	TaskID    int64         `json:"task_id,omitempty"`
+	SpentDate string        `json:"spent_date,omitempty"`
+	Notes     string        `json:"notes,omitempty"`
+	Hours     *float64      `json:"hours,omitempty"`
+	Project   *harvestNamed `json:"project,omitempty"`
+	Billable  bool          `json:"billable,omitempty"`
+	IsRunning bool          `json:"is_running,omitempty"`
+	CreatedAt *time.Time    `json:"created_at,omitempty"`
+}
+
+type harvestEntries struct {
+	TimeEntries []harvestEntry `json:"time_entries"`
+	NextPage    *int           `json:"next_page"`
+}
+
+func (h *Harvest) Name() string {
+	return "harvest"
+}
+
+func (h *Harvest) EnsureProject(ctx context.Context, name string) (string, error) {
+	for page := 1; ; {
+		var resp harvestAssignments
+		path := "users/me/project_assignments?is_active=true&page=" + strconv.Itoa(page)
+		if err := h.do(ctx, "GET", path, nil, &resp); err != nil {
+			return "", err
+		}
+		for _, pa := range resp.ProjectAssignments {
+			if !strings.EqualFold(pa.Project.Name, name) {
+				continue
+			}
+			projectID := strconv.FormatInt(pa.Project.ID, 10)
+			for _, ta := range pa.TaskAssignments {
+				if ta.IsActive {
+					h.tasks[projectID] = ta.Task.ID
+					return projectID, nil
+				}
+			}
+			return "", fmt.Errorf("harvest project %q has no active tasks", name)
+		}
+		if resp.NextPage == nil {
+			break
+		}
+		page = *resp.NextPage
+	}
+	return "", fmt.Errorf("no harvest project named %q is assigned to you (harvest "+
+		"projects must be created in Harvest)", name)
+}
+
+func (h *Harvest) StartEntry(ctx context.Context, e Entry) (string, error) {
+	taskID, ok := h.tasks[e.ProjectID]
+	if !ok {
+		return "", fmt.Errorf("unknown harvest project %s (see EnsureProject)", e.ProjectID)
+	}
+	projectID, err := strconv.ParseInt(e.ProjectID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	start := e.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	// Entries created without hours are running timers. Their duration is set
+	// when they're stopped, which accounts for the backdated start
+	var created harvestEntry
+	err = h.do(ctx, "POST", "time_entries", harvestEntry{
+		ProjectID: projectID,
+		TaskID:    taskID,
+		SpentDate: start.Format(harvestDate),
+		Notes:     e.Description,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(created.ID, 10), nil
+}
+
+func (h *Harvest) StopEntry(ctx context.Context, id string, start, stop time.Time) error {
+	if err := h.do(ctx, "PATCH", "time_entries/"+id+"/stop", nil, nil); err != nil {
+		return err
+	}
+	hours := math.Round(stop.Sub(start).Hours()*100) / 100
+	return h.do(ctx, "PATCH", "time_entries/"+id, harvestEntry{Hours: &hours}, nil)
+}
+
+func (h *Harvest) ListEntries(ctx context.Context, from, to time.Time) ([]Entry, error) {
+	if h.userID == 0 {
+		var me harvestNamed
+		if err := h.do(ctx, "GET", "users/me", nil, &me); err != nil {
+			return nil, err
+		}
+		h.userID = me.ID
+	}
+	q := url.Values{}
+	q.Set("user_id", strconv.FormatInt(h.userID, 10))
+	q.Set("from", from.Format(harvestDate))
+	q.Set("to", to.Format(harvestDate))
+	var result []Entry
+	for page := 1; ; {
+		q.Set("page", strconv.Itoa(page))
+		var resp harvestEntries
+		if err := h.do(ctx, "GET", "time_entries?"+q.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, he := range resp.TimeEntries {
+			if e, ok := he.entry(); ok && !e.Start.Before(from) && e.Start.Before(to) {
+				result = append(result, e)
+			}
+		}
+		if resp.NextPage == nil {
+			break
+		}
+		page = *resp.NextPage
+	}
+	return result, nil
+}
+
+// entry converts 'he' to an Entry. Harvest only records the duration of
+// entries, so they're taken to start when they were created (which is
+// accurate for entries created by tg). 'ok' is false if that's unknown
+func (he harvestEntry) entry() (e Entry, ok bool) {
+	if he.CreatedAt == nil {
+		return e, false
+	}
+	e = Entry{
+		ID:          strconv.FormatInt(he.ID, 10),
+		Description: he.Notes,
+		Billable:    he.Billable,
+		Start:       *he.CreatedAt,
+	}
+	if he.Project != nil {
+		e.ProjectID, e.Project = strconv.FormatInt(he.Project.ID, 10), he.Project.Name
+	}
+	if !he.IsRunning && he.Hours != nil {
+		stop := e.Start.Add(time.Duration(*he.Hours * float64(time.Hour)))
+		e.Stop = &stop
+	}
+	return e, true
+}
@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHarvest(t *testing.T) {
+	created := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	var entry harvestEntry
+	var stopped bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" ||
+			r.Header.Get("Harvest-Account-Id") != "123" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+		switch path := strings.TrimPrefix(r.URL.Path, "/"); path {
+		case "users/me":
+			reply(harvestNamed{ID: 7})
+		case "users/me/project_assignments":
+			w.Write([]byte(`{"project_assignments": [{"project": {"id": 1, "name": "Other"},
+				"task_assignments": [{"task": {"id": 10}, "is_active": true}]},
+				{"project": {"id": 2, "name": "Project"}, "task_assignments": [
+				{"task": {"id": 20}, "is_active": false},
+				{"task": {"id": 21}, "is_active": true}]}], "next_page": null}`))
+		case "time_entries":
+			if r.Method == "POST" {
+				json.NewDecoder(r.Body).Decode(&entry)
+				entry.ID, entry.IsRunning, entry.CreatedAt = 5, true, &created
+				entry.Project = &harvestNamed{ID: entry.ProjectID, Name: "Project"}
+				reply(entry)
+				return
+			}
+			reply(harvestEntries{TimeEntries: []harvestEntry{entry}})
+		case "time_entries/5/stop":
+			stopped, entry.IsRunning = true, false
+		case "time_entries/5":
+			var update harvestEntry
+			json.NewDecoder(r.Body).Decode(&update)
+			entry.Hours = update.Hours
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	h := NewHarvest("token", "123")
+	h.SetBaseURL(server.URL + "/")
+
+	if _, err := h.EnsureProject(ctx, "Missing"); err == nil {
+		t.Fatalf("expected an error for a project that isn't assigned")
+	}
+	projectID, err := h.EnsureProject(ctx, "project")
+	if err != nil || projectID != "2" {
+		t.Fatalf("expected project 2, but got %q (%v)", projectID, err)
+	}
+	id, err := h.StartEntry(ctx, Entry{ProjectID: projectID, Description: "d", Start: created})
+	if err != nil || id != "5" {
+		t.Fatalf("expected entry 5, but got %q (%v)", id, err)
+	}
+	if entry.TaskID != 21 || entry.SpentDate != "2020-01-02" || entry.Notes != "d" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if err := h.StopEntry(ctx, id, created, created.Add(90*time.Minute)); err != nil {
+		t.Fatalf("could not stop entry: %v", err)
+	}
+	if !stopped || entry.Hours == nil || *entry.Hours != 1.5 {
+		t.Fatalf("expected entry to be stopped after 1.5 hours, but got %+v", entry)
+	}
+
+	entries, err := h.ListEntries(ctx, created.Add(-time.Hour), created.Add(time.Hour))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one entry, but got %+v (%v)", entries, err)
+	}
+	if e := entries[0]; e.Project != "Project" || e.Stop == nil ||
+		!e.Stop.Equal(created.Add(90*time.Minute)) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
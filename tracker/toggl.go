@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// toggl is the TimeTracker for Toggl, the default tracker
+type toggl struct {
+	client *togglclient.Client
+}
+
+// NewToggl returns a TimeTracker that records time entries in Toggl through
+// 'c' (in c's workspace; see togglclient.Client.SetWorkspace)
+func NewToggl(c *togglclient.Client) TimeTracker {
+	return &toggl{client: c}
+}
+
+func (t *toggl) Name() string {
+	return "toggl"
+}
+
+func (t *toggl) EnsureProject(ctx context.Context, name string) (string, error) {
+	wid, err := t.client.Workspace(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := t.client.ResolveProject(ctx, wid, 0, name)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(p.ID, 10), nil
+}
+
+func (t *toggl) StartEntry(ctx context.Context, e Entry) (string, error) {
+	projectID, err := strconv.ParseInt(e.ProjectID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	created, err := t.client.CreateTimeEntry(ctx, togglclient.TimeEntry{
+		ProjectID:   projectID,
+		Description: e.Description,
+		Tags:        e.Tags,
+		Billable:    e.Billable,
+		Start:       e.Start,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(created.ID, 10), nil
+}
+
+func (t *toggl) StopEntry(ctx context.Context, id string, start, stop time.Time) error {
+	entryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.UpdateTimeEntry(ctx, 0, entryID, togglclient.TimeEntryUpdate{Stop: &stop})
+	return err
+}
+
+func (t *toggl) ListEntries(ctx context.Context, from, to time.Time) ([]Entry, error) {
+	entries, err := t.client.ListTimeEntries(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string)
+	if wid, err := t.client.Workspace(ctx); err == nil {
+		if projects, err := t.client.ListProjects(ctx, wid); err == nil {
+			for _, p := range projects {
+				names[p.ID] = p.Name
+			}
+		}
+	}
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, Entry{
+			ID:          strconv.FormatInt(e.ID, 10),
+			ProjectID:   strconv.FormatInt(e.ProjectID, 10),
+			Project:     names[e.ProjectID],
+			Description: e.Description,
+			Tags:        e.Tags,
+			Billable:    e.Billable,
+			Start:       e.Start,
+			Stop:        e.Stop,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+// Package tracker abstracts the time tracking services in which tg records
+// time entries. Toggl is the default (see NewToggl); Clockify and Harvest are
+// also supported, for users who don't track time in Toggl
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/togglclient"
+)
+
+// Entry is a time entry in a TimeTracker
+type Entry struct {
+	// ID is the tracker's ID for the entry (set by ListEntries)
+	ID string
+	// ProjectID is the tracker's ID for the entry's project (see
+	// TimeTracker.EnsureProject), and Project is its name (set by ListEntries,
+	// if the tracker reports it)
+	ProjectID string
+	Project   string
+	// Description, Tags, and Billable are the entry's settings, where the
+	// tracker supports them (Harvest has no tags, and decides whether entries
+	// are billable by their task)
+	Description string
+	Tags        []string
+	Billable    bool
+	// Start is when the entry began, and Stop is when it ended (or nil, if
+	// it's still running)
+	Start time.Time
+	Stop  *time.Time
+}
+
+// TimeTracker is a time tracking service in which tg records time entries.
+// Requests made by its methods are abandoned when their contexts are done (or
+// after togglclient.DefaultTimeout)
+type TimeTracker interface {
+	// Name is the name of the tracker, for logs and errors
+	Name() string
+
+	// EnsureProject returns the ID of the project named 'name' (ignoring
+	// case), creating the project if the tracker allows it
+	EnsureProject(ctx context.Context, name string) (string, error)
+
+	// StartEntry starts a running time entry with the settings in 'e' (whose
+	// ProjectID must be set, and whose Stop is ignored), backdated to e.Start
+	// where the tracker allows it. It returns the new entry's ID
+	StartEntry(ctx context.Context, e Entry) (string, error)
+
+	// StopEntry stops the running time entry 'id', which began at 'start', at
+	// 'stop'
+	StopEntry(ctx context.Context, id string, start, stop time.Time) error
+
+	// ListEntries returns the current user's time entries that started in
+	// [from, to)
+	ListEntries(ctx context.Context, from, to time.Time) ([]Entry, error)
+}
+
+// APIError is returned by the Clockify and Harvest trackers when the service
+// responds to a request with a non-2xx status code
+type APIError struct {
+	Tracker    string
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s request %s %s failed with status %d: %s",
+		e.Tracker, e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// api sends JSON requests to the REST API of a time tracking service
+type api struct {
+	// name is the name of the service, for errors
+	name string
+	// baseURL is the root of the API, relative to which request paths are
+	// resolved
+	baseURL string
+	// header is sent with every request (e.g. to authenticate it)
+	header http.Header
+	// httpClient is used to send all requests, each of which is abandoned
+	// after togglclient.DefaultTimeout
+	httpClient *http.Client
+}
+
+// httpClient is shared by every api, and sends requests with the same
+// transport as the Toggl client
+var httpClient = &http.Client{Transport: togglclient.Transport}
+
+// do sends a request to the API, which is abandoned if 'ctx' is done or
+// togglclient.DefaultTimeout passes. If 'in' is non-nil, it's serialized as
+// the JSON request body, and if 'out' is non-nil, the response body is
+// deserialized into it
+func (a *api) do(ctx context.Context, method, path string, in, out interface{}) error {
+	base, err := url.Parse(a.baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s base URL %q: %v", a.name, a.baseURL, err)
+	}
+	rel, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("invalid %s request path %q: %v", a.name, path, err)
+	}
+	u := base.ResolveReference(rel)
+
+	var body io.Reader
+	if in != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(in); err != nil {
+			return fmt.Errorf("could not serialize %s request: %v", a.name, err)
+		}
+		body = buf
+	}
+	ctx, cancel := context.WithTimeout(ctx, togglclient.DefaultTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range a.header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach %s: %v", a.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{
+			Tracker:    a.name,
+			Method:     method,
+			URL:        u.String(),
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(msg)),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not parse %s response to %s %s: %v",
+			a.name, method, u, err)
+	}
+	return nil
+}
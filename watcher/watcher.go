@@ -0,0 +1,154 @@
+// Package watcher watches directory trees for changes. Unlike a single inotify
+// watch, a Watcher observes every directory under the ones passed to Add,
+// including directories created after Add returns (and anything created in
+// them before their own watch was established).
+package watcher
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/logging"
+)
+
+// EventType is the type of a WatchEvent
+type EventType uint8
+
+// The types of WatchEvent
+const (
+	// Create means that a file or directory was created (or moved into a
+	// watched directory from elsewhere)
+	Create EventType = iota
+	// Delete means that a file or directory was deleted (or moved out of the
+	// watched directories). A watched directory's deletion is reported by its
+	// parent or, if its parent isn't watched (e.g. it was passed to Add), by
+	// the directory itself
+	Delete
+	// Modify means that a file was written
+	Modify
+	// Overflow means that the kernel's event queue overflowed, so events after
+	// the WatchEvent's Time (possibly including the creation of directories that
+	// should be watched) were lost. Its Path is empty
+	Overflow
+	// Rename means that a file or directory was moved from OldPath to Path,
+	// both of which are in watched directories. A directory's subdirectories
+	// stay watched under their new paths
+	Rename
+	// Attrib means that a file's metadata (e.g. its permissions or timestamps)
+	// changed, without its contents being written. It's only sent if it's
+	// wanted (see Options.Mask)
+	Attrib
+)
+
+// EventMask is a set of EventTypes (see Options.Mask)
+type EventMask uint8
+
+// MaskOf returns the EventMask containing 'types'
+func MaskOf(types ...EventType) EventMask {
+	var m EventMask
+	for _, t := range types {
+		m |= 1 << t
+	}
+	return m
+}
+
+// Has returns true if 'm' contains 't'
+func (m EventMask) Has(t EventType) bool {
+	return m&(1<<t) != 0
+}
+
+// DefaultMask is the EventMask used if Options.Mask isn't set: every type
+// except Attrib
+var DefaultMask = MaskOf(Create, Delete, Modify, Rename)
+
+func (t EventType) String() string {
+	switch t {
+	case Create:
+		return "Create"
+	case Delete:
+		return "Delete"
+	case Modify:
+		return "Modify"
+	case Overflow:
+		return "Overflow"
+	case Rename:
+		return "Rename"
+	case Attrib:
+		return "Attrib"
+	}
+	return "Unknown"
+}
+
+// A WatchEvent is sent by a Watcher to report a change in a watched directory
+type WatchEvent struct {
+	Type  EventType
+	IsDir bool
+	Path  string
+
+	// OldPath is the path from which Path was moved, for Rename events
+	OldPath string
+
+	// Moved is set on Delete events for files and directories that were moved
+	// out of the watched directories (or, if they were passed to Add, moved
+	// anywhere), rather than deleted
+	Moved bool
+
+	// Time is when the event was read or, for Overflow events, when the last
+	// read before the overflow returned
+	Time time.Time
+}
+
+func (e WatchEvent) String() string {
+	path, oldPath := e.Path, e.OldPath
+	if e.IsDir {
+		path, oldPath = path+"/", oldPath+"/"
+	}
+	if e.Type == Rename {
+		return e.Type.String() + " " + oldPath + " -> " + path
+	}
+	return e.Type.String() + " " + path
+}
+
+// Options configure a Watcher. All of them are optional
+type Options struct {
+	// Skip is called with each directory before it's watched (both those passed
+	// to Add and those under them), and returns true if neither it nor anything
+	// under it should be watched
+	Skip func(dir string) bool
+
+	// AddFailed is called if a directory can't be watched, e.g. because the
+	// inotify watch limit was reached (in which case 'err' is syscall.ENOSPC).
+	// If it returns nil, the directory is skipped; otherwise Add fails with the
+	// returned error. By default, Add fails
+	AddFailed func(dir string, err error) error
+
+	// Locker, if set, is held while Skip and AddFailed are called from the
+	// Watcher's own goroutine (to watch new directories), so that they may use
+	// state guarded by it. Callers of Add and Remove must then hold it too
+	Locker sync.Locker
+
+	// Mask, if set, is called with each directory before it's watched, and
+	// returns the types of event wanted for it. Modify and Attrib events are
+	// only received from the kernel if they're wanted. The other types are
+	// always received (and sent), as they're needed to keep track of
+	// directories, so callers must filter them. By default, DefaultMask is
+	// used
+	Mask func(dir string) EventMask
+
+	// InotifyAddWatch, if set, replaces unix.InotifyAddWatch, e.g. in tests that
+	// exhaust the watch limit
+	InotifyAddWatch func(fd int, path string, mask uint32) (int, error)
+}
+
+// ErrClosed is returned by the methods of a Watcher after Close
+var ErrClosed = errors.New("watcher was closed")
+
+// log logs the progress of every Watcher
+var log = logging.With("component", "watcher")
+
+// noLock is the Locker used if Options.Locker isn't set
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
@@ -0,0 +1,621 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// baseMask is the mask of the inotify watch added for each directory,
+	// before adding the events wanted for it (see inotifyMask). Its events are
+	// needed to keep track of directories. IN_EXCL_UNLINK suppresses events
+	// for files that were deleted while still open (e.g. temp files)
+	baseMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+		unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+		unix.IN_EXCL_UNLINK
+
+	// inotifyBufSz is the minimum buffer size required to read a unix inotify
+	// event. Per 'man 7 inotify':
+	//  Specifying a buffer of size 'sizeof(struct inotify_event) + NAME_MAX + 1'
+	//  will be sufficient to read at least one event.
+	inotifyBufSz = unix.SizeofInotifyEvent + unix.NAME_MAX + 1
+
+	// moveTimeout is how long a Watcher waits for the IN_MOVED_TO event matching
+	// an IN_MOVED_FROM event. The kernel queues the two together, so if it
+	// doesn't arrive by then, the file was moved out of the watched directories
+	moveTimeout = 10 * time.Millisecond
+)
+
+// Watcher watches directory trees with inotify. Its goroutine reads events,
+// watches new subdirectories as they're created, and sends a WatchEvent for
+// each change to Events().
+//
+// Re. watching new subdirectories (per 'man 7 inotify'):
+//
+//	If monitoring an entire directory subtree, and a new subdirectory is created
+//	in that tree or an existing directory is renamed into that tree, be aware
+//	that by the time you create a watch for the new subdirectory, new files (and
+//	subdirectories) may already exist inside the subdirectory. Therefore, you
+//	may want to scan the contents of the subdirectory immediately after adding
+//	the watch (and, if desired, recursively add watches for any subdirectories
+//	that it contains).
+//
+// So a Watcher sends a synthetic Create event for everything it finds in a
+// new directory. Files created between the watch being added and the scan may
+// therefore be reported twice (directories are only reported once)
+type Watcher struct {
+	opts Options
+
+	// fd is the inotify fd from which events are read, and wake is a pipe that
+	// Close writes to, to wake the goroutine reading it
+	fd   int
+	wake [2]int
+
+	// events is the channel returned by Events(). done is closed by Close, so
+	// that the goroutine reading events stops sending them
+	events chan WatchEvent
+	done   chan struct{}
+
+	// mu guards the fields below, which are modified both by the goroutine
+	// reading events and by callers of Add/Remove
+	mu sync.Mutex
+
+	// watchedNow maps each watched directory to its watch descriptor, and
+	// wdToPath is its inverse (used to interpret new inotify events)
+	watchedNow map[string]int
+	wdToPath   map[int]string
+
+	// moved is the Delete event for the last IN_MOVED_FROM event read, while
+	// the matching IN_MOVED_TO event (with the same cookie) hasn't been (see
+	// handle). It's only used by the goroutine reading events
+	moved       *WatchEvent
+	movedCookie uint32
+
+	// closed is set by Close, and stopped once the goroutine reading events has
+	// closed 'fd' and 'wake'. err is the error that stopped it, if it wasn't
+	// Close
+	closed, stopped bool
+	err             error
+}
+
+// NewWatcher creates a Watcher that isn't watching any directories yet (see
+// Add), and starts the goroutine that reads its events
+func NewWatcher(opts Options) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("could not create inotify fd: %v", err)
+	}
+	w := &Watcher{
+		opts:       opts,
+		fd:         fd,
+		events:     make(chan WatchEvent, 100),
+		done:       make(chan struct{}),
+		watchedNow: make(map[string]int),
+		wdToPath:   make(map[int]string),
+	}
+	if err := unix.Pipe2(w.wake[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not create pipe: %v", err)
+	}
+	if w.opts.Locker == nil {
+		w.opts.Locker = noLock{}
+	}
+	if w.opts.InotifyAddWatch == nil {
+		w.opts.InotifyAddWatch = unix.InotifyAddWatch
+	}
+	if w.opts.Mask == nil {
+		w.opts.Mask = func(string) EventMask { return DefaultMask }
+	}
+	go w.run()
+	return w, nil
+}
+
+// Events returns the channel on which w sends a WatchEvent for each change in
+// the watched directories. It's closed when w stops (see Err)
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Err returns the error that stopped w, once Events() is closed. It's nil if
+// w was stopped by Close
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Fd returns the inotify fd from which w reads events (e.g. so that tests can
+// simulate a failed read)
+func (w *Watcher) Fd() int {
+	return w.fd
+}
+
+// Add watches 'dir' and every directory under it (following 'dir' itself if
+// it's a symlink), except those that Options.Skip rejects. No events are sent
+// for the directories' existing contents. Adding a directory that's already
+// watched only watches any subdirectories of it that aren't
+func (w *Watcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.add(fp.Clean(dir), nil)
+}
+
+// Remove stops watching 'dir' and every directory under it. Events that were
+// already read for them may still be sent
+func (w *Watcher) Remove(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.remove(fp.Clean(dir))
+}
+
+// Watched returns the directories that w is watching, in lexical order
+func (w *Watcher) Watched() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make([]string, 0, len(w.watchedNow))
+	for dir := range w.watchedNow {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Prune stops watching directories that no longer exist (e.g. because their
+// deletion events were lost in an overflow), and drops watch descriptors that
+// no longer map to a watched directory. It returns the directories it stopped
+// watching, in lexical order
+func (w *Watcher) Prune() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	var pruned []string
+	for wd, path := range w.wdToPath {
+		if w.watchedNow[path] != wd {
+			// e.g. 'path' was replaced, and then the old watch was never removed
+			if _, err := unix.InotifyRmWatch(w.fd, uint32(wd)); err != nil &&
+				err != unix.EINVAL { // EINVAL: watch already removed by the kernel
+				return pruned, fmt.Errorf("could not remove watch for %q: %v", path, err)
+			}
+			delete(w.wdToPath, wd)
+		}
+	}
+	for path := range w.watchedNow {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			continue
+		}
+		for under := range w.watchedNow {
+			if isUnder(under, path) {
+				pruned = append(pruned, under)
+			}
+		}
+		if err := w.remove(path); err != nil {
+			return pruned, err
+		}
+	}
+	sort.Strings(pruned)
+	return pruned, nil
+}
+
+// Close stops w, after which Events() is closed (without sending any events
+// that haven't been received yet)
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	if !w.stopped {
+		// Closing w.fd wouldn't interrupt a poll that's already blocked
+		unix.Write(w.wake[1], []byte{0})
+	}
+	return nil
+}
+
+// add watches 'top' and every directory under it, unless Options.Skip rejects
+// them. If 'created' is set, 'top' was just created, so 'created' is called
+// with a Create event for everything under it (which may have been created
+// before 'top' was watched), and paths that are deleted while it runs are
+// skipped. w.mu must be held by the caller
+func (w *Watcher) add(top string, created func(WatchEvent)) error {
+	return fp.Walk(top+"/", func(path string, info os.FileInfo, err error) error {
+		path = fp.Clean(path)
+		if err != nil {
+			if created != nil && os.IsNotExist(err) {
+				return nil // deleted while walking; its Delete event follows
+			}
+			return err
+		}
+		if created != nil && path != top {
+			created(WatchEvent{Type: Create, IsDir: info.IsDir(), Path: path})
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if w.opts.Skip != nil && w.opts.Skip(path) {
+			return fp.SkipDir
+		}
+
+		wd, err := w.opts.InotifyAddWatch(w.fd, path, inotifyMask(w.opts.Mask(path)))
+		if err == unix.ENOENT && created != nil {
+			return fp.SkipDir
+		} else if err != nil {
+			if w.opts.AddFailed != nil {
+				err = w.opts.AddFailed(path, err)
+			}
+			if err == nil {
+				return fp.SkipDir
+			}
+			return fmt.Errorf("could not add watch for %q: %v", path, err)
+		}
+		if existing, ok := w.wdToPath[wd]; ok && existing != path {
+			// inotify watches inodes, so 'path' is the same directory as
+			// 'existing' (e.g. via a bind mount or symlink). Keep reporting its
+			// events under 'existing', so that they're neither reported twice nor
+			// flap between paths
+			log.Warnf("%q is the same directory as %q, which is already watched; "+
+				"its events are reported under %q", path, existing, existing)
+			return fp.SkipDir
+		}
+		if prev, ok := w.watchedNow[path]; ok && prev != wd {
+			delete(w.wdToPath, prev) // 'path' was replaced by another directory
+		}
+		log.Debugf("watching %q", path)
+		w.watchedNow[path] = wd
+		w.wdToPath[wd] = path
+		return nil
+	})
+}
+
+// inotifyMask returns the mask of the inotify watch for a directory in which
+// the events in 'm' are wanted
+func inotifyMask(m EventMask) uint32 {
+	mask := uint32(baseMask)
+	if m.Has(Modify) {
+		mask |= unix.IN_MODIFY
+	}
+	if m.Has(Attrib) {
+		mask |= unix.IN_ATTRIB
+	}
+	return mask
+}
+
+// remove removes the watches for 'dir' and every directory under it. w.mu
+// must be held by the caller
+func (w *Watcher) remove(dir string) error {
+	for path, wd := range w.watchedNow {
+		if !isUnder(path, dir) {
+			continue
+		}
+		if _, err := unix.InotifyRmWatch(w.fd, uint32(wd)); err != nil &&
+			err != unix.EINVAL { // EINVAL: watch already removed by the kernel
+			return fmt.Errorf("could not remove watch for %q: %v", path, err)
+		}
+		delete(w.watchedNow, path)
+		delete(w.wdToPath, wd)
+	}
+	return nil
+}
+
+// isUnder returns true if 'path' is 'dir' or is inside 'dir'
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// run reads events from w.fd until reading fails or w is closed (see read),
+// and then closes w's fds and Events()
+func (w *Watcher) run() {
+	err := w.read()
+	w.mu.Lock()
+	w.stopped = true
+	if !w.closed {
+		log.Debugf("stopped: %v", err)
+		w.err = err
+	}
+	unix.Close(w.fd)
+	unix.Close(w.wake[0])
+	unix.Close(w.wake[1])
+	w.mu.Unlock()
+	close(w.events)
+}
+
+// read reads inotify events from w.fd, and sends the WatchEvents that they
+// produce (see parse) to w.events. It returns when reading fails, or
+// ErrClosed once w is closed
+func (w *Watcher) read() error {
+	buf := eventBuffer{buf: make([]byte, inotifyBufSz*64)}
+	// lastRead is when the previous read returned. If the queue overflows, the
+	// events lost were queued after it
+	lastRead := time.Now()
+	fds := []unix.PollFd{
+		{Fd: int32(w.fd), Events: unix.POLLIN},
+		{Fd: int32(w.wake[0]), Events: unix.POLLIN},
+	}
+	for {
+		timeout := -1
+		if w.moved != nil {
+			timeout = int(moveTimeout / time.Millisecond)
+		}
+		fds[0].Revents, fds[1].Revents = 0, 0
+		ready, err := unix.Poll(fds, timeout)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not poll inotify fd: %v", err)
+		}
+		if fds[1].Revents != 0 {
+			return ErrClosed
+		}
+		var events []WatchEvent
+		if ready == 0 {
+			events = w.expireMove() // no IN_MOVED_TO event arrived
+		} else {
+			n, err := buf.read(func(p []byte) (int, error) { return unix.Read(w.fd, p) })
+			readAt := time.Now()
+			switch {
+			case err == unix.EAGAIN || err == unix.EINTR:
+				continue
+			case err != nil:
+				return fmt.Errorf("inotify read error: %v", err)
+			case n == 0:
+				return errors.New("inotify fd was closed")
+			}
+			var parsed int
+			events, parsed = w.parse(buf.unparsed(), lastRead, readAt)
+			buf.consume(parsed)
+			lastRead = readAt
+		}
+
+		// Send events without holding any locks, so that receivers may call Add
+		for _, e := range events {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return ErrClosed
+			}
+		}
+	}
+}
+
+// eventBuffer holds the bytes read from an inotify fd. Events may be split
+// across reads (if a read ends partway through one), so the bytes of an
+// incomplete event at the end of one read are kept until the rest of it has
+// been read
+type eventBuffer struct {
+	buf []byte
+	end int // the end of the bytes that haven't been parsed yet
+}
+
+// read reads more bytes into b with 'read' (e.g. unix.Read on an inotify fd),
+// after any that haven't been parsed yet, and returns how many were read
+func (b *eventBuffer) read(read func(p []byte) (int, error)) (int, error) {
+	if b.end == len(b.buf) {
+		return 0, fmt.Errorf("inotify event is larger than the %d-byte buffer", len(b.buf))
+	}
+	n, err := read(b.buf[b.end:])
+	if n > 0 {
+		b.end += n
+	}
+	return n, err
+}
+
+// unparsed returns the bytes that have been read but not parsed yet
+func (b *eventBuffer) unparsed() []byte {
+	return b.buf[:b.end]
+}
+
+// consume discards the first 'n' unparsed bytes, which were parsed, and keeps
+// the rest (the start of an incomplete event) for the next read
+func (b *eventBuffer) consume(n int) {
+	b.end = copy(b.buf, b.buf[n:b.end])
+}
+
+// parse converts the complete inotify events at the start of 'buf' (which
+// were read at 'readAt', after the previous read returned at 'lastRead') into
+// WatchEvents (see handle), and returns them along with the number of bytes
+// parsed
+func (w *Watcher) parse(buf []byte, lastRead, readAt time.Time) (events []WatchEvent, parsed int) {
+	w.opts.Locker.Lock()
+	defer w.opts.Locker.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for parsed+unix.SizeofInotifyEvent <= len(buf) {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[parsed]))
+		nameStart := parsed + unix.SizeofInotifyEvent
+		if nameStart+int(event.Len) > len(buf) {
+			break // the rest of the event hasn't been read yet
+		}
+		// Per 'man 7 inotify', the name is null-terminated, and may include
+		// further null bytes to align subsequent reads
+		name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+		parsed = nameStart + int(event.Len)
+		if w.closed {
+			continue // w.events won't be read
+		}
+		events = w.handle(events, event.Mask, int(event.Wd), event.Cookie, name,
+			lastRead, readAt)
+	}
+	return events, parsed
+}
+
+// expireMove returns the Delete event for a file moved out of the watched
+// directories, i.e. whose IN_MOVED_FROM event wasn't followed by a matching
+// IN_MOVED_TO event (see moveTimeout)
+func (w *Watcher) expireMove() []WatchEvent {
+	w.opts.Locker.Lock()
+	defer w.opts.Locker.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	return w.flushMove(nil)
+}
+
+// flushMove appends w.moved, if set, to 'events', as the file that it
+// describes was moved out of the watched directories. w.mu must be held by the
+// caller
+func (w *Watcher) flushMove(events []WatchEvent) []WatchEvent {
+	if w.moved == nil {
+		return events
+	}
+	e := *w.moved
+	w.moved = nil
+	if e.IsDir {
+		w.unwatch(e.Path)
+	}
+	return append(events, e)
+}
+
+// handle appends the WatchEvents for an inotify event (with the mask 'mask',
+// for the file 'name' in the directory watched by 'wd') to 'events', and
+// updates w's watches to match. w.mu must be held by the caller
+func (w *Watcher) handle(events []WatchEvent, mask uint32, wd int, cookie uint32,
+	name string, lastRead, readAt time.Time) []WatchEvent {
+	if w.moved != nil && (mask&unix.IN_MOVED_TO == 0 || cookie != w.movedCookie) {
+		// The kernel queues IN_MOVED_FROM and IN_MOVED_TO events together, so the
+		// file described by w.moved was moved out of the watched directories
+		events = w.flushMove(events)
+	}
+	if mask&unix.IN_Q_OVERFLOW > 0 {
+		return append(events, WatchEvent{Type: Overflow, Time: lastRead})
+	}
+	dir, ok := w.wdToPath[wd]
+	if !ok {
+		// The watch was removed (e.g. by Remove) before this was read
+		return w.flushMove(events)
+	}
+	if mask&unix.IN_IGNORED > 0 {
+		// The kernel removed the watch (e.g. because 'dir' was deleted, which is
+		// reported by its parent)
+		delete(w.wdToPath, wd)
+		if w.watchedNow[dir] == wd {
+			delete(w.watchedNow, dir)
+		}
+		return events
+	}
+
+	e := WatchEvent{IsDir: mask&unix.IN_ISDIR > 0, Path: fp.Join(dir, name), Time: readAt}
+	switch {
+	case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) > 0:
+		if _, ok := w.watchedNow[fp.Dir(dir)]; ok {
+			return events // reported by the parent of 'dir', which is watched
+		}
+		e.Type, e.IsDir, e.Moved = Delete, true, mask&unix.IN_MOVE_SELF > 0
+		w.unwatch(dir)
+	case mask&unix.IN_MOVED_TO > 0 && w.moved != nil:
+		e.Type, e.OldPath = Rename, w.moved.Path
+		w.moved = nil
+		if !e.IsDir {
+			break
+		}
+		if _, ok := w.watchedNow[e.OldPath]; !ok {
+			return w.created(events, e) // e.g. moved out of a skipped directory
+		}
+		w.move(e.OldPath, e.Path)
+		return append(events, e)
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) > 0:
+		e.Type = Create
+		if !e.IsDir {
+			break
+		}
+		if _, ok := w.watchedNow[e.Path]; ok {
+			// Already watched, and reported, while its parent was being added (e.g.
+			// if foo/ and foo/bar/ were created in rapid succession)
+			return events
+		}
+		return w.created(events, e)
+	case mask&unix.IN_MOVED_FROM > 0:
+		// Kept until the matching IN_MOVED_TO event is read, if it's in a watched
+		// directory, in which case this is part of a Rename (see flushMove)
+		e.Type, e.Moved = Delete, true
+		w.moved, w.movedCookie = &e, cookie
+		return events
+	case mask&unix.IN_DELETE > 0:
+		e.Type = Delete
+		if e.IsDir {
+			w.unwatch(e.Path)
+		}
+	case mask&unix.IN_MODIFY > 0:
+		e.Type = Modify
+	case mask&unix.IN_ATTRIB > 0:
+		e.Type = Attrib
+	default:
+		return events
+	}
+	return append(events, e)
+}
+
+// created appends 'e', for a directory that was just created or moved into a
+// watched directory, to 'events', and watches it (see add), appending Create
+// events for everything under it. w.mu must be held by the caller
+func (w *Watcher) created(events []WatchEvent, e WatchEvent) []WatchEvent {
+	events = append(events, e)
+	err := w.add(e.Path, func(c WatchEvent) {
+		c.Time = e.Time
+		events = append(events, c)
+	})
+	if err != nil {
+		log.Warnf("could not watch new directory %q: %v", e.Path, err)
+	}
+	return events
+}
+
+// move updates w's watches after the directory 'from' was moved to 'to', so
+// that the events of the directories under it (whose watch descriptors are
+// unchanged) are reported under their new paths. It then watches the
+// directories under 'to' that Options.Skip now accepts, and stops watching
+// those that it now rejects. w.mu must be held by the caller
+func (w *Watcher) move(from, to string) {
+	moved := make(map[string]int)
+	for path, wd := range w.watchedNow {
+		if isUnder(path, from) {
+			moved[to+strings.TrimPrefix(path, from)] = wd
+			delete(w.watchedNow, path)
+		}
+	}
+	for path, wd := range moved {
+		if prev, ok := w.watchedNow[path]; ok {
+			delete(w.wdToPath, prev) // 'path' was an empty directory, replaced by 'from'
+		}
+		w.watchedNow[path] = wd
+		w.wdToPath[wd] = path
+	}
+	if w.opts.Skip != nil {
+		for path := range w.watchedNow {
+			if isUnder(path, to) && w.opts.Skip(path) {
+				w.unwatch(path)
+			}
+		}
+	}
+	if err := w.add(to, nil); err != nil {
+		log.Warnf("could not watch moved directory %q: %v", to, err)
+	}
+}
+
+// unwatch removes the watches under 'dir' after it was deleted or moved,
+// logging any failure (as there's no caller to return it to). w.mu must be
+// held by the caller
+func (w *Watcher) unwatch(dir string) {
+	if err := w.remove(dir); err != nil {
+		log.Warnf("%v", err)
+	}
+}
@@ -0,0 +1,897 @@
+package watcher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	p "path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/msteffen/toggl-watcher/watchtest"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	testRandMu sync.Mutex
+	testRand   = rand.New(rand.NewSource(7))
+)
+
+// randInt31n is testRand.Int31n, but safe to call from parallel tests
+func randInt31n(n int32) int32 {
+	testRandMu.Lock()
+	defer testRandMu.Unlock()
+	return testRand.Int31n(n)
+}
+
+type paths map[string]struct{}
+
+func (d paths) String() string {
+	var buf bytes.Buffer
+	var sep string
+	for k := range d {
+		buf.WriteString(sep)
+		buf.WriteString(k)
+		sep = ", "
+	}
+	return buf.String()
+}
+
+// ConfirmEq returns 'nil' if 'd' and 'other' are equal, or an error listing
+// the paths that are missing from 'other' or unexpected in it otherwise
+func (d paths) ConfirmEq(other paths) error {
+	var missing, extra []string
+	for k := range d {
+		if _, ok := other[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	for k := range other {
+		if _, ok := d[k]; !ok {
+			extra = append(extra, k)
+		}
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		sort.Strings(missing)
+		sort.Strings(extra)
+		// slight abuse of error, but this only really used by tests
+		return fmt.Errorf("expected %v, but was missing %v and had extra %v",
+			d, missing, extra)
+	}
+	return nil // success
+}
+
+func (d paths) Copy() paths {
+	var result paths = make(map[string]struct{})
+	for s := range d {
+		result[s] = struct{}{}
+	}
+	return result
+}
+
+func (d paths) Add(path string) {
+	for path = p.Clean(path); path != ""; path = p.Dir(path) {
+		if _, ok := d[path]; ok {
+			break // all other parents have been added
+		}
+		d[path] = struct{}{}
+	}
+}
+
+func (d paths) Rm(path string) {
+	for f := range d {
+		if isUnder(f, path) {
+			delete(d, f)
+		}
+	}
+}
+
+func (d paths) Pick(except ...string) string {
+	exceptM := make(map[string]struct{})
+	for _, e := range except {
+		exceptM[e] = struct{}{}
+	}
+
+	i := randInt31n(int32(len(d) - len(exceptM)))
+	var f string
+	for f = range d {
+		if _, ok := exceptM[f]; ok {
+			continue
+		}
+		if i--; i < 0 { // i < 0 => i == 0 at the start (i \in [0, n) )
+			break
+		}
+	}
+	return f
+}
+
+// startWatcher creates a Watcher that watches 'dir', and closes it when the
+// test finishes
+func startWatcher(t *testing.T, dir string) *Watcher {
+	t.Helper()
+	w, err := NewWatcher(Options{})
+	if err != nil {
+		t.Fatalf("could not create watcher: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("could not watch %q: %v", dir, err)
+	}
+	return w
+}
+
+// nextEvent returns the next event from 'w', or fails the test if there's
+// none within a few seconds
+func nextEvent(t *testing.T, w *Watcher) WatchEvent {
+	t.Helper()
+	select {
+	case e, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("events were closed unexpectedly: %v", w.Err())
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for an event")
+	}
+	return WatchEvent{}
+}
+
+// expectNoEvent fails the test if 'w' sends an event within 'd'
+func expectNoEvent(t *testing.T, w *Watcher, d time.Duration) {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		t.Fatalf("expected no event, but got %s", e)
+	case <-time.After(d):
+	}
+}
+
+func TestAddMissing(t *testing.T) {
+	t.Parallel()
+	w, err := NewWatcher(Options{})
+	if err != nil {
+		t.Fatalf("could not create watcher: %v", err)
+	}
+	defer w.Close()
+	missing := p.Join(watchtest.Dir(t), "missing")
+	if err := w.Add(missing); err == nil {
+		t.Fatalf("expected an error watching %q, which doesn't exist", missing)
+	}
+	if len(w.Watched()) != 0 {
+		t.Fatalf("expected nothing to be watched, but have %v", w.Watched())
+	}
+}
+
+func TestDeleteRoot(t *testing.T) {
+	t.Parallel()
+	base := p.Join(watchtest.Dir(t), "base")
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Fatalf("could not create %q: %v", base, err)
+	}
+	w := startWatcher(t, base)
+	if err := os.Remove(base); err != nil {
+		t.Fatalf("could not remove %q: %v", base, err)
+	}
+	if e := nextEvent(t, w); e.Type != Delete || !e.IsDir || e.Path != base || e.Moved {
+		t.Fatalf("expected %q to be deleted, but got %s", base, e)
+	}
+	if len(w.Watched()) != 0 {
+		t.Fatalf("expected nothing to be watched, but have %v", w.Watched())
+	}
+}
+
+func TestMoveRoot(t *testing.T) {
+	t.Parallel()
+	base := p.Join(watchtest.Dir(t), "base")
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Fatalf("could not create %q: %v", base, err)
+	}
+	w := startWatcher(t, base)
+	if err := os.Rename(base, base+"-moved"); err != nil {
+		t.Fatalf("could not move %q: %v", base, err)
+	}
+	if e := nextEvent(t, w); e.Type != Delete || !e.IsDir || e.Path != base || !e.Moved {
+		t.Fatalf("expected %q to be moved away, but got %s", base, e)
+	}
+	if len(w.Watched()) != 0 {
+		t.Fatalf("expected nothing to be watched, but have %v", w.Watched())
+	}
+}
+
+// TestNewDirContents checks that the contents of a new directory are reported
+// (and watched) even if they're created before the directory's watch is
+func TestNewDirContents(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	if err := os.Mkdir(p.Join(base, "existing"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	w := startWatcher(t, base)
+	deep := p.Join(base, "a", "b", "c")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatalf("could not create %q: %v", deep, err)
+	}
+	if err := ioutil.WriteFile(p.Join(deep, "f"), nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+
+	// Directories are reported exactly once; the file may be reported twice
+	// (see Watcher)
+	created := make(map[string]int)
+	for !(created[deep] > 0 && created[p.Join(deep, "f")] > 0) {
+		e := nextEvent(t, w)
+		if e.Type != Create {
+			t.Fatalf("unexpected event: %s", e)
+		}
+		created[e.Path]++
+	}
+	expectNoEvent(t, w, 100*time.Millisecond)
+	for _, dir := range []string{"a", "a/b", "a/b/c"} {
+		if n := created[p.Join(base, dir)]; n != 1 {
+			t.Fatalf("expected one Create event for %q, but got %d", dir, n)
+		}
+	}
+	if len(created) != 4 {
+		t.Fatalf("expected Create events for 4 paths, but got %v", created)
+	}
+	if got := strings.Join(w.Watched(), " "); got != strings.Join([]string{
+		base, p.Join(base, "a"), p.Join(base, "a", "b"), deep, p.Join(base, "existing"),
+	}, " ") {
+		t.Fatalf("unexpected watched directories: %s", got)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	w, err := NewWatcher(Options{Skip: func(dir string) bool {
+		return strings.HasPrefix(p.Base(dir), ".")
+	}})
+	if err != nil {
+		t.Fatalf("could not create watcher: %v", err)
+	}
+	defer w.Close()
+	if err := os.Mkdir(p.Join(base, ".hidden"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	if err := w.Add(base); err != nil {
+		t.Fatalf("could not watch %q: %v", base, err)
+	}
+	if err := ioutil.WriteFile(p.Join(base, ".hidden", "f"), nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	expectNoEvent(t, w, 100*time.Millisecond)
+	if got := w.Watched(); len(got) != 1 || got[0] != base {
+		t.Fatalf("expected only %q to be watched, but have %v", base, got)
+	}
+}
+
+func TestAddFailed(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	if err := os.MkdirAll(p.Join(base, "full", "sub"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	var failed []string
+	w, err := NewWatcher(Options{
+		InotifyAddWatch: func(fd int, path string, mask uint32) (int, error) {
+			if p.Base(path) == "full" {
+				return -1, unix.ENOSPC
+			}
+			return unix.InotifyAddWatch(fd, path, mask)
+		},
+		AddFailed: func(dir string, err error) error {
+			failed = append(failed, dir)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create watcher: %v", err)
+	}
+	defer w.Close()
+	if err := w.Add(base); err != nil {
+		t.Fatalf("could not watch %q: %v", base, err)
+	}
+	if len(failed) != 1 || failed[0] != p.Join(base, "full") {
+		t.Fatalf("expected AddFailed to be called for \"full\", but got %v", failed)
+	}
+	if got := w.Watched(); len(got) != 1 || got[0] != base {
+		t.Fatalf("expected only %q to be watched, but have %v", base, got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	if err := os.MkdirAll(p.Join(base, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	w := startWatcher(t, base)
+	if err := w.Remove(p.Join(base, "a")); err != nil {
+		t.Fatalf("could not remove watch: %v", err)
+	}
+	if got := w.Watched(); len(got) != 1 || got[0] != base {
+		t.Fatalf("expected only %q to be watched, but have %v", base, got)
+	}
+	if err := ioutil.WriteFile(p.Join(base, "a", "b", "f"), nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	expectNoEvent(t, w, 100*time.Millisecond)
+}
+
+// TestPrune checks that Prune drops watches whose directories are gone, as if
+// their deletion events had been lost
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	if err := os.MkdirAll(p.Join(base, "a"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	w := startWatcher(t, base)
+	gone := p.Join(base, "gone")
+	w.mu.Lock()
+	w.watchedNow[gone], w.wdToPath[1000] = 1000, gone
+	w.watchedNow[p.Join(gone, "sub")], w.wdToPath[1001] = 1001, p.Join(gone, "sub")
+	w.wdToPath[1002] = p.Join(base, "a") // orphaned descriptor
+	w.mu.Unlock()
+
+	pruned, err := w.Prune()
+	if err != nil {
+		t.Fatalf("could not prune watches: %v", err)
+	}
+	if len(pruned) != 2 || pruned[0] != gone || pruned[1] != p.Join(gone, "sub") {
+		t.Fatalf("expected %q and its subdirectory to be pruned, but got %v", gone, pruned)
+	}
+	if got := w.Watched(); len(got) != 2 || got[0] != base || got[1] != p.Join(base, "a") {
+		t.Fatalf("expected %q and %q to be watched, but have %v", base,
+			p.Join(base, "a"), got)
+	}
+	w.mu.Lock()
+	descriptors := len(w.wdToPath)
+	w.mu.Unlock()
+	if descriptors != 2 {
+		t.Fatalf("expected 2 watch descriptors, but have %d", descriptors)
+	}
+}
+
+// TestRename checks that moves within the watched directories are reported as
+// a single Rename event, and that a moved directory's subdirectories stay
+// watched under their new paths
+func TestRename(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	if err := os.MkdirAll(p.Join(base, "a", "b", "c"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	if err := os.Mkdir(p.Join(base, "other"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(p.Join(base, "f"), nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	w := startWatcher(t, base)
+
+	if err := os.Rename(p.Join(base, "f"), p.Join(base, "other", "g")); err != nil {
+		t.Fatalf("could not rename file: %v", err)
+	}
+	if e := nextEvent(t, w); e.Type != Rename || e.IsDir ||
+		e.OldPath != p.Join(base, "f") || e.Path != p.Join(base, "other", "g") {
+		t.Fatalf("expected f to be renamed to other/g, but got %s", e)
+	}
+	if err := os.Rename(p.Join(base, "a"), p.Join(base, "other", "z")); err != nil {
+		t.Fatalf("could not rename dir: %v", err)
+	}
+	if e := nextEvent(t, w); e.Type != Rename || !e.IsDir ||
+		e.OldPath != p.Join(base, "a") || e.Path != p.Join(base, "other", "z") {
+		t.Fatalf("expected a/ to be renamed to other/z/, but got %s", e)
+	}
+	if got := strings.Join(w.Watched(), " "); got != strings.Join([]string{
+		base, p.Join(base, "other"), p.Join(base, "other", "z"),
+		p.Join(base, "other", "z", "b"), p.Join(base, "other", "z", "b", "c"),
+	}, " ") {
+		t.Fatalf("unexpected watched directories: %s", got)
+	}
+
+	// Writes in the moved directory are reported under its new path
+	moved := p.Join(base, "other", "z", "b", "c", "h")
+	if err := ioutil.WriteFile(moved, nil, 0644); err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	if e := nextEvent(t, w); e.Type != Create || e.Path != moved {
+		t.Fatalf("expected %q to be created, but got %s", moved, e)
+	}
+	expectNoEvent(t, w, 100*time.Millisecond)
+}
+
+// TestMoveOutAndIn checks that directories moved out of (or into) the watched
+// directories are reported as deleted (or created), and unwatched (or watched)
+func TestMoveOutAndIn(t *testing.T) {
+	t.Parallel()
+	base, outside := watchtest.Dir(t), watchtest.Dir(t)
+	if err := os.MkdirAll(p.Join(base, "a", "b"), 0755); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	w := startWatcher(t, base)
+
+	if err := os.Rename(p.Join(base, "a"), p.Join(outside, "a")); err != nil {
+		t.Fatalf("could not move dir out: %v", err)
+	}
+	if e := nextEvent(t, w); e.Type != Delete || !e.IsDir || e.Path != p.Join(base, "a") ||
+		!e.Moved {
+		t.Fatalf("expected a/ to be moved away, but got %s", e)
+	}
+	if got := w.Watched(); len(got) != 1 || got[0] != base {
+		t.Fatalf("expected only %q to be watched, but have %v", base, got)
+	}
+
+	if err := os.Rename(p.Join(outside, "a"), p.Join(base, "c")); err != nil {
+		t.Fatalf("could not move dir in: %v", err)
+	}
+	for _, expected := range []string{p.Join(base, "c"), p.Join(base, "c", "b")} {
+		if e := nextEvent(t, w); e.Type != Create || !e.IsDir || e.Path != expected {
+			t.Fatalf("expected %q to be created, but got %s", expected, e)
+		}
+	}
+	expectNoEvent(t, w, 100*time.Millisecond)
+	if got := len(w.Watched()); got != 3 {
+		t.Fatalf("expected 3 watched directories, but have %v", w.Watched())
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+	w := startWatcher(t, watchtest.Dir(t))
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close watcher: %v", err)
+	}
+	select {
+	case _, ok := <-w.Events():
+		if ok {
+			t.Fatalf("expected no events after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events weren't closed after Close")
+	}
+	if err := w.Err(); err != nil {
+		t.Fatalf("expected no error after Close, but got %v", err)
+	}
+	if err := w.Add(watchtest.Dir(t)); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Add after Close, but got %v", err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	t.Parallel()
+	w := startWatcher(t, watchtest.Dir(t))
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	// Reading /dev/null returns EOF, as if the inotify fd were closed
+	if err := unix.Dup3(int(devNull.Fd()), w.Fd(), 0); err != nil {
+		t.Fatalf("could not replace inotify fd: %v", err)
+	}
+	select {
+	case e, ok := <-w.Events():
+		if ok {
+			t.Fatalf("unexpected event: %s", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events weren't closed after a failed read")
+	}
+	if err := w.Err(); err == nil {
+		t.Fatalf("expected an error after a failed read")
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	t.Parallel()
+	base := p.Join(watchtest.Dir(t), "base")
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Fatalf("could not create %q: %v", base, err)
+	}
+	w := startWatcher(t, base)
+	var expected paths = map[string]struct{}{base: {}}
+	actual := expected.Copy()
+	expectedStateCh := make(chan paths)
+
+	// Randomly modify 'base' and make sure modifications are picked up by 'w'
+	go func() {
+		defer close(expectedStateCh)
+		for i := 0; i < 100; i++ {
+			if len(expected) == 1 || randInt31n(2) > 0 {
+				baby := p.Join(expected.Pick(), fmt.Sprintf("%03d", i))
+				if err := os.Mkdir(baby, 0755); err != nil {
+					t.Errorf("could not create %q: %v", baby, err)
+					return
+				}
+				expected.Add(baby)
+			} else {
+				dead := expected.Pick( /* except */ base)
+				if err := os.RemoveAll(dead); err != nil {
+					t.Errorf("could not remove %q: %v", dead, err)
+					return
+				}
+				expected.Rm(dead)
+			}
+			expectedStateCh <- expected.Copy()
+
+			// insert dummy value into channel; this line blocks until the target
+			// state has been reached so the next change can be applied
+			expectedStateCh <- nil
+		}
+	}()
+
+	// Read through expected states and try to reach each one--note that
+	// filesystem modifications are blocked on each expected state being reached
+	// (roughly -- real FS is typically one step ahead) so there's no way for some
+	// random run of deletes to mask the fact that a particular state was never
+	// reached. A single operation may generate multiple events (e.g. deleting
+	// foo/ generates Delete events for foo/a/, foo/b/, and foo/c/), so all
+	// events are applied before comparing states
+	for target := range expectedStateCh {
+		// Apply pending watch events until there are none left
+	applyEvents:
+		for {
+			select {
+			case e := <-w.Events():
+				switch e.Type {
+				case Create:
+					actual.Add(e.Path)
+				case Delete:
+					actual.Rm(e.Path)
+				default:
+					t.Fatalf("unexpected event: %s", e)
+				}
+			case <-time.After(100 * time.Millisecond):
+				break applyEvents // no more events arriving
+			}
+		}
+		// Make sure events put 'actual' into the right target state
+		if err := target.ConfirmEq(actual); err != nil {
+			t.Fatalf("%v", err)
+		}
+		<-expectedStateCh // unblock the generator goroutine
+	}
+}
+
+// TestFuzzAsync is similar to TestFuzz, but the goroutine that generates events
+// doesn't wait for the goroutine that receives events to catch up before
+// continuing. Because of that, this test is more lax--it's very easy for events
+// to be re-ordered (because watches take a non-trivial amount of time to
+// establish) so instead of checking every state, we just confirm that every
+// create and every delete is seen in some order. A directory that's created
+// and deleted before its parent is scanned can't be seen at all, so it may be
+// missing from both.
+//
+// The main benefit of this test over TestFuzz is that it's good at confirming
+// that a Watcher doesn't have any race conditions with files being deleted
+// shortly after being created that cause it to panic.
+func TestFuzzAsync(t *testing.T) {
+	t.Parallel()
+	base := p.Join(watchtest.Dir(t), "base")
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Fatalf("could not create %q: %v", base, err)
+	}
+	w := startWatcher(t, base)
+
+	// Randomly modify 'base' and make sure modifications are picked up by 'w'
+	var expected paths = map[string]struct{}{base: {}}
+	expectedCreates, expectedDeletes := expected.Copy(), expected.Copy()
+	actualCreates, actualDeletes := expected.Copy(), expected.Copy()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if len(expected) == 1 || randInt31n(2) > 0 {
+				baby := p.Join(expected.Pick(), fmt.Sprintf("%03d", i))
+				if err := os.Mkdir(baby, 0755); err != nil {
+					t.Errorf("could not create %q: %v", baby, err)
+					return
+				}
+				expected.Add(baby)
+				expectedCreates.Add(baby)
+			} else {
+				dead := expected.Pick( /* except */ base)
+				if err := os.RemoveAll(dead); err != nil {
+					t.Errorf("could not remove %q: %v", dead, err)
+					return
+				}
+				for path := range expected {
+					if isUnder(path, dead) {
+						expectedDeletes.Add(path) // inotify generates events for children
+					}
+				}
+				expected.Rm(dead)
+			}
+			// hack -- briefly yield control; if a dir is created and deleted before
+			// the watcher can even scan its parent, it might not generate an event
+			// and this test would break
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Receive events while 'base' is modified, so that the watcher doesn't fall
+	// behind, and then wait briefly for pending events
+	var timeout <-chan time.Time
+receiveEvents:
+	for {
+		select {
+		case e := <-w.Events():
+			switch e.Type {
+			case Create:
+				actualCreates.Add(e.Path)
+			case Delete:
+				actualDeletes.Add(e.Path)
+			default:
+				t.Fatalf("unexpected event: %s", e)
+			}
+		case <-done:
+			done, timeout = nil, time.After(time.Second)
+		case <-timeout:
+			break receiveEvents
+		}
+	}
+	// Every directory that still exists, and every deletion of a directory whose
+	// creation was seen, must have been seen
+	for path := range expectedCreates {
+		_, created := actualCreates[path]
+		_, deleted := expectedDeletes[path]
+		if !created && !deleted {
+			t.Fatalf("expected the creation of %q to be seen", path)
+		}
+		if _, ok := actualDeletes[path]; created && deleted && !ok {
+			t.Fatalf("expected the deletion of %q to be seen", path)
+		}
+	}
+	for path := range actualCreates {
+		if _, ok := expectedCreates[path]; !ok {
+			t.Fatalf("unexpected creation of %q", path)
+		}
+	}
+	for path := range actualDeletes {
+		if _, ok := expectedDeletes[path]; !ok {
+			t.Fatalf("unexpected deletion of %q", path)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	w := startWatcher(t, base)
+
+	// Create and modify files in 'base' and make sure modifications are picked
+	// up by 'w'
+	var expectedCreates paths = map[string]struct{}{base: {}}
+	expectedModifications := expectedCreates.Copy()
+	actualCreates, actualModifications := expectedCreates.Copy(), expectedCreates.Copy()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			baby := p.Join(base, fmt.Sprintf("%03d", i))
+			if err := ioutil.WriteFile(baby, nil, 0644); err != nil {
+				t.Errorf("could not create %q: %v", baby, err)
+				return
+			}
+			expectedCreates.Add(baby)
+		}
+		for i := 0; i < 100; i++ {
+			adult := p.Join(base, fmt.Sprintf("%03d", i))
+			f, err := os.OpenFile(adult, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Errorf("could not open %q: %v", adult, err)
+				return
+			}
+			f.Write([]byte("x"))
+			f.Close()
+			expectedModifications.Add(adult)
+		}
+	}()
+
+	// Receive events while 'base' is modified, so that the watcher doesn't fall
+	// behind, and then wait briefly for pending events
+	var timeout <-chan time.Time
+receiveEvents:
+	for {
+		select {
+		case e := <-w.Events():
+			switch e.Type {
+			case Create:
+				actualCreates.Add(e.Path)
+			case Modify:
+				actualModifications.Add(e.Path)
+			default:
+				t.Fatalf("unexpected event: %s", e)
+			}
+		case <-done:
+			done, timeout = nil, time.After(time.Second)
+		case <-timeout:
+			break receiveEvents
+		}
+	}
+	if err := expectedCreates.ConfirmEq(actualCreates); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := expectedModifications.ConfirmEq(actualModifications); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// TestMask checks that Modify and Attrib events are only sent for the
+// directories in which they're wanted
+func TestMask(t *testing.T) {
+	t.Parallel()
+	base := watchtest.Dir(t)
+	for _, dir := range []string{"attrib", "quiet"} {
+		if err := os.Mkdir(p.Join(base, dir), 0755); err != nil {
+			t.Fatalf("could not create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p.Join(base, dir, "f"), nil, 0644); err != nil {
+			t.Fatalf("could not create file: %v", err)
+		}
+	}
+	w, err := NewWatcher(Options{Mask: func(dir string) EventMask {
+		switch p.Base(dir) {
+		case "attrib":
+			return DefaultMask | MaskOf(Attrib)
+		case "quiet":
+			return MaskOf(Create, Delete, Rename)
+		}
+		return DefaultMask
+	}})
+	if err != nil {
+		t.Fatalf("could not create watcher: %v", err)
+	}
+	defer w.Close()
+	if err := w.Add(base); err != nil {
+		t.Fatalf("could not watch %q: %v", base, err)
+	}
+
+	for _, dir := range []string{"quiet", "attrib"} {
+		path := p.Join(base, dir, "f")
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatalf("could not chmod %q: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("could not write %q: %v", path, err)
+		}
+	}
+	// Only the events in "attrib" are sent (the write may produce several
+	// Modify events, as the file is truncated and then written)
+	if e := nextEvent(t, w); e.Type != Attrib || e.Path != p.Join(base, "attrib", "f") {
+		t.Fatalf("expected an Attrib event for attrib/f, but got %s", e)
+	}
+	if e := nextEvent(t, w); e.Type != Modify || e.Path != p.Join(base, "attrib", "f") {
+		t.Fatalf("expected a Modify event for attrib/f, but got %s", e)
+	}
+}
+
+// rawEvent returns the bytes of an inotify event for the watch descriptor
+// 'wd', as they're read from an inotify fd (with 'name' padded with null
+// bytes, as the kernel does)
+func rawEvent(wd int32, mask uint32, name string) []byte {
+	var nameLen int
+	if name != "" {
+		nameLen = (len(name)/16 + 1) * 16
+	}
+	e := unix.InotifyEvent{Wd: wd, Mask: mask, Len: uint32(nameLen)}
+	result := make([]byte, unix.SizeofInotifyEvent+nameLen)
+	copy(result, (*[unix.SizeofInotifyEvent]byte)(unsafe.Pointer(&e))[:])
+	copy(result[unix.SizeofInotifyEvent:], name)
+	return result
+}
+
+// fragmentedReader returns a function that reads 'data' (see
+// eventBuffer.read) in chunks of the given sizes, and then the rest of it at
+// once, as if the reads ended partway through events
+func fragmentedReader(data []byte, sizes ...int) func(p []byte) (int, error) {
+	return func(p []byte) (int, error) {
+		if len(data) == 0 {
+			return 0, unix.EAGAIN
+		}
+		n := len(data)
+		if len(sizes) > 0 && sizes[0] < n {
+			n = sizes[0]
+		}
+		if len(sizes) > 0 {
+			sizes = sizes[1:]
+		}
+		n = copy(p, data[:n])
+		data = data[n:]
+		return n, nil
+	}
+}
+
+// parseAll parses the events returned by 'read' with 'w', in the same way as
+// Watcher.read
+func parseAll(t *testing.T, w *Watcher, read func(p []byte) (int, error)) []string {
+	t.Helper()
+	buf := eventBuffer{buf: make([]byte, 2*inotifyBufSz)}
+	var result []string
+	for {
+		if _, err := buf.read(read); err == unix.EAGAIN {
+			break
+		} else if err != nil {
+			t.Fatalf("could not read events: %v", err)
+		}
+		events, parsed := w.parse(buf.unparsed(), time.Time{}, time.Time{})
+		buf.consume(parsed)
+		for _, e := range events {
+			result = append(result, e.String())
+		}
+	}
+	if n := len(buf.unparsed()); n != 0 {
+		t.Fatalf("expected every event to be parsed, but %d bytes are left", n)
+	}
+	return result
+}
+
+func TestFragmentedReads(t *testing.T) {
+	t.Parallel()
+	w := &Watcher{
+		opts:       Options{Locker: noLock{}},
+		watchedNow: map[string]int{"/base": 1},
+		wdToPath:   map[int]string{1: "/base"},
+	}
+	var data []byte
+	data = append(data, rawEvent(1, unix.IN_CREATE, "a")...)
+	data = append(data, rawEvent(1, unix.IN_MODIFY, "a")...)
+	data = append(data, rawEvent(1, unix.IN_DELETE, "a-much-longer-file-name")...)
+	want := "Create /base/a, Modify /base/a, Delete /base/a-much-longer-file-name"
+
+	// Split the events into two reads at every offset (including within an
+	// event's header and within its name)...
+	for i := 1; i < len(data); i++ {
+		if got := strings.Join(parseAll(t, w, fragmentedReader(data, i)), ", "); got != want {
+			t.Fatalf("split at %d/%d: expected %s, but got %s", i, len(data), want, got)
+		}
+	}
+	// ...and read them one byte at a time
+	ones := make([]int, len(data))
+	for i := range ones {
+		ones[i] = 1
+	}
+	if got := strings.Join(parseAll(t, w, fragmentedReader(data, ones...)), ", "); got != want {
+		t.Fatalf("expected %s, but got %s", want, got)
+	}
+}
+
+// TestMoveCookies checks that IN_MOVED_FROM and IN_MOVED_TO events are paired
+// by their cookies, even if they're split across reads
+func TestMoveCookies(t *testing.T) {
+	t.Parallel()
+	w := &Watcher{
+		opts:       Options{Locker: noLock{}},
+		watchedNow: map[string]int{"/base": 1, "/base/dir": 2},
+		wdToPath:   map[int]string{1: "/base", 2: "/base/dir"},
+	}
+	move := func(wd int32, mask, cookie uint32, name string) []byte {
+		e := rawEvent(wd, mask, name)
+		(*unix.InotifyEvent)(unsafe.Pointer(&e[0])).Cookie = cookie
+		return e
+	}
+	var data []byte
+	data = append(data, move(1, unix.IN_MOVED_FROM, 1, "a")...)
+	data = append(data, move(2, unix.IN_MOVED_TO, 1, "b")...)
+	data = append(data, move(1, unix.IN_MOVED_FROM, 2, "c")...) // moved out
+	data = append(data, move(2, unix.IN_MOVED_FROM, 3, "d")...)
+	data = append(data, move(1, unix.IN_MOVED_TO, 3, "e")...)
+	data = append(data, move(1, unix.IN_MOVED_TO, 4, "f")...) // moved in
+	want := "Rename /base/a -> /base/dir/b, Delete /base/c, " +
+		"Rename /base/dir/d -> /base/e, Create /base/f"
+
+	for i := 1; i < len(data); i++ {
+		if got := strings.Join(parseAll(t, w, fragmentedReader(data, i)), ", "); got != want {
+			t.Fatalf("split at %d/%d: expected %s, but got %s", i, len(data), want, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"errors"
+	"runtime"
+)
+
+// errUnsupported is returned by NewWatcher on platforms without inotify
+var errUnsupported = errors.New("watching directories requires inotify, " +
+	"which isn't available on " + runtime.GOOS)
+
+// Watcher watches directory trees. It's only implemented on Linux (see
+// NewWatcher)
+type Watcher struct{}
+
+// NewWatcher returns an error, as inotify isn't available on this platform
+func NewWatcher(opts Options) (*Watcher, error) {
+	return nil, errUnsupported
+}
+
+// The methods below are never called, as NewWatcher fails
+
+func (w *Watcher) Events() <-chan WatchEvent { return nil }
+func (w *Watcher) Err() error                { return errUnsupported }
+func (w *Watcher) Fd() int                   { return -1 }
+func (w *Watcher) Add(dir string) error      { return errUnsupported }
+func (w *Watcher) Remove(dir string) error   { return errUnsupported }
+func (w *Watcher) Watched() []string         { return nil }
+func (w *Watcher) Prune() ([]string, error)  { return nil, errUnsupported }
+func (w *Watcher) Close() error              { return nil }
@@ -0,0 +1,96 @@
+// Package watchtest provides helpers for testing code that uses status.Watch.
+// Every helper is scoped to a single test, so tests that use them may call
+// t.Parallel()
+package watchtest
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// Keep, if set, causes the directories created by Dir to be left behind
+	// after each test, so that they can be inspected
+	Keep bool
+
+	// QuietPeriod is how long CheckEvent waits for further events before
+	// counting them. It should exceed the watch's bucket size (see
+	// status.Watch.SetBucketSize), so that every bucket is counted
+	QuietPeriod = 6 * time.Second
+)
+
+// Dir creates a new temporary directory for the calling test and returns its
+// path. It's removed when the test finishes (unless Keep is set). If creating
+// the directory fails, this calls t.Fatal()
+func Dir(t testing.TB) string {
+	t.Helper()
+	// Subtest names may contain slashes, which can't be in a directory name
+	name := strings.Replace(t.Name(), "/", "_", -1)
+	dir, err := ioutil.TempDir("", "watch-test-"+name+"-")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	t.Cleanup(func() {
+		if Keep {
+			t.Logf("leaving test directory %q", dir)
+			return
+		}
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+type (
+	// AtLeast (in CheckEvent(t, AtLeast(5), events) tells CheckEvent to expect
+	// at least 5 structs from 'events'
+	AtLeast int
+	// AtMost (in CheckEvent(t, AtMost(5), events) tells CheckEvent to expect
+	// at most 5 structs from 'events'
+	AtMost int
+	// Exactly (in CheckEvent(t, Exactly(5), events) tells CheckEvent to expect
+	// exactly 5 structs from 'events'
+	Exactly int
+)
+
+// CheckEvent checks that an appropriate quantity of structs have been written
+// to 'events' (it's assumed that a watcher publishes a struct to 'events'
+// every time a new inotify event is received
+func CheckEvent(t testing.TB, count interface{}, events chan struct{}) {
+	t.Helper()
+	eventCount := 0
+
+	// Keep reading events until none arrive for QuietPeriod
+waitForEvents:
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				break waitForEvents // channel closed
+			}
+			eventCount++
+		case <-time.After(QuietPeriod):
+			break waitForEvents
+		}
+	}
+
+	// Make sure we met the count condition
+	switch v := count.(type) {
+	case AtLeast:
+		if eventCount < int(v) {
+			t.Fatalf("expected at least %d events, but only saw %d", v, eventCount)
+		}
+	case AtMost:
+		if eventCount > int(v) {
+			t.Fatalf("expected at most %d events, but only saw %d", v, eventCount)
+		}
+	case Exactly:
+		if eventCount != int(v) {
+			t.Fatalf("expected exactly %d events, but only saw %d", v, eventCount)
+		}
+	default:
+		t.Fatalf("Unexpected type %T passed to CheckEvent", v)
+	}
+}
@@ -0,0 +1,238 @@
+// Package window reads the title of the focused desktop window, so that time
+// spent without writing files (e.g. reading code, or in a terminal) can still
+// be attributed to a project, by matching the title against patterns (see
+// Rule). The window system is queried with its own command-line tools: xprop
+// on X11, swaymsg on sway, and hyprctl on Hyprland. The user's input idle time
+// is read by package idle
+package window
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msteffen/toggl-watcher/idle"
+)
+
+// ErrUnsupported is returned when the focused window (or the user's input idle
+// time) can't be read in the current session
+var ErrUnsupported = errors.New("the focused window can't be read in this " +
+	"session (supported: X11 with xprop, sway, Hyprland)")
+
+// commandTimeout bounds each call to a window system tool
+const commandTimeout = 5 * time.Second
+
+// Rule attributes time in windows whose title matches Pattern to Project
+type Rule struct {
+	Pattern *regexp.Regexp
+	Project string
+}
+
+// NewRule compiles 'pattern' (a regular expression) into a Rule
+func NewRule(pattern, project string) (Rule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid window title pattern %q: %v", pattern, err)
+	}
+	return Rule{Pattern: re, Project: project}, nil
+}
+
+// Match returns the project of the first rule in 'rules' that matches 'title',
+// or "" if none do
+func Match(rules []Rule, title string) string {
+	for _, r := range rules {
+		if r.Pattern.MatchString(title) {
+			return r.Project
+		}
+	}
+	return ""
+}
+
+// run runs the command 'name' with 'args' and returns its output
+func run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	timer := time.AfterFunc(commandTimeout, func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	})
+	defer timer.Stop()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not run %s: %v", name, err)
+	}
+	return out, nil
+}
+
+// FocusedTitle returns the title of the focused window, or "" if no window is
+// focused
+func FocusedTitle() (string, error) {
+	switch {
+	case os.Getenv("SWAYSOCK") != "":
+		out, err := run("swaymsg", "-t", "get_tree")
+		if err != nil {
+			return "", err
+		}
+		return parseSwayTree(out)
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		out, err := run("hyprctl", "activewindow", "-j")
+		if err != nil {
+			return "", err
+		}
+		return parseHyprland(out)
+	case os.Getenv("DISPLAY") != "":
+		out, err := run("xprop", "-root", "-notype", "_NET_ACTIVE_WINDOW")
+		if err != nil {
+			return "", err
+		}
+		id, err := parseXpropWindowID(out)
+		if err != nil || id == "" {
+			return "", err
+		}
+		if out, err = run("xprop", "-id", id, "-notype", "_NET_WM_NAME", "WM_NAME"); err != nil {
+			return "", err
+		}
+		return parseXpropTitle(out), nil
+	}
+	return "", ErrUnsupported
+}
+
+// InputIdle returns the time since the user's last keyboard or mouse input
+// (see idle.Duration)
+func InputIdle() (time.Duration, error) {
+	d, err := idle.Duration()
+	if err == idle.ErrUnsupported {
+		return 0, ErrUnsupported
+	}
+	return d, err
+}
+
+// parseXpropWindowID parses the output of 'xprop -root _NET_ACTIVE_WINDOW',
+// e.g. "_NET_ACTIVE_WINDOW: window id # 0x3a00007". It returns "" if no window
+// is focused
+func parseXpropWindowID(out []byte) (string, error) {
+	s := strings.TrimSpace(string(out))
+	i := strings.LastIndex(s, "#")
+	if i < 0 {
+		return "", fmt.Errorf("could not parse xprop output %q", s)
+	}
+	// xprop reports some window managers' empty value as "0x0"
+	id := strings.TrimSpace(s[i+1:])
+	if id == "0x0" {
+		return "", nil
+	}
+	return id, nil
+}
+
+// parseXpropTitle parses the output of 'xprop -id <id> _NET_WM_NAME WM_NAME',
+// e.g. `_NET_WM_NAME = "main.go - tg - Visual Studio Code"`, preferring
+// _NET_WM_NAME (which is UTF-8) over WM_NAME
+func parseXpropTitle(out []byte) string {
+	var title string
+	for _, line := range strings.Split(string(out), "\n") {
+		i := strings.Index(line, " = ")
+		if i < 0 {
+			continue // e.g. "_NET_WM_NAME:  not found."
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+3:])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		if name == "_NET_WM_NAME" {
+			return value
+		} else if title == "" {
+			title = value
+		}
+	}
+	return title
+}
+
+// swayNode is the subset of a node in the output of 'swaymsg -t get_tree' that
+// parseSwayTree reads
+type swayNode struct {
+	Name          string     `json:"name"`
+	Focused       bool       `json:"focused"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+// parseSwayTree returns the name of the focused node in the output of
+// 'swaymsg -t get_tree'
+func parseSwayTree(out []byte) (string, error) {
+	var root swayNode
+	if err := json.Unmarshal(out, &root); err != nil {
+		return "", fmt.Errorf("could not parse sway tree: %v", err)
+	}
+	var find func(n *swayNode) (string, bool)
+	find = func(n *swayNode) (string, bool) {
+		if n.Focused {
+			return n.Name, true
+		}
+		for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+			for i := range children {
+				if name, ok := find(&children[i]); ok {
+					return name, true
+				}
+			}
+		}
+		return "", false
+	}
+	name, _ := find(&root)
+	return name, nil
+}
+
+// parseHyprland returns the title in the output of 'hyprctl activewindow -j'
+func parseHyprland(out []byte) (string, error) {
+	var w struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(out, &w); err != nil {
+		return "", fmt.Errorf("could not parse hyprctl output: %v", err)
+	}
+	return w.Title, nil
+}
+
+// Poller polls the focused window, tracking whether the user is active in it
+type Poller struct {
+	// Focused and Idle read the focused window's title and the user's input
+	// idle time. They're FocusedTitle and InputIdle, except in tests
+	Focused func() (string, error)
+	Idle    func() (time.Duration, error)
+
+	last string // the title read by the previous call to Poll
+}
+
+// NewPoller returns a Poller that reads the current session's windows
+func NewPoller() *Poller {
+	return &Poller{Focused: FocusedTitle, Idle: InputIdle}
+}
+
+// Poll returns the focused window's title, and whether the user has been
+// active within 'within'. Where the input idle time can be read, that decides
+// it; elsewhere, the user is only considered active if the title changed since
+// the previous Poll (e.g. by switching files or tabs), so that a window left
+// focused while the user is away doesn't keep a time entry open
+func (p *Poller) Poll(within time.Duration) (title string, active bool, err error) {
+	title, err = p.Focused()
+	if err != nil {
+		return "", false, err
+	}
+	changed := title != p.last
+	p.last = title
+	if title == "" {
+		return "", false, nil
+	}
+	idle, err := p.Idle()
+	if err == ErrUnsupported {
+		return title, changed, nil
+	} else if err != nil {
+		return title, false, err
+	}
+	return title, idle < within, nil
+}
@@ -0,0 +1,91 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	var rules []Rule
+	for _, r := range [][2]string{
+		{`— tg — Visual Studio Code$`, "tg"},
+		{`^vim .*/src/tg/`, "tg"},
+		{`(?i)pachyderm`, "pach"},
+	} {
+		rule, err := NewRule(r[0], r[1])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		rules = append(rules, rule)
+	}
+	for title, expected := range map[string]string{
+		"main.go — tg — Visual Studio Code": "tg",
+		"vim /home/me/src/tg/main.go":       "tg",
+		"Pachyderm docs - Firefox":          "pach",
+		"Inbox - Mail":                      "",
+	} {
+		if actual := Match(rules, title); actual != expected {
+			t.Fatalf("expected %q to match %q, but got %q", title, expected, actual)
+		}
+	}
+	if _, err := NewRule("(", "x"); err == nil {
+		t.Fatalf("expected an error compiling an invalid pattern")
+	}
+}
+
+func TestParse(t *testing.T) {
+	id, err := parseXpropWindowID([]byte("_NET_ACTIVE_WINDOW: window id # 0x3a00007\n"))
+	if err != nil || id != "0x3a00007" {
+		t.Fatalf("expected 0x3a00007, but got %q (err: %v)", id, err)
+	}
+	if id, _ := parseXpropWindowID([]byte("_NET_ACTIVE_WINDOW: window id # 0x0\n")); id != "" {
+		t.Fatalf("expected no window, but got %q", id)
+	}
+	title := parseXpropTitle([]byte("WM_NAME = \"fallback\"\n" +
+		"_NET_WM_NAME = \"main.go \\\"quoted\\\" - tg\"\n"))
+	if title != `main.go "quoted" - tg` {
+		t.Fatalf("unexpected xprop title %q", title)
+	}
+	if title := parseXpropTitle([]byte("_NET_WM_NAME:  not found.\nWM_NAME = \"old\"\n")); title != "old" {
+		t.Fatalf("expected WM_NAME fallback, but got %q", title)
+	}
+
+	title, err = parseSwayTree([]byte(`{"name":"root","nodes":[{"name":"ws",
+		"nodes":[{"name":"a"}],"floating_nodes":[{"name":"b","focused":true}]}]}`))
+	if err != nil || title != "b" {
+		t.Fatalf("expected sway title \"b\", but got %q (err: %v)", title, err)
+	}
+	title, err = parseHyprland([]byte(`{"class":"kitty","title":"~/src/tg"}`))
+	if err != nil || title != "~/src/tg" {
+		t.Fatalf("expected hyprland title, but got %q (err: %v)", title, err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	titles := []string{"a", "a", "b", ""}
+	p := &Poller{
+		Focused: func() (string, error) {
+			title := titles[0]
+			titles = titles[1:]
+			return title, nil
+		},
+		Idle: func() (time.Duration, error) { return 0, ErrUnsupported },
+	}
+	// Without input idle times, only title changes count as activity
+	for i, expected := range []bool{true, false, true, false} {
+		if _, active, err := p.Poll(time.Minute); err != nil || active != expected {
+			t.Fatalf("poll %d: expected active=%v, but got %v (err: %v)", i, expected,
+				active, err)
+		}
+	}
+
+	// With input idle times, they decide
+	p.Focused = func() (string, error) { return "a", nil }
+	p.Idle = func() (time.Duration, error) { return 30 * time.Second, nil }
+	if _, active, _ := p.Poll(time.Minute); !active {
+		t.Fatalf("expected input 30s ago to count as activity within 1m")
+	}
+	if _, active, _ := p.Poll(10 * time.Second); active {
+		t.Fatalf("expected input 30s ago not to count as activity within 10s")
+	}
+}